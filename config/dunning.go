@@ -0,0 +1,18 @@
+package config
+
+// Dunning reminder mails, one per stage. They are executed with the invoice's No, DueOn,
+// DaysOverdue and Amount.
+const Dunning_ReminderNotification = `<p>Dear Customer,</p>
+<p>This is a friendly reminder that invoice <b>{{.No}}</b>{{if .Amount}} for <b>{{.Amount}}</b>{{end}} was due on {{.DueOn}}.</p>
+<p>If you have already made the payment, please ignore this email.</p>
+<p>Regards,<br/>Accounts Team</p>`
+
+const Dunning_FollowUpNotification = `<p>Dear Customer,</p>
+<p>Invoice <b>{{.No}}</b>{{if .Amount}} for <b>{{.Amount}}</b>{{end}} is now {{.DaysOverdue}} days past its due date of {{.DueOn}}.</p>
+<p>Please arrange the payment at the earliest or reach out to your sales executive if there is anything holding it up.</p>
+<p>Regards,<br/>Accounts Team</p>`
+
+const Dunning_FinalNotification = `<p>Dear Customer,</p>
+<p>Despite our earlier reminders, invoice <b>{{.No}}</b>{{if .Amount}} for <b>{{.Amount}}</b>{{end}} remains unpaid {{.DaysOverdue}} days after its due date of {{.DueOn}}.</p>
+<p>Please settle the invoice immediately to avoid any disruption to upcoming shipments.</p>
+<p>Regards,<br/>Accounts Team</p>`
@@ -0,0 +1,30 @@
+package constants
+
+// Stages of an AMS/ISF filing, from the generated file to the house bill linked to its master
+const (
+	AMSStagePending     = "pending"
+	AMSStageGenerated   = "generated"
+	AMSStageTransmitted = "transmitted"
+	AMSStageReceived    = "received"
+	AMSStageAccepted    = "accepted"
+	AMSStageLinked      = "linked"
+	AMSStageRejected    = "rejected"
+)
+
+// Statuses recorded for a filing by this service rather than by a TradeTech mail
+const (
+	AMSStatusFileGenerated = "File Generated"
+	ISFStatusTransmitted   = "ISF Transmitted"
+)
+
+// Kinds of filing listed on the filing dashboard
+const (
+	FilingKindAMS = "ams"
+	FilingKindISF = "isf"
+)
+
+// Cards raised for a filing that needs attention
+const (
+	CardAMSRejected = "AMS Filing Rejected"
+	CardAMSOverdue  = "AMS Filing Past Cut-off"
+)
@@ -0,0 +1,9 @@
+package constants
+
+// Document types numbered from the tenant's document counters
+const (
+	DocTypeHAWB      = "hawb"
+	DocTypeSISJob    = "sis_job"
+	DocTypeSISAirJob = "sis_air_job"
+	DocTypeDoBl      = "do_bl"
+)
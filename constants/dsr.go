@@ -0,0 +1,24 @@
+package constants
+
+// Formats a DSR is rendered to
+const (
+	DSRFormatXLSX = "xlsx"
+	DSRFormatCSV  = "csv"
+)
+
+const (
+	ContentTypeXLSX = "application/vnd.openxmlformats-officedocument.spreadsheetml.sheet"
+	ContentTypeCSV  = "text/csv"
+)
+
+// How often a DSR subscription is delivered
+const (
+	DSRFrequencyDaily   = "daily"
+	DSRFrequencyWeekly  = "weekly"
+	DSRFrequencyMonthly = "monthly"
+)
+
+const (
+	DSRDeliverySent   = "sent"
+	DSRDeliveryFailed = "failed"
+)
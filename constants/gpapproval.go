@@ -0,0 +1,25 @@
+package constants
+
+const (
+	GPApprovalPending    = "pending"
+	GPApprovalApproved   = "approved"
+	GPApprovalRejected   = "rejected"
+	GPApprovalSuperseded = "superseded"
+)
+
+// What triggered the evaluation that asked for a GP approval
+const (
+	GPSourceQuote          = "quote"
+	GPSourcePartnerInvoice = "partner_invoice"
+)
+
+// Steps recorded in the history of a GP approval
+const (
+	GPEventRequested  = "requested"
+	GPEventApproved   = "approved"
+	GPEventRejected   = "rejected"
+	GPEventSuperseded = "superseded"
+)
+
+// Card raised for the approver whose turn it is to decide a GP approval
+const CardGPApproval = "GP Approval Required"
@@ -0,0 +1,11 @@
+package constants
+
+// Type of the region level invoice pref that holds the invoice number templates
+const InvoicePrefNumberFormat = "number_format"
+
+// Voucher types a credit or debit note is numbered in, notes keep a sequence of their own
+// and never take a number from the invoice they are raised against
+const (
+	VoucherTypeCreditNote = "CN"
+	VoucherTypeDebitNote  = "DN"
+)
@@ -0,0 +1,8 @@
+package constants
+
+// Status of a scheduled cronjob run
+const (
+	JobRunStatusRunning   = "running"
+	JobRunStatusSucceeded = "succeeded"
+	JobRunStatusFailed    = "failed"
+)
@@ -0,0 +1,16 @@
+package constants
+
+// Settlement status of an invoice derived from its outstanding balance
+const (
+	InvoiceStatusUnpaid        = "unpaid"
+	InvoiceStatusPartiallyPaid = "partially_paid"
+	InvoiceStatusPaid          = "paid"
+	InvoiceStatusOverpaid      = "overpaid"
+)
+
+// Escalation stages of the reminders sent for overdue customer invoices
+const (
+	DunningStageReminder = "reminder"
+	DunningStageFollowUp = "follow_up"
+	DunningStageFinal    = "final_notice"
+)
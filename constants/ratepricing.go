@@ -0,0 +1,32 @@
+package constants
+
+// What the quantity of a rate template charge is measured in
+const (
+	RateBasisShipment  = "per_shipment"
+	RateBasisContainer = "per_container"
+	RateBasisKg        = "per_kg"
+	RateBasisCBM       = "per_cbm"
+	RateBasisWM        = "per_wm"
+	RateBasisPackage   = "per_package"
+)
+
+// Kilograms a cubic metre of air cargo is charged as (1:6000 volumetric divisor)
+const AirVolumetricKgPerCBM = 166.67
+
+const (
+	LineItemBuy  = "buy"
+	LineItemSell = "sell"
+)
+
+// Formats a rate sheet is imported from
+const (
+	RateSheetFormatCSV  = "csv"
+	RateSheetFormatXLSX = "xlsx"
+)
+
+// How a rate sheet row changes a template charge
+const (
+	RateSheetAdded     = "added"
+	RateSheetUpdated   = "updated"
+	RateSheetUnchanged = "unchanged"
+)
@@ -0,0 +1,52 @@
+package constants
+
+// Rules of a shipment lock policy that can lock a shipment
+const (
+	LockRuleMilestone = "milestone"
+	LockRuleFinancial = "financial"
+)
+
+// Parts of a locked shipment an approved unlock request opens for editing
+const (
+	UnlockScopeCharges   = "charges"
+	UnlockScopeDocuments = "documents"
+	UnlockScopeAll       = "all"
+)
+
+const (
+	UnlockRequestPending   = "pending"
+	UnlockRequestApproved  = "approved"
+	UnlockRequestRejected  = "rejected"
+	UnlockRequestCancelled = "cancelled"
+	UnlockRequestExpired   = "expired"
+)
+
+// Steps recorded in the history of an unlock request
+const (
+	UnlockEventRequested = "requested"
+	UnlockEventApproved  = "approved"
+	UnlockEventRejected  = "rejected"
+	UnlockEventCancelled = "cancelled"
+	UnlockEventExpired   = "expired"
+	UnlockEventRelocked  = "relocked"
+)
+
+// Areas of a shipment the lock guard protects. Each mutating endpoint writes to one area.
+const (
+	LockAreaShipment   = "shipment"
+	LockAreaDocuments  = "documents"
+	LockAreaContainers = "containers"
+	LockAreaBills      = "bills"
+	LockAreaCharges    = "charges"
+	LockAreaHBL        = "hbl"
+)
+
+// LockAllowAllFields allow-lists every field of an area.
+const LockAllowAllFields = "*"
+
+// UnlockScopeAreas are the areas an approved unlock request of each scope opens for writes.
+// The all scope opens every area.
+var UnlockScopeAreas = map[string][]string{
+	UnlockScopeCharges:   {LockAreaCharges},
+	UnlockScopeDocuments: {LockAreaDocuments, LockAreaBills, LockAreaHBL},
+}
@@ -0,0 +1,9 @@
+package constants
+
+// Lifecycle of an air waybill stock number: available -> reserved -> used, or voided
+const (
+	StockStatusAvailable = "available"
+	StockStatusReserved  = "reserved"
+	StockStatusUsed      = "used"
+	StockStatusVoided    = "voided"
+)
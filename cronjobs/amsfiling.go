@@ -0,0 +1,72 @@
+package cronjobs
+
+import (
+	"fmt"
+	"strconv"
+	"time"
+
+	"bitbucket.org/radarventures/forwarder-adapters/utils/context"
+	"bitbucket.org/radarventures/forwarder-shipments/database/models"
+	"bitbucket.org/radarventures/forwarder-shipments/services/ams/amsfiling"
+	"go.uber.org/zap"
+)
+
+// RaiseAMSFilingAlerts raises a card on the shipments whose AMS or ISF filing was rejected or is not
+// accepted by the vessel's cut-off.
+func RaiseAMSFilingAlerts(ctx *context.Context) error {
+
+	cutOffHours, err := strconv.Atoi(GetJobParam(ctx, "cutoff_hours"))
+	if err != nil {
+		ctx.Log.Error("invalid cutoff_hours parameter", zap.Error(err))
+		return err
+	}
+
+	filingService := amsfiling.NewAMSFilingService()
+	var failed int64
+	for pg, seen := 1, int64(0); ; pg++ {
+		page, err := filingService.GetFilings(ctx, &models.AMSFilingFilter{Pg: pg}, time.Duration(cutOffHours)*time.Hour)
+		if err != nil {
+			return err
+		}
+		if len(page.Filings) == 0 {
+			break
+		}
+
+		summary := RunPool(ctx, "raiseAMSFilingAlerts", page.Filings, func(f *models.AMSFilingDetail) string {
+			return f.AmsInfoId.String()
+		}, func(ctx *context.Context, filing *models.AMSFilingDetail) error {
+			name := filingService.AlertFor(filing)
+			if name == "" {
+				return ErrSkipItem
+			}
+
+			if IsDryRun(ctx) {
+				logDryRun(ctx, "raise AMS card", zap.String("card", name), zap.String("kind", filing.Kind), zap.Any("shipment_id", filing.ShipmentId), zap.String("hbl_no", filing.HblNo), zap.String("stage", filing.Stage))
+				return nil
+			}
+
+			raised, err := filingService.RaiseAlert(ctx, filing, name)
+			if err != nil {
+				return err
+			}
+			if !raised {
+				return ErrSkipItem
+			}
+
+			ctx.Log.Info("AMS card raised", zap.String("card", name), zap.String("kind", filing.Kind), zap.Any("shipment_id", filing.ShipmentId), zap.String("hbl_no", filing.HblNo))
+			return nil
+		})
+		failed += summary.Failed
+
+		seen += int64(len(page.Filings))
+		if seen >= page.Total {
+			break
+		}
+	}
+
+	if failed > 0 {
+		return fmt.Errorf("unable to raise the cards of %d AMS filings", failed)
+	}
+
+	return nil
+}
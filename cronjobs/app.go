@@ -9,97 +9,102 @@ import (
 	"bitbucket.org/radarventures/forwarder-adapters/utils/db"
 	ulog "bitbucket.org/radarventures/forwarder-adapters/utils/log"
 	"bitbucket.org/radarventures/forwarder-shipments/config"
+	"bitbucket.org/radarventures/forwarder-shipments/constants"
+	"bitbucket.org/radarventures/forwarder-shipments/daos/tenant"
 	"bitbucket.org/radarventures/forwarder-shipments/services/rfq"
 	"github.com/gin-gonic/gin"
 	"github.com/google/uuid"
 	"go.uber.org/zap"
 )
 
-func Start(cronjob *string) {
-
-	if *cronjob == "updateQuoteExpiry" {
-		ctx := getContext()
-		// To mock request context of gin. The request context is used in DAO layer
-		ctx.Context, _ = gin.CreateTestContext(httptest.NewRecorder())
-		ctx.Context.Request = httptest.NewRequest("GET", "/expired-quotes-job", nil)
-		ctx.Log.Info("Started quote expiry job", zap.String("start_time", time.Now().UTC().String()))
-		rfqService := rfq.NewRfqService()
-		rfqService.UpdateQuoteExpiry(ctx)
-		ctx.Log.Info("Completed quote expiry job", zap.Any("end_time", time.Now().UTC().String()))
-		os.Exit(0)
-	}
+// globalSchema is the schema global jobs run in, it holds the tables shared by all tenants.
+const globalSchema = "public"
 
-	if *cronjob == "handleCardStatus" {
-		ctx := getContext()
-		ctx.Context, _ = gin.CreateTestContext(httptest.NewRecorder())
-		ctx.Context.Request = httptest.NewRequest("GET", "/handle-card-status", nil)
-		NewHandleCardStatus().HandleStatus(ctx)
-		os.Exit(0)
-	}
+// Start runs a single registered cronjob. With dryRun set the job only logs the changes it
+// would make.
+func Start(cronjob *string, dryRun bool, params string) {
 
-	if *cronjob == "containerTracking" {
-		ctx := getContext()
-		ctx.Context, _ = gin.CreateTestContext(httptest.NewRecorder())
-		ctx.Context.Request = httptest.NewRequest("GET", "/container-tracking", nil)
-		RunContainerTrackingAutomation(ctx)
-		os.Exit(0)
+	job, ok := GetJob(*cronjob)
+	if !ok {
+		constants.Logger.Error("unknown cronjob, use -list-jobs to see the available jobs", zap.String("cronjob", *cronjob))
+		os.Exit(1)
 	}
 
-	if *cronjob == "sisrequestjob" {
-		ctx := getContext()
-		ctx.Context, _ = gin.CreateTestContext(httptest.NewRecorder())
-		ctx.Context.Request = httptest.NewRequest("GET", "/container-tracking", nil)
-		Run(ctx)
-		os.Exit(0)
+	values, err := ParseJobParams(job, params)
+	if err != nil {
+		constants.Logger.Error("invalid cronjob parameters", zap.String("cronjob", job.Name), zap.Error(err))
+		os.Exit(1)
 	}
 
-	if *cronjob == "CardsDeletePostExpiry" {
-		ctx := getContext()
-		ctx.Context, _ = gin.CreateTestContext(httptest.NewRecorder())
-		ctx.Context.Request = httptest.NewRequest("GET", "/cards-delete-post-expiry", nil)
-		NewCardsPostExpiry().CardsPostExpiry(ctx)
-		os.Exit(0)
+	schemas, err := getJobSchemas(job)
+	if err != nil {
+		os.Exit(1)
 	}
 
-	if *cronjob == "UpdateShipmentlockStatus" {
-		ctx := getContext()
-		ctx.Context, _ = gin.CreateTestContext(httptest.NewRecorder())
-		ctx.Context.Request = httptest.NewRequest("GET", "/update-shipment-lock", nil)
-		NewUpdateShipmentLock().UpdateShipmentlockStatus(ctx)
-		os.Exit(0)
-	}
-	if *cronjob == "UpdateShipmentlockStatuV2" {
-		ctx := getContext()
-		ctx.Context, _ = gin.CreateTestContext(httptest.NewRecorder())
-		ctx.Context.Request = httptest.NewRequest("GET", "/update-shipment-lock", nil)
-		NewUpdateShipmentLock().UpdateShipmentlockStatusV2(ctx)
-		os.Exit(0)
+	for _, schema := range schemas {
+		ctx := newJobContext(job, dryRun, values, schema)
+		if dryRun {
+			ctx.Log.Info("running cronjob in dry run mode, no changes will be written", zap.String("cronjob", job.Name), zap.String("tenant_id", schema))
+		}
+
+		err = job.Run(ctx)
+		if err != nil {
+			ctx.Log.Error("cronjob failed", zap.String("cronjob", job.Name), zap.String("tenant_id", schema), zap.Error(err))
+		}
 	}
 
-	if *cronjob == "AMSCheckMail" {
-		ctx := getContext()
-		ctx.Context, _ = gin.CreateTestContext(httptest.NewRecorder())
-		ctx.Context.Request = httptest.NewRequest("GET", "/check-ams", nil)
-		CheckMails(ctx)
-		os.Exit(0)
+	os.Exit(0)
+}
+
+// getJobSchemas returns the schemas a cronjob runs for. Every tenant gets a run of its own,
+// so a job only ever sees the rows of the tenant it runs for. A global job runs once in
+// public.
+func getJobSchemas(job *Job) ([]string, error) {
+	if job.Global {
+		return []string{globalSchema}, nil
 	}
 
-	if *cronjob == "InvoiceRetrievel" {
-		ctx := getContext()
-		ctx.Context, _ = gin.CreateTestContext(httptest.NewRecorder())
-		ctx.Context.Request = httptest.NewRequest("GET", "/invoice-retrievel", nil)
-		FetchPendingInvoices(ctx)
-		os.Exit(0)
+	return getTenantSchemas()
+}
+
+// getTenantSchemas returns the schemas of all tenants.
+func getTenantSchemas() ([]string, error) {
+	ctx := getContext()
+	ctx.Context, _ = gin.CreateTestContext(httptest.NewRecorder())
+	ctx.Context.Request = httptest.NewRequest("GET", "/tenants", nil)
+
+	schemas, err := tenant.NewTenant().GetSchemas(ctx)
+	if err != nil {
+		ctx.Log.Error("unable to list tenants", zap.Error(err))
+		return nil, err
 	}
 
-	if *cronjob == "expiryCardsNotifications" {
-		ctx := getContext()
-		ctx.Context, _ = gin.CreateTestContext(httptest.NewRecorder())
-		ctx.Context.Request = httptest.NewRequest("GET", "/expiry-cards-notifications", nil)
-		NewExpiryCards().SendExpiryCardsNotifications(ctx)
-		os.Exit(0)
+	return schemas, nil
+}
+
+// newJobContext builds the context a cronjob runs with for the tenant.
+func newJobContext(job *Job, dryRun bool, params map[string]string, tenantId string) *context.Context {
+	ctx := getContext()
+	ctx.TenantID = tenantId
+	// To mock request context of gin. The request context is used in DAO layer
+	ctx.Context, _ = gin.CreateTestContext(httptest.NewRecorder())
+	ctx.Context.Request = httptest.NewRequest("GET", job.Path, nil)
+	ctx.Set(dryRunKey, dryRun)
+	ctx.Set(jobParamsKey, params)
+
+	return ctx
+}
+
+func updateQuoteExpiry(ctx *context.Context) {
+	if IsDryRun(ctx) {
+		logDryRun(ctx, "mark the quotes past their validity as expired")
+		return
 	}
 
+	ctx.Log.Info("Started quote expiry job", zap.String("start_time", time.Now().UTC().String()))
+	rfqService := rfq.NewRfqService()
+	rfqService.UpdateQuoteExpiry(ctx)
+	ctx.Log.Info("Completed quote expiry job", zap.Any("end_time", time.Now().UTC().String()))
 }
 
 func getContext() *context.Context {
@@ -111,9 +116,5 @@ func getContext() *context.Context {
 	c.Log = ulog.New(c.RefID, cfg.AppName, cfg.LogLevel)
 	c.DB = db.New()
 
-	if c.TenantID == "" {
-		c.TenantID = "public"
-	}
-
 	return c
 }
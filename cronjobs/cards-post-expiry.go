@@ -53,6 +53,11 @@ func (c *CardsPostExpiry) CardsPostExpiry(ctx *context.Context) error {
 		return errors.New("received nil booking requests that are expired")
 	}
 
+	if IsDryRun(ctx) {
+		logDryRun(ctx, "delete the cards of expired booking requests", zap.Strings("booking_request_ids", RfqIdsExpiryList))
+		return nil
+	}
+
 	for _, id := range RfqIdsExpiryList {
 		isBooking, err := c.cardDb.DeleteBookingRequestByID(ctx, id, time.Now().UTC())
 		if err != nil {
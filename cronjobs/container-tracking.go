@@ -1,12 +1,12 @@
 package cronjobs
 
 import (
-	"fmt"
 	"time"
 
 	"bitbucket.org/radarventures/forwarder-adapters/utils/context"
 	"bitbucket.org/radarventures/forwarder-shipments/constants"
 	"bitbucket.org/radarventures/forwarder-shipments/daos/shipment"
+	"bitbucket.org/radarventures/forwarder-shipments/database/models"
 	shipmenttracking "bitbucket.org/radarventures/forwarder-shipments/services/shipment-tracking"
 	"go.uber.org/zap"
 )
@@ -15,27 +15,35 @@ func RunContainerTrackingAutomation(ctx *context.Context) {
 
 	ctx.Log.Info("RunContainerTrackingAutomation started")
 
-	createdSince := time.Date(2024, time.October, 01, 0, 0, 0, 0, time.UTC)
+	createdSince, err := time.Parse(time.DateOnly, GetJobParam(ctx, "created_since"))
+	if err != nil {
+		ctx.Log.Error("invalid created_since parameter", zap.Error(err))
+		return
+	}
 
 	shipments, err := shipment.NewShipment().GetShipmentsSince(ctx, "", []string{"id"}, []string{constants.ShipmentTypeFCL}, &createdSince, []string{constants.ShipmentCompleted, constants.ShipmentCreated}, true)
 	if err != nil {
 		ctx.Log.Error("error while fetching shipments", zap.Error(err))
 	}
 
-	for i, shipment := range shipments {
+	RunPool(ctx, "container-tracking", shipments, func(shipment *models.Shipment) string {
+		return shipment.Id.String()
+	}, func(ctx *context.Context, shipment *models.Shipment) error {
 
-		ctx.Log.Info(fmt.Sprintf("Processing Shipment %d/%d", i+1, len(shipments)),
-			zap.String("id", shipment.Id.String()),
-		)
+		if IsDryRun(ctx) {
+			logDryRun(ctx, "automate container tracking", zap.String("shipment_id", shipment.Id.String()))
+			return nil
+		}
 
 		err := shipmenttracking.NewShipmentTrackingService().AutomateOceanShipment(ctx, shipment.Id.String())
 		if err != nil {
-			ctx.Log.Error("error automating ocean shpment")
-			continue
+			ctx.Log.Error("error automating ocean shpment", zap.Error(err))
+			return err
 		}
 
-		ctx.Log.Info("Automation completed for shipment")
-	}
+		ctx.Log.Info("Automation completed for shipment", zap.String("id", shipment.Id.String()))
+		return nil
+	})
 
 	ctx.Log.Info("RunContainerTrackingAutomation completed")
 
@@ -0,0 +1,87 @@
+package cronjobs
+
+import (
+	"testing"
+	"time"
+)
+
+func TestCronScheduleNext(t *testing.T) {
+	at := func(value string) time.Time {
+		v, err := time.Parse("2006-01-02 15:04", value)
+		if err != nil {
+			t.Fatalf("parse %s: %v", value, err)
+		}
+		return v
+	}
+
+	// 2026-01-01 is a Thursday
+	tests := []struct {
+		name string
+		expr string
+		from string
+		want string
+	}{
+		{"step", "*/15 * * * *", "2026-01-01 10:07", "2026-01-01 10:15"},
+		{"step from a start", "10/20 * * * *", "2026-01-01 10:10", "2026-01-01 10:30"},
+		{"stepped range", "0-10/5 9 * * *", "2026-01-01 09:10", "2026-01-02 09:00"},
+		{"range", "5 10-12 * * *", "2026-01-01 12:05", "2026-01-02 10:05"},
+		{"lists", "0,30 8,20 * * *", "2026-01-01 08:30", "2026-01-01 20:00"},
+		{"day of month only", "0 0 15 * *", "2026-01-01 00:00", "2026-01-15 00:00"},
+		{"day of week only", "0 0 * * 0", "2026-01-01 00:00", "2026-01-04 00:00"},
+		{"sunday as 7", "0 0 * * 7", "2026-01-01 00:00", "2026-01-04 00:00"},
+		{"day of month or day of week, day of week first", "0 0 13 * 5", "2026-01-01 00:00", "2026-01-02 00:00"},
+		{"day of month or day of week, day of month first", "0 0 2 * 1", "2026-01-01 00:00", "2026-01-02 00:00"},
+		{"day of month or day of week, next week", "0 0 1 * 1", "2026-01-01 00:00", "2026-01-05 00:00"},
+		{"month", "0 0 1 3 *", "2026-01-01 00:00", "2026-03-01 00:00"},
+		{"leap day", "0 0 29 2 *", "2026-01-01 00:00", "2028-02-29 00:00"},
+		{"shorthand", "@daily", "2026-01-01 10:00", "2026-01-02 00:00"},
+		{"shorthand weekly", "@weekly", "2026-01-01 10:00", "2026-01-04 00:00"},
+		{"never", "0 0 30 2 *", "2026-01-01 00:00", ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			schedule, err := ParseCron(tt.expr)
+			if err != nil {
+				t.Fatalf("parse %q: %v", tt.expr, err)
+			}
+
+			var want time.Time
+			if tt.want != "" {
+				want = at(tt.want)
+			}
+
+			if got := schedule.Next(at(tt.from)); !got.Equal(want) {
+				t.Fatalf("next of %q after %s = %s, want %s", tt.expr, tt.from, got, want)
+			}
+		})
+	}
+}
+
+func TestParseCronInvalid(t *testing.T) {
+	exprs := []string{
+		"",
+		"* * * *",
+		"* * * * * *",
+		"@yearly",
+		"60 * * * *",
+		"* 24 * * *",
+		"* * 0 * *",
+		"* * 32 * *",
+		"* * * 0 *",
+		"* * * 13 *",
+		"* * * * 8",
+		"5-1 * * * *",
+		"*/0 * * * *",
+		"*/x * * * *",
+		"a * * * *",
+		"1-b * * * *",
+		"1,,2 * * * *",
+	}
+
+	for _, expr := range exprs {
+		if _, err := ParseCron(expr); err == nil {
+			t.Fatalf("parse %q succeeded, want an error", expr)
+		}
+	}
+}
@@ -0,0 +1,56 @@
+package cronjobs
+
+import (
+	"fmt"
+	"time"
+
+	"bitbucket.org/radarventures/forwarder-adapters/utils/context"
+	"bitbucket.org/radarventures/forwarder-shipments/database/models"
+	"bitbucket.org/radarventures/forwarder-shipments/services/dsr"
+	"go.uber.org/zap"
+)
+
+// SendDueDSRs renders and emails the DSRs whose subscriptions are due.
+func SendDueDSRs(ctx *context.Context) error {
+
+	now := time.Now().UTC()
+	dsrService := dsr.NewDSRService()
+
+	subscriptions, err := dsrService.GetDue(ctx, now)
+	if err != nil {
+		return err
+	}
+
+	if IsDryRun(ctx) {
+		for _, sub := range subscriptions {
+			report, err := dsrService.Render(ctx, sub, now)
+			if err != nil {
+				ctx.Log.Error("unable to render DSR", zap.Any("subscription_id", sub.Id), zap.Error(err))
+				continue
+			}
+			logDryRun(ctx, "send DSR", zap.Any("subscription_id", sub.Id), zap.Any("company_id", sub.CompanyId), zap.String("file", report.FileName), zap.Int("rows", report.Rows), zap.Strings("receivers", sub.Receivers))
+		}
+		return nil
+	}
+
+	summary := RunPool(ctx, "sendDueDSRs", subscriptions, func(sub *models.DSRSubscription) string {
+		return sub.Id.String()
+	}, func(ctx *context.Context, sub *models.DSRSubscription) error {
+		sent, err := dsrService.Deliver(ctx, sub, now)
+		if err != nil {
+			return err
+		}
+		if !sent {
+			return ErrSkipItem
+		}
+
+		ctx.Log.Info("DSR sent", zap.Any("subscription_id", sub.Id), zap.Any("company_id", sub.CompanyId))
+		return nil
+	})
+
+	if summary.Failed > 0 {
+		return fmt.Errorf("unable to send %d DSRs", summary.Failed)
+	}
+
+	return nil
+}
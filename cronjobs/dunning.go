@@ -0,0 +1,274 @@
+package cronjobs
+
+import (
+	"bytes"
+	"fmt"
+	"html/template"
+	"time"
+
+	"bitbucket.org/radarventures/forwarder-adapters/utils/context"
+	"github.com/google/uuid"
+	"go.uber.org/zap"
+
+	"bitbucket.org/radarventures/forwarder-adapters/apis/id"
+	"bitbucket.org/radarventures/forwarder-adapters/apis/notifications"
+	dtos "bitbucket.org/radarventures/forwarder-adapters/dtos/misc"
+	"bitbucket.org/radarventures/forwarder-shipments/config"
+	"bitbucket.org/radarventures/forwarder-shipments/constants"
+	"bitbucket.org/radarventures/forwarder-shipments/daos/dunning"
+	"bitbucket.org/radarventures/forwarder-shipments/daos/invoice"
+	"bitbucket.org/radarventures/forwarder-shipments/database/models"
+	"bitbucket.org/radarventures/forwarder-shipments/utils"
+)
+
+type dunningStage struct {
+	Name           string
+	MinDaysOverdue int
+	Title          string
+	Template       string
+}
+
+// Stages are ordered by escalation. An invoice only gets the latest stage it has reached, so a
+// customer picked up late is not sent the earlier reminders in one go.
+var dunningStages = []dunningStage{
+	{
+		Name:           constants.DunningStageReminder,
+		MinDaysOverdue: 1,
+		Title:          "Payment reminder for invoice {{.No}}",
+		Template:       config.Dunning_ReminderNotification,
+	},
+	{
+		Name:           constants.DunningStageFollowUp,
+		MinDaysOverdue: 15,
+		Title:          "Second reminder: invoice {{.No}} is overdue",
+		Template:       config.Dunning_FollowUpNotification,
+	},
+	{
+		Name:           constants.DunningStageFinal,
+		MinDaysOverdue: 30,
+		Title:          "Final notice: invoice {{.No}} is {{.DaysOverdue}} days overdue",
+		Template:       config.Dunning_FinalNotification,
+	},
+}
+
+type dunningMailDetail struct {
+	No          string
+	DueOn       string
+	DaysOverdue int
+	Amount      string
+}
+
+type Dunning struct {
+	id        id.ID
+	not       notifications.Notifications
+	invoiceDb invoice.IInvoice
+	dunningDb dunning.IDunning
+}
+
+func NewDunning() IDunning {
+	return &Dunning{
+		id:        *id.New(config.Get().IdURL),
+		not:       *notifications.New(config.Get().MiscURL),
+		invoiceDb: invoice.NewInvoice(),
+		dunningDb: dunning.NewDunning(),
+	}
+}
+
+type IDunning interface {
+	SendDunningReminders(ctx *context.Context) error
+}
+
+func (t *Dunning) SendDunningReminders(ctx *context.Context) error {
+
+	templates := map[string]*template.Template{}
+	titles := map[string]*template.Template{}
+	for _, stage := range dunningStages {
+		tmpl, err := template.New("DunningMail_" + stage.Name).Parse(stage.Template)
+		if err != nil {
+			ctx.Log.Error("unable to parse dunning template", zap.String("stage", stage.Name), zap.Error(err))
+			return err
+		}
+		templates[stage.Name] = tmpl
+
+		title, err := template.New("DunningTitle_" + stage.Name).Parse(stage.Title)
+		if err != nil {
+			ctx.Log.Error("unable to parse dunning title", zap.String("stage", stage.Name), zap.Error(err))
+			return err
+		}
+		titles[stage.Name] = title
+	}
+
+	invoices, err := t.invoiceDb.GetOverdueInvoices(ctx, dunningStages[0].MinDaysOverdue)
+	if err != nil {
+		ctx.Log.Error("unable to get overdue invoices", zap.Error(err))
+		return err
+	}
+
+	if len(invoices) == 0 {
+		return nil
+	}
+
+	companyIds := make([]string, 0)
+	invoiceIds := make([]string, 0, len(invoices))
+	for _, inv := range invoices {
+		invoiceIds = append(invoiceIds, inv.InvoiceId.String())
+		if !utils.ContainsString(companyIds, inv.CompanyId.String()) {
+			companyIds = append(companyIds, inv.CompanyId.String())
+		}
+	}
+
+	contacts, err := t.dunningDb.GetContacts(ctx, companyIds)
+	if err != nil {
+		return err
+	}
+
+	contactByCompany := map[uuid.UUID]*models.DunningContact{}
+	for _, contact := range contacts {
+		contactByCompany[contact.CompanyId] = contact
+	}
+
+	logs, err := t.dunningDb.GetLogs(ctx, invoiceIds)
+	if err != nil {
+		return err
+	}
+
+	sent := map[uuid.UUID]map[string]bool{}
+	for _, l := range logs {
+		if sent[l.InvoiceId] == nil {
+			sent[l.InvoiceId] = map[string]bool{}
+		}
+		sent[l.InvoiceId][l.Stage] = true
+	}
+
+	// Load configuration values
+	blockedUsers := config.Get().ExpiredSummaryMailBlockedUsers
+	blockedUsersManagers := config.Get().Blockusers
+
+	salesExecutiveEmails := map[uuid.UUID]string{}
+
+	for _, inv := range invoices {
+
+		// Customers opt out of reminders through the summary mail block list, by company or
+		// by address
+		if utils.ContainsString(blockedUsers, inv.CompanyId.String()) {
+			ctx.Log.Info("skipping dunning for opted out customer", zap.Any("company_id", inv.CompanyId), zap.String("invoice_no", inv.No))
+			continue
+		}
+
+		receivers := make([]string, 0)
+		if contact := contactByCompany[inv.CompanyId]; contact != nil {
+			for _, email := range contact.Emails {
+				if !utils.ContainsString(blockedUsers, email) {
+					receivers = append(receivers, email)
+				}
+			}
+		}
+		if len(receivers) == 0 {
+			ctx.Log.Info("skipping dunning for customer without contacts", zap.Any("company_id", inv.CompanyId), zap.String("invoice_no", inv.No))
+			continue
+		}
+
+		stage := currentDunningStage(inv.DaysOverdue)
+		if stage == nil || sent[inv.InvoiceId][stage.Name] {
+			continue
+		}
+
+		// Sales executives are copied unless they opted out of summary mails
+		cc := make([]string, 0)
+		salesExecutiveId := inv.SalesExecutiveId.String()
+		if inv.SalesExecutiveId != uuid.Nil && !utils.ContainsString(blockedUsers, salesExecutiveId) && !utils.ContainsString(blockedUsersManagers, salesExecutiveId) {
+			email, ok := salesExecutiveEmails[inv.SalesExecutiveId]
+			if !ok {
+				account, err := t.id.GetAccountInternal(ctx, salesExecutiveId)
+				if err != nil {
+					ctx.Log.Error("unable to get sales executive account", zap.String("sales_executive_id", salesExecutiveId), zap.Error(err))
+				} else if account != nil {
+					email = account.Email
+				}
+				salesExecutiveEmails[inv.SalesExecutiveId] = email
+			}
+
+			if email != "" {
+				cc = append(cc, email)
+			}
+		}
+
+		detail := &dunningMailDetail{
+			No:          inv.No,
+			DaysOverdue: inv.DaysOverdue,
+		}
+		if inv.DueOn != nil {
+			detail.DueOn = inv.DueOn.Format("02 Jan 2006")
+		}
+		if inv.OutstandingAmount != nil {
+			detail.Amount = fmt.Sprintf("%s %.2f", inv.Currency, *inv.OutstandingAmount)
+		}
+
+		titleBuf := new(bytes.Buffer)
+		if err := titles[stage.Name].Execute(titleBuf, detail); err != nil {
+			ctx.Log.Error("unable to execute dunning title", zap.String("invoice_no", inv.No), zap.Error(err))
+			continue
+		}
+
+		buf := new(bytes.Buffer)
+		if err := templates[stage.Name].Execute(buf, detail); err != nil {
+			ctx.Log.Error("unable to execute dunning template", zap.String("invoice_no", inv.No), zap.Error(err))
+			continue
+		}
+
+		if IsDryRun(ctx) {
+			logDryRun(ctx, "send dunning reminder", zap.String("invoice_no", inv.No), zap.String("stage", stage.Name), zap.Strings("receivers", receivers), zap.Strings("cc", cc))
+			continue
+		}
+
+		// The stage is claimed before sending so that a concurrent run cannot mail the customer
+		// twice for it. A failed send releases the claim and the next run retries the stage.
+		log := &models.DunningLog{
+			Id:        uuid.New(),
+			InvoiceId: inv.InvoiceId,
+			InvoiceNo: inv.No,
+			CompanyId: inv.CompanyId,
+			Stage:     stage.Name,
+			Receivers: receivers,
+			SentAt:    time.Now(),
+		}
+		claimed, err := t.dunningDb.CreateLog(ctx, log)
+		if err != nil || !claimed {
+			continue
+		}
+
+		err = t.not.SendNotification(ctx, &dtos.Notification{
+			ID:              uuid.New().String(),
+			Type:            constants.NotTypeEmail,
+			Title:           titleBuf.String(),
+			Sender:          config.Get().EmailSenderBot,
+			IsTransactional: true,
+			Content:         buf.String(),
+			Receivers:       receivers,
+			CC:              cc,
+		})
+		if err != nil {
+			ctx.Log.Error("unable to send dunning reminder", zap.String("invoice_no", inv.No), zap.String("stage", stage.Name), zap.Error(err))
+			if err := t.dunningDb.DeleteLog(ctx, log.Id); err != nil {
+				ctx.Log.Error("unable to release dunning stage", zap.String("invoice_no", inv.No), zap.String("stage", stage.Name), zap.Error(err))
+			}
+			continue
+		}
+
+		ctx.Log.Info("dunning reminder sent", zap.String("invoice_no", inv.No), zap.String("stage", stage.Name))
+	}
+
+	return nil
+}
+
+// currentDunningStage is the most escalated stage reached after daysOverdue days.
+func currentDunningStage(daysOverdue int) *dunningStage {
+	var current *dunningStage
+	for i := range dunningStages {
+		if daysOverdue >= dunningStages[i].MinDaysOverdue {
+			current = &dunningStages[i]
+		}
+	}
+
+	return current
+}
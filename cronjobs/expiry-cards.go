@@ -141,6 +141,11 @@ func (t *ExpiryCards) SendExpiryCardsNotificationsManager(ctx *context.Context)
 					emailTemplate.Receivers = []string{managerDetail.Email}
 					emailTemplate.CC = reporteeEmails
 
+					if IsDryRun(ctx) {
+						logDryRun(ctx, "send expiry cards email", zap.Strings("receivers", emailTemplate.Receivers), zap.Strings("cc", emailTemplate.CC))
+						continue
+					}
+
 					t.not.SendNotification(ctx, emailTemplate)
 				}
 			}
@@ -232,6 +237,11 @@ func (t *ExpiryCards) SendExpiryCardsNotificationsExecutive(ctx *context.Context
 					emailTemplate.Receivers = []string{reporteeDetail.Email}
 
 					// Send the email notification
+					if IsDryRun(ctx) {
+						logDryRun(ctx, "send expiry cards email", zap.Strings("receivers", emailTemplate.Receivers), zap.Strings("cc", emailTemplate.CC))
+						continue
+					}
+
 					t.not.SendNotification(ctx, emailTemplate)
 				}
 			}
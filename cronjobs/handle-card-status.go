@@ -2,6 +2,7 @@ package cronjobs
 
 import (
 	"fmt"
+	"strconv"
 	"strings"
 	"time"
 
@@ -50,7 +51,7 @@ type IHandleCardStatus interface {
 // HandleStatus updates the status of pending cards based on their estimate time.
 // If the current time is after the estimate time, the card status is set to "Breached",
 // and a goroutine is started to update in the booking service.
-// If the current time is within 60 minutes before the estimate time and the card is not already
+// If the current time is within warning_minutes (60 by default) before the estimate time and the card is not already
 // in a warning or breached status, the card status is set to "Warning".
 // If the current time is before the estimate time and the card is already in a breached status,
 // the card status is reset to "Created".
@@ -67,106 +68,134 @@ func (j *HandleCardStatus) HandleStatus(ctx *context.Context) error {
 
 	now := time.Now().UTC()
 
-	cardStatus := ""
-	var assignedToIds []string
+	warningMinutes, err := strconv.Atoi(GetJobParam(ctx, "warning_minutes"))
+	if err != nil {
+		ctx.Log.Error("invalid warning_minutes parameter", zap.Error(err))
+		return err
+	}
+	warning := time.Duration(warningMinutes) * time.Minute
+
+	summary := RunPool(ctx, "handle-card-status", cards, func(card models.Card) string {
+		return card.Id.String()
+	}, func(ctx *context.Context, card models.Card) error {
+		return j.handleCardStatus(ctx, card, now, warning)
+	})
+
+	ctx.Log.Info("HandleStatus Job Ended")
 
-	for _, card := range cards {
+	if summary.Failed > 0 {
+		return fmt.Errorf("unable to update the status of %d cards", summary.Failed)
+	}
 
-		cardStatus = card.Status
+	return nil
+}
+
+// handleCardStatus moves a single card between created, warning and breached and notifies
+// the members assigned to it.
+func (j *HandleCardStatus) handleCardStatus(ctx *context.Context, card models.Card, now time.Time, warning time.Duration) error {
+
+	cardStatus := card.Status
+	var assignedToIds []string
 
-		// If the current time is after the estimate and the status is not breached
-		if now.After(card.Estimate) && card.Status != constants.CardStatusBreached {
-			card.Status = constants.CardStatusBreached
+	// If the current time is after the estimate and the status is not breached
+	if now.After(card.Estimate) && card.Status != constants.CardStatusBreached {
+		card.Status = constants.CardStatusBreached
 
-			if len(card.EscalatedById) > 0 {
-				escID := card.EscalatedById[len(card.EscalatedById)-1]
-				assignedToIds = append(assignedToIds, escID)
+		if len(card.EscalatedById) > 0 {
+			escID := card.EscalatedById[len(card.EscalatedById)-1]
+			assignedToIds = append(assignedToIds, escID)
 
-				if len(card.EscalatedById) > 1 {
-					managerID := card.EscalatedById[len(card.EscalatedById)-2]
-					assignedToIds = append(assignedToIds, managerID)
-				}
+			if len(card.EscalatedById) > 1 {
+				managerID := card.EscalatedById[len(card.EscalatedById)-2]
+				assignedToIds = append(assignedToIds, managerID)
 			}
+		}
 
-			assignedToIds = utils.AppendWithoutDuplicates(assignedToIds, card.AssignedTo)
+		assignedToIds = utils.AppendWithoutDuplicates(assignedToIds, card.AssignedTo)
 
-		} else if now.After(card.Estimate.Add(-60*time.Minute)) && card.Status != constants.CardStatusWarning && card.Status != constants.CardStatusBreached {
+	} else if now.After(card.Estimate.Add(-warning)) && card.Status != constants.CardStatusWarning && card.Status != constants.CardStatusBreached {
 
-			// If the current time is after the estimate minus minutes,
-			// and the status is neither breached nor warning
+		// If the current time is after the estimate minus minutes,
+		// and the status is neither breached nor warning
 
-			card.Status = constants.CardStatusWarning
+		card.Status = constants.CardStatusWarning
 
+		if !IsDryRun(ctx) {
 			j.chatGenerationForWarning(ctx, &card)
+		}
 
-			if len(card.EscalatedById) > 0 {
-				escID := card.EscalatedById[len(card.EscalatedById)-1]
-				assignedToIds = append(assignedToIds, escID)
+		if len(card.EscalatedById) > 0 {
+			escID := card.EscalatedById[len(card.EscalatedById)-1]
+			assignedToIds = append(assignedToIds, escID)
 
-				if len(card.EscalatedById) > 1 {
-					managerID := card.EscalatedById[len(card.EscalatedById)-2]
-					assignedToIds = append(assignedToIds, managerID)
-				}
+			if len(card.EscalatedById) > 1 {
+				managerID := card.EscalatedById[len(card.EscalatedById)-2]
+				assignedToIds = append(assignedToIds, managerID)
 			}
-			assignedToIds = utils.AppendWithoutDuplicates(assignedToIds, card.AssignedTo)
+		}
+		assignedToIds = utils.AppendWithoutDuplicates(assignedToIds, card.AssignedTo)
 
-		} else if (card.Status == constants.CardStatusBreached && now.Before(card.Estimate)) || (card.Status == constants.CardStatusWarning && now.Before(card.Estimate.Add(-60*time.Minute))) {
+	} else if (card.Status == constants.CardStatusBreached && now.Before(card.Estimate)) || (card.Status == constants.CardStatusWarning && now.Before(card.Estimate.Add(-warning))) {
 
-			// Otherwise, if the current time is before the estimate and the status is breached,
-			// reset the status to "Created"
+		// Otherwise, if the current time is before the estimate and the status is breached,
+		// reset the status to "Created"
 
-			card.Status = constants.CardStatusCreated
+		card.Status = constants.CardStatusCreated
 
-			if len(card.EscalatedById) > 0 {
-				escID := card.EscalatedById[len(card.EscalatedById)-1]
-				assignedToIds = append(assignedToIds, escID)
+		if len(card.EscalatedById) > 0 {
+			escID := card.EscalatedById[len(card.EscalatedById)-1]
+			assignedToIds = append(assignedToIds, escID)
 
-				if len(card.EscalatedById) > 1 {
-					managerID := card.EscalatedById[len(card.EscalatedById)-2]
-					assignedToIds = append(assignedToIds, managerID)
-				}
+			if len(card.EscalatedById) > 1 {
+				managerID := card.EscalatedById[len(card.EscalatedById)-2]
+				assignedToIds = append(assignedToIds, managerID)
 			}
-
-			assignedToIds = utils.AppendWithoutDuplicates(assignedToIds, card.AssignedTo)
 		}
 
-		// Update the card status in the database
+		assignedToIds = utils.AppendWithoutDuplicates(assignedToIds, card.AssignedTo)
+	}
+
+	if IsDryRun(ctx) {
 		if card.Status != cardStatus {
+			logDryRun(ctx, "change card status", zap.String("card_id", card.Id.String()), zap.String("from", cardStatus), zap.String("to", card.Status))
+		}
+		return nil
+	}
 
-			reasonmap := make(map[string]interface{})
-			reasonmap["card name"] = card.Name
-			reasonmap["old card status"] = cardStatus
-			reasonmap["new card status"] = card.Status
-
-			cardAudit := &models.CardAudits{
-				CardId:         card.Id,
-				Name:           card.Name,
-				InstanceId:     card.InstanceId,
-				InstanceType:   card.InstanceType,
-				Department:     card.Department,
-				Status:         card.Status,
-				FlowInstanceId: card.FlowInstanceId.String(),
-				Reason:         reasonmap,
-			}
+	// Update the card status in the database
+	if card.Status != cardStatus {
+
+		reasonmap := make(map[string]interface{})
+		reasonmap["card name"] = card.Name
+		reasonmap["old card status"] = cardStatus
+		reasonmap["new card status"] = card.Status
+
+		cardAudit := &models.CardAudits{
+			CardId:         card.Id,
+			Name:           card.Name,
+			InstanceId:     card.InstanceId,
+			InstanceType:   card.InstanceType,
+			Department:     card.Department,
+			Status:         card.Status,
+			FlowInstanceId: card.FlowInstanceId.String(),
+			Reason:         reasonmap,
+		}
 
-			j.cardAuditsDb.Upsert(ctx, cardAudit)
+		j.cardAuditsDb.Upsert(ctx, cardAudit)
 
-			err = j.cardDb.UpdateStatus(ctx, card.Id.String(), card.Status)
-			if err != nil {
-				ctx.Log.Error("error updating card", zap.Error(err))
-				return err
-			}
+		err := j.cardDb.UpdateStatus(ctx, card.Id.String(), card.Status)
+		if err != nil {
+			ctx.Log.Error("error updating card", zap.Error(err))
+			return err
 		}
-
-		// //Websocket message
-		j.ws.SendCardsDataMiddleware(ctx, &card, map[string]interface{}{
-			"card_id":     card.Id,
-			"event":       constants.CardActionUpdate,
-			"assigned_to": card.AssignedTo,
-		}, assignedToIds)
 	}
 
-	ctx.Log.Info("HandleStatus Job Ended")
+	// //Websocket message
+	j.ws.SendCardsDataMiddleware(ctx, &card, map[string]interface{}{
+		"card_id":     card.Id,
+		"event":       constants.CardActionUpdate,
+		"assigned_to": card.AssignedTo,
+	}, assignedToIds)
 
 	return nil
 }
@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"regexp"
 	"strings"
+	"time"
 
 	"github.com/google/uuid"
 
@@ -19,6 +20,7 @@ import (
 	"bitbucket.org/radarventures/forwarder-shipments/daos/invoice"
 	"bitbucket.org/radarventures/forwarder-shipments/daos/invoicerequest"
 	"bitbucket.org/radarventures/forwarder-shipments/daos/shipment"
+	"bitbucket.org/radarventures/forwarder-shipments/daos/tenant"
 	"bitbucket.org/radarventures/forwarder-shipments/database/models"
 	inv "bitbucket.org/radarventures/forwarder-shipments/services/invoice"
 	"bitbucket.org/radarventures/forwarder-shipments/services/invoice/helper"
@@ -35,6 +37,7 @@ type PendingInvoice struct {
 	RegionId         uuid.UUID
 	DocId            uuid.UUID
 	CreatedBy        uuid.UUID
+	RetryCount       int
 }
 
 type IcaInvoice struct {
@@ -49,187 +52,258 @@ func removeSpecialChars(str string) string {
 	return cleanedStr
 }
 
-func FetchPendingInvoices(ctx *context.Context) {
+// Failed retrievals are retried with a backoff doubling from RetrievalBackoffBase up to
+// RetrievalBackoffMax, and parked in the dead-letter state once they have failed
+// MaxRetrievalAttempts times.
+const (
+	MaxRetrievalAttempts = 6
+	RetrievalBackoffBase = 5 * time.Minute
+	RetrievalBackoffMax  = 6 * time.Hour
+)
 
-	var pendingInvoices []PendingInvoice
+func FetchPendingInvoices(ctx *context.Context) {
 
-	err := ctx.DB.Table("invoice_requests JOIN invoices ON invoices.invoice_request_id::TEXT = invoice_requests.id::TEXT").
-		Where("is_completed", "false").
-		Find(&pendingInvoices).
-		Error
+	pendingInvoices, err := getPendingInvoices(ctx)
 	if err != nil {
-		ctx.Log.Error("unable to get pending invoices", zap.Error(err))
 		return
 	}
 
-	shipmentDocs := []*models.Document{}
-
 	miscService := *misc.New(config.Get().MiscURL)
 	idService := id.New(config.Get().IdURL)
 
-	for _, pendingInvoice := range pendingInvoices {
+	// One failing invoice must not hold back the ones behind it
+	RunPool(ctx, "invoice-retrievel", pendingInvoices, func(pendingInvoice PendingInvoice) string {
+		return pendingInvoice.InvoiceRequestId.String()
+	}, func(ctx *context.Context, pendingInvoice PendingInvoice) error {
 
 		ctx.Log.Info("prending invoices", zap.Any("shipment_id", pendingInvoice.ShipmentId), zap.Any("invoice_request_id", pendingInvoice.InvoiceRequestId))
 
 		if pendingInvoice.DocId != uuid.Nil {
-			continue
+			return ErrSkipItem
 		}
 
-		res, err := inv.NewInvoiceService().GetInvoiceRetrieval(ctx, pendingInvoice.Id)
-		if err != nil {
-			ctx.Log.Error("unable to get the response", zap.Error(err))
-			return
+		if IsDryRun(ctx) {
+			logDryRun(ctx, "retrieve invoice copy and attach it to the shipment", zap.String("invoice_no", pendingInvoice.No), zap.Int("retry_count", pendingInvoice.RetryCount))
+			return nil
 		}
 
-		shipment, err := shipment.NewShipment().Get(ctx, pendingInvoice.ShipmentId.String())
+		err := processPendingInvoice(ctx, pendingInvoice, &miscService, idService)
 		if err != nil {
-			ctx.Log.Error("unable to shipment", zap.Error(err))
-			return
+			recordRetrievalFailure(ctx, pendingInvoice, err)
 		}
 
-		owner := globals.Internal
-		var partnerName string
-		if shipment.CompanyId == pendingInvoice.CompanyId && (shipment.RegionId == shipment.OriginRegionId && shipment.RegionId == shipment.DestRegionId) {
-			if pendingInvoice.InvoiceType == constants.CustomerInvoice || pendingInvoice.InvoiceType == constants.CreditNote {
-				owner = "Customer"
-			}
+		return err
+	})
+
+}
+
+// getPendingInvoices returns the tenant's invoices whose copy is due for retrieval.
+func getPendingInvoices(ctx *context.Context) ([]PendingInvoice, error) {
+	var pendingInvoices []PendingInvoice
+
+	invoiceRequestsTable, err := tenant.Table(ctx, "invoice_requests")
+	if err != nil {
+		return nil, err
+	}
+	invoicesTable, err := tenant.Table(ctx, "invoices")
+	if err != nil {
+		return nil, err
+	}
+
+	err = ctx.DB.Table(invoiceRequestsTable+" AS invoice_requests JOIN "+invoicesTable+" AS invoices ON invoices.invoice_request_id::TEXT = invoice_requests.id::TEXT").
+		Where("is_completed", "false").
+		Where("COALESCE(invoice_requests.is_dead_letter, false) = false").
+		Where("(invoice_requests.next_retry_at IS NULL OR invoice_requests.next_retry_at <= ?)", time.Now()).
+		Find(&pendingInvoices).
+		Error
+	if err != nil {
+		ctx.Log.Error("unable to get pending invoices", zap.Error(err))
+		return nil, err
+	}
+
+	return pendingInvoices, nil
+}
+
+func processPendingInvoice(ctx *context.Context, pendingInvoice PendingInvoice, miscService *misc.Misc, idService *id.ID) error {
+
+	res, err := inv.NewInvoiceService().GetInvoiceRetrieval(ctx, pendingInvoice.Id)
+	if err != nil {
+		ctx.Log.Error("unable to get the response", zap.Error(err))
+		return err
+	}
+
+	shipment, err := shipment.NewShipment().Get(ctx, pendingInvoice.ShipmentId.String())
+	if err != nil {
+		ctx.Log.Error("unable to shipment", zap.Error(err))
+		return err
+	}
+
+	owner := globals.Internal
+	var partnerName string
+	if shipment.CompanyId == pendingInvoice.CompanyId && (shipment.RegionId == shipment.OriginRegionId && shipment.RegionId == shipment.DestRegionId) {
+		if pendingInvoice.InvoiceType == constants.CustomerInvoice || pendingInvoice.InvoiceType == constants.CreditNote {
+			owner = "Customer"
 		}
+	}
+
+	if shipment.Type == constants.ShipmentTypeMisc {
+		owner = globals.Internal
+	}
 
-		if shipment.Type == constants.ShipmentTypeMisc {
-			owner = globals.Internal
+	if pendingInvoice.InvoiceType == constants.DebitNote || pendingInvoice.InvoiceType == constants.VendorInvoice {
+
+		partnerAccount, err := idService.GetPartner(ctx, pendingInvoice.CompanyId.String())
+		if err != nil {
+			ctx.Log.Error("unable to get partner", zap.Error(err))
+			return err
 		}
 
-		if pendingInvoice.InvoiceType == constants.DebitNote || pendingInvoice.InvoiceType == constants.VendorInvoice {
+		if partnerAccount != nil {
+			partnerName = removeSpecialChars(partnerAccount.Company.Name)
+		}
 
-			partnerAccount, err := idService.GetPartner(ctx, pendingInvoice.CompanyId.String())
-			if err != nil {
-				return
-			}
+		ctx.Log.Info("partner details", zap.Any("partnerName", partnerName))
 
-			if partnerAccount != nil {
-				partnerName = removeSpecialChars(partnerAccount.Company.Name)
-			}
+		owner = "Partner"
+	}
 
-			ctx.Log.Info("partner details", zap.Any("partnerName", partnerName))
+	if res == nil {
 
-			owner = "Partner"
+		err = helper.NewHelper().DeleteInvoice(ctx, pendingInvoice.Id)
+		if err != nil {
+			ctx.Log.Error("unable to delete invoice", zap.Error(err))
+			return err
 		}
 
-		if res != nil {
-
-			fileName := pendingInvoice.InvoiceType + "-" + pendingInvoice.No + "." + upload.FileFormatPDF
-
-			docRes, err := upload.New(config.Get().MiscURL).UploadToS3(ctx, &upload.UploadReq{
-				File:        res.([]byte),
-				Folder:      fmt.Sprintf("/companies/%v/shipments/%v", pendingInvoice.CompanyId, pendingInvoice.ShipmentId),
-				FileName:    fileName,
-				FileFormat:  upload.FileFormatPDF,
-				ContentType: upload.ContentTypeApplication,
-			})
-			if err != nil {
-				ctx.Log.Error("unable to upload to s3", zap.Error(err))
-				return
-			}
-
-			shipmentDocs = append(shipmentDocs, &models.Document{
-				Id:           uuid.New(),
-				DocumentId:   docRes.DocumentId,
-				Name:         fileName,
-				Type:         pendingInvoice.InvoiceType,
-				Owner:        owner,
-				InstanceId:   pendingInvoice.ShipmentId,
-				InstanceType: constants.WorkflowTypeShipment,
-				RegionId:     pendingInvoice.RegionId,
-				CreatedBy:    uuid.MustParse(config.Get().WizBotID),
-				UpdatedBy:    uuid.MustParse(config.Get().WizBotID),
-			})
-
-			err = invoice.NewInvoice().Update(ctx, &models.Invoice{
-				DocId: docRes.DocumentId,
-				ID:    pendingInvoice.Id,
-			})
-			if err != nil {
-				ctx.Log.Error("unable to update the invoice", zap.Error(err))
-				return
-			}
-
-			err = invoicerequest.NewInvoiceRequest().Update(ctx, &models.InvoiceRequest{
-				IsCompleted:  true,
-				IsSuccessful: true,
-				ID:           pendingInvoice.InvoiceRequestId,
-			})
-			if err != nil {
-				ctx.Log.Error("unable to update the invoice", zap.Error(err))
-				return
-			}
-
-			adminName := ""
-			updatedBy := pendingInvoice.CreatedBy.String()
-			if ctx.Account != nil {
-				adminDetails, err := idService.GetAccountInternal(ctx, pendingInvoice.CreatedBy.String())
-				if err != nil {
-					ctx.Log.Error("error while getting account", zap.Error(err))
-					return
-				}
-				if adminDetails != nil {
-					adminName = adminDetails.Name
-				}
-			}
-
-			taggedMembers := make(map[string]interface{})
-			taggedMembers[updatedBy] = adminName
-
-			ctx.Log.Info("admin details", zap.Any("adminName", adminName), zap.Any("updatedBy", updatedBy))
-
-			_, err = miscService.SendCollab(ctx, &miscdtos.CollabMsg{
-				RefID:         pendingInvoice.ShipmentId.String(),
-				RefType:       "shipment",
-				Msg:           fmt.Sprintf("Hi %s invoice copy has been retrieved for the invoice number %s. Please refer to the document tab for the uploaded invoice copy", adminName, pendingInvoice.No),
-				TaggedMembers: taggedMembers,
-				TaskRegionID:  pendingInvoice.RegionId.String(),
-				ChatType:      "internal_chat",
-				CreatedBy:     config.Get().WizBotID,
-				AccountId:     updatedBy,
-			})
-			if err != nil {
-				ctx.Log.Error("failed to send collab message", zap.Error(err))
-			}
-
-		} else {
-
-			err = helper.NewHelper().DeleteInvoice(ctx, pendingInvoice.Id)
-			if err != nil {
-				ctx.Log.Error("unable to delete invoice", zap.Error(err))
-				return
-			}
-
-			err = invoicerequest.NewInvoiceRequest().Update(ctx, &models.InvoiceRequest{
-				IsCompleted:  true,
-				IsSuccessful: false,
-				ID:           pendingInvoice.InvoiceRequestId,
-			})
-			if err != nil {
-				ctx.Log.Error("unable to update the invoice", zap.Error(err))
-				return
-			}
+		err = invoicerequest.NewInvoiceRequest().Update(ctx, &models.InvoiceRequest{
+			IsCompleted:  true,
+			IsSuccessful: false,
+			ID:           pendingInvoice.InvoiceRequestId,
+		})
+		if err != nil {
+			ctx.Log.Error("unable to update the invoice", zap.Error(err))
+			return err
 		}
 
+		return nil
+	}
+
+	fileName := pendingInvoice.InvoiceType + "-" + pendingInvoice.No + "." + upload.FileFormatPDF
+
+	docRes, err := upload.New(config.Get().MiscURL).UploadToS3(ctx, &upload.UploadReq{
+		File:        res.([]byte),
+		Folder:      fmt.Sprintf("/companies/%v/shipments/%v", pendingInvoice.CompanyId, pendingInvoice.ShipmentId),
+		FileName:    fileName,
+		FileFormat:  upload.FileFormatPDF,
+		ContentType: upload.ContentTypeApplication,
+	})
+	if err != nil {
+		ctx.Log.Error("unable to upload to s3", zap.Error(err))
+		return err
+	}
+
+	// The document is saved with the invoice it belongs to, so a later failure in the batch
+	// cannot lose it
+	err = document.NewDocument().Upsert(ctx, &models.Document{
+		Id:           invoiceCopyDocumentId(pendingInvoice.Id),
+		DocumentId:   docRes.DocumentId,
+		Name:         fileName,
+		Type:         pendingInvoice.InvoiceType,
+		Owner:        owner,
+		InstanceId:   pendingInvoice.ShipmentId,
+		InstanceType: constants.WorkflowTypeShipment,
+		RegionId:     pendingInvoice.RegionId,
+		CreatedBy:    uuid.MustParse(config.Get().WizBotID),
+		UpdatedBy:    uuid.MustParse(config.Get().WizBotID),
+	})
+	if err != nil {
+		ctx.Log.Error("unable to upsert invoice docs", zap.Error(err))
+		return err
+	}
+
+	err = invoice.NewInvoice().Update(ctx, &models.Invoice{
+		DocId: docRes.DocumentId,
+		ID:    pendingInvoice.Id,
+	})
+	if err != nil {
+		ctx.Log.Error("unable to update the invoice", zap.Error(err))
+		return err
+	}
+
+	err = invoicerequest.NewInvoiceRequest().Update(ctx, &models.InvoiceRequest{
+		IsCompleted:  true,
+		IsSuccessful: true,
+		ID:           pendingInvoice.InvoiceRequestId,
+	})
+	if err != nil {
+		ctx.Log.Error("unable to update the invoice", zap.Error(err))
+		return err
 	}
 
-	if len(shipmentDocs) > 0 {
-		err := document.NewDocument().Upsert(ctx, shipmentDocs...)
+	// The invoice copy is retrieved at this point, failures below only affect the collab message
+	adminName := ""
+	updatedBy := pendingInvoice.CreatedBy.String()
+	if ctx.Account != nil {
+		adminDetails, err := idService.GetAccountInternal(ctx, pendingInvoice.CreatedBy.String())
 		if err != nil {
-			ctx.Log.Error("unable to upsert invoice docs", zap.Error(err))
-			return
+			ctx.Log.Error("error while getting account", zap.Error(err))
 		}
+		if adminDetails != nil {
+			adminName = adminDetails.Name
+		}
+	}
+
+	taggedMembers := make(map[string]interface{})
+	taggedMembers[updatedBy] = adminName
+
+	ctx.Log.Info("admin details", zap.Any("adminName", adminName), zap.Any("updatedBy", updatedBy))
+
+	_, err = miscService.SendCollab(ctx, &miscdtos.CollabMsg{
+		RefID:         pendingInvoice.ShipmentId.String(),
+		RefType:       "shipment",
+		Msg:           fmt.Sprintf("Hi %s invoice copy has been retrieved for the invoice number %s. Please refer to the document tab for the uploaded invoice copy", adminName, pendingInvoice.No),
+		TaggedMembers: taggedMembers,
+		TaskRegionID:  pendingInvoice.RegionId.String(),
+		ChatType:      "internal_chat",
+		CreatedBy:     config.Get().WizBotID,
+		AccountId:     updatedBy,
+	})
+	if err != nil {
+		ctx.Log.Error("failed to send collab message", zap.Error(err))
+	}
+
+	return nil
+}
+
+// recordRetrievalFailure schedules the next attempt of a failed invoice request, or moves it to
+// the dead-letter state once it has used up its attempts.
+func recordRetrievalFailure(ctx *context.Context, pendingInvoice PendingInvoice, resErr error) {
+	retryCount, deadLetter, err := invoicerequest.NewInvoiceRequest().IncrementRetry(ctx, pendingInvoice.InvoiceRequestId, MaxRetrievalAttempts, RetrievalBackoffBase, RetrievalBackoffMax, resErr)
+	if err != nil {
+		ctx.Log.Error("unable to record invoice retrieval failure", zap.Any("invoice_request_id", pendingInvoice.InvoiceRequestId), zap.Error(err))
+		return
+	}
+
+	if deadLetter {
+		ctx.Log.Warn("invoice request moved to dead letter", zap.Any("invoice_request_id", pendingInvoice.InvoiceRequestId), zap.Int("retry_count", retryCount), zap.Error(resErr))
 	}
+}
 
+// invoiceCopyDocumentId is the id of the document of an invoice's retrieved copy. It is the same
+// on every attempt, so a retry updates the document instead of adding another one.
+func invoiceCopyDocumentId(invoiceId uuid.UUID) uuid.UUID {
+	return uuid.NewSHA1(invoiceId, []byte("invoice-copy"))
 }
 
 func IcaInvoices(ctx *context.Context) {
 	var icaInvoices []IcaInvoice
 
-	err := ctx.DB.Table("invoices").
+	invoicesTable, err := tenant.Table(ctx, "invoices")
+	if err != nil {
+		return
+	}
+
+	err = ctx.DB.Table(invoicesTable).
 		Where("is_ica_invoice = ? AND cost_booking_generated = ?", true, false).
 		Find(&icaInvoices).
 		Error
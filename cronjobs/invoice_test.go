@@ -0,0 +1,114 @@
+package cronjobs
+
+import (
+	"errors"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+
+	"bitbucket.org/radarventures/forwarder-adapters/utils/context"
+	"bitbucket.org/radarventures/forwarder-adapters/utils/db"
+	ulog "bitbucket.org/radarventures/forwarder-adapters/utils/log"
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+const (
+	invoiceRequestsDDL = `CREATE TABLE %s.invoice_requests (
+		id uuid PRIMARY KEY, is_completed boolean, retry_count int, next_retry_at timestamptz,
+		is_dead_letter boolean, error_message text)`
+	invoicesDDL = `CREATE TABLE %s.invoices (
+		id uuid PRIMARY KEY, no text, invoice_type text, shipment_id uuid, company_id uuid,
+		invoice_request_id uuid, region_id uuid, doc_id uuid, created_by uuid)`
+)
+
+// The retrieval test needs a Postgres database, TEST_DATABASE_URL points at one. Each tenant
+// is a schema of its own that is dropped afterwards.
+func tenantContext(t *testing.T) *context.Context {
+	t.Helper()
+
+	url := os.Getenv("TEST_DATABASE_URL")
+	if url == "" {
+		t.Skip("TEST_DATABASE_URL is not set")
+	}
+
+	db.Init(&db.Config{URL: url, MaxDBConn: 10})
+
+	c := &context.Context{}
+	c.RefID = uuid.New().String()
+	c.Log = ulog.New(c.RefID, "forwarder-shipments", "error")
+	c.DB = db.New()
+	c.TenantID = "tenant_" + strings.ReplaceAll(uuid.New().String(), "-", "")
+	c.Context, _ = gin.CreateTestContext(httptest.NewRecorder())
+	c.Context.Request = httptest.NewRequest("GET", "/invoice-retrievel", nil)
+
+	if err := c.DB.Exec("CREATE SCHEMA " + c.TenantID).Error; err != nil {
+		t.Fatalf("create schema: %v", err)
+	}
+	t.Cleanup(func() {
+		c.DB.Exec("DROP SCHEMA " + c.TenantID + " CASCADE")
+	})
+
+	for _, ddl := range []string{invoiceRequestsDDL, invoicesDDL} {
+		if err := c.DB.Exec(strings.ReplaceAll(ddl, "%s", c.TenantID)).Error; err != nil {
+			t.Fatalf("create table: %v", err)
+		}
+	}
+
+	return c
+}
+
+func getRetryCount(t *testing.T, ctx *context.Context, requestId uuid.UUID) int {
+	t.Helper()
+
+	var count int
+	err := ctx.DB.Raw("SELECT retry_count FROM "+ctx.TenantID+".invoice_requests WHERE id = ?", requestId).Scan(&count).Error
+	if err != nil {
+		t.Fatalf("get retry count: %v", err)
+	}
+	return count
+}
+
+// A failed retrieval run over every tenant counts one retry per tenant, even when tenants
+// hold the same invoice request as they do after their rows were copied out of public.
+func TestRetrievalFailureCountsOncePerTenant(t *testing.T) {
+	tenants := []*context.Context{tenantContext(t), tenantContext(t)}
+
+	requestId := uuid.New()
+	for _, ctx := range tenants {
+		err := ctx.DB.Exec("INSERT INTO "+ctx.TenantID+".invoice_requests (id, is_completed, retry_count) VALUES (?, false, 0)", requestId).Error
+		if err != nil {
+			t.Fatalf("create invoice request: %v", err)
+		}
+		err = ctx.DB.Exec("INSERT INTO "+ctx.TenantID+".invoices (id, no, invoice_request_id) VALUES (?, 'INV-1', ?)", uuid.New(), requestId).Error
+		if err != nil {
+			t.Fatalf("create invoice: %v", err)
+		}
+	}
+
+	for _, ctx := range tenants {
+		pendingInvoices, err := getPendingInvoices(ctx)
+		if err != nil {
+			t.Fatalf("get pending invoices of %s: %v", ctx.TenantID, err)
+		}
+		if len(pendingInvoices) != 1 {
+			t.Fatalf("%s has %d pending invoices, want 1", ctx.TenantID, len(pendingInvoices))
+		}
+
+		for _, pendingInvoice := range pendingInvoices {
+			recordRetrievalFailure(ctx, pendingInvoice, errors.New("invoice copy not generated yet"))
+		}
+	}
+
+	for _, ctx := range tenants {
+		if count := getRetryCount(t, ctx, requestId); count != 1 {
+			t.Fatalf("retry count of %s = %d, want 1", ctx.TenantID, count)
+		}
+
+		pendingInvoices, err := getPendingInvoices(ctx)
+		if err != nil || len(pendingInvoices) != 0 {
+			t.Fatalf("%s has %d pending invoices inside the backoff, %v", ctx.TenantID, len(pendingInvoices), err)
+		}
+	}
+}
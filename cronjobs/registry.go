@@ -0,0 +1,312 @@
+package cronjobs
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"text/tabwriter"
+
+	"bitbucket.org/radarventures/forwarder-adapters/utils/context"
+	"bitbucket.org/radarventures/forwarder-shipments/services/ams/amsmail"
+	"go.uber.org/zap"
+)
+
+const (
+	dryRunKey    = "cronjob_dry_run"
+	jobParamsKey = "cronjob_params"
+)
+
+type JobParam struct {
+	Name        string
+	Description string
+	Default     string
+}
+
+// Job is a cronjob that can be started with -cronjob or run by the scheduler. Jobs must
+// honour IsDryRun and only log the changes they would make when it is set. A job runs once
+// for every tenant, a Global job runs once in the public schema for all tenants, e.g. to
+// read an inbox shared by every tenant.
+type Job struct {
+	Name        string
+	Description string
+	Path        string
+	Params      []JobParam
+	Global      bool
+	Run         func(ctx *context.Context) error
+}
+
+var registry = map[string]*Job{}
+
+func Register(job *Job) {
+	if _, ok := registry[job.Name]; ok {
+		panic("cronjob registered twice: " + job.Name)
+	}
+	registry[job.Name] = job
+}
+
+func GetJob(name string) (*Job, bool) {
+	job, ok := registry[name]
+	return job, ok
+}
+
+// Jobs returns the registered cronjobs sorted by name.
+func Jobs() []*Job {
+	jobs := make([]*Job, 0, len(registry))
+	for _, job := range registry {
+		jobs = append(jobs, job)
+	}
+
+	sort.Slice(jobs, func(i, j int) bool {
+		return jobs[i].Name < jobs[j].Name
+	})
+
+	return jobs
+}
+
+// ListJobs writes the registered cronjobs with their parameters for -list-jobs.
+func ListJobs(w io.Writer) {
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "JOB\tDESCRIPTION")
+	for _, job := range Jobs() {
+		fmt.Fprintf(tw, "%s\t%s\n", job.Name, job.Description)
+		for _, param := range job.Params {
+			fmt.Fprintf(tw, "  %s=%s\t%s\n", param.Name, param.Default, param.Description)
+		}
+	}
+	fmt.Fprintln(tw, "\nSet parameters with -params name=value,name=value; the values shown are the defaults.")
+	tw.Flush()
+}
+
+// ParseJobParams parses "name=value" pairs separated by commas and checks them against the
+// parameters the job declares.
+func ParseJobParams(job *Job, raw string) (map[string]string, error) {
+	params := map[string]string{}
+	for _, param := range job.Params {
+		params[param.Name] = param.Default
+	}
+
+	if strings.TrimSpace(raw) == "" {
+		return params, nil
+	}
+
+	for _, pair := range strings.Split(raw, ",") {
+		kv := strings.SplitN(pair, "=", 2)
+		if len(kv) != 2 {
+			return nil, fmt.Errorf("invalid job parameter %q, expected name=value", pair)
+		}
+
+		name := strings.TrimSpace(kv[0])
+		if _, ok := params[name]; !ok {
+			return nil, fmt.Errorf("cronjob %s has no parameter %q", job.Name, name)
+		}
+		params[name] = strings.TrimSpace(kv[1])
+	}
+
+	return params, nil
+}
+
+// IsDryRun reports whether the job should only log the changes it would make.
+func IsDryRun(ctx *context.Context) bool {
+	if ctx.Context == nil {
+		return false
+	}
+
+	return ctx.GetBool(dryRunKey)
+}
+
+// GetJobParam returns a parameter of the running job, or its declared default.
+func GetJobParam(ctx *context.Context, name string) string {
+	if ctx.Context == nil {
+		return ""
+	}
+
+	params, _ := ctx.Get(jobParamsKey)
+	if values, ok := params.(map[string]string); ok {
+		return values[name]
+	}
+
+	return ""
+}
+
+// logDryRun logs a change a job skipped because of -dry-run.
+func logDryRun(ctx *context.Context, action string, fields ...zap.Field) {
+	ctx.Log.Info("dry run: would "+action, fields...)
+}
+
+func init() {
+	Register(&Job{
+		Name:        "updateQuoteExpiry",
+		Description: "Marks quotes past their validity as expired",
+		Path:        "/expired-quotes-job",
+		Run: func(ctx *context.Context) error {
+			updateQuoteExpiry(ctx)
+			return nil
+		},
+	})
+
+	Register(&Job{
+		Name:        "handleCardStatus",
+		Description: "Moves pending cards between created, warning and breached by their estimate",
+		Path:        "/handle-card-status",
+		Params: []JobParam{
+			{Name: "warning_minutes", Description: "Minutes before the estimate a card turns to warning", Default: "60"},
+		},
+		Run: func(ctx *context.Context) error {
+			return NewHandleCardStatus().HandleStatus(ctx)
+		},
+	})
+
+	Register(&Job{
+		Name:        "containerTracking",
+		Description: "Automates container tracking of FCL shipments",
+		Path:        "/container-tracking",
+		Params: []JobParam{
+			{Name: "created_since", Description: "Only track shipments created on or after this date", Default: "2024-10-01"},
+		},
+		Run: func(ctx *context.Context) error {
+			RunContainerTrackingAutomation(ctx)
+			return nil
+		},
+	})
+
+	Register(&Job{
+		Name:        "sisrequestjob",
+		Description: "Imports SIS booking request files from the SFTP inbox",
+		Path:        "/sis-request-job",
+		Run: func(ctx *context.Context) error {
+			Run(ctx)
+			return nil
+		},
+	})
+
+	Register(&Job{
+		Name:        "CardsDeletePostExpiry",
+		Description: "Deletes the cards of expired booking requests",
+		Path:        "/cards-delete-post-expiry",
+		Run: func(ctx *context.Context) error {
+			return NewCardsPostExpiry().CardsPostExpiry(ctx)
+		},
+	})
+
+	Register(&Job{
+		Name:        "UpdateShipmentlockStatus",
+		Description: "Locks shipments once a rule of their region's lock policies fires",
+		Path:        "/update-shipment-lock",
+		Run: func(ctx *context.Context) error {
+			NewUpdateShipmentLock().UpdateShipmentlockStatus(ctx)
+			return nil
+		},
+	})
+
+	Register(&Job{
+		Name:        "UpdateShipmentlockStatuV2",
+		Description: "Locks shipments unlocked without an unlock request again once they are idle",
+		Path:        "/update-shipment-lock-v2",
+		Params: []JobParam{
+			{Name: "idle_minutes", Description: "Minutes since the last update after which a shipment is locked again", Default: "90"},
+		},
+		Run: func(ctx *context.Context) error {
+			NewUpdateShipmentLock().UpdateShipmentlockStatusV2(ctx)
+			return nil
+		},
+	})
+
+	Register(&Job{
+		Name:        "relockExpiredShipmentUnlocks",
+		Description: "Locks shipments again once their approved unlock request expired",
+		Path:        "/relock-expired-shipment-unlocks",
+		Run: func(ctx *context.Context) error {
+			return RelockExpiredShipmentUnlocks(ctx)
+		},
+	})
+
+	Register(&Job{
+		Name:        "AMSCheckMail",
+		Description: "Updates AMS filing statuses from the AMS mailbox",
+		Path:        "/check-ams",
+		Global:      true,
+		Params: []JobParam{
+			{Name: "sender", Description: "Address the AMS mails are sent from", Default: amsmail.DefaultSender},
+			{Name: "hbl_prefix", Description: "Subject prefix of status mails, followed by the house bill number", Default: amsmail.DefaultHBLPrefix},
+			{Name: "inbox", Description: "Where mails are read from, graph or dir", Default: "graph"},
+			{Name: "dir", Description: "Directory of .eml files read when inbox is dir", Default: ""},
+		},
+		Run: CheckMails,
+	})
+
+	Register(&Job{
+		Name:        "raiseAMSFilingAlerts",
+		Description: "Raises cards for AMS filings that were rejected or are not accepted by the vessel's cut-off",
+		Path:        "/raise-ams-filing-alerts",
+		Params: []JobParam{
+			{Name: "cutoff_hours", Description: "Hours before the ETD a filing must be accepted by", Default: "24"},
+		},
+		Run: RaiseAMSFilingAlerts,
+	})
+
+	Register(&Job{
+		Name:        "sendDueDSRs",
+		Description: "Emails the daily status reports customers subscribed to once they are due",
+		Path:        "/send-due-dsrs",
+		Run:         SendDueDSRs,
+	})
+
+	Register(&Job{
+		Name:        "InvoiceRetrievel",
+		Description: "Retrieves generated invoice copies and attaches them to their shipments",
+		Path:        "/invoice-retrievel",
+		Run: func(ctx *context.Context) error {
+			FetchPendingInvoices(ctx)
+			return nil
+		},
+	})
+
+	Register(&Job{
+		Name:        "invoiceDunningReminders",
+		Description: "Emails escalating reminders for overdue customer invoices",
+		Path:        "/invoice-dunning-reminders",
+		Run: func(ctx *context.Context) error {
+			return NewDunning().SendDunningReminders(ctx)
+		},
+	})
+
+	Register(&Job{
+		Name:        "releaseExpiredStockReservations",
+		Description: "Returns stock numbers whose reservation expired unconfirmed to the available stock",
+		Path:        "/release-expired-stock-reservations",
+		Run: func(ctx *context.Context) error {
+			return ReleaseExpiredStockReservations(ctx)
+		},
+	})
+
+	Register(&Job{
+		Name:        "lowStockAlerts",
+		Description: "Emails stock owners when an airline's projected days of cover at a port falls below its threshold",
+		Path:        "/low-stock-alerts",
+		Params: []JobParam{
+			{Name: "weeks", Description: "Weeks of usage the consumption rate is measured over", Default: "4"},
+			{Name: "realert_hours", Description: "Hours before the owners of the same stock are alerted again", Default: "24"},
+		},
+		Run: func(ctx *context.Context) error {
+			return NewLowStockAlerts().SendLowStockAlerts(ctx)
+		},
+	})
+
+	Register(&Job{
+		Name:        "migratePublicTenantData",
+		Description: "Copies each tenant's stock and AWB rows from the public schema into the tenant's schema",
+		Path:        "/migrate-public-tenant-data",
+		Run:         MigratePublicTenantData,
+	})
+
+	Register(&Job{
+		Name:        "expiryCardsNotifications",
+		Description: "Emails managers and executives their breached and expiring cards",
+		Path:        "/expiry-cards-notifications",
+		Run: func(ctx *context.Context) error {
+			NewExpiryCards().SendExpiryCardsNotifications(ctx)
+			return nil
+		},
+	})
+}
@@ -0,0 +1,283 @@
+package cronjobs
+
+import (
+	stdcontext "context"
+	"errors"
+	"fmt"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+	"time"
+
+	"bitbucket.org/radarventures/forwarder-adapters/utils/context"
+	"bitbucket.org/radarventures/forwarder-shipments/config"
+	"bitbucket.org/radarventures/forwarder-shipments/constants"
+	"bitbucket.org/radarventures/forwarder-shipments/daos/jobrun"
+	"bitbucket.org/radarventures/forwarder-shipments/database/models"
+	"github.com/google/uuid"
+	"go.uber.org/zap"
+)
+
+// ErrJobLocked is returned when another replica holds the advisory lock of a job.
+var ErrJobLocked = errors.New("job is already running on another replica")
+
+const poolSummariesKey = "cronjob_pool_summaries"
+
+// StartScheduler runs every job that has a schedule under "cron_schedules" in the config
+// until the process receives SIGINT or SIGTERM. Jobs in progress are allowed to finish.
+func StartScheduler() error {
+
+	ctx := getContext()
+
+	schedules, err := loadSchedules(config.Get().CronSchedules)
+	if err != nil {
+		ctx.Log.Error("unable to load cron schedules", zap.Error(err))
+		return err
+	}
+
+	if len(schedules) == 0 {
+		ctx.Log.Warn("no cron schedules configured")
+		return nil
+	}
+
+	root, stop := signal.NotifyContext(stdcontext.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	wg := sync.WaitGroup{}
+	for name, schedule := range schedules {
+		wg.Add(1)
+		go func(name string, schedule *CronSchedule) {
+			defer wg.Done()
+			runOnSchedule(root, name, schedule)
+		}(name, schedule)
+	}
+
+	ctx.Log.Info("scheduler started", zap.Int("jobs", len(schedules)))
+	wg.Wait()
+	ctx.Log.Info("scheduler stopped")
+
+	return nil
+}
+
+// loadSchedules parses the cron expression of every configured job.
+func loadSchedules(cronSchedules map[string]string) (map[string]*CronSchedule, error) {
+	schedules := map[string]*CronSchedule{}
+	for name, expr := range cronSchedules {
+		if _, ok := GetJob(name); !ok {
+			return nil, fmt.Errorf("unknown cronjob %q in cron_schedules", name)
+		}
+
+		schedule, err := ParseCron(expr)
+		if err != nil {
+			return nil, err
+		}
+		schedules[name] = schedule
+	}
+
+	return schedules, nil
+}
+
+// runOnSchedule waits for each next activation of the job and runs it. A run that outlasts
+// the following activation skips it rather than starting an overlapping run.
+func runOnSchedule(root stdcontext.Context, name string, schedule *CronSchedule) {
+	for {
+		next := schedule.Next(time.Now())
+		if next.IsZero() {
+			return
+		}
+
+		timer := time.NewTimer(time.Until(next))
+		select {
+		case <-root.Done():
+			timer.Stop()
+			return
+		case <-timer.C:
+		}
+
+		RunScheduledJob(name, next)
+	}
+}
+
+// RunScheduledJob runs the tick of a job at scheduledAt under the job's advisory lock once for
+// every tenant, or once in public for a global job, and records each run in the job_runs of
+// the schema it ran in. Tenants whose run of the tick was claimed already are skipped.
+func RunScheduledJob(name string, scheduledAt time.Time) ([]*models.JobRun, error) {
+
+	job, ok := GetJob(name)
+	if !ok {
+		return nil, fmt.Errorf("unknown cronjob %q", name)
+	}
+
+	params, err := ParseJobParams(job, "")
+	if err != nil {
+		return nil, err
+	}
+
+	schemas, err := getJobSchemas(job)
+	if err != nil {
+		return nil, err
+	}
+
+	ctx := getContext()
+	unlock, err := acquireJobLock(ctx, name)
+	if err != nil {
+		if errors.Is(err, ErrJobLocked) {
+			ctx.Log.Info("skipping job locked by another replica", zap.String("job", name))
+		}
+		return nil, err
+	}
+	defer unlock()
+
+	runs := make([]*models.JobRun, 0, len(schemas))
+	var jobErrs []error
+	for _, schema := range schemas {
+		run, err := runTenantJob(job, params, schema, scheduledAt)
+		if run != nil {
+			runs = append(runs, run)
+		}
+		if err != nil {
+			jobErrs = append(jobErrs, fmt.Errorf("tenant %s: %w", schema, err))
+		}
+	}
+
+	return runs, errors.Join(jobErrs...)
+}
+
+// runTenantJob claims the tick for one tenant in the tenant's job_runs and runs the job. The
+// advisory lock only keeps runs from overlapping, a replica that takes it after another one
+// finished the tick finds the tick claimed and returns a nil run.
+func runTenantJob(job *Job, params map[string]string, tenantId string, scheduledAt time.Time) (*models.JobRun, error) {
+
+	ctx := newJobContext(job, false, params, tenantId)
+
+	host, _ := os.Hostname()
+	run := &models.JobRun{
+		Id:          uuid.New(),
+		Job:         job.Name,
+		TenantId:    tenantId,
+		ScheduledAt: scheduledAt.UTC(),
+		Status:      constants.JobRunStatusRunning,
+		Host:        host,
+		StartedAt:   time.Now().UTC(),
+	}
+
+	jobRunDb := jobrun.NewJobRun()
+	claimed, err := jobRunDb.Claim(ctx, run)
+	if err != nil {
+		return nil, err
+	}
+	if !claimed {
+		ctx.Log.Info("skipping job run claimed by another replica", zap.String("job", job.Name), zap.String("tenant_id", tenantId), zap.Time("scheduled_at", run.ScheduledAt))
+		return nil, nil
+	}
+
+	// Worker pools report their item counts back to the run through the request context
+	summaries := &poolSummaries{}
+	ctx.Set(poolSummariesKey, summaries)
+
+	ctx.Log.Info("scheduled job started", zap.String("job", job.Name), zap.String("tenant_id", tenantId), zap.Any("run_id", run.Id))
+
+	jobErr := runJob(ctx, job.Run)
+
+	ended := time.Now().UTC()
+	run.EndedAt = &ended
+	run.Status = constants.JobRunStatusSucceeded
+	if jobErr != nil {
+		run.Status = constants.JobRunStatusFailed
+		run.Error = jobErr.Error()
+	}
+
+	for _, summary := range summaries.list() {
+		run.Total += summary.Total
+		run.Succeeded += summary.Succeeded
+		run.Failed += summary.Failed
+		run.Skipped += summary.Skipped
+	}
+
+	if err := jobRunDb.Update(ctx, run); err != nil {
+		return run, err
+	}
+
+	ctx.Log.Info("scheduled job completed", zap.String("job", job.Name), zap.String("tenant_id", tenantId), zap.String("status", run.Status), zap.Duration("duration", ended.Sub(run.StartedAt)))
+
+	return run, jobErr
+}
+
+func runJob(ctx *context.Context, job func(ctx *context.Context) error) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("panic: %v", r)
+		}
+	}()
+
+	return job(ctx)
+}
+
+// acquireJobLock takes a session level advisory lock for the job on a dedicated connection, so
+// only one replica runs it at a time. The returned func releases the lock.
+func acquireJobLock(ctx *context.Context, name string) (func(), error) {
+	sqlDB, err := ctx.DB.DB()
+	if err != nil {
+		return nil, err
+	}
+
+	conn, err := sqlDB.Conn(stdcontext.Background())
+	if err != nil {
+		ctx.Log.Error("unable to get connection for job lock", zap.Error(err))
+		return nil, err
+	}
+
+	key := "cronjob:" + name
+
+	var locked bool
+	err = conn.QueryRowContext(stdcontext.Background(), "SELECT pg_try_advisory_lock(hashtext($1))", key).Scan(&locked)
+	if err != nil {
+		conn.Close()
+		ctx.Log.Error("unable to acquire job lock", zap.String("job", name), zap.Error(err))
+		return nil, err
+	}
+
+	if !locked {
+		conn.Close()
+		return nil, ErrJobLocked
+	}
+
+	return func() {
+		_, err := conn.ExecContext(stdcontext.Background(), "SELECT pg_advisory_unlock(hashtext($1))", key)
+		if err != nil {
+			ctx.Log.Error("unable to release job lock", zap.String("job", name), zap.Error(err))
+		}
+		conn.Close()
+	}, nil
+}
+
+type poolSummaries struct {
+	mu        sync.Mutex
+	summaries []*PoolSummary
+}
+
+func (p *poolSummaries) add(summary *PoolSummary) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.summaries = append(p.summaries, summary)
+}
+
+func (p *poolSummaries) list() []*PoolSummary {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.summaries
+}
+
+// reportPoolSummary hands the summary of a worker pool to the scheduled run it belongs to.
+func reportPoolSummary(ctx *context.Context, summary *PoolSummary) {
+	if ctx.Context == nil {
+		return
+	}
+
+	if v, ok := ctx.Get(poolSummariesKey); ok {
+		if summaries, ok := v.(*poolSummaries); ok {
+			summaries.add(summary)
+		}
+	}
+}
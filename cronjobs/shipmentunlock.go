@@ -0,0 +1,50 @@
+package cronjobs
+
+import (
+	"time"
+
+	"bitbucket.org/radarventures/forwarder-adapters/utils/context"
+	"bitbucket.org/radarventures/forwarder-shipments/daos/unlockrequest"
+	"bitbucket.org/radarventures/forwarder-shipments/database/models"
+	unlockrequestSer "bitbucket.org/radarventures/forwarder-shipments/services/unlockrequest"
+	"go.uber.org/zap"
+)
+
+// RelockExpiredShipmentUnlocks locks shipments again once their approved unlock expired, and
+// closes the unlock requests that expired before anyone decided them.
+func RelockExpiredShipmentUnlocks(ctx *context.Context) error {
+
+	requests, err := unlockrequest.NewUnlockRequest().GetExpired(ctx, time.Now().UTC())
+	if err != nil {
+		return err
+	}
+
+	if len(requests) == 0 {
+		return nil
+	}
+
+	if IsDryRun(ctx) {
+		for _, request := range requests {
+			logDryRun(ctx, "close expired shipment unlock request", zap.Any("request_id", request.Id), zap.Any("shipment_id", request.ShipmentId), zap.String("status", request.Status), zap.Time("expires_at", request.ExpiresAt))
+		}
+		return nil
+	}
+
+	unlockRequestService := unlockrequestSer.NewUnlockRequestService()
+	RunPool(ctx, "relockExpiredShipmentUnlocks", requests, func(r *models.ShipmentUnlockRequest) string {
+		return r.Id.String()
+	}, func(ctx *context.Context, request *models.ShipmentUnlockRequest) error {
+		closed, err := unlockRequestService.RelockExpired(ctx, request)
+		if err != nil {
+			return err
+		}
+		if !closed {
+			return ErrSkipItem
+		}
+
+		ctx.Log.Info("expired shipment unlock request closed", zap.Any("request_id", request.Id), zap.Any("shipment_id", request.ShipmentId))
+		return nil
+	})
+
+	return nil
+}
@@ -80,6 +80,11 @@ func Run(ctx *context.Context) {
 
 func ReadFile(ctx *context.Context, fname string) {
 
+	if IsDryRun(ctx) {
+		logDryRun(ctx, "import SIS booking request file and delete it", zap.String("file", fname))
+		return
+	}
+
 	if config.Get().EnableSFTP == "true" {
 		data, err := sftpClient.Get(fname)
 		if err != nil {
@@ -0,0 +1,166 @@
+package cronjobs
+
+import (
+	"bytes"
+	"html/template"
+	"strconv"
+	"time"
+
+	"bitbucket.org/radarventures/forwarder-adapters/apis/notifications"
+	dtos "bitbucket.org/radarventures/forwarder-adapters/dtos/misc"
+	"bitbucket.org/radarventures/forwarder-adapters/utils/context"
+	"bitbucket.org/radarventures/forwarder-shipments/config"
+	"bitbucket.org/radarventures/forwarder-shipments/constants"
+	"bitbucket.org/radarventures/forwarder-shipments/daos/stockalert"
+	"bitbucket.org/radarventures/forwarder-shipments/database/models"
+	"bitbucket.org/radarventures/forwarder-shipments/services/stock/forecast"
+	"github.com/google/uuid"
+	"go.uber.org/zap"
+)
+
+const lowStockTemplate = `<p>Hello,</p>
+<p>The air waybill stock of airline <b>{{.Liner}}</b> at port <b>{{.Port}}</b> is running low.</p>
+<table border="1" cellpadding="4" cellspacing="0">
+<tr><td>Available numbers</td><td>{{.Available}}</td></tr>
+<tr><td>Used in the last {{.Weeks}} weeks</td><td>{{.UsedInWindow}}</td></tr>
+<tr><td>Days of cover</td><td>{{.DaysOfCover}}</td></tr>
+<tr><td>Alert threshold</td><td>{{.MinDaysCover}} days</td></tr>
+</table>
+<p>Please request new stock from the airline.</p>
+<p>Regards,<br/>Operations Team</p>`
+
+type lowStockMailDetail struct {
+	Liner        string
+	Port         string
+	Available    int64
+	UsedInWindow int64
+	Weeks        int
+	DaysOfCover  string
+	MinDaysCover float64
+}
+
+type LowStockAlerts struct {
+	not          notifications.Notifications
+	forecast     forecast.IForecastService
+	stockAlertDb stockalert.IStockAlert
+}
+
+func NewLowStockAlerts() ILowStockAlerts {
+	return &LowStockAlerts{
+		not:          *notifications.New(config.Get().MiscURL),
+		forecast:     forecast.NewForecastService(),
+		stockAlertDb: stockalert.NewStockAlert(),
+	}
+}
+
+type ILowStockAlerts interface {
+	SendLowStockAlerts(ctx *context.Context) error
+}
+
+// SendLowStockAlerts emails the owners of every airline and port whose projected days of cover
+// fell below the threshold set for it. Owners are alerted at most once per realert_hours.
+func (t *LowStockAlerts) SendLowStockAlerts(ctx *context.Context) error {
+
+	weeks, err := strconv.Atoi(GetJobParam(ctx, "weeks"))
+	if err != nil || weeks <= 0 {
+		weeks = forecast.DefaultForecastWeeks
+	}
+
+	realertHours, err := strconv.Atoi(GetJobParam(ctx, "realert_hours"))
+	if err != nil || realertHours <= 0 {
+		realertHours = 24
+	}
+
+	tmpl, err := template.New("LowStockMail").Parse(lowStockTemplate)
+	if err != nil {
+		ctx.Log.Error("unable to parse low stock template", zap.Error(err))
+		return err
+	}
+
+	settings, err := t.stockAlertDb.GetAll(ctx, "")
+	if err != nil {
+		return err
+	}
+
+	if len(settings) == 0 {
+		return nil
+	}
+
+	forecasts, err := t.forecast.GetForecasts(ctx, "", weeks)
+	if err != nil {
+		return err
+	}
+
+	forecastByKey := map[string]*models.StockForecast{}
+	for _, f := range forecasts {
+		forecastByKey[f.RegionId+"|"+f.Liner+"|"+f.Port] = f
+	}
+
+	now := time.Now().UTC()
+	for _, setting := range settings {
+
+		f := forecastByKey[setting.RegionId+"|"+setting.Liner+"|"+setting.Port]
+		if f == nil || len(setting.OwnerEmails) == 0 {
+			continue
+		}
+
+		// Without recent usage there is no rate to project, unless the stock is already gone
+		low := f.Available == 0 || (f.DaysOfCover != nil && *f.DaysOfCover < setting.MinDaysCover)
+		if !low {
+			continue
+		}
+
+		notAlertedSince := now.Add(-time.Duration(realertHours) * time.Hour)
+		if setting.LastAlertedAt != nil && !setting.LastAlertedAt.Before(notAlertedSince) {
+			continue
+		}
+
+		detail := &lowStockMailDetail{
+			Liner:        setting.Liner,
+			Port:         setting.Port,
+			Available:    f.Available,
+			UsedInWindow: f.UsedInWindow,
+			Weeks:        weeks,
+			DaysOfCover:  "0",
+			MinDaysCover: setting.MinDaysCover,
+		}
+		if f.DaysOfCover != nil {
+			detail.DaysOfCover = strconv.FormatFloat(*f.DaysOfCover, 'f', 1, 64)
+		}
+
+		if IsDryRun(ctx) {
+			logDryRun(ctx, "send low stock alert", zap.String("liner", setting.Liner), zap.String("port", setting.Port), zap.String("region_id", setting.RegionId),
+				zap.Int64("available", f.Available), zap.String("days_of_cover", detail.DaysOfCover), zap.Strings("receivers", setting.OwnerEmails))
+			continue
+		}
+
+		buf := new(bytes.Buffer)
+		if err := tmpl.Execute(buf, detail); err != nil {
+			ctx.Log.Error("unable to execute low stock template", zap.String("liner", setting.Liner), zap.Error(err))
+			continue
+		}
+
+		err = t.not.SendNotification(ctx, &dtos.Notification{
+			ID:              uuid.New().String(),
+			Type:            constants.NotTypeEmail,
+			Title:           "Low air waybill stock for " + setting.Liner + " at " + setting.Port,
+			Sender:          config.Get().EmailSenderBot,
+			IsTransactional: true,
+			Content:         buf.String(),
+			Receivers:       setting.OwnerEmails,
+		})
+		if err != nil {
+			// Left unmarked, the alert is sent again on the next run
+			ctx.Log.Error("unable to send low stock alert", zap.String("liner", setting.Liner), zap.String("port", setting.Port), zap.Error(err))
+			continue
+		}
+
+		if _, err := t.stockAlertDb.MarkAlerted(ctx, setting.Id, now, notAlertedSince); err != nil {
+			continue
+		}
+
+		ctx.Log.Info("low stock alert sent", zap.String("liner", setting.Liner), zap.String("port", setting.Port), zap.String("days_of_cover", detail.DaysOfCover))
+	}
+
+	return nil
+}
@@ -0,0 +1,50 @@
+package cronjobs
+
+import (
+	"time"
+
+	"bitbucket.org/radarventures/forwarder-adapters/utils/context"
+	"bitbucket.org/radarventures/forwarder-shipments/daos/stock"
+	"bitbucket.org/radarventures/forwarder-shipments/database/models"
+	"bitbucket.org/radarventures/forwarder-shipments/services/stock/allocation"
+	"go.uber.org/zap"
+)
+
+// ReleaseExpiredStockReservations returns the stock numbers whose reservation ran out before
+// the booking was confirmed to the available stock.
+func ReleaseExpiredStockReservations(ctx *context.Context) error {
+
+	reservations, err := stock.NewStock().GetExpiredReservations(ctx, time.Now().UTC())
+	if err != nil {
+		return err
+	}
+
+	if len(reservations) == 0 {
+		return nil
+	}
+
+	if IsDryRun(ctx) {
+		for _, reservation := range reservations {
+			logDryRun(ctx, "release expired stock reservation", zap.String("stock_no", reservation.StockNo), zap.Any("shipment_id", reservation.ShipmentId), zap.Timep("reserved_until", reservation.ReservedUntil))
+		}
+		return nil
+	}
+
+	allocationService := allocation.NewAllocationService()
+	RunPool(ctx, "releaseExpiredStockReservations", reservations, func(r *models.StockReservation) string {
+		return r.StockNo
+	}, func(ctx *context.Context, reservation *models.StockReservation) error {
+		released, err := allocationService.ReleaseExpired(ctx, reservation)
+		if err != nil {
+			return err
+		}
+		if !released {
+			return ErrSkipItem
+		}
+
+		ctx.Log.Info("expired stock reservation released", zap.String("stock_no", reservation.StockNo), zap.Any("shipment_id", reservation.ShipmentId))
+		return nil
+	})
+
+	return nil
+}
@@ -0,0 +1,93 @@
+package cronjobs
+
+import (
+	"fmt"
+	"strings"
+
+	"bitbucket.org/radarventures/forwarder-adapters/utils/context"
+	"bitbucket.org/radarventures/forwarder-shipments/daos/tenant"
+	"go.uber.org/zap"
+)
+
+// publicTenantTable is a table whose rows lived in the public schema before its DAO was
+// scoped to the tenant. filter picks the rows of the tenant, %[1]s is the tenant's schema
+// and %[2]s excludes the rows other tenants claim as well.
+type publicTenantTable struct {
+	Name   string
+	Filter string
+}
+
+// publicTenantTables are copied in order, a filter may rely on the tables copied before it.
+// Stock belongs to the tenant whose shipments use its region, AWBs to the tenant of their
+// shipment.
+var publicTenantTables = []publicTenantTable{
+	{Name: "air_stocks", Filter: "p.region_id::text IN (SELECT region_id::text FROM %[1]s.shipments)%[2]s"},
+	{Name: "air_stock_alert_settings", Filter: "p.region_id::text IN (SELECT region_id::text FROM %[1]s.shipments)%[2]s"},
+	{Name: "air_stock_histories", Filter: "p.stock_id IN (SELECT id FROM %[1]s.air_stocks)"},
+	{Name: "awb_master", Filter: "p.shipment_id IN (SELECT id FROM %[1]s.shipments)"},
+	{Name: "awb_house", Filter: "p.shipment_id IN (SELECT id FROM %[1]s.shipments)"},
+	{Name: "awb_info", Filter: "p.id IN (SELECT awb_info_id FROM %[1]s.awb_master UNION SELECT awb_info_id FROM %[1]s.awb_house)"},
+}
+
+// MigratePublicTenantData copies the tenant's stock and AWB rows from the public schema into
+// the tenant's schema. Rows whose region is used by the shipments of several tenants are
+// left in public and have to be assigned by hand. Public rows are kept, the job can run
+// again and only copies what is missing. Once it ran for every tenant, cronjobs stop running
+// for public.
+func MigratePublicTenantData(ctx *context.Context) error {
+
+	schema, err := tenant.Schema(ctx)
+	if err != nil {
+		return err
+	}
+	if schema == "public" {
+		return nil
+	}
+
+	tenantDb := tenant.NewTenant()
+	schemas, err := tenantDb.GetSchemas(ctx)
+	if err != nil {
+		return err
+	}
+
+	// A region used by another tenant's shipments is not this tenant's alone
+	var others strings.Builder
+	for _, other := range schemas {
+		if other == schema || other == "public" {
+			continue
+		}
+		fmt.Fprintf(&others, " AND NOT EXISTS (SELECT 1 FROM %s.shipments o WHERE o.region_id::text = p.region_id::text)", other)
+	}
+
+	for _, table := range publicTenantTables {
+		filter := fmt.Sprintf(table.Filter, schema, others.String())
+
+		if IsDryRun(ctx) {
+			count, err := tenantDb.CountPublicRows(ctx, schema, table.Name, filter)
+			if err != nil {
+				// The tenant table may not exist yet, it is created on the real run
+				ctx.Log.Warn("unable to count public rows", zap.String("table", table.Name), zap.Error(err))
+				continue
+			}
+			logDryRun(ctx, "copy public rows into the tenant", zap.String("table", table.Name), zap.Int64("rows", count))
+			continue
+		}
+
+		if err := tenantDb.EnsureTable(ctx, schema, table.Name); err != nil {
+			return err
+		}
+
+		copied, err := tenantDb.CopyPublicRows(ctx, schema, table.Name, filter)
+		if err != nil {
+			return err
+		}
+
+		ctx.Log.Info("public rows copied into the tenant", zap.String("table", table.Name), zap.Int64("rows", copied))
+	}
+
+	if IsDryRun(ctx) {
+		return nil
+	}
+
+	return tenantDb.MarkPublicDataMigrated(ctx, schema)
+}
@@ -1,8 +1,7 @@
 package cronjobs
 
 import (
-	"fmt"
-	"strings"
+	"strconv"
 	"time"
 
 	"bitbucket.org/radarventures/forwarder-adapters/apis/id"
@@ -11,13 +10,14 @@ import (
 	"bitbucket.org/radarventures/forwarder-adapters/utils/context"
 	"bitbucket.org/radarventures/forwarder-shipments/config"
 	"bitbucket.org/radarventures/forwarder-shipments/config/globals"
-	"bitbucket.org/radarventures/forwarder-shipments/constants"
 	cards "bitbucket.org/radarventures/forwarder-shipments/daos/card"
 	"bitbucket.org/radarventures/forwarder-shipments/daos/lineitem"
 	"bitbucket.org/radarventures/forwarder-shipments/daos/rfq"
 	"bitbucket.org/radarventures/forwarder-shipments/daos/shipment"
 	"bitbucket.org/radarventures/forwarder-shipments/daos/shipmentlock"
+	"bitbucket.org/radarventures/forwarder-shipments/daos/unlockrequest"
 	cardsAssignment "bitbucket.org/radarventures/forwarder-shipments/services/card-assignment"
+	"bitbucket.org/radarventures/forwarder-shipments/services/lockpolicy"
 	shipments "bitbucket.org/radarventures/forwarder-shipments/services/shipment"
 	"github.com/google/uuid"
 
@@ -41,24 +41,28 @@ type UpdateShipmentLock struct {
 	card       card.ICardService
 	cards      cardsAssignment.ICardAssignmentService
 
-	shipment     shipments.IShipmentService
-	shipmentlock shipmentlock.IShipmentLock
+	shipment        shipments.IShipmentService
+	shipmentlock    shipmentlock.IShipmentLock
+	lockPolicy      lockpolicy.ILockPolicyService
+	unlockRequestDb unlockrequest.IUnlockRequest
 }
 
 func NewUpdateShipmentLock() IShipmentLock {
 	return &UpdateShipmentLock{
-		id:           *id.New(config.Get().IdURL),
-		cardDb:       cards.NewCard(),
-		shipmentDb:   shipment.NewShipment(),
-		rfqDb:        rfq.NewRfq(),
-		misc:         *misc.New(config.Get().MiscURL),
-		rfqSer:       rfqSer.NewRfqService(),
-		workflow:     workflow.New(config.Get().WorkflowURL),
-		lineitem:     lineitem.NewLineItem(),
-		card:         card.NewCardService(),
-		shipment:     shipments.NewShipmentService(),
-		shipmentlock: shipmentlock.NewShipmentLock(),
-		cards:        cardsAssignment.NewCardAssignmentService(),
+		id:              *id.New(config.Get().IdURL),
+		cardDb:          cards.NewCard(),
+		shipmentDb:      shipment.NewShipment(),
+		rfqDb:           rfq.NewRfq(),
+		misc:            *misc.New(config.Get().MiscURL),
+		rfqSer:          rfqSer.NewRfqService(),
+		workflow:        workflow.New(config.Get().WorkflowURL),
+		lineitem:        lineitem.NewLineItem(),
+		card:            card.NewCardService(),
+		shipment:        shipments.NewShipmentService(),
+		shipmentlock:    shipmentlock.NewShipmentLock(),
+		cards:           cardsAssignment.NewCardAssignmentService(),
+		lockPolicy:      lockpolicy.NewLockPolicyService(),
+		unlockRequestDb: unlockrequest.NewUnlockRequest(),
 	}
 }
 
@@ -77,125 +81,119 @@ func (c *UpdateShipmentLock) UpdateShipmentlockStatus(ctx *context.Context) {
 		return
 	}
 
-	for _, shipment := range shipments {
-		if shipment.Type == globals.BookingTypeCONSOL {
-			continue
-		}
+	// Shipments unlocked by an approved request stay unlocked until the request expires, the
+	// relock job locks them again then
+	requestUnlocked, err := c.unlockRequestDb.GetActiveShipmentIds(ctx, time.Now().UTC())
+	if err != nil {
+		ctx.Log.Error("error while fetching shipments unlocked by request", zap.Error(err))
+		return
+	}
 
-		ctx.Log.Info("shipment is", zap.Any("shipment id", shipment.Id))
-		milestones, err := c.workflow.GetMilestones(ctx, shipment.Id.String())
-		if err != nil {
-			ctx.Log.Error("unable to get the milestones", zap.Error(err))
-			ctx.Log.Info("EF-1354 milestoneDate", zap.Any("shipment", shipment.Id))
-			continue
-		}
+	skip := map[uuid.UUID]bool{}
+	for _, id := range requestUnlocked {
+		skip[id] = true
+	}
 
-		lineItems, err := c.lineitem.GetLineItemsWithFilter(ctx, &models.LiFields{
-			QuoteId: shipment.QuoteId,
-		})
-		if err != nil {
-			ctx.Log.Error("error getting line items", zap.Error(err))
-			continue
+	RunPool(ctx, "update-shipment-lock", shipments, func(shipment *models.Shipment) string {
+		return shipment.Id.String()
+	}, func(ctx *context.Context, shipment *models.Shipment) error {
+		if skip[shipment.Id] {
+			return ErrSkipItem
 		}
 
-		milestoneCheckForLockShipment := false
-		milestoneDate := ""
-		foundBookingConfirmed := false
-
-		if milestones != nil && milestones.Milestones != nil {
-			for _, milestone := range milestones.Milestones {
-				milestoneName := strings.ToLower(milestone.Name)
-				normalizedMilestoneName := strings.ToLower(strings.TrimSpace(milestoneName))
-
-				if strings.Contains(normalizedMilestoneName, "booking confirm") {
-					if milestone.Status == globals.StatusCompleted && milestone.CompletedAt != 0 {
-						foundBookingConfirmed = true
-						milestoneDate = time.Unix(milestone.CompletedAt, 0).String()
-					}
-				}
-			}
+		return c.lockShipment(ctx, shipment)
+	})
 
-			if !foundBookingConfirmed {
-				for _, milestone := range milestones.Milestones {
-					if milestone.Name == constants.MilestoneBookingCreated && milestone.Status == globals.StatusCompleted && milestone.CompletedAt != 0 {
-						// Handle timestamp precision (milliseconds or seconds)
-						if milestone.CompletedAt > 9999999999 {
-							milestoneDate = time.Unix(milestone.CompletedAt/1000, 0).String() // Assuming milliseconds
-						} else {
-							milestoneDate = time.Unix(milestone.CompletedAt, 0).String() // Assuming seconds
-						}
-						break
-					}
-				}
-			}
-			ctx.Log.Info("milestone date", zap.Any("milestonedate", milestoneDate), zap.Any("shipment_id", shipment.Id))
-
-			if milestoneDate != "" {
-				parsedTime, err := parseMilestoneDate(milestoneDate)
-				if err != nil {
-					ctx.Log.Error("Error parsing milestoneDate: ", zap.Error(err))
-					continue
-				}
-
-				if time.Since(parsedTime).Hours()/24 > 60 && (shipment.Type == globals.BookingTypeFCL || shipment.Type == globals.BookingTypeLCL) {
-					milestoneCheckForLockShipment = true
-				} else if time.Since(parsedTime).Hours()/24 > 30 && (shipment.Type == globals.BookingTypeAIR || shipment.Type == constants.ShipmentTypeMisc) {
-					milestoneCheckForLockShipment = true
-				}
-				ctx.Log.Info("parsed time", zap.Any("parsed time", time.Since(parsedTime).Hours()), zap.Any("shipment_id", shipment.Id))
-			}
+	ctx.Log.Info("migration finished for updating shipment lock status")
+}
+
+// lockShipment locks a shipment once a rule of its region's lock policies fires, and records
+// which one did.
+func (c *UpdateShipmentLock) lockShipment(ctx *context.Context, shipment *models.Shipment) error {
+	if shipment.Type == globals.BookingTypeCONSOL {
+		return ErrSkipItem
+	}
 
+	evaluation, err := c.lockPolicy.Evaluate(ctx, shipment)
+	if err != nil {
+		return err
+	}
+
+	if evaluation.WouldLock {
+		ctx.Log.Info("lock policy fired", zap.Any("shipment_id", shipment.Id), zap.String("policy", evaluation.Policy.PolicyName), zap.String("rule", evaluation.Rule), zap.String("reason", evaluation.Reason))
+		if IsDryRun(ctx) {
+			logDryRun(ctx, "lock shipment and delete its cards", zap.Any("shipment_id", shipment.Id), zap.String("policy", evaluation.Policy.PolicyName), zap.String("rule", evaluation.Rule))
+			return nil
 		}
-		lineItemscheckforLockShipment := true
-		for i := range lineItems {
-			if (lineItems[i].IsSellInvoiceGenerated == nil || !*lineItems[i].IsSellInvoiceGenerated) ||
-				(lineItems[i].IsBuyApproved == nil || !*lineItems[i].IsBuyApproved) {
-				lineItemscheckforLockShipment = false
-				break
-			}
+
+		shipment.IsShipmentLocked = true
+		err = c.shipmentDb.UpdateShipmentLock(ctx, shipment.Id.String(), shipment.IsShipmentLocked)
+		if err != nil {
+			ctx.Log.Error("error while updating shipment", zap.Any("shipment_id", shipment.Id), zap.Error(err))
+			return err
 		}
-		ctx.Log.Info("entered outside", zap.Any("milestoneCheckForLockShipment", milestoneCheckForLockShipment), zap.Any("shipment id", shipment.Id))
-		if milestoneCheckForLockShipment || lineItemscheckforLockShipment {
-			ctx.Log.Info("entered milestoneCheckForLockShipment", zap.Any("milestoneCheckForLockShipment", milestoneCheckForLockShipment))
-			shipment.IsShipmentLocked = true
-			err = c.shipmentDb.UpdateShipmentLock(ctx, shipment.Id.String(), shipment.IsShipmentLocked)
-			if err != nil {
-				ctx.Log.Error("error while updating shipment", zap.Any("shipment_id", shipment.Id), zap.Error(err))
-				continue
-			}
 
-			c.cards.DeleteAllcards(ctx, &dtos.CardRequest{
-				InstanceId:  shipment.Id.String(),
-				ExecutiveId: config.Get().WizBotID,
-			},
-			)
-			shipmentLock := &models.ShipmentLock{
-				Id:         uuid.New(),
-				ShipmentId: shipment.Id,
-				UpdatedBy:  config.Get().WizBotID,
-				IsLocked:   true,
-			}
+		c.cards.DeleteAllcards(ctx, &dtos.CardRequest{
+			InstanceId:  shipment.Id.String(),
+			ExecutiveId: config.Get().WizBotID,
+		},
+		)
+		shipmentLock := &models.ShipmentLock{
+			Id:         uuid.New(),
+			ShipmentId: shipment.Id,
+			UpdatedBy:  config.Get().WizBotID,
+			IsLocked:   true,
+		}
 
-			err = c.shipment.UpdateShipmentLockStatusTimeline(ctx, shipmentLock, true, config.Get().WizBotID, "", false, shipment.Type, shipment.RegionId.String())
-			if err != nil {
-				ctx.Log.Error("error while persisting audit", zap.Error(err))
-				continue
-			}
-			ctx.Log.Info("completed lock shipment", zap.Any("shipment is", shipment.Id), zap.Any("shipmentLock", shipmentLock))
+		err = c.shipment.UpdateShipmentLockStatusTimeline(ctx, shipmentLock, true, config.Get().WizBotID, "", false, shipment.Type, shipment.RegionId.String())
+		if err != nil {
+			ctx.Log.Error("error while persisting audit", zap.Error(err))
+			return err
+		}
+
+		err = c.lockPolicy.RecordDecision(ctx, evaluation)
+		if err != nil {
+			ctx.Log.Error("error while recording lock decision", zap.Any("shipment_id", shipment.Id), zap.Error(err))
+			return err
 		}
+		ctx.Log.Info("completed lock shipment", zap.Any("shipment is", shipment.Id), zap.Any("shipmentLock", shipmentLock))
+		return nil
 	}
-	ctx.Log.Info("migration finished for updating shipment lock status")
+
+	return ErrSkipItem
 }
 
 func (c *UpdateShipmentLock) UpdateShipmentlockStatusV2(ctx *context.Context) {
 
+	idleMinutes, err := strconv.Atoi(GetJobParam(ctx, "idle_minutes"))
+	if err != nil {
+		ctx.Log.Error("invalid idle_minutes parameter", zap.Error(err))
+		return
+	}
+
 	allShipmentLock, err := c.shipmentlock.GetAll(ctx)
 	if err != nil {
 		ctx.Log.Error("error while fetching unlocked shipments", zap.Error(err))
 		return
 	}
 
+	// Shipments unlocked by an approved request stay unlocked until the request expires
+	requestUnlocked, err := c.unlockRequestDb.GetActiveShipmentIds(ctx, time.Now().UTC())
+	if err != nil {
+		ctx.Log.Error("error while fetching shipments unlocked by request", zap.Error(err))
+		return
+	}
+
+	skip := map[uuid.UUID]bool{}
+	for _, id := range requestUnlocked {
+		skip[id] = true
+	}
+
 	for _, shipmentlock := range allShipmentLock {
+		if skip[shipmentlock.ShipmentId] {
+			continue
+		}
+
 		ctx.Log.Info("shipmentlockV2", zap.Any("shipmentlock.ShipmentId", shipmentlock.ShipmentId.String()))
 		shipment, err := c.shipmentDb.Get(ctx, shipmentlock.ShipmentId.String())
 		if err != nil {
@@ -207,7 +205,12 @@ func (c *UpdateShipmentLock) UpdateShipmentlockStatusV2(ctx *context.Context) {
 			continue
 		}
 
-		if time.Since(shipment.UpdatedAt) > 90*time.Minute {
+		if time.Since(shipment.UpdatedAt) > time.Duration(idleMinutes)*time.Minute {
+			if IsDryRun(ctx) {
+				logDryRun(ctx, "lock idle shipment", zap.Any("shipment_id", shipment.Id), zap.Time("updated_at", shipment.UpdatedAt))
+				continue
+			}
+
 			shipment.IsShipmentLocked = true
 
 			err = c.shipmentDb.UpdateShipmentLock(ctx, shipment.Id.String(), shipment.IsShipmentLocked)
@@ -235,21 +238,3 @@ func (c *UpdateShipmentLock) UpdateShipmentlockStatusV2(ctx *context.Context) {
 
 	ctx.Log.Info("migration finished for updating shipment status V2")
 }
-
-func parseMilestoneDate(milestoneDate string) (time.Time, error) {
-	layouts := []string{
-		"2006-01-02",                    // Date format
-		"2006-01-02 15:04:05 -0700 MST", // Full timestamp with timezone
-	}
-
-	var parsedTime time.Time
-	var err error
-	for _, layout := range layouts {
-		parsedTime, err = time.Parse(layout, milestoneDate)
-		if err == nil {
-			return parsedTime, nil
-		}
-	}
-
-	return time.Time{}, fmt.Errorf("unable to parse date: %s", milestoneDate)
-}
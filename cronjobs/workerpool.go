@@ -0,0 +1,185 @@
+package cronjobs
+
+import (
+	stdcontext "context"
+	"errors"
+	"fmt"
+	"net/http/httptest"
+	"os"
+	"os/signal"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	"bitbucket.org/radarventures/forwarder-adapters/utils/context"
+	ulog "bitbucket.org/radarventures/forwarder-adapters/utils/log"
+	"bitbucket.org/radarventures/forwarder-shipments/config"
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+)
+
+// ErrSkipItem is returned by a pool item that had nothing to do. It is counted as skipped
+// rather than failed.
+var ErrSkipItem = errors.New("item skipped")
+
+var ErrItemTimeout = errors.New("item timed out")
+
+const (
+	DefaultPoolParallelism = 8
+	DefaultPoolItemTimeout = 2 * time.Minute
+)
+
+var poolParallelism = DefaultPoolParallelism
+var poolItemTimeout = DefaultPoolItemTimeout
+
+// SetWorkerPoolOptions overrides the parallelism and per-item timeout of every cronjob pool.
+// Non-positive values keep the defaults.
+func SetWorkerPoolOptions(parallelism int, itemTimeout time.Duration) {
+	if parallelism > 0 {
+		poolParallelism = parallelism
+	}
+	if itemTimeout > 0 {
+		poolItemTimeout = itemTimeout
+	}
+}
+
+type PoolSummary struct {
+	Job       string        `json:"job"`
+	Total     int64         `json:"total"`
+	Succeeded int64         `json:"succeeded"`
+	Failed    int64         `json:"failed"`
+	Skipped   int64         `json:"skipped"`
+	Cancelled bool          `json:"cancelled"`
+	Duration  time.Duration `json:"duration"`
+}
+
+// RunPool calls fn for every item with at most the configured number of items in flight.
+// Each item gets its own context with a fresh logger, DB session and a request that is
+// cancelled on the item timeout. When the process receives SIGINT or SIGTERM no new items
+// are started and the ones not yet started are counted as skipped.
+//
+// An item that outlives its timeout is counted as failed and its worker moves on to the next
+// item right away. The item's DB session and request are cancelled with the timeout, fn is
+// left to return on its own and must honour ctx for that to happen promptly.
+func RunPool[T any](ctx *context.Context, job string, items []T, key func(T) string, fn func(ctx *context.Context, item T) error) *PoolSummary {
+
+	summary := &PoolSummary{
+		Job:   job,
+		Total: int64(len(items)),
+	}
+	start := time.Now()
+
+	root, stop := signal.NotifyContext(ctx.Request.Context(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	ctx.Log.Info("pool started", zap.String("job", job), zap.Int("items", len(items)), zap.Int("parallelism", poolParallelism), zap.Duration("item_timeout", poolItemTimeout))
+
+	queue := make(chan T)
+	wg := sync.WaitGroup{}
+
+	for w := 0; w < poolParallelism; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for item := range queue {
+				err := runPoolItem(root, ctx, job, key(item), item, fn)
+				switch {
+				case err == nil:
+					atomic.AddInt64(&summary.Succeeded, 1)
+				case errors.Is(err, ErrSkipItem):
+					atomic.AddInt64(&summary.Skipped, 1)
+				default:
+					atomic.AddInt64(&summary.Failed, 1)
+				}
+			}
+		}()
+	}
+
+	dispatched := 0
+dispatch:
+	for _, item := range items {
+		select {
+		case <-root.Done():
+			summary.Cancelled = true
+			break dispatch
+		case queue <- item:
+			dispatched++
+		}
+	}
+	close(queue)
+	wg.Wait()
+
+	summary.Skipped += int64(len(items) - dispatched)
+	summary.Duration = time.Since(start)
+	reportPoolSummary(ctx, summary)
+
+	ctx.Log.Info("pool completed", zap.String("job", job), zap.Int64("total", summary.Total), zap.Int64("succeeded", summary.Succeeded),
+		zap.Int64("failed", summary.Failed), zap.Int64("skipped", summary.Skipped), zap.Bool("cancelled", summary.Cancelled), zap.Duration("duration", summary.Duration))
+
+	return summary
+}
+
+func runPoolItem[T any](root stdcontext.Context, parent *context.Context, job, itemKey string, item T, fn func(ctx *context.Context, item T) error) error {
+
+	reqCtx, cancel := stdcontext.WithTimeout(root, poolItemTimeout)
+	defer cancel()
+
+	itemCtx := newItemContext(parent, reqCtx, job)
+	itemCtx.Log.Info("pool item started", zap.String("job", job), zap.String("item", itemKey), zap.String("run_ref_id", parent.RefID))
+
+	done := make(chan error, 1)
+	go func() {
+		defer func() {
+			if r := recover(); r != nil {
+				done <- fmt.Errorf("panic: %v", r)
+			}
+		}()
+		done <- fn(itemCtx, item)
+	}()
+
+	var err error
+	select {
+	case err = <-done:
+	case <-reqCtx.Done():
+		// done is buffered, so fn can still return once it notices the cancellation
+		itemCtx.Log.Warn("pool item timed out", zap.String("job", job), zap.String("item", itemKey))
+
+		err = ErrItemTimeout
+		if root.Err() != nil {
+			err = root.Err()
+		}
+	}
+
+	if err != nil && !errors.Is(err, ErrSkipItem) {
+		itemCtx.Log.Error("pool item failed", zap.String("job", job), zap.String("item", itemKey), zap.Error(err))
+	}
+
+	return err
+}
+
+// newItemContext derives the context of a single pool item from the run context.
+func newItemContext(parent *context.Context, reqCtx stdcontext.Context, job string) *context.Context {
+	c := &context.Context{}
+
+	c.RefID = uuid.New().String()
+
+	cfg := config.Get()
+	c.Log = ulog.New(c.RefID, cfg.AppName, cfg.LogLevel)
+	// DB calls of the item are cancelled with it, also those that do not pass the request on
+	c.DB = parent.DB.Session(&gorm.Session{NewDB: true, Context: reqCtx})
+	c.TenantID = parent.TenantID
+	c.Account = parent.Account
+
+	// To mock request context of gin. The request context is used in DAO layer
+	c.Context, _ = gin.CreateTestContext(httptest.NewRecorder())
+	c.Context.Request = httptest.NewRequest("GET", "/"+job, nil).WithContext(reqCtx)
+	c.Set(dryRunKey, IsDryRun(parent))
+	if params, ok := parent.Get(jobParamsKey); ok {
+		c.Set(jobParamsKey, params)
+	}
+
+	return c
+}
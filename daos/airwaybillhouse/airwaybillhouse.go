@@ -4,6 +4,7 @@ import (
 	"time"
 
 	"bitbucket.org/radarventures/forwarder-adapters/utils/context"
+	"bitbucket.org/radarventures/forwarder-shipments/daos/tenant"
 	"bitbucket.org/radarventures/forwarder-shipments/database/models"
 	"github.com/google/uuid"
 	"gorm.io/gorm/clause"
@@ -22,13 +23,17 @@ func NewAirwayBillHouse() IAirwayBillHouse {
 	return &AirwayBillHouse{}
 }
 
-func (t *AirwayBillHouse) getTable(ctx *context.Context) string {
-	ctx.TenantID = "public"
-	return ctx.TenantID + "." + "awb_house"
+func (t *AirwayBillHouse) getTable(ctx *context.Context) (string, error) {
+	return tenant.Table(ctx, "awb_house")
 }
 
 func (t *AirwayBillHouse) Upsert(ctx *context.Context, awbHouse *models.AirwayBillHouse, by uuid.UUID) (*models.AirwayBillHouse, error) {
 
+	table, err := t.getTable(ctx)
+	if err != nil {
+		return nil, err
+	}
+
 	currentTime := time.Now().UTC()
 	if awbHouse.Id == uuid.Nil {
 		awbHouse.Id = uuid.New()
@@ -39,7 +44,7 @@ func (t *AirwayBillHouse) Upsert(ctx *context.Context, awbHouse *models.AirwayBi
 	awbHouse.UpdatedAt = currentTime
 	awbHouse.UpdatedBy = by
 
-	err := ctx.DB.WithContext(ctx.Request.Context()).Table(t.getTable(ctx)).Save(&awbHouse).Clauses(clause.OnConflict{DoNothing: true}).Error
+	err = ctx.DB.WithContext(ctx.Request.Context()).Table(table).Save(&awbHouse).Clauses(clause.OnConflict{DoNothing: true}).Error
 	if err != nil {
 		return nil, err
 	}
@@ -48,9 +53,14 @@ func (t *AirwayBillHouse) Upsert(ctx *context.Context, awbHouse *models.AirwayBi
 }
 
 func (t *AirwayBillHouse) Get(ctx *context.Context, id uuid.UUID, shipmentId uuid.UUID, awbInfoId uuid.UUID, query string) (*models.AirwayBillHouse, error) {
+	table, err := t.getTable(ctx)
+	if err != nil {
+		return nil, err
+	}
+
 	awbHouse := &models.AirwayBillHouse{}
 
-	tx := ctx.DB.WithContext(ctx.Request.Context()).Table(t.getTable(ctx))
+	tx := ctx.DB.WithContext(ctx.Request.Context()).Table(table)
 	if id != uuid.Nil {
 		tx.Where("id = ?", id)
 	}
@@ -63,7 +73,7 @@ func (t *AirwayBillHouse) Get(ctx *context.Context, id uuid.UUID, shipmentId uui
 	if query != "" {
 		tx.Where(query)
 	}
-	err := tx.First(&awbHouse).Error
+	err = tx.First(&awbHouse).Error
 	if err != nil {
 		return nil, err
 	}
@@ -72,9 +82,14 @@ func (t *AirwayBillHouse) Get(ctx *context.Context, id uuid.UUID, shipmentId uui
 }
 
 func (t *AirwayBillHouse) GetAll(ctx *context.Context, shipmentId uuid.UUID, query string) ([]*models.AirwayBillHouse, error) {
+	table, err := t.getTable(ctx)
+	if err != nil {
+		return nil, err
+	}
+
 	awbHouses := []*models.AirwayBillHouse{}
 
-	tx := ctx.DB.WithContext(ctx.Request.Context()).Table(t.getTable(ctx))
+	tx := ctx.DB.WithContext(ctx.Request.Context()).Table(table)
 	if shipmentId != uuid.Nil {
 		tx.Where("shipment_id = ?", shipmentId)
 	}
@@ -83,7 +98,7 @@ func (t *AirwayBillHouse) GetAll(ctx *context.Context, shipmentId uuid.UUID, que
 	}
 
 	tx.Order("created_at")
-	err := tx.Find(&awbHouses).Error
+	err = tx.Find(&awbHouses).Error
 	if err != nil {
 		return nil, err
 	}
@@ -5,6 +5,9 @@ import (
 
 	"bitbucket.org/radarventures/forwarder-adapters/utils/context"
 	"bitbucket.org/radarventures/forwarder-shipments/config/globals"
+	"bitbucket.org/radarventures/forwarder-shipments/constants"
+	"bitbucket.org/radarventures/forwarder-shipments/daos/documentcounter"
+	"bitbucket.org/radarventures/forwarder-shipments/daos/tenant"
 	"bitbucket.org/radarventures/forwarder-shipments/database/models"
 	"github.com/google/uuid"
 	"go.uber.org/zap"
@@ -13,9 +16,10 @@ import (
 
 type IAirwayBillInfo interface {
 	Upsert(ctx *context.Context, awbInfo *models.AirwayBillInfo, by uuid.UUID) (*models.AirwayBillInfo, error)
+	UpsertWithTx(ctx *context.Context, tx *gorm.DB, awbInfo *models.AirwayBillInfo, by uuid.UUID) (*models.AirwayBillInfo, error)
 	GetAll(ctx *context.Context, shipmentId uuid.UUID, billType string, query string) ([]*models.AirwayBillInfo, error)
 	Get(ctx *context.Context, id uuid.UUID, query string) (*models.AirwayBillInfo, error)
-	GetForGenerateHAWBNumber(ctx *context.Context, portId string) (string, error)
+	NextHAWBNumberWithTx(ctx *context.Context, tx *gorm.DB, portId string) (int64, error)
 	GetMAWBByShipmentId(ctx *context.Context, sids []string) ([]*models.DSRAWB, error)
 	GetHAWBByShipmentId(ctx *context.Context, sids []string) ([]*models.DSRAWB, error)
 }
@@ -26,12 +30,22 @@ func NewAirwayBillInfo() IAirwayBillInfo {
 	return &AirwayBillInfo{}
 }
 
-func (t *AirwayBillInfo) getTable(ctx *context.Context) string {
-	ctx.TenantID = "public"
-	return ctx.TenantID + "." + "awb_info"
+func (t *AirwayBillInfo) getTable(ctx *context.Context) (string, error) {
+	return tenant.Table(ctx, "awb_info")
 }
 
 func (t *AirwayBillInfo) Upsert(ctx *context.Context, awbInfo *models.AirwayBillInfo, by uuid.UUID) (*models.AirwayBillInfo, error) {
+	return t.UpsertWithTx(ctx, ctx.DB.WithContext(ctx.Request.Context()), awbInfo, by)
+}
+
+// UpsertWithTx saves the AWB in tx, so a house bill is saved in the transaction its number
+// was issued in.
+func (t *AirwayBillInfo) UpsertWithTx(ctx *context.Context, tx *gorm.DB, awbInfo *models.AirwayBillInfo, by uuid.UUID) (*models.AirwayBillInfo, error) {
+
+	table, err := t.getTable(ctx)
+	if err != nil {
+		return nil, err
+	}
 
 	if awbInfo.Id == uuid.Nil {
 		awbInfo.Id = uuid.New()
@@ -42,7 +56,7 @@ func (t *AirwayBillInfo) Upsert(ctx *context.Context, awbInfo *models.AirwayBill
 	awbInfo.UpdatedAt = time.Now().UTC()
 	awbInfo.UpdatedBy = by
 
-	err := ctx.DB.WithContext(ctx.Request.Context()).Table(t.getTable(ctx)).Save(&awbInfo).Error
+	err = tx.Table(table).Save(&awbInfo).Error
 	if err != nil {
 		return nil, err
 	}
@@ -51,16 +65,21 @@ func (t *AirwayBillInfo) Upsert(ctx *context.Context, awbInfo *models.AirwayBill
 }
 
 func (t *AirwayBillInfo) Get(ctx *context.Context, id uuid.UUID, query string) (*models.AirwayBillInfo, error) {
+	table, err := t.getTable(ctx)
+	if err != nil {
+		return nil, err
+	}
+
 	awbInfo := &models.AirwayBillInfo{}
 
-	tx := ctx.DB.WithContext(ctx.Request.Context()).Table(t.getTable(ctx))
+	tx := ctx.DB.WithContext(ctx.Request.Context()).Table(table)
 	if id != uuid.Nil {
 		tx.Where("id = ?", id)
 	}
 	if query != "" {
 		tx.Where(query)
 	}
-	err := tx.First(&awbInfo).Error
+	err = tx.First(&awbInfo).Error
 	if err != nil {
 		return nil, err
 	}
@@ -69,9 +88,14 @@ func (t *AirwayBillInfo) Get(ctx *context.Context, id uuid.UUID, query string) (
 }
 
 func (t *AirwayBillInfo) GetAll(ctx *context.Context, shipmentId uuid.UUID, billType string, query string) ([]*models.AirwayBillInfo, error) {
+	table, err := t.getTable(ctx)
+	if err != nil {
+		return nil, err
+	}
+
 	awbsInfo := []*models.AirwayBillInfo{}
 
-	tx := ctx.DB.WithContext(ctx.Request.Context()).Table(t.getTable(ctx))
+	tx := ctx.DB.WithContext(ctx.Request.Context()).Table(table)
 	if shipmentId != uuid.Nil {
 		tx.Joins("awb_house ON awb_info.id = awb_house.awb_info_id AND awb_house.shipment_id = ?", shipmentId)
 	}
@@ -83,7 +107,7 @@ func (t *AirwayBillInfo) GetAll(ctx *context.Context, shipmentId uuid.UUID, bill
 	}
 
 	tx.Order("created_at")
-	err := tx.Find(&awbsInfo).Error
+	err = tx.Find(&awbsInfo).Error
 	if err != nil {
 		return nil, err
 	}
@@ -91,25 +115,36 @@ func (t *AirwayBillInfo) GetAll(ctx *context.Context, shipmentId uuid.UUID, bill
 	return awbsInfo, nil
 }
 
-func (t *AirwayBillInfo) GetForGenerateHAWBNumber(ctx *context.Context, portId string) (string, error) {
-
-	awbInfo := &models.AirwayBillInfo{}
-	tx := ctx.DB.WithContext(ctx.Request.Context()).Table(t.getTable(ctx))
-	tx.Where("type = ? AND issuer_port_code = ?", globals.HouseAirwayBill, portId)
-	tx.Order("created_at DESC, number DESC")
-	err := tx.First(&awbInfo).Error
-	if err != nil && err != gorm.ErrRecordNotFound {
-		return "", err
+// NextHAWBNumberWithTx issues the next house bill number of the port from the tenant's HAWB
+// counter. The counter starts after the highest number the port's house bills end in.
+func (t *AirwayBillInfo) NextHAWBNumberWithTx(ctx *context.Context, tx *gorm.DB, portId string) (int64, error) {
+	table, err := t.getTable(ctx)
+	if err != nil {
+		return 0, err
 	}
 
-	return awbInfo.Number, nil
+	key := &models.DocumentCounterKey{DocType: constants.DocTypeHAWB, Scope: portId}
+	return documentcounter.NewDocumentCounter().NextWithTx(ctx, tx, key, func(tx *gorm.DB) (int64, error) {
+		var last int64
+		err := tx.Table(table).
+			Select("COALESCE(MAX(substring(number from '([0-9]+)$')::bigint), 0)").
+			Where("type = ? AND issuer_port_code = ?", globals.HouseAirwayBill, portId).
+			Scan(&last).Error
+		return last, err
+	})
 }
 
 func (t *AirwayBillInfo) GetMAWBByShipmentId(ctx *context.Context, sids []string) ([]*models.DSRAWB, error) {
-	awb_info := t.getTable(ctx)
-	awb_master := ctx.TenantID + "." + "awb_master"
+	awb_info, err := t.getTable(ctx)
+	if err != nil {
+		return nil, err
+	}
+	awb_master, err := tenant.Table(ctx, "awb_master")
+	if err != nil {
+		return nil, err
+	}
 	mawb := []*models.DSRAWB{}
-	err := ctx.DB.WithContext(ctx.Request.Context()).Raw(`Select m.shipment_id,i.number from `+awb_info+` i INNER JOIN `+awb_master+` m on i.id=m.awb_info_id where m.shipment_id IN ?`, sids).Scan(&mawb).Error
+	err = ctx.DB.WithContext(ctx.Request.Context()).Raw(`Select m.shipment_id,i.number from `+awb_info+` i INNER JOIN `+awb_master+` m on i.id=m.awb_info_id where m.shipment_id IN ?`, sids).Scan(&mawb).Error
 
 	if err != nil {
 		ctx.Log.Error("Error in fetching mawb", zap.Error(err))
@@ -119,10 +154,16 @@ func (t *AirwayBillInfo) GetMAWBByShipmentId(ctx *context.Context, sids []string
 }
 
 func (t *AirwayBillInfo) GetHAWBByShipmentId(ctx *context.Context, sids []string) ([]*models.DSRAWB, error) {
-	awb_house := ctx.TenantID + "." + "awb_house"
-	awb_info := t.getTable(ctx)
+	awb_house, err := tenant.Table(ctx, "awb_house")
+	if err != nil {
+		return nil, err
+	}
+	awb_info, err := t.getTable(ctx)
+	if err != nil {
+		return nil, err
+	}
 	hawb := []*models.DSRAWB{}
-	err := ctx.DB.WithContext(ctx.Request.Context()).Raw(`Select h.shipment_id,i.number from `+awb_info+` i INNER JOIN `+awb_house+` h on i.id=h.awb_info_id where h.shipment_id IN ?`, sids).Scan(&hawb).Error
+	err = ctx.DB.WithContext(ctx.Request.Context()).Raw(`Select h.shipment_id,i.number from `+awb_info+` i INNER JOIN `+awb_house+` h on i.id=h.awb_info_id where h.shipment_id IN ?`, sids).Scan(&hawb).Error
 	if err != nil {
 		ctx.Log.Error("Error in fetching hawb", zap.Error(err))
 		return nil, err
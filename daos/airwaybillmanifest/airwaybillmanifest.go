@@ -4,6 +4,7 @@ import (
 	"time"
 
 	"bitbucket.org/radarventures/forwarder-adapters/utils/context"
+	"bitbucket.org/radarventures/forwarder-shipments/daos/tenant"
 	"bitbucket.org/radarventures/forwarder-shipments/database/models"
 	"github.com/google/uuid"
 )
@@ -21,15 +22,17 @@ func NewAirwayBillManifest() IAirwayBillManifest {
 	return &AirwayBillManifest{}
 }
 
-func (t *AirwayBillManifest) getTable(ctx *context.Context) string {
-	if ctx.TenantID == "" {
-		ctx.TenantID = "public"
-	}
-	return ctx.TenantID + "." + "awb_manifest"
+func (t *AirwayBillManifest) getTable(ctx *context.Context) (string, error) {
+	return tenant.Table(ctx, "awb_manifest")
 }
 
 func (t *AirwayBillManifest) Upsert(ctx *context.Context, awbManifest *models.AirwayBillManifest, by uuid.UUID) (*models.AirwayBillManifest, error) {
 
+	table, err := t.getTable(ctx)
+	if err != nil {
+		return nil, err
+	}
+
 	currentTime := time.Now().UTC()
 	if awbManifest.Id == uuid.Nil {
 		awbManifest.Id = uuid.New()
@@ -40,7 +43,7 @@ func (t *AirwayBillManifest) Upsert(ctx *context.Context, awbManifest *models.Ai
 	awbManifest.UpdatedAt = currentTime
 	awbManifest.UpdatedBy = by
 
-	err := ctx.DB.WithContext(ctx.Request.Context()).Table(t.getTable(ctx)).Save(&awbManifest).Error
+	err = ctx.DB.WithContext(ctx.Request.Context()).Table(table).Save(&awbManifest).Error
 	if err != nil {
 		return nil, err
 	}
@@ -49,9 +52,14 @@ func (t *AirwayBillManifest) Upsert(ctx *context.Context, awbManifest *models.Ai
 }
 
 func (t *AirwayBillManifest) Get(ctx *context.Context, id uuid.UUID, mawbId uuid.UUID, hawbId uuid.UUID, query string) (*models.AirwayBillManifest, error) {
+	table, err := t.getTable(ctx)
+	if err != nil {
+		return nil, err
+	}
+
 	awbManifest := &models.AirwayBillManifest{}
 
-	tx := ctx.DB.WithContext(ctx.Request.Context()).Table(t.getTable(ctx))
+	tx := ctx.DB.WithContext(ctx.Request.Context()).Table(table)
 	if id != uuid.Nil {
 		tx.Where("id = ?", id)
 	}
@@ -64,7 +72,7 @@ func (t *AirwayBillManifest) Get(ctx *context.Context, id uuid.UUID, mawbId uuid
 	if query != "" {
 		tx.Where(query)
 	}
-	err := tx.First(&awbManifest).Error
+	err = tx.First(&awbManifest).Error
 	if err != nil {
 		return nil, err
 	}
@@ -84,9 +92,14 @@ func (t *AirwayBillManifest) Get(ctx *context.Context, id uuid.UUID, mawbId uuid
 // }
 
 func (t *AirwayBillManifest) GetAll(ctx *context.Context, id uuid.UUID, mawbId uuid.UUID, hawbId uuid.UUID, query string) ([]*models.AirwayBillManifest, error) {
+	table, err := t.getTable(ctx)
+	if err != nil {
+		return nil, err
+	}
+
 	awbManifests := []*models.AirwayBillManifest{}
 
-	tx := ctx.DB.WithContext(ctx.Request.Context()).Table(t.getTable(ctx))
+	tx := ctx.DB.WithContext(ctx.Request.Context()).Table(table)
 	if id != uuid.Nil {
 		tx.Where("id = ?", id)
 	}
@@ -102,7 +115,7 @@ func (t *AirwayBillManifest) GetAll(ctx *context.Context, id uuid.UUID, mawbId u
 	}
 
 	tx.Order("created_at")
-	err := tx.Find(&awbManifests).Error
+	err = tx.Find(&awbManifests).Error
 	if err != nil {
 		return nil, err
 	}
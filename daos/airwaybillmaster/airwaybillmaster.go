@@ -4,6 +4,7 @@ import (
 	"time"
 
 	"bitbucket.org/radarventures/forwarder-adapters/utils/context"
+	"bitbucket.org/radarventures/forwarder-shipments/daos/tenant"
 	"bitbucket.org/radarventures/forwarder-shipments/database/models"
 	"github.com/google/uuid"
 	"go.uber.org/zap"
@@ -25,15 +26,17 @@ func NewAirwayBillMaster() IAirwayBillMaster {
 	return &AirwayBillMaster{}
 }
 
-func (t *AirwayBillMaster) getTable(ctx *context.Context) string {
-	if ctx.TenantID == "" {
-		ctx.TenantID = "public"
-	}
-	return ctx.TenantID + "." + "awb_master"
+func (t *AirwayBillMaster) getTable(ctx *context.Context) (string, error) {
+	return tenant.Table(ctx, "awb_master")
 }
 
 func (t *AirwayBillMaster) Upsert(ctx *context.Context, awbMaster *models.AirwayBillMaster, by uuid.UUID) (*models.AirwayBillMaster, error) {
 
+	table, err := t.getTable(ctx)
+	if err != nil {
+		return nil, err
+	}
+
 	if awbMaster.Id == uuid.Nil {
 		awbMaster.Id = uuid.New()
 		awbMaster.CreatedAt = time.Now().UTC()
@@ -43,7 +46,7 @@ func (t *AirwayBillMaster) Upsert(ctx *context.Context, awbMaster *models.Airway
 	awbMaster.UpdatedAt = time.Now().UTC()
 	awbMaster.UpdatedBy = by
 
-	err := ctx.DB.WithContext(ctx.Request.Context()).Table(t.getTable(ctx)).Save(&awbMaster).Clauses(clause.OnConflict{DoNothing: true}).Error
+	err = ctx.DB.WithContext(ctx.Request.Context()).Table(table).Save(&awbMaster).Clauses(clause.OnConflict{DoNothing: true}).Error
 	if err != nil {
 		return nil, err
 	}
@@ -54,6 +57,11 @@ func (t *AirwayBillMaster) Upsert(ctx *context.Context, awbMaster *models.Airway
 
 func (t *AirwayBillMaster) UpsertAll(ctx *context.Context, awbMasters []*models.AirwayBillMaster, by uuid.UUID) ([]*models.AirwayBillMaster, error) {
 
+	table, err := t.getTable(ctx)
+	if err != nil {
+		return nil, err
+	}
+
 	currentTime := time.Now().UTC()
 
 	for _, awbMaster := range awbMasters {
@@ -67,7 +75,7 @@ func (t *AirwayBillMaster) UpsertAll(ctx *context.Context, awbMasters []*models.
 		awbMaster.UpdatedBy = by
 	}
 
-	err := ctx.DB.WithContext(ctx.Request.Context()).Table(t.getTable(ctx)).Save(&awbMasters).Error
+	err = ctx.DB.WithContext(ctx.Request.Context()).Table(table).Save(&awbMasters).Error
 	if err != nil {
 		ctx.Log.Error("Failed to upsert AWB masters", zap.Error(err))
 		return nil, err
@@ -77,9 +85,14 @@ func (t *AirwayBillMaster) UpsertAll(ctx *context.Context, awbMasters []*models.
 }
 
 func (t *AirwayBillMaster) Get(ctx *context.Context, id uuid.UUID, shipmentId uuid.UUID, awbInfoId uuid.UUID, query string) (*models.AirwayBillMaster, error) {
+	table, err := t.getTable(ctx)
+	if err != nil {
+		return nil, err
+	}
+
 	awbMaster := &models.AirwayBillMaster{}
 
-	tx := ctx.DB.Debug().WithContext(ctx.Request.Context()).Table(t.getTable(ctx))
+	tx := ctx.DB.Debug().WithContext(ctx.Request.Context()).Table(table)
 	if id != uuid.Nil {
 		tx.Where("id = ?", id)
 	}
@@ -92,7 +105,7 @@ func (t *AirwayBillMaster) Get(ctx *context.Context, id uuid.UUID, shipmentId uu
 	if query != "" {
 		tx.Where(query)
 	}
-	err := tx.First(&awbMaster).Error
+	err = tx.First(&awbMaster).Error
 	if err != nil {
 		return nil, err
 	}
@@ -102,8 +115,13 @@ func (t *AirwayBillMaster) Get(ctx *context.Context, id uuid.UUID, shipmentId uu
 
 func (t *AirwayBillMaster) GetAll(ctx *context.Context, awbInfoId uuid.UUID, pol string, pod string, linerId string, query string) ([]*models.AirwayBillMaster, error) {
 
+	table, err := t.getTable(ctx)
+	if err != nil {
+		return nil, err
+	}
+
 	awbMasters := []*models.AirwayBillMaster{}
-	tx := ctx.DB.Debug().WithContext(ctx.Request.Context()).Table(t.getTable(ctx))
+	tx := ctx.DB.Debug().WithContext(ctx.Request.Context()).Table(table)
 	if awbInfoId != uuid.Nil {
 		tx.Where("awb_info_id = ?", awbInfoId)
 	}
@@ -121,7 +139,7 @@ func (t *AirwayBillMaster) GetAll(ctx *context.Context, awbInfoId uuid.UUID, pol
 	}
 
 	tx.Order("created_at")
-	err := tx.Find(&awbMasters).Error
+	err = tx.Find(&awbMasters).Error
 	if err != nil {
 		return nil, err
 	}
@@ -130,8 +148,13 @@ func (t *AirwayBillMaster) GetAll(ctx *context.Context, awbInfoId uuid.UUID, pol
 }
 
 func (t *AirwayBillMaster) GetMastersWithStockNumbers(ctx *context.Context, stockNumbers []string, query string) ([]*models.MawbStockWithBookings, error) {
+	table, err := t.getTable(ctx)
+	if err != nil {
+		return nil, err
+	}
+
 	awbMasters := []*models.MawbStockWithBookings{}
-	querystr := ctx.DB.Debug().WithContext(ctx.Request.Context()).Table(t.getTable(ctx)).Select("awb_master.awb_info_id , awb_info.number, array_agg(awb_master.shipment_id::text order by awb_master.created_at asc ) as shipment_ids").Joins("JOIN awb_info ON awb_info.id = awb_master.awb_info_id")
+	querystr := ctx.DB.Debug().WithContext(ctx.Request.Context()).Table(table).Select("awb_master.awb_info_id , awb_info.number, array_agg(awb_master.shipment_id::text order by awb_master.created_at asc ) as shipment_ids").Joins("JOIN awb_info ON awb_info.id = awb_master.awb_info_id")
 	if len(stockNumbers) > 0 {
 		querystr.Where(" awb_info.number IN ?", stockNumbers)
 	}
@@ -140,7 +163,7 @@ func (t *AirwayBillMaster) GetMastersWithStockNumbers(ctx *context.Context, stoc
 	}
 	querystr.Group("awb_info.number, awb_master.awb_info_id, awb_info.created_at")
 	querystr.Order("awb_info.created_at")
-	err := querystr.Find(&awbMasters).Error
+	err = querystr.Find(&awbMasters).Error
 	if err != nil {
 		return nil, err
 	}
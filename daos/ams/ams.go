@@ -3,10 +3,14 @@ package ams
 import (
 	"encoding/json"
 	"fmt"
+	"time"
 
 	dtos "bitbucket.org/radarventures/forwarder-adapters/dtos/shipments"
 	"bitbucket.org/radarventures/forwarder-adapters/thirdparty/ams"
 	"bitbucket.org/radarventures/forwarder-adapters/utils/context"
+	"bitbucket.org/radarventures/forwarder-shipments/config"
+	"bitbucket.org/radarventures/forwarder-shipments/constants"
+	"bitbucket.org/radarventures/forwarder-shipments/daos/tenant"
 	"bitbucket.org/radarventures/forwarder-shipments/database/models"
 	"github.com/google/uuid"
 	"go.uber.org/zap"
@@ -25,6 +29,14 @@ type AMSDBI interface {
 	GetAllAddressDetails(*context.Context, string) ([]models.AmsDaoContactResp, error)
 	AddAllAddressDetails(*context.Context, []models.AmsDaoContactResp) error
 	UpdateAmsStatusManual(*context.Context, string, string, string) error
+
+	AddMailFailure(*context.Context, *models.AMSMailFailure) error
+	GetMailFailure(*context.Context, uuid.UUID) (*models.AMSMailFailure, error)
+	GetMailFailures(*context.Context, bool) ([]*models.AMSMailFailure, error)
+	ResolveMailFailure(*context.Context, uuid.UUID, *uuid.UUID, string) error
+
+	GetFilings(*context.Context, *models.AMSFilingFilter) (int64, []*models.AMSFiling, error)
+	GetStatusEvents(*context.Context, []uuid.UUID) ([]*models.AMSStatusEvent, error)
 }
 
 func NewAMSInfo() AMSDBI {
@@ -188,6 +200,11 @@ func (a *AmsDB) UpdateAmsStatus(ctx *context.Context, id, msg string) (string, e
 		return "", err
 	}
 
+	if err := addStatusEvent(ctx.DB.Debug(), ams_info_id, msg); err != nil {
+		ctx.Log.Error("AMS status event ERR  :  ", zap.Error(err))
+		return "", err
+	}
+
 	return ams_string, err
 
 }
@@ -216,7 +233,7 @@ func (a *AmsDB) UpdateFileGenerated(ctx *context.Context, cargo *ams.CargoSecuri
 			return err
 		}
 
-		return nil
+		return addStatusEvent(tx, amsID, constants.AMSStatusFileGenerated)
 	})
 
 	return err
@@ -310,13 +327,203 @@ func (a *AmsDB) AddAllAddressDetails(ctx *context.Context, contacts []models.Ams
 func (a *AmsDB) UpdateAmsStatusManual(ctx *context.Context, bookingId string, hblNo string, msg string) error {
 	ctx.Log.Debug("Started Update ams status bookingId : " + bookingId + " hblNo :" + hblNo + " msg: " + msg)
 
-	err := ctx.DB.Debug().Exec(`UPDATE ams_info SET ams_status = $1 WHERE id = (SELECT id FROM ams_info WHERE shipment_id = $2 AND hbl_no = $3 ORDER BY created_at DESC LIMIT 1)`, msg, bookingId, hblNo).Error
+	var ams_info_id uuid.UUID
+	err := ctx.DB.Debug().Raw(`UPDATE ams_info SET ams_status = $1 WHERE id = (SELECT id FROM ams_info WHERE shipment_id = $2 AND hbl_no = $3 ORDER BY created_at DESC LIMIT 1) RETURNING id`, msg, bookingId, hblNo).Scan(&ams_info_id).Error
 	if err != nil {
 		ctx.Log.Error("AMS Update ERR  :  ", zap.Error(err))
 		return err
 	}
 
+	if ams_info_id != uuid.Nil {
+		if err := addStatusEvent(ctx.DB.Debug(), ams_info_id, msg); err != nil {
+			ctx.Log.Error("AMS status event ERR  :  ", zap.Error(err))
+			return err
+		}
+	}
+
 	ctx.Log.Debug("Completed Update ams status bookingId : " + bookingId + " hblNo :" + hblNo + " msg: " + msg)
 
 	return nil
 }
+
+// AddMailFailure stores a mail that could not be parsed. A mail already stored is left as is.
+func (a *AmsDB) AddMailFailure(ctx *context.Context, failure *models.AMSMailFailure) error {
+	err := ctx.DB.WithContext(ctx.Request.Context()).Table("ams_mail_failures").
+		Clauses(clause.OnConflict{
+			Columns:   []clause.Column{{Name: "message_id"}, {Name: "source"}},
+			DoNothing: true,
+		}).
+		Create(failure).Error
+	if err != nil {
+		ctx.Log.Error("Unable to store AMS mail failure", zap.String("message_id", failure.MessageId), zap.Error(err))
+		return err
+	}
+
+	return nil
+}
+
+func (a *AmsDB) GetMailFailure(ctx *context.Context, id uuid.UUID) (*models.AMSMailFailure, error) {
+	var failure models.AMSMailFailure
+	err := ctx.DB.WithContext(ctx.Request.Context()).Table("ams_mail_failures").First(&failure, "id = ?", id).Error
+	if err != nil {
+		return nil, err
+	}
+
+	return &failure, nil
+}
+
+// GetMailFailures returns the stored failures, newest first. Resolved ones are left out unless
+// asked for.
+func (a *AmsDB) GetMailFailures(ctx *context.Context, includeResolved bool) ([]*models.AMSMailFailure, error) {
+	var failures []*models.AMSMailFailure
+	tx := ctx.DB.WithContext(ctx.Request.Context()).Table("ams_mail_failures")
+	if !includeResolved {
+		tx.Where("resolved_at IS NULL")
+	}
+
+	err := tx.Order("received_at desc").Find(&failures).Error
+	if err != nil {
+		ctx.Log.Error("Unable to get AMS mail failures", zap.Error(err))
+		return nil, err
+	}
+
+	return failures, nil
+}
+
+func (a *AmsDB) ResolveMailFailure(ctx *context.Context, id uuid.UUID, resolvedBy *uuid.UUID, resolution string) error {
+	return ctx.DB.WithContext(ctx.Request.Context()).Table("ams_mail_failures").
+		Where("id = ? AND resolved_at IS NULL", id).
+		UpdateColumns(map[string]interface{}{
+			"resolved_at": time.Now().UTC(),
+			"resolved_by": resolvedBy,
+			"resolution":  resolution,
+		}).Error
+}
+
+// addStatusEvent records a status the filing reached, the filing's stage history is read from
+// these.
+func addStatusEvent(tx *gorm.DB, amsInfoId uuid.UUID, status string) error {
+	return tx.Table("ams_status_events").Create(&models.AMSStatusEvent{
+		Id:        uuid.New(),
+		AmsInfoId: amsInfoId,
+		Status:    status,
+		CreatedAt: time.Now().UTC(),
+	}).Error
+}
+
+// GetFilings returns a page of the latest AMS filing of every house bill of the shipments and
+// of their ISF filings, newest first, with the number of filings on every page.
+func (a *AmsDB) GetFilings(ctx *context.Context, filter *models.AMSFilingFilter) (int64, []*models.AMSFiling, error) {
+	shipmentsTable, err := tenant.Table(ctx, "shipments")
+	if err != nil {
+		return 0, nil, err
+	}
+	quotesTable, err := tenant.Table(ctx, "quotes")
+	if err != nil {
+		return 0, nil, err
+	}
+	sisTable, err := tenant.Table(ctx, "sis_info")
+	if err != nil {
+		return 0, nil, err
+	}
+
+	db := ctx.DB.WithContext(ctx.Request.Context())
+
+	amsFilings := db.Table("ams_info a").
+		Select(`DISTINCT ON (a.shipment_id, a.hbl_no)
+	a.id::text AS ams_info_id,
+	?::text AS kind,
+	s.id AS shipment_id,
+	s.code AS shipment_code,
+	s.region_id,
+	s.created_by,
+	a.hbl_no,
+	COALESCE(a.ams_status, '') AS ams_status,
+	COALESCE(a.is_generated, false) AS is_generated,
+	COALESCE(g.ams_file, '') AS ams_file,
+	COALESCE(g.error_response, '') AS error_response,
+	q.etd,
+	a.created_at`, constants.FilingKindAMS).
+		Joins("JOIN " + shipmentsTable + " s ON s.id = a.shipment_id AND s.is_deleted = false").
+		Joins("LEFT JOIN " + quotesTable + " q ON q.id = s.quote_id").
+		Joins(`LEFT JOIN LATERAL (
+	SELECT ams_file, error_response::text AS error_response FROM ams_generated
+	WHERE ams_info_id::text = a.id::text ORDER BY created_at DESC LIMIT 1
+) g ON true`).
+		Order("a.shipment_id, a.hbl_no, a.created_at desc")
+
+	isfFilings := db.Table(sisTable+" si").
+		Select(`si.id::text AS ams_info_id,
+	?::text AS kind,
+	s.id AS shipment_id,
+	s.code AS shipment_code,
+	s.region_id,
+	s.created_by,
+	'' AS hbl_no,
+	CASE WHEN COALESCE(si.isf_processed, false) THEN ? ELSE ? END AS ams_status,
+	true AS is_generated,
+	'' AS ams_file,
+	'' AS error_response,
+	q.etd,
+	si.created_at`, constants.FilingKindISF, constants.ISFStatusTransmitted, constants.AMSStatusFileGenerated).
+		Joins("JOIN " + shipmentsTable + " s ON s.id = si.shipment_id AND s.is_deleted = false").
+		Joins("LEFT JOIN " + quotesTable + " q ON q.id = s.quote_id").
+		Where("si.sis_data IS NOT NULL")
+
+	tx := db.Table("((?) UNION ALL (?)) f", amsFilings, isfFilings)
+
+	if filter.RegionId != "" {
+		tx.Where("f.region_id = ?", filter.RegionId)
+	}
+	if filter.ShipmentId != "" {
+		tx.Where("f.shipment_id = ?", filter.ShipmentId)
+	}
+	if filter.HblNo != "" {
+		tx.Where("f.hbl_no = ?", filter.HblNo)
+	}
+	if filter.Kind != "" {
+		tx.Where("f.kind = ?", filter.Kind)
+	}
+
+	var total int64
+	err = tx.Count(&total).Error
+	if err != nil {
+		ctx.Log.Error("Unable to count AMS filings", zap.Any("filter", filter), zap.Error(err))
+		return 0, nil, err
+	}
+
+	pg := filter.Pg
+	if pg < 1 {
+		pg = 1
+	}
+
+	var filings []*models.AMSFiling
+	err = tx.Order("f.created_at desc").
+		Offset(config.Get().PageSize * (pg - 1)).
+		Limit(config.Get().PageSize).
+		Scan(&filings).Error
+	if err != nil {
+		ctx.Log.Error("Unable to get AMS filings", zap.Any("filter", filter), zap.Error(err))
+		return 0, nil, err
+	}
+
+	return total, filings, nil
+}
+
+func (a *AmsDB) GetStatusEvents(ctx *context.Context, amsInfoIds []uuid.UUID) ([]*models.AMSStatusEvent, error) {
+	var events []*models.AMSStatusEvent
+	if len(amsInfoIds) == 0 {
+		return events, nil
+	}
+
+	err := ctx.DB.WithContext(ctx.Request.Context()).Table("ams_status_events").
+		Where("ams_info_id IN ?", amsInfoIds).
+		Order("created_at").
+		Find(&events).Error
+	if err != nil {
+		ctx.Log.Error("Unable to get AMS status events", zap.Error(err))
+		return nil, err
+	}
+
+	return events, nil
+}
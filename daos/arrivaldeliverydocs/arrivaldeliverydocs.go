@@ -5,9 +5,12 @@ import (
 
 	"bitbucket.org/radarventures/forwarder-adapters/utils/context"
 	"bitbucket.org/radarventures/forwarder-shipments/config/globals"
+	"bitbucket.org/radarventures/forwarder-shipments/constants"
+	"bitbucket.org/radarventures/forwarder-shipments/daos/documentcounter"
 	"bitbucket.org/radarventures/forwarder-shipments/database/models"
 	"github.com/google/uuid"
 	"go.uber.org/zap"
+	"gorm.io/gorm"
 )
 
 type IArrivalDeliveryDocs interface {
@@ -20,7 +23,7 @@ type IArrivalDeliveryDocs interface {
 	DeleteAddocsTranshipmentInfo(ctx *context.Context, addocId uuid.UUID) error
 	DeleteAddocsAirTranshipmentInfo(ctx *context.Context, addocId uuid.UUID) error
 	DeleteAddocsContainerInfo(ctx *context.Context, addocId uuid.UUID) error
-	GetMaxDoBlNumber(ctx *context.Context) int64
+	NextDoBlNumberWithTx(ctx *context.Context, tx *gorm.DB) (int64, error)
 }
 
 type ArrivalDeliveryDocs struct {
@@ -176,7 +179,20 @@ func (b *ArrivalDeliveryDocs) Upsert(ctx *context.Context, addocs *models.Arriva
 		doBlInfo.ShipmentId = addocs.ShipmentId
 		doBlInfo.BlNo = addocs.DoBlInfo.BlNo
 
-		err = ctx.DB.Debug().WithContext(ctx.Request.Context()).Table(b.getAdDocDoBlTable(ctx)).Save(&doBlInfo).Error
+		// The DO number is issued in the transaction that saves the DO, a failed save returns it
+		err = ctx.DB.Debug().WithContext(ctx.Request.Context()).Transaction(func(tx *gorm.DB) error {
+			doNo, err := b.NextDoBlNumberWithTx(ctx, tx)
+			if err != nil {
+				return err
+			}
+
+			err = tx.Table(b.getAdDocDoBlTable(ctx)).Save(&doBlInfo).Error
+			if err != nil {
+				return err
+			}
+
+			return tx.Table(b.getAdDocDoBlTable(ctx)).Where("id = ?", doBlInfo.Id).UpdateColumn("do_no", doNo).Error
+		})
 		if err != nil {
 			return nil, err
 		}
@@ -269,17 +285,15 @@ func (b *ArrivalDeliveryDocs) GetDoBlInfo(ctx *context.Context, id uuid.UUID, bl
 	return addocsdoblinfo, nil
 }
 
-func (b *ArrivalDeliveryDocs) GetMaxDoBlNumber(ctx *context.Context) int64 {
-
-	var number int64
-
-	tx := ctx.DB.Debug().WithContext(ctx.Request.Context()).Table(b.getAdDocDoBlTable(ctx)).Select("max(do_no)")
-	err := tx.Scan(&number).Error
-	if err != nil {
-		return 0
-	}
-
-	return number
+// NextDoBlNumberWithTx issues the next DO number from the tenant's DO/BL counter. The counter
+// starts after the highest DO number already issued.
+func (b *ArrivalDeliveryDocs) NextDoBlNumberWithTx(ctx *context.Context, tx *gorm.DB) (int64, error) {
+	key := &models.DocumentCounterKey{DocType: constants.DocTypeDoBl}
+	return documentcounter.NewDocumentCounter().NextWithTx(ctx, tx, key, func(tx *gorm.DB) (int64, error) {
+		var last int64
+		err := tx.Table(b.getAdDocDoBlTable(ctx)).Select("COALESCE(max(do_no), 0)").Scan(&last).Error
+		return last, err
+	})
 }
 
 func (b *ArrivalDeliveryDocs) DeleteAddocsArrivalDeliveryDocsById(ctx *context.Context, shipmentId uuid.UUID) error {
@@ -10,6 +10,7 @@ import (
 	"bitbucket.org/radarventures/forwarder-adapters/utils/context"
 	"bitbucket.org/radarventures/forwarder-shipments/config/globals"
 	"bitbucket.org/radarventures/forwarder-shipments/constants"
+	"bitbucket.org/radarventures/forwarder-shipments/daos/tenant"
 	"bitbucket.org/radarventures/forwarder-shipments/database/models"
 	"github.com/google/uuid"
 	"github.com/lib/pq"
@@ -54,31 +55,35 @@ func NewCard() ICard {
 	return &Card{}
 }
 
-func (t *Card) getTable(ctx *context.Context) string {
-	if ctx.TenantID == "" {
-		ctx.TenantID = "public"
-	}
-	return ctx.TenantID + "." + "cards"
+func (t *Card) getTable(ctx *context.Context) (string, error) {
+	return tenant.Table(ctx, "cards")
 }
 
-func (t *Card) getRfqsTable(ctx *context.Context) string {
-	if ctx.TenantID == "" {
-		ctx.TenantID = "public"
-	}
-	return ctx.TenantID + ".rfqs"
+func (t *Card) getRfqsTable(ctx *context.Context) (string, error) {
+	return tenant.Table(ctx, "rfqs")
 }
 
-func (t *Card) getShipmentsTable(ctx *context.Context) string {
-	return ctx.TenantID + ".shipments"
+func (t *Card) getShipmentsTable(ctx *context.Context) (string, error) {
+	return tenant.Table(ctx, "shipments")
 }
 
 func (t *Card) Upsert(ctx *context.Context, m ...*models.Card) error {
-	return ctx.DB.WithContext(ctx.Request.Context()).Table(t.getTable(ctx)).Save(m).Error
+	table, err := t.getTable(ctx)
+	if err != nil {
+		return err
+	}
+
+	return ctx.DB.WithContext(ctx.Request.Context()).Table(table).Save(m).Error
 }
 
 func (t *Card) Get(ctx *context.Context, id string) (*models.Card, error) {
+	table, err := t.getTable(ctx)
+	if err != nil {
+		return nil, err
+	}
+
 	var result models.Card
-	err := ctx.DB.WithContext(ctx.Request.Context()).Table(t.getTable(ctx)).First(&result, "id = ?", id).Error
+	err = ctx.DB.WithContext(ctx.Request.Context()).Table(table).First(&result, "id = ?", id).Error
 	if err != nil {
 		ctx.Log.Error("Unable to get card.", zap.Error(err))
 		return nil, err
@@ -88,8 +93,13 @@ func (t *Card) Get(ctx *context.Context, id string) (*models.Card, error) {
 }
 
 func (t *Card) Delete(ctx *context.Context, id string) error {
+	table, err := t.getTable(ctx)
+	if err != nil {
+		return err
+	}
+
 	var result models.Card
-	err := ctx.DB.WithContext(ctx.Request.Context()).Table(t.getTable(ctx)).Delete(&result, "id = ?", id).Error
+	err = ctx.DB.WithContext(ctx.Request.Context()).Table(table).Delete(&result, "id = ?", id).Error
 	if err != nil {
 		ctx.Log.Error("Unable to delete card.", zap.Error(err))
 		return err
@@ -99,16 +109,21 @@ func (t *Card) Delete(ctx *context.Context, id string) error {
 }
 
 func (t *Card) GetAll(ctx *context.Context, ids []string) ([]*models.Card, error) {
+	table, err := t.getTable(ctx)
+	if err != nil {
+		return nil, err
+	}
+
 	var result []*models.Card
 	if len(ids) == 0 {
-		err := ctx.DB.Debug().WithContext(ctx.Request.Context()).Table(t.getTable(ctx)).Find(&result).Error
+		err := ctx.DB.Debug().WithContext(ctx.Request.Context()).Table(table).Find(&result).Error
 		if err != nil {
 			ctx.Log.Error("Unable to get cards.", zap.Error(err))
 			return nil, err
 		}
 		return result, err
 	}
-	err := ctx.DB.Debug().WithContext(ctx.Request.Context()).Table(t.getTable(ctx)).Where("id IN ?", ids).Find(&result).Error
+	err = ctx.DB.Debug().WithContext(ctx.Request.Context()).Table(table).Where("id IN ?", ids).Find(&result).Error
 	if err != nil {
 		ctx.Log.Error("Unable to get cards.", zap.Error(err))
 		return nil, err
@@ -118,6 +133,11 @@ func (t *Card) GetAll(ctx *context.Context, ids []string) ([]*models.Card, error
 }
 
 func (t *Card) GetCardsWithFilter(ctx *context.Context, filter *models.Card, statuslist []string) ([]*models.Card, error) {
+	table, err := t.getTable(ctx)
+	if err != nil {
+		return nil, err
+	}
+
 	var results []*models.Card
 
 	nameList := []string{}
@@ -129,7 +149,7 @@ func (t *Card) GetCardsWithFilter(ctx *context.Context, filter *models.Card, sta
 	if len(nameList) > 0 {
 		filter.Name = ""
 	}
-	tx := ctx.DB.Debug().WithContext(ctx.Request.Context()).Table(t.getTable(ctx))
+	tx := ctx.DB.Debug().WithContext(ctx.Request.Context()).Table(table)
 	tx.Where(&filter)
 
 	if len(nameList) > 0 {
@@ -139,7 +159,7 @@ func (t *Card) GetCardsWithFilter(ctx *context.Context, filter *models.Card, sta
 		tx.Where("status in (?)", statuslist)
 	}
 
-	err := tx.Find(&results).Error
+	err = tx.Find(&results).Error
 	if err != nil {
 		ctx.Log.Error("error while fetching filtering cards", zap.Any("filter", filter), zap.Error(err))
 		return nil, err
@@ -148,11 +168,16 @@ func (t *Card) GetCardsWithFilter(ctx *context.Context, filter *models.Card, sta
 }
 
 func (t *Card) GetExecCards(ctx *context.Context, req *models.Card, cardfilter *dtos.GECardFilter) ([]*models.Card, error) {
+	table, err := t.getTable(ctx)
+	if err != nil {
+		return nil, err
+	}
+
 	var results []*models.Card
 	statusList := []string{constants.CardStatusBreached, constants.CardStatusCreated, constants.CardStatusWarning}
 	currentTime := time.Now().UTC()
 
-	q := ctx.DB.Debug().WithContext(ctx.Request.Context()).Table(t.getTable(ctx)).
+	q := ctx.DB.Debug().WithContext(ctx.Request.Context()).Table(table).
 		Where("status in ?", statusList).
 		Where("visible_time < ?", currentTime).
 		Where(&cardfilter).Order("estimate ASC")
@@ -162,7 +187,7 @@ func (t *Card) GetExecCards(ctx *context.Context, req *models.Card, cardfilter *
 	} else {
 		q = q.Where("assigned_to = ?", req.AssignedTo)
 	}
-	err := q.Find(&results).Error
+	err = q.Find(&results).Error
 	if err != nil {
 		ctx.Log.Error("Error while ExecCards in DB", zap.Any("filter", req), zap.Error(err))
 		return nil, err
@@ -171,13 +196,18 @@ func (t *Card) GetExecCards(ctx *context.Context, req *models.Card, cardfilter *
 }
 
 func (t *Card) GetAssignedTo(ctx *context.Context, filter *models.Card, statusList []string) (*models.Card, error) {
+	table, err := t.getTable(ctx)
+	if err != nil {
+		return nil, err
+	}
+
 	var res *models.Card
 
-	conn := ctx.DB.Debug().WithContext(ctx.Request.Context()).Table(t.getTable(ctx)).Select("assigned_to, escalated_to").Where(&filter)
+	conn := ctx.DB.Debug().WithContext(ctx.Request.Context()).Table(table).Select("assigned_to, escalated_to").Where(&filter)
 	if len(statusList) > 0 {
 		conn.Where("status in ?", statusList)
 	}
-	err := conn.Scan(&res).Error
+	err = conn.Scan(&res).Error
 	if err != nil {
 		ctx.Log.Error("Error while Getting executive from DB", zap.Error(err))
 		return nil, err
@@ -188,46 +218,60 @@ func (t *Card) GetAssignedTo(ctx *context.Context, filter *models.Card, statusLi
 
 func (t *Card) GetShipmentsWithFilter(ctx *context.Context, isShipment bool, rep_ids []string, shipmentFilter *dtos.GEShipmentFilter, cardFilter *dtos.GECardFilter) (models.CountCards, error) {
 	var results models.CountCards
+
+	table, err := t.getTable(ctx)
+	if err != nil {
+		return results, err
+	}
+	shipmentsTable, err := t.getShipmentsTable(ctx)
+	if err != nil {
+		return results, err
+	}
+	rfqsTable, err := t.getRfqsTable(ctx)
+	if err != nil {
+		return results, err
+	}
+
 	currentTime := time.Now().UTC()
 	statusList := []string{constants.CardStatusBreached, constants.CardStatusCreated, constants.CardStatusWarning}
-	query := ctx.DB.Debug().Table(t.getTable(ctx)).
-		Select(fmt.Sprintf("%s.assigned_to,%s.assigned_to_name ,%s.status, COUNT(DISTINCT(%s.id)) as task_count", t.getTable(ctx), t.getTable(ctx), t.getTable(ctx), t.getTable(ctx)))
+	query := ctx.DB.Debug().Table(table).
+		Select(fmt.Sprintf("%s.assigned_to,%s.assigned_to_name ,%s.status, COUNT(DISTINCT(%s.id)) as task_count", table, table, table, table))
 
 	if isShipment {
-		query = query.Joins(fmt.Sprintf("JOIN %s ON %s.id::TEXT = %s.instance_id ::TEXT", t.getShipmentsTable(ctx), t.getShipmentsTable(ctx), t.getTable(ctx))).
+		query = query.Joins(fmt.Sprintf("JOIN %s ON %s.id::TEXT = %s.instance_id ::TEXT", shipmentsTable, shipmentsTable, table)).
 			Where("instance_id != '' and instance_type='shipment'").
-			Where(fmt.Sprintf("%s.consol_id IS NULL OR %s.consol_id = ?", t.getShipmentsTable(ctx), t.getShipmentsTable(ctx)), uuid.Nil)
+			Where(fmt.Sprintf("%s.consol_id IS NULL OR %s.consol_id = ?", shipmentsTable, shipmentsTable), uuid.Nil)
 	} else {
-		query = query.Joins(fmt.Sprintf("JOIN %s ON %s.id::TEXT = %s.instance_id::TEXT ", t.getRfqsTable(ctx), t.getRfqsTable(ctx), t.getTable(ctx))).Where("instance_id != '' and instance_type='rfq'")
+		query = query.Joins(fmt.Sprintf("JOIN %s ON %s.id::TEXT = %s.instance_id::TEXT ", rfqsTable, rfqsTable, table)).Where("instance_id != '' and instance_type='rfq'")
 	}
 
 	query = query.Where("assigned_to in ?", rep_ids).
 		Where("visible_time < ?", currentTime).
-		Where(fmt.Sprintf("%s.status in (?)", t.getTable(ctx)), statusList).
+		Where(fmt.Sprintf("%s.status in (?)", table), statusList).
 		Where(&cardFilter).
-		Group(fmt.Sprintf("%s.assigned_to, %s.assigned_to_name, %s.status", t.getTable(ctx), t.getTable(ctx), t.getTable(ctx)))
+		Group(fmt.Sprintf("%s.assigned_to, %s.assigned_to_name, %s.status", table, table, table))
 
 	if shipmentFilter.Code != "" {
-		query = query.Where(fmt.Sprintf("%s.code=?", t.getShipmentsTable(ctx)), shipmentFilter.Code)
+		query = query.Where(fmt.Sprintf("%s.code=?", shipmentsTable), shipmentFilter.Code)
 	}
 
 	if shipmentFilter.Pol != "" {
-		query = query.Where(fmt.Sprintf("%s.pod=?", t.getShipmentsTable(ctx)), shipmentFilter.Pol)
+		query = query.Where(fmt.Sprintf("%s.pod=?", shipmentsTable), shipmentFilter.Pol)
 	}
 
 	if shipmentFilter.Pod != "" {
-		query = query.Where(fmt.Sprintf("%s.pod=?", t.getShipmentsTable(ctx)), shipmentFilter.Pod)
+		query = query.Where(fmt.Sprintf("%s.pod=?", shipmentsTable), shipmentFilter.Pod)
 	}
 
 	if shipmentFilter.ShipmentNature != "" {
-		query = query.Where(fmt.Sprintf("%s.shipment_nature=?", t.getShipmentsTable(ctx)), shipmentFilter.ShipmentNature)
+		query = query.Where(fmt.Sprintf("%s.shipment_nature=?", shipmentsTable), shipmentFilter.ShipmentNature)
 	}
 
 	if shipmentFilter.ShipmentType != "" {
-		query = query.Where(fmt.Sprintf("%s.type = ?", t.getShipmentsTable(ctx)), shipmentFilter.ShipmentType)
+		query = query.Where(fmt.Sprintf("%s.type = ?", shipmentsTable), shipmentFilter.ShipmentType)
 	}
 
-	err := query.Find(&results).Error
+	err = query.Find(&results).Error
 	if err != nil {
 		ctx.Log.Error("Error while getting bulk  --DB", zap.Any("bookingFilter", shipmentFilter), zap.Error(err))
 	}
@@ -237,16 +281,22 @@ func (t *Card) GetShipmentsWithFilter(ctx *context.Context, isShipment bool, rep
 
 func (t *Card) GetCardswithNoBooks(ctx *context.Context, rep_ids []string, cardFilter *dtos.GECardFilter) (models.CountCards, error) {
 	var results models.CountCards
+
+	table, err := t.getTable(ctx)
+	if err != nil {
+		return results, err
+	}
+
 	currentTime := time.Now().UTC()
 	statusList := []string{constants.CardStatusBreached, constants.CardStatusCreated, constants.CardStatusWarning}
-	query := ctx.DB.Debug().Table(t.getTable(ctx)).
+	query := ctx.DB.Debug().Table(table).
 		Select("assigned_to,assigned_to_name ,status, COUNT(DISTINCT(id)) as task_count").
 		Where("assigned_to in ?", rep_ids).
 		Where("visible_time < ?", currentTime).
 		Where("status in (?)", statusList).
 		Where("instance_id = ''").
 		Group("assigned_to, assigned_to_name, status")
-	err := query.Find(&results).Error
+	err = query.Find(&results).Error
 	if err != nil {
 		ctx.Log.Error("Error while getting bulk  --DB", zap.Error(err))
 	}
@@ -255,6 +305,15 @@ func (t *Card) GetCardswithNoBooks(ctx *context.Context, rep_ids []string, cardF
 
 func (t *Card) GetLiveEscaltedCardsOrgtree(ctx *context.Context) (map[string]dtos.TaskCounts, error) {
 
+	table, err := t.getTable(ctx)
+	if err != nil {
+		return nil, err
+	}
+	shipmentsTable, err := t.getShipmentsTable(ctx)
+	if err != nil {
+		return nil, err
+	}
+
 	statusList := []string{constants.CardStatusBreached, constants.CardStatusCreated, constants.CardStatusWarning}
 	rows, err := ctx.DB.Debug().Raw(`SELECT
     unnested_uuid AS escalated_by_id,
@@ -265,13 +324,13 @@ FROM
             id,assigned_to,
             unnest(escalated_by_id) AS unnested_uuid
         FROM
-            `+t.getTable(ctx)+`
+            `+table+`
         WHERE
             escalated = true
             AND status IN (?)
 			AND instance_id NOT IN (
                 SELECT id::TEXT
-                FROM `+t.getShipmentsTable(ctx)+`
+                FROM `+shipmentsTable+`
                 WHERE consol_id is NOT NULL AND consol_id != ?
             )
     ) AS subquery
@@ -299,13 +358,22 @@ ORDER BY
 
 func (t *Card) GetCardCounts(ctx *context.Context) (map[string]dtos.TaskCounts, error) {
 
+	table, err := t.getTable(ctx)
+	if err != nil {
+		return nil, err
+	}
+	shipmentsTable, err := t.getShipmentsTable(ctx)
+	if err != nil {
+		return nil, err
+	}
+
 	statusList := []string{constants.CardStatusBreached, constants.CardStatusCreated, constants.CardStatusWarning}
 	rows, err := ctx.DB.Debug().Raw(`SELECT assigned_to,status
-	FROM  `+t.getTable(ctx)+`
+	FROM  `+table+`
 	WHERE  visible_time < Now()
 	AND escalated = 'false' AND status IN (?)
 	AND ( instance_id NOT IN (SELECT id::TEXT
-						 FROM   `+t.getShipmentsTable(ctx)+`
+						 FROM   `+shipmentsTable+`
 						 WHERE consol_id is NOT NULL AND consol_id != ?)
 						) `, statusList, uuid.Nil).Rows()
 	if err != nil {
@@ -338,6 +406,11 @@ func (t *Card) GetCardCounts(ctx *context.Context) (map[string]dtos.TaskCounts,
 
 func (c *Card) ReassignAllShipmentAssignedCard(ctx *context.Context, req *dtos.ReExecCard, instance_ids []string, aid string) ([]models.Card, error) {
 
+	table, err := c.getTable(ctx)
+	if err != nil {
+		return nil, err
+	}
+
 	statusList := []string{globals.StatusBreached, dtos.ActionCreated, globals.StatusWarning}
 
 	newValues := map[string]interface{}{
@@ -351,13 +424,13 @@ func (c *Card) ReassignAllShipmentAssignedCard(ctx *context.Context, req *dtos.R
 		"escalation_description": "",
 		"updated_at":             time.Now().UTC(),
 	}
-	err := ctx.DB.Debug().Table(c.getTable(ctx)).Where("status in (?)", statusList).Where("instance_id in (?) and assigned_to = ?", instance_ids, aid).Updates(newValues).Error
+	err = ctx.DB.Debug().Table(table).Where("status in (?)", statusList).Where("instance_id in (?) and assigned_to = ?", instance_ids, aid).Updates(newValues).Error
 	if err != nil {
 		ctx.Log.Error("Error while reassigning card in DB", zap.Error(err))
 	}
 
 	var updatedCards []models.Card
-	err = ctx.DB.Debug().Table(c.getTable(ctx)).
+	err = ctx.DB.Debug().Table(table).
 		Where("status IN (?)", statusList).
 		Where("instance_id IN (?) AND assigned_to = ?", instance_ids, aid).
 		Find(&updatedCards).
@@ -371,6 +444,11 @@ func (c *Card) ReassignAllShipmentAssignedCard(ctx *context.Context, req *dtos.R
 }
 
 func (c *Card) ReassignCard(ctx *context.Context, req *dtos.ReExecCard) (*models.Card, error) {
+	table, err := c.getTable(ctx)
+	if err != nil {
+		return nil, err
+	}
+
 	flowInstanceIds := req.CardIds
 	statusList := []string{constants.CardStatusBreached, constants.CardStatusCreated, constants.CardStatusWarning}
 	newValues := map[string]interface{}{
@@ -385,8 +463,8 @@ func (c *Card) ReassignCard(ctx *context.Context, req *dtos.ReExecCard) (*models
 		"updated_at":             time.Now().UTC(),
 	}
 
-	err := ctx.DB.Debug().
-		Table(c.getTable(ctx)).
+	err = ctx.DB.Debug().
+		Table(table).
 		Where("status IN (?)", statusList).
 		Where("id IN (?)", flowInstanceIds).
 		Updates(newValues).Error
@@ -397,7 +475,7 @@ func (c *Card) ReassignCard(ctx *context.Context, req *dtos.ReExecCard) (*models
 
 	var updatedCard *models.Card
 
-	err = ctx.DB.Debug().Table(c.getTable(ctx)).
+	err = ctx.DB.Debug().Table(table).
 		Where("status IN (?)", statusList).
 		Where("id in (?)", flowInstanceIds).
 		Find(&updatedCard).
@@ -411,6 +489,11 @@ func (c *Card) ReassignCard(ctx *context.Context, req *dtos.ReExecCard) (*models
 }
 
 func (c *Card) EscalateCard(ctx *context.Context, filter *dtos.ReExecCard, EscalatedByList pq.StringArray, id string) error {
+	table, err := c.getTable(ctx)
+	if err != nil {
+		return err
+	}
+
 	newValues := map[string]interface{}{
 		"escalated":              true,
 		"escalated_to":           filter.EscalateTo,
@@ -420,7 +503,7 @@ func (c *Card) EscalateCard(ctx *context.Context, filter *dtos.ReExecCard, Escal
 		"escalation_description": filter.EscalationRemarks,
 		"updated_at":             time.Now().UTC(),
 	}
-	err := ctx.DB.Debug().Table(c.getTable(ctx)).Where("status not in ('Completed','Delete') and id = ?", id).Updates(newValues).Error
+	err = ctx.DB.Debug().Table(table).Where("status not in ('Completed','Delete') and id = ?", id).Updates(newValues).Error
 	if err != nil {
 		ctx.Log.Error("Error while escalating card in DB", zap.Error(err))
 	}
@@ -430,8 +513,13 @@ func (c *Card) EscalateCard(ctx *context.Context, filter *dtos.ReExecCard, Escal
 
 func (c *Card) GetNonEscalatedCards(ctx *context.Context, filter *models.Card, statuslist []string, nonesc bool) ([]models.Card, error) {
 
+	table, err := c.getTable(ctx)
+	if err != nil {
+		return nil, err
+	}
+
 	var results []models.Card
-	query := ctx.DB.Debug().Table(c.getTable(ctx)).Where(&filter)
+	query := ctx.DB.Debug().Table(table).Where(&filter)
 	if nonesc {
 		query = query.Where("escalated = false")
 	}
@@ -440,7 +528,7 @@ func (c *Card) GetNonEscalatedCards(ctx *context.Context, filter *models.Card, s
 		query = query.Where("status in (?)", statuslist)
 	}
 
-	err := query.Find(&results).Error
+	err = query.Find(&results).Error
 	if err != nil {
 		ctx.Log.Error("Error while FilterCards", zap.Any("filter", filter), zap.Error(err))
 		return nil, err
@@ -451,9 +539,14 @@ func (c *Card) GetNonEscalatedCards(ctx *context.Context, filter *models.Card, s
 
 func (t *Card) GetDistinctColumnDetails(ctx *context.Context, label, value, cardType, querry string, ids, cardStatus []string) ([]dtos.CardLabelLists, error) {
 
+	table, err := t.getTable(ctx)
+	if err != nil {
+		return nil, err
+	}
+
 	var results []dtos.CardLabelLists
 
-	err := ctx.DB.Debug().WithContext(ctx.Request.Context()).Table(t.getTable(ctx)).Distinct(" cards."+label+" AS label , "+"cards."+value+" AS value, '"+cardType+"' AS type ").Where("cards.assigned_to IN (?) and cards.status IN (?) and cards."+label+" ILIKE (?) AND cards.visible_time <= NOW()", ids, cardStatus, "%"+querry+"%").Find(&results).Error
+	err = ctx.DB.Debug().WithContext(ctx.Request.Context()).Table(table).Distinct(" cards."+label+" AS label , "+"cards."+value+" AS value, '"+cardType+"' AS type ").Where("cards.assigned_to IN (?) and cards.status IN (?) and cards."+label+" ILIKE (?) AND cards.visible_time <= NOW()", ids, cardStatus, "%"+querry+"%").Find(&results).Error
 	if err != nil {
 		ctx.Log.Error("error while getting distinct column details", zap.Error(err))
 		return nil, err
@@ -463,6 +556,15 @@ func (t *Card) GetDistinctColumnDetails(ctx *context.Context, label, value, card
 }
 
 func (t *Card) GetDistinctColumnDetailsInstanceData(ctx *context.Context, val dtos.CardLabelLists, filter *dtos.FilterCardLabel, ids, cardStatus []string) ([]dtos.CardLabelLists, error) {
+	rfqsTable, err := t.getRfqsTable(ctx)
+	if err != nil {
+		return nil, err
+	}
+	shipmentsTable, err := t.getShipmentsTable(ctx)
+	if err != nil {
+		return nil, err
+	}
+
 	var results []dtos.CardLabelLists
 
 	queryFunc := func(table, instanceType string) ([]dtos.CardLabelLists, error) {
@@ -483,30 +585,30 @@ func (t *Card) GetDistinctColumnDetailsInstanceData(ctx *context.Context, val dt
 	getTable := func(forType string) (string, string) {
 		switch forType {
 		case constants.WorkflowTypeRFQ:
-			return t.getRfqsTable(ctx), constants.WorkflowTypeRFQ
+			return rfqsTable, constants.WorkflowTypeRFQ
 		case constants.WorkflowTypeShipment:
-			return t.getShipmentsTable(ctx), constants.WorkflowTypeShipment
+			return shipmentsTable, constants.WorkflowTypeShipment
 		case constants.WorkflowTypeCONSOL:
-			return t.getShipmentsTable(ctx), constants.WorkflowTypeCONSOL
+			return shipmentsTable, constants.WorkflowTypeCONSOL
 		default:
 			return "", ""
 		}
 	}
 
 	if val.ForType == "All" {
-		rfqResults, err := queryFunc(t.getRfqsTable(ctx), constants.WorkflowTypeRFQ)
+		rfqResults, err := queryFunc(rfqsTable, constants.WorkflowTypeRFQ)
 		if err != nil {
 			return nil, err
 		}
 		results = append(results, rfqResults...)
 
-		shipmentResults, err := queryFunc(t.getShipmentsTable(ctx), constants.WorkflowTypeShipment)
+		shipmentResults, err := queryFunc(shipmentsTable, constants.WorkflowTypeShipment)
 		if err != nil {
 			return nil, err
 		}
 		results = append(results, shipmentResults...)
 
-		consolShipmentResults, err := queryFunc(t.getShipmentsTable(ctx), constants.WorkflowTypeCONSOL)
+		consolShipmentResults, err := queryFunc(shipmentsTable, constants.WorkflowTypeCONSOL)
 		if err != nil {
 			return nil, err
 		}
@@ -529,10 +631,15 @@ func (t *Card) GetDistinctColumnDetailsInstanceData(ctx *context.Context, val dt
 }
 
 func (c *Card) GetCardsUsingMulti(ctx *context.Context, cardIds []string) ([]models.Card, error) {
+	table, err := c.getTable(ctx)
+	if err != nil {
+		return nil, err
+	}
+
 	var results []models.Card
 	statusList := []string{constants.CardStatusBreached, constants.CardStatusCreated, constants.CardStatusWarning}
 
-	err := ctx.DB.Debug().WithContext(ctx.Request.Context()).Table(c.getTable(ctx)).Where("status in (?)", statusList).Where("id in (?)", cardIds).Find(&results).Error
+	err = ctx.DB.Debug().WithContext(ctx.Request.Context()).Table(table).Where("status in (?)", statusList).Where("id in (?)", cardIds).Find(&results).Error
 	if err != nil {
 		ctx.Log.Error("Unable to get cards.", zap.Error(err))
 		return nil, err
@@ -542,13 +649,18 @@ func (c *Card) GetCardsUsingMulti(ctx *context.Context, cardIds []string) ([]mod
 }
 
 func (c *Card) GetAllPendingCards(ctx *context.Context) ([]models.Card, error) {
+	table, err := c.getTable(ctx)
+	if err != nil {
+		return nil, err
+	}
+
 	var results []models.Card
 	excludedStatuses := []string{
 		constants.CardStatusCompleted,
 		constants.CardStatusDeleted,
 		dtos.ActionDelete,
 	}
-	err := ctx.DB.Debug().WithContext(ctx.Request.Context()).Table(c.getTable(ctx)).Where("status NOT IN (?)", excludedStatuses).Find(&results).Error
+	err = ctx.DB.Debug().WithContext(ctx.Request.Context()).Table(table).Where("status NOT IN (?)", excludedStatuses).Find(&results).Error
 	if err != nil {
 		ctx.Log.Error("error while getting pending cards.", zap.Error(err))
 		return nil, err
@@ -559,7 +671,12 @@ func (c *Card) GetAllPendingCards(ctx *context.Context) ([]models.Card, error) {
 
 func (c *Card) UpdateStatus(ctx *context.Context, id, status string) error {
 
-	err := ctx.DB.Debug().WithContext(ctx.Request.Context()).Table(c.getTable(ctx)).Where("id = ?", id).Update("status", status).Error
+	table, err := c.getTable(ctx)
+	if err != nil {
+		return err
+	}
+
+	err = ctx.DB.Debug().WithContext(ctx.Request.Context()).Table(table).Where("id = ?", id).Update("status", status).Error
 	if err != nil {
 		ctx.Log.Error("error in updating card", zap.Any("card_id", id), zap.Any("status", status), zap.Error(err))
 		return err
@@ -569,9 +686,14 @@ func (c *Card) UpdateStatus(ctx *context.Context, id, status string) error {
 }
 
 func (c *Card) GetCardsFiltered(ctx *context.Context, req *models.Card) ([]*models.Card, error) {
+	table, err := c.getTable(ctx)
+	if err != nil {
+		return nil, err
+	}
+
 	var results []*models.Card
 
-	q := ctx.DB.Debug().WithContext(ctx.Request.Context()).Table(c.getTable(ctx))
+	q := ctx.DB.Debug().WithContext(ctx.Request.Context()).Table(table)
 
 	if req.CompanyId != "" {
 		q = q.Where("company_id = ?", req.CompanyId)
@@ -601,7 +723,7 @@ func (c *Card) GetCardsFiltered(ctx *context.Context, req *models.Card) ([]*mode
 		q = q.Where("instance_type = ?", req.InstanceType)
 	}
 
-	err := q.Find(&results).Error
+	err = q.Find(&results).Error
 	if err != nil {
 		ctx.Log.Error("error while getting pending cards.", zap.Error(err))
 		return nil, err
@@ -612,9 +734,14 @@ func (c *Card) GetCardsFiltered(ctx *context.Context, req *models.Card) ([]*mode
 
 func (c *Card) GetBulkCardsByCompanyId(ctx *context.Context, cids []string) ([]*models.Card, error) {
 
+	table, err := c.getTable(ctx)
+	if err != nil {
+		return nil, err
+	}
+
 	var results []*models.Card
 
-	err := ctx.DB.Debug().WithContext(ctx.Request.Context()).Table(c.getTable(ctx)).Where("company_id IN (?)", cids).Find(&results).Error
+	err = ctx.DB.Debug().WithContext(ctx.Request.Context()).Table(table).Where("company_id IN (?)", cids).Find(&results).Error
 	if err != nil {
 		ctx.Log.Error("error while getting bulk cards.", zap.Error(err))
 		return nil, err
@@ -655,9 +782,14 @@ func (c *Card) UpsertCardById(ctx *context.Context, card *models.Card, action st
 }
 
 func (c *Card) GetBulkCardsFiltered(ctx *context.Context, filters *models.BulkCardsFilters) ([]*models.Card, error) {
+	table, err := c.getTable(ctx)
+	if err != nil {
+		return nil, err
+	}
+
 	var results []*models.Card
 
-	q := ctx.DB.Debug().WithContext(ctx.Request.Context()).Table(c.getTable(ctx))
+	q := ctx.DB.Debug().WithContext(ctx.Request.Context()).Table(table)
 
 	if len(filters.InstanceIds) > 0 {
 		q = q.Where("instance_id IN (?)", filters.InstanceIds)
@@ -671,7 +803,7 @@ func (c *Card) GetBulkCardsFiltered(ctx *context.Context, filters *models.BulkCa
 		q = q.Where("name IN (?)", filters.Names)
 	}
 
-	err := q.Find(&results).Error
+	err = q.Find(&results).Error
 	if err != nil {
 		ctx.Log.Error("error while getting pending cards.", zap.Error(err))
 		return nil, err
@@ -682,8 +814,13 @@ func (c *Card) GetBulkCardsFiltered(ctx *context.Context, filters *models.BulkCa
 
 func (c *Card) DeleteBookingRequestByID(ctx *context.Context, id string, updatedAt time.Time) (bool, error) {
 
+	table, err := c.getTable(ctx)
+	if err != nil {
+		return false, err
+	}
+
 	tx := ctx.DB.Debug().WithContext(ctx.Request.Context()).
-		Table(c.getTable(ctx)).
+		Table(table).
 		Where("instance_id = ? AND status NOT IN ('Completed', 'Delete')", id).
 		Updates(map[string]interface{}{
 			"status":     constants.ActionDelete,
@@ -705,6 +842,11 @@ func (c *Card) DeleteBookingRequestByID(ctx *context.Context, id string, updated
 
 func (t *Card) BulkUpsert(ctx *context.Context, cardIds []string) error {
 
+	table, err := t.getTable(ctx)
+	if err != nil {
+		return err
+	}
+
 	if ctx == nil || ctx.DB == nil {
 		return errors.New("invalid context or database connection")
 	}
@@ -718,7 +860,7 @@ func (t *Card) BulkUpsert(ctx *context.Context, cardIds []string) error {
 	}
 
 	tx := ctx.DB.WithContext(ctx.Request.Context()).
-		Table(t.getTable(ctx)).
+		Table(table).
 		Where("id IN (?)", cardIds).
 		Updates(map[string]interface{}{
 			"status":       dtos.ActionDelete,
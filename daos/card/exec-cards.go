@@ -7,8 +7,13 @@ import (
 )
 
 func (t *Card) GetPendingTasksCountForExec(ctx *context.Context, execID, name string) (int, error) {
+	rfqsTable, err := t.getRfqsTable(ctx)
+	if err != nil {
+		return 0, err
+	}
+
 	var count int
-	err := ctx.DB.WithContext(ctx.Request.Context()).Table(t.getRfqsTable(ctx)+" as rfqs").
+	err = ctx.DB.WithContext(ctx.Request.Context()).Table(rfqsTable+" as rfqs").
 		Select("count(c.id)").
 		Joins("JOIN cards c ON (rfqs.id)::TEXT = c.instance_id AND c.name = ? AND c.assigned_to = ?", name, execID).
 		Joins("LEFT JOIN cards c2 ON (rfqs.id)::TEXT = c2.instance_id AND c2.name = ? AND c2.assigned_to != ?", name, execID).
@@ -27,8 +32,13 @@ func (t *Card) GetPendingTasksCountForExec(ctx *context.Context, execID, name st
 }
 
 func (t *Card) GetExpiredTasksCountForExec(ctx *context.Context, execID, name string) (int, error) {
+	table, err := t.getTable(ctx)
+	if err != nil {
+		return 0, err
+	}
+
 	var result int
-	err := ctx.DB.WithContext(ctx.Request.Context()).Table(t.getTable(ctx)).
+	err = ctx.DB.WithContext(ctx.Request.Context()).Table(table).
 		Joins("JOIN rfqs on ((rfqs.id)::TEXT = cards.instance_id)").
 		Select("count(cards.id)").Where("cards.assigned_to = ?", execID).
 		Where("rfqs.status ilike ?", "%"+constants.RfqStatusBuyTBA).
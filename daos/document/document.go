@@ -2,6 +2,7 @@ package document
 
 import (
 	"bitbucket.org/radarventures/forwarder-adapters/utils/context"
+	"bitbucket.org/radarventures/forwarder-shipments/daos/tenant"
 	"bitbucket.org/radarventures/forwarder-shipments/database/models"
 	"github.com/google/uuid"
 	"go.uber.org/zap"
@@ -23,17 +24,27 @@ func NewDocument() IDocument {
 	return &Document{}
 }
 
-func (t *Document) getTable(ctx *context.Context) string {
-	return ctx.TenantID + "." + "documents"
+func (t *Document) getTable(ctx *context.Context) (string, error) {
+	return tenant.Table(ctx, "documents")
 }
 
 func (t *Document) Upsert(ctx *context.Context, m ...*models.Document) error {
-	return ctx.DB.Table(t.getTable(ctx)).Save(m).Error
+	table, err := t.getTable(ctx)
+	if err != nil {
+		return err
+	}
+
+	return ctx.DB.Table(table).Save(m).Error
 }
 
 func (t *Document) Get(ctx *context.Context, id uuid.UUID) (*models.Document, error) {
+	table, err := t.getTable(ctx)
+	if err != nil {
+		return nil, err
+	}
+
 	var result models.Document
-	err := ctx.DB.WithContext(ctx.Request.Context()).Table(t.getTable(ctx)).First(&result, "id = ?", id).Error
+	err = ctx.DB.WithContext(ctx.Request.Context()).Table(table).First(&result, "id = ?", id).Error
 	if err != nil {
 		ctx.Log.Error("Unable to get documents.", zap.Error(err))
 		return nil, err
@@ -43,8 +54,12 @@ func (t *Document) Get(ctx *context.Context, id uuid.UUID) (*models.Document, er
 }
 
 func (t *Document) GetWithFilters(ctx *context.Context, documentIds, instanceIds []uuid.UUID, name, owner []string, nameLike string) ([]*models.Document, error) {
+	table, err := t.getTable(ctx)
+	if err != nil {
+		return nil, err
+	}
 
-	tx := ctx.DB.Debug().Table(t.getTable(ctx))
+	tx := ctx.DB.Debug().Table(table)
 
 	if len(documentIds) > 0 {
 		tx.Where("document_id IN (?)", documentIds)
@@ -70,7 +85,7 @@ func (t *Document) GetWithFilters(ctx *context.Context, documentIds, instanceIds
 
 	var result []*models.Document
 
-	err := tx.Find(&result).Error
+	err = tx.Find(&result).Error
 	if err != nil {
 		ctx.Log.Error("Unable to get documents.", zap.Error(err))
 		return nil, err
@@ -80,8 +95,12 @@ func (t *Document) GetWithFilters(ctx *context.Context, documentIds, instanceIds
 }
 
 func (t *Document) GetForShipment(ctx *context.Context, instanceId string, documentIds []string, name, owner []string, q, regionId string) ([]*models.Document, error) {
+	table, err := t.getTable(ctx)
+	if err != nil {
+		return nil, err
+	}
 
-	tx := ctx.DB.Debug().Table(t.getTable(ctx))
+	tx := ctx.DB.Debug().Table(table)
 
 	if instanceId != "" {
 		tx.Where("instance_id = ?", instanceId)
@@ -112,7 +131,7 @@ func (t *Document) GetForShipment(ctx *context.Context, instanceId string, docum
 
 	var result []*models.Document
 
-	err := tx.Find(&result).Error
+	err = tx.Find(&result).Error
 	if err != nil {
 		ctx.Log.Error("Unable to get documents.", zap.Error(err))
 		return nil, err
@@ -122,8 +141,13 @@ func (t *Document) GetForShipment(ctx *context.Context, instanceId string, docum
 }
 
 func (t *Document) DeleteByInstanceId(ctx *context.Context, instanceId string) error {
+	table, err := t.getTable(ctx)
+	if err != nil {
+		return err
+	}
+
 	var result models.Document
-	err := ctx.DB.WithContext(ctx.Request.Context()).Table(t.getTable(ctx)).Delete(&result, "instance_id = ?", instanceId).Error
+	err = ctx.DB.WithContext(ctx.Request.Context()).Table(table).Delete(&result, "instance_id = ?", instanceId).Error
 	if err != nil {
 		ctx.Log.Error("Unable to delete documents.", zap.Error(err))
 		return err
@@ -133,8 +157,13 @@ func (t *Document) DeleteByInstanceId(ctx *context.Context, instanceId string) e
 }
 
 func (t *Document) DeleteByFlowInstanceId(ctx *context.Context, instanceId string, flowInstanceId string) error {
+	table, err := t.getTable(ctx)
+	if err != nil {
+		return err
+	}
+
 	var result models.Document
-	err := ctx.DB.WithContext(ctx.Request.Context()).Table(t.getTable(ctx)).Delete(&result, "instance_id = ? AND flow_instance_id = ?", instanceId, flowInstanceId).Error
+	err = ctx.DB.WithContext(ctx.Request.Context()).Table(table).Delete(&result, "instance_id = ? AND flow_instance_id = ?", instanceId, flowInstanceId).Error
 
 	if err != nil {
 		ctx.Log.Error("Unable to delete documents.", zap.Error(err))
@@ -0,0 +1,101 @@
+package documentcounter
+
+import (
+	"errors"
+	"time"
+
+	"bitbucket.org/radarventures/forwarder-adapters/utils/context"
+	"bitbucket.org/radarventures/forwarder-shipments/daos/tenant"
+	"bitbucket.org/radarventures/forwarder-shipments/database/models"
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// SeedFunc returns the last number issued before a counter existed, so a new counter carries on
+// from the documents already numbered the old way. It runs in the transaction issuing the
+// number. Counters with a period start every period from 1 and are not seeded.
+type SeedFunc func(tx *gorm.DB) (int64, error)
+
+type IDocumentCounter interface {
+	NextWithTx(ctx *context.Context, tx *gorm.DB, key *models.DocumentCounterKey, seed SeedFunc) (int64, error)
+}
+
+type DocumentCounter struct {
+}
+
+func NewDocumentCounter() IDocumentCounter {
+	return &DocumentCounter{}
+}
+
+func (t *DocumentCounter) getTable(ctx *context.Context) (string, error) {
+	return tenant.Table(ctx, "document_counters")
+}
+
+// NextWithTx issues the next number of the key. The counter row stays locked until tx ends, so
+// concurrent requests are serialised and never get the same number, and a rollback of the
+// document also returns its number.
+func (t *DocumentCounter) NextWithTx(ctx *context.Context, tx *gorm.DB, key *models.DocumentCounterKey, seed SeedFunc) (int64, error) {
+	table, err := t.getTable(ctx)
+	if err != nil {
+		return 0, err
+	}
+
+	// Serialise the first number of a key, when there is no row to lock yet
+	err = tx.Exec("SELECT pg_advisory_xact_lock(hashtext(?))", table+":"+key.DocType+":"+key.Scope+":"+key.Period).Error
+	if err != nil {
+		ctx.Log.Error("Failed to acquire document counter lock.", zap.Error(err))
+		return 0, err
+	}
+
+	counter := models.DocumentCounter{}
+	err = tx.Table(table).
+		Clauses(clause.Locking{Strength: "UPDATE"}).
+		Where("doc_type = ? AND scope = ? AND period = ?", key.DocType, key.Scope, key.Period).
+		First(&counter).Error
+
+	if err != nil && errors.Is(err, gorm.ErrRecordNotFound) {
+		counter = models.DocumentCounter{
+			DocType: key.DocType,
+			Scope:   key.Scope,
+			Period:  key.Period,
+		}
+
+		if seed != nil && key.Period == "" {
+			counter.LastValue, err = seed(tx)
+			if err != nil {
+				ctx.Log.Error("Failed to seed document counter.", zap.String("doc_type", key.DocType), zap.String("scope", key.Scope), zap.Error(err))
+				return 0, err
+			}
+		}
+
+		counter.LastValue++
+		counter.UpdatedAt = time.Now().UTC()
+		err = tx.Table(table).Create(&counter).Error
+		if err != nil {
+			ctx.Log.Error("Failed to create document counter.", zap.Error(err))
+			return 0, err
+		}
+
+		return counter.LastValue, nil
+	}
+
+	if err != nil {
+		ctx.Log.Error("Failed to get document counter.", zap.Error(err))
+		return 0, err
+	}
+
+	counter.LastValue++
+	err = tx.Table(table).
+		Where("doc_type = ? AND scope = ? AND period = ?", key.DocType, key.Scope, key.Period).
+		UpdateColumns(map[string]interface{}{
+			"last_value": counter.LastValue,
+			"updated_at": time.Now().UTC(),
+		}).Error
+	if err != nil {
+		ctx.Log.Error("Failed to update document counter.", zap.Error(err))
+		return 0, err
+	}
+
+	return counter.LastValue, nil
+}
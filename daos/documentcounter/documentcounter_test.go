@@ -0,0 +1,137 @@
+package documentcounter_test
+
+import (
+	"net/http/httptest"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+
+	"bitbucket.org/radarventures/forwarder-adapters/utils/context"
+	"bitbucket.org/radarventures/forwarder-adapters/utils/db"
+	ulog "bitbucket.org/radarventures/forwarder-adapters/utils/log"
+	"bitbucket.org/radarventures/forwarder-shipments/constants"
+	"bitbucket.org/radarventures/forwarder-shipments/daos/documentcounter"
+	"bitbucket.org/radarventures/forwarder-shipments/database/models"
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+const documentCounterTable = `CREATE TABLE %s.document_counters (
+	doc_type text, scope text, period text, last_value bigint, updated_at timestamptz,
+	PRIMARY KEY (doc_type, scope, period))`
+
+// The counter tests need a Postgres database, TEST_DATABASE_URL points at one. The tenant is a
+// schema of its own that is dropped afterwards.
+func tenantContext(t *testing.T) *context.Context {
+	t.Helper()
+
+	url := os.Getenv("TEST_DATABASE_URL")
+	if url == "" {
+		t.Skip("TEST_DATABASE_URL is not set")
+	}
+
+	db.Init(&db.Config{URL: url, MaxDBConn: 20})
+
+	c := &context.Context{}
+	c.RefID = uuid.New().String()
+	c.Log = ulog.New(c.RefID, "forwarder-shipments", "error")
+	c.DB = db.New()
+	c.TenantID = "tenant_" + strings.ReplaceAll(uuid.New().String(), "-", "")
+	c.Context, _ = gin.CreateTestContext(httptest.NewRecorder())
+	c.Context.Request = httptest.NewRequest("GET", "/document-counters", nil)
+
+	if err := c.DB.Exec("CREATE SCHEMA " + c.TenantID).Error; err != nil {
+		t.Fatalf("create schema: %v", err)
+	}
+	t.Cleanup(func() {
+		c.DB.Exec("DROP SCHEMA " + c.TenantID + " CASCADE")
+	})
+
+	if err := c.DB.Exec(strings.ReplaceAll(documentCounterTable, "%s", c.TenantID)).Error; err != nil {
+		t.Fatalf("create table: %v", err)
+	}
+
+	return c
+}
+
+func TestNextWithTxConcurrent(t *testing.T) {
+	ctx := tenantContext(t)
+
+	const issues = 25
+	key := &models.DocumentCounterKey{DocType: constants.DocTypeHAWB, Scope: "BOM"}
+	seed := func(tx *gorm.DB) (int64, error) {
+		return 100, nil
+	}
+
+	var (
+		wg     sync.WaitGroup
+		mu     sync.Mutex
+		issued = map[int64]int{}
+		errs   []error
+	)
+	for i := 0; i < issues; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			var no int64
+			err := ctx.DB.Transaction(func(tx *gorm.DB) error {
+				var err error
+				no, err = documentcounter.NewDocumentCounter().NextWithTx(ctx, tx, key, seed)
+				return err
+			})
+
+			mu.Lock()
+			defer mu.Unlock()
+			if err != nil {
+				errs = append(errs, err)
+				return
+			}
+			issued[no]++
+		}()
+	}
+	wg.Wait()
+
+	if len(errs) > 0 {
+		t.Fatalf("issue failed: %v", errs[0])
+	}
+
+	for no := int64(101); no <= 100+issues; no++ {
+		if issued[no] != 1 {
+			t.Fatalf("number %d issued %d times, want once; issued = %v", no, issued[no], issued)
+		}
+	}
+}
+
+func TestNextWithTxRollbackReturnsNumber(t *testing.T) {
+	ctx := tenantContext(t)
+
+	key := &models.DocumentCounterKey{DocType: constants.DocTypeDoBl}
+	counterDb := documentcounter.NewDocumentCounter()
+
+	next := func(rollback bool) int64 {
+		var no int64
+		err := ctx.DB.Transaction(func(tx *gorm.DB) error {
+			var err error
+			no, err = counterDb.NextWithTx(ctx, tx, key, nil)
+			if err == nil && rollback {
+				return gorm.ErrInvalidTransaction
+			}
+			return err
+		})
+		if err != nil && !rollback {
+			t.Fatalf("issue: %v", err)
+		}
+		return no
+	}
+
+	if no := next(false); no != 1 {
+		t.Fatalf("first number = %d, want 1", no)
+	}
+	next(true)
+	if no := next(false); no != 2 {
+		t.Fatalf("number after a rolled back issue = %d, want 2", no)
+	}
+}
@@ -0,0 +1,201 @@
+package dsr
+
+import (
+	"time"
+
+	"bitbucket.org/radarventures/forwarder-adapters/utils/context"
+	"bitbucket.org/radarventures/forwarder-shipments/daos/tenant"
+	"bitbucket.org/radarventures/forwarder-shipments/database/models"
+	"github.com/google/uuid"
+	"go.uber.org/zap"
+)
+
+type IDSR interface {
+	Save(ctx *context.Context, m *models.DSRSubscription) error
+	Get(ctx *context.Context, id uuid.UUID) (*models.DSRSubscription, error)
+	GetByCompany(ctx *context.Context, companyId uuid.UUID) ([]*models.DSRSubscription, error)
+	Delete(ctx *context.Context, id uuid.UUID) error
+	GetDue(ctx *context.Context, now time.Time) ([]*models.DSRSubscription, error)
+	ClaimRun(ctx *context.Context, id uuid.UUID, nextRunAt time.Time, newNextRunAt time.Time) (bool, error)
+	ReleaseRun(ctx *context.Context, id uuid.UUID, claimedNextRunAt time.Time, nextRunAt time.Time) error
+	SetLastSent(ctx *context.Context, id uuid.UUID, sentAt time.Time) error
+
+	CreateDelivery(ctx *context.Context, m *models.DSRDelivery) error
+	GetDeliveries(ctx *context.Context, subscriptionId uuid.UUID) ([]*models.DSRDelivery, error)
+}
+
+type DSR struct {
+}
+
+func NewDSR() IDSR {
+	return &DSR{}
+}
+
+func (t *DSR) getTable(ctx *context.Context) (string, error) {
+	return tenant.Table(ctx, "dsr_subscriptions")
+}
+
+func (t *DSR) getDeliveriesTable(ctx *context.Context) (string, error) {
+	return tenant.Table(ctx, "dsr_deliveries")
+}
+
+func (t *DSR) Save(ctx *context.Context, m *models.DSRSubscription) error {
+	table, err := t.getTable(ctx)
+	if err != nil {
+		return err
+	}
+
+	return ctx.DB.WithContext(ctx.Request.Context()).Table(table).Save(m).Error
+}
+
+func (t *DSR) Get(ctx *context.Context, id uuid.UUID) (*models.DSRSubscription, error) {
+	table, err := t.getTable(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var result models.DSRSubscription
+	err = ctx.DB.WithContext(ctx.Request.Context()).Table(table).First(&result, "id = ?", id).Error
+	if err != nil {
+		ctx.Log.Error("Unable to get DSR subscription.", zap.Any("id", id), zap.Error(err))
+		return nil, err
+	}
+
+	return &result, nil
+}
+
+func (t *DSR) GetByCompany(ctx *context.Context, companyId uuid.UUID) ([]*models.DSRSubscription, error) {
+	table, err := t.getTable(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var result []*models.DSRSubscription
+	err = ctx.DB.WithContext(ctx.Request.Context()).Table(table).
+		Where("company_id = ?", companyId).
+		Order("created_at").
+		Find(&result).Error
+	if err != nil {
+		ctx.Log.Error("Unable to get DSR subscriptions.", zap.Any("company_id", companyId), zap.Error(err))
+		return nil, err
+	}
+
+	return result, nil
+}
+
+func (t *DSR) Delete(ctx *context.Context, id uuid.UUID) error {
+	table, err := t.getTable(ctx)
+	if err != nil {
+		return err
+	}
+
+	return ctx.DB.WithContext(ctx.Request.Context()).Table(table).Delete(&models.DSRSubscription{}, "id = ?", id).Error
+}
+
+func (t *DSR) GetDue(ctx *context.Context, now time.Time) ([]*models.DSRSubscription, error) {
+	table, err := t.getTable(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var result []*models.DSRSubscription
+	err = ctx.DB.WithContext(ctx.Request.Context()).Table(table).
+		Where("is_active = true AND next_run_at <= ?", now).
+		Order("next_run_at").
+		Find(&result).Error
+	if err != nil {
+		ctx.Log.Error("Unable to get due DSR subscriptions.", zap.Error(err))
+		return nil, err
+	}
+
+	return result, nil
+}
+
+// ClaimRun moves a subscription to its next run before the report is sent. It reports false
+// when another run already moved it, so a report is not sent twice.
+func (t *DSR) ClaimRun(ctx *context.Context, id uuid.UUID, nextRunAt time.Time, newNextRunAt time.Time) (bool, error) {
+	table, err := t.getTable(ctx)
+	if err != nil {
+		return false, err
+	}
+
+	res := ctx.DB.WithContext(ctx.Request.Context()).Table(table).
+		Where("id = ? AND next_run_at = ?", id, nextRunAt).
+		UpdateColumns(map[string]interface{}{
+			"next_run_at": newNextRunAt,
+		})
+	if res.Error != nil {
+		ctx.Log.Error("Unable to claim DSR run.", zap.Any("id", id), zap.Error(res.Error))
+		return false, res.Error
+	}
+
+	return res.RowsAffected > 0, nil
+}
+
+// ReleaseRun moves a claimed subscription back to the run it was claimed from, so a report that
+// could not be sent is retried by the next run of the job.
+func (t *DSR) ReleaseRun(ctx *context.Context, id uuid.UUID, claimedNextRunAt time.Time, nextRunAt time.Time) error {
+	table, err := t.getTable(ctx)
+	if err != nil {
+		return err
+	}
+
+	err = ctx.DB.WithContext(ctx.Request.Context()).Table(table).
+		Where("id = ? AND next_run_at = ?", id, claimedNextRunAt).
+		UpdateColumns(map[string]interface{}{
+			"next_run_at": nextRunAt,
+		}).Error
+	if err != nil {
+		ctx.Log.Error("Unable to release DSR run.", zap.Any("id", id), zap.Error(err))
+		return err
+	}
+
+	return nil
+}
+
+func (t *DSR) SetLastSent(ctx *context.Context, id uuid.UUID, sentAt time.Time) error {
+	table, err := t.getTable(ctx)
+	if err != nil {
+		return err
+	}
+
+	return ctx.DB.WithContext(ctx.Request.Context()).Table(table).
+		Where("id = ?", id).
+		UpdateColumns(map[string]interface{}{
+			"last_sent_at": sentAt,
+		}).Error
+}
+
+func (t *DSR) CreateDelivery(ctx *context.Context, m *models.DSRDelivery) error {
+	deliveriesTable, err := t.getDeliveriesTable(ctx)
+	if err != nil {
+		return err
+	}
+
+	err = ctx.DB.WithContext(ctx.Request.Context()).Table(deliveriesTable).Create(m).Error
+	if err != nil {
+		ctx.Log.Error("Unable to create DSR delivery.", zap.Any("subscription_id", m.SubscriptionId), zap.Error(err))
+		return err
+	}
+
+	return nil
+}
+
+func (t *DSR) GetDeliveries(ctx *context.Context, subscriptionId uuid.UUID) ([]*models.DSRDelivery, error) {
+	deliveriesTable, err := t.getDeliveriesTable(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var result []*models.DSRDelivery
+	err = ctx.DB.WithContext(ctx.Request.Context()).Table(deliveriesTable).
+		Where("subscription_id = ?", subscriptionId).
+		Order("created_at desc").
+		Find(&result).Error
+	if err != nil {
+		ctx.Log.Error("Unable to get DSR deliveries.", zap.Any("subscription_id", subscriptionId), zap.Error(err))
+		return nil, err
+	}
+
+	return result, nil
+}
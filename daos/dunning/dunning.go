@@ -0,0 +1,122 @@
+package dunning
+
+import (
+	"bitbucket.org/radarventures/forwarder-adapters/utils/context"
+	"bitbucket.org/radarventures/forwarder-shipments/daos/tenant"
+	"bitbucket.org/radarventures/forwarder-shipments/database/models"
+	"github.com/google/uuid"
+	"go.uber.org/zap"
+	"gorm.io/gorm/clause"
+)
+
+type IDunning interface {
+	UpsertContact(ctx *context.Context, m *models.DunningContact) error
+	GetContacts(ctx *context.Context, companyIds []string) ([]*models.DunningContact, error)
+
+	CreateLog(ctx *context.Context, m *models.DunningLog) (bool, error)
+	DeleteLog(ctx *context.Context, id uuid.UUID) error
+	GetLogs(ctx *context.Context, invoiceIds []string) ([]*models.DunningLog, error)
+}
+
+type Dunning struct {
+}
+
+func NewDunning() IDunning {
+	return &Dunning{}
+}
+
+func (t *Dunning) getContactsTable(ctx *context.Context) (string, error) {
+	return tenant.Table(ctx, "dunning_contacts")
+}
+
+func (t *Dunning) getLogsTable(ctx *context.Context) (string, error) {
+	return tenant.Table(ctx, "dunning_logs")
+}
+
+func (t *Dunning) UpsertContact(ctx *context.Context, m *models.DunningContact) error {
+	table, err := t.getContactsTable(ctx)
+	if err != nil {
+		return err
+	}
+
+	return ctx.DB.WithContext(ctx.Request.Context()).Table(table).
+		Clauses(clause.OnConflict{
+			Columns:   []clause.Column{{Name: "company_id"}},
+			DoUpdates: clause.AssignmentColumns([]string{"emails", "updated_by", "updated_at"}),
+		}).Create(m).Error
+}
+
+func (t *Dunning) GetContacts(ctx *context.Context, companyIds []string) ([]*models.DunningContact, error) {
+	table, err := t.getContactsTable(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var result []*models.DunningContact
+	err = ctx.DB.WithContext(ctx.Request.Context()).Table(table).
+		Where("company_id IN (?)", companyIds).
+		Find(&result).Error
+	if err != nil {
+		ctx.Log.Error("Unable to get dunning contacts.", zap.Error(err))
+		return nil, err
+	}
+
+	return result, nil
+}
+
+// CreateLog claims a stage of an invoice before its reminder is sent. It reports false when
+// the stage was already logged, so the customer is not reminded twice.
+func (t *Dunning) CreateLog(ctx *context.Context, m *models.DunningLog) (bool, error) {
+	table, err := t.getLogsTable(ctx)
+	if err != nil {
+		return false, err
+	}
+
+	res := ctx.DB.WithContext(ctx.Request.Context()).Table(table).
+		Clauses(clause.OnConflict{
+			Columns:   []clause.Column{{Name: "invoice_id"}, {Name: "stage"}},
+			DoNothing: true,
+		}).Create(m)
+	if res.Error != nil {
+		ctx.Log.Error("Unable to create dunning log.", zap.Error(res.Error))
+		return false, res.Error
+	}
+
+	return res.RowsAffected > 0, nil
+}
+
+// DeleteLog releases the stage a reminder could not be sent for.
+func (t *Dunning) DeleteLog(ctx *context.Context, id uuid.UUID) error {
+	table, err := t.getLogsTable(ctx)
+	if err != nil {
+		return err
+	}
+
+	err = ctx.DB.WithContext(ctx.Request.Context()).Table(table).
+		Where("id = ?", id).
+		Delete(&models.DunningLog{}).Error
+	if err != nil {
+		ctx.Log.Error("Unable to delete dunning log.", zap.Any("id", id), zap.Error(err))
+	}
+
+	return err
+}
+
+func (t *Dunning) GetLogs(ctx *context.Context, invoiceIds []string) ([]*models.DunningLog, error) {
+	table, err := t.getLogsTable(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var result []*models.DunningLog
+	err = ctx.DB.WithContext(ctx.Request.Context()).Table(table).
+		Where("invoice_id IN (?)", invoiceIds).
+		Order("sent_at").
+		Find(&result).Error
+	if err != nil {
+		ctx.Log.Error("Unable to get dunning logs.", zap.Error(err))
+		return nil, err
+	}
+
+	return result, nil
+}
@@ -1,9 +1,12 @@
 package invoice
 
 import (
+	"encoding/json"
 	"strings"
 
 	"bitbucket.org/radarventures/forwarder-adapters/utils/context"
+	"bitbucket.org/radarventures/forwarder-shipments/constants"
+	"bitbucket.org/radarventures/forwarder-shipments/daos/tenant"
 	"bitbucket.org/radarventures/forwarder-shipments/database/models"
 	"github.com/google/uuid"
 	"go.uber.org/zap"
@@ -30,6 +33,15 @@ type IInvoice interface {
 	Update(ctx *context.Context, m *models.Invoice) error
 	GetTotalCount(ctx *context.Context) (int, error)
 	GetIcaVendorInvoice(ctx *context.Context, shipmentId string, voucherId string) (*models.Invoice, error)
+	GetExistingNumbers(ctx *context.Context, numbers []string) ([]string, error)
+	ExistsNumberWithTx(ctx *context.Context, tx *gorm.DB, number string) (bool, error)
+	GetNumbersMatching(ctx *context.Context, pattern string) ([]string, error)
+	GetByInvoiceRequestId(ctx *context.Context, invoiceRequestId string) ([]*models.Invoice, error)
+	GetVoucherWithTx(ctx *context.Context, tx *gorm.DB, invoiceId uuid.UUID) (*models.InvoiceVoucher, error)
+	CreateNoteWithTx(ctx *context.Context, tx *gorm.DB, note *models.NoteInvoice) error
+	GetOutstanding(ctx *context.Context, filter *models.InvoiceOutstandingFilter) ([]*models.InvoiceWithBalance, error)
+	GetAgeingInvoices(ctx *context.Context, filter *models.ARAgeingFilter) ([]*models.ARAgeingInvoice, error)
+	GetOverdueInvoices(ctx *context.Context, minDaysOverdue int) ([]*models.DunningInvoice, error)
 }
 
 type Invoice struct {
@@ -39,23 +51,26 @@ func NewInvoice() IInvoice {
 	return &Invoice{}
 }
 
-func (t *Invoice) getTable(ctx *context.Context) string {
-	if ctx.TenantID == "" {
-		ctx.TenantID = "public"
-	}
-	return ctx.TenantID + "." + "invoices"
+func (t *Invoice) getTable(ctx *context.Context) (string, error) {
+	return tenant.Table(ctx, "invoices")
 }
 
-func (t *Invoice) getInvoiceLineItemsTable(ctx *context.Context) string {
-	if ctx.TenantID == "" {
-		ctx.TenantID = "public"
-	}
-	return ctx.TenantID + "." + "invoice_line_items"
+func (t *Invoice) getInvoiceLineItemsTable(ctx *context.Context) (string, error) {
+	return tenant.Table(ctx, "invoice_line_items")
+}
+
+func (t *Invoice) getInvoiceBalancesTable(ctx *context.Context) (string, error) {
+	return tenant.Table(ctx, "invoice_balances")
 }
 
 func (t *Invoice) Upsert(ctx *context.Context, m ...*models.Invoice) error {
 
-	err := ctx.DB.Table(t.getTable(ctx)).Save(m).Error
+	table, err := t.getTable(ctx)
+	if err != nil {
+		return err
+	}
+
+	err = ctx.DB.Table(table).Save(m).Error
 	if err != nil {
 		ctx.Log.Error("unable to upsert invoice", zap.Error(err))
 	}
@@ -64,7 +79,12 @@ func (t *Invoice) Upsert(ctx *context.Context, m ...*models.Invoice) error {
 
 func (t *Invoice) UpsertWithTx(ctx *context.Context, tx *gorm.DB, m ...*models.Invoice) error {
 
-	err := tx.Table(t.getTable(ctx)).Save(m).Error
+	table, err := t.getTable(ctx)
+	if err != nil {
+		return err
+	}
+
+	err = tx.Table(table).Save(m).Error
 	if err != nil {
 		ctx.Log.Error("unable to upsert invoice with tx", zap.Error(err))
 	}
@@ -73,9 +93,14 @@ func (t *Invoice) UpsertWithTx(ctx *context.Context, tx *gorm.DB, m ...*models.I
 
 func (t *Invoice) Get(ctx *context.Context, id string) (*models.Invoice, error) {
 
+	table, err := t.getTable(ctx)
+	if err != nil {
+		return nil, err
+	}
+
 	var result models.Invoice
 
-	err := ctx.DB.Table(t.getTable(ctx)).First(&result, "id = ?", id).Error
+	err = ctx.DB.Table(table).First(&result, "id = ?", id).Error
 	if err != nil {
 		ctx.Log.Error("Unable to get invoice.", zap.Error(err))
 		return nil, err
@@ -86,9 +111,14 @@ func (t *Invoice) Get(ctx *context.Context, id string) (*models.Invoice, error)
 
 func (t *Invoice) Delete(ctx *context.Context, id string) error {
 
+	table, err := t.getTable(ctx)
+	if err != nil {
+		return err
+	}
+
 	var result models.Invoice
 
-	err := ctx.DB.Table(t.getTable(ctx)).Delete(&result, "id = ?", id).Error
+	err = ctx.DB.Table(table).Delete(&result, "id = ?", id).Error
 	if err != nil {
 		ctx.Log.Error("Unable to delete invoice.", zap.Error(err))
 		return err
@@ -98,11 +128,16 @@ func (t *Invoice) Delete(ctx *context.Context, id string) error {
 }
 
 func (t *Invoice) GetAll(ctx *context.Context, ids []string, offset, limit int) ([]*models.Invoice, error) {
+	table, err := t.getTable(ctx)
+	if err != nil {
+		return nil, err
+	}
+
 	var result []*models.Invoice
 
 	if len(ids) == 0 {
 
-		tx := ctx.DB.Table(t.getTable(ctx)).Where("vat_treatment = ''").Offset(offset)
+		tx := ctx.DB.Table(table).Where("vat_treatment = ''").Offset(offset)
 
 		if limit != 0 {
 			tx = tx.Limit(limit)
@@ -116,7 +151,7 @@ func (t *Invoice) GetAll(ctx *context.Context, ids []string, offset, limit int)
 		return result, err
 	}
 
-	err := ctx.DB.Table(t.getTable(ctx)).Where("id IN ?", ids).Find(&result).Error
+	err = ctx.DB.Table(table).Where("id IN ?", ids).Find(&result).Error
 	if err != nil {
 		ctx.Log.Error("Unable to get invoices.", zap.Error(err))
 		return nil, err
@@ -127,9 +162,14 @@ func (t *Invoice) GetAll(ctx *context.Context, ids []string, offset, limit int)
 
 func (t *Invoice) GetInvoiceAmount(ctx *context.Context, req models.InvoiceAmount) ([]*models.Invoice, error) {
 
+	table, err := t.getTable(ctx)
+	if err != nil {
+		return nil, err
+	}
+
 	invoices := []*models.Invoice{}
 
-	tx := ctx.DB.Debug().Table(t.getTable(ctx))
+	tx := ctx.DB.Debug().Table(table)
 	if len(req.VoucherTypes) > 0 {
 		tx.Where("voucher_type in (?)", req.VoucherTypes)
 	}
@@ -146,7 +186,7 @@ func (t *Invoice) GetInvoiceAmount(ctx *context.Context, req models.InvoiceAmoun
 		tx.Where("voucher_type NOT in (?)", req.NotVoucherTypes)
 	}
 
-	err := tx.First(&invoices).Error
+	err = tx.First(&invoices).Error
 	if err != nil {
 		return nil, err
 	}
@@ -154,9 +194,14 @@ func (t *Invoice) GetInvoiceAmount(ctx *context.Context, req models.InvoiceAmoun
 }
 
 func (t *Invoice) GetWithFilter(ctx *context.Context, filter *models.Invoice) ([]*models.Invoice, error) {
+	table, err := t.getTable(ctx)
+	if err != nil {
+		return nil, err
+	}
+
 	var result []*models.Invoice
 
-	tx := ctx.DB.Debug().Table(t.getTable(ctx))
+	tx := ctx.DB.Debug().Table(table)
 
 	if filter != nil && filter.CompanyId != uuid.Nil {
 		tx.Where("company_id = ?", filter.CompanyId)
@@ -174,7 +219,7 @@ func (t *Invoice) GetWithFilter(ctx *context.Context, filter *models.Invoice) ([
 		tx.Where("region_id = ?", filter.RegionId)
 	}
 
-	err := tx.Find(&result).Error
+	err = tx.Find(&result).Error
 	if err != nil {
 		ctx.Log.Error("Unable to get invoices.", zap.Error(err))
 		return nil, err
@@ -184,7 +229,12 @@ func (t *Invoice) GetWithFilter(ctx *context.Context, filter *models.Invoice) ([
 }
 
 func (t *Invoice) CheckForGeneratedInvoice(ctx *context.Context, shipmentId, regionId string, invoiceTypes []string) (bool, error) {
-	tx := ctx.DB.Table(t.getTable(ctx)).Where("shipment_id = ?", shipmentId)
+	table, err := t.getTable(ctx)
+	if err != nil {
+		return false, err
+	}
+
+	tx := ctx.DB.Table(table).Where("shipment_id = ?", shipmentId)
 
 	if regionId != "" {
 		tx.Where("region_id = ?", regionId)
@@ -195,7 +245,7 @@ func (t *Invoice) CheckForGeneratedInvoice(ctx *context.Context, shipmentId, reg
 	}
 
 	var isGenerated bool
-	err := ctx.DB.Raw("SELECT EXISTS (?)", tx).Scan(&isGenerated).Error
+	err = ctx.DB.Raw("SELECT EXISTS (?)", tx).Scan(&isGenerated).Error
 	if err != nil {
 		return false, err
 	}
@@ -203,9 +253,14 @@ func (t *Invoice) CheckForGeneratedInvoice(ctx *context.Context, shipmentId, reg
 }
 
 func (t *Invoice) GetForInvoiceByCompanyIds(ctx *context.Context, shipmentId string, companyIds []string, invoiceType string, regionId string) ([]*models.Invoice, error) {
+	table, err := t.getTable(ctx)
+	if err != nil {
+		return nil, err
+	}
+
 	var result []*models.Invoice
 
-	tx := ctx.DB.Debug().Table(t.getTable(ctx))
+	tx := ctx.DB.Debug().Table(table)
 
 	if len(companyIds) > 0 {
 		tx.Where("company_id IN (?)", companyIds)
@@ -223,7 +278,7 @@ func (t *Invoice) GetForInvoiceByCompanyIds(ctx *context.Context, shipmentId str
 		tx.Where("region_id =?", regionId)
 	}
 
-	err := tx.Find(&result).Error
+	err = tx.Find(&result).Error
 	if err != nil {
 		ctx.Log.Error("Unable to get invoices.", zap.Error(err))
 		return nil, err
@@ -234,9 +289,14 @@ func (t *Invoice) GetForInvoiceByCompanyIds(ctx *context.Context, shipmentId str
 
 func (t *Invoice) GetByInvoiceNumber(ctx *context.Context, number string) (*models.Invoice, error) {
 
+	table, err := t.getTable(ctx)
+	if err != nil {
+		return nil, err
+	}
+
 	var result models.Invoice
 
-	err := ctx.DB.Table(t.getTable(ctx)).First(&result, "no = ?", number).Error
+	err = ctx.DB.Table(table).First(&result, "no = ?", number).Error
 	if err != nil {
 		ctx.Log.Error("unable to get invoice details by invoice number", zap.Error(err), zap.Any("invoice number", number))
 		return nil, err
@@ -246,12 +306,21 @@ func (t *Invoice) GetByInvoiceNumber(ctx *context.Context, number string) (*mode
 }
 
 func (t *Invoice) GetByLineItemIds(ctx *context.Context, lineItemIds []string, invoiceType string) ([]*models.Invoice, error) {
+	table, err := t.getTable(ctx)
+	if err != nil {
+		return nil, err
+	}
+	invoiceLineItemsTable, err := t.getInvoiceLineItemsTable(ctx)
+	if err != nil {
+		return nil, err
+	}
+
 	var invoices []*models.Invoice
 	var invoiceLineItems []*models.InvoiceLineItem
 
-	err := ctx.DB.Debug().Table(t.getTable(ctx)+" i").
+	err = ctx.DB.Debug().Table(table+" i").
 		Select("i.id, i.voucher_id, i.invoiced_date, i.due_on, i.no, i.partner_inv_docs").
-		Joins("JOIN "+t.getInvoiceLineItemsTable(ctx)+" ili ON i.id = ili.invoice_id").
+		Joins("JOIN "+invoiceLineItemsTable+" ili ON i.id = ili.invoice_id").
 		Where("ili.line_item_id IN (?) AND i.invoice_type = ?", lineItemIds, invoiceType).
 		Group("i.id").
 		Order("i.created_at").
@@ -266,7 +335,7 @@ func (t *Invoice) GetByLineItemIds(ctx *context.Context, lineItemIds []string, i
 		invoiceIds[i] = inv.ID.String()
 	}
 
-	err = ctx.DB.Debug().Table(t.getInvoiceLineItemsTable(ctx)).
+	err = ctx.DB.Debug().Table(invoiceLineItemsTable).
 		Select("id, invoice_id, line_item_id").
 		Where("line_item_id IN (?) AND invoice_id IN (?)", lineItemIds, invoiceIds).
 		Order("created_at").
@@ -388,13 +457,23 @@ func (t *Invoice) GetTotalDebitNoteItems(ctx *context.Context, lineItemIds []str
 }
 
 func (t *Invoice) Update(ctx *context.Context, m *models.Invoice) error {
-	return ctx.DB.WithContext(ctx.Request.Context()).Table(t.getTable(ctx)).Debug().Where("id = ?", m.ID).Updates(m).Error
+	table, err := t.getTable(ctx)
+	if err != nil {
+		return err
+	}
+
+	return ctx.DB.WithContext(ctx.Request.Context()).Table(table).Debug().Where("id = ?", m.ID).Updates(m).Error
 }
 
 func (t Invoice) GetTotalCount(ctx *context.Context) (int, error) {
 
+	table, err := t.getTable(ctx)
+	if err != nil {
+		return 0, err
+	}
+
 	var count int64
-	err := ctx.DB.WithContext(ctx.Request.Context()).Table(t.getTable(ctx)).Count(&count).Error
+	err = ctx.DB.WithContext(ctx.Request.Context()).Table(table).Count(&count).Error
 	if err != nil {
 		return 0, err
 	}
@@ -404,9 +483,14 @@ func (t Invoice) GetTotalCount(ctx *context.Context) (int, error) {
 
 func (t *Invoice) GetIcaVendorInvoice(ctx *context.Context, shipmentId string, voucherId string) (*models.Invoice, error) {
 
+	table, err := t.getTable(ctx)
+	if err != nil {
+		return nil, err
+	}
+
 	var result models.Invoice
 
-	err := ctx.DB.Table(t.getTable(ctx)).First(&result, "shipment_id = ? AND voucher_id = ?", shipmentId, voucherId).Error
+	err = ctx.DB.Table(table).First(&result, "shipment_id = ? AND voucher_id = ?", shipmentId, voucherId).Error
 	if err != nil {
 		ctx.Log.Error("unable to get invoice details by shipment and voucher ID", zap.Error(err), zap.Any("voucher id", voucherId))
 		return nil, err
@@ -414,3 +498,359 @@ func (t *Invoice) GetIcaVendorInvoice(ctx *context.Context, shipmentId string, v
 
 	return &result, err
 }
+
+// GetExistingNumbers returns the subset of numbers that are already used by an invoice.
+func (t *Invoice) GetExistingNumbers(ctx *context.Context, numbers []string) ([]string, error) {
+	table, err := t.getTable(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var result []string
+
+	if len(numbers) == 0 {
+		return result, nil
+	}
+
+	err = ctx.DB.WithContext(ctx.Request.Context()).Table(table).
+		Where("no IN (?)", numbers).
+		Pluck("no", &result).Error
+	if err != nil {
+		ctx.Log.Error("unable to check existing invoice numbers", zap.Error(err))
+		return nil, err
+	}
+
+	return result, nil
+}
+
+// ExistsNumberWithTx reports whether an invoice was already issued with the number.
+func (t *Invoice) ExistsNumberWithTx(ctx *context.Context, tx *gorm.DB, number string) (bool, error) {
+	table, err := t.getTable(ctx)
+	if err != nil {
+		return false, err
+	}
+
+	var exists bool
+	err = tx.Raw("SELECT EXISTS (?)", tx.Session(&gorm.Session{NewDB: true}).Table(table).Select("1").Where("no = ?", number)).
+		Scan(&exists).Error
+	if err != nil {
+		ctx.Log.Error("unable to check invoice number", zap.Error(err), zap.String("no", number))
+		return false, err
+	}
+
+	return exists, nil
+}
+
+// GetNumbersMatching returns the issued invoice numbers that match the POSIX regular
+// expression.
+func (t *Invoice) GetNumbersMatching(ctx *context.Context, pattern string) ([]string, error) {
+	table, err := t.getTable(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var result []string
+
+	err = ctx.DB.WithContext(ctx.Request.Context()).Table(table).
+		Where("no ~ ?", pattern).
+		Pluck("no", &result).Error
+	if err != nil {
+		ctx.Log.Error("unable to match invoice numbers", zap.Error(err), zap.String("pattern", pattern))
+		return nil, err
+	}
+
+	return result, nil
+}
+
+func (t *Invoice) GetByInvoiceRequestId(ctx *context.Context, invoiceRequestId string) ([]*models.Invoice, error) {
+	table, err := t.getTable(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var result []*models.Invoice
+
+	err = ctx.DB.WithContext(ctx.Request.Context()).Table(table).
+		Where("invoice_request_id = ?", invoiceRequestId).
+		Order("created_at").
+		Find(&result).Error
+	if err != nil {
+		ctx.Log.Error("unable to get invoices by invoice request", zap.Error(err), zap.Any("invoice_request_id", invoiceRequestId))
+		return nil, err
+	}
+
+	return result, nil
+}
+
+// GetVoucherWithTx returns the region and voucher type the invoice was numbered in.
+func (t *Invoice) GetVoucherWithTx(ctx *context.Context, tx *gorm.DB, invoiceId uuid.UUID) (*models.InvoiceVoucher, error) {
+	table, err := t.getTable(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var result models.InvoiceVoucher
+	err = tx.Table(table).Select("region_id, voucher_type").Where("id = ?", invoiceId).Take(&result).Error
+	if err != nil {
+		ctx.Log.Error("unable to get invoice voucher", zap.Error(err), zap.Any("invoice_id", invoiceId))
+		return nil, err
+	}
+
+	return &result, nil
+}
+
+// CreateNoteWithTx writes the note as a copy of the invoice it is raised against, with its
+// own id, number, type, voucher type and date and without the original's documents. Every note line is a
+// copy of the original's line of the same line item, priced at the note amount for a
+// quantity of one.
+func (t *Invoice) CreateNoteWithTx(ctx *context.Context, tx *gorm.DB, note *models.NoteInvoice) error {
+	table, err := t.getTable(ctx)
+	if err != nil {
+		return err
+	}
+	lineItemsTable, err := t.getInvoiceLineItemsTable(ctx)
+	if err != nil {
+		return err
+	}
+
+	header, err := json.Marshal(map[string]interface{}{
+		"id":                 note.Id,
+		"no":                 note.No,
+		"invoice_type":       note.InvoiceType,
+		"voucher_type":       note.VoucherType,
+		"invoice_request_id": note.InvoiceRequestId,
+		"invoiced_date":      note.InvoicedAt,
+		"created_at":         note.InvoicedAt,
+		"doc_id":             nil,
+		"partner_inv_docs":   nil,
+	})
+	if err != nil {
+		return err
+	}
+
+	res := tx.Exec("INSERT INTO "+table+" SELECT (jsonb_populate_record(NULL::"+table+", to_jsonb(i) || ?::jsonb)).* FROM "+table+" i WHERE i.id = ?",
+		string(header), note.OriginalId)
+	if res.Error != nil {
+		ctx.Log.Error("unable to create note invoice", zap.Error(res.Error), zap.Any("invoice_id", note.OriginalId))
+		return res.Error
+	}
+	if res.RowsAffected != 1 {
+		return gorm.ErrRecordNotFound
+	}
+
+	for _, line := range note.Lines {
+		patch, err := json.Marshal(map[string]interface{}{
+			"id":         uuid.New(),
+			"invoice_id": note.Id,
+			"quantity":   1,
+			"tax_amount": line.Amount,
+			"created_at": note.InvoicedAt,
+		})
+		if err != nil {
+			return err
+		}
+
+		// The rate leaves out the line's tax, so rate * quantity * (1 + tax) is the note amount
+		res := tx.Exec("INSERT INTO "+lineItemsTable+" SELECT (jsonb_populate_record(NULL::"+lineItemsTable+
+			", to_jsonb(l) || jsonb_build_object('rate', ?::numeric / (1 + COALESCE(l.tax_percentage, 0) / 100)) || ?::jsonb)).*"+
+			" FROM "+lineItemsTable+" l WHERE l.id = (SELECT id FROM "+lineItemsTable+" WHERE invoice_id = ? AND line_item_id = ? ORDER BY created_at LIMIT 1)",
+			line.Amount, string(patch), note.OriginalId, line.LineItemId)
+		if res.Error != nil {
+			ctx.Log.Error("unable to create note line", zap.Error(res.Error), zap.Any("line_item_id", line.LineItemId))
+			return res.Error
+		}
+		if res.RowsAffected != 1 {
+			return gorm.ErrRecordNotFound
+		}
+	}
+
+	return nil
+}
+
+// GetOutstanding lists invoices with their settlement balance. Days overdue are counted
+// from the due date for invoices that still have an amount outstanding. An invoice without
+// a balance row has had no note or payment recorded yet, it is unpaid for the full amount
+// of its lines.
+func (t *Invoice) GetOutstanding(ctx *context.Context, filter *models.InvoiceOutstandingFilter) ([]*models.InvoiceWithBalance, error) {
+	table, err := t.getTable(ctx)
+	if err != nil {
+		return nil, err
+	}
+	invoiceLineItemsTable, err := t.getInvoiceLineItemsTable(ctx)
+	if err != nil {
+		return nil, err
+	}
+	invoiceBalancesTable, err := t.getInvoiceBalancesTable(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	outstanding := "COALESCE(b.outstanding_amount, l.amount, 0)"
+	status := "COALESCE(b.status, '" + constants.InvoiceStatusUnpaid + "')"
+
+	var result []*models.InvoiceWithBalance
+
+	tx := ctx.DB.WithContext(ctx.Request.Context()).Table(table + " i").
+		Select(`i.id AS invoice_id, i.no, i.invoice_type, i.shipment_id, i.company_id, i.region_id, i.due_on,
+			COALESCE(b.currency, l.currency) AS currency, COALESCE(b.invoiced_amount, l.amount, 0) AS invoiced_amount,
+			COALESCE(b.paid_amount, 0) AS paid_amount, ` + outstanding + ` AS outstanding_amount, ` + status + ` AS status,
+			CASE WHEN ` + outstanding + ` > 0 AND i.due_on < CURRENT_DATE
+				THEN (CURRENT_DATE - i.due_on::date) ELSE 0 END AS days_overdue`).
+		Joins("LEFT JOIN " + invoiceBalancesTable + " b ON b.invoice_id = i.id").
+		Joins(`LEFT JOIN LATERAL (
+			SELECT MIN(il.currency) AS currency, SUM(il.tax_amount) AS amount
+			FROM ` + invoiceLineItemsTable + ` il
+			WHERE il.invoice_id = i.id
+		) l ON b.invoice_id IS NULL`)
+
+	if filter.ShipmentId != "" {
+		tx.Where("i.shipment_id = ?", filter.ShipmentId)
+	}
+
+	if len(filter.CompanyIds) > 0 {
+		tx.Where("i.company_id IN (?)", filter.CompanyIds)
+	}
+
+	if filter.RegionId != "" {
+		tx.Where("i.region_id = ?", filter.RegionId)
+	}
+
+	if len(filter.InvoiceTypes) > 0 {
+		tx.Where("i.invoice_type IN (?)", filter.InvoiceTypes)
+	}
+
+	if len(filter.Statuses) > 0 {
+		tx.Where(status+" IN (?)", filter.Statuses)
+	}
+
+	if filter.MinOutstanding != nil {
+		tx.Where(outstanding+" >= ?", *filter.MinOutstanding)
+	}
+
+	if filter.MaxOutstanding != nil {
+		tx.Where(outstanding+" <= ?", *filter.MaxOutstanding)
+	}
+
+	if filter.DueFrom != "" {
+		tx.Where("i.due_on::date >= ?", filter.DueFrom)
+	}
+
+	if filter.DueTo != "" {
+		tx.Where("i.due_on::date <= ?", filter.DueTo)
+	}
+
+	if filter.MinDaysOverdue != nil {
+		tx.Where(outstanding+" > 0 AND CURRENT_DATE - i.due_on::date >= ?", *filter.MinDaysOverdue)
+	}
+
+	tx.Order("i.due_on NULLS LAST, i.created_at").Offset(filter.Offset)
+	if filter.Limit != 0 {
+		tx.Limit(filter.Limit)
+	}
+
+	err = tx.Scan(&result).Error
+	if err != nil {
+		ctx.Log.Error("Unable to get outstanding invoices.", zap.Error(err))
+		return nil, err
+	}
+
+	return result, nil
+}
+
+// GetAgeingInvoices returns the customer invoices that still have an amount outstanding with
+// their age in days past the due date and their amount in the region's base currency. The
+// amount is the stored line totals converted with the exchange rates stored on the lines, so
+// invoices are counted once whatever rates their line items have since. Invoices not due yet
+// have a negative age.
+func (t *Invoice) GetAgeingInvoices(ctx *context.Context, filter *models.ARAgeingFilter) ([]*models.ARAgeingInvoice, error) {
+	table, err := t.getTable(ctx)
+	if err != nil {
+		return nil, err
+	}
+	invoiceLineItemsTable, err := t.getInvoiceLineItemsTable(ctx)
+	if err != nil {
+		return nil, err
+	}
+	shipmentsTable, err := tenant.Table(ctx, "shipments")
+	if err != nil {
+		return nil, err
+	}
+	invoiceBalancesTable, err := t.getInvoiceBalancesTable(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var result []*models.ARAgeingInvoice
+
+	tx := ctx.DB.WithContext(ctx.Request.Context()).Table(table+" i").
+		Select(`i.id AS invoice_id, i.no, i.company_id, i.region_id, s.sales_executive_id,
+			CURRENT_DATE - COALESCE(i.due_on, i.invoiced_date)::date AS age_days,
+			COALESCE(l.base_amount, 0) AS base_amount,
+			COALESCE(b.invoiced_amount, 0) AS invoiced_amount,
+			COALESCE(b.outstanding_amount, 0) AS outstanding_amount,
+			b.invoice_id IS NOT NULL AS has_balance`).
+		Joins(`LEFT JOIN LATERAL (
+			SELECT SUM(il.tax_amount * COALESCE(il.exchange_rate, 1)) AS base_amount
+			FROM `+invoiceLineItemsTable+` il WHERE il.invoice_id = i.id
+		) l ON true`).
+		Joins("JOIN "+shipmentsTable+" s ON s.id = i.shipment_id").
+		Joins("LEFT JOIN "+invoiceBalancesTable+" b ON b.invoice_id = i.id").
+		Where("i.invoice_type = ?", constants.CustomerInvoice).
+		Where("(b.invoice_id IS NULL OR b.outstanding_amount > 0)")
+
+	if len(filter.CompanyIds) > 0 {
+		tx.Where("i.company_id IN (?)", filter.CompanyIds)
+	}
+
+	if len(filter.RegionIds) > 0 {
+		tx.Where("i.region_id IN (?)", filter.RegionIds)
+	}
+
+	if len(filter.SalesExecutiveIds) > 0 {
+		tx.Where("s.sales_executive_id IN (?)", filter.SalesExecutiveIds)
+	}
+
+	err = tx.Scan(&result).Error
+	if err != nil {
+		ctx.Log.Error("Unable to get ageing invoices.", zap.Error(err))
+		return nil, err
+	}
+
+	return result, nil
+}
+
+// GetOverdueInvoices returns the customer invoices that are at least minDaysOverdue past their
+// due date and have not been settled. Invoices without a balance have not been paid at all.
+func (t *Invoice) GetOverdueInvoices(ctx *context.Context, minDaysOverdue int) ([]*models.DunningInvoice, error) {
+	table, err := t.getTable(ctx)
+	if err != nil {
+		return nil, err
+	}
+	shipmentsTable, err := tenant.Table(ctx, "shipments")
+	if err != nil {
+		return nil, err
+	}
+	invoiceBalancesTable, err := t.getInvoiceBalancesTable(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var result []*models.DunningInvoice
+
+	err = ctx.DB.WithContext(ctx.Request.Context()).Table(table+" i").
+		Select(`i.id AS invoice_id, i.no, i.company_id, i.region_id, i.shipment_id, s.sales_executive_id, i.due_on,
+			b.currency, b.outstanding_amount, CURRENT_DATE - i.due_on::date AS days_overdue`).
+		Joins("JOIN "+shipmentsTable+" s ON s.id = i.shipment_id").
+		Joins("LEFT JOIN "+invoiceBalancesTable+" b ON b.invoice_id = i.id").
+		Where("i.invoice_type = ?", constants.CustomerInvoice).
+		Where("i.due_on IS NOT NULL AND CURRENT_DATE - i.due_on::date >= ?", minDaysOverdue).
+		Where("(b.invoice_id IS NULL OR b.outstanding_amount > 0)").
+		Order("i.due_on").
+		Scan(&result).Error
+	if err != nil {
+		ctx.Log.Error("Unable to get overdue invoices.", zap.Error(err))
+		return nil, err
+	}
+
+	return result, nil
+}
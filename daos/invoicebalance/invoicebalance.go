@@ -0,0 +1,114 @@
+package invoicebalance
+
+import (
+	"bitbucket.org/radarventures/forwarder-adapters/utils/context"
+	"bitbucket.org/radarventures/forwarder-shipments/daos/tenant"
+	"bitbucket.org/radarventures/forwarder-shipments/database/models"
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+type IInvoiceBalance interface {
+	UpsertWithTx(ctx *context.Context, tx *gorm.DB, m ...*models.InvoiceBalance) error
+	LockWithTx(ctx *context.Context, tx *gorm.DB, m *models.InvoiceBalance) (*models.InvoiceBalance, error)
+	Get(ctx *context.Context, invoiceId string) (*models.InvoiceBalance, error)
+	GetAll(ctx *context.Context, invoiceIds []string) ([]*models.InvoiceBalance, error)
+}
+
+type InvoiceBalance struct {
+}
+
+func NewInvoiceBalance() IInvoiceBalance {
+	return &InvoiceBalance{}
+}
+
+func (t *InvoiceBalance) getTable(ctx *context.Context) (string, error) {
+	return tenant.Table(ctx, "invoice_balances")
+}
+
+func (t *InvoiceBalance) UpsertWithTx(ctx *context.Context, tx *gorm.DB, m ...*models.InvoiceBalance) error {
+	table, err := t.getTable(ctx)
+	if err != nil {
+		return err
+	}
+
+	err = tx.Table(table).
+		Clauses(clause.OnConflict{
+			Columns:   []clause.Column{{Name: "invoice_id"}},
+			UpdateAll: true,
+		}).
+		Create(m).Error
+	if err != nil {
+		ctx.Log.Error("unable to upsert invoice balances with tx", zap.Error(err))
+	}
+	return err
+}
+
+// LockWithTx locks the balance row of the invoice until tx ends, so notes and payments against
+// the same invoice are checked and applied one after the other. An invoice without a balance
+// row yet gets m inserted first, there is always a row to lock.
+func (t *InvoiceBalance) LockWithTx(ctx *context.Context, tx *gorm.DB, m *models.InvoiceBalance) (*models.InvoiceBalance, error) {
+	table, err := t.getTable(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	err = tx.Table(table).
+		Clauses(clause.OnConflict{
+			Columns:   []clause.Column{{Name: "invoice_id"}},
+			DoNothing: true,
+		}).
+		Create(m).Error
+	if err != nil {
+		ctx.Log.Error("unable to create invoice balance with tx", zap.Error(err), zap.Any("invoice_id", m.InvoiceId))
+		return nil, err
+	}
+
+	var result models.InvoiceBalance
+	err = tx.Table(table).
+		Clauses(clause.Locking{Strength: "UPDATE"}).
+		First(&result, "invoice_id = ?", m.InvoiceId).Error
+	if err != nil {
+		ctx.Log.Error("unable to lock invoice balance", zap.Error(err), zap.Any("invoice_id", m.InvoiceId))
+		return nil, err
+	}
+
+	return &result, nil
+}
+
+func (t *InvoiceBalance) Get(ctx *context.Context, invoiceId string) (*models.InvoiceBalance, error) {
+	table, err := t.getTable(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var result models.InvoiceBalance
+	err = ctx.DB.WithContext(ctx.Request.Context()).Table(table).First(&result, "invoice_id = ?", invoiceId).Error
+	if err != nil {
+		ctx.Log.Error("Unable to get invoice balance.", zap.Error(err))
+		return nil, err
+	}
+
+	return &result, nil
+}
+
+func (t *InvoiceBalance) GetAll(ctx *context.Context, invoiceIds []string) ([]*models.InvoiceBalance, error) {
+	table, err := t.getTable(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var result []*models.InvoiceBalance
+	if len(invoiceIds) == 0 {
+		return result, nil
+	}
+
+	err = ctx.DB.WithContext(ctx.Request.Context()).Table(table).Where("invoice_id IN (?)", invoiceIds).Find(&result).Error
+	if err != nil {
+		ctx.Log.Error("Unable to get invoice balances.", zap.Error(err))
+		return nil, err
+	}
+
+	return result, nil
+}
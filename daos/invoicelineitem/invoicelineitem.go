@@ -3,6 +3,7 @@ package invoicelineitem
 import (
 	"bitbucket.org/radarventures/forwarder-adapters/utils/context"
 	"bitbucket.org/radarventures/forwarder-shipments/constants"
+	"bitbucket.org/radarventures/forwarder-shipments/daos/tenant"
 	"bitbucket.org/radarventures/forwarder-shipments/database/models"
 	"github.com/google/uuid"
 	"go.uber.org/zap"
@@ -22,7 +23,9 @@ type IInvoiceLineItem interface {
 	GetLineItemsForInvoice(ctx *context.Context, lineItemId uuid.UUID, voucherType []string, invoiceType, billToAccountId, shipmentId, status, query string) ([]*models.InvoiceLineItem, error)
 	CheckForGeneratedInvoice(ctx *context.Context, lineItemIds []string, invoiceType string, single bool) (interface{}, error)
 	GetInvoicedAmounts(ctx *context.Context, lineItemIds []string, invoiceType string) ([]*models.LineItemInvoicedAmountWithType, error)
+	GetInvoicedAmountsWithTx(ctx *context.Context, tx *gorm.DB, lineItemIds []string, invoiceType string) ([]*models.LineItemInvoicedAmountWithType, error)
 	GetForInvoiceId(ctx *context.Context, invoiceId string) ([]*models.InvoiceLineItem, error)
+	GetForInvoiceIdWithTx(ctx *context.Context, tx *gorm.DB, invoiceId string) ([]*models.InvoiceLineItem, error)
 	GetTotalSoFar(ctx *context.Context, lineItemId uuid.UUID, invoiceType string) ([]*models.InvoiceLineItem, error)
 	GetInvoiceLineItemsFilter(ctx *context.Context, filters *models.InvoiceLineItemsFilters) ([]*models.InvoiceLineItem, error)
 	DeleteInvoiceLineItemsByInvoiceId(ctx *context.Context, InvoiceId string) error
@@ -35,12 +38,21 @@ func NewInvoiceLineItem() IInvoiceLineItem {
 	return &InvoiceLineItem{}
 }
 
-func (t *InvoiceLineItem) getTable(ctx *context.Context) string {
-	return ctx.TenantID + "." + "invoice_line_items"
+func (t *InvoiceLineItem) getTable(ctx *context.Context) (string, error) {
+	return tenant.Table(ctx, "invoice_line_items")
+}
+
+func (t *InvoiceLineItem) getInvoicesTable(ctx *context.Context) (string, error) {
+	return tenant.Table(ctx, "invoices")
 }
 
 func (t *InvoiceLineItem) Upsert(ctx *context.Context, m ...*models.InvoiceLineItem) error {
-	err := ctx.DB.Table(t.getTable(ctx)).Save(m).Error
+	table, err := t.getTable(ctx)
+	if err != nil {
+		return err
+	}
+
+	err = ctx.DB.Table(table).Save(m).Error
 	if err != nil {
 		ctx.Log.Error("unable to upsert invoicelineitems", zap.Error(err))
 	}
@@ -48,7 +60,12 @@ func (t *InvoiceLineItem) Upsert(ctx *context.Context, m ...*models.InvoiceLineI
 }
 
 func (t *InvoiceLineItem) UpsertWithTx(ctx *context.Context, tx *gorm.DB, m ...*models.InvoiceLineItem) error {
-	err := tx.Debug().Table(t.getTable(ctx)).Save(m).Error
+	table, err := t.getTable(ctx)
+	if err != nil {
+		return err
+	}
+
+	err = tx.Debug().Table(table).Save(m).Error
 	if err != nil {
 		ctx.Log.Error("unable to upsert invoicelineitems with tx", zap.Error(err))
 	}
@@ -56,8 +73,13 @@ func (t *InvoiceLineItem) UpsertWithTx(ctx *context.Context, tx *gorm.DB, m ...*
 }
 
 func (t *InvoiceLineItem) Get(ctx *context.Context, id string) (*models.InvoiceLineItem, error) {
+	table, err := t.getTable(ctx)
+	if err != nil {
+		return nil, err
+	}
+
 	var result models.InvoiceLineItem
-	err := ctx.DB.Table(t.getTable(ctx)).First(&result, "id = ?", id).Error
+	err = ctx.DB.Table(table).First(&result, "id = ?", id).Error
 	if err != nil {
 		ctx.Log.Error("Unable to get invoicelineitem.", zap.Error(err))
 		return nil, err
@@ -67,8 +89,13 @@ func (t *InvoiceLineItem) Get(ctx *context.Context, id string) (*models.InvoiceL
 }
 
 func (t *InvoiceLineItem) Delete(ctx *context.Context, id string) error {
+	table, err := t.getTable(ctx)
+	if err != nil {
+		return err
+	}
+
 	var result models.InvoiceLineItem
-	err := ctx.DB.Table(t.getTable(ctx)).Delete(&result, "id = ?", id).Error
+	err = ctx.DB.Table(table).Delete(&result, "id = ?", id).Error
 	if err != nil {
 		ctx.Log.Error("Unable to delete invoicelineitem.", zap.Error(err))
 		return err
@@ -78,16 +105,21 @@ func (t *InvoiceLineItem) Delete(ctx *context.Context, id string) error {
 }
 
 func (t *InvoiceLineItem) GetAll(ctx *context.Context, ids []string) ([]*models.InvoiceLineItem, error) {
+	table, err := t.getTable(ctx)
+	if err != nil {
+		return nil, err
+	}
+
 	var result []*models.InvoiceLineItem
 	if len(ids) == 0 {
-		err := ctx.DB.Table(t.getTable(ctx)).Find(&result).Error
+		err := ctx.DB.Table(table).Find(&result).Error
 		if err != nil {
 			ctx.Log.Error("Unable to get invoicelineitems.", zap.Error(err))
 			return nil, err
 		}
 		return result, err
 	}
-	err := ctx.DB.Table(t.getTable(ctx)).Where("id IN ?", ids).Find(&result).Error
+	err = ctx.DB.Table(table).Where("id IN ?", ids).Find(&result).Error
 	if err != nil {
 		ctx.Log.Error("Unable to get invoicelineitems.", zap.Error(err))
 		return nil, err
@@ -97,10 +129,14 @@ func (t *InvoiceLineItem) GetAll(ctx *context.Context, ids []string) ([]*models.
 }
 
 func (t *InvoiceLineItem) GetInvoiceWithVoucherTypes(ctx *context.Context, lineItemId uuid.UUID, voucherType []string, invoiceType string, partnerId uuid.UUID, shipmentId uuid.UUID, query string) (*models.Invoice, error) {
+	table, err := t.getTable(ctx)
+	if err != nil {
+		return nil, err
+	}
 
 	invoices := models.Invoice{}
 
-	tx := ctx.DB.Table(t.getTable(ctx)).Select("invoices.*").Joins("JOIN invoice_line_items ON invoices.id = invoice_line_items.invoice_id")
+	tx := ctx.DB.Table(table).Select("invoices.*").Joins("JOIN invoice_line_items ON invoices.id = invoice_line_items.invoice_id")
 
 	if lineItemId != uuid.Nil {
 		tx.Where("invoice_line_items.line_item_id = ?", lineItemId)
@@ -122,7 +158,7 @@ func (t *InvoiceLineItem) GetInvoiceWithVoucherTypes(ctx *context.Context, lineI
 	}
 
 	tx.Order("invoice_line_items.created_at DESC")
-	err := tx.First(&invoices).Error
+	err = tx.First(&invoices).Error
 	if err != nil {
 		return nil, err
 	}
@@ -131,11 +167,19 @@ func (t *InvoiceLineItem) GetInvoiceWithVoucherTypes(ctx *context.Context, lineI
 }
 
 func (t *InvoiceLineItem) GetLineItemsCountWithShipmentId(ctx *context.Context, invoiceType string, shipmentId uuid.UUID, query string) (*models.DistinctLineitemCount, error) {
+	table, err := t.getTable(ctx)
+	if err != nil {
+		return nil, err
+	}
+	invoicesTable, err := t.getInvoicesTable(ctx)
+	if err != nil {
+		return nil, err
+	}
 
 	total := &models.DistinctLineitemCount{}
 
-	tx := ctx.DB.Table(t.getTable(ctx)).Select("DISTINCT count(invoice_line_items.line_item_id) as total").
-		Joins("JOIN invoices ON invoices.id = invoice_line_items.invoice_id").
+	tx := ctx.DB.Table(table).Select("DISTINCT count(invoice_line_items.line_item_id) as total").
+		Joins("JOIN "+invoicesTable+" invoices ON invoices.id = invoice_line_items.invoice_id").
 		Where("invoices.shipment_id = ?", shipmentId)
 
 	if invoiceType != "" {
@@ -146,7 +190,7 @@ func (t *InvoiceLineItem) GetLineItemsCountWithShipmentId(ctx *context.Context,
 		tx.Where(query)
 	}
 
-	err := tx.Find(&total).Error
+	err = tx.Find(&total).Error
 	if err != nil {
 		return nil, err
 	}
@@ -155,10 +199,18 @@ func (t *InvoiceLineItem) GetLineItemsCountWithShipmentId(ctx *context.Context,
 }
 
 func (t *InvoiceLineItem) GetLineItemsForInvoice(ctx *context.Context, lineItemId uuid.UUID, voucherType []string, invoiceType string, billToAccountId string, shipmentId, status, query string) ([]*models.InvoiceLineItem, error) {
+	table, err := t.getTable(ctx)
+	if err != nil {
+		return nil, err
+	}
+	invoicesTable, err := t.getInvoicesTable(ctx)
+	if err != nil {
+		return nil, err
+	}
 
 	InvoicelineItem := []*models.InvoiceLineItem{}
 
-	tx := ctx.DB.Table(t.getTable(ctx)).Joins("JOIN invoices ON invoices.id = invoice_line_items.invoice_id")
+	tx := ctx.DB.Table(table).Joins("JOIN " + invoicesTable + " invoices ON invoices.id = invoice_line_items.invoice_id")
 
 	if lineItemId != uuid.Nil {
 		tx.Where("invoice_line_items.line_item_id = ?", lineItemId)
@@ -184,7 +236,7 @@ func (t *InvoiceLineItem) GetLineItemsForInvoice(ctx *context.Context, lineItemI
 	}
 
 	tx.Order("created_at DESC")
-	err := tx.Debug().Find(&InvoicelineItem).Error
+	err = tx.Debug().Find(&InvoicelineItem).Error
 	if err != nil {
 		return nil, err
 	}
@@ -193,10 +245,18 @@ func (t *InvoiceLineItem) GetLineItemsForInvoice(ctx *context.Context, lineItemI
 }
 
 func (t *InvoiceLineItem) GetLineItemForLatestInvoice(ctx *context.Context, lineItemId uuid.UUID, voucherType []string, invoiceType string, billToAccountId string, baseCurrency, exchangeRate, query string) (*models.InvoiceLineItem, error) {
+	table, err := t.getTable(ctx)
+	if err != nil {
+		return nil, err
+	}
+	invoicesTable, err := t.getInvoicesTable(ctx)
+	if err != nil {
+		return nil, err
+	}
 
 	lineItem := models.InvoiceLineItem{}
 
-	tx := ctx.DB.Table(t.getTable(ctx)).Select("invoice_line_items.*").Joins("JOIN invoices ON invoices.id = invoice_line_items.invoice_id")
+	tx := ctx.DB.Table(table).Select("invoice_line_items.*").Joins("JOIN " + invoicesTable + " invoices ON invoices.id = invoice_line_items.invoice_id")
 
 	if lineItemId != uuid.Nil {
 		tx.Where("invoice_line_items.line_item_id = ?", lineItemId)
@@ -225,7 +285,7 @@ func (t *InvoiceLineItem) GetLineItemForLatestInvoice(ctx *context.Context, line
 	}
 
 	tx.Order("invoice_line_items.created_at DESC")
-	err := tx.First(&lineItem).Error
+	err = tx.First(&lineItem).Error
 	if err != nil {
 		return nil, err
 	}
@@ -234,8 +294,17 @@ func (t *InvoiceLineItem) GetLineItemForLatestInvoice(ctx *context.Context, line
 }
 
 func (t *InvoiceLineItem) GetGeneratedLineItems(ctx *context.Context, req models.InvoiceAmount) ([]*models.InvoiceLineItem, error) {
+	table, err := t.getTable(ctx)
+	if err != nil {
+		return nil, err
+	}
+	invoicesTable, err := t.getInvoicesTable(ctx)
+	if err != nil {
+		return nil, err
+	}
+
 	invoiceLineItems := []*models.InvoiceLineItem{}
-	tx := ctx.DB.Table(t.getTable(ctx)).Select("invoice_line_items.*,invoices.booking_id,invoices.booking_id,invoices.number").Joins("JOIN invoices ON invoices.id = invoice_line_items.invoice_id")
+	tx := ctx.DB.Table(table).Select("invoice_line_items.*,invoices.booking_id,invoices.booking_id,invoices.number").Joins("JOIN " + invoicesTable + " invoices ON invoices.id = invoice_line_items.invoice_id")
 	if len(req.ShipmentIds) > 0 {
 		tx.Where("invoices.shipment_id::text in (?)", req.ShipmentIds)
 	}
@@ -248,7 +317,7 @@ func (t *InvoiceLineItem) GetGeneratedLineItems(ctx *context.Context, req models
 	if len(req.NotVoucherTypes) > 0 {
 		tx.Where("invoices.voucher_type NOT in (?)", req.NotVoucherTypes)
 	}
-	err := tx.Find(&invoiceLineItems).Error
+	err = tx.Find(&invoiceLineItems).Error
 	if err != nil {
 		return nil, err
 	}
@@ -259,9 +328,18 @@ func (t *InvoiceLineItem) GetGeneratedLineItems(ctx *context.Context, req models
 // If `single` is true, it returns a single boolean indicating whether any of the line items have a generated invoice.
 // If `single` is false, it returns a map with line_item_id as the key and a boolean indicating if the invoice has been generated for that line item.
 func (t *InvoiceLineItem) CheckForGeneratedInvoice(ctx *context.Context, lineItemIds []string, invoiceType string, single bool) (interface{}, error) {
+	table, err := t.getTable(ctx)
+	if err != nil {
+		return nil, err
+	}
+	invoicesTable, err := t.getInvoicesTable(ctx)
+	if err != nil {
+		return nil, err
+	}
+
 	if single {
 		// Single result: Check if any invoice has been generated for the line items
-		tx := ctx.DB.Table(t.getTable(ctx)).Joins("JOIN invoices ON invoices.id = invoice_line_items.invoice_id").
+		tx := ctx.DB.Table(table).Joins("JOIN "+invoicesTable+" invoices ON invoices.id = invoice_line_items.invoice_id").
 			Where("line_item_id IN (?) AND invoice_type = ?", lineItemIds, invoiceType)
 
 		var isGenerated bool
@@ -279,13 +357,13 @@ func (t *InvoiceLineItem) CheckForGeneratedInvoice(ctx *context.Context, lineIte
 	}
 
 	// Fixed query with MAX to handle multiple entries and booleans
-	tx := ctx.DB.Table(t.getTable(ctx)).
+	tx := ctx.DB.Table(table).
 		Select("line_item_id, MAX(CASE WHEN EXISTS (SELECT 1 FROM invoices WHERE invoices.id = invoice_line_items.invoice_id AND invoice_type = ?) THEN 1 ELSE 0 END) as is_generated", invoiceType).
 		Where("line_item_id IN (?)", lineItemIds).
 		Group("line_item_id")
 
 	// Execute the query and store results
-	err := tx.Scan(&results).Error
+	err = tx.Scan(&results).Error
 	if err != nil {
 		return nil, err
 	}
@@ -300,6 +378,21 @@ func (t *InvoiceLineItem) CheckForGeneratedInvoice(ctx *context.Context, lineIte
 }
 
 func (t *InvoiceLineItem) GetInvoicedAmounts(ctx *context.Context, lineItemIds []string, invoiceType string) ([]*models.LineItemInvoicedAmountWithType, error) {
+	return t.GetInvoicedAmountsWithTx(ctx, ctx.DB, lineItemIds, invoiceType)
+}
+
+// GetInvoicedAmountsWithTx reads the invoiced amounts in tx, so lines written earlier in the
+// same transaction are counted.
+func (t *InvoiceLineItem) GetInvoicedAmountsWithTx(ctx *context.Context, tx *gorm.DB, lineItemIds []string, invoiceType string) ([]*models.LineItemInvoicedAmountWithType, error) {
+	table, err := t.getTable(ctx)
+	if err != nil {
+		return nil, err
+	}
+	invoicesTable, err := t.getInvoicesTable(ctx)
+	if err != nil {
+		return nil, err
+	}
+
 	var invoicedAmounts []*models.LineItemInvoicedAmountWithType
 	var invType []string
 
@@ -309,7 +402,7 @@ func (t *InvoiceLineItem) GetInvoicedAmounts(ctx *context.Context, lineItemIds [
 		invType = append(invType, invoiceType)
 	}
 
-	err := ctx.DB.Table(t.getTable(ctx)).Select("invoice_line_items.line_item_id", "invoice_line_items.invoice_id", "invoice_line_items.currency", "invoice_line_items.tax_amount as amount", "invoices.invoice_type").Joins("JOIN invoices ON invoices.id = invoice_line_items.invoice_id").
+	err = tx.Table(table).Select("invoice_line_items.line_item_id", "invoice_line_items.invoice_id", "invoice_line_items.currency", "invoice_line_items.tax_amount as amount", "invoices.invoice_type").Joins("JOIN "+invoicesTable+" invoices ON invoices.id = invoice_line_items.invoice_id").
 		Where("line_item_id IN (?) AND invoices.invoice_type IN (?)", lineItemIds, invType).Find(&invoicedAmounts).Error
 	if err != nil {
 		return nil, err
@@ -318,15 +411,23 @@ func (t *InvoiceLineItem) GetInvoicedAmounts(ctx *context.Context, lineItemIds [
 }
 
 func (t *InvoiceLineItem) GetForInvoiceId(ctx *context.Context, invoiceId string) ([]*models.InvoiceLineItem, error) {
+	return t.GetForInvoiceIdWithTx(ctx, ctx.DB, invoiceId)
+}
+
+func (t *InvoiceLineItem) GetForInvoiceIdWithTx(ctx *context.Context, db *gorm.DB, invoiceId string) ([]*models.InvoiceLineItem, error) {
+	table, err := t.getTable(ctx)
+	if err != nil {
+		return nil, err
+	}
 
 	invoiceLineItems := []*models.InvoiceLineItem{}
 
-	tx := ctx.DB.Debug().Table(t.getTable(ctx))
+	tx := db.Debug().Table(table)
 	if invoiceId != "" {
 		tx.Where("invoice_id = ?", invoiceId)
 	}
 
-	err := tx.Find(&invoiceLineItems).Error
+	err = tx.Find(&invoiceLineItems).Error
 	if err != nil {
 		return nil, err
 	}
@@ -336,12 +437,20 @@ func (t *InvoiceLineItem) GetForInvoiceId(ctx *context.Context, invoiceId string
 }
 
 func (t *InvoiceLineItem) GetTotalSoFar(ctx *context.Context, lineItemId uuid.UUID, invoiceType string) ([]*models.InvoiceLineItem, error) {
+	table, err := t.getTable(ctx)
+	if err != nil {
+		return nil, err
+	}
+	invoicesTable, err := t.getInvoicesTable(ctx)
+	if err != nil {
+		return nil, err
+	}
 
 	invoicelineItems := []*models.InvoiceLineItem{}
 
-	tx := ctx.DB.Table(t.getTable(ctx)).Joins("JOIN invoices ON invoices.id = invoice_line_items.invoice_id").Where("invoice_line_items.line_item_id = ? AND invoices.invoice_type = ?", lineItemId, invoiceType)
+	tx := ctx.DB.Table(table).Joins("JOIN "+invoicesTable+" invoices ON invoices.id = invoice_line_items.invoice_id").Where("invoice_line_items.line_item_id = ? AND invoices.invoice_type = ?", lineItemId, invoiceType)
 
-	err := tx.Find(&invoicelineItems).Error
+	err = tx.Find(&invoicelineItems).Error
 	if err != nil {
 		return nil, err
 	}
@@ -350,8 +459,17 @@ func (t *InvoiceLineItem) GetTotalSoFar(ctx *context.Context, lineItemId uuid.UU
 }
 
 func (t *InvoiceLineItem) GetInvoiceLineItemsFilter(ctx *context.Context, filters *models.InvoiceLineItemsFilters) ([]*models.InvoiceLineItem, error) {
+	table, err := t.getTable(ctx)
+	if err != nil {
+		return nil, err
+	}
+	invoicesTable, err := t.getInvoicesTable(ctx)
+	if err != nil {
+		return nil, err
+	}
+
 	invoiceLineItems := []*models.InvoiceLineItem{}
-	tx := ctx.DB.Table(t.getTable(ctx)).Debug().Joins("JOIN invoices ON invoices.id = invoice_line_items.invoice_id")
+	tx := ctx.DB.Table(table).Debug().Joins("JOIN " + invoicesTable + " invoices ON invoices.id = invoice_line_items.invoice_id")
 
 	if len(filters.InvoiceIds) > 0 {
 		tx.Where("invoice_line_items.invoice_id in (?)", filters.InvoiceIds)
@@ -365,7 +483,7 @@ func (t *InvoiceLineItem) GetInvoiceLineItemsFilter(ctx *context.Context, filter
 		tx.Where("invoice_line_items.line_item_id in (?)", filters.LineItemIds)
 	}
 
-	err := tx.Find(&invoiceLineItems).Error
+	err = tx.Find(&invoiceLineItems).Error
 	if err != nil {
 		return nil, err
 	}
@@ -374,8 +492,13 @@ func (t *InvoiceLineItem) GetInvoiceLineItemsFilter(ctx *context.Context, filter
 }
 
 func (t *InvoiceLineItem) DeleteInvoiceLineItemsByInvoiceId(ctx *context.Context, InvoiceId string) error {
+	table, err := t.getTable(ctx)
+	if err != nil {
+		return err
+	}
+
 	var result models.InvoiceLineItem
-	err := ctx.DB.Table(t.getTable(ctx)).Delete(&result, "invoice_id = ?", InvoiceId).Error
+	err = ctx.DB.Table(table).Delete(&result, "invoice_id = ?", InvoiceId).Error
 	if err != nil {
 		ctx.Log.Error("Unable to delete invoicelineitem.", zap.Error(err))
 		return err
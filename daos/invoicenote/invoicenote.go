@@ -0,0 +1,103 @@
+package invoicenote
+
+import (
+	"bitbucket.org/radarventures/forwarder-adapters/utils/context"
+	"bitbucket.org/radarventures/forwarder-shipments/daos/tenant"
+	"bitbucket.org/radarventures/forwarder-shipments/database/models"
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+)
+
+type IInvoiceNote interface {
+	UpsertWithTx(ctx *context.Context, tx *gorm.DB, m ...*models.InvoiceNote) error
+	GetByInvoiceId(ctx *context.Context, invoiceId string) ([]*models.InvoiceNote, error)
+	GetByInvoiceIdWithTx(ctx *context.Context, tx *gorm.DB, invoiceId string) ([]*models.InvoiceNote, error)
+	GetByInvoiceIds(ctx *context.Context, invoiceIds []string) ([]*models.InvoiceNote, error)
+	GetByNoteInvoiceId(ctx *context.Context, noteInvoiceId string) (*models.InvoiceNote, error)
+}
+
+type InvoiceNote struct {
+}
+
+func NewInvoiceNote() IInvoiceNote {
+	return &InvoiceNote{}
+}
+
+func (t *InvoiceNote) getTable(ctx *context.Context) (string, error) {
+	return tenant.Table(ctx, "invoice_notes")
+}
+
+func (t *InvoiceNote) UpsertWithTx(ctx *context.Context, tx *gorm.DB, m ...*models.InvoiceNote) error {
+	table, err := t.getTable(ctx)
+	if err != nil {
+		return err
+	}
+
+	err = tx.Table(table).Save(m).Error
+	if err != nil {
+		ctx.Log.Error("unable to upsert invoice notes with tx", zap.Error(err))
+	}
+	return err
+}
+
+func (t *InvoiceNote) GetByInvoiceId(ctx *context.Context, invoiceId string) ([]*models.InvoiceNote, error) {
+	return t.GetByInvoiceIdWithTx(ctx, ctx.DB.WithContext(ctx.Request.Context()), invoiceId)
+}
+
+func (t *InvoiceNote) GetByInvoiceIdWithTx(ctx *context.Context, tx *gorm.DB, invoiceId string) ([]*models.InvoiceNote, error) {
+	table, err := t.getTable(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var result []*models.InvoiceNote
+	err = tx.Table(table).
+		Where("invoice_id = ?", invoiceId).
+		Order("created_at").
+		Find(&result).Error
+	if err != nil {
+		ctx.Log.Error("Unable to get invoice notes.", zap.Error(err))
+		return nil, err
+	}
+
+	return result, nil
+}
+
+func (t *InvoiceNote) GetByInvoiceIds(ctx *context.Context, invoiceIds []string) ([]*models.InvoiceNote, error) {
+	table, err := t.getTable(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var result []*models.InvoiceNote
+	if len(invoiceIds) == 0 {
+		return result, nil
+	}
+
+	err = ctx.DB.WithContext(ctx.Request.Context()).Table(table).
+		Where("invoice_id IN (?)", invoiceIds).
+		Order("created_at").
+		Find(&result).Error
+	if err != nil {
+		ctx.Log.Error("Unable to get invoice notes.", zap.Error(err))
+		return nil, err
+	}
+
+	return result, nil
+}
+
+func (t *InvoiceNote) GetByNoteInvoiceId(ctx *context.Context, noteInvoiceId string) (*models.InvoiceNote, error) {
+	table, err := t.getTable(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var result models.InvoiceNote
+	err = ctx.DB.WithContext(ctx.Request.Context()).Table(table).First(&result, "note_invoice_id = ?", noteInvoiceId).Error
+	if err != nil {
+		ctx.Log.Error("Unable to get invoice note.", zap.Error(err))
+		return nil, err
+	}
+
+	return &result, nil
+}
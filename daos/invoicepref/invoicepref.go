@@ -2,8 +2,12 @@ package invoicepref
 
 import (
 	"bitbucket.org/radarventures/forwarder-adapters/utils/context"
+	"bitbucket.org/radarventures/forwarder-shipments/constants"
+	"bitbucket.org/radarventures/forwarder-shipments/daos/tenant"
 	"bitbucket.org/radarventures/forwarder-shipments/database/models"
+	"github.com/google/uuid"
 	"go.uber.org/zap"
+	"gorm.io/gorm"
 	"gorm.io/gorm/clause"
 )
 
@@ -13,6 +17,10 @@ type IInvoicePref interface {
 	Get(ctx *context.Context, shipmentId, regionId, companyId, prefType string) (*models.InvoicePref, error)
 	GetAll(ctx *context.Context, shipmentId, prefType string) ([]*models.InvoicePref, error)
 	Delete(ctx *context.Context, id string) error
+
+	UpsertNumberTemplates(ctx *context.Context, m *models.InvoiceNumberTemplates) error
+	GetNumberTemplates(ctx *context.Context, regionId string) (*models.InvoiceNumberTemplates, error)
+	GetNumberTemplatesWithTx(ctx *context.Context, tx *gorm.DB, regionId string) (*models.InvoiceNumberTemplates, error)
 }
 
 type InvoicePref struct {
@@ -22,13 +30,18 @@ func NewInvoicePref() IInvoicePref {
 	return &InvoicePref{}
 }
 
-func (t *InvoicePref) getTable(ctx *context.Context) string {
-	return ctx.TenantID + "." + "invoice_prefs"
+func (t *InvoicePref) getTable(ctx *context.Context) (string, error) {
+	return tenant.Table(ctx, "invoice_prefs")
 }
 
 func (t *InvoicePref) Upsert(ctx *context.Context, m ...*models.InvoicePref) error {
+	table, err := t.getTable(ctx)
+	if err != nil {
+		return err
+	}
+
 	for _, invoicePref := range m {
-		if err := ctx.DB.WithContext(ctx.Request.Context()).Table(t.getTable(ctx)).
+		if err := ctx.DB.WithContext(ctx.Request.Context()).Table(table).
 			Clauses(clause.OnConflict{
 				UpdateAll: true,
 				Columns:   []clause.Column{{Name: "region_id"}, {Name: "shipment_id"}, {Name: "type"}, {Name: "company_id"}},
@@ -41,12 +54,22 @@ func (t *InvoicePref) Upsert(ctx *context.Context, m ...*models.InvoicePref) err
 }
 
 func (t *InvoicePref) Update(ctx *context.Context, m *models.InvoicePref) error {
-	return ctx.DB.Debug().WithContext(ctx.Request.Context()).Table(t.getTable(ctx)).Updates(m).Error
+	table, err := t.getTable(ctx)
+	if err != nil {
+		return err
+	}
+
+	return ctx.DB.Debug().WithContext(ctx.Request.Context()).Table(table).Updates(m).Error
 }
 
 func (t *InvoicePref) Get(ctx *context.Context, shipmentId, regionId, companyId, prefType string) (*models.InvoicePref, error) {
+	table, err := t.getTable(ctx)
+	if err != nil {
+		return nil, err
+	}
+
 	var result models.InvoicePref
-	err := ctx.DB.Table(t.getTable(ctx)).First(&result, "shipment_id = ? and region_id = ? and type = ? and company_id = ?", shipmentId, regionId, prefType, companyId).Error
+	err = ctx.DB.Table(table).First(&result, "shipment_id = ? and region_id = ? and type = ? and company_id = ?", shipmentId, regionId, prefType, companyId).Error
 	if err != nil {
 		ctx.Log.Error("Unable to get invoicepref.", zap.Error(err))
 	}
@@ -55,8 +78,13 @@ func (t *InvoicePref) Get(ctx *context.Context, shipmentId, regionId, companyId,
 }
 
 func (t *InvoicePref) Delete(ctx *context.Context, id string) error {
+	table, err := t.getTable(ctx)
+	if err != nil {
+		return err
+	}
+
 	var result models.InvoicePref
-	err := ctx.DB.Table(t.getTable(ctx)).Delete(&result, "id = ?", id).Error
+	err = ctx.DB.Table(table).Delete(&result, "id = ?", id).Error
 	if err != nil {
 		ctx.Log.Error("Unable to delete invoicepref.", zap.Error(err))
 		return err
@@ -66,9 +94,14 @@ func (t *InvoicePref) Delete(ctx *context.Context, id string) error {
 }
 
 func (t *InvoicePref) GetAll(ctx *context.Context, shipmentId, prefType string) ([]*models.InvoicePref, error) {
+	table, err := t.getTable(ctx)
+	if err != nil {
+		return nil, err
+	}
+
 	var result []*models.InvoicePref
 
-	tx := ctx.DB.Table(t.getTable(ctx))
+	tx := ctx.DB.Table(table)
 
 	if shipmentId != "" {
 		tx.Where("shipment_id = ?", shipmentId)
@@ -78,7 +111,7 @@ func (t *InvoicePref) GetAll(ctx *context.Context, shipmentId, prefType string)
 		tx.Where("type = ?", prefType)
 	}
 
-	err := tx.Find(&result).Error
+	err = tx.Find(&result).Error
 	if err != nil {
 		ctx.Log.Error("Unable to get invoiceprefs.", zap.Error(err))
 		return nil, err
@@ -86,3 +119,66 @@ func (t *InvoicePref) GetAll(ctx *context.Context, shipmentId, prefType string)
 
 	return result, err
 }
+
+// UpsertNumberTemplates stores the templates on the region's number format pref, a region
+// level row without shipment or company.
+func (t *InvoicePref) UpsertNumberTemplates(ctx *context.Context, m *models.InvoiceNumberTemplates) error {
+	table, err := t.getTable(ctx)
+	if err != nil {
+		return err
+	}
+
+	row := map[string]interface{}{
+		"id":               uuid.New(),
+		"region_id":        m.RegionId,
+		"shipment_id":      uuid.Nil,
+		"company_id":       uuid.Nil,
+		"type":             constants.InvoicePrefNumberFormat,
+		"region_code":      m.RegionCode,
+		"number_templates": m.Templates,
+		"updated_by":       m.UpdatedBy,
+		"created_at":       m.UpdatedAt,
+		"updated_at":       m.UpdatedAt,
+	}
+
+	err = ctx.DB.WithContext(ctx.Request.Context()).Table(table).
+		Clauses(clause.OnConflict{
+			Columns:   []clause.Column{{Name: "region_id"}, {Name: "shipment_id"}, {Name: "type"}, {Name: "company_id"}},
+			DoUpdates: clause.AssignmentColumns([]string{"region_code", "number_templates", "updated_by", "updated_at"}),
+		}).
+		Create(row).Error
+	if err != nil {
+		ctx.Log.Error("Unable to upsert invoice number templates.", zap.Error(err))
+	}
+
+	return err
+}
+
+// GetNumberTemplates returns nil without an error when the region has no templates.
+func (t *InvoicePref) GetNumberTemplates(ctx *context.Context, regionId string) (*models.InvoiceNumberTemplates, error) {
+	return t.GetNumberTemplatesWithTx(ctx, ctx.DB.WithContext(ctx.Request.Context()), regionId)
+}
+
+func (t *InvoicePref) GetNumberTemplatesWithTx(ctx *context.Context, tx *gorm.DB, regionId string) (*models.InvoiceNumberTemplates, error) {
+	table, err := t.getTable(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var result []*models.InvoiceNumberTemplates
+	err = tx.Table(table).
+		Select("region_id, region_code, number_templates, updated_by, updated_at").
+		Where("region_id = ? AND shipment_id = ? AND company_id = ? AND type = ?", regionId, uuid.Nil, uuid.Nil, constants.InvoicePrefNumberFormat).
+		Limit(1).
+		Find(&result).Error
+	if err != nil {
+		ctx.Log.Error("Unable to get invoice number templates.", zap.Error(err))
+		return nil, err
+	}
+
+	if len(result) == 0 {
+		return nil, nil
+	}
+
+	return result[0], nil
+}
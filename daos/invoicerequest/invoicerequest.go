@@ -2,8 +2,10 @@ package invoicerequest
 
 import (
 	"errors"
+	"time"
 
 	"bitbucket.org/radarventures/forwarder-adapters/utils/context"
+	"bitbucket.org/radarventures/forwarder-shipments/daos/tenant"
 	"bitbucket.org/radarventures/forwarder-shipments/database/models"
 	"github.com/google/uuid"
 	"go.uber.org/zap"
@@ -20,6 +22,10 @@ type IInvoiceRequest interface {
 	ValidateAndAuditRequest(ctx *context.Context, shipmentId, regionId uuid.UUID, invType string) (*models.InvoiceRequest, error)
 	UpdateStatus(ctx *context.Context, err error) error
 	MarkCompleted(ctx *context.Context) error
+
+	IncrementRetry(ctx *context.Context, id uuid.UUID, maxAttempts int, backoffBase, backoffMax time.Duration, resErr error) (int, bool, error)
+	GetStuck(ctx *context.Context, regionId string, deadLetterOnly bool) ([]*models.StuckInvoiceRequest, error)
+	ResetRetry(ctx *context.Context, ids []uuid.UUID) (int64, error)
 }
 
 type InvoiceRequest struct {
@@ -29,21 +35,36 @@ func NewInvoiceRequest() IInvoiceRequest {
 	return &InvoiceRequest{}
 }
 
-func (t *InvoiceRequest) getTable(ctx *context.Context) string {
-	return ctx.TenantID + "." + "invoice_requests"
+func (t *InvoiceRequest) getTable(ctx *context.Context) (string, error) {
+	return tenant.Table(ctx, "invoice_requests")
 }
 
 func (t *InvoiceRequest) Upsert(ctx *context.Context, m ...*models.InvoiceRequest) error {
-	return ctx.DB.Table(t.getTable(ctx)).Save(m).Error
+	table, err := t.getTable(ctx)
+	if err != nil {
+		return err
+	}
+
+	return ctx.DB.Table(table).Save(m).Error
 }
 
 func (t *InvoiceRequest) Update(ctx *context.Context, m *models.InvoiceRequest) error {
-	return ctx.DB.WithContext(ctx.Request.Context()).Table(t.getTable(ctx)).Debug().Where("id = ?", m.ID).Updates(m).Error
+	table, err := t.getTable(ctx)
+	if err != nil {
+		return err
+	}
+
+	return ctx.DB.WithContext(ctx.Request.Context()).Table(table).Debug().Where("id = ?", m.ID).Updates(m).Error
 }
 
 func (t *InvoiceRequest) Get(ctx *context.Context, id string) (*models.InvoiceRequest, error) {
+	table, err := t.getTable(ctx)
+	if err != nil {
+		return nil, err
+	}
+
 	var result models.InvoiceRequest
-	err := ctx.DB.Table(t.getTable(ctx)).First(&result, "id = ?", id).Error
+	err = ctx.DB.Table(table).First(&result, "id = ?", id).Error
 	if err != nil {
 		ctx.Log.Error("Unable to get invoicerequest.", zap.Error(err))
 		return nil, err
@@ -53,8 +74,13 @@ func (t *InvoiceRequest) Get(ctx *context.Context, id string) (*models.InvoiceRe
 }
 
 func (t *InvoiceRequest) Delete(ctx *context.Context, id string) error {
+	table, err := t.getTable(ctx)
+	if err != nil {
+		return err
+	}
+
 	var result models.InvoiceRequest
-	err := ctx.DB.Table(t.getTable(ctx)).Delete(&result, "id = ?", id).Error
+	err = ctx.DB.Table(table).Delete(&result, "id = ?", id).Error
 	if err != nil {
 		ctx.Log.Error("Unable to delete invoicerequest.", zap.Error(err))
 		return err
@@ -64,16 +90,21 @@ func (t *InvoiceRequest) Delete(ctx *context.Context, id string) error {
 }
 
 func (t *InvoiceRequest) GetAll(ctx *context.Context, ids []string) ([]*models.InvoiceRequest, error) {
+	table, err := t.getTable(ctx)
+	if err != nil {
+		return nil, err
+	}
+
 	var result []*models.InvoiceRequest
 	if len(ids) == 0 {
-		err := ctx.DB.Table(t.getTable(ctx)).Find(&result).Error
+		err := ctx.DB.Table(table).Find(&result).Error
 		if err != nil {
 			ctx.Log.Error("Unable to get invoicerequests.", zap.Error(err))
 			return nil, err
 		}
 		return result, err
 	}
-	err := ctx.DB.Table(t.getTable(ctx)).Where("id IN ?", ids).Find(&result).Error
+	err = ctx.DB.Table(table).Where("id IN ?", ids).Find(&result).Error
 	if err != nil {
 		ctx.Log.Error("Unable to get invoicerequests.", zap.Error(err))
 		return nil, err
@@ -83,13 +114,17 @@ func (t *InvoiceRequest) GetAll(ctx *context.Context, ids []string) ([]*models.I
 }
 
 func (t *InvoiceRequest) ValidateAndAuditRequest(ctx *context.Context, shipmentId, regionId uuid.UUID, invType string) (*models.InvoiceRequest, error) {
+	table, err := t.getTable(ctx)
+	if err != nil {
+		return nil, err
+	}
 
 	requestId, err := uuid.Parse(ctx.RefID)
 	if err != nil {
 		ctx.Log.Error("invalid request id", zap.Any("id", ctx.RefID), zap.Error(err))
 	}
 
-	tx := ctx.DB.Table(t.getTable(ctx)).
+	tx := ctx.DB.Table(table).
 		Clauses(clause.Locking{Strength: "SHARE", Options: "NOWAIT"}).
 		Begin()
 	err = tx.Error
@@ -133,6 +168,11 @@ func (t *InvoiceRequest) ValidateAndAuditRequest(ctx *context.Context, shipmentI
 }
 
 func (t *InvoiceRequest) UpdateStatus(ctx *context.Context, resErr error) error {
+	table, err := t.getTable(ctx)
+	if err != nil {
+		return err
+	}
+
 	columns := make(map[string]interface{})
 	columns["is_successful"] = true
 	if resErr != nil {
@@ -140,7 +180,7 @@ func (t *InvoiceRequest) UpdateStatus(ctx *context.Context, resErr error) error
 		columns["error_message"] = resErr.Error()
 	}
 
-	err := ctx.DB.Table(t.getTable(ctx)).Where("id = ?", ctx.RefID).UpdateColumns(columns).Error
+	err = ctx.DB.Table(table).Where("id = ?", ctx.RefID).UpdateColumns(columns).Error
 	if err != nil {
 		ctx.Log.Error("unable to update invoicerequest", zap.Error(err))
 	}
@@ -148,9 +188,110 @@ func (t *InvoiceRequest) UpdateStatus(ctx *context.Context, resErr error) error
 }
 
 func (t *InvoiceRequest) MarkCompleted(ctx *context.Context) error {
-	err := ctx.DB.Table(t.getTable(ctx)).Where("id = ?", ctx.RefID).Update("is_completed", true).Error
+	table, err := t.getTable(ctx)
+	if err != nil {
+		return err
+	}
+
+	err = ctx.DB.Table(table).Where("id = ?", ctx.RefID).Update("is_completed", true).Error
 	if err != nil {
 		ctx.Log.Error("unable to update invoicerequest", zap.Error(err))
 	}
 	return err
 }
+
+// IncrementRetry records a failed retrieval attempt of the invoice request and when it is due
+// next. The count is raised in the database, so failures of overlapping runs are all counted.
+// The delay doubles from backoffBase up to backoffMax, and the request is parked in the
+// dead-letter state once it has failed maxAttempts times. It returns the new count and whether
+// the request was parked.
+func (t *InvoiceRequest) IncrementRetry(ctx *context.Context, id uuid.UUID, maxAttempts int, backoffBase, backoffMax time.Duration, resErr error) (int, bool, error) {
+	table, err := t.getTable(ctx)
+	if err != nil {
+		return 0, false, err
+	}
+
+	var errMsg *string
+	if resErr != nil {
+		msg := resErr.Error()
+		errMsg = &msg
+	}
+
+	var res struct {
+		RetryCount   int
+		IsDeadLetter bool
+	}
+	err = ctx.DB.Raw(`UPDATE `+table+` SET
+	retry_count = COALESCE(retry_count, 0) + 1,
+	is_dead_letter = COALESCE(retry_count, 0) + 1 >= ?,
+	next_retry_at = now() + LEAST(? * power(2, LEAST(COALESCE(retry_count, 0), 30)), ?) * interval '1 second',
+	error_message = COALESCE(?, error_message)
+WHERE id = ?
+RETURNING retry_count, is_dead_letter`,
+		maxAttempts, backoffBase.Seconds(), backoffMax.Seconds(), errMsg, id).Scan(&res).Error
+	if err != nil {
+		ctx.Log.Error("unable to update invoicerequest retry", zap.Error(err))
+		return 0, false, err
+	}
+
+	return res.RetryCount, res.IsDeadLetter, nil
+}
+
+// GetStuck lists the pending invoice requests that failed at least once, oldest first.
+func (t *InvoiceRequest) GetStuck(ctx *context.Context, regionId string, deadLetterOnly bool) ([]*models.StuckInvoiceRequest, error) {
+	table, err := t.getTable(ctx)
+	if err != nil {
+		return nil, err
+	}
+	invoicesTable, err := tenant.Table(ctx, "invoices")
+	if err != nil {
+		return nil, err
+	}
+
+	var result []*models.StuckInvoiceRequest
+
+	tx := ctx.DB.Table(table + " ir").
+		Select(`ir.id, i.id AS invoice_id, i.no AS invoice_no, ir.invoice_type, ir.shipment_id, ir.region_id,
+			ir.retry_count, ir.next_retry_at, COALESCE(ir.is_dead_letter, false) AS is_dead_letter,
+			ir.error_message AS last_error, ir.created_at`).
+		Joins("LEFT JOIN " + invoicesTable + " i ON i.invoice_request_id = ir.id").
+		Where("ir.is_completed = false AND ir.retry_count > 0")
+
+	if regionId != "" {
+		tx.Where("ir.region_id = ?", regionId)
+	}
+
+	if deadLetterOnly {
+		tx.Where("ir.is_dead_letter = true")
+	}
+
+	err = tx.Order("ir.created_at").Scan(&result).Error
+	if err != nil {
+		ctx.Log.Error("unable to get stuck invoicerequests", zap.Error(err))
+		return nil, err
+	}
+
+	return result, nil
+}
+
+// ResetRetry makes stuck invoice requests due on the next retrieval run with a fresh set of
+// attempts. Completed requests are left untouched.
+func (t *InvoiceRequest) ResetRetry(ctx *context.Context, ids []uuid.UUID) (int64, error) {
+	table, err := t.getTable(ctx)
+	if err != nil {
+		return 0, err
+	}
+
+	columns := make(map[string]interface{})
+	columns["retry_count"] = 0
+	columns["next_retry_at"] = nil
+	columns["is_dead_letter"] = false
+
+	res := ctx.DB.Table(table).Where("id IN (?) AND is_completed = false", ids).UpdateColumns(columns)
+	if res.Error != nil {
+		ctx.Log.Error("unable to reset invoicerequest retry", zap.Error(res.Error))
+		return 0, res.Error
+	}
+
+	return res.RowsAffected, nil
+}
@@ -2,22 +2,40 @@ package invoicesequence
 
 import (
 	"errors"
+	"fmt"
+	"strconv"
 	"strings"
+	"time"
 
 	"bitbucket.org/radarventures/forwarder-adapters/utils/context"
 	"bitbucket.org/radarventures/forwarder-shipments/constants"
+	"bitbucket.org/radarventures/forwarder-shipments/daos/invoicepref"
+	"bitbucket.org/radarventures/forwarder-shipments/daos/tenant"
 	"bitbucket.org/radarventures/forwarder-shipments/database/models"
 	"github.com/google/uuid"
 	"go.uber.org/zap"
 	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
 )
 
+// DefaultFiscalYearStartMonth labels the fiscal year of regions without an
+// invoice_fiscal_years row, their sequences never restart.
+const DefaultFiscalYearStartMonth = time.April
+
 type IInvoiceSequence interface {
 	Upsert(ctx *context.Context, m ...*models.InvoiceSequence) error
 	Get(ctx *context.Context, id string) (*models.InvoiceSequence, error)
 	GetAll(ctx *context.Context, ids []string) ([]*models.InvoiceSequence, error)
 	Delete(ctx *context.Context, id string) error
 	NewInvoiceNumber(ctx *context.Context, invoiceType, voucherType string, regionId uuid.UUID) (int64, error)
+	NewInvoiceNumberWithTx(ctx *context.Context, tx *gorm.DB, invoiceType, voucherType string, regionId uuid.UUID, invoicedAt time.Time) (int64, error)
+	GetLastInvoiceNumber(ctx *context.Context, invoiceType, voucherType string, regionId uuid.UUID, at time.Time) (int64, error)
+	GetSequenceName(ctx *context.Context, invoiceType, voucherType string, regionId uuid.UUID, at time.Time) (string, error)
+	VoidInvoiceNumber(ctx *context.Context, void *models.InvoiceNumberVoid) error
+	VoidInvoiceNumberWithTx(ctx *context.Context, tx *gorm.DB, void *models.InvoiceNumberVoid) error
+	GetVoidedNumbers(ctx *context.Context, regionId uuid.UUID, sequenceName string) ([]*models.InvoiceNumberVoid, error)
+	UpsertFiscalYear(ctx *context.Context, m *models.InvoiceFiscalYear) error
+	GetFiscalYearStartMonth(ctx *context.Context, regionId uuid.UUID) (time.Month, error)
 }
 
 type InvoiceSequence struct {
@@ -27,17 +45,27 @@ func NewInvoiceSequence() IInvoiceSequence {
 	return &InvoiceSequence{}
 }
 
-func (t *InvoiceSequence) getTable(ctx *context.Context) string {
-	return ctx.TenantID + "." + "invoice_sequences"
+func (t *InvoiceSequence) getTable(ctx *context.Context) (string, error) {
+	return tenant.Table(ctx, "invoice_sequences")
 }
 
 func (t *InvoiceSequence) Upsert(ctx *context.Context, m ...*models.InvoiceSequence) error {
-	return ctx.DB.Table(t.getTable(ctx)).Save(m).Error
+	table, err := t.getTable(ctx)
+	if err != nil {
+		return err
+	}
+
+	return ctx.DB.Table(table).Save(m).Error
 }
 
 func (t *InvoiceSequence) Get(ctx *context.Context, id string) (*models.InvoiceSequence, error) {
+	table, err := t.getTable(ctx)
+	if err != nil {
+		return nil, err
+	}
+
 	var result models.InvoiceSequence
-	err := ctx.DB.Table(t.getTable(ctx)).First(&result, "id = ?", id).Error
+	err = ctx.DB.Table(table).First(&result, "id = ?", id).Error
 	if err != nil {
 		ctx.Log.Error("Unable to get invoicesequence.", zap.Error(err))
 		return nil, err
@@ -47,8 +75,13 @@ func (t *InvoiceSequence) Get(ctx *context.Context, id string) (*models.InvoiceS
 }
 
 func (t *InvoiceSequence) Delete(ctx *context.Context, id string) error {
+	table, err := t.getTable(ctx)
+	if err != nil {
+		return err
+	}
+
 	var result models.InvoiceSequence
-	err := ctx.DB.Table(t.getTable(ctx)).Delete(&result, "id = ?", id).Error
+	err = ctx.DB.Table(table).Delete(&result, "id = ?", id).Error
 	if err != nil {
 		ctx.Log.Error("Unable to delete invoicesequence.", zap.Error(err))
 		return err
@@ -58,16 +91,21 @@ func (t *InvoiceSequence) Delete(ctx *context.Context, id string) error {
 }
 
 func (t *InvoiceSequence) GetAll(ctx *context.Context, ids []string) ([]*models.InvoiceSequence, error) {
+	table, err := t.getTable(ctx)
+	if err != nil {
+		return nil, err
+	}
+
 	var result []*models.InvoiceSequence
 	if len(ids) == 0 {
-		err := ctx.DB.Table(t.getTable(ctx)).Find(&result).Error
+		err := ctx.DB.Table(table).Find(&result).Error
 		if err != nil {
 			ctx.Log.Error("Unable to get invoicesequences.", zap.Error(err))
 			return nil, err
 		}
 		return result, err
 	}
-	err := ctx.DB.Table(t.getTable(ctx)).Where("id IN ?", ids).Find(&result).Error
+	err = ctx.DB.Table(table).Where("id IN ?", ids).Find(&result).Error
 	if err != nil {
 		ctx.Log.Error("Unable to get invoicesequences.", zap.Error(err))
 		return nil, err
@@ -76,43 +114,358 @@ func (t *InvoiceSequence) GetAll(ctx *context.Context, ids []string) ([]*models.
 	return result, err
 }
 
+func (i *InvoiceSequence) getVoidTable(ctx *context.Context) (string, error) {
+	return tenant.Table(ctx, "invoice_number_voids")
+}
+
+func (i *InvoiceSequence) getFiscalYearTable(ctx *context.Context) (string, error) {
+	return tenant.Table(ctx, "invoice_fiscal_years")
+}
+
+// NewInvoiceNumber allocates the next number in its own transaction. Callers that
+// persist the invoice should prefer NewInvoiceNumberWithTx so that a rollback also
+// returns the number to the sequence and no gap is left behind.
 func (i *InvoiceSequence) NewInvoiceNumber(ctx *context.Context, invoiceType string, voucherType string, regionId uuid.UUID) (int64, error) {
+	var no int64
+
+	err := ctx.DB.WithContext(ctx.Request.Context()).Transaction(func(tx *gorm.DB) error {
+		var err error
+		no, err = i.NewInvoiceNumberWithTx(ctx, tx, invoiceType, voucherType, regionId, time.Now().UTC())
+		return err
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	return no, nil
+}
+
+// NewInvoiceNumberWithTx allocates the next number of the voucher type's sequence. The
+// sequence restarts every fiscal year only when the region sets its fiscal year and renders
+// the voucher type with a {FY} template, see getResetMonth. The sequence row stays locked
+// until tx ends, so concurrent allocations for the same region and sequence are serialised
+// and never hand out the same number.
+func (i *InvoiceSequence) NewInvoiceNumberWithTx(ctx *context.Context, tx *gorm.DB, invoiceType string, voucherType string, regionId uuid.UUID, invoicedAt time.Time) (int64, error) {
+	table, err := i.getTable(ctx)
+	if err != nil {
+		return 0, err
+	}
+
 	seqName, err := getSequenceName(ctx, invoiceType, voucherType)
 	if err != nil {
 		return 0, err
 	}
 
-	sequence := models.InvoiceSequence{
-		RegionId: regionId,
-		Name:     seqName,
+	startMonth, yearly, err := i.getResetMonth(ctx, tx, invoiceType, voucherType, regionId)
+	if err != nil {
+		return 0, err
+	}
+
+	name := seqName
+	if yearly {
+		name = seqName + "_" + FiscalYear(startMonth, invoicedAt)
+	}
+	accountId := accountId(ctx)
+
+	// Serialise the first allocation of a sequence, when there is no row to lock yet
+	err = tx.Exec("SELECT pg_advisory_xact_lock(hashtext(?))", table+":"+regionId.String()+":"+name).Error
+	if err != nil {
+		ctx.Log.Error("Failed to acquire invoice sequence lock.", zap.Error(err))
+		return 0, err
 	}
 
-	// Fetch the existing sequence
-	err = ctx.DB.Table(i.getTable(ctx)).
-		Where("region_id = ? AND name = ?", regionId, seqName).
+	sequence := models.InvoiceSequence{}
+	err = tx.Table(table).
+		Clauses(clause.Locking{Strength: "UPDATE"}).
+		Where("region_id = ? AND name = ?", regionId, name).
 		First(&sequence).Error
 
 	if err != nil && errors.Is(err, gorm.ErrRecordNotFound) {
-		// If not found, set initial values for a new sequence
-		sequence.No = 1
-		sequence.CreatedBy = ctx.Account.ID
-		sequence.UpdatedBy = ctx.Account.ID
+		// Continue from the continuous sequence if it was last used in this fiscal year,
+		// otherwise numbers already issued this year would be handed out again.
+		legacy := models.InvoiceSequence{}
+		if yearly {
+			err = tx.Table(table).
+				Clauses(clause.Locking{Strength: "UPDATE"}).
+				Where("region_id = ? AND name = ? AND updated_at >= ?", regionId, seqName, FiscalYearStart(startMonth, invoicedAt)).
+				Limit(1).
+				Find(&legacy).Error
+			if err != nil {
+				ctx.Log.Error("Failed to retrieve invoice sequence.", zap.Error(err))
+				return 0, err
+			}
+		}
+
+		sequence = models.InvoiceSequence{
+			RegionId:  regionId,
+			Name:      name,
+			No:        legacy.No + 1,
+			CreatedBy: accountId,
+			UpdatedBy: accountId,
+		}
+
+		if err := tx.Table(table).Create(&sequence).Error; err != nil {
+			ctx.Log.Error("Failed to create invoice sequence.", zap.Error(err))
+			return 0, err
+		}
+
+		return sequence.No, nil
 	} else if err != nil {
 		ctx.Log.Error("Failed to retrieve invoice sequence.", zap.Error(err))
 		return 0, err
-	} else {
-		// If found, increment the sequence number and update fields
-		sequence.No++
-		sequence.UpdatedBy = ctx.Account.ID
 	}
 
-	// Save the record
-	if err := ctx.DB.Save(&sequence).Error; err != nil {
+	err = tx.Table(table).
+		Where("region_id = ? AND name = ?", regionId, name).
+		UpdateColumns(map[string]interface{}{
+			"no":         gorm.Expr("no + 1"),
+			"updated_by": accountId,
+			"updated_at": time.Now().UTC(),
+		}).Error
+	if err != nil {
 		ctx.Log.Error("Failed to save invoice sequence.", zap.Error(err))
 		return 0, err
 	}
 
-	return sequence.No, nil
+	return sequence.No + 1, nil
+}
+
+// GetLastInvoiceNumber returns the last number allocated in the sequence that at falls in
+// without consuming one, so upcoming numbers can be previewed.
+func (i *InvoiceSequence) GetLastInvoiceNumber(ctx *context.Context, invoiceType, voucherType string, regionId uuid.UUID, at time.Time) (int64, error) {
+	table, err := i.getTable(ctx)
+	if err != nil {
+		return 0, err
+	}
+
+	seqName, err := getSequenceName(ctx, invoiceType, voucherType)
+	if err != nil {
+		return 0, err
+	}
+
+	tx := ctx.DB.WithContext(ctx.Request.Context())
+
+	startMonth, yearly, err := i.getResetMonth(ctx, tx, invoiceType, voucherType, regionId)
+	if err != nil {
+		return 0, err
+	}
+
+	var result []*models.InvoiceSequence
+	if yearly {
+		err = tx.Table(table).
+			Where("region_id = ? AND name = ?", regionId, seqName+"_"+FiscalYear(startMonth, at)).
+			Limit(1).
+			Find(&result).Error
+		if err != nil {
+			ctx.Log.Error("Failed to retrieve invoice sequence.", zap.Error(err))
+			return 0, err
+		}
+		if len(result) > 0 {
+			return result[0].No, nil
+		}
+	}
+
+	// A restarting sequence continues from the continuous one if it was used this fiscal year
+	query := tx.Table(table).Where("region_id = ? AND name = ?", regionId, seqName)
+	if yearly {
+		query = query.Where("updated_at >= ?", FiscalYearStart(startMonth, at))
+	}
+
+	err = query.Limit(1).Find(&result).Error
+	if err != nil {
+		ctx.Log.Error("Failed to retrieve invoice sequence.", zap.Error(err))
+		return 0, err
+	}
+	if len(result) > 0 {
+		return result[0].No, nil
+	}
+
+	return 0, nil
+}
+
+// GetSequenceName returns the name of the sequence that at falls in, the name voided
+// numbers are recorded under.
+func (i *InvoiceSequence) GetSequenceName(ctx *context.Context, invoiceType, voucherType string, regionId uuid.UUID, at time.Time) (string, error) {
+	seqName, err := getSequenceName(ctx, invoiceType, voucherType)
+	if err != nil {
+		return "", err
+	}
+
+	startMonth, yearly, err := i.getResetMonth(ctx, ctx.DB.WithContext(ctx.Request.Context()), invoiceType, voucherType, regionId)
+	if err != nil || !yearly {
+		return seqName, err
+	}
+
+	return seqName + "_" + FiscalYear(startMonth, at), nil
+}
+
+// VoidInvoiceNumber records why an allocated number was burned instead of being issued.
+func (i *InvoiceSequence) VoidInvoiceNumber(ctx *context.Context, void *models.InvoiceNumberVoid) error {
+	return i.VoidInvoiceNumberWithTx(ctx, ctx.DB.WithContext(ctx.Request.Context()), void)
+}
+
+func (i *InvoiceSequence) VoidInvoiceNumberWithTx(ctx *context.Context, tx *gorm.DB, void *models.InvoiceNumberVoid) error {
+	voidTable, err := i.getVoidTable(ctx)
+	if err != nil {
+		return err
+	}
+
+	if void.Id == uuid.Nil {
+		void.Id = uuid.New()
+	}
+	if void.CreatedAt.IsZero() {
+		void.CreatedAt = time.Now().UTC()
+	}
+	if void.CreatedBy == uuid.Nil {
+		void.CreatedBy = accountId(ctx)
+	}
+
+	if strings.TrimSpace(void.Reason) == "" {
+		return errors.New("void reason is required")
+	}
+
+	err = tx.Table(voidTable).Create(void).Error
+	if err != nil {
+		ctx.Log.Error("Unable to void invoice number.", zap.Error(err), zap.Any("no", void.No), zap.Any("sequence", void.SequenceName))
+	}
+
+	return err
+}
+
+func (i *InvoiceSequence) GetVoidedNumbers(ctx *context.Context, regionId uuid.UUID, sequenceName string) ([]*models.InvoiceNumberVoid, error) {
+	voidTable, err := i.getVoidTable(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var result []*models.InvoiceNumberVoid
+
+	tx := ctx.DB.WithContext(ctx.Request.Context()).Table(voidTable).Where("region_id = ?", regionId)
+	if sequenceName != "" {
+		tx.Where("sequence_name = ?", sequenceName)
+	}
+
+	err = tx.Order("no").Find(&result).Error
+	if err != nil {
+		ctx.Log.Error("Unable to get voided invoice numbers.", zap.Error(err))
+		return nil, err
+	}
+
+	return result, nil
+}
+
+func (i *InvoiceSequence) UpsertFiscalYear(ctx *context.Context, m *models.InvoiceFiscalYear) error {
+	fiscalYearTable, err := i.getFiscalYearTable(ctx)
+	if err != nil {
+		return err
+	}
+
+	if m.StartMonth < int(time.January) || m.StartMonth > int(time.December) {
+		return errors.New("fiscal year start month must be between 1 and 12")
+	}
+
+	return ctx.DB.WithContext(ctx.Request.Context()).Table(fiscalYearTable).
+		Clauses(clause.OnConflict{
+			Columns:   []clause.Column{{Name: "region_id"}},
+			DoUpdates: clause.AssignmentColumns([]string{"start_month", "updated_by", "updated_at"}),
+		}).
+		Create(m).Error
+}
+
+func (i *InvoiceSequence) GetFiscalYearStartMonth(ctx *context.Context, regionId uuid.UUID) (time.Month, error) {
+	return i.getFiscalYearStartMonth(ctx, ctx.DB.WithContext(ctx.Request.Context()), regionId)
+}
+
+func (i *InvoiceSequence) getFiscalYearStartMonth(ctx *context.Context, tx *gorm.DB, regionId uuid.UUID) (time.Month, error) {
+	fiscalYear, err := i.getFiscalYear(ctx, tx, regionId)
+	if err != nil {
+		return 0, err
+	}
+
+	if fiscalYear == nil {
+		return DefaultFiscalYearStartMonth, nil
+	}
+
+	return time.Month(fiscalYear.StartMonth), nil
+}
+
+// getFiscalYear returns nil without an error when the region has no valid fiscal year set.
+func (i *InvoiceSequence) getFiscalYear(ctx *context.Context, tx *gorm.DB, regionId uuid.UUID) (*models.InvoiceFiscalYear, error) {
+	fiscalYearTable, err := i.getFiscalYearTable(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var result []*models.InvoiceFiscalYear
+
+	err = tx.Table(fiscalYearTable).Where("region_id = ?", regionId).Limit(1).Find(&result).Error
+	if err != nil {
+		ctx.Log.Error("Unable to get invoice fiscal year.", zap.Error(err))
+		return nil, err
+	}
+
+	if len(result) == 0 || result[0].StartMonth < int(time.January) || result[0].StartMonth > int(time.December) {
+		return nil, nil
+	}
+
+	return result[0], nil
+}
+
+// getResetMonth returns the month the voucher type's sequence restarts from 1 and false
+// when it never restarts. Only a region that sets its fiscal year and renders the voucher
+// type with a template holding {FY} restarts every fiscal year, without the fiscal year in
+// the rendered number a restarted sequence would issue the same numbers again. Proforma
+// invoices keep the default format and so never restart.
+func (i *InvoiceSequence) getResetMonth(ctx *context.Context, tx *gorm.DB, invoiceType, voucherType string, regionId uuid.UUID) (time.Month, bool, error) {
+	if strings.Contains(invoiceType, constants.CustomerProforma) {
+		return 0, false, nil
+	}
+
+	fiscalYear, err := i.getFiscalYear(ctx, tx, regionId)
+	if err != nil || fiscalYear == nil {
+		return 0, false, err
+	}
+
+	templates, err := invoicepref.NewInvoicePref().GetNumberTemplatesWithTx(ctx, tx, regionId.String())
+	if err != nil || templates == nil {
+		return 0, false, err
+	}
+
+	if !strings.Contains(templates.Templates[voucherType], "{FY}") {
+		return 0, false, nil
+	}
+
+	return time.Month(fiscalYear.StartMonth), true, nil
+}
+
+// FiscalYearStart returns the first instant of the fiscal year that at falls in.
+func FiscalYearStart(startMonth time.Month, at time.Time) time.Time {
+	year := at.Year()
+	if at.Month() < startMonth {
+		year--
+	}
+
+	return time.Date(year, startMonth, 1, 0, 0, 0, 0, at.Location())
+}
+
+// FiscalYear labels the fiscal year that at falls in, e.g. "2024-25" for an April
+// start or "2024" when the fiscal year matches the calendar year.
+func FiscalYear(startMonth time.Month, at time.Time) string {
+	start := FiscalYearStart(startMonth, at)
+	if startMonth == time.January {
+		return strconv.Itoa(start.Year())
+	}
+
+	return fmt.Sprintf("%d-%02d", start.Year(), (start.Year()+1)%100)
+}
+
+// accountId is the account allocating or voiding a number, or nil for cronjobs.
+func accountId(ctx *context.Context) uuid.UUID {
+	if ctx.Account == nil {
+		return uuid.Nil
+	}
+
+	return ctx.Account.ID
 }
 
 func getSequenceName(ctx *context.Context, invoiceType, voucherType string) (string, error) {
@@ -0,0 +1,329 @@
+package invoicesequence
+
+import (
+	"fmt"
+	"net/http/httptest"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"bitbucket.org/radarventures/forwarder-adapters/utils/context"
+	"bitbucket.org/radarventures/forwarder-adapters/utils/db"
+	ulog "bitbucket.org/radarventures/forwarder-adapters/utils/log"
+	"bitbucket.org/radarventures/forwarder-shipments/constants"
+	"bitbucket.org/radarventures/forwarder-shipments/daos/invoicepref"
+	"bitbucket.org/radarventures/forwarder-shipments/database/models"
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+const invoicePrefTable = `CREATE TABLE %s.invoice_prefs (
+	id uuid, region_id uuid, shipment_id uuid, company_id uuid, type text, region_code text,
+	number_templates jsonb, updated_by uuid, created_at timestamptz, updated_at timestamptz,
+	UNIQUE (region_id, shipment_id, type, company_id))`
+
+// The allocation tests need a Postgres database, TEST_DATABASE_URL points at one. Each run
+// works in a schema of its own that is dropped afterwards.
+func testContext(t *testing.T) *context.Context {
+	t.Helper()
+
+	url := os.Getenv("TEST_DATABASE_URL")
+	if url == "" {
+		t.Skip("TEST_DATABASE_URL is not set")
+	}
+
+	db.Init(&db.Config{URL: url, MaxDBConn: 50})
+
+	schema := "test_" + strings.ReplaceAll(uuid.New().String(), "-", "")
+	ctx := newContext(schema)
+
+	if err := ctx.DB.Exec("CREATE SCHEMA " + schema).Error; err != nil {
+		t.Fatalf("create schema: %v", err)
+	}
+	t.Cleanup(func() {
+		ctx.DB.Exec("DROP SCHEMA " + schema + " CASCADE")
+	})
+
+	seq := &InvoiceSequence{}
+	tables := []struct {
+		getTable func(*context.Context) (string, error)
+		model    interface{}
+	}{
+		{seq.getTable, &models.InvoiceSequence{}},
+		{seq.getFiscalYearTable, &models.InvoiceFiscalYear{}},
+		{seq.getVoidTable, &models.InvoiceNumberVoid{}},
+	}
+	for _, tt := range tables {
+		table, err := tt.getTable(ctx)
+		if err != nil {
+			t.Fatalf("table: %v", err)
+		}
+		if err := ctx.DB.Table(table).AutoMigrate(tt.model); err != nil {
+			t.Fatalf("migrate %s: %v", table, err)
+		}
+	}
+	if err := ctx.DB.Exec(strings.ReplaceAll(invoicePrefTable, "%s", schema)).Error; err != nil {
+		t.Fatalf("create invoice_prefs: %v", err)
+	}
+
+	return ctx
+}
+
+// setRegion saves the region's fiscal year start month and invoice number templates, a
+// zero month or nil templates leave them unset.
+func setRegion(t *testing.T, ctx *context.Context, regionId uuid.UUID, startMonth time.Month, templates models.NumberTemplates) {
+	t.Helper()
+
+	if startMonth != 0 {
+		err := NewInvoiceSequence().UpsertFiscalYear(ctx, &models.InvoiceFiscalYear{
+			Id:         uuid.New(),
+			RegionId:   regionId,
+			StartMonth: int(startMonth),
+			CreatedAt:  time.Now().UTC(),
+			UpdatedAt:  time.Now().UTC(),
+		})
+		if err != nil {
+			t.Fatalf("save fiscal year: %v", err)
+		}
+	}
+
+	if templates != nil {
+		err := invoicepref.NewInvoicePref().UpsertNumberTemplates(ctx, &models.InvoiceNumberTemplates{
+			RegionId:   regionId,
+			RegionCode: "BOM",
+			Templates:  templates,
+			UpdatedAt:  time.Now().UTC(),
+		})
+		if err != nil {
+			t.Fatalf("save number templates: %v", err)
+		}
+	}
+}
+
+// newContext builds a request context for the schema, every goroutine gets its own like
+// every request does.
+func newContext(schema string) *context.Context {
+	c := &context.Context{}
+	c.RefID = uuid.New().String()
+	c.Log = ulog.New(c.RefID, "forwarder-shipments", "error")
+	c.DB = db.New()
+	c.TenantID = schema
+	c.Context, _ = gin.CreateTestContext(httptest.NewRecorder())
+	c.Context.Request = httptest.NewRequest("POST", "/invoices", nil)
+
+	return c
+}
+
+func TestNewInvoiceNumberConcurrent(t *testing.T) {
+	ctx := testContext(t)
+
+	const allocations = 200
+	regionId := uuid.New()
+
+	var (
+		wg      sync.WaitGroup
+		mu      sync.Mutex
+		numbers []int64
+		errs    []error
+	)
+	for i := 0; i < allocations; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			no, err := NewInvoiceSequence().NewInvoiceNumber(newContext(ctx.TenantID), "", "INV", regionId)
+
+			mu.Lock()
+			defer mu.Unlock()
+			if err != nil {
+				errs = append(errs, err)
+				return
+			}
+			numbers = append(numbers, no)
+		}()
+	}
+	wg.Wait()
+
+	if len(errs) > 0 {
+		t.Fatalf("%d of %d allocations failed, first: %v", len(errs), allocations, errs[0])
+	}
+
+	sort.Slice(numbers, func(i, j int) bool { return numbers[i] < numbers[j] })
+	for i, no := range numbers {
+		if no != int64(i+1) {
+			t.Fatalf("numbers are not unique and gap-free, position %d holds %d: %v", i, no, numbers)
+		}
+	}
+}
+
+func TestNewInvoiceNumberPerSequence(t *testing.T) {
+	ctx := testContext(t)
+
+	regionId := uuid.New()
+	otherRegionId := uuid.New()
+
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	got := map[string][]int64{}
+	for _, voucherType := range []string{"INV", "CN"} {
+		for _, rid := range []uuid.UUID{regionId, otherRegionId} {
+			for i := 0; i < 25; i++ {
+				wg.Add(1)
+				go func(voucherType string, rid uuid.UUID) {
+					defer wg.Done()
+
+					no, err := NewInvoiceSequence().NewInvoiceNumber(newContext(ctx.TenantID), "", voucherType, rid)
+					if err != nil {
+						t.Errorf("allocate %s: %v", voucherType, err)
+						return
+					}
+
+					mu.Lock()
+					defer mu.Unlock()
+					key := fmt.Sprintf("%s/%s", rid, voucherType)
+					got[key] = append(got[key], no)
+				}(voucherType, rid)
+			}
+		}
+	}
+	wg.Wait()
+
+	for key, numbers := range got {
+		sort.Slice(numbers, func(i, j int) bool { return numbers[i] < numbers[j] })
+		for i, no := range numbers {
+			if no != int64(i+1) {
+				t.Fatalf("sequence %s is not gap-free: %v", key, numbers)
+			}
+		}
+	}
+}
+
+func TestNewInvoiceNumberFiscalYearReset(t *testing.T) {
+	ctx := testContext(t)
+
+	regionId := uuid.New()
+	seq := NewInvoiceSequence()
+	setRegion(t, ctx, regionId, time.April, models.NumberTemplates{"INV": "{REGION}/{FY}/{SEQ:04}"})
+
+	allocate := func(at time.Time) int64 {
+		var no int64
+		err := ctx.DB.Transaction(func(tx *gorm.DB) error {
+			var err error
+			no, err = seq.NewInvoiceNumberWithTx(ctx, tx, "", "INV", regionId, at)
+			return err
+		})
+		if err != nil {
+			t.Fatalf("allocate at %s: %v", at, err)
+		}
+		return no
+	}
+
+	march := time.Date(2025, time.March, 31, 12, 0, 0, 0, time.UTC)
+	april := time.Date(2025, time.April, 1, 12, 0, 0, 0, time.UTC)
+
+	if no := allocate(march); no != 1 {
+		t.Fatalf("first number of 2024-25 = %d, want 1", no)
+	}
+	if no := allocate(march); no != 2 {
+		t.Fatalf("second number of 2024-25 = %d, want 2", no)
+	}
+	if no := allocate(april); no != 1 {
+		t.Fatalf("first number of 2025-26 = %d, want 1", no)
+	}
+
+	last, err := seq.GetLastInvoiceNumber(ctx, "", "INV", regionId, march)
+	if err != nil || last != 2 {
+		t.Fatalf("last number of 2024-25 = %d, %v, want 2", last, err)
+	}
+
+	name, err := seq.GetSequenceName(ctx, "", "INV", regionId, april)
+	if err != nil || name != constants.InvoiceNoCustomerInvoice+"_2025-26" {
+		t.Fatalf("sequence name = %q, %v", name, err)
+	}
+}
+
+func TestNewInvoiceNumberContinuousWithoutFYTemplate(t *testing.T) {
+	ctx := testContext(t)
+
+	fyOnly := uuid.New()
+	setRegion(t, ctx, fyOnly, time.April, nil)
+	templateOnly := uuid.New()
+	setRegion(t, ctx, templateOnly, 0, models.NumberTemplates{"INV": "{REGION}/{FY}/{SEQ:04}"})
+	otherVoucher := uuid.New()
+	setRegion(t, ctx, otherVoucher, time.April, models.NumberTemplates{"CN": "{REGION}/{FY}/CN-{SEQ:04}"})
+
+	seq := NewInvoiceSequence()
+	march := time.Date(2025, time.March, 31, 12, 0, 0, 0, time.UTC)
+	april := time.Date(2025, time.April, 1, 12, 0, 0, 0, time.UTC)
+
+	for name, regionId := range map[string]uuid.UUID{"fiscal year only": fyOnly, "template only": templateOnly, "template of another voucher type": otherVoucher} {
+		for i, at := range []time.Time{march, march, april} {
+			var no int64
+			err := ctx.DB.Transaction(func(tx *gorm.DB) error {
+				var err error
+				no, err = seq.NewInvoiceNumberWithTx(ctx, tx, "", "INV", regionId, at)
+				return err
+			})
+			if err != nil {
+				t.Fatalf("%s: allocate at %s: %v", name, at, err)
+			}
+			if no != int64(i+1) {
+				t.Fatalf("%s: number %d = %d, want %d as the sequence never restarts", name, i, no, i+1)
+			}
+		}
+
+		seqName, err := seq.GetSequenceName(ctx, "", "INV", regionId, april)
+		if err != nil || seqName != constants.InvoiceNoCustomerInvoice {
+			t.Fatalf("%s: sequence name = %q, %v", name, seqName, err)
+		}
+
+		last, err := seq.GetLastInvoiceNumber(ctx, "", "INV", regionId, april)
+		if err != nil || last != 3 {
+			t.Fatalf("%s: last number = %d, %v, want 3", name, last, err)
+		}
+	}
+}
+
+func TestNewInvoiceNumberRollback(t *testing.T) {
+	ctx := testContext(t)
+
+	regionId := uuid.New()
+	seq := NewInvoiceSequence()
+	now := time.Now().UTC()
+
+	// A number allocated by a transaction that rolls back is handed out again
+	_ = ctx.DB.Transaction(func(tx *gorm.DB) error {
+		if _, err := seq.NewInvoiceNumberWithTx(ctx, tx, "", "INV", regionId, now); err != nil {
+			t.Fatalf("allocate: %v", err)
+		}
+		return fmt.Errorf("invoice not saved")
+	})
+
+	no, err := seq.NewInvoiceNumber(ctx, "", "INV", regionId)
+	if err != nil || no != 1 {
+		t.Fatalf("number after rollback = %d, %v, want 1", no, err)
+	}
+}
+
+func TestFiscalYear(t *testing.T) {
+	cases := []struct {
+		start time.Month
+		at    time.Time
+		want  string
+	}{
+		{time.April, time.Date(2025, time.March, 31, 23, 59, 0, 0, time.UTC), "2024-25"},
+		{time.April, time.Date(2025, time.April, 1, 0, 0, 0, 0, time.UTC), "2025-26"},
+		{time.January, time.Date(2025, time.June, 1, 0, 0, 0, 0, time.UTC), "2025"},
+		{time.July, time.Date(2099, time.December, 1, 0, 0, 0, 0, time.UTC), "2099-00"},
+	}
+
+	for _, c := range cases {
+		if got := FiscalYear(c.start, c.at); got != c.want {
+			t.Errorf("FiscalYear(%s, %s) = %q, want %q", c.start, c.at, got, c.want)
+		}
+	}
+}
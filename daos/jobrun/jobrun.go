@@ -0,0 +1,95 @@
+package jobrun
+
+import (
+	"bitbucket.org/radarventures/forwarder-adapters/utils/context"
+	"bitbucket.org/radarventures/forwarder-shipments/daos/tenant"
+	"bitbucket.org/radarventures/forwarder-shipments/database/models"
+	"go.uber.org/zap"
+	"gorm.io/gorm/clause"
+)
+
+type IJobRun interface {
+	Claim(ctx *context.Context, m *models.JobRun) (bool, error)
+	Update(ctx *context.Context, m *models.JobRun) error
+	GetAll(ctx *context.Context, filter *models.JobRunFilter) ([]*models.JobRun, error)
+}
+
+type JobRun struct {
+}
+
+func NewJobRun() IJobRun {
+	return &JobRun{}
+}
+
+// getTable is the tenant's job_runs. The scheduler records each tenant's run there and
+// GetJobRuns reads it back for the tenant of the request.
+func (t *JobRun) getTable(ctx *context.Context) (string, error) {
+	return tenant.Table(ctx, "job_runs")
+}
+
+// Claim creates the run unless the tenant has a run of the same job and tick already, and
+// reports whether it did. Of the replicas that fire on a tick only one claims it.
+func (t *JobRun) Claim(ctx *context.Context, m *models.JobRun) (bool, error) {
+	table, err := t.getTable(ctx)
+	if err != nil {
+		return false, err
+	}
+
+	res := ctx.DB.Table(table).
+		Clauses(clause.OnConflict{
+			Columns:   []clause.Column{{Name: "job"}, {Name: "tenant_id"}, {Name: "scheduled_at"}},
+			DoNothing: true,
+		}).
+		Create(m)
+	if res.Error != nil {
+		ctx.Log.Error("Unable to claim job run.", zap.Error(res.Error))
+		return false, res.Error
+	}
+
+	return res.RowsAffected > 0, nil
+}
+
+func (t *JobRun) Update(ctx *context.Context, m *models.JobRun) error {
+	table, err := t.getTable(ctx)
+	if err != nil {
+		return err
+	}
+
+	err = ctx.DB.Table(table).Where("id = ?", m.Id).Save(m).Error
+	if err != nil {
+		ctx.Log.Error("Unable to update job run.", zap.Error(err))
+	}
+	return err
+}
+
+func (t *JobRun) GetAll(ctx *context.Context, filter *models.JobRunFilter) ([]*models.JobRun, error) {
+	table, err := t.getTable(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var result []*models.JobRun
+
+	tx := ctx.DB.WithContext(ctx.Request.Context()).Table(table)
+
+	if filter.Job != "" {
+		tx.Where("job = ?", filter.Job)
+	}
+
+	if filter.Status != "" {
+		tx.Where("status = ?", filter.Status)
+	}
+
+	tx.Order("started_at DESC").Offset(filter.Offset)
+	if filter.Limit != 0 {
+		tx.Limit(filter.Limit)
+	}
+
+	err = tx.Find(&result).Error
+	if err != nil {
+		ctx.Log.Error("Unable to get job runs.", zap.Error(err))
+		return nil, err
+	}
+
+	return result, nil
+}
@@ -231,7 +231,7 @@ func (t *LineItem) ValidateLiForGeneratedInvoice(ctx *context.Context, lineItemI
 	SELECT COUNT(DISTINCT id) as count_ids
 	FROM (
 		SELECT DISTINCT id
-		FROM "public"."line_items"
+		FROM `+t.getTable(ctx)+`
 		WHERE id IN ? 
 		AND is_sell_invoice_generated = ?
 	) as filtered_ids
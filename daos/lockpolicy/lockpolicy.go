@@ -0,0 +1,190 @@
+package lockpolicy
+
+import (
+	"bitbucket.org/radarventures/forwarder-adapters/utils/context"
+	"bitbucket.org/radarventures/forwarder-shipments/daos/tenant"
+	"bitbucket.org/radarventures/forwarder-shipments/database/models"
+	"github.com/google/uuid"
+	"go.uber.org/zap"
+	"gorm.io/gorm/clause"
+)
+
+type ILockPolicy interface {
+	Upsert(ctx *context.Context, m *models.ShipmentLockPolicy) error
+	Get(ctx *context.Context, id uuid.UUID) (*models.ShipmentLockPolicy, error)
+	GetForRegion(ctx *context.Context, regionId string, activeOnly bool) ([]*models.ShipmentLockPolicy, error)
+	Delete(ctx *context.Context, id uuid.UUID) error
+
+	CreateDecision(ctx *context.Context, m *models.ShipmentLockDecision) error
+	GetDecisions(ctx *context.Context, shipmentId uuid.UUID) ([]*models.ShipmentLockDecision, error)
+
+	UpsertAllowedField(ctx *context.Context, m *models.ShipmentLockAllowedField) error
+	DeleteAllowedField(ctx *context.Context, id uuid.UUID) error
+	GetAllowedFields(ctx *context.Context, regionId string) ([]*models.ShipmentLockAllowedField, error)
+}
+
+type LockPolicy struct {
+}
+
+func NewLockPolicy() ILockPolicy {
+	return &LockPolicy{}
+}
+
+func (t *LockPolicy) getTable(ctx *context.Context) (string, error) {
+	return tenant.Table(ctx, "shipment_lock_policies")
+}
+
+func (t *LockPolicy) getDecisionsTable(ctx *context.Context) (string, error) {
+	return tenant.Table(ctx, "shipment_lock_decisions")
+}
+
+func (t *LockPolicy) getAllowedFieldsTable(ctx *context.Context) (string, error) {
+	return tenant.Table(ctx, "shipment_lock_allowed_fields")
+}
+
+func (t *LockPolicy) Upsert(ctx *context.Context, m *models.ShipmentLockPolicy) error {
+	table, err := t.getTable(ctx)
+	if err != nil {
+		return err
+	}
+
+	return ctx.DB.WithContext(ctx.Request.Context()).Table(table).Save(m).Error
+}
+
+func (t *LockPolicy) Get(ctx *context.Context, id uuid.UUID) (*models.ShipmentLockPolicy, error) {
+	table, err := t.getTable(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var result models.ShipmentLockPolicy
+	err = ctx.DB.WithContext(ctx.Request.Context()).Table(table).First(&result, "id = ?", id).Error
+	if err != nil {
+		ctx.Log.Error("Unable to get shipment lock policy.", zap.Error(err))
+		return nil, err
+	}
+
+	return &result, nil
+}
+
+// GetForRegion returns the policies of the region and those without a region, in the order
+// they are evaluated.
+func (t *LockPolicy) GetForRegion(ctx *context.Context, regionId string, activeOnly bool) ([]*models.ShipmentLockPolicy, error) {
+	table, err := t.getTable(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var result []*models.ShipmentLockPolicy
+	tx := ctx.DB.WithContext(ctx.Request.Context()).Table(table).
+		Where("region_id = ? OR region_id = ''", regionId)
+	if activeOnly {
+		tx.Where("is_active = ?", true)
+	}
+
+	err = tx.Order("priority, name").Find(&result).Error
+	if err != nil {
+		ctx.Log.Error("Unable to get shipment lock policies.", zap.Error(err))
+		return nil, err
+	}
+
+	return result, nil
+}
+
+func (t *LockPolicy) Delete(ctx *context.Context, id uuid.UUID) error {
+	table, err := t.getTable(ctx)
+	if err != nil {
+		return err
+	}
+
+	err = ctx.DB.WithContext(ctx.Request.Context()).Table(table).Delete(&models.ShipmentLockPolicy{}, "id = ?", id).Error
+	if err != nil {
+		ctx.Log.Error("Unable to delete shipment lock policy.", zap.Error(err))
+		return err
+	}
+
+	return nil
+}
+
+func (t *LockPolicy) CreateDecision(ctx *context.Context, m *models.ShipmentLockDecision) error {
+	decisionsTable, err := t.getDecisionsTable(ctx)
+	if err != nil {
+		return err
+	}
+
+	err = ctx.DB.WithContext(ctx.Request.Context()).Table(decisionsTable).Create(m).Error
+	if err != nil {
+		ctx.Log.Error("Unable to create shipment lock decision.", zap.Error(err))
+		return err
+	}
+
+	return nil
+}
+
+func (t *LockPolicy) GetDecisions(ctx *context.Context, shipmentId uuid.UUID) ([]*models.ShipmentLockDecision, error) {
+	decisionsTable, err := t.getDecisionsTable(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var result []*models.ShipmentLockDecision
+	err = ctx.DB.WithContext(ctx.Request.Context()).Table(decisionsTable).
+		Where("shipment_id = ?", shipmentId).
+		Order("locked_at desc").
+		Find(&result).Error
+	if err != nil {
+		ctx.Log.Error("Unable to get shipment lock decisions.", zap.Error(err))
+		return nil, err
+	}
+
+	return result, nil
+}
+
+func (t *LockPolicy) UpsertAllowedField(ctx *context.Context, m *models.ShipmentLockAllowedField) error {
+	allowedFieldsTable, err := t.getAllowedFieldsTable(ctx)
+	if err != nil {
+		return err
+	}
+
+	return ctx.DB.WithContext(ctx.Request.Context()).Table(allowedFieldsTable).
+		Clauses(clause.OnConflict{
+			Columns:   []clause.Column{{Name: "region_id"}, {Name: "area"}, {Name: "field"}},
+			DoUpdates: clause.AssignmentColumns([]string{"updated_by", "updated_at"}),
+		}).
+		Create(m).Error
+}
+
+func (t *LockPolicy) DeleteAllowedField(ctx *context.Context, id uuid.UUID) error {
+	allowedFieldsTable, err := t.getAllowedFieldsTable(ctx)
+	if err != nil {
+		return err
+	}
+
+	err = ctx.DB.WithContext(ctx.Request.Context()).Table(allowedFieldsTable).Delete(&models.ShipmentLockAllowedField{}, "id = ?", id).Error
+	if err != nil {
+		ctx.Log.Error("Unable to delete shipment lock allowed field.", zap.Error(err))
+		return err
+	}
+
+	return nil
+}
+
+// GetAllowedFields returns the fields allow-listed for the region and for every region.
+func (t *LockPolicy) GetAllowedFields(ctx *context.Context, regionId string) ([]*models.ShipmentLockAllowedField, error) {
+	allowedFieldsTable, err := t.getAllowedFieldsTable(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var result []*models.ShipmentLockAllowedField
+	err = ctx.DB.WithContext(ctx.Request.Context()).Table(allowedFieldsTable).
+		Where("region_id = ? OR region_id = ''", regionId).
+		Order("area, field").
+		Find(&result).Error
+	if err != nil {
+		ctx.Log.Error("Unable to get shipment lock allowed fields.", zap.Error(err))
+		return nil, err
+	}
+
+	return result, nil
+}
@@ -0,0 +1,371 @@
+package marginpolicy
+
+import (
+	"errors"
+
+	"bitbucket.org/radarventures/forwarder-adapters/utils/context"
+	"bitbucket.org/radarventures/forwarder-shipments/constants"
+	"bitbucket.org/radarventures/forwarder-shipments/daos/tenant"
+	"bitbucket.org/radarventures/forwarder-shipments/database/models"
+	"github.com/google/uuid"
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+type IMarginPolicy interface {
+	Upsert(ctx *context.Context, m *models.MarginPolicy) error
+	Get(ctx *context.Context, id uuid.UUID) (*models.MarginPolicy, error)
+	GetForRegion(ctx *context.Context, regionId string, activeOnly bool) ([]*models.MarginPolicy, error)
+	Delete(ctx *context.Context, id uuid.UUID) error
+
+	UpsertTier(ctx *context.Context, m *models.MarginCustomerTier) error
+	GetTier(ctx *context.Context, companyId string) (string, error)
+
+	GetQuoteSubject(ctx *context.Context, quoteId string) (*models.GPSubject, error)
+	GetShipmentSubject(ctx *context.Context, shipmentId string) (*models.GPSubject, error)
+
+	CreateApprovalWithTx(ctx *context.Context, tx *gorm.DB, m *models.GPApproval) error
+	GetApproval(ctx *context.Context, id uuid.UUID) (*models.GPApproval, error)
+	GetApprovalWithTx(ctx *context.Context, tx *gorm.DB, id uuid.UUID) (*models.GPApproval, error)
+	GetLatestApprovalWithTx(ctx *context.Context, tx *gorm.DB, quoteId string) (*models.GPApproval, error)
+	UpdateApprovalWithTx(ctx *context.Context, tx *gorm.DB, id uuid.UUID, fromStatus string, fields map[string]interface{}) (bool, error)
+	GetApprovals(ctx *context.Context, quoteId string) ([]*models.GPApproval, error)
+	GetPendingForApprover(ctx *context.Context, accountId string) ([]*models.GPApproval, error)
+
+	CreateEventWithTx(ctx *context.Context, tx *gorm.DB, m *models.GPApprovalEvent) error
+	GetEvents(ctx *context.Context, approvalId uuid.UUID) ([]*models.GPApprovalEvent, error)
+}
+
+type MarginPolicy struct {
+}
+
+func NewMarginPolicy() IMarginPolicy {
+	return &MarginPolicy{}
+}
+
+func (t *MarginPolicy) getTable(ctx *context.Context) (string, error) {
+	return tenant.Table(ctx, "margin_policies")
+}
+
+func (t *MarginPolicy) getTiersTable(ctx *context.Context) (string, error) {
+	return tenant.Table(ctx, "margin_customer_tiers")
+}
+
+func (t *MarginPolicy) getApprovalsTable(ctx *context.Context) (string, error) {
+	return tenant.Table(ctx, "gp_approvals")
+}
+
+func (t *MarginPolicy) getEventsTable(ctx *context.Context) (string, error) {
+	return tenant.Table(ctx, "gp_approval_events")
+}
+
+func (t *MarginPolicy) Upsert(ctx *context.Context, m *models.MarginPolicy) error {
+	table, err := t.getTable(ctx)
+	if err != nil {
+		return err
+	}
+
+	return ctx.DB.WithContext(ctx.Request.Context()).Table(table).Save(m).Error
+}
+
+func (t *MarginPolicy) Get(ctx *context.Context, id uuid.UUID) (*models.MarginPolicy, error) {
+	table, err := t.getTable(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var result models.MarginPolicy
+	err = ctx.DB.WithContext(ctx.Request.Context()).Table(table).First(&result, "id = ?", id).Error
+	if err != nil {
+		ctx.Log.Error("Unable to get margin policy.", zap.Error(err))
+		return nil, err
+	}
+
+	return &result, nil
+}
+
+// GetForRegion returns the policies of the region and those without a region.
+func (t *MarginPolicy) GetForRegion(ctx *context.Context, regionId string, activeOnly bool) ([]*models.MarginPolicy, error) {
+	table, err := t.getTable(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var result []*models.MarginPolicy
+	tx := ctx.DB.WithContext(ctx.Request.Context()).Table(table).
+		Where("region_id = ? OR region_id = ''", regionId)
+	if activeOnly {
+		tx.Where("is_active = ?", true)
+	}
+
+	err = tx.Order("priority, name").Find(&result).Error
+	if err != nil {
+		ctx.Log.Error("Unable to get margin policies.", zap.Error(err))
+		return nil, err
+	}
+
+	return result, nil
+}
+
+func (t *MarginPolicy) Delete(ctx *context.Context, id uuid.UUID) error {
+	table, err := t.getTable(ctx)
+	if err != nil {
+		return err
+	}
+
+	err = ctx.DB.WithContext(ctx.Request.Context()).Table(table).Delete(&models.MarginPolicy{}, "id = ?", id).Error
+	if err != nil {
+		ctx.Log.Error("Unable to delete margin policy.", zap.Error(err))
+		return err
+	}
+
+	return nil
+}
+
+func (t *MarginPolicy) UpsertTier(ctx *context.Context, m *models.MarginCustomerTier) error {
+	tiersTable, err := t.getTiersTable(ctx)
+	if err != nil {
+		return err
+	}
+
+	return ctx.DB.WithContext(ctx.Request.Context()).Table(tiersTable).
+		Clauses(clause.OnConflict{
+			Columns:   []clause.Column{{Name: "company_id"}},
+			DoUpdates: clause.AssignmentColumns([]string{"tier", "updated_by", "updated_at"}),
+		}).
+		Create(m).Error
+}
+
+// GetTier returns the tier of a customer, or an empty tier when it has none.
+func (t *MarginPolicy) GetTier(ctx *context.Context, companyId string) (string, error) {
+	tiersTable, err := t.getTiersTable(ctx)
+	if err != nil {
+		return "", err
+	}
+
+	var result models.MarginCustomerTier
+	err = ctx.DB.WithContext(ctx.Request.Context()).Table(tiersTable).Take(&result, "company_id = ?", companyId).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return "", nil
+	}
+	if err != nil {
+		ctx.Log.Error("Unable to get margin customer tier.", zap.String("company_id", companyId), zap.Error(err))
+		return "", err
+	}
+
+	return result.Tier, nil
+}
+
+// GetQuoteSubject returns the enquiry a quote was made for.
+func (t *MarginPolicy) GetQuoteSubject(ctx *context.Context, quoteId string) (*models.GPSubject, error) {
+	rfqQuotesTable, err := tenant.Table(ctx, "rfq_quotes")
+	if err != nil {
+		return nil, err
+	}
+	rfqsTable, err := tenant.Table(ctx, "rfqs")
+	if err != nil {
+		return nil, err
+	}
+
+	var result models.GPSubject
+	err = ctx.DB.WithContext(ctx.Request.Context()).Table(rfqQuotesTable+" rq").
+		Select("rq.quote_id, r.id AS rfq_id, r.type, r.region_id, r.company_id").
+		Joins("JOIN "+rfqsTable+" r ON r.id = rq.rfq_id").
+		Where("rq.quote_id = ?", quoteId).
+		Take(&result).Error
+	if err != nil {
+		ctx.Log.Error("Unable to get GP subject of quote.", zap.String("quote_id", quoteId), zap.Error(err))
+		return nil, err
+	}
+
+	return &result, nil
+}
+
+// GetShipmentSubject returns the quote a shipment was booked from.
+func (t *MarginPolicy) GetShipmentSubject(ctx *context.Context, shipmentId string) (*models.GPSubject, error) {
+	shipmentsTable, err := tenant.Table(ctx, "shipments")
+	if err != nil {
+		return nil, err
+	}
+
+	var result models.GPSubject
+	err = ctx.DB.WithContext(ctx.Request.Context()).Table(shipmentsTable).
+		Select("COALESCE(quote_id::text, '') AS quote_id, id AS shipment_id, type, region_id, company_id").
+		Where("id = ?", shipmentId).
+		Take(&result).Error
+	if err != nil {
+		ctx.Log.Error("Unable to get GP subject of shipment.", zap.String("shipment_id", shipmentId), zap.Error(err))
+		return nil, err
+	}
+
+	return &result, nil
+}
+
+func (t *MarginPolicy) CreateApprovalWithTx(ctx *context.Context, tx *gorm.DB, m *models.GPApproval) error {
+	approvalsTable, err := t.getApprovalsTable(ctx)
+	if err != nil {
+		return err
+	}
+
+	err = tx.Table(approvalsTable).Create(m).Error
+	if err != nil {
+		ctx.Log.Error("Unable to create GP approval.", zap.String("quote_id", m.QuoteId), zap.Error(err))
+		return err
+	}
+
+	return nil
+}
+
+func (t *MarginPolicy) GetApproval(ctx *context.Context, id uuid.UUID) (*models.GPApproval, error) {
+	approvalsTable, err := t.getApprovalsTable(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var result models.GPApproval
+	err = ctx.DB.WithContext(ctx.Request.Context()).Table(approvalsTable).First(&result, "id = ?", id).Error
+	if err != nil {
+		ctx.Log.Error("Unable to get GP approval.", zap.Error(err))
+		return nil, err
+	}
+
+	return &result, nil
+}
+
+// GetApprovalWithTx locks the approval until the transaction ends, so that each level is
+// decided only once.
+func (t *MarginPolicy) GetApprovalWithTx(ctx *context.Context, tx *gorm.DB, id uuid.UUID) (*models.GPApproval, error) {
+	approvalsTable, err := t.getApprovalsTable(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var result models.GPApproval
+	err = tx.Table(approvalsTable).
+		Clauses(clause.Locking{Strength: "UPDATE"}).
+		First(&result, "id = ?", id).Error
+	if err != nil {
+		return nil, err
+	}
+
+	return &result, nil
+}
+
+// GetLatestApprovalWithTx returns the last approval of a quote that was not superseded. The
+// quote is held under an advisory lock until the transaction ends so that two evaluations
+// cannot both ask for an approval.
+func (t *MarginPolicy) GetLatestApprovalWithTx(ctx *context.Context, tx *gorm.DB, quoteId string) (*models.GPApproval, error) {
+	approvalsTable, err := t.getApprovalsTable(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	err = tx.Exec("SELECT pg_advisory_xact_lock(hashtext(?))", approvalsTable+":"+quoteId).Error
+	if err != nil {
+		return nil, err
+	}
+
+	var result models.GPApproval
+	err = tx.Table(approvalsTable).
+		Where("quote_id = ? AND status != ?", quoteId, constants.GPApprovalSuperseded).
+		Order("requested_at desc").
+		First(&result).Error
+	if err != nil {
+		return nil, err
+	}
+
+	return &result, nil
+}
+
+// UpdateApprovalWithTx updates the approval only while it still has fromStatus and reports
+// whether it did.
+func (t *MarginPolicy) UpdateApprovalWithTx(ctx *context.Context, tx *gorm.DB, id uuid.UUID, fromStatus string, fields map[string]interface{}) (bool, error) {
+	approvalsTable, err := t.getApprovalsTable(ctx)
+	if err != nil {
+		return false, err
+	}
+
+	res := tx.Table(approvalsTable).
+		Where("id = ? AND status = ?", id, fromStatus).
+		UpdateColumns(fields)
+	if res.Error != nil {
+		ctx.Log.Error("Unable to update GP approval.", zap.Any("id", id), zap.Error(res.Error))
+		return false, res.Error
+	}
+
+	return res.RowsAffected > 0, nil
+}
+
+func (t *MarginPolicy) GetApprovals(ctx *context.Context, quoteId string) ([]*models.GPApproval, error) {
+	approvalsTable, err := t.getApprovalsTable(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var result []*models.GPApproval
+	err = ctx.DB.WithContext(ctx.Request.Context()).Table(approvalsTable).
+		Where("quote_id = ?", quoteId).
+		Order("requested_at desc").
+		Find(&result).Error
+	if err != nil {
+		ctx.Log.Error("Unable to get GP approvals.", zap.String("quote_id", quoteId), zap.Error(err))
+		return nil, err
+	}
+
+	return result, nil
+}
+
+// GetPendingForApprover returns the pending approvals waiting on the account.
+func (t *MarginPolicy) GetPendingForApprover(ctx *context.Context, accountId string) ([]*models.GPApproval, error) {
+	approvalsTable, err := t.getApprovalsTable(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var result []*models.GPApproval
+	err = ctx.DB.WithContext(ctx.Request.Context()).Table(approvalsTable).
+		Where("status = ? AND approvers[level + 1] = ?", constants.GPApprovalPending, accountId).
+		Order("requested_at").
+		Find(&result).Error
+	if err != nil {
+		ctx.Log.Error("Unable to get pending GP approvals.", zap.String("account_id", accountId), zap.Error(err))
+		return nil, err
+	}
+
+	return result, nil
+}
+
+func (t *MarginPolicy) CreateEventWithTx(ctx *context.Context, tx *gorm.DB, m *models.GPApprovalEvent) error {
+	eventsTable, err := t.getEventsTable(ctx)
+	if err != nil {
+		return err
+	}
+
+	err = tx.Table(eventsTable).Create(m).Error
+	if err != nil {
+		ctx.Log.Error("Unable to create GP approval event.", zap.Error(err))
+		return err
+	}
+
+	return nil
+}
+
+func (t *MarginPolicy) GetEvents(ctx *context.Context, approvalId uuid.UUID) ([]*models.GPApprovalEvent, error) {
+	eventsTable, err := t.getEventsTable(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var result []*models.GPApprovalEvent
+	err = ctx.DB.WithContext(ctx.Request.Context()).Table(eventsTable).
+		Where("approval_id = ?", approvalId).
+		Order("created_at").
+		Find(&result).Error
+	if err != nil {
+		ctx.Log.Error("Unable to get GP approval events.", zap.Error(err))
+		return nil, err
+	}
+
+	return result, nil
+}
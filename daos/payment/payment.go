@@ -2,8 +2,11 @@ package payment
 
 import (
 	"bitbucket.org/radarventures/forwarder-adapters/utils/context"
+	"bitbucket.org/radarventures/forwarder-shipments/daos/tenant"
 	"bitbucket.org/radarventures/forwarder-shipments/database/models"
+	"github.com/google/uuid"
 	"go.uber.org/zap"
+	"gorm.io/gorm"
 )
 
 type IPayment interface {
@@ -11,6 +14,11 @@ type IPayment interface {
 	Get(ctx *context.Context, id string) (*models.Payment, error)
 	GetAll(ctx *context.Context, ids []string) ([]*models.Payment, error)
 	Delete(ctx *context.Context, id string) error
+
+	UpsertAllocationsWithTx(ctx *context.Context, tx *gorm.DB, m ...*models.PaymentAllocation) error
+	GetAllocations(ctx *context.Context, invoiceIds []string) ([]*models.PaymentAllocation, error)
+	GetPaidAmounts(ctx *context.Context, invoiceIds []string) (map[uuid.UUID]float64, error)
+	GetPaidAmountsWithTx(ctx *context.Context, tx *gorm.DB, invoiceIds []string) (map[uuid.UUID]float64, error)
 }
 
 type Payment struct {
@@ -20,17 +28,31 @@ func NewPayment() IPayment {
 	return &Payment{}
 }
 
-func (t *Payment) getTable(ctx *context.Context) string {
-	return ctx.TenantID + "." + "payments"
+func (t *Payment) getTable(ctx *context.Context) (string, error) {
+	return tenant.Table(ctx, "payments")
+}
+
+func (t *Payment) getAllocationTable(ctx *context.Context) (string, error) {
+	return tenant.Table(ctx, "payment_allocations")
 }
 
 func (t *Payment) Upsert(ctx *context.Context, m ...*models.Payment) error {
-	return ctx.DB.WithContext(ctx.Request.Context()).Table(t.getTable(ctx)).Save(m).Error
+	table, err := t.getTable(ctx)
+	if err != nil {
+		return err
+	}
+
+	return ctx.DB.WithContext(ctx.Request.Context()).Table(table).Save(m).Error
 }
 
 func (t *Payment) Get(ctx *context.Context, id string) (*models.Payment, error) {
+	table, err := t.getTable(ctx)
+	if err != nil {
+		return nil, err
+	}
+
 	var result models.Payment
-	err := ctx.DB.WithContext(ctx.Request.Context()).Table(t.getTable(ctx)).First(&result, "id = ?", id).Error
+	err = ctx.DB.WithContext(ctx.Request.Context()).Table(table).First(&result, "id = ?", id).Error
 	if err != nil {
 		ctx.Log.Error("Unable to get payment.", zap.Error(err))
 		return nil, err
@@ -40,8 +62,13 @@ func (t *Payment) Get(ctx *context.Context, id string) (*models.Payment, error)
 }
 
 func (t *Payment) Delete(ctx *context.Context, id string) error {
+	table, err := t.getTable(ctx)
+	if err != nil {
+		return err
+	}
+
 	var result models.Payment
-	err := ctx.DB.WithContext(ctx.Request.Context()).Table(t.getTable(ctx)).Delete(&result, "id = ?", id).Error
+	err = ctx.DB.WithContext(ctx.Request.Context()).Table(table).Delete(&result, "id = ?", id).Error
 	if err != nil {
 		ctx.Log.Error("Unable to delete payment.", zap.Error(err))
 		return err
@@ -51,16 +78,21 @@ func (t *Payment) Delete(ctx *context.Context, id string) error {
 }
 
 func (t *Payment) GetAll(ctx *context.Context, ids []string) ([]*models.Payment, error) {
+	table, err := t.getTable(ctx)
+	if err != nil {
+		return nil, err
+	}
+
 	var result []*models.Payment
 	if len(ids) == 0 {
-		err := ctx.DB.WithContext(ctx.Request.Context()).Table(t.getTable(ctx)).Find(&result).Error
+		err := ctx.DB.WithContext(ctx.Request.Context()).Table(table).Find(&result).Error
 		if err != nil {
 			ctx.Log.Error("Unable to get payments.", zap.Error(err))
 			return nil, err
 		}
 		return result, err
 	}
-	err := ctx.DB.WithContext(ctx.Request.Context()).Table(t.getTable(ctx)).Where("id IN ?", ids).Find(&result).Error
+	err = ctx.DB.WithContext(ctx.Request.Context()).Table(table).Where("id IN ?", ids).Find(&result).Error
 	if err != nil {
 		ctx.Log.Error("Unable to get payments.", zap.Error(err))
 		return nil, err
@@ -68,3 +100,79 @@ func (t *Payment) GetAll(ctx *context.Context, ids []string) ([]*models.Payment,
 
 	return result, err
 }
+
+func (t *Payment) UpsertAllocationsWithTx(ctx *context.Context, tx *gorm.DB, m ...*models.PaymentAllocation) error {
+	allocationTable, err := t.getAllocationTable(ctx)
+	if err != nil {
+		return err
+	}
+
+	err = tx.Table(allocationTable).Save(m).Error
+	if err != nil {
+		ctx.Log.Error("Unable to upsert payment allocations.", zap.Error(err))
+	}
+	return err
+}
+
+func (t *Payment) GetAllocations(ctx *context.Context, invoiceIds []string) ([]*models.PaymentAllocation, error) {
+	allocationTable, err := t.getAllocationTable(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var result []*models.PaymentAllocation
+	if len(invoiceIds) == 0 {
+		return result, nil
+	}
+
+	err = ctx.DB.WithContext(ctx.Request.Context()).Table(allocationTable).
+		Where("invoice_id IN (?)", invoiceIds).
+		Order("received_on, created_at").
+		Find(&result).Error
+	if err != nil {
+		ctx.Log.Error("Unable to get payment allocations.", zap.Error(err))
+		return nil, err
+	}
+
+	return result, nil
+}
+
+// GetPaidAmounts returns the total settled per invoice in the invoice currency.
+func (t *Payment) GetPaidAmounts(ctx *context.Context, invoiceIds []string) (map[uuid.UUID]float64, error) {
+	return t.GetPaidAmountsWithTx(ctx, ctx.DB.WithContext(ctx.Request.Context()), invoiceIds)
+}
+
+// GetPaidAmountsWithTx reads the settled totals in tx, so allocations written earlier in the
+// same transaction are counted.
+func (t *Payment) GetPaidAmountsWithTx(ctx *context.Context, tx *gorm.DB, invoiceIds []string) (map[uuid.UUID]float64, error) {
+	allocationTable, err := t.getAllocationTable(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	result := map[uuid.UUID]float64{}
+	if len(invoiceIds) == 0 {
+		return result, nil
+	}
+
+	var rows []struct {
+		InvoiceId uuid.UUID
+		Paid      float64
+	}
+
+	err = tx.Table(allocationTable).
+		Select("invoice_id, COALESCE(SUM(allocated_amount), 0) AS paid").
+		Where("invoice_id IN (?)", invoiceIds).
+		Group("invoice_id").
+		Scan(&rows).Error
+	if err != nil {
+		ctx.Log.Error("Unable to get paid amounts.", zap.Error(err))
+		return nil, err
+	}
+
+	for _, row := range rows {
+		result[row.InvoiceId] = row.Paid
+	}
+
+	return result, nil
+}
@@ -0,0 +1,198 @@
+package ratetemplate
+
+import (
+	"time"
+
+	"bitbucket.org/radarventures/forwarder-adapters/utils/context"
+	"bitbucket.org/radarventures/forwarder-shipments/daos/tenant"
+	"bitbucket.org/radarventures/forwarder-shipments/database/models"
+	"github.com/google/uuid"
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+)
+
+func (t *RateTemplate) getPricingTable(ctx *context.Context) (string, error) {
+	return tenant.Table(ctx, "rate_template_pricing")
+}
+
+func (t *RateTemplate) getChargesTable(ctx *context.Context) (string, error) {
+	return tenant.Table(ctx, "rate_template_charges")
+}
+
+// SavePricing replaces the validity window and charges of a template.
+func (t *RateTemplate) SavePricing(ctx *context.Context, m *models.RateTemplatePricing) error {
+	return ctx.DB.WithContext(ctx.Request.Context()).Transaction(func(tx *gorm.DB) error {
+		return t.SavePricingWithTx(ctx, tx, m)
+	})
+}
+
+func (t *RateTemplate) SavePricingWithTx(ctx *context.Context, tx *gorm.DB, m *models.RateTemplatePricing) error {
+	pricingTable, err := t.getPricingTable(ctx)
+	if err != nil {
+		return err
+	}
+	chargesTable, err := t.getChargesTable(ctx)
+	if err != nil {
+		return err
+	}
+
+	err = tx.Table(pricingTable).Save(m).Error
+	if err != nil {
+		ctx.Log.Error("Unable to save rate template pricing", zap.Any("template_id", m.TemplateId), zap.Error(err))
+		return err
+	}
+
+	err = tx.Table(chargesTable).Delete(&models.RateTemplateCharge{}, "template_id = ?", m.TemplateId).Error
+	if err != nil {
+		ctx.Log.Error("Unable to delete rate template charges", zap.Any("template_id", m.TemplateId), zap.Error(err))
+		return err
+	}
+
+	if len(m.Charges) == 0 {
+		return nil
+	}
+
+	err = tx.Table(chargesTable).Create(m.Charges).Error
+	if err != nil {
+		ctx.Log.Error("Unable to create rate template charges", zap.Any("template_id", m.TemplateId), zap.Error(err))
+		return err
+	}
+
+	return nil
+}
+
+func (t *RateTemplate) GetPricing(ctx *context.Context, templateId uuid.UUID) (*models.RateTemplatePricing, error) {
+	pricingTable, err := t.getPricingTable(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var result models.RateTemplatePricing
+	err = ctx.DB.WithContext(ctx.Request.Context()).Table(pricingTable).
+		Take(&result, "template_id = ?", templateId).Error
+	if err != nil {
+		ctx.Log.Error("Unable to get rate template pricing", zap.Any("template_id", templateId), zap.Error(err))
+		return nil, err
+	}
+
+	result.Charges, err = t.GetCharges(ctx, templateId)
+	if err != nil {
+		return nil, err
+	}
+
+	return &result, nil
+}
+
+func (t *RateTemplate) GetCharges(ctx *context.Context, templateId uuid.UUID) ([]*models.RateTemplateCharge, error) {
+	chargesTable, err := t.getChargesTable(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var result []*models.RateTemplateCharge
+	err = ctx.DB.WithContext(ctx.Request.Context()).Table(chargesTable).
+		Where("template_id = ?", templateId).
+		Order("position").
+		Find(&result).Error
+	if err != nil {
+		ctx.Log.Error("Unable to get rate template charges", zap.Any("template_id", templateId), zap.Error(err))
+		return nil, err
+	}
+
+	return result, nil
+}
+
+// GetCandidates returns the priced templates of a shipment type, region and lane that apply
+// to the company and are valid on the given day.
+func (t *RateTemplate) GetCandidates(ctx *context.Context, shipmentType string, regionId string, lane string, companyId string, on time.Time) ([]*models.RateTemplateCandidate, error) {
+	pricingTable, err := t.getPricingTable(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var result []*models.RateTemplateCandidate
+
+	tx := ctx.DB.WithContext(ctx.Request.Context()).Table(t.getTable(ctx)+" rt").
+		Select(`rt.id AS template_id,
+	(array_length(rt.applicability, 1) IS NOT NULL) AS company_specific,
+	p.effective_from, p.effective_to, p.currency`).
+		Joins("JOIN "+pricingTable+" p ON p.template_id = rt.id").
+		Where("rt.shipment_type = ? AND rt.region_id = ? AND p.lane = ?", shipmentType, regionId, lane).
+		Where("(array_length(rt.applicability, 1) IS NULL OR ? = ANY(rt.applicability))", companyId).
+		Where("(p.effective_from IS NULL OR p.effective_from <= ?)", on).
+		Where("(p.effective_to IS NULL OR p.effective_to >= ?)", on)
+
+	err = tx.Find(&result).Error
+	if err != nil {
+		ctx.Log.Error("Unable to get rate template candidates", zap.String("region_id", regionId), zap.Error(err))
+		return nil, err
+	}
+
+	return result, nil
+}
+
+// GetPricingsWithTx returns the pricing and charges of the templates of a region with one of
+// the lanes or ids. Templates that are not priced yet come back without a lane or charges.
+func (t *RateTemplate) GetPricingsWithTx(ctx *context.Context, tx *gorm.DB, regionId string, lanes []string, ids []uuid.UUID) ([]*models.RateTemplatePricing, error) {
+	pricingTable, err := t.getPricingTable(ctx)
+	if err != nil {
+		return nil, err
+	}
+	chargesTable, err := t.getChargesTable(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var result []*models.RateTemplatePricing
+	if len(lanes) == 0 && len(ids) == 0 {
+		return result, nil
+	}
+
+	q := tx.Table(t.getTable(ctx)+" rt").
+		Select(`rt.id AS template_id, COALESCE(p.lane, '') AS lane, p.effective_from, p.effective_to,
+	COALESCE(p.currency, '') AS currency, COALESCE(p.created_at, now()) AS created_at`).
+		Joins("LEFT JOIN "+pricingTable+" p ON p.template_id = rt.id").
+		Where("rt.region_id = ?", regionId)
+
+	switch {
+	case len(lanes) > 0 && len(ids) > 0:
+		q.Where("(p.lane IN ? OR rt.id IN ?)", lanes, ids)
+	case len(lanes) > 0:
+		q.Where("p.lane IN ?", lanes)
+	default:
+		q.Where("rt.id IN ?", ids)
+	}
+
+	err = q.Find(&result).Error
+	if err != nil {
+		ctx.Log.Error("Unable to get rate template pricings", zap.String("region_id", regionId), zap.Error(err))
+		return nil, err
+	}
+
+	if len(result) == 0 {
+		return result, nil
+	}
+
+	templateIds := make([]uuid.UUID, 0, len(result))
+	byTemplate := map[uuid.UUID]*models.RateTemplatePricing{}
+	for _, p := range result {
+		templateIds = append(templateIds, p.TemplateId)
+		byTemplate[p.TemplateId] = p
+	}
+
+	var charges []*models.RateTemplateCharge
+	err = tx.Table(chargesTable).
+		Where("template_id IN ?", templateIds).
+		Order("position").
+		Find(&charges).Error
+	if err != nil {
+		ctx.Log.Error("Unable to get rate template charges", zap.String("region_id", regionId), zap.Error(err))
+		return nil, err
+	}
+
+	for _, charge := range charges {
+		byTemplate[charge.TemplateId].Charges = append(byTemplate[charge.TemplateId].Charges, charge)
+	}
+
+	return result, nil
+}
@@ -1,9 +1,13 @@
 package ratetemplate
 
 import (
+	"time"
+
 	"bitbucket.org/radarventures/forwarder-adapters/utils/context"
 	"bitbucket.org/radarventures/forwarder-shipments/database/models"
+	"github.com/google/uuid"
 	"go.uber.org/zap"
+	"gorm.io/gorm"
 )
 
 type IRateTemplate interface {
@@ -11,6 +15,13 @@ type IRateTemplate interface {
 	Get(ctx *context.Context, id, rid string) (*models.RateTemplate, error)
 	GetAll(ctx *context.Context, ids []string, service string, regionId string, companyId string) ([]*models.RateTemplate, error)
 	Delete(ctx *context.Context, id string) error
+
+	SavePricing(ctx *context.Context, m *models.RateTemplatePricing) error
+	SavePricingWithTx(ctx *context.Context, tx *gorm.DB, m *models.RateTemplatePricing) error
+	GetPricing(ctx *context.Context, templateId uuid.UUID) (*models.RateTemplatePricing, error)
+	GetCharges(ctx *context.Context, templateId uuid.UUID) ([]*models.RateTemplateCharge, error)
+	GetCandidates(ctx *context.Context, shipmentType string, regionId string, lane string, companyId string, on time.Time) ([]*models.RateTemplateCandidate, error)
+	GetPricingsWithTx(ctx *context.Context, tx *gorm.DB, regionId string, lanes []string, ids []uuid.UUID) ([]*models.RateTemplatePricing, error)
 }
 
 type RateTemplate struct {
@@ -11,7 +11,12 @@ import (
 )
 
 func (t *Rfq) GetforFunnelFilterQuotes(ctx *context.Context, filter *dtos.DashboardFilters) (*dtos.LiveViewResponse, error) {
-	query := ctx.DB.Table(t.getTable(ctx)).Select(`rfqs.id ,rfqs.code, extract(epoch from rfqs.created_at)::INTEGER as created_at,
+	table, err := t.getTable(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	query := ctx.DB.Table(table).Select(`rfqs.id ,rfqs.code, extract(epoch from rfqs.created_at)::INTEGER as created_at,
 		rfqs.pol_name as origin_port, rfqs.pod_name as dest_port,  rfqs.company_name,
 		(CASE WHEN rfqs.type != 'FCL' THEN round(rfqs.occupied_cbm,2) ELSE 0 END) as volume, 
 		(CASE WHEN rfqs.type != 'FCL' THEN round(rfqs.occupied_weight,2) ELSE 0 END) as weight, 
@@ -70,7 +75,7 @@ func (t *Rfq) GetforFunnelFilterQuotes(ctx *context.Context, filter *dtos.Dashbo
 		Data: []*dtos.LiveViewData{},
 	}
 
-	err := query.Debug().Find(&res.Data).Error
+	err = query.Debug().Find(&res.Data).Error
 	if err != nil {
 		return nil, err
 	}
@@ -79,8 +84,13 @@ func (t *Rfq) GetforFunnelFilterQuotes(ctx *context.Context, filter *dtos.Dashbo
 }
 
 func (t *Rfq) GetforFunnelCountFilter(ctx *context.Context, filter *dtos.DashboardFilters) ([]string, error) {
+	table, err := t.getTable(ctx)
+	if err != nil {
+		return nil, err
+	}
+
 	var res []string
-	query := ctx.DB.Debug().WithContext(ctx.Request.Context()).Table(t.getTable(ctx)).
+	query := ctx.DB.Debug().WithContext(ctx.Request.Context()).Table(table).
 		Joins("LEFT JOIN cards AS bc ON (rfqs.id)::TEXT = bc.instance_id AND bc.name = 'Buy Rates' AND bc.assigned_to = ?", filter.RequestedBy).Where("is_deleted = false").
 		Select("distinct(rfqs.type) as type").
 		Where("rfqs.is_shipment_converted = false")
@@ -101,7 +111,7 @@ func (t *Rfq) GetforFunnelCountFilter(ctx *context.Context, filter *dtos.Dashboa
 		query = query.Where("rfqs.created_at <= ?", time.Unix(filter.To, 0))
 	}
 
-	err := query.Scan(&res).Error
+	err = query.Scan(&res).Error
 	if err != nil {
 		return nil, err
 	}
@@ -110,8 +120,13 @@ func (t *Rfq) GetforFunnelCountFilter(ctx *context.Context, filter *dtos.Dashboa
 }
 
 func (t *Rfq) GetFunnelViewCount(ctx *context.Context, filter *dtos.DashboardFilters) (int, error) {
+	table, err := t.getTable(ctx)
+	if err != nil {
+		return 0, err
+	}
+
 	var cnt int
-	query := ctx.DB.Debug().WithContext(ctx.Request.Context()).Table(t.getTable(ctx)).
+	query := ctx.DB.Debug().WithContext(ctx.Request.Context()).Table(table).
 		Joins("LEFT JOIN cards AS bc ON (rfqs.id)::TEXT = bc.instance_id AND bc.name = 'Buy Rates' AND bc.assigned_to = ?", filter.RequestedBy).Where("is_deleted = false").
 		Where("rfqs.is_shipment_converted = false")
 
@@ -159,7 +174,7 @@ func (t *Rfq) GetFunnelViewCount(ctx *context.Context, filter *dtos.DashboardFil
 		query = query.Where("(rfqs.status not ilike ? AND rfqs.status not ilike ?)", "%"+constants.RfqStatusSellTBA, "%"+constants.RfqStatusBuyTBA)
 	}
 
-	err := query.Scan(&cnt).Error
+	err = query.Scan(&cnt).Error
 	if err != nil {
 		return 0, err
 	}
@@ -168,7 +183,12 @@ func (t *Rfq) GetFunnelViewCount(ctx *context.Context, filter *dtos.DashboardFil
 }
 
 func (t *Rfq) GetLiveQuotes(ctx *context.Context, filter *dtos.DashboardFilters) (*dtos.LiveViewResponse, error) {
-	query := ctx.DB.Table(t.getTable(ctx)).Select(`rfqs.id ,rfqs.code, extract(epoch from rfqs.created_at)::INTEGER as created_at,
+	table, err := t.getTable(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	query := ctx.DB.Table(table).Select(`rfqs.id ,rfqs.code, extract(epoch from rfqs.created_at)::INTEGER as created_at,
 		rfqs.pol_name as origin_port, rfqs.pod_name as dest_port,  rfqs.company_name,
 		(CASE WHEN rfqs.type != 'FCL' THEN round(rfqs.occupied_cbm,2) ELSE 0 END) as volume, 
 		(CASE WHEN rfqs.type != 'FCL' THEN round(rfqs.occupied_weight,2) ELSE 0 END) as weight,
@@ -214,7 +234,7 @@ func (t *Rfq) GetLiveQuotes(ctx *context.Context, filter *dtos.DashboardFilters)
 		Data: []*dtos.LiveViewData{},
 	}
 
-	err := query.Debug().Find(&res.Data).Error
+	err = query.Debug().Find(&res.Data).Error
 	if err != nil {
 		return nil, err
 	}
@@ -223,7 +243,12 @@ func (t *Rfq) GetLiveQuotes(ctx *context.Context, filter *dtos.DashboardFilters)
 }
 
 func (t *Rfq) GetLiveEnquiries(ctx *context.Context, filter *dtos.DashboardFilters) (*dtos.LiveViewResponse, error) {
-	query := ctx.DB.Table(t.getTable(ctx)).
+	table, err := t.getTable(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	query := ctx.DB.Table(table).
 		Joins("LEFT JOIN cards AS bc ON (rfqs.id)::TEXT = bc.instance_id AND bc.name = 'Buy Rates' AND bc.assigned_to = ? AND bc.status != 'Delete'", filter.RequestedBy).
 		Joins("LEFT JOIN cards AS sc ON (rfqs.id)::TEXT = sc.instance_id AND sc.name = 'Sell Rates' AND sc.assigned_to = ? AND sc.status != 'Delete'", filter.RequestedBy).
 		Joins("LEFT JOIN (select instance_id, bool_or(CASE WHEN (completed_at > estimate OR (estimate < now() AND status != 'Completed')) THEN true ELSE false END) as expired from cards WHERE name = 'Buy Rates' AND status != 'Delete' GROUP BY instance_id) as bre ON ((rfqs.id)::TEXT = bre.instance_id)").
@@ -308,7 +333,7 @@ func (t *Rfq) GetLiveEnquiries(ctx *context.Context, filter *dtos.DashboardFilte
 		Data: []*dtos.LiveViewData{},
 	}
 
-	err := query.Debug().Find(&res.Data).Error
+	err = query.Debug().Find(&res.Data).Error
 	if err != nil {
 		return nil, err
 	}
@@ -317,8 +342,13 @@ func (t *Rfq) GetLiveEnquiries(ctx *context.Context, filter *dtos.DashboardFilte
 }
 
 func (t *Rfq) GetLiveEnquiresCount(ctx *context.Context, filter *dtos.DashboardFilters) (int, error) {
+	table, err := t.getTable(ctx)
+	if err != nil {
+		return 0, err
+	}
+
 	var cnt int
-	query := ctx.DB.Debug().WithContext(ctx.Request.Context()).Select("count(rfqs.id)").Table(t.getTable(ctx)).
+	query := ctx.DB.Debug().WithContext(ctx.Request.Context()).Select("count(rfqs.id)").Table(table).
 		Joins("LEFT JOIN cards AS bc ON (rfqs.id)::TEXT = bc.instance_id AND bc.name = 'Buy Rates' AND bc.assigned_to = ?", filter.RequestedBy).
 		Joins("LEFT JOIN cards AS sc ON (rfqs.id)::TEXT = sc.instance_id AND sc.name = 'Sell Rates' AND sc.assigned_to = ?", filter.RequestedBy).
 		Joins("LEFT JOIN (select instance_id, bool_or(CASE WHEN (completed_at > estimate OR (estimate < now() AND status != 'Completed')) THEN true ELSE false END) as expired from cards WHERE name = 'Buy Rates' GROUP BY instance_id) as bre ON ((rfqs.id)::TEXT = bre.instance_id)").
@@ -352,7 +382,7 @@ func (t *Rfq) GetLiveEnquiresCount(ctx *context.Context, filter *dtos.DashboardF
 		query = query.Where("rfqs.status = ?", globals.QuoteStatusPricingApprovalPending).Where("ae.expired = true")
 	}
 
-	err := query.Scan(&cnt).Error
+	err = query.Scan(&cnt).Error
 	if err != nil {
 		return 0, err
 	}
@@ -361,8 +391,13 @@ func (t *Rfq) GetLiveEnquiresCount(ctx *context.Context, filter *dtos.DashboardF
 }
 
 func (t *Rfq) GetLiveRFQsCount(ctx *context.Context, filter *dtos.DashboardFilters) (int, error) {
+	table, err := t.getTable(ctx)
+	if err != nil {
+		return 0, err
+	}
+
 	var cnt int
-	query := ctx.DB.Debug().WithContext(ctx.Request.Context()).Select("count(rfqs.id)").Table(t.getTable(ctx)).
+	query := ctx.DB.Debug().WithContext(ctx.Request.Context()).Select("count(rfqs.id)").Table(table).
 		Joins("JOIN rfq_quotes AS rq ON (rfqs.id = rq.rfq_id)").
 		Joins("JOIN quotes AS q ON (rq.quote_id = q.id)").
 		Joins("LEFT JOIN cards AS bc ON (rfqs.id)::TEXT = bc.instance_id AND bc.name = 'Buy Rates' AND bc.assigned_to = ?", filter.RequestedBy).Where("is_deleted = false").Where("q.is_approved = true").
@@ -385,7 +420,7 @@ func (t *Rfq) GetLiveRFQsCount(ctx *context.Context, filter *dtos.DashboardFilte
 		query = query.Where("rfqs.status != ?", constants.RfqStatusExpired).Where("rfqs.expires_at >= ?", time.Now().Add(1*time.Hour*time.Duration(config.Get().LivequotesDays)))
 	}
 
-	err := query.Scan(&cnt).Error
+	err = query.Scan(&cnt).Error
 	if err != nil {
 		return 0, err
 	}
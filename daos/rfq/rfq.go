@@ -9,6 +9,7 @@ import (
 	"bitbucket.org/radarventures/forwarder-shipments/config"
 	"bitbucket.org/radarventures/forwarder-shipments/config/globals"
 	"bitbucket.org/radarventures/forwarder-shipments/constants"
+	"bitbucket.org/radarventures/forwarder-shipments/daos/tenant"
 	"bitbucket.org/radarventures/forwarder-shipments/database/models"
 	"bitbucket.org/radarventures/forwarder-shipments/utils"
 	"github.com/google/uuid"
@@ -44,6 +45,7 @@ type IRfq interface {
 	GetQuoteActivities(ctx *context.Context, cid string) ([]*models.Rfq, error)
 	GetPaginatedConsolRfqs(ctx *context.Context, req *dtos.ConsolGetReq) ([]*dtos.ConsolParameters, error)
 	GetCountsPendingConsol(ctx *context.Context, req *dtos.ConsolGetReq) (int64, error)
+	GetPricingBasis(ctx *context.Context, id string) (*models.RfqPricingBasis, error)
 }
 
 type Rfq struct {
@@ -53,28 +55,45 @@ func NewRfq() IRfq {
 	return &Rfq{}
 }
 
-func (t *Rfq) getTable(ctx *context.Context) string {
-	if ctx.TenantID == "" {
-		ctx.TenantID = "public"
-	}
-	return ctx.TenantID + "." + "rfqs"
+func (t *Rfq) getTable(ctx *context.Context) (string, error) {
+	return tenant.Table(ctx, "rfqs")
 }
 
 func (t *Rfq) Upsert(ctx *context.Context, m ...*models.Rfq) error {
-	return ctx.DB.WithContext(ctx.Request.Context()).Table(t.getTable(ctx)).Save(m).Error
+	table, err := t.getTable(ctx)
+	if err != nil {
+		return err
+	}
+
+	return ctx.DB.WithContext(ctx.Request.Context()).Table(table).Save(m).Error
 }
 
 func (t *Rfq) UpdateWithTx(ctx *context.Context, tx *gorm.DB, m *models.Rfq) error {
-	return tx.WithContext(ctx.Request.Context()).Table(t.getTable(ctx)).Debug().Where("id = ?", m.ID).Updates(m).Error
+	table, err := t.getTable(ctx)
+	if err != nil {
+		return err
+	}
+
+	return tx.WithContext(ctx.Request.Context()).Table(table).Debug().Where("id = ?", m.ID).Updates(m).Error
 }
 
 func (t *Rfq) Update(ctx *context.Context, m *models.Rfq) error {
-	return ctx.DB.WithContext(ctx.Request.Context()).Table(t.getTable(ctx)).Updates(m).Error
+	table, err := t.getTable(ctx)
+	if err != nil {
+		return err
+	}
+
+	return ctx.DB.WithContext(ctx.Request.Context()).Table(table).Updates(m).Error
 }
 
 func (t *Rfq) Get(ctx *context.Context, id string) (*models.Rfq, error) {
+	table, err := t.getTable(ctx)
+	if err != nil {
+		return nil, err
+	}
+
 	var result models.Rfq
-	err := ctx.DB.Debug().WithContext(ctx.Request.Context()).Table(t.getTable(ctx)).First(&result, "id = ?", id).Error
+	err = ctx.DB.Debug().WithContext(ctx.Request.Context()).Table(table).First(&result, "id = ?", id).Error
 	if err != nil {
 		ctx.Log.Error("Unable to get rfq.", zap.Error(err))
 		return nil, err
@@ -84,8 +103,13 @@ func (t *Rfq) Get(ctx *context.Context, id string) (*models.Rfq, error) {
 }
 
 func (t *Rfq) Delete(ctx *context.Context, id string) error {
+	table, err := t.getTable(ctx)
+	if err != nil {
+		return err
+	}
+
 	var result models.Rfq
-	err := ctx.DB.WithContext(ctx.Request.Context()).Table(t.getTable(ctx)).Delete(&result, "id = ?", id).Error
+	err = ctx.DB.WithContext(ctx.Request.Context()).Table(table).Delete(&result, "id = ?", id).Error
 	if err != nil {
 		ctx.Log.Error("Unable to delete rfq.", zap.Error(err))
 		return err
@@ -95,16 +119,21 @@ func (t *Rfq) Delete(ctx *context.Context, id string) error {
 }
 
 func (t *Rfq) GetAll(ctx *context.Context, ids []string) ([]*models.Rfq, error) {
+	table, err := t.getTable(ctx)
+	if err != nil {
+		return nil, err
+	}
+
 	var result []*models.Rfq
 	if len(ids) == 0 {
-		err := ctx.DB.WithContext(ctx.Request.Context()).Table(t.getTable(ctx)).Find(&result).Error
+		err := ctx.DB.WithContext(ctx.Request.Context()).Table(table).Find(&result).Error
 		if err != nil {
 			ctx.Log.Error("Unable to get rfqs.", zap.Error(err))
 			return nil, err
 		}
 		return result, err
 	}
-	err := ctx.DB.WithContext(ctx.Request.Context()).Table(t.getTable(ctx)).Where("id IN ?", ids).Find(&result).Error
+	err = ctx.DB.WithContext(ctx.Request.Context()).Table(table).Where("id IN ?", ids).Find(&result).Error
 	if err != nil {
 		ctx.Log.Error("Unable to get rfqs.", zap.Error(err))
 		return nil, err
@@ -114,19 +143,34 @@ func (t *Rfq) GetAll(ctx *context.Context, ids []string) ([]*models.Rfq, error)
 }
 
 func (t *Rfq) CheckCode(ctx *context.Context, code string) (bool, error) {
+	table, err := t.getTable(ctx)
+	if err != nil {
+		return false, err
+	}
+
 	var result *models.Rfq
-	err := ctx.DB.WithContext(ctx.Request.Context()).Table(t.getTable(ctx)).Where("code = ?", code).First(&result).Error
+	err = ctx.DB.WithContext(ctx.Request.Context()).Table(table).Where("code = ?", code).First(&result).Error
 	return (err == nil && result.ID != uuid.Nil), err
 }
 
 func (t *Rfq) UpdateRFQIsShipmentConverted(ctx *context.Context, rfqId uuid.UUID, value bool) error {
-	return ctx.DB.WithContext(ctx.Request.Context()).Table(t.getTable(ctx)).Where("id", rfqId).Update("is_shipement_converted", value).Error
+	table, err := t.getTable(ctx)
+	if err != nil {
+		return err
+	}
+
+	return ctx.DB.WithContext(ctx.Request.Context()).Table(table).Where("id", rfqId).Update("is_shipement_converted", value).Error
 }
 
 func (t *Rfq) GetRfqsCount(ctx *context.Context, m *dtos.GetRfqsFiltersReq) ([]*models.GetRfqCountRes, error) {
+	table, err := t.getTable(ctx)
+	if err != nil {
+		return nil, err
+	}
+
 	var result []*models.GetRfqCountRes
 
-	q := ctx.DB.WithContext(ctx.Request.Context()).Table(t.getTable(ctx)).Select("rfqs.status", "count(DISTINCT rfqs.id) as count")
+	q := ctx.DB.WithContext(ctx.Request.Context()).Table(table).Select("rfqs.status", "count(DISTINCT rfqs.id) as count")
 	reqStatus := m.Status
 	m.Status = ""
 
@@ -136,7 +180,7 @@ func (t *Rfq) GetRfqsCount(ctx *context.Context, m *dtos.GetRfqsFiltersReq) ([]*
 		q = q.Where("rfqs.status = ? or rfqs.status = ?", reqStatus, constants.RfqStatusExpired)
 	}
 
-	err := q.Group("rfqs.status").Scan(&result).Error
+	err = q.Group("rfqs.status").Scan(&result).Error
 	if err != nil {
 		ctx.Log.Error("failed to get count of rfqs", zap.Error(err))
 		return nil, err
@@ -146,11 +190,16 @@ func (t *Rfq) GetRfqsCount(ctx *context.Context, m *dtos.GetRfqsFiltersReq) ([]*
 
 func (t *Rfq) GetRfqsPaginated(ctx *context.Context, ms *dtos.GetRfqsFiltersReq) ([]*models.Rfq, error) {
 
+	table, err := t.getTable(ctx)
+	if err != nil {
+		return nil, err
+	}
+
 	var res []*models.Rfq
 	offset := int(ms.Count * (ms.Pg - 1))
 	limit := int(ms.Count)
 
-	q := ctx.DB.Debug().WithContext(ctx.Request.Context()).Table(t.getTable(ctx)).Select("DISTINCT rfqs.*")
+	q := ctx.DB.Debug().WithContext(ctx.Request.Context()).Table(table).Select("DISTINCT rfqs.*")
 
 	t.getRfqsFilterQuery(ctx, ms, q)
 
@@ -167,7 +216,7 @@ func (t *Rfq) GetRfqsPaginated(ctx *context.Context, ms *dtos.GetRfqsFiltersReq)
 	q = q.Limit(limit).
 		Offset(offset)
 
-	err := q.Scan(&res).Error
+	err = q.Scan(&res).Error
 	if err != nil {
 		ctx.Log.Error("failed to get count of rfqs", zap.Error(err))
 		return nil, err
@@ -336,8 +385,13 @@ func (t *Rfq) getRfqsFilterQuery(ctx *context.Context, m *dtos.GetRfqsFiltersReq
 }
 
 func (t *Rfq) GetSearchListForRfq(ctx *context.Context, m *dtos.GetRfqsFiltersReq) ([]*dtos.RfqFilter, error) {
+	table, err := t.getTable(ctx)
+	if err != nil {
+		return nil, err
+	}
+
 	var res []*dtos.RfqFilter
-	err := ctx.DB.WithContext(ctx.Request.Context()).Table(t.getTable(ctx)).Select("id", "code").
+	err = ctx.DB.WithContext(ctx.Request.Context()).Table(table).Select("id", "code").
 		Where("(is_deleted = false OR is_deleted IS NULL) AND (is_shipment_converted = false) AND ? = ANY(ARRAY[region_id,origin_region_id,dest_region_id]) AND code ilike ?", ctx.Account.RegionID, m.Q+"%").Scan(&res).Error
 	if err != nil {
 		ctx.Log.Error("failed to get search list for rfq", zap.Error(err))
@@ -347,22 +401,27 @@ func (t *Rfq) GetSearchListForRfq(ctx *context.Context, m *dtos.GetRfqsFiltersRe
 }
 
 func (t *Rfq) GetCustomerDashboardEnquiryQuoteCount(ctx *context.Context, cids []string) (int64, int64, error) {
+	table, err := t.getTable(ctx)
+	if err != nil {
+		return 0, 0, err
+	}
+
 	var enquiryCount, quoteCount int64
 
 	enquiryQuery := ctx.DB.WithContext(ctx.Request.Context()).
-		Table(t.getTable(ctx)).
+		Table(table).
 		Select("id").
 		Where("is_deleted = false").
 		Where("is_shipment_converted = false").
 		Where("company_id IN (?)", cids).
 		Not("status IN (?)", []string{constants.RfqStatusConfirmed, constants.RfqStatusExpired})
 
-	err := enquiryQuery.Debug().Count(&enquiryCount).Error
+	err = enquiryQuery.Debug().Count(&enquiryCount).Error
 	if err != nil {
 		return 0, 0, err
 	}
 
-	quoteQuery := ctx.DB.WithContext(ctx.Request.Context()).Table(t.getTable(ctx)).Select("distinct(rfqs.id)").
+	quoteQuery := ctx.DB.WithContext(ctx.Request.Context()).Table(table).Select("distinct(rfqs.id)").
 		Joins("INNER JOIN rfq_quotes rq ON rfqs.id = rq.rfq_id").
 		Joins("INNER JOIN quotes q ON rq.quote_id = q.id").
 		Where("rfqs.company_id IN (?)", cids).
@@ -382,9 +441,14 @@ func (t *Rfq) GetCustomerDashboardEnquiryQuoteCount(ctx *context.Context, cids [
 }
 
 func (t *Rfq) GetRfqCardFilter(ctx *context.Context, ids []string, req dtos.CardInstanceReq) ([]*models.Rfq, error) {
+	table, err := t.getTable(ctx)
+	if err != nil {
+		return nil, err
+	}
+
 	var results []*models.Rfq
-	query := ctx.DB.WithContext(ctx.Request.Context()).Table(t.getTable(ctx)).Where("id in (?) ", ids).Where(&req)
-	err := query.Find(&results).Error
+	query := ctx.DB.WithContext(ctx.Request.Context()).Table(table).Where("id in (?) ", ids).Where(&req)
+	err = query.Find(&results).Error
 	if err != nil {
 		ctx.Log.Error("Error while getting bulk  --DB", zap.Any("id", ids), zap.Error(err))
 	}
@@ -392,8 +456,13 @@ func (t *Rfq) GetRfqCardFilter(ctx *context.Context, ids []string, req dtos.Card
 }
 
 func (t *Rfq) GetEnquiriesForPartner(ctx *context.Context, id string) ([]*models.Rfq, error) {
+	table, err := t.getTable(ctx)
+	if err != nil {
+		return nil, err
+	}
+
 	var rfqs []*models.Rfq
-	query := ctx.DB.Debug().WithContext(ctx.Request.Context()).Table(t.getTable(ctx)).
+	query := ctx.DB.Debug().WithContext(ctx.Request.Context()).Table(table).
 		Select("DISTINCT rfqs.id").
 		Joins("INNER JOIN rfq_quotes AS rq ON rfqs.id = rq.rfq_id").
 		Joins("INNER JOIN quotes AS q ON rq.quote_id = q.id").
@@ -402,7 +471,7 @@ func (t *Rfq) GetEnquiriesForPartner(ctx *context.Context, id string) ([]*models
 		Where("q.is_approved = ?", false).
 		Where("rfqs.status != 'expired'").
 		Where("rq.rfq_id IS NOT NULL")
-	err := query.Find(&rfqs).Error
+	err = query.Find(&rfqs).Error
 	if err != nil {
 		return nil, err
 	}
@@ -410,15 +479,20 @@ func (t *Rfq) GetEnquiriesForPartner(ctx *context.Context, id string) ([]*models
 }
 
 func (t *Rfq) GetEnquiriesForCustomer(ctx *context.Context, cid string) ([]*models.Rfq, error) {
+	table, err := t.getTable(ctx)
+	if err != nil {
+		return nil, err
+	}
+
 	var rfqs []*models.Rfq
 	enquiryQuery := ctx.DB.WithContext(ctx.Request.Context()).
-		Table(t.getTable(ctx)).
+		Table(table).
 		Where("is_deleted = false").
 		Where("is_shipment_converted = false").
 		Where("company_id = ?", cid).
 		Not("status IN (?)", []string{constants.RfqStatusExpired})
 
-	err := enquiryQuery.Debug().Find(&rfqs).Error
+	err = enquiryQuery.Debug().Find(&rfqs).Error
 	if err != nil {
 		return nil, err
 	}
@@ -427,9 +501,14 @@ func (t *Rfq) GetEnquiriesForCustomer(ctx *context.Context, cid string) ([]*mode
 
 func (t *Rfq) GetRfqByDealId(ctx *context.Context, dealId string) (*models.Rfq, error) {
 
+	table, err := t.getTable(ctx)
+	if err != nil {
+		return nil, err
+	}
+
 	var result models.Rfq
 
-	err := ctx.DB.Debug().WithContext(ctx.Request.Context()).Table(t.getTable(ctx)).First(&result, "deal_id = ?", dealId).Error
+	err = ctx.DB.Debug().WithContext(ctx.Request.Context()).Table(table).First(&result, "deal_id = ?", dealId).Error
 	if err != nil {
 		ctx.Log.Error("Unable to get rfq.", zap.Error(err))
 		return nil, err
@@ -439,8 +518,13 @@ func (t *Rfq) GetRfqByDealId(ctx *context.Context, dealId string) (*models.Rfq,
 }
 
 func (t *Rfq) GetQuoteActivities(ctx *context.Context, cid string) ([]*models.Rfq, error) {
+	table, err := t.getTable(ctx)
+	if err != nil {
+		return nil, err
+	}
+
 	var result []*models.Rfq
-	query := ctx.DB.Debug().WithContext(ctx.Request.Context()).Table(t.getTable(ctx)+" rfqs").
+	query := ctx.DB.Debug().WithContext(ctx.Request.Context()).Table(table+" rfqs").
 		Select("rfqs.id, rfqs.code, rfqs.pol, rfqs.pod, cards.updated_at").
 		Joins("INNER JOIN cards ON rfqs.id::text = cards.instance_id").
 		Where("rfqs.company_id = ?", cid).
@@ -450,7 +534,7 @@ func (t *Rfq) GetQuoteActivities(ctx *context.Context, cid string) ([]*models.Rf
 		Where("cards.name = ?", constants.MilestoneQuoteConfirmation).
 		Where("cards.type = ?", constants.MilestoneShipmentCreated)
 
-	err := query.Find(&result).Error
+	err = query.Find(&result).Error
 	if err != nil {
 		ctx.Log.Error("Error in fetching rfq card details", zap.Error(err))
 		return nil, err
@@ -460,8 +544,13 @@ func (t *Rfq) GetQuoteActivities(ctx *context.Context, cid string) ([]*models.Rf
 }
 
 func (t *Rfq) GetPaginatedConsolRfqs(ctx *context.Context, req *dtos.ConsolGetReq) ([]*dtos.ConsolParameters, error) {
+	table, err := t.getTable(ctx)
+	if err != nil {
+		return nil, err
+	}
+
 	var results []*dtos.ConsolParameters
-	query := ctx.DB.Debug().WithContext(ctx.Request.Context()).Table(t.getTable(ctx)+" consol").
+	query := ctx.DB.Debug().WithContext(ctx.Request.Context()).Table(table+" consol").
 		Select(`
 	consol.id AS consol_id, 
 	rq.quote_id AS consol_quote_id,
@@ -515,7 +604,12 @@ func (t *Rfq) GetPaginatedConsolRfqs(ctx *context.Context, req *dtos.ConsolGetRe
 }
 
 func (t *Rfq) GetCountsPendingConsol(ctx *context.Context, req *dtos.ConsolGetReq) (int64, error) {
-	q := ctx.DB.Debug().WithContext(ctx.Request.Context()).Table(t.getTable(ctx)+" rfq").
+	table, err := t.getTable(ctx)
+	if err != nil {
+		return 0, err
+	}
+
+	q := ctx.DB.Debug().WithContext(ctx.Request.Context()).Table(table+" rfq").
 		Select("count(rfq.id)").
 		Where("rfq.type = ? AND rfq.status = ? AND rfq.is_deleted = false", globals.BookingTypeCONSOL, globals.ConsolStatusBuyTBA).
 		Where("rfq.region_id = ?", req.RegionId)
@@ -531,10 +625,31 @@ func (t *Rfq) GetCountsPendingConsol(ctx *context.Context, req *dtos.ConsolGetRe
 	}
 
 	var pendingCount int64
-	err := q.Scan(&pendingCount).Error
+	err = q.Scan(&pendingCount).Error
 	if err != nil {
 		return 0, err
 	}
 
 	return pendingCount, nil
 }
+
+// GetPricingBasis returns the type, region, customer, ports and cargo volume and weight an
+// RFQ is priced by.
+func (t *Rfq) GetPricingBasis(ctx *context.Context, id string) (*models.RfqPricingBasis, error) {
+	table, err := t.getTable(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var result models.RfqPricingBasis
+	err = ctx.DB.WithContext(ctx.Request.Context()).Table(table).
+		Select(`id, type, region_id, company_id, COALESCE(pol, '') AS pol, COALESCE(pod, '') AS pod,
+	COALESCE(occupied_cbm, 0) AS cbm, COALESCE(occupied_weight, 0) AS gross_weight`).
+		Take(&result, "id = ?", id).Error
+	if err != nil {
+		ctx.Log.Error("Unable to get rfq pricing basis.", zap.String("rfq_id", id), zap.Error(err))
+		return nil, err
+	}
+
+	return &result, nil
+}
@@ -15,6 +15,7 @@ type IRfqContainer interface {
 	Update(ctx *context.Context, m *models.RfqContainer) error
 	GetByRfqIds(ctx *context.Context, rfqIds []string) ([]*models.RfqContainer, error)
 	GetCountByRfq(ctx *context.Context, rfqId string) int64
+	GetTypeCounts(ctx *context.Context, rfqId string) ([]*models.RfqContainerCount, error)
 }
 
 type RfqContainer struct {
@@ -111,3 +112,19 @@ func (t *RfqContainer) GetCountByRfq(ctx *context.Context, rfqId string) int64 {
 
 	return count
 }
+
+// GetTypeCounts returns how many containers of each type an RFQ asks for.
+func (t *RfqContainer) GetTypeCounts(ctx *context.Context, rfqId string) ([]*models.RfqContainerCount, error) {
+	var result []*models.RfqContainerCount
+	err := ctx.DB.WithContext(ctx.Request.Context()).Table(t.getTable(ctx)).
+		Select("type, count(*) AS count").
+		Where("rfq_id = ? AND is_active = ?", rfqId, true).
+		Group("type").
+		Find(&result).Error
+	if err != nil {
+		ctx.Log.Error("unable to get rfq container type counts", zap.Error(err), zap.Any("rfq_id", rfqId))
+		return nil, err
+	}
+
+	return result, nil
+}
@@ -14,6 +14,7 @@ type IRfqProduct interface {
 	GetRfqProductsByRfqId(ctx *context.Context, rfqID string) ([]*models.RfqProduct, error)
 	Update(ctx *context.Context, m *models.RfqProduct) error
 	GetByRfqIds(ctx *context.Context, rfqIds []string) ([]*models.RfqProduct, error)
+	GetPackageCount(ctx *context.Context, rfqId string) (float64, error)
 }
 
 type RfqProduct struct {
@@ -96,3 +97,18 @@ func (t *RfqProduct) GetByRfqIds(ctx *context.Context, rfqIds []string) ([]*mode
 
 	return result, err
 }
+
+// GetPackageCount returns the number of packages of the active products of an RFQ.
+func (t *RfqProduct) GetPackageCount(ctx *context.Context, rfqId string) (float64, error) {
+	var count float64
+	err := ctx.DB.WithContext(ctx.Request.Context()).Table(t.getTable(ctx)).
+		Select("COALESCE(SUM(count), 0)").
+		Where("rfq_id = ? AND is_active = ?", rfqId, true).
+		Scan(&count).Error
+	if err != nil {
+		ctx.Log.Error("failed to get rfq package count", zap.Error(err), zap.Any("rfq_id", rfqId))
+		return 0, err
+	}
+
+	return count, nil
+}
@@ -11,8 +11,13 @@ import (
 )
 
 func (t *Shipment) GetInsightDetailCompanies(ctx *context.Context, filter *dtos.DashboardFilters, ports []string) ([]*dtos.Company, error) {
+	table, err := t.getTable(ctx)
+	if err != nil {
+		return nil, err
+	}
+
 	res := []*dtos.Company{}
-	query := ctx.DB.Debug().WithContext(ctx.Request.Context()).Table(t.getTable(ctx)).
+	query := ctx.DB.Debug().WithContext(ctx.Request.Context()).Table(table).
 		Select(`distinct shipments.company_id as id, shipments.company_name as name`).
 		Joins("JOIN rfqs as r ON (r.id = shipments.rfq_id)").
 		Joins("LEFT JOIN cards as c ON ((r.id)::TEXT = c.instance_id  AND c.assigned_to = ?)", filter.RequestedBy).Where("shipments.is_deleted = false").
@@ -54,7 +59,7 @@ func (t *Shipment) GetInsightDetailCompanies(ctx *context.Context, filter *dtos.
 		query = query.Where("shipments.created_at <= ?", time.Unix(filter.To, 0))
 	}
 
-	err := query.Debug().Scan(&res).Error
+	err = query.Debug().Scan(&res).Error
 	if err != nil {
 		return nil, err
 	}
@@ -63,8 +68,13 @@ func (t *Shipment) GetInsightDetailCompanies(ctx *context.Context, filter *dtos.
 }
 
 func (t *Shipment) GetInsightDetails(ctx *context.Context, filter *dtos.DashboardFilters, ports []string) (*dtos.InsightDetailsResponse, error) {
+	table, err := t.getTable(ctx)
+	if err != nil {
+		return nil, err
+	}
+
 	res := &dtos.InsightDetailsResponse{}
-	query := ctx.DB.Debug().WithContext(ctx.Request.Context()).Table(t.getTable(ctx)).
+	query := ctx.DB.Debug().WithContext(ctx.Request.Context()).Table(table).
 		Select(`count(DISTINCT shipments.id), round(sum(DISTINCT shipments.occupied_cbm),2) as volume, round(sum(DISTINCT shipments.occupied_weight),2) as weight, round(sum(DISTINCT bli.total_sell)::NUMERIC, 2) as revenue, round((sum(DISTINCT bli.total_sell)-sum(DISTINCT bli.total_buy))::NUMERIC, 2) as profit, 
 		round(sum(DISTINCT bli.total_buy)::NUMERIC, 2) as total_buy, STRING_AGG(DISTINCT shipments.id::TEXT, ',') AS booking_ids , string_agg(DISTINCT bli.line_item_ids, ',') AS line_item_ids,
 		  sum(shipments.teus) as teus`).
@@ -135,7 +145,7 @@ func (t *Shipment) GetInsightDetails(ctx *context.Context, filter *dtos.Dashboar
 		query = query.Where("shipments.created_at <= ?", time.Unix(filter.To, 0))
 	}
 
-	err := query.Debug().Scan(&res.GetInsightDetails).Error
+	err = query.Debug().Scan(&res.GetInsightDetails).Error
 	if err != nil {
 		return nil, err
 	}
@@ -146,8 +156,13 @@ func (t *Shipment) GetInsightDetails(ctx *context.Context, filter *dtos.Dashboar
 }
 
 func (t *Shipment) GetInsightTimeRangeProcurement(ctx *context.Context, filter *dtos.DashboardFilters) (*dtos.BookingJobs, error) {
+	table, err := t.getTable(ctx)
+	if err != nil {
+		return nil, err
+	}
+
 	res := &dtos.BookingJobs{}
-	query := ctx.DB.Debug().WithContext(ctx.Request.Context()).Table(t.getTable(ctx)).
+	query := ctx.DB.Debug().WithContext(ctx.Request.Context()).Table(table).
 		Select(`count(DISTINCT shipments.id), round(sum(DISTINCT shipments.occupied_cbm),2) as total_volume, round(sum(DISTINCT shipments.occupied_weight),2) as total_weight, round(sum(DISTINCT bli.total_sell)::NUMERIC, 2) as total_revenue, round((sum(DISTINCT bli.total_sell)-sum(DISTINCT bli.total_buy))::NUMERIC, 2) as total_profit, 
 		round(sum(DISTINCT bli.total_buy)::NUMERIC, 2) as total_buy, STRING_AGG(DISTINCT shipments.id::TEXT, ',') AS booking_ids , string_agg(DISTINCT bli.line_item_ids, ',') AS line_item_ids, sum(shipments.teus) as total_teu`).
 		Joins(`JOIN (SELECT li.quote_id, sum(DISTINCT li.buy*li.units*buy_ex.exchange_rate)::NUMERIC as total_buy, 
@@ -182,7 +197,7 @@ func (t *Shipment) GetInsightTimeRangeProcurement(ctx *context.Context, filter *
 		query = query.Where("shipments.created_at <= ?", time.Unix(filter.To, 0))
 	}
 
-	err := query.Scan(&res).Error
+	err = query.Scan(&res).Error
 	if err != nil {
 		return nil, err
 	}
@@ -191,8 +206,13 @@ func (t *Shipment) GetInsightTimeRangeProcurement(ctx *context.Context, filter *
 }
 
 func (t *Shipment) GetTimeRangeForInsight(ctx *context.Context, filter *dtos.DashboardFilters) (*dtos.BookingJobs, error) {
+	table, err := t.getTable(ctx)
+	if err != nil {
+		return nil, err
+	}
+
 	res := &dtos.BookingJobs{}
-	query := ctx.DB.Debug().WithContext(ctx.Request.Context()).Table(t.getTable(ctx)).
+	query := ctx.DB.Debug().WithContext(ctx.Request.Context()).Table(table).
 		Select(`count(DISTINCT shipments.id), round(sum(DISTINCT shipments.occupied_cbm),2) as total_volume, round(sum(DISTINCT shipments.occupied_weight),2) as total_weight, round(sum(DISTINCT bli.total_sell)::NUMERIC, 2) as total_revenue, round((sum(DISTINCT bli.total_sell)-sum(DISTINCT bli.total_buy))::NUMERIC, 2) as total_profit, 
 		round(sum(DISTINCT bli.total_buy)::NUMERIC, 2) as total_buy, STRING_AGG(DISTINCT shipments.id::TEXT, ',') AS booking_ids , string_agg(DISTINCT bli.line_item_ids, ',') AS line_item_ids, sum(shipments.teus) as total_teu`).
 		Joins(`JOIN (SELECT li.quote_id, sum(DISTINCT li.buy*li.units*buy_ex.exchange_rate)::NUMERIC as total_buy, 
@@ -227,7 +247,7 @@ func (t *Shipment) GetTimeRangeForInsight(ctx *context.Context, filter *dtos.Das
 		query = query.Where("shipments.created_at <= ?", time.Unix(filter.To, 0))
 	}
 
-	err := query.Scan(&res).Error
+	err = query.Scan(&res).Error
 	if err != nil {
 		return nil, err
 	}
@@ -236,7 +256,12 @@ func (t *Shipment) GetTimeRangeForInsight(ctx *context.Context, filter *dtos.Das
 }
 
 func (t *Shipment) GetforFunnelFilterBookings(ctx *context.Context, filter *dtos.DashboardFilters) (*dtos.LiveViewResponse, error) {
-	query := ctx.DB.Debug().WithContext(ctx.Request.Context()).Table(t.getTable(ctx)).Select(`shipments.id ,shipments.code, extract(epoch from shipments.created_at)::INTEGER as created_at,
+	table, err := t.getTable(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	query := ctx.DB.Debug().WithContext(ctx.Request.Context()).Table(table).Select(`shipments.id ,shipments.code, extract(epoch from shipments.created_at)::INTEGER as created_at,
 		shipments.pol_name as origin_port, shipments.pod_name as dest_port, shipments.quote_id, shipments.company_name,
 		(CASE WHEN shipments.type != 'FCL' THEN round(shipments.occupied_cbm,2) ELSE 0 END) as volume, 
 		(CASE WHEN shipments.type != 'FCL' THEN round(shipments.occupied_weight,2) ELSE 0 END) as weight,
@@ -287,7 +312,7 @@ func (t *Shipment) GetforFunnelFilterBookings(ctx *context.Context, filter *dtos
 		Data: []*dtos.LiveViewData{},
 	}
 
-	err := query.Debug().Find(&res.Data).Error
+	err = query.Debug().Find(&res.Data).Error
 	if err != nil {
 		return nil, err
 	}
@@ -296,8 +321,13 @@ func (t *Shipment) GetforFunnelFilterBookings(ctx *context.Context, filter *dtos
 }
 
 func (t *Shipment) GetFunnelViewCount(ctx *context.Context, filter *dtos.DashboardFilters) (int, error) {
+	table, err := t.getTable(ctx)
+	if err != nil {
+		return 0, err
+	}
+
 	var cnt int
-	query := ctx.DB.Debug().WithContext(ctx.Request.Context()).Table(t.getTable(ctx)).
+	query := ctx.DB.Debug().WithContext(ctx.Request.Context()).Table(table).
 		Joins("JOIN rfqs as r ON (r.id = shipments.rfq_id)").
 		Joins("LEFT JOIN cards as c ON ((r.id)::TEXT = c.instance_id AND c.assigned_to = ?)", filter.RequestedBy).Where("shipments.is_deleted = false")
 
@@ -343,7 +373,7 @@ func (t *Shipment) GetFunnelViewCount(ctx *context.Context, filter *dtos.Dashboa
 		query = query.Where("shipments.status != ?", constants.ShipmentCreated)
 	}
 
-	err := query.Scan(&cnt).Error
+	err = query.Scan(&cnt).Error
 	if err != nil {
 		return 0, err
 	}
@@ -352,7 +382,12 @@ func (t *Shipment) GetFunnelViewCount(ctx *context.Context, filter *dtos.Dashboa
 }
 
 func (t *Shipment) GetLiveBookings(ctx *context.Context, filter *dtos.DashboardFilters) (*dtos.LiveViewResponse, error) {
-	query := ctx.DB.Debug().WithContext(ctx.Request.Context()).Table(t.getTable(ctx)).Select(`distinct shipments.id ,shipments.code, extract(epoch from shipments.created_at)::INTEGER as created_at,
+	table, err := t.getTable(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	query := ctx.DB.Debug().WithContext(ctx.Request.Context()).Table(table).Select(`distinct shipments.id ,shipments.code, extract(epoch from shipments.created_at)::INTEGER as created_at,
 		shipments.pol_name as origin_port, shipments.pod_name as dest_port, shipments.quote_id, shipments.company_name,
 		(CASE WHEN shipments.type != 'FCL' THEN round(shipments.occupied_cbm,2) ELSE 0 END) as volume, 
 		(CASE WHEN shipments.type != 'FCL' THEN round(shipments.occupied_weight,2) ELSE 0 END) as weight,
@@ -412,7 +447,7 @@ func (t *Shipment) GetLiveBookings(ctx *context.Context, filter *dtos.DashboardF
 	res := &dtos.LiveViewResponse{
 		Data: []*dtos.LiveViewData{},
 	}
-	err := query.Debug().Find(&res.Data).Error
+	err = query.Debug().Find(&res.Data).Error
 	if err != nil {
 		return nil, err
 	}
@@ -421,8 +456,13 @@ func (t *Shipment) GetLiveBookings(ctx *context.Context, filter *dtos.DashboardF
 }
 
 func (t *Shipment) GetLiveBookingNotBreached(ctx *context.Context, filter *dtos.DashboardFilters) (int, error) {
+	table, err := t.getTable(ctx)
+	if err != nil {
+		return 0, err
+	}
+
 	var res int
-	query := ctx.DB.Debug().WithContext(ctx.Request.Context()).Table(t.getTable(ctx)).Select("count(shipments.id)").
+	query := ctx.DB.Debug().WithContext(ctx.Request.Context()).Table(table).Select("count(shipments.id)").
 		Joins("JOIN rfqs as r ON (r.id = shipments.rfq_id)").
 		Joins("LEFT JOIN cards as c ON ((r.id)::TEXT = c.instance_id AND  name = 'Buy Rates' AND c.assigned_to = ?)", filter.RequestedBy).
 		Joins("LEFT JOIN (select instance_id, bool_or(CASE WHEN (completed_at > estimate OR (estimate < now() AND status != 'Completed')) THEN true ELSE false END) as expired from cards WHERE name = 'Buy Rates' GROUP BY instance_id) as bre ON ((r.id)::TEXT = bre.instance_id)").
@@ -444,7 +484,7 @@ func (t *Shipment) GetLiveBookingNotBreached(ctx *context.Context, filter *dtos.
 		query = query.Where("shipments.status != ?", constants.ShipmentCreated)
 	}
 
-	err := query.Debug().Scan(&res).Error
+	err = query.Debug().Scan(&res).Error
 	if err != nil {
 		return 0, err
 	}
@@ -453,8 +493,13 @@ func (t *Shipment) GetLiveBookingNotBreached(ctx *context.Context, filter *dtos.
 }
 
 func (t *Shipment) GetLiveBookingBreached(ctx *context.Context, filter *dtos.DashboardFilters) (int, error) {
+	table, err := t.getTable(ctx)
+	if err != nil {
+		return 0, err
+	}
+
 	var res int
-	query := ctx.DB.Debug().WithContext(ctx.Request.Context()).Table(t.getTable(ctx)).Select("count(shipments.id)").
+	query := ctx.DB.Debug().WithContext(ctx.Request.Context()).Table(table).Select("count(shipments.id)").
 		Joins("JOIN rfqs as r ON (r.id = shipments.rfq_id)").
 		Joins("JOIN cards as c ON ((r.id)::TEXT = c.instance_id AND  name = 'Buy Rates' AND c.assigned_to = ?)", filter.RequestedBy).
 		Joins("LEFT JOIN (select instance_id, bool_or(CASE WHEN (completed_at > estimate OR (estimate < now() AND status != 'Completed')) THEN true ELSE false END) as expired from cards WHERE name = 'Buy Rates' GROUP BY instance_id) as bre ON ((r.id)::TEXT = bre.instance_id)").
@@ -476,7 +521,7 @@ func (t *Shipment) GetLiveBookingBreached(ctx *context.Context, filter *dtos.Das
 		query = query.Where("shipments.status != ?", constants.ShipmentCreated)
 	}
 
-	err := query.Debug().Scan(&res).Error
+	err = query.Debug().Scan(&res).Error
 	if err != nil {
 		return 0, err
 	}
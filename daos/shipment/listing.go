@@ -212,6 +212,11 @@ func (t *Shipment) getListingFiltersQuery(ctx *context.Context, q *gorm.DB, isFo
 }
 
 func (t *Shipment) GetShipmentCounts(ctx *context.Context, adminIds, customerIds []string) ([]*models.ShipmentCount, error) {
+	table, err := t.getTable(ctx)
+	if err != nil {
+		return nil, err
+	}
+
 	var counts []*models.ShipmentCount
 
 	if ctx.Query("partner_id") != "" || ctx.Query("q_type") == "partner" {
@@ -223,11 +228,11 @@ func (t *Shipment) GetShipmentCounts(ctx *context.Context, adminIds, customerIds
 		return counts, nil
 	}
 
-	query := ctx.DB.Debug().WithContext(ctx.Request.Context()).Table(t.getTable(ctx)).Select("shipments.status", "count(shipments.id) as count")
+	query := ctx.DB.Debug().WithContext(ctx.Request.Context()).Table(table).Select("shipments.status", "count(shipments.id) as count")
 	t.getListingFiltersQuery(ctx, query, true, adminIds, customerIds)
 	query.Group("shipments.status")
 
-	err := query.Find(&counts).Error
+	err = query.Find(&counts).Error
 	if err != nil {
 		ctx.Log.Error("error while fetching GetShipmentCounts", zap.Error(err))
 		return nil, err
@@ -237,9 +242,14 @@ func (t *Shipment) GetShipmentCounts(ctx *context.Context, adminIds, customerIds
 }
 
 func (t *Shipment) GetShipmentList(ctx *context.Context, adminIds, customerIds []string) ([]*models.ShipmentList, error) {
+	table, err := t.getTable(ctx)
+	if err != nil {
+		return nil, err
+	}
+
 	var shipments []*models.ShipmentList
 
-	query := ctx.DB.Debug().WithContext(ctx.Request.Context()).Table(t.getTable(ctx)).Select("shipments.*", "quotes.eta", "quotes.etd").
+	query := ctx.DB.Debug().WithContext(ctx.Request.Context()).Table(table).Select("shipments.*", "quotes.eta", "quotes.etd").
 		Joins("LEFT JOIN " + ctx.TenantID + ".quotes ON shipments.quote_id = quotes.id")
 	t.getListingFiltersQuery(ctx, query, false, adminIds, customerIds)
 
@@ -288,18 +298,23 @@ func (t *Shipment) GetShipmentList(ctx *context.Context, adminIds, customerIds [
 }
 
 func (t *Shipment) GetShipmentListSearch(ctx *context.Context) ([]*models.ShipmentSearchFilter, error) {
+	table, err := t.getTable(ctx)
+	if err != nil {
+		return nil, err
+	}
+
 	var shipmentSearch []*models.ShipmentSearchFilter
 
-	subQuery := ctx.DB.WithContext(ctx.Request.Context()).Table(t.getTable(ctx)).Select("id", "unnest(house_bill_nos) AS name", "created_at", "'shipment' AS type").Where("is_deleted = ? AND ? = ANY(ARRAY[region_id,origin_region_id,dest_region_id])", false, ctx.Account.RegionID)
+	subQuery := ctx.DB.WithContext(ctx.Request.Context()).Table(table).Select("id", "unnest(house_bill_nos) AS name", "created_at", "'shipment' AS type").Where("is_deleted = ? AND ? = ANY(ARRAY[region_id,origin_region_id,dest_region_id])", false, ctx.Account.RegionID)
 	query1 := ctx.DB.WithContext(ctx.Request.Context()).Raw("WITH house_bills AS ( ? ) SELECT id, name, created_at, 'shipment' AS type FROM house_bills WHERE name ilike ?", subQuery, ctx.Query("q")+"%")
 
-	query2 := ctx.DB.WithContext(ctx.Request.Context()).Table(t.getTable(ctx)).Select("id", "master_bill_no AS name", "created_at", "'shipment' AS type").
+	query2 := ctx.DB.WithContext(ctx.Request.Context()).Table(table).Select("id", "master_bill_no AS name", "created_at", "'shipment' AS type").
 		Where("is_deleted = ? AND ? = ANY(ARRAY[region_id,origin_region_id,dest_region_id]) AND master_bill_no ilike ?", false, ctx.Account.RegionID, ctx.Query("q")+"%")
 
-	query3 := ctx.DB.WithContext(ctx.Request.Context()).Table(t.getTable(ctx)).Select("id", "code AS name", "created_at", "'shipment' AS type").
+	query3 := ctx.DB.WithContext(ctx.Request.Context()).Table(table).Select("id", "code AS name", "created_at", "'shipment' AS type").
 		Where("is_deleted = ? AND ? = ANY(ARRAY[region_id,origin_region_id,dest_region_id]) AND code ilike ?", false, ctx.Account.RegionID, ctx.Query("q")+"%")
 
-	err := ctx.DB.WithContext(ctx.Request.Context()).Raw("? UNION ? UNION ?", query1, query2, query3).Find(&shipmentSearch).Error
+	err = ctx.DB.WithContext(ctx.Request.Context()).Raw("? UNION ? UNION ?", query1, query2, query3).Find(&shipmentSearch).Error
 	if err != nil {
 		ctx.Log.Error("error while fetching GetShipmentCounts", zap.Error(err))
 		return nil, err
@@ -309,16 +324,21 @@ func (t *Shipment) GetShipmentListSearch(ctx *context.Context) ([]*models.Shipme
 }
 
 func (t *Shipment) GetShipmentCountsForPartner(ctx *context.Context, adminIds []string) ([]*models.ShipmentCount, error) {
+	table, err := t.getTable(ctx)
+	if err != nil {
+		return nil, err
+	}
+
 	var partnerShipments []*models.ShipmentList
 	var counts []*models.ShipmentCount
-	query := ctx.DB.Debug().WithContext(ctx.Request.Context()).Table(t.getTable(ctx)).Select("shipments.*", "quotes.eta", "quotes.etd").
+	query := ctx.DB.Debug().WithContext(ctx.Request.Context()).Table(table).Select("shipments.*", "quotes.eta", "quotes.etd").
 		Joins("LEFT JOIN " + ctx.TenantID + ".quotes ON shipments.quote_id = quotes.id")
 	t.getListingFiltersQuery(ctx, query, true, adminIds, nil)
 
 	query.Group("shipments.id")
 	query.Group("quotes.eta")
 	query.Group("quotes.etd")
-	err := query.Find(&partnerShipments).Error
+	err = query.Find(&partnerShipments).Error
 	if err != nil {
 		ctx.Log.Error("error while fetching GetShipmentCounts", zap.Error(err))
 		return nil, err
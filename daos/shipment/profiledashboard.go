@@ -6,10 +6,15 @@ import (
 )
 
 func (t *Shipment) GetShipmentsForPartner(ctx *context.Context, partnerID string) ([]*models.Shipment, error) {
+	table, err := t.getTable(ctx)
+	if err != nil {
+		return nil, err
+	}
+
 	var result []*models.Shipment
 	subQuery := ctx.DB.Debug().Table("line_items li").Select("distinct quote_id").Where("partner_id = ? ", partnerID)
-	query := ctx.DB.Table(t.getTable(ctx)).Joins("JOIN quotes q ON shipments.quote_id = q.id").Where("q.id IN (?) AND shipments.is_deleted = false", subQuery)
-	err := query.Debug().Find(&result).Error
+	query := ctx.DB.Table(table).Joins("JOIN quotes q ON shipments.quote_id = q.id").Where("q.id IN (?) AND shipments.is_deleted = false", subQuery)
+	err = query.Debug().Find(&result).Error
 	if err != nil {
 		return nil, err
 	}
@@ -17,14 +22,19 @@ func (t *Shipment) GetShipmentsForPartner(ctx *context.Context, partnerID string
 }
 
 func (t *Shipment) GetShipmentsForCustomerInfo(ctx *context.Context, cid string) ([]*models.Shipment, error) {
+	table, err := t.getTable(ctx)
+	if err != nil {
+		return nil, err
+	}
+
 	var res []*models.Shipment
 
-	query := ctx.DB.Table(t.getTable(ctx)).
+	query := ctx.DB.Table(table).
 		Where("is_deleted = false")
 
 	query = query.Where("company_id = ?", cid)
 
-	err := query.Debug().Find(&res).Error
+	err = query.Debug().Find(&res).Error
 	if err != nil {
 		return nil, err
 	}
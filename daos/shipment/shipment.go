@@ -10,6 +10,7 @@ import (
 	"bitbucket.org/radarventures/forwarder-shipments/config"
 	"bitbucket.org/radarventures/forwarder-shipments/config/globals"
 	"bitbucket.org/radarventures/forwarder-shipments/constants"
+	"bitbucket.org/radarventures/forwarder-shipments/daos/tenant"
 	"bitbucket.org/radarventures/forwarder-shipments/database/models"
 	"github.com/google/uuid"
 	"go.uber.org/zap"
@@ -69,6 +70,7 @@ type IShipment interface {
 	GetAllUnlockedShipments(ctx *context.Context) ([]*models.Shipment, error)
 	UpdateShipmentsAndRfqs(ctx *context.Context, req *dtos.CustomerNameChangeReq) error
 	UpdateShipmentLock(ctx *context.Context, id string, isLocked bool) error
+	UpdateShipmentLockWithTx(ctx *context.Context, tx *gorm.DB, id string, isLocked bool) error
 }
 
 type Shipment struct {
@@ -78,35 +80,45 @@ func NewShipment() IShipment {
 	return &Shipment{}
 }
 
-func (t *Shipment) getTable(ctx *context.Context) string {
-
-	if ctx.TenantID == "" {
-		ctx.TenantID = "public"
-	}
-
-	return ctx.TenantID + "." + "shipments"
+func (t *Shipment) getTable(ctx *context.Context) (string, error) {
+	return tenant.Table(ctx, "shipments")
 }
-func (t *Shipment) getConsolShipmentsTable(ctx *context.Context) string {
-	return ctx.TenantID + "." + "consol_shipments"
+func (t *Shipment) getConsolShipmentsTable(ctx *context.Context) (string, error) {
+	return tenant.Table(ctx, "consol_shipments")
 }
-func (t *Shipment) getQuotesTable(ctx *context.Context) string {
-	return ctx.TenantID + "." + "quotes"
+func (t *Shipment) getQuotesTable(ctx *context.Context) (string, error) {
+	return tenant.Table(ctx, "quotes")
 }
-func (t *Shipment) getRfqsTable(ctx *context.Context) string {
-	return ctx.TenantID + "." + "rfqs"
+func (t *Shipment) getRfqsTable(ctx *context.Context) (string, error) {
+	return tenant.Table(ctx, "rfqs")
 }
 func (t *Shipment) Upsert(ctx *context.Context, m ...*models.Shipment) error {
-	return ctx.DB.WithContext(ctx.Request.Context()).Table(t.getTable(ctx)).Save(m).Error
+	table, err := t.getTable(ctx)
+	if err != nil {
+		return err
+	}
+
+	return ctx.DB.WithContext(ctx.Request.Context()).Table(table).Save(m).Error
 }
 
 func (t *Shipment) UpsertWithTx(ctx *context.Context, tx *gorm.DB, m ...*models.Shipment) error {
-	return tx.Table(t.getTable(ctx)).Save(m).Error
+	table, err := t.getTable(ctx)
+	if err != nil {
+		return err
+	}
+
+	return tx.Table(table).Save(m).Error
 }
 
 func (t *Shipment) Get(ctx *context.Context, id string) (*models.Shipment, error) {
+	table, err := t.getTable(ctx)
+	if err != nil {
+		return nil, err
+	}
+
 	var result *models.Shipment
 
-	err := ctx.DB.WithContext(ctx.Request.Context()).Table(t.getTable(ctx)).Where("id = ?", id).First(&result).Error
+	err = ctx.DB.WithContext(ctx.Request.Context()).Table(table).Where("id = ?", id).First(&result).Error
 	if err != nil {
 		ctx.Log.Error("unable to get shipment", zap.Error(err))
 		return nil, err
@@ -116,8 +128,13 @@ func (t *Shipment) Get(ctx *context.Context, id string) (*models.Shipment, error
 }
 
 func (t *Shipment) GetByQuote(ctx *context.Context, qid string) (*models.Shipment, error) {
+	table, err := t.getTable(ctx)
+	if err != nil {
+		return nil, err
+	}
+
 	var result *models.Shipment
-	err := ctx.DB.WithContext(ctx.Request.Context()).Table(t.getTable(ctx)).Where("quote_id = ?", qid).First(&result).Error
+	err = ctx.DB.WithContext(ctx.Request.Context()).Table(table).Where("quote_id = ?", qid).First(&result).Error
 	if err != nil {
 		ctx.Log.Error("unable to get shipment", zap.Error(err))
 		return nil, err
@@ -127,7 +144,12 @@ func (t *Shipment) GetByQuote(ctx *context.Context, qid string) (*models.Shipmen
 }
 
 func (t *Shipment) Delete(ctx *context.Context, id string) error {
-	err := ctx.DB.WithContext(ctx.Request.Context()).Table(t.getTable(ctx)).Where("id = ?", id).UpdateColumn("is_deleted", true).UpdateColumn("updated_at", time.Now().UTC()).Error
+	table, err := t.getTable(ctx)
+	if err != nil {
+		return err
+	}
+
+	err = ctx.DB.WithContext(ctx.Request.Context()).Table(table).Where("id = ?", id).UpdateColumn("is_deleted", true).UpdateColumn("updated_at", time.Now().UTC()).Error
 	if err != nil {
 		ctx.Log.Error("Unable to delete shipment.", zap.Error(err))
 		return err
@@ -137,16 +159,21 @@ func (t *Shipment) Delete(ctx *context.Context, id string) error {
 }
 
 func (t *Shipment) GetAll(ctx *context.Context, ids []string) ([]*models.Shipment, error) {
+	table, err := t.getTable(ctx)
+	if err != nil {
+		return nil, err
+	}
+
 	var result []*models.Shipment
 	if len(ids) == 0 {
-		err := ctx.DB.WithContext(ctx.Request.Context()).Table(t.getTable(ctx)).Find(&result).Error
+		err := ctx.DB.WithContext(ctx.Request.Context()).Table(table).Find(&result).Error
 		if err != nil {
 			ctx.Log.Error("Unable to get shipments.", zap.Error(err))
 			return nil, err
 		}
 		return result, err
 	}
-	err := ctx.DB.WithContext(ctx.Request.Context()).Table(t.getTable(ctx)).Where("id IN ?", ids).Find(&result).Error
+	err = ctx.DB.WithContext(ctx.Request.Context()).Table(table).Where("id IN ?", ids).Find(&result).Error
 	if err != nil {
 		ctx.Log.Error("Unable to get shipments.", zap.Error(err))
 		return nil, err
@@ -156,15 +183,25 @@ func (t *Shipment) GetAll(ctx *context.Context, ids []string) ([]*models.Shipmen
 }
 
 func (t *Shipment) CheckCode(ctx *context.Context, code string) (bool, error) {
+	table, err := t.getTable(ctx)
+	if err != nil {
+		return false, err
+	}
+
 	var result *models.Shipment
-	err := ctx.DB.WithContext(ctx.Request.Context()).Table(t.getTable(ctx)).Where("code = ?", code).First(&result).Error
+	err = ctx.DB.WithContext(ctx.Request.Context()).Table(table).Where("code = ?", code).First(&result).Error
 	return (err == nil && result.Id != uuid.Nil), err
 }
 
 func (t *Shipment) GetShipmentCardFilter(ctx *context.Context, ids []string, req dtos.CardInstanceReq) ([]*models.Shipment, error) {
+	table, err := t.getTable(ctx)
+	if err != nil {
+		return nil, err
+	}
+
 	var results []*models.Shipment
-	query := ctx.DB.Debug().WithContext(ctx.Request.Context()).Table(t.getTable(ctx)).Where("id in (?) and (consol_id is null or consol_id = ? )", ids, uuid.Nil).Where(&req)
-	err := query.Find(&results).Error
+	query := ctx.DB.Debug().WithContext(ctx.Request.Context()).Table(table).Where("id in (?) and (consol_id is null or consol_id = ? )", ids, uuid.Nil).Where(&req)
+	err = query.Find(&results).Error
 	if err != nil {
 		ctx.Log.Error("Error while getting bulk  --DB", zap.Any("bid", ids), zap.Error(err))
 	}
@@ -172,16 +209,31 @@ func (t *Shipment) GetShipmentCardFilter(ctx *context.Context, ids []string, req
 }
 
 func (t *Shipment) Update(ctx *context.Context, m *models.Shipment) error {
-	return ctx.DB.WithContext(ctx.Request.Context()).Table(t.getTable(ctx)).Debug().Where("id = ?", m.Id).Updates(m).Error
+	table, err := t.getTable(ctx)
+	if err != nil {
+		return err
+	}
+
+	return ctx.DB.WithContext(ctx.Request.Context()).Table(table).Debug().Where("id = ?", m.Id).Updates(m).Error
 }
 
 func (t *Shipment) UpdateWithTx(ctx *context.Context, tx *gorm.DB, m *models.Shipment) error {
-	return tx.WithContext(ctx.Request.Context()).Table(t.getTable(ctx)).Debug().Where("id = ?", m.Id).Updates(m).Error
+	table, err := t.getTable(ctx)
+	if err != nil {
+		return err
+	}
+
+	return tx.WithContext(ctx.Request.Context()).Table(table).Debug().Where("id = ?", m.Id).Updates(m).Error
 }
 
 func (t *Shipment) GetPaginatedConsol(ctx *context.Context, req *dtos.ConsolGetReq) ([]*dtos.ConsolParameters, error) {
+	table, err := t.getTable(ctx)
+	if err != nil {
+		return nil, err
+	}
+
 	var results []*dtos.ConsolParameters
-	query := ctx.DB.Debug().WithContext(ctx.Request.Context()).Table(t.getTable(ctx)+" consol").
+	query := ctx.DB.Debug().WithContext(ctx.Request.Context()).Table(table+" consol").
 		Select(`
 	consol.id AS consol_id, 
 	consol.quote_id AS consol_quote_id,
@@ -256,9 +308,14 @@ func (t *Shipment) GetPaginatedConsol(ctx *context.Context, req *dtos.ConsolGetR
 
 func (t *Shipment) GetCountsByStatusPaginatedConsol(ctx *context.Context, req *dtos.ConsolGetReq) (map[string]int64, error) {
 
+	table, err := t.getTable(ctx)
+	if err != nil {
+		return nil, err
+	}
+
 	counts := make(map[string]int64)
 
-	query := ctx.DB.Debug().WithContext(ctx.Request.Context()).Table(t.getTable(ctx)+" consol").
+	query := ctx.DB.Debug().WithContext(ctx.Request.Context()).Table(table+" consol").
 		Select(`
 			COUNT(CASE WHEN consol.status NOT ILIKE '%Booking completed%' AND consol.is_deleted = false THEN 1 END) AS active_count,
 			COUNT(CASE WHEN consol.is_deleted = true THEN 1 END) AS dropped_count,
@@ -282,7 +339,7 @@ func (t *Shipment) GetCountsByStatusPaginatedConsol(ctx *context.Context, req *d
 		CanShift       int64
 		CompletedCount int64
 	}
-	err := query.Scan(&result).Error
+	err = query.Scan(&result).Error
 	if err != nil {
 		return nil, err
 	}
@@ -319,17 +376,27 @@ func (t *Shipment) GetCountsByStatusPaginatedConsol(ctx *context.Context, req *d
 }
 
 func (t *Shipment) UpsertConsolShipment(ctx *context.Context, m *models.ConsolShipment) error {
-	return ctx.DB.WithContext(ctx.Request.Context()).Table(t.getConsolShipmentsTable(ctx)).Save(m).Error
+	consolShipmentsTable, err := t.getConsolShipmentsTable(ctx)
+	if err != nil {
+		return err
+	}
+
+	return ctx.DB.WithContext(ctx.Request.Context()).Table(consolShipmentsTable).Save(m).Error
 }
 
 func (t *Shipment) GetShipmentCountWithConsolId(ctx *context.Context, id string) (int, error) {
+	table, err := t.getTable(ctx)
+	if err != nil {
+		return 0, err
+	}
+
 	var shipments_count int64
 
-	q := ctx.DB.Debug().WithContext(ctx.Request.Context()).Table(t.getTable(ctx)+" shipment").Where("shipment.consol_id =?", id).
+	q := ctx.DB.Debug().WithContext(ctx.Request.Context()).Table(table+" shipment").Where("shipment.consol_id =?", id).
 		Select("shipment.id").Where("shipment.is_deleted::BOOLEAN = false")
 
 	q.Count(&shipments_count)
-	err := q.Begin().Error
+	err = q.Begin().Error
 	if err != nil {
 		return int(shipments_count), err
 	}
@@ -337,9 +404,14 @@ func (t *Shipment) GetShipmentCountWithConsolId(ctx *context.Context, id string)
 	return int(shipments_count), nil
 }
 func (t *Shipment) GetConsol(ctx *context.Context, id string) (*models.ConsolShipment, error) {
+	consolShipmentsTable, err := t.getConsolShipmentsTable(ctx)
+	if err != nil {
+		return nil, err
+	}
+
 	var result *models.ConsolShipment
-	q := ctx.DB.Debug().WithContext(ctx.Request.Context()).Table(t.getConsolShipmentsTable(ctx)).Where("id =?", id)
-	err := q.Scan(&result).Error
+	q := ctx.DB.Debug().WithContext(ctx.Request.Context()).Table(consolShipmentsTable).Where("id =?", id)
+	err = q.Scan(&result).Error
 	if err != nil && err != gorm.ErrRecordNotFound {
 		ctx.Log.Error("error while getting Consol")
 	}
@@ -361,8 +433,13 @@ func (t *Shipment) DeleteConsol(ctx *context.Context, id string, deleteReason st
 }
 
 func (t *Shipment) GetConsolMatchedShipments(ctx *context.Context, regionId string, pol string, pod string, isPolMatchingOnly bool) ([]*models.ConsolMatchedShipment, error) {
+	table, err := t.getTable(ctx)
+	if err != nil {
+		return nil, err
+	}
+
 	var result []*models.ConsolMatchedShipment
-	q := ctx.DB.Debug().WithContext(ctx.Request.Context()).Table(t.getTable(ctx)+" s").Select("s.id, s.code ,s.pol, s.pod , s.cargo_ready_date,s.created_at,s.created_by,s.region_id, s.origin_region_id, s.dest_region_id,s.occupied_cbm,s.company_id, q.etd").Joins("join quotes q on q.id =s.quote_id ").Where(" s.type = 'LCL' and s.is_deleted::BOOLEAN =false").Where("s.pol =? ", pol)
+	q := ctx.DB.Debug().WithContext(ctx.Request.Context()).Table(table+" s").Select("s.id, s.code ,s.pol, s.pod , s.cargo_ready_date,s.created_at,s.created_by,s.region_id, s.origin_region_id, s.dest_region_id,s.occupied_cbm,s.company_id, q.etd").Joins("join quotes q on q.id =s.quote_id ").Where(" s.type = 'LCL' and s.is_deleted::BOOLEAN =false").Where("s.pol =? ", pol)
 	if regionId != "" {
 		q.Where("s.region_id = ? ", regionId)
 	}
@@ -373,7 +450,7 @@ func (t *Shipment) GetConsolMatchedShipments(ctx *context.Context, regionId stri
 	}
 	q.Order("s.created_at ASC")
 
-	err := q.Find(&result).Error
+	err = q.Find(&result).Error
 	if err != nil && err != gorm.ErrRecordNotFound {
 		ctx.Log.Error("error while getting Consol")
 	}
@@ -381,12 +458,22 @@ func (t *Shipment) GetConsolMatchedShipments(ctx *context.Context, regionId stri
 }
 
 func (t *Shipment) UpsertConsol(ctx *context.Context, consol *models.ConsolShipment) error {
-	return ctx.DB.WithContext(ctx.Request.Context()).Table(t.getConsolShipmentsTable(ctx)).Save(consol).Error
+	consolShipmentsTable, err := t.getConsolShipmentsTable(ctx)
+	if err != nil {
+		return err
+	}
+
+	return ctx.DB.WithContext(ctx.Request.Context()).Table(consolShipmentsTable).Save(consol).Error
 }
 
 func (t *Shipment) GetShipmentsForCompany(ctx *context.Context, cid string) ([]*models.Shipment, error) {
+	table, err := t.getTable(ctx)
+	if err != nil {
+		return nil, err
+	}
+
 	var result []*models.Shipment
-	err := ctx.DB.Table(t.getTable(ctx)).Where("is_deleted = false AND company_id = ?", cid).Find(&result).Error
+	err = ctx.DB.Table(table).Where("is_deleted = false AND company_id = ?", cid).Find(&result).Error
 	if err != nil {
 		ctx.Log.Error("Unable to get shipments.", zap.Error(err))
 		return nil, err
@@ -396,13 +483,23 @@ func (t *Shipment) GetShipmentsForCompany(ctx *context.Context, cid string) ([]*
 }
 
 func (t *Shipment) UpsertConsolWithTx(ctx *context.Context, tx *gorm.DB, m ...*models.ConsolShipment) error {
-	return tx.Table(t.getConsolShipmentsTable(ctx)).Save(m).Error
+	consolShipmentsTable, err := t.getConsolShipmentsTable(ctx)
+	if err != nil {
+		return err
+	}
+
+	return tx.Table(consolShipmentsTable).Save(m).Error
 }
 
 func (t *Shipment) GetConsolByQuoteId(ctx *context.Context, quoteId string) (*models.ConsolShipment, error) {
+	consolShipmentsTable, err := t.getConsolShipmentsTable(ctx)
+	if err != nil {
+		return nil, err
+	}
+
 	var result *models.ConsolShipment
-	q := ctx.DB.Debug().WithContext(ctx.Request.Context()).Table(t.getConsolShipmentsTable(ctx)).Where("quote_id =?", quoteId)
-	err := q.Scan(&result).Error
+	q := ctx.DB.Debug().WithContext(ctx.Request.Context()).Table(consolShipmentsTable).Where("quote_id =?", quoteId)
+	err = q.Scan(&result).Error
 	if err != nil && err != gorm.ErrRecordNotFound {
 		ctx.Log.Error("error while getting Consol")
 	}
@@ -410,8 +507,13 @@ func (t *Shipment) GetConsolByQuoteId(ctx *context.Context, quoteId string) (*mo
 }
 
 func (t *Shipment) GetShipmentsByConsolId(ctx *context.Context, consolId string) ([]*models.Shipment, error) {
+	table, err := t.getTable(ctx)
+	if err != nil {
+		return nil, err
+	}
+
 	var result []*models.Shipment
-	err := ctx.DB.Table(t.getTable(ctx)).Where("consol_id = ?", consolId).Find(&result).Error
+	err = ctx.DB.Table(table).Where("consol_id = ?", consolId).Find(&result).Error
 	if err != nil {
 		ctx.Log.Error("Unable to get shipments.", zap.Error(err))
 		return nil, err
@@ -421,8 +523,13 @@ func (t *Shipment) GetShipmentsByConsolId(ctx *context.Context, consolId string)
 }
 
 func (t *Shipment) GetShipmentsSince(ctx *context.Context, cid string, selectFields []string, shipmentTypes []string, createdSince *time.Time, excludedStatus []string, MasterBillNoCheck bool) ([]*models.Shipment, error) {
+	table, err := t.getTable(ctx)
+	if err != nil {
+		return nil, err
+	}
+
 	var result []*models.Shipment
-	tx := ctx.DB.Table(t.getTable(ctx))
+	tx := ctx.DB.Table(table)
 
 	if selectFields != nil {
 		tx.Select(selectFields)
@@ -450,7 +557,7 @@ func (t *Shipment) GetShipmentsSince(ctx *context.Context, cid string, selectFie
 		tx.Where("created_at >= ?", createdSince)
 	}
 
-	err := tx.Debug().Find(&result).Error
+	err = tx.Debug().Find(&result).Error
 	if err != nil {
 		ctx.Log.Error("Unable to get shipments.", zap.Error(err))
 		return nil, err
@@ -462,15 +569,20 @@ func (t *Shipment) GetShipmentsSince(ctx *context.Context, cid string, selectFie
 const DashboardListCount = 5
 
 func (t *Shipment) GetCompanyDasboardBookingsCount(ctx *context.Context, cids []string) (int64, error) {
+	table, err := t.getTable(ctx)
+	if err != nil {
+		return 0, err
+	}
+
 	var bookingCount int64
 
-	query := ctx.DB.Table(t.getTable(ctx)).
+	query := ctx.DB.Table(table).
 		Where("is_deleted = false").
 		Not("status IN (?)", "Booking completed")
 
 	query = query.Where("company_id IN (?)", cids)
 
-	err := query.Debug().Count(&bookingCount).Error
+	err = query.Debug().Count(&bookingCount).Error
 	if err != nil {
 		return 0, err
 	}
@@ -479,16 +591,21 @@ func (t *Shipment) GetCompanyDasboardBookingsCount(ctx *context.Context, cids []
 }
 
 func (t *Shipment) CustomerPaginationAciveBookingsCount(ctx *context.Context, cids []string) ([]*models.ActiveShipmentCount, error) {
+	table, err := t.getTable(ctx)
+	if err != nil {
+		return nil, err
+	}
+
 	var bookingCount []*models.ActiveShipmentCount
 
-	query := ctx.DB.Debug().Table(t.getTable(ctx)).
+	query := ctx.DB.Debug().Table(table).
 		Where("is_deleted = ?", false).
 		Not("status = ?", "Booking completed").
 		Where("company_id IN (?)", cids).
 		Select("count(distinct(id)) as count, company_id").
 		Group("company_id")
 
-	err := query.Debug().Find(&bookingCount).Error
+	err = query.Debug().Find(&bookingCount).Error
 	if err != nil {
 		return nil, err
 	}
@@ -497,8 +614,13 @@ func (t *Shipment) CustomerPaginationAciveBookingsCount(ctx *context.Context, ci
 }
 
 func (t *Shipment) GetDSRShipments(ctx *context.Context, req *dtos.DSRShipmentParamters) ([]models.ShipmentQuoteDetailsForDSR, error) {
+	table, err := t.getTable(ctx)
+	if err != nil {
+		return nil, err
+	}
+
 	var shipments []models.ShipmentQuoteDetailsForDSR
-	query := ctx.DB.Debug().Table(t.getTable(ctx)+" s").Select(`s.id, s.code, s.house_bill_nos, s.cargo_ready_date, 
+	query := ctx.DB.Debug().Table(table+" s").Select(`s.id, s.code, s.house_bill_nos, s.cargo_ready_date, 
 	s.pol_name, s.pod_name, s.status, s.incoterm, s.occupied_cbm, s.occupied_weight, s.occupied_volume_weight, 
 	s.created_at, s.teus, q.etd, q.eta, q.free_days, q.transit_days,q.liner,q.vessel_name,q.voyage_no,s.type,COALESCE(SUM(CASE WHEN s.type IN ('LCL', 'AIR') THEN sp.count ELSE 0 END), 0) AS count,
 	MAX(CASE WHEN s.is_door_pickup = TRUE AND sl.type = 'origin' THEN sl.address ELSE NULL END) AS door_pickup, 
@@ -509,7 +631,7 @@ func (t *Shipment) GetDSRShipments(ctx *context.Context, req *dtos.DSRShipmentPa
 		Where("s.company_id = ? and s.type in ? and s.is_deleted = false ", req.CompanyId, req.Types).
 		Group("s.id, s.code, s.house_bill_nos, s.cargo_ready_date, s.pol_name, s.pod_name, s.status, s.incoterm, s.occupied_cbm, s.occupied_weight, s.occupied_volume_weight, s.created_at, s.teus, q.etd, q.eta, q.free_days, q.transit_days,q.liner,q.vessel_name,q.voyage_no, s.type").
 		Order("s.created_at DESC")
-	err := query.Scan(&shipments).Error
+	err = query.Scan(&shipments).Error
 	if err != nil {
 		ctx.Log.Error("Error fetching shipment details for DSR", zap.Error(err))
 		return nil, err
@@ -519,8 +641,13 @@ func (t *Shipment) GetDSRShipments(ctx *context.Context, req *dtos.DSRShipmentPa
 }
 
 func (t *Shipment) GetAllCompletedShipments(ctx *context.Context, cid string) ([]*dtos.Shipment, error) {
+	table, err := t.getTable(ctx)
+	if err != nil {
+		return nil, err
+	}
+
 	var shipments []*dtos.Shipment
-	err := ctx.DB.Debug().Table(t.getTable(ctx)).Select("id,type").
+	err = ctx.DB.Debug().Table(table).Select("id,type").
 		Where("is_deleted = false").
 		Where("status = ?", constants.ShipmentCompleted).
 		Where("company_id = ?", cid).
@@ -534,8 +661,13 @@ func (t *Shipment) GetAllCompletedShipments(ctx *context.Context, cid string) ([
 }
 
 func (t *Shipment) GetAllUnlockedShipments(ctx *context.Context) ([]*models.Shipment, error) {
+	table, err := t.getTable(ctx)
+	if err != nil {
+		return nil, err
+	}
+
 	var result []*models.Shipment
-	err := ctx.DB.WithContext(ctx.Request.Context()).Table(t.getTable(ctx)).
+	err = ctx.DB.WithContext(ctx.Request.Context()).Table(table).
 		Where("is_deleted::BOOLEAN = false").
 		Where("is_shipment_locked::BOOLEAN = false").
 		Order("created_at DESC").
@@ -551,13 +683,22 @@ func (t *Shipment) GetAllUnlockedShipments(ctx *context.Context) ([]*models.Ship
 
 func (t *Shipment) UpdateShipmentsAndRfqs(ctx *context.Context, req *dtos.CustomerNameChangeReq) error {
 
-	err := ctx.DB.Debug().Exec(fmt.Sprintf(`UPDATE %s set company_name = ? WHERE company_id = ? `, t.getTable(ctx)), req.CompanyName, req.CompanyId).Error
+	table, err := t.getTable(ctx)
+	if err != nil {
+		return err
+	}
+	rfqsTable, err := t.getRfqsTable(ctx)
+	if err != nil {
+		return err
+	}
+
+	err = ctx.DB.Debug().Exec(fmt.Sprintf(`UPDATE %s set company_name = ? WHERE company_id = ? `, table), req.CompanyName, req.CompanyId).Error
 	if err != nil {
 		ctx.Log.Error("Unable to update shipments", zap.Error(err))
 		return err
 	}
 
-	err = ctx.DB.Debug().Exec(fmt.Sprintf(`UPDATE %s set company_name = ? WHERE company_id = ? `, t.getRfqsTable(ctx)), req.CompanyName, req.CompanyId).Error
+	err = ctx.DB.Debug().Exec(fmt.Sprintf(`UPDATE %s set company_name = ? WHERE company_id = ? `, rfqsTable), req.CompanyName, req.CompanyId).Error
 	if err != nil {
 		ctx.Log.Error("Unable to update rfqs", zap.Error(err))
 		return err
@@ -567,5 +708,19 @@ func (t *Shipment) UpdateShipmentsAndRfqs(ctx *context.Context, req *dtos.Custom
 }
 
 func (t *Shipment) UpdateShipmentLock(ctx *context.Context, id string, isLocked bool) error {
-	return ctx.DB.WithContext(ctx.Request.Context()).Table(t.getTable(ctx)).Debug().Where("id = ?", id).Update("is_shipment_locked", isLocked).Error
+	table, err := t.getTable(ctx)
+	if err != nil {
+		return err
+	}
+
+	return ctx.DB.WithContext(ctx.Request.Context()).Table(table).Debug().Where("id = ?", id).Update("is_shipment_locked", isLocked).Error
+}
+
+func (t *Shipment) UpdateShipmentLockWithTx(ctx *context.Context, tx *gorm.DB, id string, isLocked bool) error {
+	table, err := t.getTable(ctx)
+	if err != nil {
+		return err
+	}
+
+	return tx.Table(table).Where("id = ?", id).Update("is_shipment_locked", isLocked).Error
 }
@@ -2,6 +2,7 @@ package shipmentlock
 
 import (
 	"bitbucket.org/radarventures/forwarder-adapters/utils/context"
+	"bitbucket.org/radarventures/forwarder-shipments/daos/tenant"
 	"bitbucket.org/radarventures/forwarder-shipments/database/models"
 	"github.com/google/uuid"
 	"go.uber.org/zap"
@@ -23,21 +24,27 @@ func NewShipmentLock() IShipmentLock {
 	return &ShipmentLock{}
 }
 
-func (t *ShipmentLock) getTable(ctx *context.Context) string {
-	if ctx.TenantID == "" {
-		ctx.Log.Error("TenantID is empty")
-		return "public.shipment_lock" 
-	}
-	return ctx.TenantID + "." + "shipment_lock"
+func (t *ShipmentLock) getTable(ctx *context.Context) (string, error) {
+	return tenant.Table(ctx, "shipment_lock")
 }
 
 func (t *ShipmentLock) Upsert(ctx *context.Context, m ...*models.ShipmentLock) error {
-	return ctx.DB.WithContext(ctx.Request.Context()).Table(t.getTable(ctx)).Save(m).Error
+	table, err := t.getTable(ctx)
+	if err != nil {
+		return err
+	}
+
+	return ctx.DB.WithContext(ctx.Request.Context()).Table(table).Save(m).Error
 }
 
 func (t *ShipmentLock) Get(ctx *context.Context, id string) (*models.ShipmentLock, error) {
+	table, err := t.getTable(ctx)
+	if err != nil {
+		return nil, err
+	}
+
 	var result models.ShipmentLock
-	err := ctx.DB.WithContext(ctx.Request.Context()).Table(t.getTable(ctx)).First(&result, "id = ?", id).Error
+	err = ctx.DB.WithContext(ctx.Request.Context()).Table(table).First(&result, "id = ?", id).Error
 	if err != nil {
 		ctx.Log.Error("Unable to get ShipmentLock.", zap.Error(err))
 		return nil, err
@@ -47,9 +54,14 @@ func (t *ShipmentLock) Get(ctx *context.Context, id string) (*models.ShipmentLoc
 }
 
 func (t *ShipmentLock) GetAll(ctx *context.Context) ([]*models.ShipmentLock, error) {
+	table, err := t.getTable(ctx)
+	if err != nil {
+		return nil, err
+	}
+
 	var result []*models.ShipmentLock
 
-	err := ctx.DB.WithContext(ctx.Request.Context()).Table(t.getTable(ctx)).
+	err = ctx.DB.WithContext(ctx.Request.Context()).Table(table).
 		Where("is_locked = ?", false).
 		Find(&result).Error
 
@@ -62,8 +74,13 @@ func (t *ShipmentLock) GetAll(ctx *context.Context) ([]*models.ShipmentLock, err
 }
 
 func (t *ShipmentLock) GetByShipment(ctx *context.Context, shipmentId uuid.UUID) ([]*models.ShipmentLock, error) {
+	table, err := t.getTable(ctx)
+	if err != nil {
+		return nil, err
+	}
+
 	var result []*models.ShipmentLock
-	err := ctx.DB.Table(t.getTable(ctx)).Where("shipment_id = ?", shipmentId).Find(&result).Error
+	err = ctx.DB.Table(table).Where("shipment_id = ?", shipmentId).Find(&result).Error
 	if err != nil {
 		ctx.Log.Error("Unable to get ShipmentLocks.", zap.Error(err))
 		return nil, err
@@ -73,8 +90,13 @@ func (t *ShipmentLock) GetByShipment(ctx *context.Context, shipmentId uuid.UUID)
 }
 
 func (t *ShipmentLock) UpdateShipmentLock(ctx *context.Context, IsBookingLocked bool, Id uuid.UUID) error {
-	err := ctx.DB.Debug().
-		Table(t.getTable(ctx)).
+	table, err := t.getTable(ctx)
+	if err != nil {
+		return err
+	}
+
+	err = ctx.DB.Debug().
+		Table(table).
 		Where("id = ?", Id).
 		Update("is_locked", IsBookingLocked).Error
 
@@ -11,6 +11,10 @@ import (
 
 	dtos "bitbucket.org/radarventures/forwarder-adapters/dtos/shipments"
 	"bitbucket.org/radarventures/forwarder-adapters/utils/context"
+	"bitbucket.org/radarventures/forwarder-shipments/constants"
+	"bitbucket.org/radarventures/forwarder-shipments/daos/documentcounter"
+	"bitbucket.org/radarventures/forwarder-shipments/daos/tenant"
+	"bitbucket.org/radarventures/forwarder-shipments/database/models"
 	"github.com/google/uuid"
 	"go.uber.org/zap"
 	"gorm.io/gorm"
@@ -19,9 +23,10 @@ import (
 
 type ISIS interface {
 	Get(ctx *context.Context, id string) (*dtos.SISInfoModelAir, error)
-	GetForGenerateJobNumber(ctx *context.Context, code string) ([]string, error)
-	GetForGenerateSISAirJobNumber(ctx *context.Context, code string) ([]string, error)
+	NextJobNumberWithTx(ctx *context.Context, tx *gorm.DB, code string) (int64, error)
+	NextSISAirJobNumberWithTx(ctx *context.Context, tx *gorm.DB, code string) (int64, error)
 	UpsertAirSisInfo(ctx *context.Context, obj *dtos.SISInfoModelAir, fname string, isProcessed bool, data []byte) error
+	UpsertAirSisInfoWithTx(ctx *context.Context, tx *gorm.DB, obj *dtos.SISInfoModelAir, fname string, isProcessed bool, data []byte) error
 	UpdateSISData(ctx *context.Context, model *dtos.SISInfoModelAir, id string) error
 	GetSISInfo(ctx *context.Context, id string) (*dtos.SISInfoModel, error)
 	UpdateSisInfoStatus(ctx *context.Context, id string, filename string, data []byte) error
@@ -39,22 +44,24 @@ func NewSIS() ISIS {
 	return &SIS{}
 }
 
-func (t *SIS) getTable(ctx *context.Context) string {
-	return ctx.TenantID + "." + "sis_info_air"
+func (t *SIS) getTable(ctx *context.Context) (string, error) {
+	return tenant.Table(ctx, "sis_info_air")
 }
 
-func (t *SIS) getSISTable(ctx *context.Context) string {
-	if ctx.TenantID == "" {
-		ctx.TenantID = "public"
-	}
-	return ctx.TenantID + "." + "sis_info"
+func (t *SIS) getSISTable(ctx *context.Context) (string, error) {
+	return tenant.Table(ctx, "sis_info")
 }
 
 func (t *SIS) Get(ctx *context.Context, id string) (*dtos.SISInfoModelAir, error) {
+	table, err := t.getTable(ctx)
+	if err != nil {
+		return nil, err
+	}
+
 	var sisData string
 
-	q := fmt.Sprintf(`SELECT sis_data FROM %s WHERE shipment_id = ?`, t.getTable(ctx))
-	err := ctx.DB.WithContext(ctx.Request.Context()).Raw(q, id).Scan(&sisData).Error
+	q := fmt.Sprintf(`SELECT sis_data FROM %s WHERE shipment_id = ?`, table)
+	err = ctx.DB.WithContext(ctx.Request.Context()).Raw(q, id).Scan(&sisData).Error
 	if err != nil {
 		if errors.Is(err, gorm.ErrRecordNotFound) {
 			ctx.Log.Warn("No data found for the given shipment ID", zap.String("shipment_id", id))
@@ -79,34 +86,50 @@ func (t *SIS) Get(ctx *context.Context, id string) (*dtos.SISInfoModelAir, error
 	return &model, nil
 }
 
-func (t *SIS) GetForGenerateJobNumber(ctx *context.Context, code string) ([]string, error) {
-	var jobNums []string
-	param := "%" + code + "%"
+// NextJobNumberWithTx issues the next SIS job number of the code from the tenant's SIS job
+// counter. The counter starts after the highest number the code's job numbers end in.
+func (t *SIS) NextJobNumberWithTx(ctx *context.Context, tx *gorm.DB, code string) (int64, error) {
+	return t.nextJobNumberWithTx(ctx, tx, constants.DocTypeSISJob, "sis_data->>'job_no'", code)
+}
 
-	err := ctx.DB.WithContext(ctx.Request.Context()).Table(t.getTable(ctx)).Select("sis_data->>'job_no'").Where("sis_data->>'job_no' LIKE ?", param).Pluck("sis_data->>'job_no'", &jobNums).Error
+// NextSISAirJobNumberWithTx issues the next air SIS job number of the code from the tenant's
+// air SIS job counter.
+func (t *SIS) NextSISAirJobNumberWithTx(ctx *context.Context, tx *gorm.DB, code string) (int64, error) {
+	return t.nextJobNumberWithTx(ctx, tx, constants.DocTypeSISAirJob, "job_number", code)
+}
 
+func (t *SIS) nextJobNumberWithTx(ctx *context.Context, tx *gorm.DB, docType string, column string, code string) (int64, error) {
+	table, err := t.getTable(ctx)
 	if err != nil {
-		ctx.Log.Error("Unable to retrieve job numbers", zap.Error(err))
-		return nil, err
+		return 0, err
 	}
 
-	return jobNums, nil
+	key := &models.DocumentCounterKey{DocType: docType, Scope: code}
+	return documentcounter.NewDocumentCounter().NextWithTx(ctx, tx, key, func(tx *gorm.DB) (int64, error) {
+		var last int64
+		err := tx.Table(table).
+			Select("COALESCE(MAX(substring("+column+" from '([0-9]+)$')::bigint), 0)").
+			Where(column+" LIKE ?", "%"+code+"%").
+			Scan(&last).Error
+		if err != nil {
+			ctx.Log.Error("Unable to retrieve job numbers", zap.Error(err))
+		}
+		return last, err
+	})
 }
 
-func (t *SIS) GetForGenerateSISAirJobNumber(ctx *context.Context, code string) ([]string, error) {
-	var jobNums []string
-	param := "%" + code + "%"
-	err := ctx.DB.WithContext(ctx.Request.Context()).Table(t.getTable(ctx)).Select("job_number").Where("job_number LIKE ?", param).Pluck("job_number", &jobNums).Error
+func (t *SIS) UpsertAirSisInfo(ctx *context.Context, obj *dtos.SISInfoModelAir, fname string, isProcessed bool, data []byte) error {
+	return t.UpsertAirSisInfoWithTx(ctx, ctx.DB.WithContext(ctx.Request.Context()), obj, fname, isProcessed, data)
+}
 
+// UpsertAirSisInfoWithTx saves the air SIS info in tx, so it is saved in the transaction its
+// job number was issued in.
+func (t *SIS) UpsertAirSisInfoWithTx(ctx *context.Context, tx *gorm.DB, obj *dtos.SISInfoModelAir, fname string, isProcessed bool, data []byte) error {
+	table, err := t.getTable(ctx)
 	if err != nil {
-		ctx.Log.Error("Unable to retrieve job numbers", zap.Error(err))
-		return nil, err
+		return err
 	}
 
-	return jobNums, nil
-}
-
-func (t *SIS) UpsertAirSisInfo(ctx *context.Context, obj *dtos.SISInfoModelAir, fname string, isProcessed bool, data []byte) error {
 	if obj == nil {
 		return errors.New("nil sis info cannot be saved")
 	}
@@ -130,8 +153,8 @@ func (t *SIS) UpsertAirSisInfo(ctx *context.Context, obj *dtos.SISInfoModelAir,
 		"updated_at":            time.Now().UTC(),
 	}
 
-	err = ctx.DB.WithContext(ctx.Request.Context()).
-		Table(t.getTable(ctx)).
+	err = tx.
+		Table(table).
 		Clauses(clause.OnConflict{
 			Columns:   []clause.Column{{Name: "shipment_id"}},
 			DoUpdates: clause.AssignmentColumns([]string{"job_number", "file_name", "service_type", "sis_data", "sis_processed", "sis_data_out_contents", "updated_at"}),
@@ -147,8 +170,13 @@ func (t *SIS) UpsertAirSisInfo(ctx *context.Context, obj *dtos.SISInfoModelAir,
 }
 
 func (t *SIS) UpdateSISData(ctx *context.Context, model *dtos.SISInfoModelAir, id string) error {
-	err := ctx.DB.WithContext(ctx.Request.Context()).
-		Table(t.getTable(ctx)).
+	table, err := t.getTable(ctx)
+	if err != nil {
+		return err
+	}
+
+	err = ctx.DB.WithContext(ctx.Request.Context()).
+		Table(table).
 		Where("booking_id = ?", id).
 		Update("sis_data", model).Error
 
@@ -161,9 +189,14 @@ func (t *SIS) UpdateSISData(ctx *context.Context, model *dtos.SISInfoModelAir, i
 }
 
 func (s *SIS) GetSISInfo(ctx *context.Context, id string) (*dtos.SISInfoModel, error) {
+	sISTable, err := s.getSISTable(ctx)
+	if err != nil {
+		return nil, err
+	}
+
 	var model dtos.SISInfoModel
-	q := fmt.Sprintf(`SELECT sis_data FROM %s WHERE shipment_id = ?`, s.getSISTable(ctx))
-	err := ctx.DB.WithContext(ctx.Request.Context()).Raw(q, id).Scan(&model).Error
+	q := fmt.Sprintf(`SELECT sis_data FROM %s WHERE shipment_id = ?`, sISTable)
+	err = ctx.DB.WithContext(ctx.Request.Context()).Raw(q, id).Scan(&model).Error
 	if err != nil {
 		ctx.Log.Error("unable to get sis info", zap.Error(err))
 		return nil, err
@@ -174,13 +207,23 @@ func (s *SIS) GetSISInfo(ctx *context.Context, id string) (*dtos.SISInfoModel, e
 
 func (s *SIS) UpdateSisInfoStatus(ctx *context.Context, id string, filename string, data []byte) error {
 
-	query := fmt.Sprintf(`update %s set status_file_name=$1,sis_status_data_out_contents=$2 where id = $3`, s.getSISTable(ctx))
-	err := ctx.DB.WithContext(ctx.Request.Context()).Raw(query, filename, string(data), id).Error
+	sISTable, err := s.getSISTable(ctx)
+	if err != nil {
+		return err
+	}
+
+	query := fmt.Sprintf(`update %s set status_file_name=$1,sis_status_data_out_contents=$2 where id = $3`, sISTable)
+	err = ctx.DB.WithContext(ctx.Request.Context()).Raw(query, filename, string(data), id).Error
 	return err
 }
 
 func (s *SIS) Upsert(ctx *context.Context, obj *dtos.SISInfo, fname string, data []byte) error {
 
+	sISTable, err := s.getSISTable(ctx)
+	if err != nil {
+		return err
+	}
+
 	if obj == nil {
 		return errors.New("nil sis info cannot be saved")
 	}
@@ -208,7 +251,7 @@ func (s *SIS) Upsert(ctx *context.Context, obj *dtos.SISInfo, fname string, data
 				incoterms = EXCLUDED.incoterms,
 				handling_agent_code = EXCLUDED.handling_agent_code,
 				released_agent_code = EXCLUDED.released_agent_code,
-				updated_at = now()`, s.getSISTable(ctx))
+				updated_at = now()`, sISTable)
 	} else {
 		q = fmt.Sprintf(`UPDATE %s SET sis_booking = $2,
 			shipment_code = $3,
@@ -229,10 +272,10 @@ func (s *SIS) Upsert(ctx *context.Context, obj *dtos.SISInfo, fname string, data
 			handling_agent_code = $18,
 			released_agent_code = $19,
 			updated_at = now()
-			WHERE id = $1`, s.getSISTable(ctx))
+			WHERE id = $1`, sISTable)
 	}
 
-	err := ctx.DB.WithContext(ctx.Request.Context()).Debug().Exec(q,
+	err = ctx.DB.WithContext(ctx.Request.Context()).Debug().Exec(q,
 		obj.Id,
 		obj.SisBooking,
 		obj.ShipmentCode,
@@ -263,6 +306,11 @@ func (s *SIS) Upsert(ctx *context.Context, obj *dtos.SISInfo, fname string, data
 }
 
 func (s *SIS) GetSISInfoReq(ctx *context.Context, req *dtos.GetSISInfoReq) (int64, []*dtos.SISInfo, error) {
+	sISTable, err := s.getSISTable(ctx)
+	if err != nil {
+		return 0, nil, err
+	}
+
 	var sisInfos []*dtos.SISInfo
 	var totalCount int64
 
@@ -271,11 +319,11 @@ func (s *SIS) GetSISInfoReq(ctx *context.Context, req *dtos.GetSISInfoReq) (int6
 	offset := (page - 1) * pageSize
 
 	query := ctx.DB.WithContext(ctx.Request.Context()).Debug().
-		Table(s.getSISTable(ctx)).
+		Table(sISTable).
 		Where("is_booked = false").
 		Where(s.sisFilters(req))
 
-	err := query.Count(&totalCount).Error
+	err = query.Count(&totalCount).Error
 	if err != nil {
 		ctx.Log.Error("Unable to count SIS objects", zap.Error(err))
 		return 0, nil, err
@@ -314,9 +362,14 @@ func (s *SIS) sisFilters(req *dtos.GetSISInfoReq) string {
 
 func (s *SIS) GetContent(ctx *context.Context, id string) (string, error) {
 
+	table, err := s.getTable(ctx)
+	if err != nil {
+		return "", err
+	}
+
 	var result string
 
-	err := ctx.DB.Debug().WithContext(ctx.Request.Context()).Table(s.getTable(ctx)).Select("contents").Where("id = ?", id).Scan(&result).Error
+	err = ctx.DB.Debug().WithContext(ctx.Request.Context()).Table(table).Select("contents").Where("id = ?", id).Scan(&result).Error
 	if err != nil {
 		ctx.Log.Error("unable to get content of sis", zap.Error(err))
 		return "", err
@@ -327,9 +380,14 @@ func (s *SIS) GetContent(ctx *context.Context, id string) (string, error) {
 
 func (s *SIS) GetSISInfoRequest(ctx *context.Context, id string) (*dtos.SISInfo, error) {
 
+	sISTable, err := s.getSISTable(ctx)
+	if err != nil {
+		return nil, err
+	}
+
 	var model dtos.SISInfo
 
-	err := ctx.DB.Debug().WithContext(ctx.Request.Context()).Table(s.getSISTable(ctx)).Where("id =?", id).Scan(&model).Error
+	err = ctx.DB.Debug().WithContext(ctx.Request.Context()).Table(sISTable).Where("id =?", id).Scan(&model).Error
 	if err != nil {
 		ctx.Log.Error("unable to get sis info", zap.Error(err))
 		return nil, err
@@ -340,6 +398,11 @@ func (s *SIS) GetSISInfoRequest(ctx *context.Context, id string) (*dtos.SISInfo,
 
 func (s *SIS) SaveSISInfo(ctx *context.Context, model *dtos.SISInfoModel, isISF, isSIS bool, data []byte) error {
 
+	sISTable, err := s.getSISTable(ctx)
+	if err != nil {
+		return err
+	}
+
 	var SOIds []string
 	for _, v := range model.Info {
 		SOIds = append(SOIds, fmt.Sprintf("'%s'", v.ShipmentOrder))
@@ -347,7 +410,7 @@ func (s *SIS) SaveSISInfo(ctx *context.Context, model *dtos.SISInfoModel, isISF,
 
 	subQuery := fmt.Sprintf("where sis_booking in (%s)", strings.Join(SOIds, ","))
 
-	err := ctx.DB.Debug().WithContext(ctx.Request.Context()).Table(s.getSISTable(ctx)).Exec(`update sis_info set sis_data = $1,isf_processed=$2,sis_processed=$3, sis_data_out_contents = $4 `+subQuery, model, isISF, isSIS, string(data)).Error
+	err = ctx.DB.Debug().WithContext(ctx.Request.Context()).Table(sISTable).Exec(`update sis_info set sis_data = $1,isf_processed=$2,sis_processed=$3, sis_data_out_contents = $4 `+subQuery, model, isISF, isSIS, string(data)).Error
 	if err != nil {
 		ctx.Log.Error("Unable to save sis info", zap.Error(err))
 		return err
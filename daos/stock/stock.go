@@ -2,12 +2,17 @@ package stock
 
 import (
 	"strings"
+	"time"
 
 	"bitbucket.org/radarventures/forwarder-adapters/utils/context"
 	"bitbucket.org/radarventures/forwarder-shipments/config/globals"
+	"bitbucket.org/radarventures/forwarder-shipments/constants"
+	"bitbucket.org/radarventures/forwarder-shipments/daos/tenant"
 	"bitbucket.org/radarventures/forwarder-shipments/database/models"
 	"github.com/google/uuid"
 	"go.uber.org/zap"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
 )
 
 type IStock interface {
@@ -24,6 +29,18 @@ type IStock interface {
 	GetStockCountByStatusWithAirlineAndPort(ctx *context.Context, statuses []string, q string, regionId string, Pg int64, ids []string) (map[string]map[string]int32, error)
 	GetStockCountsPaginationByStatus(ctx *context.Context, statuses []string, q string, regionId string, ids []string) (int, error)
 	GetStockDetailsByNumberId(ctx *context.Context, stock_number_id uuid.UUID) (*models.StockDetails, error)
+	LockShipmentWithTx(ctx *context.Context, tx *gorm.DB, shipmentId uuid.UUID) error
+	ReserveNextWithTx(ctx *context.Context, tx *gorm.DB, airline_id string, port_id string, region_id string, shipmentId uuid.UUID, reservedUntil time.Time) (*models.StockReservation, error)
+	GetReservationWithTx(ctx *context.Context, tx *gorm.DB, shipmentId uuid.UUID, stock_number string) (*models.StockReservation, error)
+	GetReservationByNumber(ctx *context.Context, stock_number string) (*models.StockReservation, error)
+	GetExpiredReservations(ctx *context.Context, at time.Time) ([]*models.StockReservation, error)
+	UpdateStatusWithTx(ctx *context.Context, tx *gorm.DB, id uuid.UUID, fromStatus string, toStatus string, shipmentId *uuid.UUID, reservedUntil *time.Time) (bool, error)
+	CreateHistoryWithTx(ctx *context.Context, tx *gorm.DB, m *models.StockStatusHistory) error
+	GetHistory(ctx *context.Context, stockId uuid.UUID) ([]*models.StockStatusHistory, error)
+	GetCountsByStatus(ctx *context.Context, status string, regionId string) ([]*models.StockConsumption, error)
+	GetUsedCountsSince(ctx *context.Context, regionId string, since time.Time) ([]*models.StockConsumption, error)
+	GetExistingNumbersWithTx(ctx *context.Context, tx *gorm.DB, digits []string) ([]string, error)
+	CreateNumbersWithTx(ctx *context.Context, tx *gorm.DB, m []*models.StockNumber) error
 }
 
 type Stock struct {
@@ -33,18 +50,27 @@ func NewStock() IStock {
 	return &Stock{}
 }
 
-func (t *Stock) getTable(ctx *context.Context) string {
-	ctx.TenantID = "public"
-	return ctx.TenantID + "." + "air_stocks"
+func (t *Stock) getTable(ctx *context.Context) (string, error) {
+	return tenant.Table(ctx, "air_stocks")
 }
 
 func (t *Stock) Upsert(ctx *context.Context, m ...*models.StockDetails) error {
-	return ctx.DB.WithContext(ctx.Request.Context()).Table(t.getTable(ctx)).Save(m).Error
+	table, err := t.getTable(ctx)
+	if err != nil {
+		return err
+	}
+
+	return ctx.DB.WithContext(ctx.Request.Context()).Table(table).Save(m).Error
 }
 
 func (t *Stock) Get(ctx *context.Context, id string) (*models.Stock, error) {
+	table, err := t.getTable(ctx)
+	if err != nil {
+		return nil, err
+	}
+
 	var result models.Stock
-	err := ctx.DB.WithContext(ctx.Request.Context()).Table(t.getTable(ctx)).First(&result, "id = ?", id).Error
+	err = ctx.DB.WithContext(ctx.Request.Context()).Table(table).First(&result, "id = ?", id).Error
 	if err != nil {
 		ctx.Log.Error("Unable to get stock.", zap.Error(err))
 		return nil, err
@@ -54,8 +80,13 @@ func (t *Stock) Get(ctx *context.Context, id string) (*models.Stock, error) {
 }
 
 func (t *Stock) Delete(ctx *context.Context, id string) error {
+	table, err := t.getTable(ctx)
+	if err != nil {
+		return err
+	}
+
 	var result models.Stock
-	err := ctx.DB.WithContext(ctx.Request.Context()).Table(t.getTable(ctx)).Delete(&result, "id = ?", id).Error
+	err = ctx.DB.WithContext(ctx.Request.Context()).Table(table).Delete(&result, "id = ?", id).Error
 	if err != nil {
 		ctx.Log.Error("Unable to delete stock.", zap.Error(err))
 		return err
@@ -65,16 +96,21 @@ func (t *Stock) Delete(ctx *context.Context, id string) error {
 }
 
 func (t *Stock) GetAll(ctx *context.Context, ids []string) ([]*models.Stock, error) {
+	table, err := t.getTable(ctx)
+	if err != nil {
+		return nil, err
+	}
+
 	var result []*models.Stock
 	if len(ids) == 0 {
-		err := ctx.DB.WithContext(ctx.Request.Context()).Table(t.getTable(ctx)).Find(&result).Error
+		err := ctx.DB.WithContext(ctx.Request.Context()).Table(table).Find(&result).Error
 		if err != nil {
 			ctx.Log.Error("Unable to get stocks.", zap.Error(err))
 			return nil, err
 		}
 		return result, nil
 	}
-	err := ctx.DB.WithContext(ctx.Request.Context()).Table(t.getTable(ctx)).Where("id IN ?", ids).Find(&result).Error
+	err = ctx.DB.WithContext(ctx.Request.Context()).Table(table).Where("id IN ?", ids).Find(&result).Error
 	if err != nil {
 		ctx.Log.Error("Unable to get stocks.", zap.Error(err))
 		return nil, err
@@ -84,8 +120,13 @@ func (t *Stock) GetAll(ctx *context.Context, ids []string) ([]*models.Stock, err
 }
 
 func (t *Stock) GetStockDetailByAirlineAndPort(ctx *context.Context, airline_id string, port_id string, region_id string) (*models.StockDetails, error) {
+	table, err := t.getTable(ctx)
+	if err != nil {
+		return nil, err
+	}
+
 	var stockDetail *models.StockDetails
-	err := ctx.DB.WithContext(ctx.Request.Context()).Table(t.getTable(ctx)).Where("liner = ? AND port =? AND region_id =?", airline_id, port_id, region_id).Order("created_at desc").Limit(1).Find(&stockDetail).Error
+	err = ctx.DB.WithContext(ctx.Request.Context()).Table(table).Where("liner = ? AND port =? AND region_id =?", airline_id, port_id, region_id).Order("created_at desc").Limit(1).Find(&stockDetail).Error
 	if err != nil {
 		ctx.Log.Error("Unable to get stocks.", zap.Error(err))
 		return nil, err
@@ -94,8 +135,13 @@ func (t *Stock) GetStockDetailByAirlineAndPort(ctx *context.Context, airline_id
 }
 
 func (t *Stock) GetStockDetailsByNumber(ctx *context.Context, stock_number string) (*models.StockDetails, error) {
+	table, err := t.getTable(ctx)
+	if err != nil {
+		return nil, err
+	}
+
 	var stockDetail *models.StockDetails
-	err := ctx.DB.WithContext(ctx.Request.Context()).Table(t.getTable(ctx)).Where("stock_no = ? ", stock_number).Order("created_at desc").First(&stockDetail).Error
+	err = ctx.DB.WithContext(ctx.Request.Context()).Table(table).Where("stock_no = ? ", stock_number).Order("created_at desc").First(&stockDetail).Error
 	if err != nil {
 		ctx.Log.Error("Unable to get stocks.", zap.Error(err))
 		return nil, err
@@ -105,16 +151,20 @@ func (t *Stock) GetStockDetailsByNumber(ctx *context.Context, stock_number strin
 }
 
 func (t *Stock) GetOldestAvailabelStockDetail(ctx *context.Context, airline_id string, port_id string, status string, region_id string, Q string) ([]*models.StockDetails, error) {
+	table, err := t.getTable(ctx)
+	if err != nil {
+		return nil, err
+	}
+
 	var stockDetail []*models.StockDetails
-	var err error
 	if Q == "" {
-		err = ctx.DB.WithContext(ctx.Request.Context()).Table(t.getTable(ctx)).Where("liner = ? AND port =? AND status=? AND region_id =?", airline_id, port_id, status, region_id).Order("created_at desc").Find(&stockDetail).Error
+		err = ctx.DB.WithContext(ctx.Request.Context()).Table(table).Where("liner = ? AND port =? AND status=? AND region_id =?", airline_id, port_id, status, region_id).Order("created_at desc").Find(&stockDetail).Error
 		if err != nil {
 			ctx.Log.Error("Unable to get stocks.", zap.Error(err))
 			return nil, err
 		}
 	} else {
-		err = ctx.DB.WithContext(ctx.Request.Context()).Table(t.getTable(ctx)).Where("liner = ? AND port =? AND status=? AND region_id =? AND stock_no ILIKE ?", airline_id, port_id, status, region_id, "%"+Q+"%").Order("created_at desc").Find(&stockDetail).Error
+		err = ctx.DB.WithContext(ctx.Request.Context()).Table(table).Where("liner = ? AND port =? AND status=? AND region_id =? AND stock_no ILIKE ?", airline_id, port_id, status, region_id, "%"+Q+"%").Order("created_at desc").Find(&stockDetail).Error
 		if err != nil {
 			ctx.Log.Error("Unable to get stocks.", zap.Error(err))
 			return nil, err
@@ -125,11 +175,15 @@ func (t *Stock) GetOldestAvailabelStockDetail(ctx *context.Context, airline_id s
 }
 
 func (t *Stock) GetAllStockDetailsByAirlineAndPort(ctx *context.Context, airline_id string, port_id string, statuses []string, region_id string) ([]*models.StockDetails, error) {
+	table, err := t.getTable(ctx)
+	if err != nil {
+		return nil, err
+	}
+
 	var stockDetails []*models.StockDetails
-	var err error
 	for _, status := range statuses {
 		var stockDetail []*models.StockDetails
-		err = ctx.DB.Debug().WithContext(ctx.Request.Context()).Table(t.getTable(ctx)).Where("liner = ? AND port = ? AND status= ? AND region_id = ? ", airline_id, port_id, status, region_id).Order("created_at desc").Find(&stockDetail).Error
+		err = ctx.DB.Debug().WithContext(ctx.Request.Context()).Table(table).Where("liner = ? AND port = ? AND status= ? AND region_id = ? ", airline_id, port_id, status, region_id).Order("created_at desc").Find(&stockDetail).Error
 		if err != nil {
 			ctx.Log.Error("Unable to get stocks.", zap.Error(err))
 			return nil, err
@@ -142,18 +196,23 @@ func (t *Stock) GetAllStockDetailsByAirlineAndPort(ctx *context.Context, airline
 }
 
 func (t *Stock) GetAllStockDetailsByStatus(ctx *context.Context, statuses []string, regionId string, q string, Pg int64) ([]*models.StockDetails, error) {
+	table, err := t.getTable(ctx)
+	if err != nil {
+		return nil, err
+	}
+
 	var limit, offset int64
 	PageSize := 10
 	offset = int64(PageSize) * (Pg - 1)
 	limit = int64(PageSize)
 	var stocks []*models.StockDetails
 	if q != "" {
-		err := ctx.DB.Debug().WithContext(ctx.Request.Context()).Table(t.getTable(ctx)).Where("status in ? AND region_id =? AND stock_no ILIKE ?", statuses, regionId, q+"%").Limit(10).Scan(&stocks).Error
+		err := ctx.DB.Debug().WithContext(ctx.Request.Context()).Table(table).Where("status in ? AND region_id =? AND stock_no ILIKE ?", statuses, regionId, q+"%").Limit(10).Scan(&stocks).Error
 		if err != nil {
 			return nil, err
 		}
 	} else {
-		err := ctx.DB.Debug().WithContext(ctx.Request.Context()).Table(t.getTable(ctx)).Where("status in ? AND region_id =? ", statuses, regionId).Limit(int(limit)).Offset(int(offset)).Scan(&stocks).Error
+		err := ctx.DB.Debug().WithContext(ctx.Request.Context()).Table(table).Where("status in ? AND region_id =? ", statuses, regionId).Limit(int(limit)).Offset(int(offset)).Scan(&stocks).Error
 		if err != nil {
 			return nil, err
 		}
@@ -163,9 +222,12 @@ func (t *Stock) GetAllStockDetailsByStatus(ctx *context.Context, statuses []stri
 }
 
 func (t *Stock) GetAllStockCountsByStatus(ctx *context.Context, statuses []string, regionId string, q string) (int, error) {
+	tablename, err := t.getTable(ctx)
+	if err != nil {
+		return 0, err
+	}
+
 	stockCount := 0
-	ctx.TenantID = "public"
-	tablename := t.getTable(ctx)
 	for _, status := range statuses {
 		var count int
 
@@ -189,10 +251,13 @@ func (t *Stock) GetAllStockCountsByStatus(ctx *context.Context, statuses []strin
 }
 
 func (t *Stock) GetStockCountByStatusWithAirlineAndPort(ctx *context.Context, statuses []string, q string, regionId string, Pg int64, ids []string) (map[string]map[string]int32, error) {
+	tablename, err := t.getTable(ctx)
+	if err != nil {
+		return nil, err
+	}
+
 	stockCountMap := make(map[string]map[string]int32)
-	var err error
 	var limit, offset int64
-	tablename := t.getTable(ctx)
 	offset = int64(globals.PageSize) * (Pg - 1)
 	limit = int64(globals.PageSize)
 	for _, status := range statuses {
@@ -231,8 +296,12 @@ func (t *Stock) GetStockCountByStatusWithAirlineAndPort(ctx *context.Context, st
 }
 
 func (t *Stock) GetStockCountsPaginationByStatus(ctx *context.Context, statuses []string, q string, regionId string, ids []string) (int, error) {
+	tablename, err := t.getTable(ctx)
+	if err != nil {
+		return 0, err
+	}
+
 	stockCount := 0
-	tablename := t.getTable(ctx)
 	for _, status := range statuses {
 		var count int
 		if q != "" {
@@ -256,8 +325,13 @@ func (t *Stock) GetStockCountsPaginationByStatus(ctx *context.Context, statuses
 }
 
 func (t *Stock) GetStockDetailsByNumberId(ctx *context.Context, stock_number_id uuid.UUID) (*models.StockDetails, error) {
+	table, err := t.getTable(ctx)
+	if err != nil {
+		return nil, err
+	}
+
 	var stockDetail *models.StockDetails
-	err := ctx.DB.Debug().WithContext(ctx.Request.Context()).Table(t.getTable(ctx)).Where("id = ? ", stock_number_id).Order("created_at desc").First(&stockDetail).Error
+	err = ctx.DB.Debug().WithContext(ctx.Request.Context()).Table(table).Where("id = ? ", stock_number_id).Order("created_at desc").First(&stockDetail).Error
 	if err != nil {
 		ctx.Log.Error("Unable to get stocks.", zap.Error(err))
 		return nil, err
@@ -265,3 +339,275 @@ func (t *Stock) GetStockDetailsByNumberId(ctx *context.Context, stock_number_id
 
 	return stockDetail, nil
 }
+
+func (t *Stock) getHistoryTable(ctx *context.Context) (string, error) {
+	return tenant.Table(ctx, "air_stock_histories")
+}
+
+const reservationColumns = "id, stock_no, liner, port, region_id, status, shipment_id, reserved_until"
+
+// LockShipmentWithTx serialises the reservations of a shipment until the transaction ends. A
+// shipment without a reservation has no row to lock, so two concurrent reservations would
+// otherwise both find none and each take a number.
+func (t *Stock) LockShipmentWithTx(ctx *context.Context, tx *gorm.DB, shipmentId uuid.UUID) error {
+	table, err := t.getTable(ctx)
+	if err != nil {
+		return err
+	}
+
+	err = tx.Exec("SELECT pg_advisory_xact_lock(hashtext(?))", table+":"+shipmentId.String()).Error
+	if err != nil {
+		ctx.Log.Error("Failed to acquire stock reservation lock.", zap.Error(err))
+		return err
+	}
+
+	return nil
+}
+
+// ReserveNextWithTx reserves the oldest available number of the airline, port and region for
+// the shipment. Rows locked by a concurrent allocation are skipped instead of waited on, so
+// two bookings never get the same number. Returns gorm.ErrRecordNotFound when the stock is
+// exhausted.
+func (t *Stock) ReserveNextWithTx(ctx *context.Context, tx *gorm.DB, airline_id string, port_id string, region_id string, shipmentId uuid.UUID, reservedUntil time.Time) (*models.StockReservation, error) {
+	table, err := t.getTable(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var reservation models.StockReservation
+	err = tx.Table(table).
+		Clauses(clause.Locking{Strength: "UPDATE", Options: "SKIP LOCKED"}).
+		Select(reservationColumns).
+		Where("liner = ? AND port = ? AND region_id = ? AND status = ?", airline_id, port_id, region_id, constants.StockStatusAvailable).
+		Order("created_at asc").
+		Limit(1).
+		Take(&reservation).Error
+	if err != nil {
+		ctx.Log.Error("Unable to lock available stock.", zap.Error(err))
+		return nil, err
+	}
+
+	err = tx.Table(table).Where("id = ?", reservation.Id).UpdateColumns(map[string]interface{}{
+		"status":         constants.StockStatusReserved,
+		"shipment_id":    shipmentId,
+		"reserved_until": reservedUntil,
+	}).Error
+	if err != nil {
+		ctx.Log.Error("Unable to reserve stock.", zap.Error(err))
+		return nil, err
+	}
+
+	reservation.Status = constants.StockStatusReserved
+	reservation.ShipmentId = &shipmentId
+	reservation.ReservedUntil = &reservedUntil
+
+	return &reservation, nil
+}
+
+// GetReservationWithTx locks the number reserved or used by the shipment. When stock_number
+// is empty the shipment's current reservation is returned.
+func (t *Stock) GetReservationWithTx(ctx *context.Context, tx *gorm.DB, shipmentId uuid.UUID, stock_number string) (*models.StockReservation, error) {
+	table, err := t.getTable(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var reservation models.StockReservation
+	tx = tx.Table(table).
+		Clauses(clause.Locking{Strength: "UPDATE"}).
+		Select(reservationColumns).
+		Where("shipment_id = ? AND status IN ?", shipmentId, []string{constants.StockStatusReserved, constants.StockStatusUsed})
+	if stock_number != "" {
+		tx.Where("stock_no = ?", stock_number)
+	}
+
+	err = tx.Order("reserved_until desc").Limit(1).Take(&reservation).Error
+	if err != nil {
+		return nil, err
+	}
+
+	return &reservation, nil
+}
+
+func (t *Stock) GetReservationByNumber(ctx *context.Context, stock_number string) (*models.StockReservation, error) {
+	table, err := t.getTable(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var reservation models.StockReservation
+	err = ctx.DB.WithContext(ctx.Request.Context()).Table(table).Select(reservationColumns).Where("stock_no = ?", stock_number).Order("created_at desc").Take(&reservation).Error
+	if err != nil {
+		ctx.Log.Error("Unable to get stock.", zap.Error(err))
+		return nil, err
+	}
+
+	return &reservation, nil
+}
+
+func (t *Stock) GetExpiredReservations(ctx *context.Context, at time.Time) ([]*models.StockReservation, error) {
+	table, err := t.getTable(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var reservations []*models.StockReservation
+	err = ctx.DB.WithContext(ctx.Request.Context()).Table(table).Select(reservationColumns).
+		Where("status = ? AND reserved_until < ?", constants.StockStatusReserved, at).
+		Order("reserved_until asc").
+		Find(&reservations).Error
+	if err != nil {
+		ctx.Log.Error("Unable to get expired stock reservations.", zap.Error(err))
+		return nil, err
+	}
+
+	return reservations, nil
+}
+
+// UpdateStatusWithTx moves a number from fromStatus to toStatus. It reports false without an
+// error when the number is no longer in fromStatus, e.g. because a concurrent release won.
+func (t *Stock) UpdateStatusWithTx(ctx *context.Context, tx *gorm.DB, id uuid.UUID, fromStatus string, toStatus string, shipmentId *uuid.UUID, reservedUntil *time.Time) (bool, error) {
+	table, err := t.getTable(ctx)
+	if err != nil {
+		return false, err
+	}
+
+	res := tx.Table(table).Where("id = ? AND status = ?", id, fromStatus).UpdateColumns(map[string]interface{}{
+		"status":         toStatus,
+		"shipment_id":    shipmentId,
+		"reserved_until": reservedUntil,
+	})
+	if res.Error != nil {
+		ctx.Log.Error("Unable to update stock status.", zap.Error(res.Error))
+		return false, res.Error
+	}
+
+	return res.RowsAffected > 0, nil
+}
+
+func (t *Stock) CreateHistoryWithTx(ctx *context.Context, tx *gorm.DB, m *models.StockStatusHistory) error {
+	historyTable, err := t.getHistoryTable(ctx)
+	if err != nil {
+		return err
+	}
+
+	err = tx.Table(historyTable).Create(m).Error
+	if err != nil {
+		ctx.Log.Error("Unable to create stock history.", zap.Error(err))
+		return err
+	}
+
+	return nil
+}
+
+func (t *Stock) GetHistory(ctx *context.Context, stockId uuid.UUID) ([]*models.StockStatusHistory, error) {
+	historyTable, err := t.getHistoryTable(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var history []*models.StockStatusHistory
+	err = ctx.DB.WithContext(ctx.Request.Context()).Table(historyTable).Where("stock_id = ?", stockId).Order("created_at asc").Find(&history).Error
+	if err != nil {
+		ctx.Log.Error("Unable to get stock history.", zap.Error(err))
+		return nil, err
+	}
+
+	return history, nil
+}
+
+// GetCountsByStatus counts the numbers in status per airline, port and region. An empty
+// regionId counts every region.
+func (t *Stock) GetCountsByStatus(ctx *context.Context, status string, regionId string) ([]*models.StockConsumption, error) {
+	table, err := t.getTable(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var counts []*models.StockConsumption
+	tx := ctx.DB.WithContext(ctx.Request.Context()).Table(table).
+		Select("liner, port, region_id, count(id) AS count").
+		Where("status = ?", status)
+	if regionId != "" {
+		tx.Where("region_id = ?", regionId)
+	}
+
+	err = tx.Group("liner, port, region_id").Scan(&counts).Error
+	if err != nil {
+		ctx.Log.Error("Unable to get stock counts.", zap.Error(err))
+		return nil, err
+	}
+
+	return counts, nil
+}
+
+// GetUsedCountsSince counts the numbers that moved to used since the given time per airline,
+// port and region. An empty regionId counts every region.
+func (t *Stock) GetUsedCountsSince(ctx *context.Context, regionId string, since time.Time) ([]*models.StockConsumption, error) {
+	historyTable, err := t.getHistoryTable(ctx)
+	if err != nil {
+		return nil, err
+	}
+	table, err := t.getTable(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var counts []*models.StockConsumption
+	query := `SELECT s.liner, s.port, s.region_id, count(DISTINCT h.stock_id) AS count FROM ` + historyTable + ` h `
+	query += `INNER JOIN ` + table + ` s ON s.id = h.stock_id `
+	query += `WHERE h.to_status = ? AND h.created_at >= ? `
+	args := []interface{}{constants.StockStatusUsed, since}
+	if regionId != "" {
+		query += `AND s.region_id = ? `
+		args = append(args, regionId)
+	}
+	query += `GROUP BY s.liner, s.port, s.region_id`
+
+	err = ctx.DB.WithContext(ctx.Request.Context()).Raw(query, args...).Scan(&counts).Error
+	if err != nil {
+		ctx.Log.Error("Unable to get used stock counts.", zap.Error(err))
+		return nil, err
+	}
+
+	return counts, nil
+}
+
+// GetExistingNumbersWithTx returns which of the numbers, given without separators, are already
+// in stock in any form they were written in.
+func (t *Stock) GetExistingNumbersWithTx(ctx *context.Context, tx *gorm.DB, digits []string) ([]string, error) {
+	table, err := t.getTable(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var existing []string
+	if len(digits) == 0 {
+		return existing, nil
+	}
+
+	err = tx.Table(table).
+		Where("REPLACE(REPLACE(stock_no, '-', ''), ' ', '') IN ?", digits).
+		Pluck("REPLACE(REPLACE(stock_no, '-', ''), ' ', '')", &existing).Error
+	if err != nil {
+		ctx.Log.Error("Unable to get existing stock numbers.", zap.Error(err))
+		return nil, err
+	}
+
+	return existing, nil
+}
+
+func (t *Stock) CreateNumbersWithTx(ctx *context.Context, tx *gorm.DB, m []*models.StockNumber) error {
+	table, err := t.getTable(ctx)
+	if err != nil {
+		return err
+	}
+
+	err = tx.Table(table).CreateInBatches(m, 500).Error
+	if err != nil {
+		ctx.Log.Error("Unable to create stock numbers.", zap.Error(err))
+		return err
+	}
+
+	return nil
+}
@@ -0,0 +1,83 @@
+package stockalert
+
+import (
+	"time"
+
+	"bitbucket.org/radarventures/forwarder-adapters/utils/context"
+	"bitbucket.org/radarventures/forwarder-shipments/daos/tenant"
+	"bitbucket.org/radarventures/forwarder-shipments/database/models"
+	"github.com/google/uuid"
+	"go.uber.org/zap"
+	"gorm.io/gorm/clause"
+)
+
+type IStockAlert interface {
+	Upsert(ctx *context.Context, m *models.StockAlertSetting) error
+	GetAll(ctx *context.Context, regionId string) ([]*models.StockAlertSetting, error)
+	MarkAlerted(ctx *context.Context, id uuid.UUID, at time.Time, notAlertedSince time.Time) (bool, error)
+}
+
+type StockAlert struct {
+}
+
+func NewStockAlert() IStockAlert {
+	return &StockAlert{}
+}
+
+func (t *StockAlert) getTable(ctx *context.Context) (string, error) {
+	return tenant.Table(ctx, "air_stock_alert_settings")
+}
+
+func (t *StockAlert) Upsert(ctx *context.Context, m *models.StockAlertSetting) error {
+	table, err := t.getTable(ctx)
+	if err != nil {
+		return err
+	}
+
+	return ctx.DB.WithContext(ctx.Request.Context()).Table(table).
+		Clauses(clause.OnConflict{
+			Columns:   []clause.Column{{Name: "liner"}, {Name: "port"}, {Name: "region_id"}},
+			DoUpdates: clause.AssignmentColumns([]string{"min_days_cover", "owner_emails", "updated_by", "updated_at"}),
+		}).Create(m).Error
+}
+
+// GetAll returns the settings of a region, or of every region when regionId is empty.
+func (t *StockAlert) GetAll(ctx *context.Context, regionId string) ([]*models.StockAlertSetting, error) {
+	table, err := t.getTable(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var result []*models.StockAlertSetting
+	tx := ctx.DB.WithContext(ctx.Request.Context()).Table(table)
+	if regionId != "" {
+		tx.Where("region_id = ?", regionId)
+	}
+
+	err = tx.Order("liner, port").Find(&result).Error
+	if err != nil {
+		ctx.Log.Error("Unable to get stock alert settings.", zap.Error(err))
+		return nil, err
+	}
+
+	return result, nil
+}
+
+// MarkAlerted records the alert sent for the setting, so owners are not mailed on every run.
+// It reports false when an alert was already recorded after notAlertedSince.
+func (t *StockAlert) MarkAlerted(ctx *context.Context, id uuid.UUID, at time.Time, notAlertedSince time.Time) (bool, error) {
+	table, err := t.getTable(ctx)
+	if err != nil {
+		return false, err
+	}
+
+	res := ctx.DB.WithContext(ctx.Request.Context()).Table(table).
+		Where("id = ? AND (last_alerted_at IS NULL OR last_alerted_at < ?)", id, notAlertedSince).
+		UpdateColumn("last_alerted_at", at)
+	if res.Error != nil {
+		ctx.Log.Error("Unable to mark stock alert.", zap.Error(res.Error))
+		return false, res.Error
+	}
+
+	return res.RowsAffected > 0, nil
+}
@@ -0,0 +1,205 @@
+package tenant
+
+import (
+	"fmt"
+	"strings"
+
+	"bitbucket.org/radarventures/forwarder-adapters/utils/context"
+	"go.uber.org/zap"
+)
+
+// tenantMarkerTable is the table every tenant schema holds.
+const tenantMarkerTable = "shipments"
+
+// publicMigrationsTable records the tenants whose rows were copied out of the public schema.
+const publicMigrationsTable = "public.tenant_data_migrations"
+
+type ITenant interface {
+	GetSchemas(ctx *context.Context) ([]string, error)
+	EnsureTable(ctx *context.Context, schema string, table string) error
+	CountPublicRows(ctx *context.Context, schema string, table string, filter string) (int64, error)
+	CopyPublicRows(ctx *context.Context, schema string, table string, filter string) (int64, error)
+	MarkPublicDataMigrated(ctx *context.Context, schema string) error
+}
+
+type Tenant struct {
+}
+
+func NewTenant() ITenant {
+	return &Tenant{}
+}
+
+// GetSchemas returns the schemas of all tenants, a tenant being a schema with a shipments
+// table. public holds the rows of the time before tenants had schemas of their own, it
+// counts as a tenant until its rows were copied into every other tenant.
+func (t *Tenant) GetSchemas(ctx *context.Context) ([]string, error) {
+	var schemas []string
+	err := ctx.DB.WithContext(ctx.Request.Context()).Table("information_schema.tables").
+		Where("table_name = ? AND table_type = 'BASE TABLE'", tenantMarkerTable).
+		Order("table_schema").
+		Pluck("table_schema", &schemas).Error
+	if err != nil {
+		ctx.Log.Error("unable to list tenant schemas", zap.Error(err))
+		return nil, err
+	}
+
+	result := make([]string, 0, len(schemas))
+	others := []string{}
+	hasPublic := false
+	for _, schema := range schemas {
+		if !schemaPattern.MatchString(schema) {
+			continue
+		}
+		result = append(result, schema)
+		if schema == "public" {
+			hasPublic = true
+		} else {
+			others = append(others, schema)
+		}
+	}
+
+	if !hasPublic {
+		return result, nil
+	}
+
+	migrated, err := t.publicDataMigrated(ctx, others)
+	if err != nil {
+		return nil, err
+	}
+	if !migrated {
+		return result, nil
+	}
+
+	return others, nil
+}
+
+// publicDataMigrated reports whether the public rows were copied into every one of schemas.
+func (t *Tenant) publicDataMigrated(ctx *context.Context, schemas []string) (bool, error) {
+	if len(schemas) == 0 {
+		return false, nil
+	}
+
+	var exists bool
+	err := ctx.DB.WithContext(ctx.Request.Context()).
+		Raw("SELECT to_regclass(?) IS NOT NULL", publicMigrationsTable).
+		Scan(&exists).Error
+	if err != nil {
+		ctx.Log.Error("unable to check public data migrations", zap.Error(err))
+		return false, err
+	}
+	if !exists {
+		return false, nil
+	}
+
+	var count int64
+	err = ctx.DB.WithContext(ctx.Request.Context()).Table(publicMigrationsTable).
+		Where("schema IN ?", schemas).
+		Count(&count).Error
+	if err != nil {
+		ctx.Log.Error("unable to count public data migrations", zap.Error(err))
+		return false, err
+	}
+
+	return count == int64(len(schemas)), nil
+}
+
+// MarkPublicDataMigrated records that the public rows of the tenant were copied into its
+// schema. Once every tenant is marked, GetSchemas no longer returns public.
+func (t *Tenant) MarkPublicDataMigrated(ctx *context.Context, schema string) error {
+	if !schemaPattern.MatchString(schema) {
+		return ErrTenantRequired
+	}
+
+	db := ctx.DB.WithContext(ctx.Request.Context())
+	err := db.Exec("CREATE TABLE IF NOT EXISTS " + publicMigrationsTable + " (schema text PRIMARY KEY, migrated_at timestamptz NOT NULL)").Error
+	if err != nil {
+		ctx.Log.Error("unable to create public data migrations table", zap.Error(err))
+		return err
+	}
+
+	err = db.Exec("INSERT INTO "+publicMigrationsTable+" (schema, migrated_at) VALUES (?, now()) ON CONFLICT (schema) DO UPDATE SET migrated_at = EXCLUDED.migrated_at", schema).Error
+	if err != nil {
+		ctx.Log.Error("unable to mark public data migrated", zap.String("schema", schema), zap.Error(err))
+	}
+
+	return err
+}
+
+// EnsureTable creates table in schema with the definition of public's table when the schema
+// does not have it yet.
+func (t *Tenant) EnsureTable(ctx *context.Context, schema string, table string) error {
+	if !schemaPattern.MatchString(schema) || !schemaPattern.MatchString(table) {
+		return ErrTenantRequired
+	}
+
+	err := ctx.DB.WithContext(ctx.Request.Context()).
+		Exec("CREATE TABLE IF NOT EXISTS " + schema + "." + table + " (LIKE public." + table + " INCLUDING ALL)").Error
+	if err != nil {
+		ctx.Log.Error("unable to create tenant table", zap.String("schema", schema), zap.String("table", table), zap.Error(err))
+	}
+
+	return err
+}
+
+// CountPublicRows counts the rows of public's table that match filter and are not in the
+// schema's table yet. filter refers to the public row as p.
+func (t *Tenant) CountPublicRows(ctx *context.Context, schema string, table string, filter string) (int64, error) {
+	if !schemaPattern.MatchString(schema) || !schemaPattern.MatchString(table) {
+		return 0, ErrTenantRequired
+	}
+
+	var count int64
+	err := ctx.DB.WithContext(ctx.Request.Context()).
+		Raw("SELECT count(*) FROM public." + table + " p WHERE (" + filter + ") AND " + t.notCopied(schema, table)).
+		Scan(&count).Error
+	if err != nil {
+		ctx.Log.Error("unable to count public rows", zap.String("schema", schema), zap.String("table", table), zap.Error(err))
+		return 0, err
+	}
+
+	return count, nil
+}
+
+// CopyPublicRows copies the rows of public's table that match filter into the schema's
+// table. Rows already copied are skipped, so the copy can run again. filter refers to the
+// public row as p.
+func (t *Tenant) CopyPublicRows(ctx *context.Context, schema string, table string, filter string) (int64, error) {
+	if !schemaPattern.MatchString(schema) || !schemaPattern.MatchString(table) {
+		return 0, ErrTenantRequired
+	}
+
+	var columns []string
+	err := ctx.DB.WithContext(ctx.Request.Context()).Table("information_schema.columns c").
+		Joins("JOIN information_schema.columns tc ON tc.column_name = c.column_name AND tc.table_schema = ? AND tc.table_name = ?", schema, table).
+		Where("c.table_schema = 'public' AND c.table_name = ?", table).
+		Order("c.ordinal_position").
+		Pluck("c.column_name", &columns).Error
+	if err != nil {
+		ctx.Log.Error("unable to get columns", zap.String("schema", schema), zap.String("table", table), zap.Error(err))
+		return 0, err
+	}
+
+	if len(columns) == 0 {
+		return 0, fmt.Errorf("%s.%s has no columns in common with public.%s", schema, table, table)
+	}
+
+	quoted := make([]string, len(columns))
+	for i, column := range columns {
+		quoted[i] = `"` + strings.ReplaceAll(column, `"`, `""`) + `"`
+	}
+	list := strings.Join(quoted, ", ")
+
+	res := ctx.DB.WithContext(ctx.Request.Context()).
+		Exec("INSERT INTO " + schema + "." + table + " (" + list + ") SELECT p." + strings.Join(quoted, ", p.") +
+			" FROM public." + table + " p WHERE (" + filter + ") AND " + t.notCopied(schema, table))
+	if res.Error != nil {
+		ctx.Log.Error("unable to copy public rows", zap.String("schema", schema), zap.String("table", table), zap.Error(res.Error))
+		return 0, res.Error
+	}
+
+	return res.RowsAffected, nil
+}
+
+func (t *Tenant) notCopied(schema string, table string) string {
+	return "NOT EXISTS (SELECT 1 FROM " + schema + "." + table + " x WHERE x.id = p.id)"
+}
@@ -0,0 +1,35 @@
+package tenant
+
+import (
+	"errors"
+	"regexp"
+
+	"bitbucket.org/radarventures/forwarder-adapters/utils/context"
+	"go.uber.org/zap"
+)
+
+var ErrTenantRequired = errors.New("tenant is required")
+
+var schemaPattern = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// Schema returns the schema of the request's tenant. The context is never changed, so a
+// DAO cannot move later calls of the same request to another schema.
+func Schema(ctx *context.Context) (string, error) {
+	if ctx.TenantID == "" || !schemaPattern.MatchString(ctx.TenantID) {
+		return "", ErrTenantRequired
+	}
+
+	return ctx.TenantID, nil
+}
+
+// Table qualifies table with the schema of the request's tenant. Without a tenant it
+// returns ErrTenantRequired, there is no fallback to public.
+func Table(ctx *context.Context, table string) (string, error) {
+	schema, err := Schema(ctx)
+	if err != nil {
+		ctx.Log.Error("unable to resolve tenant schema", zap.String("table", table), zap.String("tenant_id", ctx.TenantID), zap.Error(err))
+		return "", err
+	}
+
+	return schema + "." + table, nil
+}
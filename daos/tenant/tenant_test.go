@@ -0,0 +1,159 @@
+package tenant_test
+
+import (
+	"errors"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"bitbucket.org/radarventures/forwarder-adapters/utils/context"
+	"bitbucket.org/radarventures/forwarder-adapters/utils/db"
+	ulog "bitbucket.org/radarventures/forwarder-adapters/utils/log"
+	"bitbucket.org/radarventures/forwarder-shipments/config/globals"
+	"bitbucket.org/radarventures/forwarder-shipments/constants"
+	"bitbucket.org/radarventures/forwarder-shipments/daos/airwaybillinfo"
+	"bitbucket.org/radarventures/forwarder-shipments/daos/stock"
+	"bitbucket.org/radarventures/forwarder-shipments/daos/tenant"
+	"bitbucket.org/radarventures/forwarder-shipments/database/models"
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+const (
+	stockTable = `CREATE TABLE %s.air_stocks (
+		id uuid PRIMARY KEY, stock_no text, liner text, port text, region_id text, status text,
+		shipment_id uuid, reserved_until timestamptz, created_at timestamptz)`
+	awbInfoTable = `CREATE TABLE %s.awb_info (
+		id uuid PRIMARY KEY, number text, type text, issuer_port_code text,
+		created_at timestamptz, created_by uuid, updated_at timestamptz, updated_by uuid)`
+	documentCounterTable = `CREATE TABLE %s.document_counters (
+		doc_type text, scope text, period text, last_value bigint, updated_at timestamptz,
+		PRIMARY KEY (doc_type, scope, period))`
+)
+
+// The isolation test needs a Postgres database, TEST_DATABASE_URL points at one. Each tenant
+// is a schema of its own that is dropped afterwards.
+func tenantContext(t *testing.T) *context.Context {
+	t.Helper()
+
+	url := os.Getenv("TEST_DATABASE_URL")
+	if url == "" {
+		t.Skip("TEST_DATABASE_URL is not set")
+	}
+
+	db.Init(&db.Config{URL: url, MaxDBConn: 10})
+
+	schema := "tenant_" + strings.ReplaceAll(uuid.New().String(), "-", "")
+	ctx := newContext(schema)
+
+	if err := ctx.DB.Exec("CREATE SCHEMA " + schema).Error; err != nil {
+		t.Fatalf("create schema: %v", err)
+	}
+	t.Cleanup(func() {
+		ctx.DB.Exec("DROP SCHEMA " + schema + " CASCADE")
+	})
+
+	for _, ddl := range []string{stockTable, awbInfoTable, documentCounterTable} {
+		if err := ctx.DB.Exec(strings.ReplaceAll(ddl, "%s", schema)).Error; err != nil {
+			t.Fatalf("create table: %v", err)
+		}
+	}
+
+	return ctx
+}
+
+func newContext(schema string) *context.Context {
+	c := &context.Context{}
+	c.RefID = uuid.New().String()
+	c.Log = ulog.New(c.RefID, "forwarder-shipments", "error")
+	c.DB = db.New()
+	c.TenantID = schema
+	c.Context, _ = gin.CreateTestContext(httptest.NewRecorder())
+	c.Context.Request = httptest.NewRequest("GET", "/stocks", nil)
+
+	return c
+}
+
+func createStock(t *testing.T, ctx *context.Context, stockNo string) {
+	t.Helper()
+
+	err := ctx.DB.Transaction(func(tx *gorm.DB) error {
+		return stock.NewStock().CreateNumbersWithTx(ctx, tx, []*models.StockNumber{{
+			Id:        uuid.New(),
+			Liner:     "AI",
+			Port:      "BOM",
+			RegionId:  "region",
+			Status:    constants.StockStatusAvailable,
+			StockNo:   stockNo,
+			CreatedAt: time.Now().UTC(),
+		}})
+	})
+	if err != nil {
+		t.Fatalf("create stock %s: %v", stockNo, err)
+	}
+}
+
+func createHAWB(t *testing.T, ctx *context.Context, number string) {
+	t.Helper()
+
+	err := ctx.DB.Exec("INSERT INTO "+ctx.TenantID+".awb_info (id, number, type, issuer_port_code, created_at) VALUES (?, ?, ?, ?, ?)",
+		uuid.New(), number, globals.HouseAirwayBill, "BOM", time.Now().UTC()).Error
+	if err != nil {
+		t.Fatalf("create hawb %s: %v", number, err)
+	}
+}
+
+func TestTenantIsolation(t *testing.T) {
+	tenantA := tenantContext(t)
+	tenantB := tenantContext(t)
+
+	createStock(t, tenantA, "098-11111111")
+	createStock(t, tenantB, "098-22222222")
+	createHAWB(t, tenantA, "BOMA0005")
+	createHAWB(t, tenantB, "BOMB0001")
+
+	stockDb := stock.NewStock()
+
+	if _, err := stockDb.GetReservationByNumber(tenantA, "098-11111111"); err != nil {
+		t.Fatalf("tenant A cannot read its own stock: %v", err)
+	}
+	if _, err := stockDb.GetReservationByNumber(tenantA, "098-22222222"); !errors.Is(err, gorm.ErrRecordNotFound) {
+		t.Fatalf("tenant A reads tenant B's stock, err = %v", err)
+	}
+	if _, err := stockDb.GetReservationByNumber(tenantB, "098-11111111"); !errors.Is(err, gorm.ErrRecordNotFound) {
+		t.Fatalf("tenant B reads tenant A's stock, err = %v", err)
+	}
+
+	awbDb := airwaybillinfo.NewAirwayBillInfo()
+	for ctx, want := range map[*context.Context]int64{tenantA: 6, tenantB: 2} {
+		got, err := awbDb.NextHAWBNumberWithTx(ctx, ctx.DB, "BOM")
+		if err != nil || got != want {
+			t.Fatalf("next HAWB of %s = %d, %v, want %d", ctx.TenantID, got, err, want)
+		}
+	}
+}
+
+func TestTenantRequired(t *testing.T) {
+	ctx := tenantContext(t)
+
+	for _, tenantId := range []string{"", "public; DROP TABLE x", "1tenant"} {
+		c := newContext(tenantId)
+
+		if _, err := tenant.Table(c, "air_stocks"); !errors.Is(err, tenant.ErrTenantRequired) {
+			t.Fatalf("Table with tenant %q: err = %v, want ErrTenantRequired", tenantId, err)
+		}
+		if _, err := stock.NewStock().GetReservationByNumber(c, "098-11111111"); !errors.Is(err, tenant.ErrTenantRequired) {
+			t.Fatalf("stock read with tenant %q: err = %v, want ErrTenantRequired", tenantId, err)
+		}
+		if _, err := airwaybillinfo.NewAirwayBillInfo().NextHAWBNumberWithTx(c, c.DB, "BOM"); !errors.Is(err, tenant.ErrTenantRequired) {
+			t.Fatalf("AWB read with tenant %q: err = %v, want ErrTenantRequired", tenantId, err)
+		}
+	}
+
+	if table, err := tenant.Table(ctx, "air_stocks"); err != nil || table != ctx.TenantID+".air_stocks" {
+		t.Fatalf("Table = %q, %v", table, err)
+	}
+}
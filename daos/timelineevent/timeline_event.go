@@ -2,6 +2,7 @@ package timelineevent
 
 import (
 	"bitbucket.org/radarventures/forwarder-adapters/utils/context"
+	"bitbucket.org/radarventures/forwarder-shipments/daos/tenant"
 	"bitbucket.org/radarventures/forwarder-shipments/database/models"
 	"go.uber.org/zap"
 )
@@ -20,17 +21,18 @@ func NewTimelineEvent() ITimelineEvent {
 	return &TimelineEvent{}
 }
 
-func (t *TimelineEvent) getTable(ctx *context.Context) string {
-	if ctx.TenantID == "" {
-		ctx.TenantID = "public"
-	}
-
-	return ctx.TenantID + "." + "timeline_event"
+func (t *TimelineEvent) getTable(ctx *context.Context) (string, error) {
+	return tenant.Table(ctx, "timeline_event")
 }
 
 func (t *TimelineEvent) Get(ctx *context.Context, id string) ([]*models.TimelineEvent, error) {
+	table, err := t.getTable(ctx)
+	if err != nil {
+		return nil, err
+	}
+
 	var result []*models.TimelineEvent
-	err := ctx.DB.WithContext(ctx.Request.Context()).Table(t.getTable(ctx)).Where("id = ?", id).Find(&result).Error
+	err = ctx.DB.WithContext(ctx.Request.Context()).Table(table).Where("id = ?", id).Find(&result).Error
 	if err != nil {
 		ctx.Log.Error("Unable to get stock.", zap.Error(err))
 		return nil, err
@@ -40,13 +42,23 @@ func (t *TimelineEvent) Get(ctx *context.Context, id string) ([]*models.Timeline
 }
 
 func (t *TimelineEvent) Upsert(ctx *context.Context, m ...*models.TimelineEvent) error {
-	return ctx.DB.Debug().WithContext(ctx.Request.Context()).Table(t.getTable(ctx)).Save(m).Error
+	table, err := t.getTable(ctx)
+	if err != nil {
+		return err
+	}
+
+	return ctx.DB.Debug().WithContext(ctx.Request.Context()).Table(table).Save(m).Error
 }
 
 func (t *TimelineEvent) GetByShipmentID(ctx *context.Context,
 	shipmentID string) ([]*models.TimelineEvent, error) {
+	table, err := t.getTable(ctx)
+	if err != nil {
+		return nil, err
+	}
+
 	var result []*models.TimelineEvent
-	err := ctx.DB.WithContext(ctx.Request.Context()).Table(t.getTable(ctx)).
+	err = ctx.DB.WithContext(ctx.Request.Context()).Table(table).
 		Find(&result).Where("shipment_id = ?", shipmentID).Error
 	if err != nil {
 		ctx.Log.Error("Unable to get workflow fields.", zap.Error(err))
@@ -57,9 +69,14 @@ func (t *TimelineEvent) GetByShipmentID(ctx *context.Context,
 }
 
 func (t *TimelineEvent) GetTimeLineEvent(ctx *context.Context, req *models.Card) ([]*models.TimelineEvent, error) {
+	table, err := t.getTable(ctx)
+	if err != nil {
+		return nil, err
+	}
+
 	var result []*models.TimelineEvent
 
-	q := ctx.DB.Debug().WithContext(ctx.Request.Context()).Table(t.getTable(ctx))
+	q := ctx.DB.Debug().WithContext(ctx.Request.Context()).Table(table)
 
 	if req.InstanceType == "shipment" {
 		q = q.Where("shipment_id = ? ", req.InstanceId)
@@ -73,7 +90,7 @@ func (t *TimelineEvent) GetTimeLineEvent(ctx *context.Context, req *models.Card)
 		q = q.Where("quote_id = ? ", req.InstanceId)
 	}
 
-	err := q.Find(&result).Error
+	err = q.Find(&result).Error
 
 	if err != nil {
 		return nil, err
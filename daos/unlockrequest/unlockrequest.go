@@ -0,0 +1,345 @@
+package unlockrequest
+
+import (
+	"time"
+
+	"bitbucket.org/radarventures/forwarder-adapters/utils/context"
+	"bitbucket.org/radarventures/forwarder-shipments/constants"
+	"bitbucket.org/radarventures/forwarder-shipments/daos/tenant"
+	"bitbucket.org/radarventures/forwarder-shipments/database/models"
+	"github.com/google/uuid"
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+type IUnlockRequest interface {
+	CreateWithTx(ctx *context.Context, tx *gorm.DB, m *models.ShipmentUnlockRequest) error
+	Get(ctx *context.Context, id uuid.UUID) (*models.ShipmentUnlockRequest, error)
+	GetWithTx(ctx *context.Context, tx *gorm.DB, id uuid.UUID) (*models.ShipmentUnlockRequest, error)
+	UpdateWithTx(ctx *context.Context, tx *gorm.DB, id uuid.UUID, fromStatus string, fields map[string]interface{}) (bool, error)
+	GetByShipment(ctx *context.Context, shipmentId uuid.UUID) ([]*models.ShipmentUnlockRequest, error)
+	GetOpenWithTx(ctx *context.Context, tx *gorm.DB, shipmentId uuid.UUID, at time.Time) (*models.ShipmentUnlockRequest, error)
+	GetActive(ctx *context.Context, shipmentId uuid.UUID, at time.Time) (*models.ShipmentUnlockRequest, error)
+	GetActiveShipmentIds(ctx *context.Context, at time.Time) ([]uuid.UUID, error)
+	GetPending(ctx *context.Context, regionId string) ([]*models.ShipmentUnlockRequest, error)
+	GetExpired(ctx *context.Context, at time.Time) ([]*models.ShipmentUnlockRequest, error)
+
+	CreateEventWithTx(ctx *context.Context, tx *gorm.DB, m *models.ShipmentUnlockRequestEvent) error
+	GetEvents(ctx *context.Context, requestId uuid.UUID) ([]*models.ShipmentUnlockRequestEvent, error)
+
+	UpsertApprover(ctx *context.Context, m *models.ShipmentUnlockApprover) error
+	DeleteApprover(ctx *context.Context, regionId string, accountId uuid.UUID) error
+	GetApprovers(ctx *context.Context, regionId string) ([]*models.ShipmentUnlockApprover, error)
+	IsApprover(ctx *context.Context, regionId string, accountId uuid.UUID) (bool, error)
+}
+
+type UnlockRequest struct {
+}
+
+func NewUnlockRequest() IUnlockRequest {
+	return &UnlockRequest{}
+}
+
+func (t *UnlockRequest) getTable(ctx *context.Context) (string, error) {
+	return tenant.Table(ctx, "shipment_unlock_requests")
+}
+
+func (t *UnlockRequest) getEventsTable(ctx *context.Context) (string, error) {
+	return tenant.Table(ctx, "shipment_unlock_request_events")
+}
+
+func (t *UnlockRequest) getApproversTable(ctx *context.Context) (string, error) {
+	return tenant.Table(ctx, "shipment_unlock_approvers")
+}
+
+func (t *UnlockRequest) CreateWithTx(ctx *context.Context, tx *gorm.DB, m *models.ShipmentUnlockRequest) error {
+	table, err := t.getTable(ctx)
+	if err != nil {
+		return err
+	}
+
+	err = tx.Table(table).Create(m).Error
+	if err != nil {
+		ctx.Log.Error("Unable to create shipment unlock request.", zap.Error(err))
+		return err
+	}
+
+	return nil
+}
+
+func (t *UnlockRequest) Get(ctx *context.Context, id uuid.UUID) (*models.ShipmentUnlockRequest, error) {
+	table, err := t.getTable(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var result models.ShipmentUnlockRequest
+	err = ctx.DB.WithContext(ctx.Request.Context()).Table(table).First(&result, "id = ?", id).Error
+	if err != nil {
+		ctx.Log.Error("Unable to get shipment unlock request.", zap.Error(err))
+		return nil, err
+	}
+
+	return &result, nil
+}
+
+// GetWithTx locks the request until the transaction ends, so that it is decided only once.
+func (t *UnlockRequest) GetWithTx(ctx *context.Context, tx *gorm.DB, id uuid.UUID) (*models.ShipmentUnlockRequest, error) {
+	table, err := t.getTable(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var result models.ShipmentUnlockRequest
+	err = tx.Table(table).
+		Clauses(clause.Locking{Strength: "UPDATE"}).
+		First(&result, "id = ?", id).Error
+	if err != nil {
+		return nil, err
+	}
+
+	return &result, nil
+}
+
+// UpdateWithTx updates the request only while it still has fromStatus and reports whether it
+// did.
+func (t *UnlockRequest) UpdateWithTx(ctx *context.Context, tx *gorm.DB, id uuid.UUID, fromStatus string, fields map[string]interface{}) (bool, error) {
+	table, err := t.getTable(ctx)
+	if err != nil {
+		return false, err
+	}
+
+	res := tx.Table(table).
+		Where("id = ? AND status = ?", id, fromStatus).
+		UpdateColumns(fields)
+	if res.Error != nil {
+		ctx.Log.Error("Unable to update shipment unlock request.", zap.Any("id", id), zap.Error(res.Error))
+		return false, res.Error
+	}
+
+	return res.RowsAffected > 0, nil
+}
+
+func (t *UnlockRequest) GetByShipment(ctx *context.Context, shipmentId uuid.UUID) ([]*models.ShipmentUnlockRequest, error) {
+	table, err := t.getTable(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var result []*models.ShipmentUnlockRequest
+	err = ctx.DB.WithContext(ctx.Request.Context()).Table(table).
+		Where("shipment_id = ?", shipmentId).
+		Order("requested_at desc").
+		Find(&result).Error
+	if err != nil {
+		ctx.Log.Error("Unable to get shipment unlock requests.", zap.Error(err))
+		return nil, err
+	}
+
+	return result, nil
+}
+
+// GetOpenWithTx returns the request of the shipment that is pending or approved and not yet
+// expired. The shipment is held under an advisory lock until the transaction ends so that two
+// requests cannot be opened for it at once.
+func (t *UnlockRequest) GetOpenWithTx(ctx *context.Context, tx *gorm.DB, shipmentId uuid.UUID, at time.Time) (*models.ShipmentUnlockRequest, error) {
+	table, err := t.getTable(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	err = tx.Exec("SELECT pg_advisory_xact_lock(hashtext(?))", table+":"+shipmentId.String()).Error
+	if err != nil {
+		return nil, err
+	}
+
+	var result models.ShipmentUnlockRequest
+	err = tx.Table(table).
+		Where("shipment_id = ?", shipmentId).
+		Where("status = ? OR (status = ? AND relocked_at IS NULL)", constants.UnlockRequestPending, constants.UnlockRequestApproved).
+		Where("expires_at > ?", at).
+		Order("requested_at desc").
+		First(&result).Error
+	if err != nil {
+		return nil, err
+	}
+
+	return &result, nil
+}
+
+// GetActive returns the approved request that currently keeps the shipment unlocked.
+func (t *UnlockRequest) GetActive(ctx *context.Context, shipmentId uuid.UUID, at time.Time) (*models.ShipmentUnlockRequest, error) {
+	table, err := t.getTable(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var result models.ShipmentUnlockRequest
+	err = ctx.DB.WithContext(ctx.Request.Context()).Table(table).
+		Where("shipment_id = ? AND status = ? AND relocked_at IS NULL AND expires_at > ?", shipmentId, constants.UnlockRequestApproved, at).
+		Order("decided_at desc").
+		First(&result).Error
+	if err != nil {
+		return nil, err
+	}
+
+	return &result, nil
+}
+
+// GetActiveShipmentIds returns the shipments kept unlocked by an approved request. Their
+// relock is governed by the request's expiry rather than by how long they were idle.
+func (t *UnlockRequest) GetActiveShipmentIds(ctx *context.Context, at time.Time) ([]uuid.UUID, error) {
+	table, err := t.getTable(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var result []uuid.UUID
+	err = ctx.DB.WithContext(ctx.Request.Context()).Table(table).
+		Where("status = ? AND relocked_at IS NULL AND expires_at > ?", constants.UnlockRequestApproved, at).
+		Distinct().
+		Pluck("shipment_id", &result).Error
+	if err != nil {
+		ctx.Log.Error("Unable to get unlocked shipments.", zap.Error(err))
+		return nil, err
+	}
+
+	return result, nil
+}
+
+func (t *UnlockRequest) GetPending(ctx *context.Context, regionId string) ([]*models.ShipmentUnlockRequest, error) {
+	table, err := t.getTable(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var result []*models.ShipmentUnlockRequest
+	err = ctx.DB.WithContext(ctx.Request.Context()).Table(table).
+		Where("region_id = ? AND status = ?", regionId, constants.UnlockRequestPending).
+		Order("requested_at asc").
+		Find(&result).Error
+	if err != nil {
+		ctx.Log.Error("Unable to get pending shipment unlock requests.", zap.Error(err))
+		return nil, err
+	}
+
+	return result, nil
+}
+
+// GetExpired returns the approved requests whose unlock ran out and the pending ones that were
+// never decided before their expiry.
+func (t *UnlockRequest) GetExpired(ctx *context.Context, at time.Time) ([]*models.ShipmentUnlockRequest, error) {
+	table, err := t.getTable(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var result []*models.ShipmentUnlockRequest
+	err = ctx.DB.WithContext(ctx.Request.Context()).Table(table).
+		Where("expires_at <= ?", at).
+		Where("status = ? OR (status = ? AND relocked_at IS NULL)", constants.UnlockRequestPending, constants.UnlockRequestApproved).
+		Order("expires_at asc").
+		Find(&result).Error
+	if err != nil {
+		ctx.Log.Error("Unable to get expired shipment unlock requests.", zap.Error(err))
+		return nil, err
+	}
+
+	return result, nil
+}
+
+func (t *UnlockRequest) CreateEventWithTx(ctx *context.Context, tx *gorm.DB, m *models.ShipmentUnlockRequestEvent) error {
+	eventsTable, err := t.getEventsTable(ctx)
+	if err != nil {
+		return err
+	}
+
+	err = tx.Table(eventsTable).Create(m).Error
+	if err != nil {
+		ctx.Log.Error("Unable to create shipment unlock request event.", zap.Error(err))
+		return err
+	}
+
+	return nil
+}
+
+func (t *UnlockRequest) GetEvents(ctx *context.Context, requestId uuid.UUID) ([]*models.ShipmentUnlockRequestEvent, error) {
+	eventsTable, err := t.getEventsTable(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var result []*models.ShipmentUnlockRequestEvent
+	err = ctx.DB.WithContext(ctx.Request.Context()).Table(eventsTable).
+		Where("request_id = ?", requestId).
+		Order("created_at asc").
+		Find(&result).Error
+	if err != nil {
+		ctx.Log.Error("Unable to get shipment unlock request events.", zap.Error(err))
+		return nil, err
+	}
+
+	return result, nil
+}
+
+func (t *UnlockRequest) UpsertApprover(ctx *context.Context, m *models.ShipmentUnlockApprover) error {
+	approversTable, err := t.getApproversTable(ctx)
+	if err != nil {
+		return err
+	}
+
+	return ctx.DB.WithContext(ctx.Request.Context()).Table(approversTable).
+		Clauses(clause.OnConflict{
+			Columns:   []clause.Column{{Name: "region_id"}, {Name: "account_id"}},
+			DoUpdates: clause.AssignmentColumns([]string{"updated_by", "updated_at"}),
+		}).
+		Create(m).Error
+}
+
+func (t *UnlockRequest) DeleteApprover(ctx *context.Context, regionId string, accountId uuid.UUID) error {
+	approversTable, err := t.getApproversTable(ctx)
+	if err != nil {
+		return err
+	}
+
+	return ctx.DB.WithContext(ctx.Request.Context()).Table(approversTable).
+		Where("region_id = ? AND account_id = ?", regionId, accountId).
+		Delete(&models.ShipmentUnlockApprover{}).Error
+}
+
+func (t *UnlockRequest) GetApprovers(ctx *context.Context, regionId string) ([]*models.ShipmentUnlockApprover, error) {
+	approversTable, err := t.getApproversTable(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var result []*models.ShipmentUnlockApprover
+	err = ctx.DB.WithContext(ctx.Request.Context()).Table(approversTable).
+		Where("region_id = ?", regionId).
+		Find(&result).Error
+	if err != nil {
+		ctx.Log.Error("Unable to get shipment unlock approvers.", zap.Error(err))
+		return nil, err
+	}
+
+	return result, nil
+}
+
+func (t *UnlockRequest) IsApprover(ctx *context.Context, regionId string, accountId uuid.UUID) (bool, error) {
+	approversTable, err := t.getApproversTable(ctx)
+	if err != nil {
+		return false, err
+	}
+
+	var count int64
+	err = ctx.DB.WithContext(ctx.Request.Context()).Table(approversTable).
+		Where("region_id = ? AND account_id = ?", regionId, accountId).
+		Count(&count).Error
+	if err != nil {
+		ctx.Log.Error("Unable to check shipment unlock approver.", zap.Error(err))
+		return false, err
+	}
+
+	return count > 0, nil
+}
@@ -20,6 +20,7 @@ type IVersionedLineItems interface {
 	GetMaxLineItemVersion(ctx *context.Context, quoteID string) (int64, error)
 	GetAllMaxVersionedLiWitExRates(ctx *context.Context, ids []string, regionID string) ([]*models.LineItemWithExRate, error)
 	GetMaxLineItemsForTimeline(ctx *context.Context, qid string, buyRegionId string, version int64) ([]*models.TimeLineLineItems, error)
+	GetLineItemVersions(ctx *context.Context, quoteID string) ([]int64, error)
 }
 
 type VersionedLineItems struct {
@@ -190,3 +191,19 @@ func (t *VersionedLineItems) GetMaxLineItemsForTimeline(ctx *context.Context, qi
 
 	return liVersions, nil
 }
+
+// GetLineItemVersions returns the versions a quote has line items for, oldest first.
+func (t *VersionedLineItems) GetLineItemVersions(ctx *context.Context, quoteID string) ([]int64, error) {
+	var versions []int64
+	err := ctx.DB.WithContext(ctx.Request.Context()).Table(t.getTable(ctx)).
+		Distinct("version").
+		Where("quote_id = ?", quoteID).
+		Order("version").
+		Pluck("version", &versions).Error
+	if err != nil {
+		ctx.Log.Error("error fetching line item versions", zap.Error(err))
+		return nil, err
+	}
+
+	return versions, nil
+}
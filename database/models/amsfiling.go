@@ -0,0 +1,72 @@
+package models
+
+import (
+	"time"
+
+	dtos "bitbucket.org/radarventures/forwarder-adapters/dtos/shipments"
+	"github.com/google/uuid"
+)
+
+// AMSStatusEvent is a status a filing reached, in the order they were reached. The time spent
+// in each stage of the filing is read from them.
+type AMSStatusEvent struct {
+	Id        uuid.UUID `json:"id"`
+	AmsInfoId uuid.UUID `json:"ams_info_id"`
+	Status    string    `json:"status"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// AMSFiling is the latest AMS filing of a house bill of a shipment with its latest generated
+// file, or the ISF filing of a shipment. AmsInfoId is the sis_info id of an ISF filing.
+type AMSFiling struct {
+	AmsInfoId     uuid.UUID  `json:"ams_info_id"`
+	Kind          string     `json:"kind"`
+	ShipmentId    uuid.UUID  `json:"shipment_id"`
+	ShipmentCode  string     `json:"shipment_code"`
+	RegionId      string     `json:"region_id"`
+	CreatedBy     string     `json:"-"`
+	HblNo         string     `json:"hbl_no"`
+	AmsStatus     string     `json:"ams_status"`
+	IsGenerated   bool       `json:"is_generated"`
+	AmsFile       string     `json:"ams_file"`
+	ErrorResponse string     `json:"-"`
+	Etd           *time.Time `json:"etd"`
+	CreatedAt     time.Time  `json:"created_at"`
+}
+
+type AMSFilingFilter struct {
+	RegionId   string
+	ShipmentId string
+	HblNo      string
+	Kind       string
+	Stage      string
+	Pg         int
+}
+
+// AMSFilingPage is a page of filings. Total counts the filings of every page before they are
+// filtered by stage.
+type AMSFilingPage struct {
+	Total   int64              `json:"total"`
+	Filings []*AMSFilingDetail `json:"filings"`
+}
+
+// AMSStagePeriod is a stretch of time a filing spent in a stage. LeftAt is nil for the current
+// stage.
+type AMSStagePeriod struct {
+	Stage     string     `json:"stage"`
+	Status    string     `json:"status"`
+	EnteredAt time.Time  `json:"entered_at"`
+	LeftAt    *time.Time `json:"left_at"`
+	Seconds   int64      `json:"seconds"`
+}
+
+type AMSFilingDetail struct {
+	*AMSFiling
+	Stage        string           `json:"stage"`
+	StageSince   *time.Time       `json:"stage_since"`
+	Errors       []dtos.AMSError  `json:"errors"`
+	Periods      []AMSStagePeriod `json:"periods"`
+	StageSeconds map[string]int64 `json:"stage_seconds"`
+	CutOff       *time.Time       `json:"cut_off"`
+	PastCutOff   bool             `json:"past_cut_off"`
+}
@@ -0,0 +1,24 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// AMSMailFailure is an AMS mail that could not be parsed. It is kept with its raw content for
+// review instead of being dropped, and can be retried once the parser handles it.
+type AMSMailFailure struct {
+	Id         uuid.UUID  `json:"id"`
+	MessageId  string     `json:"message_id"`
+	Source     string     `json:"source"`
+	Sender     string     `json:"sender"`
+	Subject    string     `json:"subject"`
+	Body       string     `json:"body"`
+	Error      string     `json:"error"`
+	ReceivedAt time.Time  `json:"received_at"`
+	CreatedAt  time.Time  `json:"created_at"`
+	ResolvedAt *time.Time `json:"resolved_at"`
+	ResolvedBy *uuid.UUID `json:"resolved_by"`
+	Resolution string     `json:"resolution"`
+}
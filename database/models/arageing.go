@@ -0,0 +1,44 @@
+package models
+
+import (
+	"github.com/google/uuid"
+)
+
+type ARAgeingFilter struct {
+	CompanyIds        []string `json:"company_ids"`
+	RegionIds         []string `json:"region_ids"`
+	SalesExecutiveIds []string `json:"sales_executive_ids"`
+}
+
+// ARAgeingInvoice is the outstanding part of a customer invoice converted to the region's
+// base currency with the exchange rates stored on its lines.
+type ARAgeingInvoice struct {
+	InvoiceId         uuid.UUID `json:"invoice_id"`
+	No                string    `json:"no"`
+	CompanyId         uuid.UUID `json:"company_id"`
+	RegionId          uuid.UUID `json:"region_id"`
+	SalesExecutiveId  uuid.UUID `json:"sales_executive_id"`
+	AgeDays           int       `json:"age_days"`
+	BaseAmount        float64   `json:"base_amount"`
+	InvoicedAmount    float64   `json:"invoiced_amount"`
+	OutstandingAmount float64   `json:"outstanding_amount"`
+	HasBalance        bool      `json:"has_balance"`
+}
+
+type ARAgeingRow struct {
+	CompanyId        uuid.UUID `json:"company_id"`
+	RegionId         uuid.UUID `json:"region_id"`
+	SalesExecutiveId uuid.UUID `json:"sales_executive_id"`
+	Current          float64   `json:"current"`
+	Days0To30        float64   `json:"days_0_30"`
+	Days31To60       float64   `json:"days_31_60"`
+	Days61To90       float64   `json:"days_61_90"`
+	Days90Plus       float64   `json:"days_90_plus"`
+	Total            float64   `json:"total"`
+	InvoiceCount     int       `json:"invoice_count"`
+}
+
+type ARAgeingReport struct {
+	Rows   []*ARAgeingRow `json:"rows"`
+	Totals *ARAgeingRow   `json:"totals"`
+}
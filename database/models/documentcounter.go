@@ -0,0 +1,20 @@
+package models
+
+import "time"
+
+// DocumentCounterKey identifies a document number sequence of the tenant. Scope is the port or
+// code the numbers are issued for and Period is empty for sequences that never restart.
+type DocumentCounterKey struct {
+	DocType string `json:"doc_type"`
+	Scope   string `json:"scope"`
+	Period  string `json:"period"`
+}
+
+// DocumentCounter holds the last number issued for a key.
+type DocumentCounter struct {
+	DocType   string    `json:"doc_type" gorm:"primaryKey"`
+	Scope     string    `json:"scope" gorm:"primaryKey"`
+	Period    string    `json:"period" gorm:"primaryKey"`
+	LastValue int64     `json:"last_value"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
@@ -0,0 +1,57 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/lib/pq"
+)
+
+// DSRSubscription delivers a company's daily status report to its receivers at SendHour (UTC)
+// every day, every Weekday or on every DayOfMonth.
+type DSRSubscription struct {
+	Id         uuid.UUID      `json:"id"`
+	CompanyId  uuid.UUID      `json:"company_id"`
+	Name       string         `json:"name"`
+	Columns    pq.StringArray `json:"columns" gorm:"type:text[]"`
+	Format     string         `json:"format"`
+	Frequency  string         `json:"frequency"`
+	SendHour   int            `json:"send_hour"`
+	Weekday    int            `json:"weekday"`
+	DayOfMonth int            `json:"day_of_month"`
+	Receivers  pq.StringArray `json:"receivers" gorm:"type:text[]"`
+
+	// Filters, an empty one matches every shipment
+	Types        pq.StringArray `json:"types" gorm:"type:text[]"`
+	Statuses     pq.StringArray `json:"statuses" gorm:"type:text[]"`
+	Pols         pq.StringArray `json:"pols" gorm:"type:text[]"`
+	Pods         pq.StringArray `json:"pods" gorm:"type:text[]"`
+	CreatedSince int            `json:"created_since_days"`
+
+	IsActive   bool       `json:"is_active"`
+	NextRunAt  time.Time  `json:"next_run_at"`
+	LastSentAt *time.Time `json:"last_sent_at"`
+	CreatedBy  uuid.UUID  `json:"created_by"`
+	CreatedAt  time.Time  `json:"created_at"`
+	UpdatedAt  time.Time  `json:"updated_at"`
+}
+
+// DSRDelivery is a report rendered for a subscription and whether it reached the receivers.
+type DSRDelivery struct {
+	Id             uuid.UUID      `json:"id"`
+	SubscriptionId uuid.UUID      `json:"subscription_id"`
+	CompanyId      uuid.UUID      `json:"company_id"`
+	DocumentId     string         `json:"document_id"`
+	FileName       string         `json:"file_name"`
+	Rows           int            `json:"rows"`
+	Receivers      pq.StringArray `json:"receivers" gorm:"type:text[]"`
+	Status         string         `json:"status"`
+	Error          string         `json:"error"`
+	CreatedAt      time.Time      `json:"created_at"`
+}
+
+// DSRColumn is a column a DSR can be subscribed to.
+type DSRColumn struct {
+	Key    string `json:"key"`
+	Header string `json:"header"`
+}
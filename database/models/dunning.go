@@ -0,0 +1,41 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/lib/pq"
+)
+
+// DunningContact holds the addresses overdue reminders are sent to for a customer. Opting
+// out goes through the summary mail block list, like every other mail of the cronjobs.
+type DunningContact struct {
+	CompanyId uuid.UUID      `json:"company_id" gorm:"primaryKey"`
+	Emails    pq.StringArray `json:"emails" gorm:"type:text[]"`
+	UpdatedBy uuid.UUID      `json:"updated_by"`
+	UpdatedAt time.Time      `json:"updated_at"`
+}
+
+// DunningLog records a reminder sent for an invoice. There is at most one per stage.
+type DunningLog struct {
+	Id        uuid.UUID      `json:"id"`
+	InvoiceId uuid.UUID      `json:"invoice_id"`
+	InvoiceNo string         `json:"invoice_no"`
+	CompanyId uuid.UUID      `json:"company_id"`
+	Stage     string         `json:"stage"`
+	Receivers pq.StringArray `json:"receivers" gorm:"type:text[]"`
+	SentAt    time.Time      `json:"sent_at"`
+}
+
+type DunningInvoice struct {
+	InvoiceId         uuid.UUID  `json:"invoice_id"`
+	No                string     `json:"no"`
+	CompanyId         uuid.UUID  `json:"company_id"`
+	RegionId          uuid.UUID  `json:"region_id"`
+	ShipmentId        uuid.UUID  `json:"shipment_id"`
+	SalesExecutiveId  uuid.UUID  `json:"sales_executive_id"`
+	DueOn             *time.Time `json:"due_on"`
+	Currency          string     `json:"currency"`
+	OutstandingAmount *float64   `json:"outstanding_amount"`
+	DaysOverdue       int        `json:"days_overdue"`
+}
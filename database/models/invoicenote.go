@@ -0,0 +1,75 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// InvoiceNote links a credit or debit note to the invoice it was raised against.
+type InvoiceNote struct {
+	Id            uuid.UUID `json:"id"`
+	InvoiceId     uuid.UUID `json:"invoice_id"`
+	InvoiceNo     string    `json:"invoice_no"`
+	NoteInvoiceId uuid.UUID `json:"note_invoice_id"`
+	NoteNo        string    `json:"note_no"`
+	NoteType      string    `json:"note_type"`
+	Reason        string    `json:"reason"`
+	Amount        float64   `json:"amount"`
+	CreatedBy     uuid.UUID `json:"created_by"`
+	CreatedAt     time.Time `json:"created_at"`
+}
+
+// InvoiceBalance is the running outstanding amount of an invoice after notes are applied.
+type InvoiceBalance struct {
+	InvoiceId         uuid.UUID `json:"invoice_id" gorm:"primaryKey"`
+	InvoiceNo         string    `json:"invoice_no"`
+	ShipmentId        uuid.UUID `json:"shipment_id"`
+	Currency          string    `json:"currency"`
+	InvoicedAmount    float64   `json:"invoiced_amount"`
+	CreditedAmount    float64   `json:"credited_amount"`
+	DebitedAmount     float64   `json:"debited_amount"`
+	PaidAmount        float64   `json:"paid_amount"`
+	OutstandingAmount float64   `json:"outstanding_amount"`
+	Status            string    `json:"status"`
+	UpdatedAt         time.Time `json:"updated_at"`
+}
+
+type InvoiceNoteLine struct {
+	LineItemId uuid.UUID `json:"line_item_id"`
+	Amount     float64   `json:"amount"`
+}
+
+// NoteInvoice is a credit or debit note written as a copy of the invoice it is raised
+// against, carrying only the note lines.
+type NoteInvoice struct {
+	Id               uuid.UUID
+	OriginalId       uuid.UUID
+	No               string
+	InvoiceType      string
+	VoucherType      string
+	InvoiceRequestId string
+	InvoicedAt       time.Time
+	Lines            []*InvoiceNoteLine
+}
+
+// InvoiceVoucher is what an invoice number is allocated by, a note is numbered in the
+// region of its invoice.
+type InvoiceVoucher struct {
+	RegionId    uuid.UUID `json:"region_id"`
+	VoucherType string    `json:"voucher_type"`
+}
+
+// InvoiceNoteLineError explains why a requested note line was rejected.
+type InvoiceNoteLineError struct {
+	LineItemId uuid.UUID `json:"line_item_id"`
+	Requested  float64   `json:"requested"`
+	Available  float64   `json:"available"`
+	Message    string    `json:"message"`
+}
+
+type InvoiceChain struct {
+	Invoice *Invoice        `json:"invoice"`
+	Balance *InvoiceBalance `json:"balance"`
+	Notes   []*InvoiceNote  `json:"notes"`
+}
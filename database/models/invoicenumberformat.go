@@ -0,0 +1,61 @@
+package models
+
+import (
+	"database/sql/driver"
+	"encoding/json"
+	"errors"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// InvoiceNumberTemplates are the templates a region renders invoice numbers with, kept on
+// the region's number format invoice pref. Templates maps a voucher type to a template such
+// as "{REGION}/{FY}/{TYPE}-{SEQ:06}".
+type InvoiceNumberTemplates struct {
+	RegionId   uuid.UUID       `json:"region_id"`
+	RegionCode string          `json:"region_code"`
+	Templates  NumberTemplates `json:"number_templates" gorm:"column:number_templates;type:jsonb"`
+	UpdatedBy  uuid.UUID       `json:"updated_by"`
+	UpdatedAt  time.Time       `json:"updated_at"`
+}
+
+type NumberTemplates map[string]string
+
+func (t NumberTemplates) Value() (driver.Value, error) {
+	return json.Marshal(t)
+}
+
+func (t *NumberTemplates) Scan(value interface{}) error {
+	if value == nil {
+		*t = nil
+		return nil
+	}
+
+	var b []byte
+	switch v := value.(type) {
+	case []byte:
+		b = v
+	case string:
+		b = []byte(v)
+	default:
+		return errors.New("unsupported type for number templates")
+	}
+
+	return json.Unmarshal(b, t)
+}
+
+// InvoiceNumberFormat is the template of one voucher type of a region.
+type InvoiceNumberFormat struct {
+	RegionId    uuid.UUID `json:"region_id"`
+	RegionCode  string    `json:"region_code"`
+	VoucherType string    `json:"voucher_type"`
+	Template    string    `json:"template"`
+}
+
+type InvoiceNumberPreview struct {
+	RegionId    uuid.UUID `json:"region_id"`
+	VoucherType string    `json:"voucher_type"`
+	Template    string    `json:"template"`
+	Numbers     []string  `json:"numbers"`
+}
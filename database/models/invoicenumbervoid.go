@@ -0,0 +1,32 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// InvoiceNumberVoid records an invoice number that was allocated from a
+// sequence but never issued, so the gap is accounted for during audits.
+type InvoiceNumberVoid struct {
+	Id           uuid.UUID `json:"id"`
+	RegionId     uuid.UUID `json:"region_id"`
+	SequenceName string    `json:"sequence_name"`
+	No           int64     `json:"no"`
+	InvoiceId    uuid.UUID `json:"invoice_id"`
+	Reason       string    `json:"reason"`
+	CreatedBy    uuid.UUID `json:"created_by"`
+	CreatedAt    time.Time `json:"created_at"`
+}
+
+// InvoiceFiscalYear holds the month a region's fiscal year starts in. Invoice
+// sequences rendered with a {FY} template restart from 1 on this boundary.
+type InvoiceFiscalYear struct {
+	Id         uuid.UUID `json:"id"`
+	RegionId   uuid.UUID `json:"region_id"`
+	StartMonth int       `json:"start_month"`
+	CreatedBy  uuid.UUID `json:"created_by"`
+	UpdatedBy  uuid.UUID `json:"updated_by"`
+	CreatedAt  time.Time `json:"created_at"`
+	UpdatedAt  time.Time `json:"updated_at"`
+}
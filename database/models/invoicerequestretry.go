@@ -0,0 +1,26 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// StuckInvoiceRequest is an invoice request whose invoice copy could not be retrieved yet.
+type StuckInvoiceRequest struct {
+	Id           uuid.UUID  `json:"id"`
+	InvoiceId    uuid.UUID  `json:"invoice_id"`
+	InvoiceNo    string     `json:"invoice_no"`
+	InvoiceType  string     `json:"invoice_type"`
+	ShipmentId   uuid.UUID  `json:"shipment_id"`
+	RegionId     uuid.UUID  `json:"region_id"`
+	RetryCount   int        `json:"retry_count"`
+	NextRetryAt  *time.Time `json:"next_retry_at"`
+	IsDeadLetter bool       `json:"is_dead_letter"`
+	LastError    string     `json:"last_error"`
+	CreatedAt    time.Time  `json:"created_at"`
+}
+
+type RetryInvoiceRequestsReq struct {
+	Ids []uuid.UUID `json:"ids"`
+}
@@ -0,0 +1,33 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// JobRun is one execution of a scheduled cronjob. Item counts are summed over the worker
+// pools the job ran. job_runs has a unique index on (job, tenant_id, scheduled_at), so each
+// tick of a job runs once per tenant however many replicas fire on it.
+type JobRun struct {
+	Id          uuid.UUID  `json:"id"`
+	Job         string     `json:"job"`
+	TenantId    string     `json:"tenant_id"`
+	ScheduledAt time.Time  `json:"scheduled_at"`
+	Status      string     `json:"status"`
+	Host        string     `json:"host"`
+	StartedAt   time.Time  `json:"started_at"`
+	EndedAt     *time.Time `json:"ended_at"`
+	Total       int64      `json:"total"`
+	Succeeded   int64      `json:"succeeded"`
+	Failed      int64      `json:"failed"`
+	Skipped     int64      `json:"skipped"`
+	Error       string     `json:"error"`
+}
+
+type JobRunFilter struct {
+	Job    string `json:"job"`
+	Status string `json:"status"`
+	Offset int    `json:"offset"`
+	Limit  int    `json:"limit"`
+}
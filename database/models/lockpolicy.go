@@ -0,0 +1,129 @@
+package models
+
+import (
+	"database/sql/driver"
+	"encoding/json"
+	"errors"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// LockGracePeriods is the number of days after the trigger milestone a shipment of each type is
+// locked. Types without an entry are never locked by the milestone rule.
+type LockGracePeriods map[string]int
+
+func (g LockGracePeriods) Value() (driver.Value, error) {
+	return json.Marshal(g)
+}
+
+func (g *LockGracePeriods) Scan(value interface{}) error {
+	if value == nil {
+		*g = nil
+		return nil
+	}
+
+	var b []byte
+	switch v := value.(type) {
+	case []byte:
+		b = v
+	case string:
+		b = []byte(v)
+	default:
+		return errors.New("unsupported type for lock grace periods")
+	}
+
+	return json.Unmarshal(b, g)
+}
+
+// ShipmentLockPolicy decides when the shipments of a region are locked automatically. A
+// shipment is locked once its grace period after the trigger milestone ran out, or once its
+// line items meet every financial condition the policy enables. Policies without a region
+// apply to every region of the tenant.
+type ShipmentLockPolicy struct {
+	Id                  uuid.UUID        `json:"id"`
+	RegionId            string           `json:"region_id"`
+	Name                string           `json:"name"`
+	Priority            int              `json:"priority"`
+	IsActive            bool             `json:"is_active"`
+	TriggerMilestone    string           `json:"trigger_milestone"`
+	FallbackMilestone   string           `json:"fallback_milestone"`
+	GracePeriods        LockGracePeriods `json:"grace_periods" gorm:"type:jsonb"`
+	RequireSellInvoiced bool             `json:"require_sell_invoiced"`
+	RequireBuyApproved  bool             `json:"require_buy_approved"`
+	UpdatedBy           uuid.UUID        `json:"updated_by"`
+	UpdatedAt           time.Time        `json:"updated_at"`
+}
+
+// ShipmentLockDecision records the rule that locked a shipment automatically.
+type ShipmentLockDecision struct {
+	Id         uuid.UUID  `json:"id"`
+	ShipmentId uuid.UUID  `json:"shipment_id"`
+	PolicyId   *uuid.UUID `json:"policy_id"`
+	PolicyName string     `json:"policy_name"`
+	Rule       string     `json:"rule"`
+	Reason     string     `json:"reason"`
+	LockedAt   time.Time  `json:"locked_at"`
+}
+
+type LockMilestone struct {
+	Name        string `json:"name"`
+	Status      string `json:"status"`
+	CompletedAt int64  `json:"completed_at"`
+}
+
+type LockLineItem struct {
+	IsSellInvoiceGenerated bool `json:"is_sell_invoice_generated"`
+	IsBuyApproved          bool `json:"is_buy_approved"`
+}
+
+type LockPolicyResult struct {
+	PolicyId   *uuid.UUID `json:"policy_id"`
+	PolicyName string     `json:"policy_name"`
+	Fired      bool       `json:"fired"`
+	Rule       string     `json:"rule,omitempty"`
+	Reasons    []string   `json:"reasons"`
+}
+
+// LockEvaluation explains whether a shipment would be locked and by which policy and rule.
+type LockEvaluation struct {
+	ShipmentId   uuid.UUID           `json:"shipment_id"`
+	ShipmentType string              `json:"shipment_type"`
+	IsLocked     bool                `json:"is_locked"`
+	WouldLock    bool                `json:"would_lock"`
+	Rule         string              `json:"rule,omitempty"`
+	Reason       string              `json:"reason,omitempty"`
+	Policy       *LockPolicyResult   `json:"policy,omitempty"`
+	Policies     []*LockPolicyResult `json:"policies"`
+	EvaluatedAt  time.Time           `json:"evaluated_at"`
+}
+
+// ShipmentLockAllowedField is a field of an area that can still be written while a shipment is
+// locked, e.g. tracking events. Fields without a region apply to every region of the tenant.
+type ShipmentLockAllowedField struct {
+	Id        uuid.UUID `json:"id"`
+	RegionId  string    `json:"region_id"`
+	Area      string    `json:"area"`
+	Field     string    `json:"field"`
+	UpdatedBy uuid.UUID `json:"updated_by"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// ShipmentLockedError is the body of the 423 returned for a write to a locked shipment.
+type ShipmentLockedError struct {
+	Code          int         `json:"code"`
+	Error         string      `json:"error"`
+	Message       string      `json:"message"`
+	ShipmentId    uuid.UUID   `json:"shipment_id"`
+	Area          string      `json:"area"`
+	BlockedFields []string    `json:"blocked_fields"`
+	Lock          *LockHolder `json:"lock"`
+}
+
+// LockHolder names what locked the shipment: the policy rule that fired, or a manual lock.
+type LockHolder struct {
+	Name     string     `json:"name"`
+	Rule     string     `json:"rule"`
+	Reason   string     `json:"reason"`
+	LockedAt *time.Time `json:"locked_at"`
+}
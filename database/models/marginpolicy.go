@@ -0,0 +1,116 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/lib/pq"
+)
+
+// MarginPolicy is the gross profit a quote must make in a region, for a shipment type and
+// customer tier. An empty region, type or tier matches any. A quote below MinGPPercent or
+// MinGP needs the approval of every account of Approvers, in order.
+type MarginPolicy struct {
+	Id           uuid.UUID      `json:"id"`
+	Name         string         `json:"name"`
+	RegionId     string         `json:"region_id"`
+	ShipmentType string         `json:"shipment_type"`
+	CustomerTier string         `json:"customer_tier"`
+	MinGPPercent float64        `json:"min_gp_percent"`
+	MinGP        float64        `json:"min_gp"`
+	Approvers    pq.StringArray `json:"approvers" gorm:"type:text[]"`
+	Priority     int            `json:"priority"`
+	IsActive     bool           `json:"is_active"`
+	UpdatedBy    uuid.UUID      `json:"updated_by"`
+	CreatedAt    time.Time      `json:"created_at"`
+	UpdatedAt    time.Time      `json:"updated_at"`
+}
+
+// MarginCustomerTier is the tier margin policies see a customer company in.
+type MarginCustomerTier struct {
+	CompanyId string    `json:"company_id" gorm:"primaryKey"`
+	Tier      string    `json:"tier"`
+	UpdatedBy uuid.UUID `json:"updated_by"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// GPSubject is the quote a GP evaluation is for, with the enquiry or shipment it belongs to.
+type GPSubject struct {
+	QuoteId    string `json:"quote_id"`
+	RfqId      string `json:"rfq_id"`
+	ShipmentId string `json:"shipment_id"`
+	Type       string `json:"type"`
+	RegionId   string `json:"region_id"`
+	CompanyId  string `json:"company_id"`
+}
+
+// GPApproval asks the approver chain of a policy to accept a quote below its margin. Level is
+// the index of the approver whose turn it is. It covers the quote as long as its GP does not
+// fall below the GP it was approved at.
+type GPApproval struct {
+	Id           uuid.UUID      `json:"id"`
+	QuoteId      string         `json:"quote_id"`
+	RfqId        string         `json:"rfq_id"`
+	ShipmentId   string         `json:"shipment_id"`
+	Source       string         `json:"source"`
+	PolicyId     uuid.UUID      `json:"policy_id"`
+	RegionId     string         `json:"region_id"`
+	Buy          float64        `json:"buy"`
+	Sell         float64        `json:"sell"`
+	GP           float64        `json:"gp"`
+	GPPercent    float64        `json:"gp_percent"`
+	MinGP        float64        `json:"min_gp"`
+	MinGPPercent float64        `json:"min_gp_percent"`
+	Approvers    pq.StringArray `json:"approvers" gorm:"type:text[]"`
+	Level        int            `json:"level"`
+	Status       string         `json:"status"`
+	CardId       *uuid.UUID     `json:"card_id"`
+	RequestedBy  string         `json:"requested_by"`
+	RequestedAt  time.Time      `json:"requested_at"`
+	DecidedBy    string         `json:"decided_by"`
+	DecidedAt    *time.Time     `json:"decided_at"`
+	DecisionNote string         `json:"decision_note"`
+}
+
+type GPApprovalEvent struct {
+	Id         uuid.UUID `json:"id"`
+	ApprovalId uuid.UUID `json:"approval_id"`
+	Event      string    `json:"event"`
+	Level      int       `json:"level"`
+	Note       string    `json:"note"`
+	ActorId    string    `json:"actor_id"`
+	CreatedAt  time.Time `json:"created_at"`
+}
+
+type GPApprovalDetail struct {
+	Approval *GPApproval        `json:"approval"`
+	Events   []*GPApprovalEvent `json:"events"`
+}
+
+// GPDecisionReq is an approver's decision. Approve has no default, a request without it
+// cannot decide an approval.
+type GPDecisionReq struct {
+	Approve *bool  `json:"approve"`
+	Note    string `json:"note"`
+}
+
+// GPEvaluation is the margin of a quote against the policy that applies to it. Blocked is set
+// while the quote is below the policy and not covered by an approval.
+type GPEvaluation struct {
+	QuoteId  string        `json:"quote_id"`
+	Margin   *QuoteMargin  `json:"margin"`
+	Policy   *MarginPolicy `json:"policy"`
+	Breaches []string      `json:"breaches"`
+	Approval *GPApproval   `json:"approval"`
+	Blocked  bool          `json:"blocked"`
+}
+
+// GPApprovalRequiredError is the body of a request refused because the quote still needs its
+// GP approved.
+type GPApprovalRequiredError struct {
+	Message  string      `json:"message"`
+	QuoteId  string      `json:"quote_id"`
+	Breaches []string    `json:"breaches"`
+	Margin   QuoteMargin `json:"margin"`
+	Approval *GPApproval `json:"approval"`
+}
@@ -0,0 +1,74 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// PaymentAllocation is the part of a received payment settled against one invoice.
+// Amount is in the receipt currency, AllocatedAmount in the invoice currency.
+type PaymentAllocation struct {
+	Id              uuid.UUID `json:"id"`
+	ReceiptId       uuid.UUID `json:"receipt_id"`
+	ReceiptNo       string    `json:"receipt_no"`
+	ReceivedOn      time.Time `json:"received_on"`
+	InvoiceId       uuid.UUID `json:"invoice_id"`
+	InvoiceNo       string    `json:"invoice_no"`
+	Currency        string    `json:"currency"`
+	Amount          float64   `json:"amount"`
+	ExchangeRate    float64   `json:"exchange_rate"`
+	InvoiceCurrency string    `json:"invoice_currency"`
+	AllocatedAmount float64   `json:"allocated_amount"`
+	Remarks         string    `json:"remarks"`
+	CreatedBy       uuid.UUID `json:"created_by"`
+	CreatedAt       time.Time `json:"created_at"`
+}
+
+type PaymentReceiptReq struct {
+	ReceiptNo   string                  `json:"receipt_no"`
+	ReceivedOn  time.Time               `json:"received_on"`
+	Currency    string                  `json:"currency"`
+	Amount      float64                 `json:"amount"`
+	Remarks     string                  `json:"remarks"`
+	Allocations []*PaymentAllocationReq `json:"allocations"`
+}
+
+// PaymentAllocationReq settles Amount of the receipt against an invoice. ExchangeRate
+// converts the receipt currency to the invoice currency and may be omitted when both match.
+type PaymentAllocationReq struct {
+	InvoiceNo    string  `json:"invoice_no"`
+	Amount       float64 `json:"amount"`
+	ExchangeRate float64 `json:"exchange_rate"`
+}
+
+type InvoiceOutstandingFilter struct {
+	ShipmentId     string   `json:"shipment_id"`
+	CompanyIds     []string `json:"company_ids"`
+	RegionId       string   `json:"region_id"`
+	InvoiceTypes   []string `json:"invoice_types"`
+	Statuses       []string `json:"statuses"`
+	MinOutstanding *float64 `json:"min_outstanding"`
+	MaxOutstanding *float64 `json:"max_outstanding"`
+	DueFrom        string   `json:"due_from"`
+	DueTo          string   `json:"due_to"`
+	MinDaysOverdue *int     `json:"min_days_overdue"`
+	Offset         int      `json:"offset"`
+	Limit          int      `json:"limit"`
+}
+
+type InvoiceWithBalance struct {
+	InvoiceId         uuid.UUID  `json:"invoice_id"`
+	No                string     `json:"no"`
+	InvoiceType       string     `json:"invoice_type"`
+	ShipmentId        uuid.UUID  `json:"shipment_id"`
+	CompanyId         uuid.UUID  `json:"company_id"`
+	RegionId          uuid.UUID  `json:"region_id"`
+	DueOn             *time.Time `json:"due_on"`
+	Currency          string     `json:"currency"`
+	InvoicedAmount    float64    `json:"invoiced_amount"`
+	PaidAmount        float64    `json:"paid_amount"`
+	OutstandingAmount float64    `json:"outstanding_amount"`
+	Status            string     `json:"status"`
+	DaysOverdue       int        `json:"days_overdue"`
+}
@@ -0,0 +1,87 @@
+package models
+
+// QuoteLineItemVersion is a line item as it was in one version of a quote. Totals are in the
+// currency of the region, converted with the exchange rates of that version.
+type QuoteLineItemVersion struct {
+	Id               string  `json:"id"`
+	Name             string  `json:"name"`
+	SubType          string  `json:"sub_type"`
+	Units            float64 `json:"units"`
+	Buy              float64 `json:"buy"`
+	Sell             float64 `json:"sell"`
+	BuyCurrency      string  `json:"buy_currency"`
+	SellCurrency     string  `json:"sell_currency"`
+	BuyExchangeRate  float64 `json:"buy_exchange_rate"`
+	SellExchangeRate float64 `json:"sell_exchange_rate"`
+	Tax              float64 `json:"tax"`
+	BuyTax           float64 `json:"buy_tax"`
+	TotalBuy         float64 `json:"total_buy"`
+	TotalSell        float64 `json:"total_sell"`
+}
+
+// QuoteLineItemChange is a line item that differs between two versions and the fields that
+// changed.
+type QuoteLineItemChange struct {
+	Id     string                `json:"id"`
+	Name   string                `json:"name"`
+	Fields []string              `json:"fields"`
+	From   *QuoteLineItemVersion `json:"from"`
+	To     *QuoteLineItemVersion `json:"to"`
+}
+
+// QuoteExchangeRateChange is a change of the rate a currency was converted at.
+type QuoteExchangeRateChange struct {
+	Currency string  `json:"currency"`
+	Type     string  `json:"type"`
+	From     float64 `json:"from"`
+	To       float64 `json:"to"`
+}
+
+// QuoteMargin is the buy, sell and margin of a version before tax, tax lines excluded.
+type QuoteMargin struct {
+	Buy           float64 `json:"buy"`
+	Sell          float64 `json:"sell"`
+	Margin        float64 `json:"margin"`
+	MarginPercent float64 `json:"margin_percent"`
+}
+
+type QuoteMarginChange struct {
+	From          QuoteMargin `json:"from"`
+	To            QuoteMargin `json:"to"`
+	Margin        float64     `json:"margin"`
+	MarginPercent float64     `json:"margin_percent"`
+}
+
+// QuoteVersionDiff is how a quote changed from one version to another.
+type QuoteVersionDiff struct {
+	QuoteId             string                     `json:"quote_id"`
+	RegionId            string                     `json:"region_id"`
+	FromVersion         int64                      `json:"from_version"`
+	ToVersion           int64                      `json:"to_version"`
+	Added               []*QuoteLineItemVersion    `json:"added"`
+	Removed             []*QuoteLineItemVersion    `json:"removed"`
+	Changed             []*QuoteLineItemChange     `json:"changed"`
+	ExchangeRateChanges []*QuoteExchangeRateChange `json:"exchange_rate_changes"`
+	Margin              QuoteMarginChange          `json:"margin"`
+}
+
+// QuoteRevisionCharge is a sell charge of a revised quote as the customer sees it.
+type QuoteRevisionCharge struct {
+	Name      string  `json:"name"`
+	Currency  string  `json:"currency"`
+	OldAmount float64 `json:"old_amount"`
+	NewAmount float64 `json:"new_amount"`
+}
+
+// QuoteRevisionSummary is the customer facing summary of a revised quote. It only covers the
+// sell side; buy rates and margin are never part of it.
+type QuoteRevisionSummary struct {
+	FromVersion int64                  `json:"from_version"`
+	ToVersion   int64                  `json:"to_version"`
+	Added       []*QuoteRevisionCharge `json:"added"`
+	Removed     []*QuoteRevisionCharge `json:"removed"`
+	Changed     []*QuoteRevisionCharge `json:"changed"`
+	OldTotal    float64                `json:"old_total"`
+	NewTotal    float64                `json:"new_total"`
+	Difference  float64                `json:"difference"`
+}
@@ -0,0 +1,136 @@
+package models
+
+import (
+	"database/sql/driver"
+	"encoding/json"
+	"errors"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// RateTemplatePricing prices a rate template. The template applies to RFQs between
+// EffectiveFrom and EffectiveTo, an empty bound is open. Lane is the POL-POD code rate sheets
+// refer to the template by.
+type RateTemplatePricing struct {
+	TemplateId    uuid.UUID             `json:"template_id" gorm:"primaryKey"`
+	Lane          string                `json:"lane"`
+	EffectiveFrom *time.Time            `json:"effective_from"`
+	EffectiveTo   *time.Time            `json:"effective_to"`
+	Currency      string                `json:"currency"`
+	Charges       []*RateTemplateCharge `json:"charges" gorm:"-"`
+	UpdatedBy     uuid.UUID             `json:"updated_by"`
+	CreatedAt     time.Time             `json:"created_at"`
+	UpdatedAt     time.Time             `json:"updated_at"`
+}
+
+// RateTemplateCharge is a charge of a rate template. Its quantity is measured in Basis and
+// the slab the quantity falls in overrides the flat rates. The amount never falls below the
+// minimum charge.
+type RateTemplateCharge struct {
+	Id            uuid.UUID `json:"id"`
+	TemplateId    uuid.UUID `json:"template_id"`
+	Code          string    `json:"code"`
+	Name          string    `json:"name"`
+	Basis         string    `json:"basis"`
+	ContainerType string    `json:"container_type"`
+	Currency      string    `json:"currency"`
+	BuyRate       float64   `json:"buy_rate"`
+	SellRate      float64   `json:"sell_rate"`
+	MinBuy        float64   `json:"min_buy"`
+	MinSell       float64   `json:"min_sell"`
+	Slabs         RateSlabs `json:"slabs" gorm:"type:jsonb"`
+	Position      int       `json:"position"`
+}
+
+// RateSlab applies to quantities from From up to, not including, To. A zero To is open.
+type RateSlab struct {
+	From     float64 `json:"from"`
+	To       float64 `json:"to"`
+	BuyRate  float64 `json:"buy_rate"`
+	SellRate float64 `json:"sell_rate"`
+}
+
+// RateSlabs are the slabs of a charge ordered by From.
+type RateSlabs []RateSlab
+
+func (s RateSlabs) Value() (driver.Value, error) {
+	return json.Marshal(s)
+}
+
+func (s *RateSlabs) Scan(value interface{}) error {
+	if value == nil {
+		*s = nil
+		return nil
+	}
+
+	var b []byte
+	switch v := value.(type) {
+	case []byte:
+		b = v
+	case string:
+		b = []byte(v)
+	default:
+		return errors.New("unsupported type for rate slabs")
+	}
+
+	return json.Unmarshal(b, s)
+}
+
+// RateTemplateCandidate is a priced template that applies to an RFQ.
+type RateTemplateCandidate struct {
+	TemplateId      uuid.UUID  `json:"template_id"`
+	CompanySpecific bool       `json:"company_specific"`
+	EffectiveFrom   *time.Time `json:"effective_from"`
+	EffectiveTo     *time.Time `json:"effective_to"`
+	Currency        string     `json:"currency"`
+}
+
+// RfqPricingBasis is what the charges of an RFQ are measured against.
+type RfqPricingBasis struct {
+	Id               uuid.UUID          `json:"id"`
+	Type             string             `json:"type"`
+	RegionId         string             `json:"region_id"`
+	CompanyId        string             `json:"company_id"`
+	Pol              string             `json:"pol"`
+	Pod              string             `json:"pod"`
+	Cbm              float64            `json:"cbm"`
+	GrossWeight      float64            `json:"gross_weight"`
+	ChargeableWeight float64            `json:"chargeable_weight"`
+	WeightMeasure    float64            `json:"weight_measure"`
+	Packages         float64            `json:"packages"`
+	Containers       map[string]float64 `json:"containers" gorm:"-"`
+}
+
+// RfqContainerCount is the number of containers of a type an RFQ asks for.
+type RfqContainerCount struct {
+	Type  string `json:"type"`
+	Count int64  `json:"count"`
+}
+
+// PricedLineItem is a buy or sell line item computed from a rate template charge.
+type PricedLineItem struct {
+	ChargeId      uuid.UUID `json:"charge_id"`
+	Code          string    `json:"code"`
+	Name          string    `json:"name"`
+	Type          string    `json:"type"`
+	Basis         string    `json:"basis"`
+	ContainerType string    `json:"container_type,omitempty"`
+	Quantity      float64   `json:"quantity"`
+	Rate          float64   `json:"rate"`
+	Amount        float64   `json:"amount"`
+	Currency      string    `json:"currency"`
+	MinApplied    bool      `json:"min_applied"`
+}
+
+// RfqPricing is the result of pricing an RFQ from its best matching rate template.
+type RfqPricing struct {
+	RfqId      uuid.UUID          `json:"rfq_id"`
+	TemplateId uuid.UUID          `json:"template_id"`
+	PricedOn   time.Time          `json:"priced_on"`
+	Basis      *RfqPricingBasis   `json:"basis"`
+	Buy        []*PricedLineItem  `json:"buy"`
+	Sell       []*PricedLineItem  `json:"sell"`
+	BuyTotal   map[string]float64 `json:"buy_total"`
+	SellTotal  map[string]float64 `json:"sell_total"`
+}
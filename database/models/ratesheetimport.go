@@ -0,0 +1,57 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// RateSheetValues are the values of a charge a rate sheet row sets.
+type RateSheetValues struct {
+	Basis    string  `json:"basis"`
+	Currency string  `json:"currency"`
+	BuyRate  float64 `json:"buy_rate"`
+	SellRate float64 `json:"sell_rate"`
+	MinBuy   float64 `json:"min_buy"`
+}
+
+// RateSheetChargeDiff is how a row of a rate sheet changes a charge of a template.
+type RateSheetChargeDiff struct {
+	Row           int              `json:"row"`
+	ChargeCode    string           `json:"charge_code"`
+	ContainerType string           `json:"container_type"`
+	Action        string           `json:"action"`
+	Old           *RateSheetValues `json:"old,omitempty"`
+	New           *RateSheetValues `json:"new"`
+}
+
+// RateSheetTemplateDiff is how a rate sheet changes the pricing of a template.
+type RateSheetTemplateDiff struct {
+	TemplateId       uuid.UUID              `json:"template_id"`
+	Lane             string                 `json:"lane"`
+	OldEffectiveFrom *time.Time             `json:"old_effective_from"`
+	OldEffectiveTo   *time.Time             `json:"old_effective_to"`
+	EffectiveFrom    *time.Time             `json:"effective_from"`
+	EffectiveTo      *time.Time             `json:"effective_to"`
+	Charges          []*RateSheetChargeDiff `json:"charges"`
+}
+
+type RateSheetRowError struct {
+	Row        int    `json:"row"`
+	Lane       string `json:"lane"`
+	ChargeCode string `json:"charge_code"`
+	Reason     string `json:"reason"`
+}
+
+// RateSheetImportResult is the diff of a rate sheet against the current rate templates and
+// whether it was applied. A sheet with row errors is never applied.
+type RateSheetImportResult struct {
+	DryRun    bool                     `json:"dry_run"`
+	Applied   bool                     `json:"applied"`
+	Rows      int                      `json:"rows"`
+	Added     int                      `json:"added"`
+	Updated   int                      `json:"updated"`
+	Unchanged int                      `json:"unchanged"`
+	Templates []*RateSheetTemplateDiff `json:"templates"`
+	Errors    []*RateSheetRowError     `json:"errors"`
+}
@@ -0,0 +1,42 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/lib/pq"
+)
+
+// StockAlertSetting is the days-of-cover threshold below which the owners of an airline's
+// stock at a port are alerted to replenish it.
+type StockAlertSetting struct {
+	Id            uuid.UUID      `json:"id"`
+	Liner         string         `json:"liner"`
+	Port          string         `json:"port"`
+	RegionId      string         `json:"region_id"`
+	MinDaysCover  float64        `json:"min_days_cover"`
+	OwnerEmails   pq.StringArray `json:"owner_emails" gorm:"type:text[]"`
+	LastAlertedAt *time.Time     `json:"last_alerted_at"`
+	UpdatedBy     uuid.UUID      `json:"updated_by"`
+	UpdatedAt     time.Time      `json:"updated_at"`
+}
+
+type StockConsumption struct {
+	Liner    string `json:"liner"`
+	Port     string `json:"port"`
+	RegionId string `json:"region_id"`
+	Count    int64  `json:"count"`
+}
+
+// StockForecast projects how long the available stock of an airline at a port lasts at the
+// rate it was used over the last weeks. DaysOfCover is nil when nothing was used.
+type StockForecast struct {
+	Liner        string   `json:"liner"`
+	Port         string   `json:"port"`
+	RegionId     string   `json:"region_id"`
+	Available    int64    `json:"available"`
+	UsedInWindow int64    `json:"used_in_window"`
+	DailyRate    float64  `json:"daily_rate"`
+	DaysOfCover  *float64 `json:"days_of_cover"`
+	MinDaysCover *float64 `json:"min_days_cover,omitempty"`
+}
@@ -0,0 +1,43 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// StockNumber is a new row of air_stocks created by a range or CSV import.
+type StockNumber struct {
+	Id        uuid.UUID `json:"id"`
+	Liner     string    `json:"liner"`
+	Port      string    `json:"port"`
+	RegionId  string    `json:"region_id"`
+	Status    string    `json:"status"`
+	StockNo   string    `json:"stock_no"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+type StockRangeReq struct {
+	AirLine     string `json:"airline_id"`
+	PortId      string `json:"port_id"`
+	Prefix      string `json:"prefix"`
+	StartSerial int64  `json:"start_serial"`
+	Count       int    `json:"count"`
+}
+
+type StockNumbersReq struct {
+	AirLine  string   `json:"airline_id"`
+	PortId   string   `json:"port_id"`
+	StockNos []string `json:"stock_nos"`
+}
+
+type StockImportRejection struct {
+	Row     int    `json:"row,omitempty"`
+	StockNo string `json:"stock_no"`
+	Reason  string `json:"reason"`
+}
+
+type StockImportResult struct {
+	Created  []string                `json:"created"`
+	Rejected []*StockImportRejection `json:"rejected"`
+}
@@ -0,0 +1,51 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// StockReservation is a stock number held for a shipment until its booking is confirmed or
+// the reservation expires.
+type StockReservation struct {
+	Id            uuid.UUID  `json:"id"`
+	StockNo       string     `json:"stock_no"`
+	Liner         string     `json:"liner"`
+	Port          string     `json:"port"`
+	RegionId      string     `json:"region_id"`
+	Status        string     `json:"status"`
+	ShipmentId    *uuid.UUID `json:"shipment_id"`
+	ReservedUntil *time.Time `json:"reserved_until"`
+}
+
+// StockStatusHistory is a single status change of a stock number.
+type StockStatusHistory struct {
+	Id         uuid.UUID  `json:"id"`
+	StockId    uuid.UUID  `json:"stock_id"`
+	StockNo    string     `json:"stock_no"`
+	FromStatus string     `json:"from_status"`
+	ToStatus   string     `json:"to_status"`
+	ShipmentId *uuid.UUID `json:"shipment_id"`
+	Reason     string     `json:"reason"`
+	CreatedBy  uuid.UUID  `json:"created_by"`
+	CreatedAt  time.Time  `json:"created_at"`
+}
+
+type ReserveStockReq struct {
+	AirLine    string    `json:"airline_id"`
+	PortId     string    `json:"port_id"`
+	ShipmentId uuid.UUID `json:"shipment_id"`
+	TTLMinutes int       `json:"ttl_minutes"`
+}
+
+type StockAllocationReq struct {
+	ShipmentId uuid.UUID `json:"shipment_id"`
+	StockNo    string    `json:"stock_no"`
+	Reason     string    `json:"reason"`
+}
+
+type StockNumberHistory struct {
+	Stock   *StockReservation     `json:"stock"`
+	History []*StockStatusHistory `json:"history"`
+}
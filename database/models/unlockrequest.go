@@ -0,0 +1,64 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// ShipmentUnlockRequest asks to unlock a locked shipment for part of its data until ExpiresAt.
+// The shipment is only unlocked once a finance approver of its region approves the request,
+// and it is locked again by the relock cronjob when the unlock expires.
+type ShipmentUnlockRequest struct {
+	Id           uuid.UUID  `json:"id"`
+	ShipmentId   uuid.UUID  `json:"shipment_id"`
+	RegionId     string     `json:"region_id"`
+	Scope        string     `json:"scope"`
+	Reason       string     `json:"reason"`
+	Status       string     `json:"status"`
+	RequestedBy  uuid.UUID  `json:"requested_by"`
+	RequestedAt  time.Time  `json:"requested_at"`
+	ExpiresAt    time.Time  `json:"expires_at"`
+	DecidedBy    *uuid.UUID `json:"decided_by"`
+	DecidedAt    *time.Time `json:"decided_at"`
+	DecisionNote string     `json:"decision_note"`
+	LockId       *uuid.UUID `json:"lock_id"`
+	RelockedAt   *time.Time `json:"relocked_at"`
+}
+
+type ShipmentUnlockRequestEvent struct {
+	Id         uuid.UUID `json:"id"`
+	RequestId  uuid.UUID `json:"request_id"`
+	ShipmentId uuid.UUID `json:"shipment_id"`
+	Event      string    `json:"event"`
+	Note       string    `json:"note"`
+	ActorId    string    `json:"actor_id"`
+	CreatedAt  time.Time `json:"created_at"`
+}
+
+// ShipmentUnlockApprover is a finance user allowed to approve the unlock requests of a region.
+type ShipmentUnlockApprover struct {
+	RegionId  string    `json:"region_id" gorm:"primaryKey"`
+	AccountId uuid.UUID `json:"account_id" gorm:"primaryKey"`
+	UpdatedBy uuid.UUID `json:"updated_by"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+type UnlockShipmentReq struct {
+	Scope     string    `json:"scope"`
+	Reason    string    `json:"reason"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+// UnlockDecisionReq approves or rejects a pending unlock request. An approver may shorten or
+// extend the requested unlock with ExpiresAt.
+type UnlockDecisionReq struct {
+	Approve   bool       `json:"approve"`
+	Note      string     `json:"note"`
+	ExpiresAt *time.Time `json:"expires_at"`
+}
+
+type ShipmentUnlockRequestDetail struct {
+	Request *ShipmentUnlockRequest        `json:"request"`
+	Events  []*ShipmentUnlockRequestEvent `json:"events"`
+}
@@ -6,6 +6,7 @@ import (
 
 	dtos "bitbucket.org/radarventures/forwarder-adapters/dtos/shipments"
 	"bitbucket.org/radarventures/forwarder-adapters/utils/context"
+	"bitbucket.org/radarventures/forwarder-shipments/constants"
 	billRegeneration "bitbucket.org/radarventures/forwarder-shipments/services/billregeneration"
 	"bitbucket.org/radarventures/forwarder-shipments/utils"
 	"github.com/gin-gonic/gin"
@@ -42,6 +43,10 @@ func GetBillDetails(c *context.Context) {
 
 func SaveBillDetails(c *context.Context) {
 
+	if !guardLockedShipment(c, constants.LockAreaBills, c.Query("sid")) {
+		return
+	}
+
 	req := dtos.BillDetailsReq{}
 	err := c.BindJSON(&req)
 	if err != nil {
@@ -1,11 +1,16 @@
 package handlers
 
 import (
+	"errors"
 	"net/http"
+	"strconv"
+	"time"
 
 	dtos "bitbucket.org/radarventures/forwarder-adapters/dtos/shipments"
 	"bitbucket.org/radarventures/forwarder-adapters/utils/context"
+	"bitbucket.org/radarventures/forwarder-shipments/database/models"
 	"bitbucket.org/radarventures/forwarder-shipments/services/ams"
+	"bitbucket.org/radarventures/forwarder-shipments/services/ams/amsfiling"
 	"bitbucket.org/radarventures/forwarder-shipments/utils"
 	"github.com/gin-gonic/gin"
 	"github.com/google/uuid"
@@ -92,3 +97,95 @@ func CompleteRefileAms(c *context.Context) {
 
 	c.JSON(http.StatusOK, utils.MessageRefileAmsCompleted)
 }
+
+func GetAMSFilings(c *context.Context) {
+
+	regionId := c.Query("region_id")
+	if regionId == "" && c.Account != nil {
+		regionId = c.Account.RegionID
+	}
+
+	cutOff := amsfiling.DefaultCutOff
+	if hours := c.Query("cutoff_hours"); hours != "" {
+		h, err := strconv.Atoi(hours)
+		if err != nil || h < 0 {
+			c.JSON(http.StatusBadRequest,
+				utils.GetResponse(http.StatusBadRequest, "", "cutoff_hours must be a number of hours"),
+			)
+			return
+		}
+		cutOff = time.Duration(h) * time.Hour
+	}
+
+	pg, _ := strconv.Atoi(c.Query("pg"))
+	res, err := amsfiling.NewAMSFilingService().GetFilings(c, &models.AMSFilingFilter{
+		RegionId:   regionId,
+		ShipmentId: c.Query("shipment_id"),
+		HblNo:      c.Query("hbl_no"),
+		Kind:       c.Query("kind"),
+		Stage:      c.Query("stage"),
+		Pg:         pg,
+	}, cutOff)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError,
+			utils.GetResponse(http.StatusInternalServerError, "", err.Error()),
+		)
+		return
+	}
+
+	c.JSON(http.StatusOK, res)
+}
+
+func RefileAms(c *context.Context) {
+
+	c.SetLoggingContext(c.Param("sid"), "RefileAms")
+	sid, err := uuid.Parse(c.Param("sid"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest,
+			utils.GetResponse(http.StatusBadRequest, "", utils.ErrParsingUUID.Error()),
+		)
+		return
+	}
+
+	hblNo := c.Param("hbl_no")
+	if hblNo == "" {
+		c.JSON(http.StatusBadRequest,
+			utils.GetResponse(http.StatusBadRequest, "", utils.ErrEmptyBlNo.Error()),
+		)
+		return
+	}
+
+	req := &dtos.AmsInfo{}
+	if err := c.BindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest,
+			utils.GetResponse(http.StatusBadRequest, "", utils.ErrJSONDecode.Error()),
+		)
+		return
+	}
+
+	err = amsfiling.NewAMSFilingService().Refile(c, sid, hblNo, req)
+	if err != nil {
+		var refileErr *amsfiling.RefileError
+		switch {
+		case errors.As(err, &refileErr):
+			c.JSON(http.StatusForbidden, gin.H{
+				"errs": refileErr.Errs,
+			})
+		case err == amsfiling.ErrFilingNotFound:
+			c.JSON(http.StatusNotFound,
+				utils.GetResponse(http.StatusNotFound, "", err.Error()),
+			)
+		case err == amsfiling.ErrFilingNotRejected:
+			c.JSON(http.StatusConflict,
+				utils.GetResponse(http.StatusConflict, "", err.Error()),
+			)
+		default:
+			c.JSON(http.StatusInternalServerError,
+				utils.GetResponse(http.StatusInternalServerError, "", err.Error()),
+			)
+		}
+		return
+	}
+
+	c.JSON(http.StatusOK, utils.MessageRefileAmsCompleted)
+}
@@ -0,0 +1,97 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+
+	"bitbucket.org/radarventures/forwarder-adapters/utils/context"
+	"bitbucket.org/radarventures/forwarder-shipments/services/ams/amsmail"
+	"bitbucket.org/radarventures/forwarder-shipments/utils"
+	"github.com/google/uuid"
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+)
+
+func GetAMSMailFailures(c *context.Context) {
+
+	res, err := amsmail.NewAMSMailService().GetFailures(c, c.Query("include_resolved") == "true")
+	if err != nil {
+		c.JSON(http.StatusInternalServerError,
+			utils.GetResponse(http.StatusInternalServerError, "", err.Error()),
+		)
+		return
+	}
+
+	c.JSON(http.StatusOK, res)
+}
+
+func RetryAMSMailFailure(c *context.Context) {
+
+	id, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest,
+			utils.GetResponse(http.StatusBadRequest, "", utils.ErrParsingUUID.Error()),
+		)
+		return
+	}
+
+	res, err := amsmail.NewAMSMailService().RetryFailure(c, id, amsmail.ParserConfig{
+		HBLPrefix: c.Query("hbl_prefix"),
+	})
+	if err != nil {
+		code := amsMailErrorCode(err)
+		if code == http.StatusInternalServerError {
+			c.Log.Error("Error retrying AMS mail", zap.Error(err))
+		}
+		c.JSON(code, utils.GetResponse(code, "", err.Error()))
+		return
+	}
+
+	c.JSON(http.StatusOK, res)
+}
+
+func ResolveAMSMailFailure(c *context.Context) {
+
+	id, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest,
+			utils.GetResponse(http.StatusBadRequest, "", utils.ErrParsingUUID.Error()),
+		)
+		return
+	}
+
+	req := &struct {
+		Resolution string `json:"resolution"`
+	}{}
+	if err := c.BindJSON(req); err != nil {
+		c.JSON(http.StatusBadRequest,
+			utils.GetResponse(http.StatusBadRequest, "", utils.ErrJSONDecode),
+		)
+		return
+	}
+
+	err = amsmail.NewAMSMailService().ResolveFailure(c, id, req.Resolution)
+	if err != nil {
+		code := amsMailErrorCode(err)
+		if code == http.StatusInternalServerError {
+			c.Log.Error("Error resolving AMS mail", zap.Error(err))
+		}
+		c.JSON(code, utils.GetResponse(code, "", err.Error()))
+		return
+	}
+
+	c.JSON(http.StatusOK, utils.GetResponse(http.StatusOK, "", utils.MessageResourceUpdated))
+}
+
+func amsMailErrorCode(err error) int {
+	var missing *amsmail.MissingFieldError
+	switch {
+	case err == amsmail.ErrFailureResolved:
+		return http.StatusConflict
+	case err == amsmail.ErrUnrecognisedMail, errors.Is(err, amsmail.ErrErrorCount), errors.As(err, &missing):
+		return http.StatusUnprocessableEntity
+	case errors.Is(err, gorm.ErrRecordNotFound):
+		return http.StatusNotFound
+	}
+	return http.StatusInternalServerError
+}
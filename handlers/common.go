@@ -4,7 +4,6 @@ import (
 	"bitbucket.org/radarventures/forwarder-adapters/utils/context"
 	"bitbucket.org/radarventures/forwarder-adapters/utils/log"
 	"bitbucket.org/radarventures/forwarder-shipments/config"
-	"bitbucket.org/radarventures/forwarder-shipments/constants"
 
 	"bitbucket.org/radarventures/forwarder-adapters/utils/db"
 	"github.com/google/uuid"
@@ -23,10 +22,6 @@ func LogAndGetContext(c *context.Context) {
 	c.DB = db.New()
 
 	c.TenantID = c.GetHeader("tenant_id")
-	// Local-Testing:
-	if cfg.Env == constants.Dev {
-		c.TenantID = "public"
-	}
 
 	c.RegionId = c.GetHeader("switch_region_id")
 }
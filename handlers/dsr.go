@@ -0,0 +1,152 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"time"
+
+	"bitbucket.org/radarventures/forwarder-adapters/utils/context"
+	"bitbucket.org/radarventures/forwarder-shipments/database/models"
+	"bitbucket.org/radarventures/forwarder-shipments/services/dsr"
+	"bitbucket.org/radarventures/forwarder-shipments/utils"
+	"github.com/google/uuid"
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+)
+
+func GetDSRColumns(c *context.Context) {
+	c.JSON(http.StatusOK, dsr.NewDSRService().Columns())
+}
+
+func SaveDSRSubscription(c *context.Context) {
+
+	req := &models.DSRSubscription{}
+	if err := c.BindJSON(req); err != nil {
+		c.JSON(http.StatusBadRequest,
+			utils.GetResponse(http.StatusBadRequest, "", utils.ErrJSONDecode),
+		)
+		return
+	}
+
+	res, err := dsr.NewDSRService().SaveSubscription(c, req)
+	if err != nil {
+		code := dsrErrorCode(err)
+		if code == http.StatusInternalServerError {
+			c.Log.Error("Error saving DSR subscription", zap.Error(err))
+		}
+		c.JSON(code, utils.GetResponse(code, "", err.Error()))
+		return
+	}
+
+	c.JSON(http.StatusOK, res)
+}
+
+func GetDSRSubscriptions(c *context.Context) {
+
+	companyId, err := uuid.Parse(c.Query("company_id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest,
+			utils.GetResponse(http.StatusBadRequest, "", utils.ErrParsingUUID.Error()),
+		)
+		return
+	}
+
+	res, err := dsr.NewDSRService().GetSubscriptions(c, companyId)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError,
+			utils.GetResponse(http.StatusInternalServerError, "", err.Error()),
+		)
+		return
+	}
+
+	c.JSON(http.StatusOK, res)
+}
+
+func DeleteDSRSubscription(c *context.Context) {
+
+	id, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest,
+			utils.GetResponse(http.StatusBadRequest, "", utils.ErrParsingUUID.Error()),
+		)
+		return
+	}
+
+	err = dsr.NewDSRService().DeleteSubscription(c, id)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError,
+			utils.GetResponse(http.StatusInternalServerError, "", err.Error()),
+		)
+		return
+	}
+
+	c.JSON(http.StatusOK, utils.GetResponse(http.StatusOK, "", utils.MessageResourceUpdated))
+}
+
+// PreviewDSR renders the report of a subscription as it would be sent now and downloads it.
+func PreviewDSR(c *context.Context) {
+
+	id, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest,
+			utils.GetResponse(http.StatusBadRequest, "", utils.ErrParsingUUID.Error()),
+		)
+		return
+	}
+
+	dsrService := dsr.NewDSRService()
+	sub, err := dsrService.GetSubscription(c, id)
+	if err != nil {
+		code := dsrErrorCode(err)
+		c.JSON(code, utils.GetResponse(code, "", err.Error()))
+		return
+	}
+
+	report, err := dsrService.Render(c, sub, time.Now().UTC())
+	if err != nil {
+		c.Log.Error("Error rendering DSR", zap.Error(err))
+		c.JSON(http.StatusInternalServerError,
+			utils.GetResponse(http.StatusInternalServerError, "", err.Error()),
+		)
+		return
+	}
+
+	c.Header("Content-Disposition", `attachment; filename="`+report.FileName+`"`)
+	c.Data(http.StatusOK, report.ContentType, report.Data)
+}
+
+func GetDSRDeliveries(c *context.Context) {
+
+	id, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest,
+			utils.GetResponse(http.StatusBadRequest, "", utils.ErrParsingUUID.Error()),
+		)
+		return
+	}
+
+	res, err := dsr.NewDSRService().GetDeliveries(c, id)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError,
+			utils.GetResponse(http.StatusInternalServerError, "", err.Error()),
+		)
+		return
+	}
+
+	c.JSON(http.StatusOK, res)
+}
+
+func dsrErrorCode(err error) int {
+	switch err {
+	case dsr.ErrCompanyRequired, dsr.ErrInvalidFormat, dsr.ErrInvalidFrequency, dsr.ErrInvalidSchedule,
+		dsr.ErrReceiversRequired, dsr.ErrTypesRequired:
+		return http.StatusBadRequest
+	}
+	if errors.Is(err, dsr.ErrUnknownColumn) {
+		return http.StatusBadRequest
+	}
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return http.StatusNotFound
+	}
+	return http.StatusInternalServerError
+}
@@ -0,0 +1,240 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+
+	"bitbucket.org/radarventures/forwarder-adapters/utils/context"
+	"bitbucket.org/radarventures/forwarder-shipments/database/models"
+	"bitbucket.org/radarventures/forwarder-shipments/services/gpapproval"
+	"bitbucket.org/radarventures/forwarder-shipments/utils"
+	"github.com/google/uuid"
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+)
+
+// guardGPApproval answers a share of a quote below its margin policy with a 409 and reports
+// whether the handler may go on.
+func guardGPApproval(c *context.Context, quoteId string) bool {
+	required, err := gpapproval.NewGPApprovalService().CheckShare(c, quoteId)
+	if err != nil {
+		c.Log.Error("Error checking quote GP", zap.String("quote_id", quoteId), zap.Error(err))
+		c.JSON(http.StatusInternalServerError,
+			utils.GetResponse(http.StatusInternalServerError, "", err.Error()),
+		)
+		return false
+	}
+
+	if required != nil {
+		c.Log.Info("share of quote below margin policy rejected", zap.String("quote_id", quoteId), zap.Strings("breaches", required.Breaches))
+		c.JSON(http.StatusConflict, required)
+		return false
+	}
+
+	return true
+}
+
+func SaveMarginPolicy(c *context.Context) {
+
+	req := &models.MarginPolicy{}
+	if err := c.BindJSON(req); err != nil {
+		c.JSON(http.StatusBadRequest,
+			utils.GetResponse(http.StatusBadRequest, "", utils.ErrJSONDecode),
+		)
+		return
+	}
+
+	res, err := gpapproval.NewGPApprovalService().SavePolicy(c, req)
+	if err != nil {
+		code := gpApprovalErrorCode(err)
+		if code == http.StatusInternalServerError {
+			c.Log.Error("Error saving margin policy", zap.Error(err))
+		}
+		c.JSON(code, utils.GetResponse(code, "", err.Error()))
+		return
+	}
+
+	c.JSON(http.StatusOK, res)
+}
+
+func GetMarginPolicies(c *context.Context) {
+
+	regionId := c.Query("region_id")
+	if regionId == "" {
+		regionId = c.Account.RegionID
+	}
+
+	res, err := gpapproval.NewGPApprovalService().GetPolicies(c, regionId)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError,
+			utils.GetResponse(http.StatusInternalServerError, "", err.Error()),
+		)
+		return
+	}
+
+	c.JSON(http.StatusOK, res)
+}
+
+func DeleteMarginPolicy(c *context.Context) {
+
+	id, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest,
+			utils.GetResponse(http.StatusBadRequest, "", utils.ErrParsingUUID.Error()),
+		)
+		return
+	}
+
+	err = gpapproval.NewGPApprovalService().DeletePolicy(c, id)
+	if err != nil {
+		c.Log.Error("Error deleting margin policy", zap.Error(err))
+		c.JSON(http.StatusInternalServerError,
+			utils.GetResponse(http.StatusInternalServerError, "", err.Error()),
+		)
+		return
+	}
+
+	c.JSON(http.StatusOK, utils.GetResponse(http.StatusOK, "", utils.MessageResourceUpdated))
+}
+
+func SaveMarginCustomerTier(c *context.Context) {
+
+	req := &models.MarginCustomerTier{}
+	if err := c.BindJSON(req); err != nil {
+		c.JSON(http.StatusBadRequest,
+			utils.GetResponse(http.StatusBadRequest, "", utils.ErrJSONDecode),
+		)
+		return
+	}
+
+	err := gpapproval.NewGPApprovalService().SaveTier(c, req)
+	if err != nil {
+		code := gpApprovalErrorCode(err)
+		if code == http.StatusInternalServerError {
+			c.Log.Error("Error saving margin customer tier", zap.Error(err))
+		}
+		c.JSON(code, utils.GetResponse(code, "", err.Error()))
+		return
+	}
+
+	c.JSON(http.StatusOK, utils.GetResponse(http.StatusOK, "", utils.MessageResourceUpdated))
+}
+
+// EvaluateQuoteGP returns the margin of a quote against its policy, asking for an approval
+// when it falls below it.
+func EvaluateQuoteGP(c *context.Context) {
+
+	quoteId := c.Param("qid")
+	c.SetLoggingContext(quoteId, "EvaluateQuoteGP")
+
+	res, err := gpapproval.NewGPApprovalService().EvaluateQuote(c, quoteId)
+	if err != nil {
+		code := gpApprovalErrorCode(err)
+		if code == http.StatusInternalServerError {
+			c.Log.Error("Error evaluating quote GP", zap.String("quote_id", quoteId), zap.Error(err))
+		}
+		c.JSON(code, utils.GetResponse(code, "", err.Error()))
+		return
+	}
+
+	c.JSON(http.StatusOK, res)
+}
+
+func GetGPApprovals(c *context.Context) {
+
+	res, err := gpapproval.NewGPApprovalService().GetApprovals(c, c.Param("qid"))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError,
+			utils.GetResponse(http.StatusInternalServerError, "", err.Error()),
+		)
+		return
+	}
+
+	c.JSON(http.StatusOK, res)
+}
+
+func GetGPApproval(c *context.Context) {
+
+	id, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest,
+			utils.GetResponse(http.StatusBadRequest, "", utils.ErrParsingUUID.Error()),
+		)
+		return
+	}
+
+	res, err := gpapproval.NewGPApprovalService().GetApproval(c, id)
+	if err != nil {
+		code := gpApprovalErrorCode(err)
+		c.JSON(code, utils.GetResponse(code, "", err.Error()))
+		return
+	}
+
+	c.JSON(http.StatusOK, res)
+}
+
+// GetPendingGPApprovals lists the GP approvals waiting for the decision of the caller.
+func GetPendingGPApprovals(c *context.Context) {
+
+	res, err := gpapproval.NewGPApprovalService().GetPending(c)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError,
+			utils.GetResponse(http.StatusInternalServerError, "", err.Error()),
+		)
+		return
+	}
+
+	c.JSON(http.StatusOK, res)
+}
+
+func DecideGPApproval(c *context.Context) {
+
+	if c.Account == nil || c.Account.ID == uuid.Nil {
+		c.JSON(http.StatusBadRequest, ErrEmptyAccountID)
+		return
+	}
+
+	id, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest,
+			utils.GetResponse(http.StatusBadRequest, "", utils.ErrParsingUUID.Error()),
+		)
+		return
+	}
+
+	req := &models.GPDecisionReq{}
+	if err := c.BindJSON(req); err != nil {
+		c.JSON(http.StatusBadRequest,
+			utils.GetResponse(http.StatusBadRequest, "", utils.ErrJSONDecode),
+		)
+		return
+	}
+
+	res, err := gpapproval.NewGPApprovalService().Decide(c, id, req)
+	if err != nil {
+		code := gpApprovalErrorCode(err)
+		if code == http.StatusInternalServerError {
+			c.Log.Error("Error deciding GP approval", zap.Any("approval_id", id), zap.Error(err))
+		}
+		c.JSON(code, utils.GetResponse(code, "", err.Error()))
+		return
+	}
+
+	c.JSON(http.StatusOK, res)
+}
+
+func gpApprovalErrorCode(err error) int {
+	switch err {
+	case gpapproval.ErrPolicyNameRequired, gpapproval.ErrInvalidThresholds, gpapproval.ErrApproversRequired,
+		gpapproval.ErrInvalidApprovers, gpapproval.ErrTierRequired, gpapproval.ErrDecisionRequired:
+		return http.StatusBadRequest
+	case gpapproval.ErrNotCurrentApprover, gpapproval.ErrSelfApproval:
+		return http.StatusForbidden
+	case gpapproval.ErrApprovalClosed, gpapproval.ErrApprovalConcurrency:
+		return http.StatusConflict
+	}
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return http.StatusNotFound
+	}
+	return http.StatusInternalServerError
+}
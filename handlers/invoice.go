@@ -1,12 +1,23 @@
 package handlers
 
 import (
+	"encoding/json"
 	"net/http"
+	"strconv"
+	"strings"
 
 	dtos "bitbucket.org/radarventures/forwarder-adapters/dtos/shipments"
 	"bitbucket.org/radarventures/forwarder-adapters/utils/context"
+	"bitbucket.org/radarventures/forwarder-shipments/database/models"
 	"bitbucket.org/radarventures/forwarder-shipments/services/invoice"
+	"bitbucket.org/radarventures/forwarder-shipments/services/invoice/ageing"
+	"bitbucket.org/radarventures/forwarder-shipments/services/invoice/dunning"
 	"bitbucket.org/radarventures/forwarder-shipments/services/invoice/invoicepref"
+	"bitbucket.org/radarventures/forwarder-shipments/services/invoice/notes"
+	"bitbucket.org/radarventures/forwarder-shipments/services/invoice/numberformat"
+	"bitbucket.org/radarventures/forwarder-shipments/services/invoice/numbering"
+	"bitbucket.org/radarventures/forwarder-shipments/services/invoice/payments"
+	"bitbucket.org/radarventures/forwarder-shipments/services/invoice/retrieval"
 	"bitbucket.org/radarventures/forwarder-shipments/utils"
 	"github.com/gin-gonic/gin"
 	"github.com/google/uuid"
@@ -44,12 +55,61 @@ func GetInvoice(c *context.Context) {
 		return
 	}
 
+	// Credit and debit notes with the outstanding balance are only sent when asked for,
+	// so existing consumers keep receiving the same payload.
+	if c.Query("include_notes") == "true" {
+		sid, err := uuid.Parse(c.Param("sid"))
+		if err != nil {
+			c.JSON(http.StatusBadRequest,
+				utils.GetResponse(http.StatusBadRequest, "", utils.ErrParsingUUID.Error()),
+			)
+			return
+		}
+
+		chains, err := notes.NewNoteService().GetInvoiceChains(c, sid)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{
+				"message": err.Error(),
+			})
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{
+			"invoices": res,
+			"chains":   chains,
+		})
+		return
+	}
+
 	c.JSON(http.StatusOK, res)
 }
 
 func GetInvoiceListing(c *context.Context) {
 
 	c.SetLoggingContext(c.Param("sid"), "GetInvoiceListing")
+
+	filter, err := getOutstandingFilter(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest,
+			utils.GetResponse(http.StatusBadRequest, "", err.Error()),
+		)
+		return
+	}
+
+	// Settlement filters are served from the invoice balances
+	if filter != nil {
+		res, err := payments.NewPaymentService().GetOutstandingInvoices(c, filter)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{
+				"message": err.Error(),
+			})
+			return
+		}
+
+		c.JSON(http.StatusOK, res)
+		return
+	}
+
 	res, err := invoice.NewInvoiceService().GetInvoiceListing(c)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{
@@ -82,8 +142,17 @@ func GenerateInvoice(c *context.Context) {
 	c.JSON(http.StatusCreated, nil)
 }
 
+// invoiceNumberTemplatesReq holds the invoice number templates of an invoice pref body,
+// keyed by voucher type.
+type invoiceNumberTemplatesReq struct {
+	RegionCode      string            `json:"region_code"`
+	NumberTemplates map[string]string `json:"number_templates"`
+}
+
 func SaveInvoicePref(c *context.Context) {
 
+	body := requestBody(c)
+
 	req := &dtos.InvoicePref{}
 	if err := c.BindJSON(&req); err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{
@@ -105,6 +174,17 @@ func SaveInvoicePref(c *context.Context) {
 		req.RegionId = regionId
 	}
 
+	// The region's invoice number templates ride along with its prefs
+	numberTemplates := &invoiceNumberTemplatesReq{}
+	if err := json.Unmarshal(body, numberTemplates); err == nil && numberTemplates.NumberTemplates != nil {
+		err := numberformat.NewNumberFormatService().SaveTemplates(c, req.RegionId, numberTemplates.RegionCode, numberTemplates.NumberTemplates)
+		if err != nil {
+			code := numberFormatErrorCode(err)
+			c.JSON(code, utils.GetResponse(code, "", err.Error()))
+			return
+		}
+	}
+
 	err := invoicepref.NewInvoicePrefsService().SaveInvoicePref(c, req, false)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{
@@ -179,3 +259,423 @@ func GenerateIcaInvoice(c *context.Context) {
 
 	c.JSON(http.StatusOK, res)
 }
+
+func GetInvoiceNumberFormats(c *context.Context) {
+
+	regionId := c.Query("rid")
+	if regionId == "" {
+		regionId = c.Account.RegionID
+	}
+
+	res, err := numberformat.NewNumberFormatService().GetNumberFormats(c, regionId)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError,
+			utils.GetResponse(http.StatusInternalServerError, "", err.Error()),
+		)
+		return
+	}
+
+	c.JSON(http.StatusOK, res)
+}
+
+// PreviewInvoiceNumbers renders the next n invoice numbers without consuming any of them.
+func PreviewInvoiceNumbers(c *context.Context) {
+
+	rid := c.Query("rid")
+	if rid == "" {
+		rid = c.Account.RegionID
+	}
+
+	regionId, err := uuid.Parse(rid)
+	if err != nil {
+		c.JSON(http.StatusBadRequest,
+			utils.GetResponse(http.StatusBadRequest, "", utils.ErrParsingUUID.Error()),
+		)
+		return
+	}
+
+	count := 1
+	if c.Query("n") != "" {
+		count, err = strconv.Atoi(c.Query("n"))
+		if err != nil {
+			c.JSON(http.StatusBadRequest,
+				utils.GetResponse(http.StatusBadRequest, "", err.Error()),
+			)
+			return
+		}
+	}
+
+	res, err := numberformat.NewNumberFormatService().PreviewNumbers(c, regionId, c.Query("invoice_type"), c.Query("voucher_type"), count)
+	if err != nil {
+		code := numberFormatErrorCode(err)
+		c.JSON(code, utils.GetResponse(code, "", err.Error()))
+		return
+	}
+
+	c.JSON(http.StatusOK, res)
+}
+
+// VoidInvoiceNumber records why an allocated invoice number was burned instead of issued.
+func VoidInvoiceNumber(c *context.Context) {
+
+	req := &numbering.VoidNumberReq{}
+	if err := c.BindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest,
+			utils.GetResponse(http.StatusBadRequest, "", utils.ErrJSONDecode),
+		)
+		return
+	}
+
+	if req.RegionId == uuid.Nil && c.Account != nil {
+		regionId, err := uuid.Parse(c.Account.RegionID)
+		if err != nil {
+			c.JSON(http.StatusBadRequest,
+				utils.GetResponse(http.StatusBadRequest, "", utils.ErrParsingUUID.Error()),
+			)
+			return
+		}
+		req.RegionId = regionId
+	}
+
+	res, err := numbering.NewNumberingService().Void(c, req)
+	if err != nil {
+		switch err {
+		case numbering.ErrVoidReasonRequired, numbering.ErrVoidNumberInvalid:
+			c.JSON(http.StatusBadRequest,
+				utils.GetResponse(http.StatusBadRequest, "", err.Error()),
+			)
+		case numbering.ErrVoidNumberDuplicate:
+			c.JSON(http.StatusConflict,
+				utils.GetResponse(http.StatusConflict, "", err.Error()),
+			)
+		default:
+			c.JSON(http.StatusInternalServerError,
+				utils.GetResponse(http.StatusInternalServerError, "", err.Error()),
+			)
+		}
+		return
+	}
+
+	c.JSON(http.StatusCreated, res)
+}
+
+func GetVoidedInvoiceNumbers(c *context.Context) {
+
+	rid := c.Query("rid")
+	if rid == "" {
+		rid = c.Account.RegionID
+	}
+
+	regionId, err := uuid.Parse(rid)
+	if err != nil {
+		c.JSON(http.StatusBadRequest,
+			utils.GetResponse(http.StatusBadRequest, "", utils.ErrParsingUUID.Error()),
+		)
+		return
+	}
+
+	res, err := numbering.NewNumberingService().GetVoided(c, regionId, c.Query("sequence_name"))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError,
+			utils.GetResponse(http.StatusInternalServerError, "", err.Error()),
+		)
+		return
+	}
+
+	c.JSON(http.StatusOK, res)
+}
+
+// SaveInvoiceFiscalYear sets the month a region's templated invoice sequences restart from 1.
+func SaveInvoiceFiscalYear(c *context.Context) {
+
+	req := &models.InvoiceFiscalYear{}
+	if err := c.BindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest,
+			utils.GetResponse(http.StatusBadRequest, "", utils.ErrJSONDecode),
+		)
+		return
+	}
+
+	rid := c.Query("rid")
+	if rid == "" {
+		rid = c.Account.RegionID
+	}
+
+	regionId, err := uuid.Parse(rid)
+	if err != nil {
+		c.JSON(http.StatusBadRequest,
+			utils.GetResponse(http.StatusBadRequest, "", utils.ErrParsingUUID.Error()),
+		)
+		return
+	}
+	req.RegionId = regionId
+
+	err = numbering.NewNumberingService().SaveFiscalYear(c, req)
+	if err != nil {
+		if err == numbering.ErrInvalidStartMonth {
+			c.JSON(http.StatusBadRequest,
+				utils.GetResponse(http.StatusBadRequest, "", err.Error()),
+			)
+			return
+		}
+		c.JSON(http.StatusInternalServerError,
+			utils.GetResponse(http.StatusInternalServerError, "", err.Error()),
+		)
+		return
+	}
+
+	c.JSON(http.StatusOK, utils.GetResponse(http.StatusOK, "", utils.MessageResourceUpdated))
+}
+
+// RaiseInvoiceNote raises a credit or debit note against the invoice number in the path.
+func RaiseInvoiceNote(c *context.Context) {
+
+	req := &notes.RaiseNoteReq{}
+	if err := c.BindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest,
+			utils.GetResponse(http.StatusBadRequest, "", utils.ErrJSONDecode),
+		)
+		return
+	}
+
+	c.SetLoggingContext(c.Param("no"), "RaiseInvoiceNote")
+	lineErrs, err := notes.NewNoteService().RaiseNote(c, c.Param("no"), req)
+	if len(lineErrs) > 0 {
+		c.JSON(http.StatusUnprocessableEntity, gin.H{
+			"message": err.Error(),
+			"errors":  lineErrs,
+		})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"message": err.Error(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusCreated, nil)
+}
+
+func RecordInvoicePayment(c *context.Context) {
+
+	req := &models.PaymentReceiptReq{}
+	if err := c.BindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest,
+			utils.GetResponse(http.StatusBadRequest, "", utils.ErrJSONDecode),
+		)
+		return
+	}
+
+	res, err := payments.NewPaymentService().RecordPayment(c, req)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError,
+			utils.GetResponse(http.StatusInternalServerError, "", err.Error()),
+		)
+		return
+	}
+
+	c.JSON(http.StatusCreated, res)
+}
+
+func GetInvoicePayments(c *context.Context) {
+
+	res, err := payments.NewPaymentService().GetInvoicePayments(c, c.Param("no"))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError,
+			utils.GetResponse(http.StatusInternalServerError, "", err.Error()),
+		)
+		return
+	}
+
+	c.JSON(http.StatusOK, res)
+}
+
+func SaveDunningContact(c *context.Context) {
+
+	cid, err := uuid.Parse(c.Param("cid"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest,
+			utils.GetResponse(http.StatusBadRequest, "", utils.ErrParsingUUID.Error()),
+		)
+		return
+	}
+
+	req := &models.DunningContact{}
+	if err := c.BindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest,
+			utils.GetResponse(http.StatusBadRequest, "", utils.ErrJSONDecode),
+		)
+		return
+	}
+	req.CompanyId = cid
+
+	err = dunning.NewDunningService().SaveContact(c, req)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError,
+			utils.GetResponse(http.StatusInternalServerError, "", err.Error()),
+		)
+		return
+	}
+
+	c.JSON(http.StatusOK, utils.GetResponse(http.StatusOK, "", utils.MessageResourceUpdated))
+}
+
+func GetInvoiceReminders(c *context.Context) {
+
+	res, err := dunning.NewDunningService().GetInvoiceReminders(c, c.Param("no"))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError,
+			utils.GetResponse(http.StatusInternalServerError, "", err.Error()),
+		)
+		return
+	}
+
+	c.JSON(http.StatusOK, res)
+}
+
+func GetStuckInvoiceRequests(c *context.Context) {
+
+	res, err := retrieval.NewRetrievalService().GetStuckRequests(c, c.Query("rid"), c.Query("dead_letter") == "true")
+	if err != nil {
+		c.JSON(http.StatusInternalServerError,
+			utils.GetResponse(http.StatusInternalServerError, "", err.Error()),
+		)
+		return
+	}
+
+	c.JSON(http.StatusOK, res)
+}
+
+func RetryInvoiceRequests(c *context.Context) {
+
+	req := &models.RetryInvoiceRequestsReq{}
+	if err := c.BindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest,
+			utils.GetResponse(http.StatusBadRequest, "", utils.ErrJSONDecode),
+		)
+		return
+	}
+
+	count, err := retrieval.NewRetrievalService().RetryRequests(c, req)
+	if err != nil {
+		if err == retrieval.ErrRetryIdsRequired {
+			c.JSON(http.StatusBadRequest,
+				utils.GetResponse(http.StatusBadRequest, "", err.Error()),
+			)
+			return
+		}
+		c.JSON(http.StatusInternalServerError,
+			utils.GetResponse(http.StatusInternalServerError, "", err.Error()),
+		)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"retried": count})
+}
+
+// GetARAgeingReport returns the ageing of outstanding customer invoices, as CSV with
+// format=csv.
+func GetARAgeingReport(c *context.Context) {
+
+	c.SetLoggingContext("", "GetARAgeingReport")
+	filter := &models.ARAgeingFilter{
+		CompanyIds:        splitQuery(c, "company_ids"),
+		RegionIds:         splitQuery(c, "region_ids"),
+		SalesExecutiveIds: splitQuery(c, "sales_executive_ids"),
+	}
+
+	srv := ageing.NewAgeingService()
+	res, err := srv.GetAgeingReport(c, filter)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError,
+			utils.GetResponse(http.StatusInternalServerError, "", err.Error()),
+		)
+		return
+	}
+
+	if c.Query("format") != "csv" {
+		c.JSON(http.StatusOK, res)
+		return
+	}
+
+	data, err := srv.ToCSV(res)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError,
+			utils.GetResponse(http.StatusInternalServerError, "", err.Error()),
+		)
+		return
+	}
+
+	c.Header("Content-Disposition", "attachment; filename=ar_ageing.csv")
+	c.Data(http.StatusOK, "text/csv", data)
+}
+
+func splitQuery(c *context.Context, key string) []string {
+	if c.Query(key) == "" {
+		return nil
+	}
+
+	return strings.Split(c.Query(key), ",")
+}
+
+// getOutstandingFilter reads the settlement filters of the query. It returns nil when none of
+// them are requested, and the invoices are then listed without their balances.
+func getOutstandingFilter(c *context.Context) (*models.InvoiceOutstandingFilter, error) {
+	filter := &models.InvoiceOutstandingFilter{
+		ShipmentId: c.Param("sid"),
+		RegionId:   c.Query("rid"),
+		DueFrom:    c.Query("due_from"),
+		DueTo:      c.Query("due_to"),
+	}
+	requested := filter.DueFrom != "" || filter.DueTo != ""
+
+	if c.Query("payment_status") != "" {
+		filter.Statuses = strings.Split(c.Query("payment_status"), ",")
+		requested = true
+	}
+
+	if c.Query("min_outstanding") != "" {
+		v, err := strconv.ParseFloat(c.Query("min_outstanding"), 64)
+		if err != nil {
+			return nil, err
+		}
+		filter.MinOutstanding = &v
+		requested = true
+	}
+
+	if c.Query("max_outstanding") != "" {
+		v, err := strconv.ParseFloat(c.Query("max_outstanding"), 64)
+		if err != nil {
+			return nil, err
+		}
+		filter.MaxOutstanding = &v
+		requested = true
+	}
+
+	if c.Query("min_days_overdue") != "" {
+		v, err := strconv.Atoi(c.Query("min_days_overdue"))
+		if err != nil {
+			return nil, err
+		}
+		filter.MinDaysOverdue = &v
+		requested = true
+	}
+
+	if !requested {
+		return nil, nil
+	}
+
+	return filter, nil
+}
+
+func numberFormatErrorCode(err error) int {
+	switch err {
+	case numberformat.ErrTemplateNoSeq, numberformat.ErrTemplateNoFY, numberformat.ErrTemplateToken,
+		numberformat.ErrTemplateRegion, numberformat.ErrInvalidPreviewSize:
+		return http.StatusBadRequest
+	case numberformat.ErrTemplateCollision:
+		return http.StatusConflict
+	}
+	return http.StatusInternalServerError
+}
@@ -0,0 +1,31 @@
+package handlers
+
+import (
+	"net/http"
+	"strconv"
+
+	"bitbucket.org/radarventures/forwarder-adapters/utils/context"
+	"bitbucket.org/radarventures/forwarder-shipments/database/models"
+	"bitbucket.org/radarventures/forwarder-shipments/services/jobrun"
+	"bitbucket.org/radarventures/forwarder-shipments/utils"
+)
+
+func GetJobRuns(c *context.Context) {
+	offset, _ := strconv.Atoi(c.Query("offset"))
+	limit, _ := strconv.Atoi(c.Query("limit"))
+
+	res, err := jobrun.NewJobRunService().GetJobRuns(c, &models.JobRunFilter{
+		Job:    c.Query("job"),
+		Status: c.Query("status"),
+		Offset: offset,
+		Limit:  limit,
+	})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError,
+			utils.GetResponse(http.StatusInternalServerError, "", err.Error()),
+		)
+		return
+	}
+
+	c.JSON(http.StatusOK, res)
+}
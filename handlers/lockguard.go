@@ -0,0 +1,176 @@
+package handlers
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"net/http"
+	"sort"
+
+	"bitbucket.org/radarventures/forwarder-adapters/utils/context"
+	"bitbucket.org/radarventures/forwarder-shipments/database/models"
+	"bitbucket.org/radarventures/forwarder-shipments/services/lockguard"
+	"bitbucket.org/radarventures/forwarder-shipments/utils"
+	"github.com/google/uuid"
+	"go.uber.org/zap"
+)
+
+// guardLockedShipment answers a write to the area of a locked shipment with a 423 and reports
+// whether the handler may go on. It must run before the body is bound, the top level fields of
+// the body are what is checked against the allow-list. Ids that do not parse are left to the
+// handler to reject.
+func guardLockedShipment(c *context.Context, area string, shipmentId string) bool {
+	sid, err := uuid.Parse(shipmentId)
+	if err != nil {
+		return true
+	}
+
+	locked, err := lockguard.NewLockGuardService().Check(c, sid, area, requestFields(c))
+	return allowWrite(c, locked, err)
+}
+
+// guardLockedContainer is guardLockedShipment for a write to a container of the shipment.
+func guardLockedContainer(c *context.Context) bool {
+	body := requestBody(c)
+
+	var req struct {
+		Id string `json:"id"`
+	}
+	_ = json.Unmarshal(body, &req)
+
+	containerId, err := uuid.Parse(req.Id)
+	if err != nil {
+		return true
+	}
+
+	locked, err := lockguard.NewLockGuardService().CheckContainer(c, containerId, bodyFields(body))
+	return allowWrite(c, locked, err)
+}
+
+// guardLockedQuote is guardLockedShipment for a write to the quote or line items a shipment was
+// booked from.
+func guardLockedQuote(c *context.Context, quoteId string) bool {
+	locked, err := lockguard.NewLockGuardService().CheckQuote(c, quoteId, requestFields(c))
+	return allowWrite(c, locked, err)
+}
+
+func allowWrite(c *context.Context, locked *models.ShipmentLockedError, err error) bool {
+	if err != nil {
+		c.Log.Error("Error checking shipment lock", zap.Error(err))
+		c.JSON(http.StatusInternalServerError,
+			utils.GetResponse(http.StatusInternalServerError, "", err.Error()),
+		)
+		return false
+	}
+
+	if locked != nil {
+		c.Log.Info("write to locked shipment rejected", zap.Any("shipment_id", locked.ShipmentId), zap.String("area", locked.Area), zap.Strings("blocked_fields", locked.BlockedFields))
+		c.JSON(http.StatusLocked, locked)
+		return false
+	}
+
+	return true
+}
+
+// requestBody reads the request body and puts it back for the handler to bind.
+func requestBody(c *context.Context) []byte {
+	if c.Request.Body == nil {
+		return nil
+	}
+
+	body, err := io.ReadAll(c.Request.Body)
+	if err != nil {
+		return nil
+	}
+	c.Request.Body = io.NopCloser(bytes.NewReader(body))
+
+	return body
+}
+
+func requestFields(c *context.Context) []string {
+	return bodyFields(requestBody(c))
+}
+
+// bodyFields returns the top level fields of a JSON object body, sorted.
+func bodyFields(body []byte) []string {
+	values := map[string]json.RawMessage{}
+	if err := json.Unmarshal(body, &values); err != nil {
+		return nil
+	}
+
+	fields := make([]string, 0, len(values))
+	for field := range values {
+		fields = append(fields, field)
+	}
+	sort.Strings(fields)
+
+	return fields
+}
+
+func SaveShipmentLockAllowedField(c *context.Context) {
+
+	req := &models.ShipmentLockAllowedField{}
+	if err := c.BindJSON(req); err != nil {
+		c.JSON(http.StatusBadRequest,
+			utils.GetResponse(http.StatusBadRequest, "", utils.ErrJSONDecode),
+		)
+		return
+	}
+
+	res, err := lockguard.NewLockGuardService().SaveAllowedField(c, req)
+	if err != nil {
+		if err == lockguard.ErrInvalidLockArea || err == lockguard.ErrLockFieldRequired {
+			c.JSON(http.StatusBadRequest,
+				utils.GetResponse(http.StatusBadRequest, "", err.Error()),
+			)
+			return
+		}
+		c.Log.Error("Error saving shipment lock allowed field", zap.Error(err))
+		c.JSON(http.StatusInternalServerError,
+			utils.GetResponse(http.StatusInternalServerError, "", err.Error()),
+		)
+		return
+	}
+
+	c.JSON(http.StatusOK, res)
+}
+
+func DeleteShipmentLockAllowedField(c *context.Context) {
+
+	id, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest,
+			utils.GetResponse(http.StatusBadRequest, "", utils.ErrParsingUUID.Error()),
+		)
+		return
+	}
+
+	err = lockguard.NewLockGuardService().DeleteAllowedField(c, id)
+	if err != nil {
+		c.Log.Error("Error deleting shipment lock allowed field", zap.Error(err))
+		c.JSON(http.StatusInternalServerError,
+			utils.GetResponse(http.StatusInternalServerError, "", err.Error()),
+		)
+		return
+	}
+
+	c.JSON(http.StatusOK, utils.GetResponse(http.StatusOK, "", utils.MessageResourceUpdated))
+}
+
+func GetShipmentLockAllowedFields(c *context.Context) {
+
+	regionId := c.Query("region_id")
+	if regionId == "" {
+		regionId = c.Account.RegionID
+	}
+
+	res, err := lockguard.NewLockGuardService().GetAllowedFields(c, regionId)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError,
+			utils.GetResponse(http.StatusInternalServerError, "", err.Error()),
+		)
+		return
+	}
+
+	c.JSON(http.StatusOK, res)
+}
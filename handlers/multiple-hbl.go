@@ -36,6 +36,10 @@ func GetBlInstructions(c *context.Context) {
 
 func SaveBlInstructions(c *context.Context) {
 
+	if !guardLockedShipment(c, constants.LockAreaHBL, c.Param("sid")) {
+		return
+	}
+
 	c.SetLoggingContext(c.Param("sid"), "SaveBlInstructions")
 	shipmentId, err := uuid.Parse(c.Param("sid"))
 	if err != nil {
@@ -69,6 +73,10 @@ func SaveBlInstructions(c *context.Context) {
 
 func SaveMasterContainers(c *context.Context) {
 
+	if !guardLockedShipment(c, constants.LockAreaHBL, c.Param("sid")) {
+		return
+	}
+
 	c.SetLoggingContext(c.Param("sid"), "SaveMasterContainers")
 	shipmentId, err := uuid.Parse(c.Param("sid"))
 	if err != nil {
@@ -124,6 +132,10 @@ func GetContainerMapping(c *context.Context) {
 
 func CreateBlNo(c *context.Context) {
 
+	if !guardLockedShipment(c, constants.LockAreaHBL, c.Param("sid")) {
+		return
+	}
+
 	c.SetLoggingContext(c.Param("sid"), "CreateBlNo")
 	shipmentId, err := uuid.Parse(c.Param("sid"))
 	if err != nil {
@@ -192,6 +204,10 @@ func GetBlData(c *context.Context) {
 
 func SaveMarksAndDescription(c *context.Context) {
 
+	if !guardLockedShipment(c, constants.LockAreaHBL, c.Param("sid")) {
+		return
+	}
+
 	c.SetLoggingContext(c.Param("sid"), "SaveMarksAndDescription")
 	shipmentId, err := uuid.Parse(c.Param("sid"))
 	if err != nil {
@@ -253,6 +269,10 @@ func GetHbl(c *context.Context) {
 
 func CreateHbl(c *context.Context) {
 
+	if !guardLockedShipment(c, constants.LockAreaHBL, c.Param("sid")) {
+		return
+	}
+
 	c.SetLoggingContext(c.Param("sid"), "CreateHbl")
 	shipmentId, err := uuid.Parse(c.Param("sid"))
 	if err != nil {
@@ -326,6 +346,10 @@ func GetGeneratedHblList(c *context.Context) {
 
 func DeleteBlNo(c *context.Context) {
 
+	if !guardLockedShipment(c, constants.LockAreaHBL, c.Param("sid")) {
+		return
+	}
+
 	c.SetLoggingContext(c.Param("sid"), "DeleteBlNo")
 	shipmentId, err := uuid.Parse(c.Param("sid"))
 	if err != nil {
@@ -2,6 +2,8 @@ package handlers
 import (
 	"net/http"
 	"bitbucket.org/radarventures/forwarder-adapters/utils/context"
+	"bitbucket.org/radarventures/forwarder-shipments/database/models"
+	"bitbucket.org/radarventures/forwarder-shipments/services/invoice/ageing"
 	"bitbucket.org/radarventures/forwarder-shipments/services/shipment"
 	"bitbucket.org/radarventures/forwarder-shipments/utils"
 	"github.com/google/uuid"
@@ -39,4 +41,25 @@ func GetCustomerInfoDashboard(c *context.Context) {
 		return
 	}
 	c.JSON(http.StatusOK, res)
-}
\ No newline at end of file
+}
+func GetCustomerAgeingDashboard(c *context.Context) {
+	Id := c.Params.ByName("cid")
+	companyId, err := uuid.Parse(Id)
+	if err != nil {
+		c.Log.Error("unable to parse uuid", zap.Error(err))
+		c.JSON(http.StatusBadRequest,
+			utils.GetResponse(http.StatusBadRequest, "", utils.ErrParsingUUID.Error()),
+		)
+		return
+	}
+	res, err := ageing.NewAgeingService().GetAgeingReport(c, &models.ARAgeingFilter{
+		CompanyIds: []string{companyId.String()},
+	})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError,
+			utils.GetResponse(http.StatusInternalServerError, "", err.Error()),
+		)
+		return
+	}
+	c.JSON(http.StatusOK, res)
+}
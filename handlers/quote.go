@@ -7,10 +7,18 @@ import (
 
 	dtos "bitbucket.org/radarventures/forwarder-adapters/dtos/shipments"
 	"bitbucket.org/radarventures/forwarder-adapters/utils/context"
+	"bitbucket.org/radarventures/forwarder-shipments/constants"
+	"bitbucket.org/radarventures/forwarder-shipments/database/models"
+	"bitbucket.org/radarventures/forwarder-shipments/services/gpapproval"
 	"bitbucket.org/radarventures/forwarder-shipments/services/quote"
+	"bitbucket.org/radarventures/forwarder-shipments/services/quoteversion"
 	"bitbucket.org/radarventures/forwarder-shipments/services/shipment"
 	"bitbucket.org/radarventures/forwarder-shipments/utils"
+	"github.com/gin-gonic/gin"
+	"github.com/gin-gonic/gin/binding"
 	"github.com/google/uuid"
+	"go.uber.org/zap"
+	"gorm.io/gorm"
 )
 
 func CreateQuote(c *context.Context) {
@@ -39,6 +47,10 @@ func CreateQuote(c *context.Context) {
 }
 
 func UpdateQuote(c *context.Context) {
+	if !guardLockedQuote(c, c.Params.ByName("qid")) {
+		return
+	}
+
 	req := &dtos.Quote{}
 	if err := c.BindJSON(&req); err != nil {
 		c.JSON(http.StatusBadRequest,
@@ -77,6 +89,12 @@ func UpdateQuote(c *context.Context) {
 		}
 	}
 
+	// The quote is saved, a failed evaluation is retried when the quote is shared
+	_, err = gpapproval.NewGPApprovalService().EvaluateQuote(c, req.ID)
+	if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+		c.Log.Error("Error evaluating quote GP", zap.String("quote_id", req.ID), zap.Error(err))
+	}
+
 	c.JSON(http.StatusCreated,
 		utils.GetResponse(http.StatusCreated, id, utils.MessageResourceUpdated),
 	)
@@ -105,10 +123,134 @@ func PreviewPDF(c *context.Context) {
 		)
 		return
 	}
+
+	// revision=true adds the "revised quote" summary of the sell side since revision_from,
+	// or since the previous version, with the section the PDF renders it as
+	if c.Query("revision") == "true" {
+		from, err := queryVersion(c, "revision_from")
+		if err != nil {
+			c.JSON(http.StatusBadRequest,
+				utils.GetResponse(http.StatusBadRequest, "", err.Error()),
+			)
+			return
+		}
+
+		summary, err := quoteversion.NewQuoteVersionService().Revision(c, req.ID, quoteRegion(c), from)
+		if err != nil {
+			code := quoteVersionErrorCode(err)
+			c.JSON(code, utils.GetResponse(code, "", err.Error()))
+			return
+		}
+
+		section, err := quoteversion.RenderRevision(summary)
+		if err != nil {
+			c.Log.Error("Error rendering quote revision", zap.String("quote_id", req.ID), zap.Error(err))
+			c.JSON(http.StatusInternalServerError,
+				utils.GetResponse(http.StatusInternalServerError, "", err.Error()),
+			)
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{
+			"preview":       resp,
+			"revision":      summary,
+			"revision_html": section,
+		})
+		return
+	}
 	c.JSON(http.StatusOK, resp)
 
 }
 
+func GetQuoteVersions(c *context.Context) {
+
+	res, err := quoteversion.NewQuoteVersionService().GetVersions(c, c.Param("qid"))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError,
+			utils.GetResponse(http.StatusInternalServerError, "", err.Error()),
+		)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"versions": res,
+	})
+}
+
+// GetQuoteVersionDiff compares the line items of two versions of a quote, by default the
+// latest against the one before it.
+func GetQuoteVersionDiff(c *context.Context) {
+
+	quoteId := c.Param("qid")
+	if err := uuid.Validate(quoteId); err != nil {
+		c.JSON(http.StatusBadRequest,
+			utils.GetResponse(http.StatusBadRequest, "", utils.ErrParsingUUID.Error()),
+		)
+		return
+	}
+
+	from, err := queryVersion(c, "from")
+	if err != nil {
+		c.JSON(http.StatusBadRequest,
+			utils.GetResponse(http.StatusBadRequest, "", err.Error()),
+		)
+		return
+	}
+	to, err := queryVersion(c, "to")
+	if err != nil {
+		c.JSON(http.StatusBadRequest,
+			utils.GetResponse(http.StatusBadRequest, "", err.Error()),
+		)
+		return
+	}
+
+	res, err := quoteversion.NewQuoteVersionService().Diff(c, quoteId, quoteRegion(c), from, to)
+	if err != nil {
+		code := quoteVersionErrorCode(err)
+		if code == http.StatusInternalServerError {
+			c.Log.Error("Error comparing quote versions", zap.Error(err))
+		}
+		c.JSON(code, utils.GetResponse(code, "", err.Error()))
+		return
+	}
+
+	c.JSON(http.StatusOK, res)
+}
+
+func queryVersion(c *context.Context, name string) (int64, error) {
+	raw := c.Query(name)
+	if raw == "" {
+		return 0, nil
+	}
+
+	version, err := strconv.ParseInt(raw, 10, 64)
+	if err != nil || version < 0 {
+		return 0, errors.New(name + " must be a quote version")
+	}
+
+	return version, nil
+}
+
+func quoteRegion(c *context.Context) string {
+	if regionId := c.Query("region_id"); regionId != "" {
+		return regionId
+	}
+	if c.Account != nil {
+		return c.Account.RegionID
+	}
+	return ""
+}
+
+func quoteVersionErrorCode(err error) int {
+	switch err {
+	case quoteversion.ErrRegionRequired, quoteversion.ErrSameVersion, quoteversion.ErrNoPreviousVersion:
+		return http.StatusBadRequest
+	case quoteversion.ErrVersionNotFound:
+		return http.StatusNotFound
+	}
+	return http.StatusInternalServerError
+}
+
 func GeneratePDF(c *context.Context) {
 
 	req := &dtos.GenerateQuotePDF{}
@@ -136,6 +278,9 @@ func GeneratePDF(c *context.Context) {
 }
 
 func ShareQuote(c *context.Context) {
+	if !guardGPApproval(c, c.Params.ByName("qid")) {
+		return
+	}
 
 	req := &dtos.QuoteShareReq{}
 	if err := c.BindJSON(&req); err != nil {
@@ -225,6 +370,9 @@ func GetQuoteWithLineItems(c *context.Context) {
 	c.JSON(http.StatusOK, res)
 }
 
+// ApproveRejectGPForShipmentAndQuote decides the GP of a quote. A quote below its margin
+// policy is decided through its approval chain, and the quote's GP flag is only set once the
+// last approver approved it or one rejected it.
 func ApproveRejectGPForShipmentAndQuote(c *context.Context) {
 
 	quoteId := c.Param("qid")
@@ -238,16 +386,40 @@ func ApproveRejectGPForShipmentAndQuote(c *context.Context) {
 	}
 
 	req := &dtos.GPApproveRejectReq{}
-	if err := c.BindJSON(&req); err != nil {
+	if err := c.ShouldBindBodyWith(&req, binding.JSON); err != nil {
 		c.JSON(http.StatusInternalServerError,
 			utils.GetResponse(http.StatusBadRequest, "", err.Error()),
 		)
 		return
 	}
 
+	decision := &models.GPDecisionReq{}
+	if err := c.ShouldBindBodyWith(decision, binding.JSON); err != nil {
+		c.JSON(http.StatusBadRequest,
+			utils.GetResponse(http.StatusBadRequest, "", utils.ErrJSONDecode),
+		)
+		return
+	}
+
 	req.QuoteID = quoteId
 
-	err := quote.NewQuoteService().HandleGpApproveReject(c, req)
+	approval, err := gpapproval.NewGPApprovalService().DecideQuote(c, quoteId, decision)
+	if err != nil {
+		code := gpApprovalErrorCode(err)
+		if code == http.StatusInternalServerError {
+			c.Log.Error("Error deciding quote GP approval", zap.String("quote_id", quoteId), zap.Error(err))
+		}
+		c.JSON(code, utils.GetResponse(code, "", err.Error()))
+		return
+	}
+
+	// The next approver of the chain still has to decide
+	if approval != nil && approval.Status == constants.GPApprovalPending {
+		c.JSON(http.StatusOK, approval)
+		return
+	}
+
+	err = quote.NewQuoteService().HandleGpApproveReject(c, req)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError,
 			utils.GetResponse(http.StatusInternalServerError, "", err.Error()),
@@ -255,6 +427,11 @@ func ApproveRejectGPForShipmentAndQuote(c *context.Context) {
 		return
 	}
 
+	if approval != nil {
+		c.JSON(http.StatusOK, approval)
+		return
+	}
+
 	c.JSON(http.StatusOK, "")
 }
 
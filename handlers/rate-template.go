@@ -1,13 +1,23 @@
 package handlers
 
 import (
+	"encoding/csv"
+	"errors"
+	"io"
 	"net/http"
+	"path/filepath"
+	"strings"
+	"time"
 
 	dtos "bitbucket.org/radarventures/forwarder-adapters/dtos/shipments"
 	"bitbucket.org/radarventures/forwarder-adapters/utils/context"
+	"bitbucket.org/radarventures/forwarder-shipments/database/models"
 	ratetemplate "bitbucket.org/radarventures/forwarder-shipments/services/rate-template"
+	"bitbucket.org/radarventures/forwarder-shipments/services/ratepricing"
 	"bitbucket.org/radarventures/forwarder-shipments/utils"
 	"github.com/google/uuid"
+	"go.uber.org/zap"
+	"gorm.io/gorm"
 )
 
 func GetRateTemplates(c *context.Context) {
@@ -84,3 +94,170 @@ func GetRateTemplate(c *context.Context) {
 
 	c.JSON(http.StatusOK, rateTemplates)
 }
+
+func SaveRateTemplatePricing(c *context.Context) {
+
+	templateId, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest,
+			utils.GetResponse(http.StatusBadRequest, "", utils.ErrParsingUUID.Error()),
+		)
+		return
+	}
+
+	req := &models.RateTemplatePricing{}
+	if err := c.BindJSON(req); err != nil {
+		c.JSON(http.StatusBadRequest,
+			utils.GetResponse(http.StatusBadRequest, "", utils.ErrJSONDecode),
+		)
+		return
+	}
+
+	res, err := ratepricing.NewRatePricingService().SavePricing(c, templateId, req)
+	if err != nil {
+		code := ratePricingErrorCode(err)
+		c.JSON(code, utils.GetResponse(code, "", err.Error()))
+		return
+	}
+
+	c.JSON(http.StatusOK, res)
+}
+
+func GetRateTemplatePricing(c *context.Context) {
+
+	templateId, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest,
+			utils.GetResponse(http.StatusBadRequest, "", utils.ErrParsingUUID.Error()),
+		)
+		return
+	}
+
+	res, err := ratepricing.NewRatePricingService().GetPricing(c, templateId)
+	if err != nil {
+		code := ratePricingErrorCode(err)
+		c.JSON(code, utils.GetResponse(code, "", err.Error()))
+		return
+	}
+
+	c.JSON(http.StatusOK, res)
+}
+
+// PriceRfq returns the buy and sell line items of an RFQ priced from its best matching rate
+// template, on the given date or today.
+func PriceRfq(c *context.Context) {
+
+	rfqId := c.Param("id")
+	if err := uuid.Validate(rfqId); err != nil {
+		c.JSON(http.StatusBadRequest,
+			utils.GetResponse(http.StatusBadRequest, "", utils.ErrParsingUUID.Error()),
+		)
+		return
+	}
+
+	on := time.Now().UTC()
+	if date := c.Query("date"); date != "" {
+		var err error
+		on, err = time.Parse(time.DateOnly, date)
+		if err != nil {
+			c.JSON(http.StatusBadRequest,
+				utils.GetResponse(http.StatusBadRequest, "", "date must be YYYY-MM-DD"),
+			)
+			return
+		}
+	}
+
+	res, err := ratepricing.NewRatePricingService().PriceRfq(c, rfqId, on)
+	if err != nil {
+		code := ratePricingErrorCode(err)
+		c.JSON(code, utils.GetResponse(code, "", err.Error()))
+		return
+	}
+
+	c.JSON(http.StatusOK, res)
+}
+
+func ratePricingErrorCode(err error) int {
+	switch {
+	case errors.Is(err, ratepricing.ErrCurrencyRequired), errors.Is(err, ratepricing.ErrInvalidValidity),
+		errors.Is(err, ratepricing.ErrInvalidBasis), errors.Is(err, ratepricing.ErrInvalidRate),
+		errors.Is(err, ratepricing.ErrInvalidSlabs), errors.Is(err, ratepricing.ErrRateSheetFormat),
+		errors.Is(err, ratepricing.ErrRateSheetEmpty), errors.Is(err, ratepricing.ErrRateSheetColumns),
+		errors.Is(err, ratepricing.ErrRateSheetTooLarge), errors.Is(err, ratepricing.ErrRegionRequired),
+		errors.Is(err, ratepricing.ErrInvalidWorkbook), errors.Is(err, ratepricing.ErrWorkbookTooLarge):
+		return http.StatusBadRequest
+	case errors.Is(err, ratepricing.ErrNoMatchingTemplate), errors.Is(err, gorm.ErrRecordNotFound):
+		return http.StatusNotFound
+	}
+	if _, ok := err.(*csv.ParseError); ok {
+		return http.StatusBadRequest
+	}
+	return http.StatusInternalServerError
+}
+
+// ImportRateSheet diffs an uploaded CSV or XLSX rate sheet against the region's rate
+// templates. It is a dry run unless dry_run=false; a sheet with row errors is then answered
+// with 422 and nothing applied.
+func ImportRateSheet(c *context.Context) {
+
+	file, err := c.FormFile("file")
+	if err != nil {
+		c.JSON(http.StatusBadRequest,
+			utils.GetResponse(http.StatusBadRequest, "", "csv or xlsx file is required"),
+		)
+		return
+	}
+
+	format := c.Query("format")
+	if format == "" {
+		format = strings.TrimPrefix(strings.ToLower(filepath.Ext(file.Filename)), ".")
+	}
+
+	regionId := c.Query("region_id")
+	if regionId == "" && c.Account != nil {
+		regionId = c.Account.RegionID
+	}
+
+	f, err := file.Open()
+	if err != nil {
+		c.Log.Error("Error opening rate sheet", zap.Error(err))
+		c.JSON(http.StatusInternalServerError,
+			utils.GetResponse(http.StatusInternalServerError, "", err.Error()),
+		)
+		return
+	}
+	defer f.Close()
+
+	data, err := io.ReadAll(io.LimitReader(f, ratepricing.MaxRateSheetBytes+1))
+	if err != nil {
+		c.Log.Error("Error reading rate sheet", zap.Error(err))
+		c.JSON(http.StatusInternalServerError,
+			utils.GetResponse(http.StatusInternalServerError, "", err.Error()),
+		)
+		return
+	}
+	if len(data) > ratepricing.MaxRateSheetBytes {
+		c.JSON(http.StatusBadRequest,
+			utils.GetResponse(http.StatusBadRequest, "", ratepricing.ErrRateSheetTooBig.Error()),
+		)
+		return
+	}
+
+	dryRun := c.Query("dry_run") != "false"
+	res, err := ratepricing.NewRatePricingService().ImportRateSheet(c, data, format, regionId, dryRun)
+	if err != nil {
+		code := ratePricingErrorCode(err)
+		if code == http.StatusInternalServerError {
+			c.Log.Error("Error importing rate sheet", zap.Error(err))
+		}
+		c.JSON(code, utils.GetResponse(code, "", err.Error()))
+		return
+	}
+
+	if !dryRun && !res.Applied {
+		c.JSON(http.StatusUnprocessableEntity, res)
+		return
+	}
+
+	c.JSON(http.StatusOK, res)
+}
@@ -1,13 +1,16 @@
 package handlers
 
 import (
+	"errors"
 	"net/http"
 	"strings"
+	"time"
 
 	dtos "bitbucket.org/radarventures/forwarder-adapters/dtos/shipments"
 	"bitbucket.org/radarventures/forwarder-adapters/utils/context"
 	"bitbucket.org/radarventures/forwarder-shipments/constants"
 	"bitbucket.org/radarventures/forwarder-shipments/services/charges"
+	"bitbucket.org/radarventures/forwarder-shipments/services/ratepricing"
 	"bitbucket.org/radarventures/forwarder-shipments/services/rfq"
 	"bitbucket.org/radarventures/forwarder-shipments/utils"
 	"github.com/gin-gonic/gin"
@@ -178,6 +181,25 @@ func GetRfQDefaultLineItems(c *context.Context) {
 	}
 	c.SetLoggingContext(rfqId, "GetRfQDefaultLineItems")
 
+	// source=template prices the line items from the best matching rate template, falling
+	// back to the default charges when none applies
+	if c.Query("source") == "template" {
+		pricing, err := ratepricing.NewRatePricingService().PriceRfq(c, rfqId, time.Now().UTC())
+		if err == nil {
+			c.JSON(http.StatusOK, gin.H{
+				"line_items":  append(pricing.Buy, pricing.Sell...),
+				"template_id": pricing.TemplateId,
+			})
+			return
+		}
+		if !errors.Is(err, ratepricing.ErrNoMatchingTemplate) {
+			c.JSON(http.StatusInternalServerError, gin.H{
+				"message": err.Error(),
+			})
+			return
+		}
+	}
+
 	ch := charges.New()
 	res, err := ch.GetRfqDefaultCharges(c, rfqId)
 	if err != nil {
@@ -1,6 +1,7 @@
 package handlers
 
 import (
+	"errors"
 	"net/http"
 	"strconv"
 	"strings"
@@ -8,10 +9,12 @@ import (
 	dtos "bitbucket.org/radarventures/forwarder-adapters/dtos/shipments"
 	"bitbucket.org/radarventures/forwarder-adapters/utils/context"
 	"bitbucket.org/radarventures/forwarder-shipments/config/globals"
+	"bitbucket.org/radarventures/forwarder-shipments/constants"
 	"bitbucket.org/radarventures/forwarder-shipments/services/airwaybillinfo"
 	"bitbucket.org/radarventures/forwarder-shipments/services/charges"
 	"bitbucket.org/radarventures/forwarder-shipments/services/document"
 	globalaccounting "bitbucket.org/radarventures/forwarder-shipments/services/global-accounting"
+	"bitbucket.org/radarventures/forwarder-shipments/services/gpapproval"
 	"bitbucket.org/radarventures/forwarder-shipments/services/quote"
 	"bitbucket.org/radarventures/forwarder-shipments/services/shipment"
 	"bitbucket.org/radarventures/forwarder-shipments/services/shipmentcontainer"
@@ -19,6 +22,7 @@ import (
 	"github.com/gin-gonic/gin"
 	"github.com/google/uuid"
 	"go.uber.org/zap"
+	"gorm.io/gorm"
 )
 
 var (
@@ -300,6 +304,10 @@ func DeleteConsol(c *context.Context) {
 }
 
 func EditShipment(c *context.Context) {
+	if !guardLockedShipment(c, constants.LockAreaShipment, c.Param("sid")) {
+		return
+	}
+
 	req := &dtos.Shipment{}
 
 	if err := c.BindJSON(&req); err != nil {
@@ -608,6 +616,10 @@ func GetConsolJobAccounting(c *context.Context) {
 
 func UpsertDocument(c *context.Context) {
 
+	if !guardLockedShipment(c, constants.LockAreaDocuments, c.Param("instance_id")) {
+		return
+	}
+
 	req := &dtos.Document{}
 	if err := c.BindJSON(&req); err != nil {
 		c.JSON(http.StatusBadRequest, err.Error())
@@ -634,6 +646,11 @@ func UpsertDocument(c *context.Context) {
 func UpdateETAETD(c *context.Context) {
 
 	c.SetLoggingContext(c.Param("sid"), "UpdateETAETD")
+
+	if !guardLockedShipment(c, constants.LockAreaShipment, c.Param("sid")) {
+		return
+	}
+
 	req := &dtos.Quote{}
 	if err := c.BindJSON(&req); err != nil {
 		c.JSON(http.StatusBadRequest, err.Error())
@@ -723,6 +740,10 @@ func AddPartnerInvoices(c *context.Context) {
 		return
 	}
 
+	if !guardLockedShipment(c, constants.LockAreaCharges, c.Param("sid")) {
+		return
+	}
+
 	req := &dtos.PartnerInvoices{}
 	if err := c.BindJSON(&req); err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{
@@ -744,6 +765,12 @@ func AddPartnerInvoices(c *context.Context) {
 		return
 	}
 
+	// The invoices are saved, a failed evaluation is retried when the quote is shared
+	_, err = gpapproval.NewGPApprovalService().EvaluateShipment(c, req.InstanceId)
+	if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+		c.Log.Error("Error evaluating shipment GP", zap.String("shipment_id", req.InstanceId), zap.Error(err))
+	}
+
 	c.JSON(http.StatusOK, res)
 
 }
@@ -937,8 +964,14 @@ func GenerateFreightCertificate(c *context.Context) {
 	c.JSON(http.StatusOK, res)
 }
 
+// UpdateShipmentLock locks or unlocks a shipment by hand. Only the region's finance
+// approvers may do so, everyone else asks through an unlock request.
 func UpdateShipmentLock(c *context.Context) {
 
+	if !guardUnlockApprover(c) {
+		return
+	}
+
 	shipmentLockReq := &dtos.ShipmentLock{}
 
 	if err := c.BindJSON(&shipmentLockReq); err != nil {
@@ -983,6 +1016,10 @@ func PreAlert(c *context.Context) {
 
 func UpdateContainer(c *context.Context) {
 
+	if !guardLockedContainer(c) {
+		return
+	}
+
 	shipmentContainers := &dtos.ShipmentContainer{}
 
 	if err := c.BindJSON(&shipmentContainers); err != nil {
@@ -1052,6 +1089,9 @@ func UpateCompanyNameForShipmentsAndRfqs(c *context.Context) {
 }
 
 func DeleteDocuments(c *context.Context) {
+	if !guardLockedShipment(c, constants.LockAreaDocuments, c.Param("instance_id")) {
+		return
+	}
 	instanceId := c.Param("instance_id")
 	flowInstanceId := c.Param("flow_instance_id")
 
@@ -0,0 +1,119 @@
+package handlers
+
+import (
+	"net/http"
+
+	"bitbucket.org/radarventures/forwarder-adapters/utils/context"
+	"bitbucket.org/radarventures/forwarder-shipments/database/models"
+	"bitbucket.org/radarventures/forwarder-shipments/services/lockpolicy"
+	"bitbucket.org/radarventures/forwarder-shipments/utils"
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"go.uber.org/zap"
+)
+
+func SaveShipmentLockPolicy(c *context.Context) {
+
+	req := &models.ShipmentLockPolicy{}
+	if err := c.BindJSON(req); err != nil {
+		c.JSON(http.StatusBadRequest,
+			utils.GetResponse(http.StatusBadRequest, "", utils.ErrJSONDecode),
+		)
+		return
+	}
+
+	res, err := lockpolicy.NewLockPolicyService().SavePolicy(c, req)
+	if err != nil {
+		if err == lockpolicy.ErrPolicyNameRequired || err == lockpolicy.ErrPolicyHasNoRule || err == lockpolicy.ErrInvalidGracePeriod {
+			c.JSON(http.StatusBadRequest,
+				utils.GetResponse(http.StatusBadRequest, "", err.Error()),
+			)
+			return
+		}
+		c.Log.Error("Error saving shipment lock policy", zap.Error(err))
+		c.JSON(http.StatusInternalServerError,
+			utils.GetResponse(http.StatusInternalServerError, "", err.Error()),
+		)
+		return
+	}
+
+	c.JSON(http.StatusOK, res)
+}
+
+func GetShipmentLockPolicies(c *context.Context) {
+
+	regionId := c.Query("region_id")
+	if regionId == "" {
+		regionId = c.Account.RegionID
+	}
+
+	res, err := lockpolicy.NewLockPolicyService().GetPolicies(c, regionId)
+	if err != nil {
+		c.Log.Error("Error fetching shipment lock policies", zap.Error(err))
+		c.JSON(http.StatusInternalServerError,
+			utils.GetResponse(http.StatusInternalServerError, "", err.Error()),
+		)
+		return
+	}
+
+	c.JSON(http.StatusOK, res)
+}
+
+func DeleteShipmentLockPolicy(c *context.Context) {
+
+	id, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest,
+			utils.GetResponse(http.StatusBadRequest, "", utils.ErrParsingUUID.Error()),
+		)
+		return
+	}
+
+	err = lockpolicy.NewLockPolicyService().DeletePolicy(c, id)
+	if err != nil {
+		c.Log.Error("Error deleting shipment lock policy", zap.Error(err))
+		c.JSON(http.StatusInternalServerError,
+			utils.GetResponse(http.StatusInternalServerError, "", err.Error()),
+		)
+		return
+	}
+
+	c.JSON(http.StatusOK, utils.GetResponse(http.StatusOK, "", utils.MessageResourceUpdated))
+}
+
+// ExplainShipmentLock tells whether the policies of the shipment's region would lock it now,
+// and which policies locked it before.
+func ExplainShipmentLock(c *context.Context) {
+
+	sid, err := uuid.Parse(c.Param("sid"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest,
+			utils.GetResponse(http.StatusBadRequest, "", utils.ErrParsingUUID.Error()),
+		)
+		return
+	}
+
+	service := lockpolicy.NewLockPolicyService()
+	evaluation, err := service.EvaluateShipment(c, sid.String())
+	if err != nil {
+		c.Log.Error("Error evaluating shipment lock", zap.Any("shipment_id", sid), zap.Error(err))
+		c.JSON(http.StatusInternalServerError,
+			utils.GetResponse(http.StatusInternalServerError, "", err.Error()),
+		)
+		return
+	}
+
+	decisions, err := service.GetDecisions(c, sid)
+	if err != nil {
+		c.Log.Error("Error fetching shipment lock decisions", zap.Any("shipment_id", sid), zap.Error(err))
+		c.JSON(http.StatusInternalServerError,
+			utils.GetResponse(http.StatusInternalServerError, "", err.Error()),
+		)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"evaluation": evaluation,
+		"decisions":  decisions,
+	})
+}
@@ -0,0 +1,248 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+
+	"bitbucket.org/radarventures/forwarder-adapters/utils/context"
+	"bitbucket.org/radarventures/forwarder-shipments/database/models"
+	"bitbucket.org/radarventures/forwarder-shipments/services/unlockrequest"
+	"bitbucket.org/radarventures/forwarder-shipments/utils"
+	"github.com/google/uuid"
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+)
+
+func RequestShipmentUnlock(c *context.Context) {
+
+	sid, err := uuid.Parse(c.Param("sid"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest,
+			utils.GetResponse(http.StatusBadRequest, "", utils.ErrParsingUUID.Error()),
+		)
+		return
+	}
+
+	req := &models.UnlockShipmentReq{}
+	if err := c.BindJSON(req); err != nil {
+		c.JSON(http.StatusBadRequest,
+			utils.GetResponse(http.StatusBadRequest, "", utils.ErrJSONDecode),
+		)
+		return
+	}
+
+	res, err := unlockrequest.NewUnlockRequestService().RequestUnlock(c, sid, req)
+	if err != nil {
+		code := unlockRequestErrorCode(err)
+		if code == http.StatusInternalServerError {
+			c.Log.Error("Error requesting shipment unlock", zap.Any("shipment_id", sid), zap.Error(err))
+		}
+		c.JSON(code, utils.GetResponse(code, "", err.Error()))
+		return
+	}
+
+	c.JSON(http.StatusOK, res)
+}
+
+func DecideShipmentUnlock(c *context.Context) {
+
+	id, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest,
+			utils.GetResponse(http.StatusBadRequest, "", utils.ErrParsingUUID.Error()),
+		)
+		return
+	}
+
+	req := &models.UnlockDecisionReq{}
+	if err := c.BindJSON(req); err != nil {
+		c.JSON(http.StatusBadRequest,
+			utils.GetResponse(http.StatusBadRequest, "", utils.ErrJSONDecode),
+		)
+		return
+	}
+
+	res, err := unlockrequest.NewUnlockRequestService().Decide(c, id, req)
+	if err != nil {
+		code := unlockRequestErrorCode(err)
+		if code == http.StatusInternalServerError {
+			c.Log.Error("Error deciding shipment unlock request", zap.Any("request_id", id), zap.Error(err))
+		}
+		c.JSON(code, utils.GetResponse(code, "", err.Error()))
+		return
+	}
+
+	c.JSON(http.StatusOK, res)
+}
+
+func CancelShipmentUnlock(c *context.Context) {
+
+	id, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest,
+			utils.GetResponse(http.StatusBadRequest, "", utils.ErrParsingUUID.Error()),
+		)
+		return
+	}
+
+	res, err := unlockrequest.NewUnlockRequestService().Cancel(c, id, c.Query("note"))
+	if err != nil {
+		code := unlockRequestErrorCode(err)
+		if code == http.StatusInternalServerError {
+			c.Log.Error("Error cancelling shipment unlock request", zap.Any("request_id", id), zap.Error(err))
+		}
+		c.JSON(code, utils.GetResponse(code, "", err.Error()))
+		return
+	}
+
+	c.JSON(http.StatusOK, res)
+}
+
+func GetShipmentUnlockRequests(c *context.Context) {
+
+	sid, err := uuid.Parse(c.Param("sid"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest,
+			utils.GetResponse(http.StatusBadRequest, "", utils.ErrParsingUUID.Error()),
+		)
+		return
+	}
+
+	res, err := unlockrequest.NewUnlockRequestService().GetRequests(c, sid)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError,
+			utils.GetResponse(http.StatusInternalServerError, "", err.Error()),
+		)
+		return
+	}
+
+	c.JSON(http.StatusOK, res)
+}
+
+func GetShipmentUnlockRequest(c *context.Context) {
+
+	id, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest,
+			utils.GetResponse(http.StatusBadRequest, "", utils.ErrParsingUUID.Error()),
+		)
+		return
+	}
+
+	res, err := unlockrequest.NewUnlockRequestService().GetRequest(c, id)
+	if err != nil {
+		code := unlockRequestErrorCode(err)
+		c.JSON(code, utils.GetResponse(code, "", err.Error()))
+		return
+	}
+
+	c.JSON(http.StatusOK, res)
+}
+
+// GetPendingShipmentUnlocks lists the unlock requests waiting for a finance approver of the
+// caller's region.
+func GetPendingShipmentUnlocks(c *context.Context) {
+
+	res, err := unlockrequest.NewUnlockRequestService().GetPending(c, c.Account.RegionID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError,
+			utils.GetResponse(http.StatusInternalServerError, "", err.Error()),
+		)
+		return
+	}
+
+	c.JSON(http.StatusOK, res)
+}
+
+func SaveShipmentUnlockApprover(c *context.Context) {
+
+	accountId, err := uuid.Parse(c.Param("accountId"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest,
+			utils.GetResponse(http.StatusBadRequest, "", utils.ErrParsingUUID.Error()),
+		)
+		return
+	}
+
+	err = unlockrequest.NewUnlockRequestService().SaveApprover(c, c.Account.RegionID, accountId)
+	if err != nil {
+		c.Log.Error("Error saving shipment unlock approver", zap.Error(err))
+		c.JSON(http.StatusInternalServerError,
+			utils.GetResponse(http.StatusInternalServerError, "", err.Error()),
+		)
+		return
+	}
+
+	c.JSON(http.StatusOK, utils.GetResponse(http.StatusOK, "", utils.MessageResourceUpdated))
+}
+
+func DeleteShipmentUnlockApprover(c *context.Context) {
+
+	accountId, err := uuid.Parse(c.Param("accountId"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest,
+			utils.GetResponse(http.StatusBadRequest, "", utils.ErrParsingUUID.Error()),
+		)
+		return
+	}
+
+	err = unlockrequest.NewUnlockRequestService().DeleteApprover(c, c.Account.RegionID, accountId)
+	if err != nil {
+		c.Log.Error("Error deleting shipment unlock approver", zap.Error(err))
+		c.JSON(http.StatusInternalServerError,
+			utils.GetResponse(http.StatusInternalServerError, "", err.Error()),
+		)
+		return
+	}
+
+	c.JSON(http.StatusOK, utils.GetResponse(http.StatusOK, "", utils.MessageResourceUpdated))
+}
+
+func GetShipmentUnlockApprovers(c *context.Context) {
+
+	res, err := unlockrequest.NewUnlockRequestService().GetApprovers(c, c.Account.RegionID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError,
+			utils.GetResponse(http.StatusInternalServerError, "", err.Error()),
+		)
+		return
+	}
+
+	c.JSON(http.StatusOK, res)
+}
+
+// guardUnlockApprover answers a caller who is not a finance approver of the region with a
+// 403 and reports whether the handler may go on.
+func guardUnlockApprover(c *context.Context) bool {
+	regionId := c.Query("rid")
+	if regionId == "" && c.Account != nil {
+		regionId = c.Account.RegionID
+	}
+
+	err := unlockrequest.NewUnlockRequestService().CheckApprover(c, regionId)
+	if err != nil {
+		code := unlockRequestErrorCode(err)
+		if code == http.StatusInternalServerError {
+			c.Log.Error("Error checking unlock approver", zap.String("region_id", regionId), zap.Error(err))
+		}
+		c.JSON(code, utils.GetResponse(code, "", err.Error()))
+		return false
+	}
+
+	return true
+}
+
+func unlockRequestErrorCode(err error) int {
+	switch err {
+	case unlockrequest.ErrInvalidUnlockScope, unlockrequest.ErrUnlockReasonRequired, unlockrequest.ErrInvalidUnlockExpiry:
+		return http.StatusBadRequest
+	case unlockrequest.ErrNotUnlockApprover, unlockrequest.ErrSelfApproval, unlockrequest.ErrNotUnlockRequester:
+		return http.StatusForbidden
+	case unlockrequest.ErrShipmentNotLocked, unlockrequest.ErrUnlockRequestOpen, unlockrequest.ErrUnlockRequestClosed:
+		return http.StatusConflict
+	}
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return http.StatusNotFound
+	}
+	return http.StatusInternalServerError
+}
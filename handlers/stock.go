@@ -1,35 +1,41 @@
 package handlers
 
 import (
+	"encoding/csv"
+	"encoding/json"
 	"net/http"
 	"strconv"
 
 	dtos "bitbucket.org/radarventures/forwarder-adapters/dtos/shipments"
 	"bitbucket.org/radarventures/forwarder-adapters/utils/context"
+	"bitbucket.org/radarventures/forwarder-shipments/database/models"
 	"bitbucket.org/radarventures/forwarder-shipments/services/stock"
+	"bitbucket.org/radarventures/forwarder-shipments/services/stock/allocation"
+	"bitbucket.org/radarventures/forwarder-shipments/services/stock/forecast"
+	"bitbucket.org/radarventures/forwarder-shipments/services/stock/numbers"
 	"bitbucket.org/radarventures/forwarder-shipments/utils"
 	"go.uber.org/zap"
 )
 
 func CreateStockDetails(c *context.Context) {
 
-	req := dtos.StocksReq{}
-	err := c.BindJSON(&req)
-	if err != nil {
-		c.Log.Error("Unable to bing json", zap.Error(err))
+	req := &models.StockNumbersReq{}
+	if err := c.BindJSON(req); err != nil {
+		c.JSON(http.StatusBadRequest,
+			utils.GetResponse(http.StatusBadRequest, "", utils.ErrJSONDecode),
+		)
+		return
 	}
-	req.RegionId = c.Account.RegionID
-	res, err := stock.NewStockService().CreateNewStockDetails(c, &req)
+
+	res, err := numbers.NewNumberService().CreateNumbers(c, req)
 	if err != nil {
 		c.Log.Error("Error creating stock details", zap.Error(err))
-		c.JSON(http.StatusInternalServerError,
-			utils.GetResponse(http.StatusInternalServerError, "", err.Error()),
+		c.JSON(stockImportErrorCode(err),
+			utils.GetResponse(stockImportErrorCode(err), "", err.Error()),
 		)
 		return
 	}
-	c.Log.Info("checking res", zap.Any("new stock details", res))
 	c.JSON(http.StatusOK, res)
-
 }
 
 func CreateStockNumbers(c *context.Context) {
@@ -104,6 +110,20 @@ func GetAvailableStock(c *context.Context) {
 
 func GetStockNumberDetails(c *context.Context) {
 
+	// A single number is returned with its status history
+	if stockNo := c.Query("stock_no"); stockNo != "" {
+		res, err := allocation.NewAllocationService().GetNumberHistory(c, stockNo)
+		if err != nil {
+			c.Log.Error("Error fetching stock number history", zap.Error(err))
+			c.JSON(http.StatusInternalServerError,
+				utils.GetResponse(http.StatusInternalServerError, "", err.Error()),
+			)
+			return
+		}
+		c.JSON(http.StatusOK, res)
+		return
+	}
+
 	queryAirlineId := c.Query("airline_id")
 	queryPortId := c.Query("port_id")
 	queryStatus := c.Query("status")
@@ -150,7 +170,14 @@ func GetAllAirLineStocks(c *context.Context) {
 			)
 			return
 		}
-		c.JSON(http.StatusOK, res)
+
+		daysOfCover, err := forecast.NewForecastService().GetDaysOfCover(c, req.RegionId)
+		if err != nil {
+			c.Log.Error("Error fetching stock days of cover", zap.Error(err))
+			c.JSON(http.StatusOK, res)
+			return
+		}
+		c.JSON(http.StatusOK, withDaysOfCover(res, daysOfCover))
 
 	} else if c.Query("status") == "completed" {
 
@@ -192,3 +219,239 @@ func UpdateStockStatus(c *context.Context) {
 	c.JSON(http.StatusOK, res)
 
 }
+
+func ReserveStockNumber(c *context.Context) {
+
+	req := &models.ReserveStockReq{}
+	if err := c.BindJSON(req); err != nil {
+		c.JSON(http.StatusBadRequest,
+			utils.GetResponse(http.StatusBadRequest, "", utils.ErrJSONDecode),
+		)
+		return
+	}
+
+	res, err := allocation.NewAllocationService().Reserve(c, req)
+	if err != nil {
+		c.Log.Error("Error reserving stock number", zap.Error(err))
+		c.JSON(stockAllocationErrorCode(err),
+			utils.GetResponse(stockAllocationErrorCode(err), "", err.Error()),
+		)
+		return
+	}
+	c.JSON(http.StatusOK, res)
+}
+
+func ConfirmStockNumber(c *context.Context) {
+
+	req := &models.StockAllocationReq{}
+	if err := c.BindJSON(req); err != nil {
+		c.JSON(http.StatusBadRequest,
+			utils.GetResponse(http.StatusBadRequest, "", utils.ErrJSONDecode),
+		)
+		return
+	}
+
+	res, err := allocation.NewAllocationService().Confirm(c, req)
+	if err != nil {
+		c.Log.Error("Error confirming stock number", zap.Error(err))
+		c.JSON(stockAllocationErrorCode(err),
+			utils.GetResponse(stockAllocationErrorCode(err), "", err.Error()),
+		)
+		return
+	}
+	c.JSON(http.StatusOK, res)
+}
+
+func ReleaseStockNumber(c *context.Context) {
+
+	req := &models.StockAllocationReq{}
+	if err := c.BindJSON(req); err != nil {
+		c.JSON(http.StatusBadRequest,
+			utils.GetResponse(http.StatusBadRequest, "", utils.ErrJSONDecode),
+		)
+		return
+	}
+
+	err := allocation.NewAllocationService().Release(c, req)
+	if err != nil {
+		c.Log.Error("Error releasing stock number", zap.Error(err))
+		c.JSON(stockAllocationErrorCode(err),
+			utils.GetResponse(stockAllocationErrorCode(err), "", err.Error()),
+		)
+		return
+	}
+	c.JSON(http.StatusOK, utils.GetResponse(http.StatusOK, "", utils.MessageResourceUpdated))
+}
+
+func VoidStockNumber(c *context.Context) {
+
+	req := &models.StockAllocationReq{}
+	if err := c.BindJSON(req); err != nil {
+		c.JSON(http.StatusBadRequest,
+			utils.GetResponse(http.StatusBadRequest, "", utils.ErrJSONDecode),
+		)
+		return
+	}
+
+	err := allocation.NewAllocationService().Void(c, req)
+	if err != nil {
+		c.Log.Error("Error voiding stock number", zap.Error(err))
+		c.JSON(stockAllocationErrorCode(err),
+			utils.GetResponse(stockAllocationErrorCode(err), "", err.Error()),
+		)
+		return
+	}
+	c.JSON(http.StatusOK, utils.GetResponse(http.StatusOK, "", utils.MessageResourceUpdated))
+}
+
+func stockAllocationErrorCode(err error) int {
+	switch err {
+	case allocation.ErrShipmentRequired, allocation.ErrAirlinePortRequired, allocation.ErrStockNoRequired:
+		return http.StatusBadRequest
+	case allocation.ErrReservationNotFound:
+		return http.StatusNotFound
+	case allocation.ErrStockExhausted, allocation.ErrStockNotVoidable:
+		return http.StatusConflict
+	}
+	return http.StatusInternalServerError
+}
+
+// withDaysOfCover adds the days of cover by airline and port to an airline stocks response.
+// The response is returned as is when it is not a JSON object.
+func withDaysOfCover(res interface{}, daysOfCover map[string]map[string]*float64) interface{} {
+	b, err := json.Marshal(res)
+	if err != nil {
+		return res
+	}
+
+	body := map[string]interface{}{}
+	if err := json.Unmarshal(b, &body); err != nil {
+		return res
+	}
+	body["days_of_cover"] = daysOfCover
+
+	return body
+}
+
+func GetStockForecast(c *context.Context) {
+
+	weeks, _ := strconv.Atoi(c.Query("weeks"))
+	regionId := c.Query("region_id")
+	if regionId == "" {
+		regionId = c.Account.RegionID
+	}
+
+	res, err := forecast.NewForecastService().GetForecasts(c, regionId, weeks)
+	if err != nil {
+		c.Log.Error("Error fetching stock forecast", zap.Error(err))
+		c.JSON(http.StatusInternalServerError,
+			utils.GetResponse(http.StatusInternalServerError, "", err.Error()),
+		)
+		return
+	}
+	c.JSON(http.StatusOK, res)
+}
+
+func SaveStockAlertSetting(c *context.Context) {
+
+	req := &models.StockAlertSetting{}
+	if err := c.BindJSON(req); err != nil {
+		c.JSON(http.StatusBadRequest,
+			utils.GetResponse(http.StatusBadRequest, "", utils.ErrJSONDecode),
+		)
+		return
+	}
+
+	err := forecast.NewForecastService().SaveAlertSetting(c, req)
+	if err != nil {
+		if err == forecast.ErrAlertAirlinePortRequired || err == forecast.ErrInvalidMinDaysCover || err == forecast.ErrInvalidAlertEmail {
+			c.JSON(http.StatusBadRequest,
+				utils.GetResponse(http.StatusBadRequest, "", err.Error()),
+			)
+			return
+		}
+		c.Log.Error("Error saving stock alert setting", zap.Error(err))
+		c.JSON(http.StatusInternalServerError,
+			utils.GetResponse(http.StatusInternalServerError, "", err.Error()),
+		)
+		return
+	}
+	c.JSON(http.StatusOK, utils.GetResponse(http.StatusOK, "", utils.MessageResourceUpdated))
+}
+
+func GetStockAlertSettings(c *context.Context) {
+
+	res, err := forecast.NewForecastService().GetAlertSettings(c, c.Account.RegionID)
+	if err != nil {
+		c.Log.Error("Error fetching stock alert settings", zap.Error(err))
+		c.JSON(http.StatusInternalServerError,
+			utils.GetResponse(http.StatusInternalServerError, "", err.Error()),
+		)
+		return
+	}
+	c.JSON(http.StatusOK, res)
+}
+
+func GenerateStockRange(c *context.Context) {
+
+	req := &models.StockRangeReq{}
+	if err := c.BindJSON(req); err != nil {
+		c.JSON(http.StatusBadRequest,
+			utils.GetResponse(http.StatusBadRequest, "", utils.ErrJSONDecode),
+		)
+		return
+	}
+
+	res, err := numbers.NewNumberService().GenerateRange(c, req)
+	if err != nil {
+		c.Log.Error("Error generating stock range", zap.Error(err))
+		c.JSON(stockImportErrorCode(err),
+			utils.GetResponse(stockImportErrorCode(err), "", err.Error()),
+		)
+		return
+	}
+	c.JSON(http.StatusOK, res)
+}
+
+func ImportStockNumbers(c *context.Context) {
+
+	file, err := c.FormFile("file")
+	if err != nil {
+		c.JSON(http.StatusBadRequest,
+			utils.GetResponse(http.StatusBadRequest, "", "csv file is required"),
+		)
+		return
+	}
+
+	f, err := file.Open()
+	if err != nil {
+		c.Log.Error("Error opening stock import file", zap.Error(err))
+		c.JSON(http.StatusInternalServerError,
+			utils.GetResponse(http.StatusInternalServerError, "", err.Error()),
+		)
+		return
+	}
+	defer f.Close()
+
+	res, err := numbers.NewNumberService().ImportCSV(c, f, c.Query("airline_id"), c.Query("port_id"))
+	if err != nil {
+		c.Log.Error("Error importing stock numbers", zap.Error(err))
+		c.JSON(stockImportErrorCode(err),
+			utils.GetResponse(stockImportErrorCode(err), "", err.Error()),
+		)
+		return
+	}
+	c.JSON(http.StatusOK, res)
+}
+
+func stockImportErrorCode(err error) int {
+	switch err {
+	case numbers.ErrImportAirlinePortRequired, numbers.ErrInvalidImportCount, numbers.ErrImportStockNoColumn,
+		numbers.ErrInvalidAWBPrefix, numbers.ErrInvalidAWBSerial, numbers.ErrAWBRangeOverflow:
+		return http.StatusBadRequest
+	}
+	if _, ok := err.(*csv.ParseError); ok {
+		return http.StatusBadRequest
+	}
+	return http.StatusInternalServerError
+}
@@ -4,7 +4,6 @@ import (
 	"bitbucket.org/radarventures/forwarder-adapters/utils/context"
 	"bitbucket.org/radarventures/forwarder-adapters/utils/log"
 	"bitbucket.org/radarventures/forwarder-shipments/config"
-	"bitbucket.org/radarventures/forwarder-shipments/constants"
 
 	"bitbucket.org/radarventures/forwarder-adapters/utils/db"
 	"github.com/google/uuid"
@@ -23,7 +22,4 @@ func logAndGetContext(c *context.Context) {
 	c.DB = db.New()
 
 	c.TenantID = c.GetHeader("tenant_id")
-	if cfg.Env == constants.Dev {
-		c.TenantID = "public"
-	}
 }
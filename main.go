@@ -55,6 +55,12 @@ func main() {
 
 	job := flag.String("job", "", "Flag to check if job need to Run")
 	cronjob := flag.String("cronjob", "", "Flag to check if cronjob need to Run")
+	dryRun := flag.Bool("dry-run", false, "Log the changes the cronjob would make without writing them")
+	params := flag.String("params", "", "Cronjob parameters as comma separated name=value pairs")
+	listJobs := flag.Bool("list-jobs", false, "List the available cronjobs with their parameters")
+	scheduler := flag.Bool("scheduler", false, "Run the cronjobs on their configured schedules until stopped")
+	cronWorkers := flag.Int("cron-workers", cronjobs.DefaultPoolParallelism, "Number of items a cronjob processes in parallel")
+	cronItemTimeout := flag.Duration("cron-item-timeout", cronjobs.DefaultPoolItemTimeout, "Time a cronjob may spend on a single item")
 	flag.Parse()
 
 	docs.InitializeDocs(config.Get().DocsURL, constants.Logger)
@@ -64,8 +70,22 @@ func main() {
 		os.Exit(0)
 	}
 
+	if *listJobs {
+		cronjobs.ListJobs(os.Stdout)
+		os.Exit(0)
+	}
+
+	if *scheduler {
+		cronjobs.SetWorkerPoolOptions(*cronWorkers, *cronItemTimeout)
+		if err := cronjobs.StartScheduler(); err != nil {
+			os.Exit(1)
+		}
+		os.Exit(0)
+	}
+
 	if cronjob != nil && len(*cronjob) > 0 {
-		cronjobs.Start(cronjob)
+		cronjobs.SetWorkerPoolOptions(*cronWorkers, *cronItemTimeout)
+		cronjobs.Start(cronjob, *dryRun, *params)
 		os.Exit(0)
 	}
 
@@ -0,0 +1,303 @@
+package amsfiling
+
+import (
+	"encoding/json"
+	"errors"
+	"strings"
+	"time"
+
+	dtos "bitbucket.org/radarventures/forwarder-adapters/dtos/shipments"
+	"bitbucket.org/radarventures/forwarder-adapters/utils/context"
+	"bitbucket.org/radarventures/forwarder-shipments/config/globals"
+	"bitbucket.org/radarventures/forwarder-shipments/constants"
+	amsDao "bitbucket.org/radarventures/forwarder-shipments/daos/ams"
+	"bitbucket.org/radarventures/forwarder-shipments/daos/card"
+	"bitbucket.org/radarventures/forwarder-shipments/database/models"
+	"bitbucket.org/radarventures/forwarder-shipments/services/ams"
+	"github.com/google/uuid"
+	"go.uber.org/zap"
+)
+
+// DefaultCutOff is how long before the vessel's ETD a filing must be accepted. US customs want
+// the AMS 24 hours before loading.
+const DefaultCutOff = 24 * time.Hour
+
+var (
+	ErrFilingNotFound    = errors.New("no AMS filing for the house bill of the shipment")
+	ErrFilingNotRejected = errors.New("only a rejected AMS filing can be re-filed")
+)
+
+// RefileError is returned when the corrected filing still fails validation. Errs are the
+// messages of the AMS generation.
+type RefileError struct {
+	Errs interface{}
+}
+
+func (e *RefileError) Error() string {
+	return "corrected AMS filing failed validation"
+}
+
+type IAMSFilingService interface {
+	GetFilings(ctx *context.Context, filter *models.AMSFilingFilter, cutOff time.Duration) (*models.AMSFilingPage, error)
+	Refile(ctx *context.Context, shipmentId uuid.UUID, hblNo string, req *dtos.AmsInfo) error
+
+	AlertFor(filing *models.AMSFilingDetail) string
+	RaiseAlert(ctx *context.Context, filing *models.AMSFilingDetail, name string) (bool, error)
+}
+
+type AMSFilingService struct {
+	amsDb  amsDao.AMSDBI
+	cardDb card.ICard
+}
+
+func NewAMSFilingService() IAMSFilingService {
+	return &AMSFilingService{
+		amsDb:  amsDao.NewAMSInfo(),
+		cardDb: card.NewCard(),
+	}
+}
+
+// GetFilings lists a page of filings with their stage, errors and the time they spent in each
+// stage. The vessel's cut-off is the quote's ETD less cutOff. The stage filter applies to the
+// filings of the page.
+func (s *AMSFilingService) GetFilings(ctx *context.Context, filter *models.AMSFilingFilter, cutOff time.Duration) (*models.AMSFilingPage, error) {
+	total, filings, err := s.amsDb.GetFilings(ctx, filter)
+	if err != nil {
+		return nil, err
+	}
+
+	ids := make([]uuid.UUID, 0, len(filings))
+	for _, filing := range filings {
+		if filing.Kind == constants.FilingKindAMS {
+			ids = append(ids, filing.AmsInfoId)
+		}
+	}
+
+	events, err := s.amsDb.GetStatusEvents(ctx, ids)
+	if err != nil {
+		return nil, err
+	}
+
+	eventsOf := map[uuid.UUID][]*models.AMSStatusEvent{}
+	for _, event := range events {
+		eventsOf[event.AmsInfoId] = append(eventsOf[event.AmsInfoId], event)
+	}
+
+	now := time.Now().UTC()
+	res := &models.AMSFilingPage{
+		Total:   total,
+		Filings: make([]*models.AMSFilingDetail, 0, len(filings)),
+	}
+	for _, filing := range filings {
+		detail := describe(ctx, filing, eventsOf[filing.AmsInfoId], cutOff, now)
+		if filter.Stage != "" && detail.Stage != filter.Stage {
+			continue
+		}
+		res.Filings = append(res.Filings, detail)
+	}
+
+	return res, nil
+}
+
+// describe works out the stage of a filing from its status history. Filings filed before the
+// history was kept only have their current status.
+func describe(ctx *context.Context, filing *models.AMSFiling, events []*models.AMSStatusEvent, cutOff time.Duration, now time.Time) *models.AMSFilingDetail {
+	detail := &models.AMSFilingDetail{
+		AMSFiling:    filing,
+		Stage:        constants.AMSStagePending,
+		Errors:       []dtos.AMSError{},
+		Periods:      []models.AMSStagePeriod{},
+		StageSeconds: map[string]int64{},
+	}
+
+	if filing.IsGenerated {
+		detail.Stage = constants.AMSStageGenerated
+	}
+	if stage := StageOf(filing.AmsStatus); stage != "" {
+		detail.Stage = stage
+	}
+
+	for _, event := range events {
+		stage := StageOf(event.Status)
+		if stage == "" {
+			continue
+		}
+
+		if n := len(detail.Periods); n > 0 {
+			if detail.Periods[n-1].Stage == stage {
+				continue
+			}
+			leftAt := event.CreatedAt
+			detail.Periods[n-1].LeftAt = &leftAt
+		}
+		detail.Periods = append(detail.Periods, models.AMSStagePeriod{
+			Stage:     stage,
+			Status:    event.Status,
+			EnteredAt: event.CreatedAt,
+		})
+	}
+
+	for i := range detail.Periods {
+		period := &detail.Periods[i]
+		end := now
+		if period.LeftAt != nil {
+			end = *period.LeftAt
+		}
+		period.Seconds = int64(end.Sub(period.EnteredAt).Seconds())
+		detail.StageSeconds[period.Stage] += period.Seconds
+	}
+
+	if n := len(detail.Periods); n > 0 {
+		detail.Stage = detail.Periods[n-1].Stage
+		detail.StageSince = &detail.Periods[n-1].EnteredAt
+	}
+
+	if detail.Stage == constants.AMSStageRejected && filing.ErrorResponse != "" {
+		if err := json.Unmarshal([]byte(filing.ErrorResponse), &detail.Errors); err != nil {
+			ctx.Log.Error("unable to read AMS errors", zap.Any("ams_info_id", filing.AmsInfoId), zap.Error(err))
+		}
+	}
+
+	if filing.Etd != nil && !filing.Etd.IsZero() {
+		cutOffAt := filing.Etd.Add(-cutOff)
+		detail.CutOff = &cutOffAt
+		detail.PastCutOff = now.After(cutOffAt) && !isFiled(detail.Stage)
+	}
+
+	return detail
+}
+
+// StageOf maps a status of a filing to its stage. Statuses that say nothing about the stage,
+// e.g. a refile being completed by hand, map to "".
+func StageOf(status string) string {
+	status = strings.TrimSpace(status)
+	lower := strings.ToLower(status)
+
+	switch {
+	case status == "":
+		return ""
+	case status == constants.AMSStatusFileGenerated:
+		return constants.AMSStageGenerated
+	case status == globals.AMSAcceptedCode, status == constants.ISFStatusTransmitted:
+		return constants.AMSStageTransmitted
+	case strings.Contains(lower, "reject"):
+		return constants.AMSStageRejected
+	case strings.Contains(lower, "linked"):
+		return constants.AMSStageLinked
+	case strings.Contains(lower, "accepted"):
+		return constants.AMSStageAccepted
+	case strings.Contains(lower, "received"):
+		return constants.AMSStageReceived
+	}
+
+	return ""
+}
+
+func isFiled(stage string) bool {
+	return stage == constants.AMSStageAccepted || stage == constants.AMSStageLinked
+}
+
+// AlertFor returns the card a filing needs, or "" when it needs none.
+func (s *AMSFilingService) AlertFor(filing *models.AMSFilingDetail) string {
+	switch {
+	case filing.Stage == constants.AMSStageRejected:
+		return constants.CardAMSRejected
+	case filing.PastCutOff:
+		return constants.CardAMSOverdue
+	}
+
+	return ""
+}
+
+// RaiseAlert raises the card on the filing's shipment unless one is still open. It goes to the
+// executive handling the shipment, or to whoever created it.
+func (s *AMSFilingService) RaiseAlert(ctx *context.Context, filing *models.AMSFilingDetail, name string) (bool, error) {
+	openStatuses := []string{constants.CardStatusCreated, constants.CardStatusWarning, constants.CardStatusBreached}
+
+	open, err := s.cardDb.GetCardsWithFilter(ctx, &models.Card{
+		Name:         name,
+		InstanceId:   filing.ShipmentId.String(),
+		InstanceType: constants.WorkflowTypeShipment,
+	}, openStatuses)
+	if err != nil {
+		return false, err
+	}
+	if len(open) > 0 {
+		return false, nil
+	}
+
+	assignedTo := filing.CreatedBy
+	executive, err := s.cardDb.GetAssignedTo(ctx, &models.Card{
+		InstanceId:   filing.ShipmentId.String(),
+		InstanceType: constants.WorkflowTypeShipment,
+	}, openStatuses)
+	if err != nil {
+		return false, err
+	}
+	if executive != nil && executive.AssignedTo != "" {
+		assignedTo = executive.AssignedTo
+	}
+
+	estimate := time.Now().UTC()
+	if filing.CutOff != nil && filing.CutOff.After(estimate) {
+		estimate = *filing.CutOff
+	}
+
+	err = s.cardDb.Upsert(ctx, &models.Card{
+		Id:           uuid.New(),
+		Name:         name,
+		InstanceId:   filing.ShipmentId.String(),
+		InstanceType: constants.WorkflowTypeShipment,
+		AssignedTo:   assignedTo,
+		Status:       constants.CardStatusCreated,
+		Estimate:     estimate,
+	})
+	if err != nil {
+		ctx.Log.Error("unable to raise AMS card", zap.String("card", name), zap.Any("shipment_id", filing.ShipmentId), zap.String("hbl_no", filing.HblNo), zap.Error(err))
+		return false, err
+	}
+
+	return true, nil
+}
+
+// Refile generates and transmits the corrected file of a rejected filing and completes the
+// refile of the house bill.
+func (s *AMSFilingService) Refile(ctx *context.Context, shipmentId uuid.UUID, hblNo string, req *dtos.AmsInfo) error {
+	_, filings, err := s.amsDb.GetFilings(ctx, &models.AMSFilingFilter{
+		ShipmentId: shipmentId.String(),
+		HblNo:      hblNo,
+		Kind:       constants.FilingKindAMS,
+	})
+	if err != nil {
+		return err
+	}
+	if len(filings) == 0 {
+		return ErrFilingNotFound
+	}
+
+	events, err := s.amsDb.GetStatusEvents(ctx, []uuid.UUID{filings[0].AmsInfoId})
+	if err != nil {
+		return err
+	}
+
+	if describe(ctx, filings[0], events, DefaultCutOff, time.Now().UTC()).Stage != constants.AMSStageRejected {
+		return ErrFilingNotRejected
+	}
+
+	amsService := ams.New()
+	msgs, err := amsService.GenerateAMS(ctx, req)
+	if err != nil {
+		if len(msgs) > 0 {
+			return &RefileError{Errs: msgs}
+		}
+		return err
+	}
+
+	err = amsService.CompleteRefileAms(ctx, shipmentId.String(), hblNo, true)
+	if err != nil {
+		ctx.Log.Error("unable to complete AMS refile", zap.Any("shipment_id", shipmentId), zap.String("hbl_no", hblNo), zap.Error(err))
+		return err
+	}
+
+	return nil
+}
@@ -0,0 +1,198 @@
+package amsmail
+
+import (
+	"errors"
+	"time"
+
+	dtos "bitbucket.org/radarventures/forwarder-adapters/dtos/shipments"
+	"bitbucket.org/radarventures/forwarder-adapters/utils/context"
+	"bitbucket.org/radarventures/forwarder-shipments/config/globals"
+	amsDao "bitbucket.org/radarventures/forwarder-shipments/daos/ams"
+	"bitbucket.org/radarventures/forwarder-shipments/database/models"
+	"bitbucket.org/radarventures/forwarder-shipments/services/ams"
+	"github.com/google/uuid"
+	"go.uber.org/zap"
+)
+
+var ErrFailureResolved = errors.New("mail failure is already resolved")
+
+type ProcessConfig struct {
+	Sender string
+	Parser ParserConfig
+	DryRun bool
+}
+
+type ProcessSummary struct {
+	Read    int `json:"read"`
+	Applied int `json:"applied"`
+	Failed  int `json:"failed"`
+}
+
+type IAMSMailService interface {
+	Process(ctx *context.Context, inbox Inbox, cfg *ProcessConfig) (*ProcessSummary, error)
+	Apply(ctx *context.Context, parsed *ParsedMail) error
+
+	GetFailures(ctx *context.Context, includeResolved bool) ([]*models.AMSMailFailure, error)
+	RetryFailure(ctx *context.Context, id uuid.UUID, cfg ParserConfig) (*ParsedMail, error)
+	ResolveFailure(ctx *context.Context, id uuid.UUID, resolution string) error
+}
+
+// amsStatusUpdater is the part of the AMS service the parsed mails are applied with.
+type amsStatusUpdater interface {
+	UpdateAmsStatus(ctx *context.Context, id string, status string, errs []dtos.AMSError) error
+	UpdateErrorStatus(ctx *context.Context, file string, errs []dtos.AMSError) error
+}
+
+type AMSMailService struct {
+	amsDb amsDao.AMSDBI
+	ams   amsStatusUpdater
+}
+
+func NewAMSMailService() IAMSMailService {
+	return &AMSMailService{
+		amsDb: amsDao.NewAMSInfo(),
+		ams:   ams.New(),
+	}
+}
+
+// Process applies the unread AMS mails of the inbox to their filings. A mail is marked read
+// once applied; a mail that cannot be parsed is stored for review and marked read too, while
+// one that fails to apply is left unread for the next run.
+func (s *AMSMailService) Process(ctx *context.Context, inbox Inbox, cfg *ProcessConfig) (*ProcessSummary, error) {
+	messages, err := inbox.Unread(ctx, cfg.Sender)
+	if err != nil {
+		ctx.Log.Error("unable to read AMS inbox", zap.String("source", inbox.Source()), zap.Error(err))
+		return nil, err
+	}
+
+	summary := &ProcessSummary{
+		Read: len(messages),
+	}
+
+	for _, message := range messages {
+		parsed, err := Parse(message, cfg.Parser)
+		if err != nil {
+			summary.Failed++
+			ctx.Log.Warn("unable to parse AMS mail", zap.String("message_id", message.Id), zap.String("subject", message.Subject), zap.Error(err))
+			if cfg.DryRun {
+				ctx.Log.Info("dry run: would store AMS mail failure", zap.String("message_id", message.Id))
+				continue
+			}
+
+			err = s.amsDb.AddMailFailure(ctx, &models.AMSMailFailure{
+				Id:         uuid.New(),
+				MessageId:  message.Id,
+				Source:     inbox.Source(),
+				Sender:     message.Sender,
+				Subject:    message.Subject,
+				Body:       message.Body,
+				Error:      err.Error(),
+				ReceivedAt: message.ReceivedAt,
+				CreatedAt:  time.Now().UTC(),
+			})
+			if err != nil {
+				continue
+			}
+
+			s.markRead(ctx, inbox, message.Id)
+			continue
+		}
+
+		if cfg.DryRun {
+			ctx.Log.Info("dry run: would apply AMS mail", zap.String("message_id", message.Id), zap.String("kind", parsed.Kind), zap.String("reference", parsed.Reference), zap.String("status", parsed.Status), zap.Int("errors", len(parsed.Errors)))
+			continue
+		}
+
+		if err := s.Apply(ctx, parsed); err != nil {
+			ctx.Log.Error("unable to apply AMS mail", zap.String("message_id", message.Id), zap.String("reference", parsed.Reference), zap.Error(err))
+			continue
+		}
+
+		summary.Applied++
+		s.markRead(ctx, inbox, message.Id)
+	}
+
+	ctx.Log.Info("AMS inbox processed", zap.String("source", inbox.Source()), zap.Int("read", summary.Read), zap.Int("applied", summary.Applied), zap.Int("failed", summary.Failed))
+
+	return summary, nil
+}
+
+// Apply updates the AMS filing a parsed mail is about.
+func (s *AMSMailService) Apply(ctx *context.Context, parsed *ParsedMail) error {
+	switch parsed.Kind {
+	case KindAccepted:
+		return s.ams.UpdateAmsStatus(ctx, parsed.FileName, globals.AMSAcceptedCode, nil)
+	case KindStatus:
+		return s.ams.UpdateAmsStatus(ctx, parsed.HBLNo, parsed.Status, nil)
+	case KindRejected:
+		err := s.ams.UpdateAmsStatus(ctx, parsed.HBLNo, parsed.Status, parsed.Errors)
+		if err != nil {
+			return err
+		}
+		return s.ams.UpdateErrorStatus(ctx, parsed.FileName, parsed.Errors)
+	}
+
+	return ErrUnrecognisedMail
+}
+
+func (s *AMSMailService) GetFailures(ctx *context.Context, includeResolved bool) ([]*models.AMSMailFailure, error) {
+	return s.amsDb.GetMailFailures(ctx, includeResolved)
+}
+
+// RetryFailure parses a stored mail again and applies it, e.g. once the parser learnt its
+// format. The failure is resolved when the mail applies.
+func (s *AMSMailService) RetryFailure(ctx *context.Context, id uuid.UUID, cfg ParserConfig) (*ParsedMail, error) {
+	failure, err := s.amsDb.GetMailFailure(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+
+	if failure.ResolvedAt != nil {
+		return nil, ErrFailureResolved
+	}
+
+	parsed, err := Parse(&Message{
+		Id:         failure.MessageId,
+		Sender:     failure.Sender,
+		Subject:    failure.Subject,
+		Body:       failure.Body,
+		ReceivedAt: failure.ReceivedAt,
+	}, cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := s.Apply(ctx, parsed); err != nil {
+		return nil, err
+	}
+
+	return parsed, s.amsDb.ResolveMailFailure(ctx, id, accountId(ctx), "retried")
+}
+
+// ResolveFailure closes a stored mail that was handled by hand.
+func (s *AMSMailService) ResolveFailure(ctx *context.Context, id uuid.UUID, resolution string) error {
+	failure, err := s.amsDb.GetMailFailure(ctx, id)
+	if err != nil {
+		return err
+	}
+
+	if failure.ResolvedAt != nil {
+		return ErrFailureResolved
+	}
+
+	return s.amsDb.ResolveMailFailure(ctx, id, accountId(ctx), resolution)
+}
+
+func (s *AMSMailService) markRead(ctx *context.Context, inbox Inbox, id string) {
+	if err := inbox.MarkRead(ctx, id); err != nil {
+		ctx.Log.Error("unable to mark AMS mail as read", zap.String("message_id", id), zap.Error(err))
+	}
+}
+
+func accountId(ctx *context.Context) *uuid.UUID {
+	if ctx.Account == nil {
+		return nil
+	}
+
+	return &ctx.Account.ID
+}
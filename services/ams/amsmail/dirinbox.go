@@ -0,0 +1,171 @@
+package amsmail
+
+import (
+	"bytes"
+	"encoding/base64"
+	"io"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net/mail"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"bitbucket.org/radarventures/forwarder-adapters/utils/context"
+	"go.uber.org/zap"
+)
+
+// DirInbox reads mails saved as .eml files in a directory, e.g. to replay real AMS mails
+// locally. A mail is marked read by moving it to the "read" directory inside it.
+type DirInbox struct {
+	dir string
+}
+
+func NewDirInbox(dir string) Inbox {
+	return &DirInbox{
+		dir: dir,
+	}
+}
+
+func (d *DirInbox) Source() string {
+	return "dir:" + d.dir
+}
+
+func (d *DirInbox) Unread(ctx *context.Context, sender string) ([]*Message, error) {
+	files, err := filepath.Glob(filepath.Join(d.dir, "*.eml"))
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(files)
+
+	messages := make([]*Message, 0, len(files))
+	for _, file := range files {
+		message, err := ReadEML(file)
+		if err != nil {
+			ctx.Log.Error("unable to read mail file", zap.String("file", file), zap.Error(err))
+			continue
+		}
+
+		if sender != "" && !strings.EqualFold(message.Sender, sender) {
+			continue
+		}
+		messages = append(messages, message)
+	}
+
+	return messages, nil
+}
+
+func (d *DirInbox) MarkRead(ctx *context.Context, id string) error {
+	readDir := filepath.Join(d.dir, "read")
+	if err := os.MkdirAll(readDir, 0o755); err != nil {
+		return err
+	}
+
+	return os.Rename(filepath.Join(d.dir, id), filepath.Join(readDir, id))
+}
+
+// ReadEML reads a mail saved in RFC 5322 format. The message id is the file name. Of a
+// multipart mail the plain text part is preferred over the HTML one.
+func ReadEML(file string) (*Message, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	m, err := mail.ReadMessage(f)
+	if err != nil {
+		return nil, err
+	}
+
+	message := &Message{
+		Id:      filepath.Base(file),
+		Subject: decodeHeader(m.Header.Get("Subject")),
+	}
+
+	if from, err := mail.ParseAddress(m.Header.Get("From")); err == nil {
+		message.Sender = from.Address
+	}
+
+	if date, err := m.Header.Date(); err == nil {
+		message.ReceivedAt = date.UTC()
+	}
+
+	body, err := readPart(m.Header.Get("Content-Type"), m.Header.Get("Content-Transfer-Encoding"), m.Body)
+	if err != nil {
+		return nil, err
+	}
+	message.Body = body
+
+	return message, nil
+}
+
+func readPart(contentType, encoding string, r io.Reader) (string, error) {
+	mediaType, params, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		mediaType = "text/plain"
+	}
+
+	if !strings.HasPrefix(mediaType, "multipart/") {
+		b, err := io.ReadAll(decodeTransfer(encoding, r))
+		return string(b), err
+	}
+
+	var text, htmlBody string
+	parts := multipart.NewReader(r, params["boundary"])
+	for {
+		part, err := parts.NextPart()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return "", err
+		}
+
+		body, err := readPart(part.Header.Get("Content-Type"), part.Header.Get("Content-Transfer-Encoding"), part)
+		if err != nil {
+			return "", err
+		}
+
+		partType, _, _ := mime.ParseMediaType(part.Header.Get("Content-Type"))
+		switch {
+		case partType == "text/html" && htmlBody == "":
+			htmlBody = body
+		case text == "" && (partType == "text/plain" || strings.HasPrefix(partType, "multipart/")):
+			text = body
+		}
+	}
+
+	if text != "" {
+		return text, nil
+	}
+
+	return htmlBody, nil
+}
+
+func decodeTransfer(encoding string, r io.Reader) io.Reader {
+	switch strings.ToLower(strings.TrimSpace(encoding)) {
+	case "quoted-printable":
+		return quotedprintable.NewReader(r)
+	case "base64":
+		b, _ := io.ReadAll(r)
+		decoded, err := base64.StdEncoding.DecodeString(strings.Join(strings.Fields(string(b)), ""))
+		if err != nil {
+			return bytes.NewReader(b)
+		}
+		return bytes.NewReader(decoded)
+	}
+
+	return r
+}
+
+func decodeHeader(value string) string {
+	decoded, err := new(mime.WordDecoder).DecodeHeader(value)
+	if err != nil {
+		return value
+	}
+
+	return decoded
+}
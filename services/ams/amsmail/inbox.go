@@ -0,0 +1,108 @@
+package amsmail
+
+import (
+	stdcontext "context"
+	"time"
+
+	"bitbucket.org/radarventures/forwarder-adapters/utils/context"
+	msgraph "github.com/microsoftgraph/msgraph-sdk-go"
+	graphmodels "github.com/microsoftgraph/msgraph-sdk-go/models"
+	graphconfig "github.com/microsoftgraph/msgraph-sdk-go/users"
+)
+
+// Message is a mail read from an AMS inbox.
+type Message struct {
+	Id         string
+	Sender     string
+	Subject    string
+	Body       string
+	ReceivedAt time.Time
+}
+
+// Inbox is where AMS mails are read from. Graph reads the AMS mailbox; a local directory is
+// used to replay saved mails.
+type Inbox interface {
+	// Source names the inbox, message ids are unique within it
+	Source() string
+	Unread(ctx *context.Context, sender string) ([]*Message, error)
+	MarkRead(ctx *context.Context, id string) error
+}
+
+type GraphInbox struct {
+	client  *msgraph.GraphServiceClient
+	mailbox string
+}
+
+func NewGraphInbox(client *msgraph.GraphServiceClient, mailbox string) Inbox {
+	return &GraphInbox{
+		client:  client,
+		mailbox: mailbox,
+	}
+}
+
+func (g *GraphInbox) Source() string {
+	return "graph:" + g.mailbox
+}
+
+func (g *GraphInbox) Unread(ctx *context.Context, sender string) ([]*Message, error) {
+	filter := "isRead eq false"
+	if sender != "" {
+		filter = "from/emailAddress/address eq '" + sender + "' and " + filter
+	}
+
+	configuration := &graphconfig.ItemMailFoldersItemMessagesRequestBuilderGetRequestConfiguration{
+		QueryParameters: &graphconfig.ItemMailFoldersItemMessagesRequestBuilderGetQueryParameters{
+			Filter: &filter,
+		},
+	}
+
+	result, err := g.client.Users().ByUserId(g.mailbox).MailFolders().ByMailFolderId("inbox").Messages().Get(requestContext(ctx), configuration)
+	if err != nil {
+		return nil, err
+	}
+
+	messages := make([]*Message, 0, len(result.GetValue()))
+	for _, v := range result.GetValue() {
+		message := &Message{
+			Id:      deref(v.GetId()),
+			Subject: deref(v.GetSubject()),
+		}
+		if v.GetSender() != nil && v.GetSender().GetEmailAddress() != nil {
+			message.Sender = deref(v.GetSender().GetEmailAddress().GetAddress())
+		}
+		if v.GetBody() != nil {
+			message.Body = deref(v.GetBody().GetContent())
+		}
+		if v.GetReceivedDateTime() != nil {
+			message.ReceivedAt = v.GetReceivedDateTime().UTC()
+		}
+		messages = append(messages, message)
+	}
+
+	return messages, nil
+}
+
+func (g *GraphInbox) MarkRead(ctx *context.Context, id string) error {
+	isRead := true
+	requestBody := graphmodels.NewMessage()
+	requestBody.SetIsRead(&isRead)
+
+	_, err := g.client.Users().ByUserId(g.mailbox).Messages().ByMessageId(id).Patch(requestContext(ctx), requestBody, nil)
+	return err
+}
+
+func requestContext(ctx *context.Context) stdcontext.Context {
+	if ctx.Context != nil && ctx.Request != nil {
+		return ctx.Request.Context()
+	}
+
+	return stdcontext.Background()
+}
+
+func deref(s *string) string {
+	if s == nil {
+		return ""
+	}
+
+	return *s
+}
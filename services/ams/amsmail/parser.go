@@ -0,0 +1,190 @@
+package amsmail
+
+import (
+	"errors"
+	"fmt"
+	"html"
+	"regexp"
+	"strconv"
+	"strings"
+
+	dtos "bitbucket.org/radarventures/forwarder-adapters/dtos/shipments"
+)
+
+// Kinds of TradeTech AMS mails
+const (
+	KindAccepted = "accepted"
+	KindRejected = "rejected"
+	KindStatus   = "status"
+)
+
+const (
+	DefaultSender    = "noreply@tradetech.net"
+	DefaultHBLPrefix = "WZLW HBL "
+)
+
+var (
+	ErrUnrecognisedMail = errors.New("mail is not an AMS acceptance, rejection or status message")
+	ErrErrorCount       = errors.New("number of errors does not match the errors listed")
+)
+
+// MissingFieldError is returned for a mail that lacks a field its kind requires.
+type MissingFieldError struct {
+	Field string
+}
+
+func (e *MissingFieldError) Error() string {
+	return fmt.Sprintf("mail has no %s", e.Field)
+}
+
+// ParserConfig holds what identifies the mails of this forwarder.
+type ParserConfig struct {
+	HBLPrefix string
+}
+
+// ParsedMail is what an AMS mail says about a filing. Reference is the file name for accepted
+// and rejected files and the house bill for status messages.
+type ParsedMail struct {
+	Kind       string          `json:"kind"`
+	Reference  string          `json:"reference"`
+	FileName   string          `json:"file_name"`
+	HBLNo      string          `json:"hbl_no"`
+	Status     string          `json:"status"`
+	ErrorCount int             `json:"error_count"`
+	Errors     []dtos.AMSError `json:"errors"`
+}
+
+// Subject of an accepted transmission, e.g.
+// "ftp_wizlogtec--Transmission Received: Accepted File: WZLW123.txt"
+var acceptedSubject = regexp.MustCompile(`Transmission Received:\s*Accepted File:\s*(\S.*)$`)
+
+// Parse reads a TradeTech acceptance, rejection or status mail. Rejections list their errors in
+// the body as "Name: value" lines, each error ending with its element position.
+func Parse(mail *Message, cfg ParserConfig) (*ParsedMail, error) {
+	if cfg.HBLPrefix == "" {
+		cfg.HBLPrefix = DefaultHBLPrefix
+	}
+
+	subject := strings.TrimSpace(mail.Subject)
+
+	switch {
+	case strings.Contains(subject, "Rejected File"):
+		return parseRejection(mail.Body)
+	case acceptedSubject.MatchString(subject):
+		fileName := strings.TrimSpace(acceptedSubject.FindStringSubmatch(subject)[1])
+		return &ParsedMail{
+			Kind:      KindAccepted,
+			Reference: fileName,
+			FileName:  fileName,
+		}, nil
+	case strings.HasPrefix(subject, cfg.HBLPrefix):
+		return parseStatus(subject, cfg.HBLPrefix)
+	}
+
+	return nil, ErrUnrecognisedMail
+}
+
+// parseStatus reads a subject like "WZLW HBL ABC123: USA Ocean AMS Filing Accepted".
+func parseStatus(subject, prefix string) (*ParsedMail, error) {
+	head, _, found := strings.Cut(strings.TrimPrefix(subject, prefix), ":")
+	if !found {
+		return nil, &MissingFieldError{Field: "status"}
+	}
+
+	hblNo := strings.TrimSpace(head)
+	if hblNo == "" {
+		return nil, &MissingFieldError{Field: "house bill number"}
+	}
+
+	status := strings.TrimSpace(subject[strings.LastIndex(subject, ":")+1:])
+	if status == "" {
+		return nil, &MissingFieldError{Field: "status"}
+	}
+
+	return &ParsedMail{
+		Kind:      KindStatus,
+		Reference: hblNo,
+		HBLNo:     hblNo,
+		Status:    status,
+	}, nil
+}
+
+func parseRejection(body string) (*ParsedMail, error) {
+	parsed := &ParsedMail{
+		Kind:   KindRejected,
+		Errors: make([]dtos.AMSError, 0),
+	}
+
+	errorCount := ""
+	current := dtos.AMSError{}
+	for _, line := range strings.Split(plainText(body), "\n") {
+		name, value, ok := strings.Cut(strings.TrimSpace(line), ":")
+		if !ok {
+			continue
+		}
+		value = strings.TrimSpace(value)
+
+		switch strings.TrimSpace(name) {
+		case "File Name":
+			parsed.FileName = value
+			current.File = value
+		case "Number of Errors":
+			errorCount = value
+		case "House Bill Number":
+			parsed.HBLNo = value
+		case "Document":
+			current.Document = value
+		case "Error Value":
+			current.ErrorValue = value
+		case "Error Message":
+			current.ErrorMessage = value
+		case "Element Position":
+			current.ElementPosition = value
+			parsed.Errors = append(parsed.Errors, current)
+			current = dtos.AMSError{Document: current.Document, File: current.File}
+		}
+	}
+
+	if parsed.HBLNo == "" {
+		return nil, &MissingFieldError{Field: "house bill number"}
+	}
+
+	if parsed.FileName == "" {
+		return nil, &MissingFieldError{Field: "file name"}
+	}
+
+	count, err := strconv.Atoi(errorCount)
+	if err != nil {
+		return nil, &MissingFieldError{Field: "number of errors"}
+	}
+
+	if count != len(parsed.Errors) {
+		return nil, fmt.Errorf("%w: %d declared, %d listed", ErrErrorCount, count, len(parsed.Errors))
+	}
+
+	parsed.Reference = parsed.FileName
+	parsed.ErrorCount = count
+	parsed.Status = fmt.Sprintf("Rejected With %d Errors", count)
+
+	return parsed, nil
+}
+
+var (
+	htmlBreaks = regexp.MustCompile(`(?i)<br\s*/?>|</(p|div|tr|li|h[1-6])>`)
+	htmlTags   = regexp.MustCompile(`<[^>]*>`)
+)
+
+// plainText turns an HTML mail body into lines of text. Plain text bodies are returned with
+// their carriage returns dropped.
+func plainText(body string) string {
+	body = strings.ReplaceAll(body, "\r", "")
+	if !strings.Contains(body, "<") {
+		return body
+	}
+
+	body = htmlBreaks.ReplaceAllString(body, "\n")
+	body = htmlTags.ReplaceAllString(body, "")
+	body = html.UnescapeString(body)
+
+	return strings.ReplaceAll(body, "\u00a0", " ")
+}
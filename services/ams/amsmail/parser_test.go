@@ -0,0 +1,180 @@
+package amsmail_test
+
+import (
+	"encoding/json"
+	"flag"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+
+	"bitbucket.org/radarventures/forwarder-adapters/utils/context"
+	ulog "bitbucket.org/radarventures/forwarder-adapters/utils/log"
+	"bitbucket.org/radarventures/forwarder-shipments/services/ams/amsmail"
+	"github.com/gin-gonic/gin"
+)
+
+var update = flag.Bool("update", false, "rewrite the golden files in testdata from the parser's output")
+
+// goldenMail is what a golden file holds for a mail: the parsed mail or the parse error.
+type goldenMail struct {
+	Kind       string        `json:"kind,omitempty"`
+	Reference  string        `json:"reference,omitempty"`
+	FileName   string        `json:"file_name,omitempty"`
+	HBLNo      string        `json:"hbl_no,omitempty"`
+	Status     string        `json:"status,omitempty"`
+	ErrorCount int           `json:"error_count,omitempty"`
+	Errors     []goldenError `json:"errors,omitempty"`
+	Error      string        `json:"error,omitempty"`
+}
+
+type goldenError struct {
+	File            string `json:"file"`
+	Document        string `json:"document"`
+	ErrorValue      string `json:"error_value"`
+	ErrorMessage    string `json:"error_message"`
+	ElementPosition string `json:"element_position"`
+}
+
+func toGolden(parsed *amsmail.ParsedMail, err error) *goldenMail {
+	if err != nil {
+		return &goldenMail{Error: err.Error()}
+	}
+
+	golden := &goldenMail{
+		Kind:       parsed.Kind,
+		Reference:  parsed.Reference,
+		FileName:   parsed.FileName,
+		HBLNo:      parsed.HBLNo,
+		Status:     parsed.Status,
+		ErrorCount: parsed.ErrorCount,
+	}
+	for _, e := range parsed.Errors {
+		golden.Errors = append(golden.Errors, goldenError{
+			File:            e.File,
+			Document:        e.Document,
+			ErrorValue:      e.ErrorValue,
+			ErrorMessage:    e.ErrorMessage,
+			ElementPosition: e.ElementPosition,
+		})
+	}
+
+	return golden
+}
+
+// Every testdata/<name>.eml is parsed and compared with testdata/<name>.json. Run the tests
+// with -update after changing the parser to rewrite the golden files, and review the diff.
+func TestParse(t *testing.T) {
+	files, err := filepath.Glob(filepath.Join("testdata", "*.eml"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) == 0 {
+		t.Fatal("no mails in testdata")
+	}
+
+	for _, file := range files {
+		name := strings.TrimSuffix(filepath.Base(file), ".eml")
+		t.Run(name, func(t *testing.T) {
+			message, err := amsmail.ReadEML(file)
+			if err != nil {
+				t.Fatalf("read %s: %v", file, err)
+			}
+
+			got := toGolden(amsmail.Parse(message, amsmail.ParserConfig{}))
+
+			goldenFile := filepath.Join("testdata", name+".json")
+			if *update {
+				b, err := json.MarshalIndent(got, "", "  ")
+				if err != nil {
+					t.Fatal(err)
+				}
+				if err := os.WriteFile(goldenFile, append(b, '\n'), 0o644); err != nil {
+					t.Fatal(err)
+				}
+				return
+			}
+
+			b, err := os.ReadFile(goldenFile)
+			if err != nil {
+				t.Fatalf("read golden file: %v", err)
+			}
+
+			want := &goldenMail{}
+			if err := json.Unmarshal(b, want); err != nil {
+				t.Fatalf("decode %s: %v", goldenFile, err)
+			}
+
+			if !reflect.DeepEqual(got, want) {
+				gotJSON, _ := json.MarshalIndent(got, "", "  ")
+				t.Fatalf("Parse(%s) =\n%s\nwant\n%s", file, gotJSON, b)
+			}
+		})
+	}
+}
+
+func TestParseKinds(t *testing.T) {
+	tests := []struct {
+		file string
+		kind string
+	}{
+		{file: "accepted.eml", kind: amsmail.KindAccepted},
+		{file: "rejected_single.eml", kind: amsmail.KindRejected},
+		{file: "rejected_multi.eml", kind: amsmail.KindRejected},
+		{file: "status.eml", kind: amsmail.KindStatus},
+	}
+
+	for _, tt := range tests {
+		message, err := amsmail.ReadEML(filepath.Join("testdata", tt.file))
+		if err != nil {
+			t.Fatalf("read %s: %v", tt.file, err)
+		}
+
+		parsed, err := amsmail.Parse(message, amsmail.ParserConfig{})
+		if err != nil {
+			t.Fatalf("Parse(%s): %v", tt.file, err)
+		}
+		if parsed.Kind != tt.kind {
+			t.Fatalf("Parse(%s).Kind = %q, want %q", tt.file, parsed.Kind, tt.kind)
+		}
+	}
+}
+
+// DirInbox replays the same mails, only the ones from the AMS sender are unread.
+func TestDirInboxUnread(t *testing.T) {
+	c := &context.Context{}
+	c.Log = ulog.New("amsmail-test", "forwarder-shipments", "error")
+	c.Context, _ = gin.CreateTestContext(httptest.NewRecorder())
+
+	messages, err := amsmail.NewDirInbox("testdata").Unread(c, amsmail.DefaultSender)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	files, _ := filepath.Glob(filepath.Join("testdata", "*.eml"))
+	want := make([]string, 0, len(files))
+	for _, file := range files {
+		want = append(want, filepath.Base(file))
+	}
+	sort.Strings(want)
+
+	got := make([]string, 0, len(messages))
+	for _, message := range messages {
+		got = append(got, message.Id)
+	}
+
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("Unread = %v, want %v", got, want)
+	}
+
+	messages, err = amsmail.NewDirInbox("testdata").Unread(c, "someone@example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(messages) != 0 {
+		t.Fatalf("Unread from another sender = %d mails, want 0", len(messages))
+	}
+}
@@ -0,0 +1,594 @@
+package dsr
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"html/template"
+	"net/mail"
+	"strings"
+	"time"
+
+	"bitbucket.org/radarventures/forwarder-adapters/apis/notifications"
+	miscdtos "bitbucket.org/radarventures/forwarder-adapters/dtos/misc"
+	dtos "bitbucket.org/radarventures/forwarder-adapters/dtos/shipments"
+	"bitbucket.org/radarventures/forwarder-adapters/utils/context"
+	"bitbucket.org/radarventures/forwarder-adapters/utils/upload"
+	"bitbucket.org/radarventures/forwarder-shipments/config"
+	"bitbucket.org/radarventures/forwarder-shipments/constants"
+	"bitbucket.org/radarventures/forwarder-shipments/daos/airwaybillinfo"
+	"bitbucket.org/radarventures/forwarder-shipments/daos/dsr"
+	"bitbucket.org/radarventures/forwarder-shipments/daos/shipment"
+	"bitbucket.org/radarventures/forwarder-shipments/database/models"
+	"github.com/google/uuid"
+	"go.uber.org/zap"
+)
+
+var (
+	ErrCompanyRequired    = errors.New("company is required")
+	ErrInvalidFormat      = errors.New("format must be xlsx or csv")
+	ErrInvalidFrequency   = errors.New("frequency must be daily, weekly or monthly")
+	ErrInvalidSchedule    = errors.New("send hour must be 0-23, weekday 0-6 and day of month 1-28")
+	ErrReceiversRequired  = errors.New("at least one valid receiver email is required")
+	ErrTypesRequired      = errors.New("at least one shipment type is required")
+	ErrUnknownColumn      = errors.New("unknown DSR column")
+	ErrSubscriptionClosed = errors.New("DSR subscription is not active")
+)
+
+type column struct {
+	key    string
+	header string
+	date   bool
+}
+
+// columns a DSR can be made of, in the order they are shown. The keys are the fields of the
+// DSR shipment rows; mawb and hawb come from the air waybills of the shipment.
+var columns = []column{
+	{key: "code", header: "Booking No"},
+	{key: "house_bill_nos", header: "House Bill No"},
+	{key: "mawb", header: "MAWB No"},
+	{key: "hawb", header: "HAWB No"},
+	{key: "type", header: "Type"},
+	{key: "status", header: "Status"},
+	{key: "incoterm", header: "Incoterm"},
+	{key: "pol_name", header: "POL"},
+	{key: "pod_name", header: "POD"},
+	{key: "cargo_ready_date", header: "Cargo Ready Date", date: true},
+	{key: "etd", header: "ETD", date: true},
+	{key: "eta", header: "ETA", date: true},
+	{key: "liner", header: "Liner"},
+	{key: "vessel_name", header: "Vessel"},
+	{key: "voyage_no", header: "Voyage"},
+	{key: "transit_days", header: "Transit Days"},
+	{key: "free_days", header: "Free Days"},
+	{key: "teus", header: "TEUs"},
+	{key: "count", header: "Packages"},
+	{key: "occupied_cbm", header: "CBM"},
+	{key: "occupied_weight", header: "Weight"},
+	{key: "occupied_volume_weight", header: "Volume Weight"},
+	{key: "door_pickup", header: "Door Pickup"},
+	{key: "door_delivery", header: "Door Delivery"},
+	{key: "created_at", header: "Booked On", date: true},
+}
+
+const dsrMailTemplate = `<p>Dear Customer,</p>
+<p>Your {{.Frequency}} shipment status report <b>{{.Name}}</b> for {{.Date}} is ready with {{.Rows}} shipments.</p>
+<p><a href="{{.Link}}">Download {{.FileName}}</a></p>
+<p>Regards,<br/>Operations Team</p>`
+
+type dsrMailDetail struct {
+	Name      string
+	Frequency string
+	Date      string
+	Rows      int
+	FileName  string
+	Link      string
+}
+
+// Report is a rendered DSR.
+type Report struct {
+	FileName    string
+	ContentType string
+	Data        []byte
+	Rows        int
+}
+
+type IDSRService interface {
+	Columns() []models.DSRColumn
+	SaveSubscription(ctx *context.Context, req *models.DSRSubscription) (*models.DSRSubscription, error)
+	GetSubscription(ctx *context.Context, id uuid.UUID) (*models.DSRSubscription, error)
+	GetSubscriptions(ctx *context.Context, companyId uuid.UUID) ([]*models.DSRSubscription, error)
+	DeleteSubscription(ctx *context.Context, id uuid.UUID) error
+	GetDeliveries(ctx *context.Context, subscriptionId uuid.UUID) ([]*models.DSRDelivery, error)
+
+	GetDue(ctx *context.Context, now time.Time) ([]*models.DSRSubscription, error)
+	Render(ctx *context.Context, sub *models.DSRSubscription, now time.Time) (*Report, error)
+	Deliver(ctx *context.Context, sub *models.DSRSubscription, now time.Time) (bool, error)
+}
+
+type DSRService struct {
+	dsrDb      dsr.IDSR
+	shipmentDb shipment.IShipment
+	awbDb      airwaybillinfo.IAirwayBillInfo
+	not        notifications.Notifications
+}
+
+func NewDSRService() IDSRService {
+	return &DSRService{
+		dsrDb:      dsr.NewDSR(),
+		shipmentDb: shipment.NewShipment(),
+		awbDb:      airwaybillinfo.NewAirwayBillInfo(),
+		not:        *notifications.New(config.Get().MiscURL),
+	}
+}
+
+func (s *DSRService) Columns() []models.DSRColumn {
+	res := make([]models.DSRColumn, 0, len(columns))
+	for _, c := range columns {
+		res = append(res, models.DSRColumn{Key: c.key, Header: c.header})
+	}
+
+	return res
+}
+
+func (s *DSRService) SaveSubscription(ctx *context.Context, req *models.DSRSubscription) (*models.DSRSubscription, error) {
+	if err := validate(req); err != nil {
+		return nil, err
+	}
+
+	now := time.Now().UTC()
+	if req.Id == uuid.Nil {
+		req.Id = uuid.New()
+		req.CreatedAt = now
+		if ctx.Account != nil {
+			req.CreatedBy = ctx.Account.ID
+		}
+	} else {
+		existing, err := s.dsrDb.Get(ctx, req.Id)
+		if err != nil {
+			return nil, err
+		}
+		req.CreatedAt = existing.CreatedAt
+		req.CreatedBy = existing.CreatedBy
+		req.LastSentAt = existing.LastSentAt
+	}
+
+	req.UpdatedAt = now
+	req.NextRunAt = NextRun(req, now)
+
+	if err := s.dsrDb.Save(ctx, req); err != nil {
+		ctx.Log.Error("unable to save DSR subscription", zap.Any("company_id", req.CompanyId), zap.Error(err))
+		return nil, err
+	}
+
+	return req, nil
+}
+
+func validate(req *models.DSRSubscription) error {
+	if req.CompanyId == uuid.Nil {
+		return ErrCompanyRequired
+	}
+
+	req.Format = strings.ToLower(strings.TrimSpace(req.Format))
+	if req.Format != constants.DSRFormatXLSX && req.Format != constants.DSRFormatCSV {
+		return ErrInvalidFormat
+	}
+
+	req.Frequency = strings.ToLower(strings.TrimSpace(req.Frequency))
+	switch req.Frequency {
+	case constants.DSRFrequencyDaily, constants.DSRFrequencyWeekly, constants.DSRFrequencyMonthly:
+	default:
+		return ErrInvalidFrequency
+	}
+
+	if req.SendHour < 0 || req.SendHour > 23 || req.Weekday < 0 || req.Weekday > 6 {
+		return ErrInvalidSchedule
+	}
+	if req.Frequency == constants.DSRFrequencyMonthly && (req.DayOfMonth < 1 || req.DayOfMonth > 28) {
+		return ErrInvalidSchedule
+	}
+
+	if len(req.Receivers) == 0 {
+		return ErrReceiversRequired
+	}
+	for i, receiver := range req.Receivers {
+		address, err := mail.ParseAddress(receiver)
+		if err != nil {
+			return ErrReceiversRequired
+		}
+		req.Receivers[i] = address.Address
+	}
+
+	if len(req.Types) == 0 {
+		return ErrTypesRequired
+	}
+
+	for _, key := range req.Columns {
+		if columnOf(key) == nil {
+			return fmt.Errorf("%w: %s", ErrUnknownColumn, key)
+		}
+	}
+
+	return nil
+}
+
+func columnOf(key string) *column {
+	for i := range columns {
+		if columns[i].key == key {
+			return &columns[i]
+		}
+	}
+
+	return nil
+}
+
+// NextRun is the first time after the given one the subscription is due, at its send hour in
+// UTC.
+func NextRun(sub *models.DSRSubscription, after time.Time) time.Time {
+	after = after.UTC()
+	next := time.Date(after.Year(), after.Month(), after.Day(), sub.SendHour, 0, 0, 0, time.UTC)
+
+	switch sub.Frequency {
+	case constants.DSRFrequencyWeekly:
+		next = next.AddDate(0, 0, (sub.Weekday-int(next.Weekday())+7)%7)
+		if !next.After(after) {
+			next = next.AddDate(0, 0, 7)
+		}
+	case constants.DSRFrequencyMonthly:
+		next = time.Date(after.Year(), after.Month(), sub.DayOfMonth, sub.SendHour, 0, 0, 0, time.UTC)
+		if !next.After(after) {
+			next = next.AddDate(0, 1, 0)
+		}
+	default:
+		if !next.After(after) {
+			next = next.AddDate(0, 0, 1)
+		}
+	}
+
+	return next
+}
+
+func (s *DSRService) GetSubscription(ctx *context.Context, id uuid.UUID) (*models.DSRSubscription, error) {
+	return s.dsrDb.Get(ctx, id)
+}
+
+func (s *DSRService) GetSubscriptions(ctx *context.Context, companyId uuid.UUID) ([]*models.DSRSubscription, error) {
+	return s.dsrDb.GetByCompany(ctx, companyId)
+}
+
+func (s *DSRService) DeleteSubscription(ctx *context.Context, id uuid.UUID) error {
+	return s.dsrDb.Delete(ctx, id)
+}
+
+func (s *DSRService) GetDeliveries(ctx *context.Context, subscriptionId uuid.UUID) ([]*models.DSRDelivery, error) {
+	return s.dsrDb.GetDeliveries(ctx, subscriptionId)
+}
+
+func (s *DSRService) GetDue(ctx *context.Context, now time.Time) ([]*models.DSRSubscription, error) {
+	return s.dsrDb.GetDue(ctx, now)
+}
+
+// Render builds the report of the subscription from the DSR shipment rows of its company.
+func (s *DSRService) Render(ctx *context.Context, sub *models.DSRSubscription, now time.Time) (*Report, error) {
+	shipments, err := s.shipmentDb.GetDSRShipments(ctx, &dtos.DSRShipmentParamters{
+		CompanyId: sub.CompanyId.String(),
+		Types:     sub.Types,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	rows := make([]map[string]interface{}, 0, len(shipments))
+	for _, shipment := range shipments {
+		row, err := toRow(shipment)
+		if err != nil {
+			return nil, err
+		}
+		if matches(sub, row, now) {
+			rows = append(rows, row)
+		}
+	}
+
+	selected := make([]*column, 0, len(sub.Columns))
+	for _, key := range sub.Columns {
+		if c := columnOf(key); c != nil {
+			selected = append(selected, c)
+		}
+	}
+	if len(selected) == 0 {
+		for i := range columns {
+			selected = append(selected, &columns[i])
+		}
+	}
+
+	if err := s.addAirwayBills(ctx, selected, rows); err != nil {
+		return nil, err
+	}
+
+	headers := make([]string, 0, len(selected))
+	for _, c := range selected {
+		headers = append(headers, c.header)
+	}
+
+	values := make([][]interface{}, 0, len(rows))
+	for _, row := range rows {
+		value := make([]interface{}, 0, len(selected))
+		for _, c := range selected {
+			value = append(value, cell(c, row[c.key]))
+		}
+		values = append(values, value)
+	}
+
+	report := &Report{
+		FileName: fmt.Sprintf("DSR-%s-%s.%s", fileSafe(sub.Name), now.Format("2006-01-02"), sub.Format),
+		Rows:     len(values),
+	}
+
+	if sub.Format == constants.DSRFormatCSV {
+		report.ContentType = constants.ContentTypeCSV
+		report.Data, err = renderCSV(headers, values)
+	} else {
+		report.ContentType = constants.ContentTypeXLSX
+		report.Data, err = renderXLSX(headers, values)
+	}
+	if err != nil {
+		ctx.Log.Error("unable to render DSR", zap.Any("subscription_id", sub.Id), zap.Error(err))
+		return nil, err
+	}
+
+	return report, nil
+}
+
+// addAirwayBills fills the mawb and hawb columns when the report has them.
+func (s *DSRService) addAirwayBills(ctx *context.Context, selected []*column, rows []map[string]interface{}) error {
+	if len(rows) == 0 {
+		return nil
+	}
+
+	ids := make([]string, 0, len(rows))
+	for _, row := range rows {
+		ids = append(ids, fmt.Sprint(row["id"]))
+	}
+
+	for _, c := range selected {
+		var awbs []*models.DSRAWB
+		var err error
+		switch c.key {
+		case "mawb":
+			awbs, err = s.awbDb.GetMAWBByShipmentId(ctx, ids)
+		case "hawb":
+			awbs, err = s.awbDb.GetHAWBByShipmentId(ctx, ids)
+		default:
+			continue
+		}
+		if err != nil {
+			return err
+		}
+
+		numbers := map[string][]string{}
+		for _, awb := range awbs {
+			row, err := toRow(awb)
+			if err != nil {
+				return err
+			}
+			id := fmt.Sprint(row["shipment_id"])
+			numbers[id] = append(numbers[id], fmt.Sprint(row["number"]))
+		}
+
+		for _, row := range rows {
+			row[c.key] = strings.Join(numbers[fmt.Sprint(row["id"])], ", ")
+		}
+	}
+
+	return nil
+}
+
+// toRow reads a row by its JSON fields, which are the keys of the columns.
+func toRow(v interface{}) (map[string]interface{}, error) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+
+	row := map[string]interface{}{}
+	return row, json.Unmarshal(data, &row)
+}
+
+func matches(sub *models.DSRSubscription, row map[string]interface{}, now time.Time) bool {
+	if len(sub.Statuses) > 0 && !containsFold(sub.Statuses, fmt.Sprint(row["status"])) {
+		return false
+	}
+	if len(sub.Pols) > 0 && !containsFold(sub.Pols, fmt.Sprint(row["pol_name"])) {
+		return false
+	}
+	if len(sub.Pods) > 0 && !containsFold(sub.Pods, fmt.Sprint(row["pod_name"])) {
+		return false
+	}
+	if sub.CreatedSince > 0 {
+		createdAt, ok := parseTime(row["created_at"])
+		if !ok || createdAt.Before(now.AddDate(0, 0, -sub.CreatedSince)) {
+			return false
+		}
+	}
+
+	return true
+}
+
+func containsFold(values []string, value string) bool {
+	for _, v := range values {
+		if strings.EqualFold(strings.TrimSpace(v), strings.TrimSpace(value)) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// cell turns a value of a row into a number or text of the report.
+func cell(c *column, value interface{}) interface{} {
+	if c.date {
+		if t, ok := parseTime(value); ok {
+			return t.Format("02-Jan-2006")
+		}
+		return ""
+	}
+
+	switch v := value.(type) {
+	case nil:
+		return ""
+	case float64:
+		return v
+	case bool:
+		if v {
+			return "Yes"
+		}
+		return "No"
+	case []interface{}:
+		parts := make([]string, 0, len(v))
+		for _, p := range v {
+			parts = append(parts, fmt.Sprint(p))
+		}
+		return strings.Join(parts, ", ")
+	case string:
+		return v
+	}
+
+	return fmt.Sprint(value)
+}
+
+func parseTime(value interface{}) (time.Time, bool) {
+	s, ok := value.(string)
+	if !ok || s == "" {
+		return time.Time{}, false
+	}
+
+	t, err := time.Parse(time.RFC3339, s)
+	if err != nil || t.IsZero() || t.Year() <= 1 {
+		return time.Time{}, false
+	}
+
+	return t, true
+}
+
+func fileSafe(name string) string {
+	name = strings.Map(func(r rune) rune {
+		if r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || r == '-' || r == '_' {
+			return r
+		}
+		return '-'
+	}, strings.TrimSpace(name))
+	if name == "" {
+		return "report"
+	}
+
+	return name
+}
+
+// Deliver renders the due report of a subscription, uploads it and mails it to the receivers.
+// The run is claimed first, so a report is sent at most once per run even if the job overlaps;
+// it reports false when another run claimed it. A report that could not be sent releases the
+// run, so the next run of the job retries it.
+func (s *DSRService) Deliver(ctx *context.Context, sub *models.DSRSubscription, now time.Time) (bool, error) {
+	if !sub.IsActive {
+		return false, ErrSubscriptionClosed
+	}
+
+	nextRunAt := NextRun(sub, now)
+	claimed, err := s.dsrDb.ClaimRun(ctx, sub.Id, sub.NextRunAt, nextRunAt)
+	if err != nil || !claimed {
+		return false, err
+	}
+
+	delivery := &models.DSRDelivery{
+		Id:             uuid.New(),
+		SubscriptionId: sub.Id,
+		CompanyId:      sub.CompanyId,
+		Receivers:      sub.Receivers,
+		Status:         constants.DSRDeliveryFailed,
+		CreatedAt:      now,
+	}
+
+	err = s.deliver(ctx, sub, delivery, now)
+	if err != nil {
+		delivery.Error = err.Error()
+		if releaseErr := s.dsrDb.ReleaseRun(ctx, sub.Id, nextRunAt, sub.NextRunAt); releaseErr != nil {
+			ctx.Log.Error("unable to release DSR run", zap.Any("subscription_id", sub.Id), zap.Error(releaseErr))
+		}
+	}
+
+	if recordErr := s.dsrDb.CreateDelivery(ctx, delivery); recordErr != nil && err == nil {
+		err = recordErr
+	}
+
+	return err == nil, err
+}
+
+func (s *DSRService) deliver(ctx *context.Context, sub *models.DSRSubscription, delivery *models.DSRDelivery, now time.Time) error {
+	report, err := s.Render(ctx, sub, now)
+	if err != nil {
+		return err
+	}
+	delivery.FileName = report.FileName
+	delivery.Rows = report.Rows
+
+	uploadReq := &upload.UploadReq{
+		File:     report.Data,
+		Folder:   fmt.Sprintf("/companies/%v/dsr", sub.CompanyId),
+		FileName: report.FileName,
+	}
+	if sub.Format == constants.DSRFormatCSV {
+		uploadReq.FileFormat = constants.DSRFormatCSV
+		uploadReq.ContentType = constants.ContentTypeCSV
+	} else {
+		uploadReq.FileFormat = constants.DSRFormatXLSX
+		uploadReq.ContentType = constants.ContentTypeXLSX
+	}
+
+	docRes, err := upload.New(config.Get().MiscURL).UploadToS3(ctx, uploadReq)
+	if err != nil {
+		ctx.Log.Error("unable to upload DSR", zap.Any("subscription_id", sub.Id), zap.Error(err))
+		return err
+	}
+	delivery.DocumentId = fmt.Sprint(docRes.DocumentId)
+
+	tmpl, err := template.New("DSRMail").Parse(dsrMailTemplate)
+	if err != nil {
+		return err
+	}
+
+	buf := new(bytes.Buffer)
+	err = tmpl.Execute(buf, &dsrMailDetail{
+		Name:      sub.Name,
+		Frequency: sub.Frequency,
+		Date:      now.Format("02 Jan 2006"),
+		Rows:      report.Rows,
+		FileName:  report.FileName,
+		Link:      config.Get().BaseURL + "/documents/" + delivery.DocumentId,
+	})
+	if err != nil {
+		ctx.Log.Error("unable to execute DSR template", zap.Any("subscription_id", sub.Id), zap.Error(err))
+		return err
+	}
+
+	err = s.not.SendNotification(ctx, &miscdtos.Notification{
+		ID:              uuid.New().String(),
+		Type:            constants.NotTypeEmail,
+		Title:           fmt.Sprintf("Shipment status report %s - %s", sub.Name, now.Format("02 Jan 2006")),
+		Sender:          config.Get().EmailSenderBot,
+		IsTransactional: true,
+		Content:         buf.String(),
+		Receivers:       sub.Receivers,
+	})
+	if err != nil {
+		ctx.Log.Error("unable to send DSR", zap.Any("subscription_id", sub.Id), zap.Error(err))
+		return err
+	}
+
+	delivery.Status = constants.DSRDeliverySent
+	if err := s.dsrDb.SetLastSent(ctx, sub.Id, now); err != nil {
+		ctx.Log.Error("unable to set DSR last sent", zap.Any("subscription_id", sub.Id), zap.Error(err))
+	}
+
+	return nil
+}
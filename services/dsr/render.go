@@ -0,0 +1,151 @@
+package dsr
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/csv"
+	"encoding/xml"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// renderCSV writes the report as CSV. Text starting like a formula is quoted with an
+// apostrophe so spreadsheets do not run it.
+func renderCSV(headers []string, rows [][]interface{}) ([]byte, error) {
+	buf := new(bytes.Buffer)
+	w := csv.NewWriter(buf)
+
+	if err := w.Write(headers); err != nil {
+		return nil, err
+	}
+
+	record := make([]string, len(headers))
+	for _, row := range rows {
+		for i, value := range row {
+			switch v := value.(type) {
+			case float64:
+				record[i] = strconv.FormatFloat(v, 'f', -1, 64)
+			case string:
+				if v != "" && strings.ContainsRune("=+-@", rune(v[0])) {
+					v = "'" + v
+				}
+				record[i] = v
+			default:
+				record[i] = ""
+			}
+		}
+		if err := w.Write(record); err != nil {
+			return nil, err
+		}
+	}
+
+	w.Flush()
+	return buf.Bytes(), w.Error()
+}
+
+const (
+	xlsxContentTypes = `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<Types xmlns="http://schemas.openxmlformats.org/package/2006/content-types"><Default Extension="rels" ContentType="application/vnd.openxmlformats-package.relationships+xml"/><Default Extension="xml" ContentType="application/xml"/><Override PartName="/xl/workbook.xml" ContentType="application/vnd.openxmlformats-officedocument.spreadsheetml.sheet.main+xml"/><Override PartName="/xl/worksheets/sheet1.xml" ContentType="application/vnd.openxmlformats-officedocument.spreadsheetml.worksheet+xml"/><Override PartName="/xl/styles.xml" ContentType="application/vnd.openxmlformats-officedocument.spreadsheetml.styles+xml"/></Types>`
+
+	xlsxRels = `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<Relationships xmlns="http://schemas.openxmlformats.org/package/2006/relationships"><Relationship Id="rId1" Type="http://schemas.openxmlformats.org/officeDocument/2006/relationships/officeDocument" Target="xl/workbook.xml"/></Relationships>`
+
+	xlsxWorkbook = `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<workbook xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main" xmlns:r="http://schemas.openxmlformats.org/officeDocument/2006/relationships"><sheets><sheet name="DSR" sheetId="1" r:id="rId1"/></sheets></workbook>`
+
+	xlsxWorkbookRels = `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<Relationships xmlns="http://schemas.openxmlformats.org/package/2006/relationships"><Relationship Id="rId1" Type="http://schemas.openxmlformats.org/officeDocument/2006/relationships/worksheet" Target="worksheets/sheet1.xml"/><Relationship Id="rId2" Type="http://schemas.openxmlformats.org/officeDocument/2006/relationships/styles" Target="styles.xml"/></Relationships>`
+
+	// Style 1 is the bold header
+	xlsxStyles = `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<styleSheet xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main"><fonts count="2"><font><sz val="11"/><name val="Calibri"/></font><font><b/><sz val="11"/><name val="Calibri"/></font></fonts><fills count="2"><fill><patternFill patternType="none"/></fill><fill><patternFill patternType="gray125"/></fill></fills><borders count="1"><border><left/><right/><top/><bottom/><diagonal/></border></borders><cellStyleXfs count="1"><xf numFmtId="0" fontId="0" fillId="0" borderId="0"/></cellStyleXfs><cellXfs count="2"><xf numFmtId="0" fontId="0" fillId="0" borderId="0" xfId="0"/><xf numFmtId="0" fontId="1" fillId="0" borderId="0" xfId="0" applyFont="1"/></cellXfs></styleSheet>`
+)
+
+// renderXLSX writes the report as a single sheet workbook with a frozen bold header row.
+// Numbers are written as numbers, everything else as inline text.
+func renderXLSX(headers []string, rows [][]interface{}) ([]byte, error) {
+	sheet := new(bytes.Buffer)
+	sheet.WriteString(`<?xml version="1.0" encoding="UTF-8" standalone="yes"?>`)
+	sheet.WriteString(`<worksheet xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main">`)
+	sheet.WriteString(`<sheetViews><sheetView workbookViewId="0"><pane ySplit="1" topLeftCell="A2" activePane="bottomLeft" state="frozen"/></sheetView></sheetViews>`)
+	sheet.WriteString(`<sheetData>`)
+
+	header := make([]interface{}, len(headers))
+	for i, h := range headers {
+		header[i] = h
+	}
+	if err := writeXLSXRow(sheet, 1, header, true); err != nil {
+		return nil, err
+	}
+	for i, row := range rows {
+		if err := writeXLSXRow(sheet, i+2, row, false); err != nil {
+			return nil, err
+		}
+	}
+	sheet.WriteString(`</sheetData></worksheet>`)
+
+	buf := new(bytes.Buffer)
+	zw := zip.NewWriter(buf)
+	parts := []struct {
+		name    string
+		content []byte
+	}{
+		{"[Content_Types].xml", []byte(xlsxContentTypes)},
+		{"_rels/.rels", []byte(xlsxRels)},
+		{"xl/workbook.xml", []byte(xlsxWorkbook)},
+		{"xl/_rels/workbook.xml.rels", []byte(xlsxWorkbookRels)},
+		{"xl/styles.xml", []byte(xlsxStyles)},
+		{"xl/worksheets/sheet1.xml", sheet.Bytes()},
+	}
+	for _, part := range parts {
+		f, err := zw.Create(part.name)
+		if err != nil {
+			return nil, err
+		}
+		if _, err := f.Write(part.content); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := zw.Close(); err != nil {
+		return nil, err
+	}
+
+	return buf.Bytes(), nil
+}
+
+func writeXLSXRow(buf *bytes.Buffer, n int, values []interface{}, bold bool) error {
+	fmt.Fprintf(buf, `<row r="%d">`, n)
+	for i, value := range values {
+		ref := xlsxColumn(i) + strconv.Itoa(n)
+		style := ""
+		if bold {
+			style = ` s="1"`
+		}
+
+		switch v := value.(type) {
+		case float64:
+			fmt.Fprintf(buf, `<c r="%s"%s><v>%s</v></c>`, ref, style, strconv.FormatFloat(v, 'f', -1, 64))
+		case string:
+			fmt.Fprintf(buf, `<c r="%s"%s t="inlineStr"><is><t xml:space="preserve">`, ref, style)
+			if err := xml.EscapeText(buf, []byte(v)); err != nil {
+				return err
+			}
+			buf.WriteString(`</t></is></c>`)
+		}
+	}
+	buf.WriteString(`</row>`)
+
+	return nil
+}
+
+// xlsxColumn returns the letters of the i-th column, A for 0.
+func xlsxColumn(i int) string {
+	name := ""
+	for i++; i > 0; i = (i - 1) / 26 {
+		name = string(rune('A'+(i-1)%26)) + name
+	}
+
+	return name
+}
@@ -0,0 +1,519 @@
+package gpapproval
+
+import (
+	"errors"
+	"time"
+
+	"bitbucket.org/radarventures/forwarder-adapters/utils/context"
+	"bitbucket.org/radarventures/forwarder-shipments/constants"
+	"bitbucket.org/radarventures/forwarder-shipments/daos/card"
+	"bitbucket.org/radarventures/forwarder-shipments/daos/marginpolicy"
+	"bitbucket.org/radarventures/forwarder-shipments/database/models"
+	"bitbucket.org/radarventures/forwarder-shipments/services/quoteversion"
+	"github.com/google/uuid"
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+)
+
+// ApprovalCardSLA is how long an approver has to decide before their card is overdue.
+const ApprovalCardSLA = 24 * time.Hour
+
+var (
+	ErrPolicyNameRequired  = errors.New("margin policy name is required")
+	ErrInvalidThresholds   = errors.New("a margin policy needs a minimum GP % below 100 or a minimum GP, and neither can be negative")
+	ErrApproversRequired   = errors.New("a margin policy needs at least one approver")
+	ErrInvalidApprovers    = errors.New("approvers must be distinct account ids")
+	ErrTierRequired        = errors.New("company and tier are required")
+	ErrApprovalClosed      = errors.New("GP approval is no longer pending")
+	ErrNotCurrentApprover  = errors.New("only the approver whose turn it is can decide the GP approval")
+	ErrSelfApproval        = errors.New("a GP approval cannot be decided by its requester")
+	ErrApprovalConcurrency = errors.New("GP approval was changed by another request, retry")
+	ErrDecisionRequired    = errors.New("approve is required to decide a GP approval")
+)
+
+type IGPApprovalService interface {
+	SavePolicy(ctx *context.Context, req *models.MarginPolicy) (*models.MarginPolicy, error)
+	GetPolicies(ctx *context.Context, regionId string) ([]*models.MarginPolicy, error)
+	DeletePolicy(ctx *context.Context, id uuid.UUID) error
+	SaveTier(ctx *context.Context, req *models.MarginCustomerTier) error
+
+	EvaluateQuote(ctx *context.Context, quoteId string) (*models.GPEvaluation, error)
+	EvaluateShipment(ctx *context.Context, shipmentId string) (*models.GPEvaluation, error)
+	CheckShare(ctx *context.Context, quoteId string) (*models.GPApprovalRequiredError, error)
+
+	Decide(ctx *context.Context, id uuid.UUID, req *models.GPDecisionReq) (*models.GPApproval, error)
+	DecideQuote(ctx *context.Context, quoteId string, req *models.GPDecisionReq) (*models.GPApproval, error)
+	GetApprovals(ctx *context.Context, quoteId string) ([]*models.GPApproval, error)
+	GetApproval(ctx *context.Context, id uuid.UUID) (*models.GPApprovalDetail, error)
+	GetPending(ctx *context.Context) ([]*models.GPApproval, error)
+}
+
+type GPApprovalService struct {
+	policyDb     marginpolicy.IMarginPolicy
+	cardDb       card.ICard
+	quoteVersion quoteversion.IQuoteVersionService
+}
+
+func NewGPApprovalService() IGPApprovalService {
+	return &GPApprovalService{
+		policyDb:     marginpolicy.NewMarginPolicy(),
+		cardDb:       card.NewCard(),
+		quoteVersion: quoteversion.NewQuoteVersionService(),
+	}
+}
+
+func (s *GPApprovalService) SavePolicy(ctx *context.Context, req *models.MarginPolicy) (*models.MarginPolicy, error) {
+	if req.Name == "" {
+		return nil, ErrPolicyNameRequired
+	}
+
+	if req.MinGPPercent < 0 || req.MinGPPercent >= 100 || req.MinGP < 0 || (req.MinGPPercent == 0 && req.MinGP == 0) {
+		return nil, ErrInvalidThresholds
+	}
+
+	if len(req.Approvers) == 0 {
+		return nil, ErrApproversRequired
+	}
+
+	seen := map[string]bool{}
+	for i, approver := range req.Approvers {
+		id, err := uuid.Parse(approver)
+		if err != nil || seen[id.String()] {
+			return nil, ErrInvalidApprovers
+		}
+		seen[id.String()] = true
+		req.Approvers[i] = id.String()
+	}
+
+	now := time.Now().UTC()
+	if req.Id == uuid.Nil {
+		req.Id = uuid.New()
+		req.CreatedAt = now
+	} else {
+		existing, err := s.policyDb.Get(ctx, req.Id)
+		if err != nil {
+			return nil, err
+		}
+		req.CreatedAt = existing.CreatedAt
+	}
+	req.UpdatedAt = now
+	if ctx.Account != nil {
+		req.UpdatedBy = ctx.Account.ID
+	}
+
+	if err := s.policyDb.Upsert(ctx, req); err != nil {
+		return nil, err
+	}
+
+	return req, nil
+}
+
+func (s *GPApprovalService) GetPolicies(ctx *context.Context, regionId string) ([]*models.MarginPolicy, error) {
+	return s.policyDb.GetForRegion(ctx, regionId, false)
+}
+
+// DeletePolicy removes a policy. Approvals asked under it stay in the history, and the next
+// evaluation of their quotes supersedes the pending ones.
+func (s *GPApprovalService) DeletePolicy(ctx *context.Context, id uuid.UUID) error {
+	return s.policyDb.Delete(ctx, id)
+}
+
+func (s *GPApprovalService) SaveTier(ctx *context.Context, req *models.MarginCustomerTier) error {
+	if req.CompanyId == "" || req.Tier == "" {
+		return ErrTierRequired
+	}
+
+	req.UpdatedAt = time.Now().UTC()
+	if ctx.Account != nil {
+		req.UpdatedBy = ctx.Account.ID
+	}
+
+	return s.policyDb.UpsertTier(ctx, req)
+}
+
+// EvaluateQuote checks the latest version of a quote against the policy of its enquiry, and
+// asks the approvers of the policy when it falls below it.
+func (s *GPApprovalService) EvaluateQuote(ctx *context.Context, quoteId string) (*models.GPEvaluation, error) {
+	subject, err := s.policyDb.GetQuoteSubject(ctx, quoteId)
+	if err != nil {
+		return nil, err
+	}
+
+	return s.evaluate(ctx, subject, constants.GPSourceQuote)
+}
+
+// EvaluateShipment checks the quote a shipment was booked on, after its partner invoices
+// changed.
+func (s *GPApprovalService) EvaluateShipment(ctx *context.Context, shipmentId string) (*models.GPEvaluation, error) {
+	subject, err := s.policyDb.GetShipmentSubject(ctx, shipmentId)
+	if err != nil {
+		return nil, err
+	}
+
+	if subject.QuoteId == "" {
+		return &models.GPEvaluation{Breaches: []string{}}, nil
+	}
+
+	return s.evaluate(ctx, subject, constants.GPSourcePartnerInvoice)
+}
+
+// CheckShare returns why a quote cannot be shared yet, or nil when it can. Quotes outside an
+// enquiry are not guarded.
+func (s *GPApprovalService) CheckShare(ctx *context.Context, quoteId string) (*models.GPApprovalRequiredError, error) {
+	eval, err := s.EvaluateQuote(ctx, quoteId)
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	if !eval.Blocked {
+		return nil, nil
+	}
+
+	res := &models.GPApprovalRequiredError{
+		Message:  "quote is below the margin policy and needs its GP approved before it is shared",
+		QuoteId:  quoteId,
+		Breaches: eval.Breaches,
+		Margin:   *eval.Margin,
+		Approval: eval.Approval,
+	}
+	if eval.Approval != nil && eval.Approval.Status == constants.GPApprovalRejected {
+		res.Message = "GP of the quote was rejected, revise the quote before it is shared"
+	}
+
+	return res, nil
+}
+
+func (s *GPApprovalService) evaluate(ctx *context.Context, subject *models.GPSubject, source string) (*models.GPEvaluation, error) {
+	res := &models.GPEvaluation{QuoteId: subject.QuoteId, Breaches: []string{}}
+
+	tier, err := s.policyDb.GetTier(ctx, subject.CompanyId)
+	if err != nil {
+		return nil, err
+	}
+
+	policies, err := s.policyDb.GetForRegion(ctx, subject.RegionId, true)
+	if err != nil {
+		return nil, err
+	}
+
+	res.Policy = match(policies, subject, tier)
+	if res.Policy != nil {
+		res.Margin, err = s.quoteVersion.Margin(ctx, subject.QuoteId, subject.RegionId)
+		if err != nil {
+			// A quote without line items has no margin to judge yet
+			if errors.Is(err, quoteversion.ErrVersionNotFound) {
+				return res, nil
+			}
+			// Judging a margin that could not be read would block the quote on a 0% GP
+			if errors.Is(err, quoteversion.ErrMarginUnknown) {
+				ctx.Log.Warn("quote GP not evaluated", zap.String("quote_id", subject.QuoteId), zap.Error(err))
+				res.Margin = nil
+				return res, nil
+			}
+			return nil, err
+		}
+		res.Breaches = breaches(res.Policy, res.Margin)
+	}
+
+	var (
+		raise  *models.Card
+		closed []uuid.UUID
+	)
+	err = ctx.DB.WithContext(ctx.Request.Context()).Transaction(func(tx *gorm.DB) error {
+		latest, err := s.policyDb.GetLatestApprovalWithTx(ctx, tx, subject.QuoteId)
+		if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+			return err
+		}
+		if err != nil {
+			latest = nil
+		}
+
+		if len(res.Breaches) == 0 {
+			res.Approval = latest
+			return s.supersede(ctx, tx, latest, &closed, "quote is within its margin policy")
+		}
+
+		if covers(latest, res.Policy, res.Margin) {
+			res.Approval = latest
+			return nil
+		}
+
+		res.Blocked = true
+		if asks(latest, res.Policy, res.Margin) {
+			res.Approval = latest
+			return nil
+		}
+
+		if err := s.supersede(ctx, tx, latest, &closed, "margin of the quote changed"); err != nil {
+			return err
+		}
+
+		approval := &models.GPApproval{
+			Id:           uuid.New(),
+			QuoteId:      subject.QuoteId,
+			RfqId:        subject.RfqId,
+			ShipmentId:   subject.ShipmentId,
+			Source:       source,
+			PolicyId:     res.Policy.Id,
+			RegionId:     subject.RegionId,
+			Buy:          res.Margin.Buy,
+			Sell:         res.Margin.Sell,
+			GP:           res.Margin.Margin,
+			GPPercent:    res.Margin.MarginPercent,
+			MinGP:        res.Policy.MinGP,
+			MinGPPercent: res.Policy.MinGPPercent,
+			Approvers:    res.Policy.Approvers,
+			Level:        0,
+			Status:       constants.GPApprovalPending,
+			RequestedBy:  actor(ctx),
+			RequestedAt:  time.Now().UTC(),
+		}
+
+		raise = s.card(approval)
+		approval.CardId = &raise.Id
+		if err := s.policyDb.CreateApprovalWithTx(ctx, tx, approval); err != nil {
+			return err
+		}
+		res.Approval = approval
+
+		return s.policyDb.CreateEventWithTx(ctx, tx, event(ctx, approval, constants.GPEventRequested, res.Breaches[0]))
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	s.closeCards(ctx, closed)
+	if raise != nil {
+		s.raiseCard(ctx, raise)
+		ctx.Log.Info("GP approval requested", zap.String("quote_id", subject.QuoteId), zap.String("source", source), zap.Strings("breaches", res.Breaches))
+	}
+
+	return res, nil
+}
+
+// supersede closes a pending approval that no longer matches the margin of its quote.
+func (s *GPApprovalService) supersede(ctx *context.Context, tx *gorm.DB, approval *models.GPApproval, closed *[]uuid.UUID, note string) error {
+	if approval == nil || approval.Status != constants.GPApprovalPending {
+		return nil
+	}
+
+	ok, err := s.policyDb.UpdateApprovalWithTx(ctx, tx, approval.Id, constants.GPApprovalPending, map[string]interface{}{
+		"status": constants.GPApprovalSuperseded,
+	})
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return ErrApprovalConcurrency
+	}
+	approval.Status = constants.GPApprovalSuperseded
+
+	if approval.CardId != nil {
+		*closed = append(*closed, *approval.CardId)
+	}
+
+	return s.policyDb.CreateEventWithTx(ctx, tx, event(ctx, approval, constants.GPEventSuperseded, note))
+}
+
+// Decide records the decision of the approver whose turn it is. Approving passes the quote to
+// the next approver of the chain, or approves it when they were the last one.
+func (s *GPApprovalService) Decide(ctx *context.Context, id uuid.UUID, req *models.GPDecisionReq) (*models.GPApproval, error) {
+	return s.decide(ctx, req, func(tx *gorm.DB) (*models.GPApproval, error) {
+		return s.policyDb.GetApprovalWithTx(ctx, tx, id)
+	})
+}
+
+// DecideQuote decides the latest approval of a quote. A quote that never needed one returns
+// nil, so callers can fall back to the quote's own GP flag.
+func (s *GPApprovalService) DecideQuote(ctx *context.Context, quoteId string, req *models.GPDecisionReq) (*models.GPApproval, error) {
+	approval, err := s.decide(ctx, req, func(tx *gorm.DB) (*models.GPApproval, error) {
+		latest, err := s.policyDb.GetLatestApprovalWithTx(ctx, tx, quoteId)
+		if err != nil {
+			return nil, err
+		}
+		return s.policyDb.GetApprovalWithTx(ctx, tx, latest.Id)
+	})
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, nil
+	}
+
+	return approval, err
+}
+
+func (s *GPApprovalService) decide(ctx *context.Context, req *models.GPDecisionReq, load func(tx *gorm.DB) (*models.GPApproval, error)) (*models.GPApproval, error) {
+	now := time.Now().UTC()
+	decidedBy := actor(ctx)
+
+	var (
+		approval *models.GPApproval
+		raise    *models.Card
+		closed   []uuid.UUID
+	)
+	err := ctx.DB.WithContext(ctx.Request.Context()).Transaction(func(tx *gorm.DB) error {
+		var err error
+		approval, err = load(tx)
+		if err != nil {
+			return err
+		}
+
+		if approval.Status != constants.GPApprovalPending {
+			return ErrApprovalClosed
+		}
+
+		if req.Approve == nil {
+			return ErrDecisionRequired
+		}
+
+		if approval.RequestedBy == decidedBy {
+			return ErrSelfApproval
+		}
+
+		if approval.Level >= len(approval.Approvers) || approval.Approvers[approval.Level] != decidedBy {
+			return ErrNotCurrentApprover
+		}
+
+		if approval.CardId != nil {
+			closed = append(closed, *approval.CardId)
+		}
+
+		name := constants.GPEventRejected
+		fields := map[string]interface{}{}
+		switch {
+		case !*req.Approve:
+			fields["status"] = constants.GPApprovalRejected
+		case approval.Level+1 < len(approval.Approvers):
+			name = constants.GPEventApproved
+			approval.Level++
+			raise = s.card(approval)
+			fields["level"] = approval.Level
+			fields["card_id"] = raise.Id
+		default:
+			name = constants.GPEventApproved
+			fields["status"] = constants.GPApprovalApproved
+		}
+		if _, ok := fields["status"]; ok {
+			fields["decided_by"] = decidedBy
+			fields["decided_at"] = now
+			fields["decision_note"] = req.Note
+		}
+
+		// The event is recorded at the level that was decided
+		e := event(ctx, approval, name, req.Note)
+		if raise != nil {
+			e.Level--
+		}
+
+		ok, err := s.policyDb.UpdateApprovalWithTx(ctx, tx, approval.Id, constants.GPApprovalPending, fields)
+		if err != nil {
+			return err
+		}
+		if !ok {
+			return ErrApprovalConcurrency
+		}
+
+		if err := s.policyDb.CreateEventWithTx(ctx, tx, e); err != nil {
+			return err
+		}
+
+		approval, err = s.policyDb.GetApprovalWithTx(ctx, tx, approval.Id)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	s.closeCards(ctx, closed)
+	if raise != nil {
+		s.raiseCard(ctx, raise)
+	}
+
+	ctx.Log.Info("GP approval decided", zap.Any("approval_id", approval.Id), zap.String("quote_id", approval.QuoteId), zap.String("status", approval.Status), zap.Int("level", approval.Level))
+
+	return approval, nil
+}
+
+func (s *GPApprovalService) GetApprovals(ctx *context.Context, quoteId string) ([]*models.GPApproval, error) {
+	return s.policyDb.GetApprovals(ctx, quoteId)
+}
+
+func (s *GPApprovalService) GetApproval(ctx *context.Context, id uuid.UUID) (*models.GPApprovalDetail, error) {
+	approval, err := s.policyDb.GetApproval(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+
+	events, err := s.policyDb.GetEvents(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+
+	return &models.GPApprovalDetail{
+		Approval: approval,
+		Events:   events,
+	}, nil
+}
+
+// GetPending returns the approvals waiting for the decision of the calling account.
+func (s *GPApprovalService) GetPending(ctx *context.Context) ([]*models.GPApproval, error) {
+	return s.policyDb.GetPendingForApprover(ctx, actor(ctx))
+}
+
+// card builds the card of the approver whose turn it is. Approvals asked from a quote hang off
+// its enquiry, those asked from partner invoices off the shipment.
+func (s *GPApprovalService) card(approval *models.GPApproval) *models.Card {
+	c := &models.Card{
+		Id:           uuid.New(),
+		Name:         constants.CardGPApproval,
+		InstanceId:   approval.RfqId,
+		InstanceType: constants.WorkflowTypeRFQ,
+		AssignedTo:   approval.Approvers[approval.Level],
+		Status:       constants.CardStatusCreated,
+		Estimate:     time.Now().UTC().Add(ApprovalCardSLA),
+	}
+	if approval.Source == constants.GPSourcePartnerInvoice || approval.RfqId == "" {
+		c.InstanceId = approval.ShipmentId
+		c.InstanceType = constants.WorkflowTypeShipment
+	}
+
+	return c
+}
+
+// raiseCard creates the card after the approval is committed. A failure is only logged: the
+// approval stays listed in the pending approvals of the approver.
+func (s *GPApprovalService) raiseCard(ctx *context.Context, c *models.Card) {
+	if err := s.cardDb.Upsert(ctx, c); err != nil {
+		ctx.Log.Error("unable to raise GP approval card", zap.String("instance_id", c.InstanceId), zap.String("assigned_to", c.AssignedTo), zap.Error(err))
+	}
+}
+
+func (s *GPApprovalService) closeCards(ctx *context.Context, ids []uuid.UUID) {
+	for _, id := range ids {
+		if err := s.cardDb.UpdateStatus(ctx, id.String(), constants.CardStatusCompleted); err != nil {
+			ctx.Log.Error("unable to complete GP approval card", zap.Any("card_id", id), zap.Error(err))
+		}
+	}
+}
+
+func event(ctx *context.Context, approval *models.GPApproval, name, note string) *models.GPApprovalEvent {
+	return &models.GPApprovalEvent{
+		Id:         uuid.New(),
+		ApprovalId: approval.Id,
+		Event:      name,
+		Level:      approval.Level,
+		Note:       note,
+		ActorId:    actor(ctx),
+		CreatedAt:  time.Now().UTC(),
+	}
+}
+
+// actor is the account acting on an approval, or "system" for evaluations run without one.
+func actor(ctx *context.Context) string {
+	if ctx.Account == nil {
+		return "system"
+	}
+
+	return ctx.Account.ID.String()
+}
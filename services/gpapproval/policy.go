@@ -0,0 +1,84 @@
+package gpapproval
+
+import (
+	"fmt"
+	"math"
+
+	"bitbucket.org/radarventures/forwarder-shipments/constants"
+	"bitbucket.org/radarventures/forwarder-shipments/database/models"
+)
+
+// gpTolerance absorbs rounding when a GP is compared with the GP it was approved at.
+const gpTolerance = 0.005
+
+// match returns the active policy that applies to the subject. A policy naming the region,
+// shipment type or tier is more specific than one that leaves it open and wins over it; among
+// equally specific policies the lowest priority wins.
+func match(policies []*models.MarginPolicy, subject *models.GPSubject, tier string) *models.MarginPolicy {
+	var best *models.MarginPolicy
+	bestScore := -1
+	for _, p := range policies {
+		if !p.IsActive {
+			continue
+		}
+		if (p.RegionId != "" && p.RegionId != subject.RegionId) ||
+			(p.ShipmentType != "" && p.ShipmentType != subject.Type) ||
+			(p.CustomerTier != "" && p.CustomerTier != tier) {
+			continue
+		}
+
+		score := 0
+		if p.RegionId != "" {
+			score += 4
+		}
+		if p.ShipmentType != "" {
+			score += 2
+		}
+		if p.CustomerTier != "" {
+			score++
+		}
+
+		if score > bestScore || (score == bestScore && p.Priority < best.Priority) {
+			best, bestScore = p, score
+		}
+	}
+
+	return best
+}
+
+// breaches returns the thresholds of the policy the margin is below.
+func breaches(policy *models.MarginPolicy, margin *models.QuoteMargin) []string {
+	res := []string{}
+	if policy == nil {
+		return res
+	}
+
+	if policy.MinGPPercent > 0 && margin.MarginPercent < policy.MinGPPercent {
+		res = append(res, fmt.Sprintf("GP %.2f%% is below the minimum of %.2f%%", margin.MarginPercent, policy.MinGPPercent))
+	}
+	if policy.MinGP > 0 && margin.Margin < policy.MinGP {
+		res = append(res, fmt.Sprintf("GP %.2f is below the minimum of %.2f", margin.Margin, policy.MinGP))
+	}
+
+	return res
+}
+
+// covers reports whether an approval accepts the margin: it was approved under the same
+// policy and the GP has not fallen below what was approved.
+func covers(approval *models.GPApproval, policy *models.MarginPolicy, margin *models.QuoteMargin) bool {
+	return approval != nil &&
+		approval.Status == constants.GPApprovalApproved &&
+		approval.PolicyId == policy.Id &&
+		margin.Margin >= approval.GP-gpTolerance &&
+		margin.MarginPercent >= approval.GPPercent-gpTolerance
+}
+
+// asks reports whether an open or rejected approval was already asked for this policy and
+// margin, so that saving an unchanged quote does not ask the approvers again.
+func asks(approval *models.GPApproval, policy *models.MarginPolicy, margin *models.QuoteMargin) bool {
+	return approval != nil &&
+		(approval.Status == constants.GPApprovalPending || approval.Status == constants.GPApprovalRejected) &&
+		approval.PolicyId == policy.Id &&
+		math.Abs(approval.GP-margin.Margin) < gpTolerance &&
+		math.Abs(approval.GPPercent-margin.MarginPercent) < gpTolerance
+}
@@ -0,0 +1,143 @@
+package ageing
+
+import (
+	"bytes"
+	"encoding/csv"
+	"sort"
+	"strconv"
+
+	"bitbucket.org/radarventures/forwarder-adapters/utils/context"
+	"bitbucket.org/radarventures/forwarder-shipments/daos/invoice"
+	"bitbucket.org/radarventures/forwarder-shipments/database/models"
+	"github.com/google/uuid"
+)
+
+type IAgeingService interface {
+	GetAgeingReport(ctx *context.Context, filter *models.ARAgeingFilter) (*models.ARAgeingReport, error)
+	ToCSV(report *models.ARAgeingReport) ([]byte, error)
+}
+
+type AgeingService struct {
+	invoiceDb invoice.IInvoice
+}
+
+func NewAgeingService() IAgeingService {
+	return &AgeingService{
+		invoiceDb: invoice.NewInvoice(),
+	}
+}
+
+type ageingKey struct {
+	companyId        uuid.UUID
+	regionId         uuid.UUID
+	salesExecutiveId uuid.UUID
+}
+
+// GetAgeingReport buckets outstanding customer invoices by days past due into current, 0-30,
+// 31-60, 61-90 and 90+ per company, region and sales executive. Invoices that are not due yet
+// are current. Amounts are in the region's base currency.
+func (s *AgeingService) GetAgeingReport(ctx *context.Context, filter *models.ARAgeingFilter) (*models.ARAgeingReport, error) {
+	invoices, err := s.invoiceDb.GetAgeingInvoices(ctx, filter)
+	if err != nil {
+		return nil, err
+	}
+
+	rows := map[ageingKey]*models.ARAgeingRow{}
+	totals := &models.ARAgeingRow{}
+
+	for _, inv := range invoices {
+		amount := outstandingBaseAmount(inv)
+		if amount <= 0 {
+			continue
+		}
+
+		key := ageingKey{inv.CompanyId, inv.RegionId, inv.SalesExecutiveId}
+		row, ok := rows[key]
+		if !ok {
+			row = &models.ARAgeingRow{
+				CompanyId:        inv.CompanyId,
+				RegionId:         inv.RegionId,
+				SalesExecutiveId: inv.SalesExecutiveId,
+			}
+			rows[key] = row
+		}
+
+		addToBucket(row, inv.AgeDays, amount)
+		addToBucket(totals, inv.AgeDays, amount)
+	}
+
+	report := &models.ARAgeingReport{
+		Rows:   make([]*models.ARAgeingRow, 0, len(rows)),
+		Totals: totals,
+	}
+	for _, row := range rows {
+		report.Rows = append(report.Rows, row)
+	}
+
+	sort.Slice(report.Rows, func(i, j int) bool {
+		return report.Rows[i].Total > report.Rows[j].Total
+	})
+
+	return report, nil
+}
+
+func (s *AgeingService) ToCSV(report *models.ARAgeingReport) ([]byte, error) {
+	buf := new(bytes.Buffer)
+	w := csv.NewWriter(buf)
+
+	records := [][]string{{"company_id", "region_id", "sales_executive_id", "current", "0-30", "31-60", "61-90", "90+", "total", "invoices"}}
+	for _, row := range report.Rows {
+		records = append(records, csvRecord(row.CompanyId.String(), row.RegionId.String(), row.SalesExecutiveId.String(), row))
+	}
+	records = append(records, csvRecord("total", "", "", report.Totals))
+
+	err := w.WriteAll(records)
+	if err != nil {
+		return nil, err
+	}
+
+	return buf.Bytes(), nil
+}
+
+// outstandingBaseAmount scales the base currency amount of the invoice down to the part that
+// is still outstanding. Invoices without a balance have not been settled at all.
+func outstandingBaseAmount(inv *models.ARAgeingInvoice) float64 {
+	if !inv.HasBalance || inv.InvoicedAmount == 0 {
+		return inv.BaseAmount
+	}
+
+	return inv.BaseAmount * inv.OutstandingAmount / inv.InvoicedAmount
+}
+
+func addToBucket(row *models.ARAgeingRow, ageDays int, amount float64) {
+	switch {
+	case ageDays < 0:
+		row.Current += amount
+	case ageDays <= 30:
+		row.Days0To30 += amount
+	case ageDays <= 60:
+		row.Days31To60 += amount
+	case ageDays <= 90:
+		row.Days61To90 += amount
+	default:
+		row.Days90Plus += amount
+	}
+
+	row.Total += amount
+	row.InvoiceCount++
+}
+
+func csvRecord(companyId, regionId, salesExecutiveId string, row *models.ARAgeingRow) []string {
+	return []string{
+		companyId,
+		regionId,
+		salesExecutiveId,
+		strconv.FormatFloat(row.Current, 'f', 2, 64),
+		strconv.FormatFloat(row.Days0To30, 'f', 2, 64),
+		strconv.FormatFloat(row.Days31To60, 'f', 2, 64),
+		strconv.FormatFloat(row.Days61To90, 'f', 2, 64),
+		strconv.FormatFloat(row.Days90Plus, 'f', 2, 64),
+		strconv.FormatFloat(row.Total, 'f', 2, 64),
+		strconv.Itoa(row.InvoiceCount),
+	}
+}
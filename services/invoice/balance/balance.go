@@ -0,0 +1,136 @@
+package balance
+
+import (
+	"time"
+
+	"bitbucket.org/radarventures/forwarder-adapters/utils/context"
+	"bitbucket.org/radarventures/forwarder-shipments/constants"
+	"bitbucket.org/radarventures/forwarder-shipments/daos/invoicelineitem"
+	"bitbucket.org/radarventures/forwarder-shipments/daos/invoicenote"
+	"bitbucket.org/radarventures/forwarder-shipments/daos/payment"
+	"bitbucket.org/radarventures/forwarder-shipments/database/models"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// AmountTolerance absorbs floating point noise when comparing amounts.
+const AmountTolerance = 0.005
+
+type IBalanceService interface {
+	Calculate(ctx *context.Context, original *models.Invoice, notes []*models.InvoiceNote) (*models.InvoiceBalance, error)
+	CalculateWithTx(ctx *context.Context, tx *gorm.DB, original *models.Invoice, notes []*models.InvoiceNote) (*models.InvoiceBalance, error)
+}
+
+type BalanceService struct {
+	invoiceLineItemDb invoicelineitem.IInvoiceLineItem
+	invoiceNoteDb     invoicenote.IInvoiceNote
+	paymentDb         payment.IPayment
+}
+
+func NewBalanceService() IBalanceService {
+	return &BalanceService{
+		invoiceLineItemDb: invoicelineitem.NewInvoiceLineItem(),
+		invoiceNoteDb:     invoicenote.NewInvoiceNote(),
+		paymentDb:         payment.NewPayment(),
+	}
+}
+
+// Calculate recomputes the outstanding amount of the invoice from the stored line amounts
+// of the invoice and its notes and from the payments settled against it. When notes is nil
+// the notes linked to the invoice are loaded, otherwise every note gets its own total set.
+func (s *BalanceService) Calculate(ctx *context.Context, original *models.Invoice, notes []*models.InvoiceNote) (*models.InvoiceBalance, error) {
+	return s.CalculateWithTx(ctx, ctx.DB.WithContext(ctx.Request.Context()), original, notes)
+}
+
+// CalculateWithTx recomputes the balance from what tx sees, notes and payments written
+// earlier in the same transaction included.
+func (s *BalanceService) CalculateWithTx(ctx *context.Context, tx *gorm.DB, original *models.Invoice, notes []*models.InvoiceNote) (*models.InvoiceBalance, error) {
+	var err error
+	if notes == nil {
+		notes, err = s.invoiceNoteDb.GetByInvoiceIdWithTx(ctx, tx, original.ID.String())
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	invoiceLineItems, err := s.invoiceLineItemDb.GetForInvoiceIdWithTx(ctx, tx, original.ID.String())
+	if err != nil {
+		return nil, err
+	}
+
+	lineItemIds := make([]string, 0, len(invoiceLineItems))
+	for _, lineItem := range invoiceLineItems {
+		lineItemIds = append(lineItemIds, lineItem.LineItemId.String())
+	}
+
+	balance := &models.InvoiceBalance{
+		InvoiceId:  original.ID,
+		InvoiceNo:  original.No,
+		ShipmentId: original.ShipmentID,
+		UpdatedAt:  time.Now().UTC(),
+	}
+
+	if len(lineItemIds) > 0 {
+		amounts, err := s.invoiceLineItemDb.GetInvoicedAmountsWithTx(ctx, tx, lineItemIds, original.InvoiceType)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, amount := range amounts {
+			if amount.InvoiceId != original.ID {
+				continue
+			}
+			if balance.Currency == "" {
+				balance.Currency = amount.Currency
+			}
+			balance.InvoicedAmount += amount.Amount
+		}
+
+		noteAmounts, err := s.invoiceLineItemDb.GetInvoicedAmountsWithTx(ctx, tx, lineItemIds, "both")
+		if err != nil {
+			return nil, err
+		}
+
+		noteTotals := map[uuid.UUID]float64{}
+		for _, amount := range noteAmounts {
+			noteTotals[amount.InvoiceId] += amount.Amount
+		}
+
+		for _, note := range notes {
+			note.Amount = noteTotals[note.NoteInvoiceId]
+		}
+	}
+
+	for _, note := range notes {
+		if note.NoteType == constants.CreditNote {
+			balance.CreditedAmount += note.Amount
+		} else {
+			balance.DebitedAmount += note.Amount
+		}
+	}
+
+	paid, err := s.paymentDb.GetPaidAmountsWithTx(ctx, tx, []string{original.ID.String()})
+	if err != nil {
+		return nil, err
+	}
+	balance.PaidAmount = paid[original.ID]
+
+	balance.OutstandingAmount = balance.InvoicedAmount - balance.CreditedAmount + balance.DebitedAmount - balance.PaidAmount
+	balance.Status = Status(balance)
+
+	return balance, nil
+}
+
+// Status moves an invoice through unpaid, partially paid, paid and overpaid.
+func Status(balance *models.InvoiceBalance) string {
+	switch {
+	case balance.OutstandingAmount < -AmountTolerance:
+		return constants.InvoiceStatusOverpaid
+	case balance.OutstandingAmount <= AmountTolerance:
+		return constants.InvoiceStatusPaid
+	case balance.PaidAmount > AmountTolerance:
+		return constants.InvoiceStatusPartiallyPaid
+	default:
+		return constants.InvoiceStatusUnpaid
+	}
+}
@@ -0,0 +1,62 @@
+package dunning
+
+import (
+	"errors"
+	"net/mail"
+	"strings"
+	"time"
+
+	"bitbucket.org/radarventures/forwarder-adapters/utils/context"
+	"bitbucket.org/radarventures/forwarder-shipments/daos/dunning"
+	"bitbucket.org/radarventures/forwarder-shipments/daos/invoice"
+	"bitbucket.org/radarventures/forwarder-shipments/database/models"
+)
+
+var ErrInvalidDunningEmail = errors.New("invalid dunning contact email")
+
+type IDunningService interface {
+	SaveContact(ctx *context.Context, req *models.DunningContact) error
+	GetInvoiceReminders(ctx *context.Context, invoiceNo string) ([]*models.DunningLog, error)
+}
+
+type DunningService struct {
+	dunningDb dunning.IDunning
+	invoiceDb invoice.IInvoice
+}
+
+func NewDunningService() IDunningService {
+	return &DunningService{
+		dunningDb: dunning.NewDunning(),
+		invoiceDb: invoice.NewInvoice(),
+	}
+}
+
+// SaveContact sets the addresses overdue reminders of a customer are sent to.
+func (s *DunningService) SaveContact(ctx *context.Context, req *models.DunningContact) error {
+	emails := make([]string, 0, len(req.Emails))
+	for _, email := range req.Emails {
+		email = strings.TrimSpace(email)
+		if email == "" {
+			continue
+		}
+		if _, err := mail.ParseAddress(email); err != nil {
+			return ErrInvalidDunningEmail
+		}
+		emails = append(emails, email)
+	}
+
+	req.Emails = emails
+	req.UpdatedBy = ctx.Account.ID
+	req.UpdatedAt = time.Now().UTC()
+
+	return s.dunningDb.UpsertContact(ctx, req)
+}
+
+func (s *DunningService) GetInvoiceReminders(ctx *context.Context, invoiceNo string) ([]*models.DunningLog, error) {
+	inv, err := s.invoiceDb.GetByInvoiceNumber(ctx, invoiceNo)
+	if err != nil {
+		return nil, err
+	}
+
+	return s.dunningDb.GetLogs(ctx, []string{inv.ID.String()})
+}
@@ -0,0 +1,354 @@
+package notes
+
+import (
+	"errors"
+	"math"
+	"time"
+
+	"bitbucket.org/radarventures/forwarder-adapters/utils/context"
+	"bitbucket.org/radarventures/forwarder-shipments/constants"
+	"bitbucket.org/radarventures/forwarder-shipments/daos/invoice"
+	"bitbucket.org/radarventures/forwarder-shipments/daos/invoicebalance"
+	"bitbucket.org/radarventures/forwarder-shipments/daos/invoicelineitem"
+	"bitbucket.org/radarventures/forwarder-shipments/daos/invoicenote"
+	"bitbucket.org/radarventures/forwarder-shipments/database/models"
+	"bitbucket.org/radarventures/forwarder-shipments/services/invoice/balance"
+	"bitbucket.org/radarventures/forwarder-shipments/services/invoice/numbering"
+	"github.com/google/uuid"
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+)
+
+var (
+	ErrInvalidNoteType    = errors.New("note type must be credit_note or debit_note")
+	ErrNoteAgainstNote    = errors.New("a note can only be raised against an invoice")
+	ErrNoteLinesRequired  = errors.New("at least one note line is required")
+	ErrNoteReasonRequired = errors.New("a reason is required to raise a note")
+	ErrNoteExceedsInvoice = errors.New("note lines exceed the amount available on the invoice")
+)
+
+type RaiseNoteReq struct {
+	NoteType string                    `json:"note_type"`
+	Reason   string                    `json:"reason"`
+	Lines    []*models.InvoiceNoteLine `json:"lines"`
+}
+
+type INoteService interface {
+	RaiseNote(ctx *context.Context, invoiceNo string, req *RaiseNoteReq) ([]*models.InvoiceNoteLineError, error)
+	ValidateNoteLines(ctx *context.Context, original *models.Invoice, noteType string, lines []*models.InvoiceNoteLine) ([]*models.InvoiceNoteLineError, error)
+	GetInvoiceChains(ctx *context.Context, shipmentId uuid.UUID) ([]*models.InvoiceChain, error)
+}
+
+type NoteService struct {
+	invoiceDb         invoice.IInvoice
+	invoiceLineItemDb invoicelineitem.IInvoiceLineItem
+	invoiceNoteDb     invoicenote.IInvoiceNote
+	invoiceBalanceDb  invoicebalance.IInvoiceBalance
+	balanceSrv        balance.IBalanceService
+	numbering         numbering.INumberingService
+}
+
+func NewNoteService() INoteService {
+	return &NoteService{
+		invoiceDb:         invoice.NewInvoice(),
+		invoiceLineItemDb: invoicelineitem.NewInvoiceLineItem(),
+		invoiceNoteDb:     invoicenote.NewInvoiceNote(),
+		invoiceBalanceDb:  invoicebalance.NewInvoiceBalance(),
+		balanceSrv:        balance.NewBalanceService(),
+		numbering:         numbering.NewNumberingService(),
+	}
+}
+
+func isNoteType(invoiceType string) bool {
+	return invoiceType == constants.CreditNote || invoiceType == constants.DebitNote
+}
+
+// noteVoucherType is the voucher type a note is numbered in, a credit note takes the next
+// CN number and a debit note the next DN number of the invoice's region.
+func noteVoucherType(noteType string) string {
+	if noteType == constants.DebitNote {
+		return constants.VoucherTypeDebitNote
+	}
+	return constants.VoucherTypeCreditNote
+}
+
+// RaiseNote validates the requested lines against the original invoice and writes the note
+// from the validated lines, linked to the original invoice. The invoice's balance row is
+// locked for the whole check and write, so two notes against the same invoice cannot both
+// pass against the same prior credits. The line errors are returned when the request was
+// rejected line by line.
+func (s *NoteService) RaiseNote(ctx *context.Context, invoiceNo string, req *RaiseNoteReq) ([]*models.InvoiceNoteLineError, error) {
+	if !isNoteType(req.NoteType) {
+		return nil, ErrInvalidNoteType
+	}
+	if req.Reason == "" {
+		return nil, ErrNoteReasonRequired
+	}
+
+	original, err := s.invoiceDb.GetByInvoiceNumber(ctx, invoiceNo)
+	if err != nil {
+		return nil, err
+	}
+
+	var lineErrs []*models.InvoiceNoteLineError
+	err = ctx.DB.WithContext(ctx.Request.Context()).Transaction(func(tx *gorm.DB) error {
+		now := time.Now().UTC()
+
+		_, err := s.invoiceBalanceDb.LockWithTx(ctx, tx, &models.InvoiceBalance{
+			InvoiceId:  original.ID,
+			InvoiceNo:  original.No,
+			ShipmentId: original.ShipmentID,
+			Status:     constants.InvoiceStatusUnpaid,
+			UpdatedAt:  now,
+		})
+		if err != nil {
+			return err
+		}
+
+		lineErrs, err = s.validateNoteLinesWithTx(ctx, tx, original, req.NoteType, req.Lines)
+		if err != nil {
+			return err
+		}
+		if len(lineErrs) > 0 {
+			return ErrNoteExceedsInvoice
+		}
+
+		voucher, err := s.invoiceDb.GetVoucherWithTx(ctx, tx, original.ID)
+		if err != nil {
+			return err
+		}
+
+		voucherType := noteVoucherType(req.NoteType)
+		no, err := s.numbering.IssueWithTx(ctx, tx, req.NoteType, voucherType, voucher.RegionId, now)
+		if err != nil {
+			return err
+		}
+
+		noteInvoice := &models.NoteInvoice{
+			Id:               uuid.New(),
+			OriginalId:       original.ID,
+			No:               no,
+			InvoiceType:      req.NoteType,
+			VoucherType:      voucherType,
+			InvoiceRequestId: ctx.RefID,
+			InvoicedAt:       now,
+			Lines:            mergeNoteLines(req.Lines),
+		}
+		if err := s.invoiceDb.CreateNoteWithTx(ctx, tx, noteInvoice); err != nil {
+			return err
+		}
+
+		note := &models.InvoiceNote{
+			Id:            uuid.New(),
+			InvoiceId:     original.ID,
+			InvoiceNo:     original.No,
+			NoteInvoiceId: noteInvoice.Id,
+			NoteNo:        noteInvoice.No,
+			NoteType:      req.NoteType,
+			Reason:        req.Reason,
+			CreatedAt:     now,
+		}
+		if ctx.Account != nil {
+			note.CreatedBy = ctx.Account.ID
+		}
+
+		notes, err := s.invoiceNoteDb.GetByInvoiceIdWithTx(ctx, tx, original.ID.String())
+		if err != nil {
+			return err
+		}
+		notes = append(notes, note)
+
+		invoiceBalance, err := s.balanceSrv.CalculateWithTx(ctx, tx, original, notes)
+		if err != nil {
+			return err
+		}
+
+		if err := s.invoiceNoteDb.UpsertWithTx(ctx, tx, notes...); err != nil {
+			return err
+		}
+
+		return s.invoiceBalanceDb.UpsertWithTx(ctx, tx, invoiceBalance)
+	})
+	if len(lineErrs) > 0 {
+		return lineErrs, ErrNoteExceedsInvoice
+	}
+	if err != nil {
+		ctx.Log.Error("unable to raise note against invoice", zap.Error(err), zap.Any("invoice_no", invoiceNo))
+		return nil, err
+	}
+
+	return nil, nil
+}
+
+// mergeNoteLines sums the requested amounts per line item, the note carries one line for
+// each line item of the invoice it credits or debits.
+func mergeNoteLines(lines []*models.InvoiceNoteLine) []*models.InvoiceNoteLine {
+	merged := []*models.InvoiceNoteLine{}
+	byLineItem := map[uuid.UUID]*models.InvoiceNoteLine{}
+	for _, line := range lines {
+		if m, ok := byLineItem[line.LineItemId]; ok {
+			m.Amount += line.Amount
+			continue
+		}
+
+		m := &models.InvoiceNoteLine{LineItemId: line.LineItemId, Amount: line.Amount}
+		byLineItem[line.LineItemId] = m
+		merged = append(merged, m)
+	}
+
+	return merged
+}
+
+// ValidateNoteLines checks every requested line against the original invoice. A credit
+// note line may not exceed what was invoiced for the line minus the credit notes already
+// raised against the same invoice.
+func (s *NoteService) ValidateNoteLines(ctx *context.Context, original *models.Invoice, noteType string, lines []*models.InvoiceNoteLine) ([]*models.InvoiceNoteLineError, error) {
+	return s.validateNoteLinesWithTx(ctx, ctx.DB.WithContext(ctx.Request.Context()), original, noteType, lines)
+}
+
+func (s *NoteService) validateNoteLinesWithTx(ctx *context.Context, tx *gorm.DB, original *models.Invoice, noteType string, lines []*models.InvoiceNoteLine) ([]*models.InvoiceNoteLineError, error) {
+	if isNoteType(original.InvoiceType) {
+		return nil, ErrNoteAgainstNote
+	}
+	if len(lines) == 0 {
+		return nil, ErrNoteLinesRequired
+	}
+
+	lineItemIds := make([]string, 0, len(lines))
+	for _, line := range lines {
+		lineItemIds = append(lineItemIds, line.LineItemId.String())
+	}
+
+	invoiced, err := s.getLineAmounts(ctx, tx, lineItemIds, original.InvoiceType, []uuid.UUID{original.ID})
+	if err != nil {
+		return nil, err
+	}
+
+	notes, err := s.invoiceNoteDb.GetByInvoiceIdWithTx(ctx, tx, original.ID.String())
+	if err != nil {
+		return nil, err
+	}
+
+	creditNoteIds := []uuid.UUID{}
+	for _, note := range notes {
+		if note.NoteType == constants.CreditNote {
+			creditNoteIds = append(creditNoteIds, note.NoteInvoiceId)
+		}
+	}
+
+	credited, err := s.getLineAmounts(ctx, tx, lineItemIds, constants.CreditNote, creditNoteIds)
+	if err != nil {
+		return nil, err
+	}
+
+	lineErrs := []*models.InvoiceNoteLineError{}
+	requested := map[uuid.UUID]float64{}
+	for _, line := range lines {
+		requested[line.LineItemId] += line.Amount
+
+		invoicedAmount, ok := invoiced[line.LineItemId]
+		switch {
+		case !ok:
+			lineErrs = append(lineErrs, &models.InvoiceNoteLineError{
+				LineItemId: line.LineItemId,
+				Requested:  line.Amount,
+				Message:    "line item is not part of invoice " + original.No,
+			})
+		case line.Amount <= 0:
+			lineErrs = append(lineErrs, &models.InvoiceNoteLineError{
+				LineItemId: line.LineItemId,
+				Requested:  line.Amount,
+				Message:    "amount must be greater than zero",
+			})
+		case noteType == constants.CreditNote:
+			available := invoicedAmount - credited[line.LineItemId]
+			if requested[line.LineItemId] > available+balance.AmountTolerance {
+				lineErrs = append(lineErrs, &models.InvoiceNoteLineError{
+					LineItemId: line.LineItemId,
+					Requested:  requested[line.LineItemId],
+					Available:  math.Max(available, 0),
+					Message:    "credited amount exceeds invoiced amount minus prior credits",
+				})
+			}
+		}
+	}
+
+	return lineErrs, nil
+}
+
+// GetInvoiceChains returns every invoice of the shipment with the notes raised against it
+// and its outstanding balance.
+func (s *NoteService) GetInvoiceChains(ctx *context.Context, shipmentId uuid.UUID) ([]*models.InvoiceChain, error) {
+	invoices, err := s.invoiceDb.GetWithFilter(ctx, &models.Invoice{ShipmentID: shipmentId})
+	if err != nil {
+		return nil, err
+	}
+
+	invoiceIds := []string{}
+	for _, inv := range invoices {
+		if !isNoteType(inv.InvoiceType) {
+			invoiceIds = append(invoiceIds, inv.ID.String())
+		}
+	}
+
+	notes, err := s.invoiceNoteDb.GetByInvoiceIds(ctx, invoiceIds)
+	if err != nil {
+		return nil, err
+	}
+
+	balances, err := s.invoiceBalanceDb.GetAll(ctx, invoiceIds)
+	if err != nil {
+		return nil, err
+	}
+
+	notesMap := map[uuid.UUID][]*models.InvoiceNote{}
+	for _, note := range notes {
+		notesMap[note.InvoiceId] = append(notesMap[note.InvoiceId], note)
+	}
+
+	balanceMap := map[uuid.UUID]*models.InvoiceBalance{}
+	for _, invoiceBalance := range balances {
+		balanceMap[invoiceBalance.InvoiceId] = invoiceBalance
+	}
+
+	chains := []*models.InvoiceChain{}
+	for _, inv := range invoices {
+		if isNoteType(inv.InvoiceType) {
+			continue
+		}
+
+		chains = append(chains, &models.InvoiceChain{
+			Invoice: inv,
+			Balance: balanceMap[inv.ID],
+			Notes:   notesMap[inv.ID],
+		})
+	}
+
+	return chains, nil
+}
+
+// getLineAmounts sums the stored amounts per line item for the given invoices.
+func (s *NoteService) getLineAmounts(ctx *context.Context, tx *gorm.DB, lineItemIds []string, invoiceType string, invoiceIds []uuid.UUID) (map[uuid.UUID]float64, error) {
+	result := map[uuid.UUID]float64{}
+	if len(invoiceIds) == 0 {
+		return result, nil
+	}
+
+	included := map[uuid.UUID]bool{}
+	for _, id := range invoiceIds {
+		included[id] = true
+	}
+
+	amounts, err := s.invoiceLineItemDb.GetInvoicedAmountsWithTx(ctx, tx, lineItemIds, invoiceType)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, amount := range amounts {
+		if included[amount.InvoiceId] {
+			result[amount.LineItemId] += amount.Amount
+		}
+	}
+
+	return result, nil
+}
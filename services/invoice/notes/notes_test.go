@@ -0,0 +1,213 @@
+package notes
+
+import (
+	"net/http/httptest"
+	"os"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"bitbucket.org/radarventures/forwarder-adapters/utils/context"
+	"bitbucket.org/radarventures/forwarder-adapters/utils/db"
+	ulog "bitbucket.org/radarventures/forwarder-adapters/utils/log"
+	"bitbucket.org/radarventures/forwarder-shipments/constants"
+	"bitbucket.org/radarventures/forwarder-shipments/daos/invoice"
+	"bitbucket.org/radarventures/forwarder-shipments/daos/invoicebalance"
+	"bitbucket.org/radarventures/forwarder-shipments/daos/invoicelineitem"
+	"bitbucket.org/radarventures/forwarder-shipments/daos/invoicenote"
+	"bitbucket.org/radarventures/forwarder-shipments/daos/invoicesequence"
+	"bitbucket.org/radarventures/forwarder-shipments/database/models"
+	"bitbucket.org/radarventures/forwarder-shipments/services/invoice/balance"
+	"bitbucket.org/radarventures/forwarder-shipments/services/invoice/numbering"
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// The note tests allocate numbers from the real invoice sequences and need a Postgres
+// database, TEST_DATABASE_URL points at one. Each run works in a schema of its own that is
+// dropped afterwards. The invoice, line item, note and balance tables are stubbed.
+func testContext(t *testing.T) *context.Context {
+	t.Helper()
+
+	url := os.Getenv("TEST_DATABASE_URL")
+	if url == "" {
+		t.Skip("TEST_DATABASE_URL is not set")
+	}
+
+	db.Init(&db.Config{URL: url, MaxDBConn: 10})
+
+	c := &context.Context{}
+	c.RefID = uuid.New().String()
+	c.Log = ulog.New(c.RefID, "forwarder-shipments", "error")
+	c.DB = db.New()
+	c.TenantID = "test_" + strings.ReplaceAll(uuid.New().String(), "-", "")
+	c.Context, _ = gin.CreateTestContext(httptest.NewRecorder())
+	c.Context.Request = httptest.NewRequest("POST", "/invoices/notes", nil)
+
+	if err := c.DB.Exec("CREATE SCHEMA " + c.TenantID).Error; err != nil {
+		t.Fatalf("create schema: %v", err)
+	}
+	t.Cleanup(func() {
+		c.DB.Exec("DROP SCHEMA " + c.TenantID + " CASCADE")
+	})
+
+	tables := map[string]interface{}{
+		c.TenantID + ".invoice_sequences":    &models.InvoiceSequence{},
+		c.TenantID + ".invoice_fiscal_years": &models.InvoiceFiscalYear{},
+	}
+	for table, model := range tables {
+		if err := c.DB.Table(table).AutoMigrate(model); err != nil {
+			t.Fatalf("migrate %s: %v", table, err)
+		}
+	}
+
+	return c
+}
+
+// sequenceNumbering issues the bare sequence numbers, without a number template.
+type sequenceNumbering struct {
+	numbering.INumberingService
+	sequence invoicesequence.IInvoiceSequence
+}
+
+func (s *sequenceNumbering) IssueWithTx(ctx *context.Context, tx *gorm.DB, invoiceType, voucherType string, regionId uuid.UUID, invoicedAt time.Time) (string, error) {
+	no, err := s.sequence.NewInvoiceNumberWithTx(ctx, tx, invoiceType, voucherType, regionId, invoicedAt)
+	if err != nil {
+		return "", err
+	}
+	return strconv.FormatInt(no, 10), nil
+}
+
+type stubInvoiceDb struct {
+	invoice.IInvoice
+	original *models.Invoice
+	voucher  *models.InvoiceVoucher
+	notes    []*models.NoteInvoice
+}
+
+func (s *stubInvoiceDb) GetByInvoiceNumber(ctx *context.Context, invoiceNo string) (*models.Invoice, error) {
+	return s.original, nil
+}
+
+func (s *stubInvoiceDb) GetVoucherWithTx(ctx *context.Context, tx *gorm.DB, invoiceId uuid.UUID) (*models.InvoiceVoucher, error) {
+	return s.voucher, nil
+}
+
+func (s *stubInvoiceDb) CreateNoteWithTx(ctx *context.Context, tx *gorm.DB, note *models.NoteInvoice) error {
+	s.notes = append(s.notes, note)
+	return nil
+}
+
+type stubLineItemDb struct {
+	invoicelineitem.IInvoiceLineItem
+	invoiceId  uuid.UUID
+	lineItemId uuid.UUID
+}
+
+func (s *stubLineItemDb) GetInvoicedAmountsWithTx(ctx *context.Context, tx *gorm.DB, lineItemIds []string, invoiceType string) ([]*models.LineItemInvoicedAmountWithType, error) {
+	return []*models.LineItemInvoicedAmountWithType{
+		{InvoiceId: s.invoiceId, LineItemId: s.lineItemId, Amount: 100},
+	}, nil
+}
+
+type stubNoteDb struct {
+	invoicenote.IInvoiceNote
+}
+
+func (s *stubNoteDb) GetByInvoiceIdWithTx(ctx *context.Context, tx *gorm.DB, invoiceId string) ([]*models.InvoiceNote, error) {
+	return nil, nil
+}
+
+func (s *stubNoteDb) UpsertWithTx(ctx *context.Context, tx *gorm.DB, m ...*models.InvoiceNote) error {
+	return nil
+}
+
+type stubBalanceDb struct {
+	invoicebalance.IInvoiceBalance
+}
+
+func (s *stubBalanceDb) LockWithTx(ctx *context.Context, tx *gorm.DB, m *models.InvoiceBalance) (*models.InvoiceBalance, error) {
+	return m, nil
+}
+
+func (s *stubBalanceDb) UpsertWithTx(ctx *context.Context, tx *gorm.DB, m ...*models.InvoiceBalance) error {
+	return nil
+}
+
+type stubBalanceSrv struct {
+	balance.IBalanceService
+}
+
+func (s *stubBalanceSrv) CalculateWithTx(ctx *context.Context, tx *gorm.DB, original *models.Invoice, notes []*models.InvoiceNote) (*models.InvoiceBalance, error) {
+	return &models.InvoiceBalance{InvoiceId: original.ID}, nil
+}
+
+func TestRaiseNoteKeepsInvoiceSequence(t *testing.T) {
+	ctx := testContext(t)
+
+	regionId := uuid.New()
+	lineItemId := uuid.New()
+	original := &models.Invoice{ID: uuid.New(), No: "1", ShipmentID: uuid.New()}
+
+	invoiceDb := &stubInvoiceDb{
+		original: original,
+		voucher:  &models.InvoiceVoucher{RegionId: regionId, VoucherType: "INV"},
+	}
+	numberingSrv := &sequenceNumbering{sequence: invoicesequence.NewInvoiceSequence()}
+	s := &NoteService{
+		invoiceDb:         invoiceDb,
+		invoiceLineItemDb: &stubLineItemDb{invoiceId: original.ID, lineItemId: lineItemId},
+		invoiceNoteDb:     &stubNoteDb{},
+		invoiceBalanceDb:  &stubBalanceDb{},
+		balanceSrv:        &stubBalanceSrv{},
+		numbering:         numberingSrv,
+	}
+
+	issueInvoice := func() string {
+		var no string
+		err := ctx.DB.Transaction(func(tx *gorm.DB) error {
+			var err error
+			no, err = numberingSrv.IssueWithTx(ctx, tx, original.InvoiceType, "INV", regionId, time.Now().UTC())
+			return err
+		})
+		if err != nil {
+			t.Fatalf("issue invoice number: %v", err)
+		}
+		return no
+	}
+
+	if no := issueInvoice(); no != "1" {
+		t.Fatalf("first invoice number = %s, want 1", no)
+	}
+
+	for _, noteType := range []string{constants.CreditNote, constants.CreditNote, constants.DebitNote} {
+		_, err := s.RaiseNote(ctx, original.No, &RaiseNoteReq{
+			NoteType: noteType,
+			Reason:   "rate correction",
+			Lines:    []*models.InvoiceNoteLine{{LineItemId: lineItemId, Amount: 10}},
+		})
+		if err != nil {
+			t.Fatalf("raise %s: %v", noteType, err)
+		}
+	}
+
+	want := []struct{ voucherType, no string }{
+		{constants.VoucherTypeCreditNote, "1"},
+		{constants.VoucherTypeCreditNote, "2"},
+		{constants.VoucherTypeDebitNote, "1"},
+	}
+	if len(invoiceDb.notes) != len(want) {
+		t.Fatalf("%d notes written, want %d", len(invoiceDb.notes), len(want))
+	}
+	for i, note := range invoiceDb.notes {
+		if note.VoucherType != want[i].voucherType || note.No != want[i].no {
+			t.Fatalf("note %d = %s %s, want %s %s", i, note.VoucherType, note.No, want[i].voucherType, want[i].no)
+		}
+	}
+
+	if no := issueInvoice(); no != "2" {
+		t.Fatalf("invoice number after raising notes = %s, want 2", no)
+	}
+}
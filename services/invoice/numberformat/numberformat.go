@@ -0,0 +1,334 @@
+package numberformat
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"bitbucket.org/radarventures/forwarder-adapters/utils/context"
+	"bitbucket.org/radarventures/forwarder-shipments/constants"
+	"bitbucket.org/radarventures/forwarder-shipments/daos/invoice"
+	"bitbucket.org/radarventures/forwarder-shipments/daos/invoicepref"
+	"bitbucket.org/radarventures/forwarder-shipments/daos/invoicesequence"
+	"bitbucket.org/radarventures/forwarder-shipments/database/models"
+	"github.com/google/uuid"
+	"go.uber.org/zap"
+)
+
+// MaxPreviewCount caps how many upcoming numbers a preview renders.
+const MaxPreviewCount = 50
+
+var (
+	ErrTemplateNoSeq      = errors.New("invoice number template must contain exactly one {SEQ} token")
+	ErrTemplateNoFY       = errors.New("invoice number template must contain {FY} as sequences restart every fiscal year")
+	ErrTemplateToken      = errors.New("invoice number template contains an unknown token")
+	ErrTemplateCollision  = errors.New("invoice number template would reuse an already issued invoice number")
+	ErrTemplateRegion     = errors.New("region is required to save invoice number templates")
+	ErrInvalidPreviewSize = fmt.Errorf("preview count must be between 1 and %d", MaxPreviewCount)
+
+	tokenRegex = regexp.MustCompile(`\{([A-Z]+)(?::(\d+))?\}`)
+)
+
+type INumberFormatService interface {
+	SaveTemplates(ctx *context.Context, regionId uuid.UUID, regionCode string, templates map[string]string) error
+	GetNumberFormats(ctx *context.Context, regionId string) ([]*models.InvoiceNumberFormat, error)
+	PreviewNumbers(ctx *context.Context, regionId uuid.UUID, invoiceType, voucherType string, count int) (*models.InvoiceNumberPreview, error)
+	FormatNumber(ctx *context.Context, regionId uuid.UUID, invoiceType, voucherType string, no int64, invoicedAt time.Time) (string, error)
+}
+
+type NumberFormatService struct {
+	invoicePref     invoicepref.IInvoicePref
+	invoiceSequence invoicesequence.IInvoiceSequence
+	invoice         invoice.IInvoice
+}
+
+func NewNumberFormatService() INumberFormatService {
+	return &NumberFormatService{
+		invoicePref:     invoicepref.NewInvoicePref(),
+		invoiceSequence: invoicesequence.NewInvoiceSequence(),
+		invoice:         invoice.NewInvoice(),
+	}
+}
+
+// SaveTemplates validates the templates of the voucher types and stores them on the
+// region's number format pref. An empty template removes the voucher type's template. A
+// template is rejected when a number it renders for the rest of the fiscal year was
+// already issued, whatever template or invoice type issued it.
+func (s *NumberFormatService) SaveTemplates(ctx *context.Context, regionId uuid.UUID, regionCode string, templates map[string]string) error {
+	if regionId == uuid.Nil {
+		return ErrTemplateRegion
+	}
+
+	current, err := s.invoicePref.GetNumberTemplates(ctx, regionId.String())
+	if err != nil {
+		return err
+	}
+	if current == nil {
+		current = &models.InvoiceNumberTemplates{RegionId: regionId}
+	}
+	if current.Templates == nil {
+		current.Templates = models.NumberTemplates{}
+	}
+	if regionCode = strings.TrimSpace(regionCode); regionCode != "" {
+		current.RegionCode = regionCode
+	}
+
+	now := time.Now().UTC()
+	fyStartMonth, err := s.invoiceSequence.GetFiscalYearStartMonth(ctx, regionId)
+	if err != nil {
+		return err
+	}
+
+	for voucherType, template := range templates {
+		voucherType = strings.TrimSpace(voucherType)
+		template = strings.TrimSpace(template)
+		if template == "" {
+			delete(current.Templates, voucherType)
+			continue
+		}
+
+		if err := ValidateTemplate(template); err != nil {
+			return err
+		}
+
+		last, err := s.invoiceSequence.GetLastInvoiceNumber(ctx, "", voucherType, regionId, now)
+		if err != nil {
+			return err
+		}
+
+		pattern, err := Pattern(template, current.RegionCode, voucherType, invoicesequence.FiscalYear(fyStartMonth, now))
+		if err != nil {
+			return err
+		}
+
+		issued, err := s.invoice.GetNumbersMatching(ctx, pattern.String())
+		if err != nil {
+			return err
+		}
+
+		for _, no := range issued {
+			seq, ok := Sequence(pattern, no)
+			if ok && seq > last {
+				ctx.Log.Error("invoice number template collides with issued invoices", zap.String("template", template), zap.String("no", no))
+				return ErrTemplateCollision
+			}
+		}
+
+		current.Templates[voucherType] = template
+	}
+
+	current.UpdatedAt = now
+	if ctx.Account != nil {
+		current.UpdatedBy = ctx.Account.ID
+	}
+
+	return s.invoicePref.UpsertNumberTemplates(ctx, current)
+}
+
+func (s *NumberFormatService) GetNumberFormats(ctx *context.Context, regionId string) ([]*models.InvoiceNumberFormat, error) {
+	templates, err := s.invoicePref.GetNumberTemplates(ctx, regionId)
+	if err != nil {
+		return nil, err
+	}
+
+	res := []*models.InvoiceNumberFormat{}
+	if templates == nil {
+		return res, nil
+	}
+
+	for voucherType, template := range templates.Templates {
+		res = append(res, &models.InvoiceNumberFormat{
+			RegionId:    templates.RegionId,
+			RegionCode:  templates.RegionCode,
+			VoucherType: voucherType,
+			Template:    template,
+		})
+	}
+	sort.Slice(res, func(i, j int) bool { return res[i].VoucherType < res[j].VoucherType })
+
+	return res, nil
+}
+
+// PreviewNumbers renders the next count numbers of the sequence without allocating them.
+func (s *NumberFormatService) PreviewNumbers(ctx *context.Context, regionId uuid.UUID, invoiceType, voucherType string, count int) (*models.InvoiceNumberPreview, error) {
+	if count < 1 || count > MaxPreviewCount {
+		return nil, ErrInvalidPreviewSize
+	}
+
+	now := time.Now().UTC()
+	last, err := s.invoiceSequence.GetLastInvoiceNumber(ctx, invoiceType, voucherType, regionId, now)
+	if err != nil {
+		return nil, err
+	}
+
+	res := &models.InvoiceNumberPreview{
+		RegionId:    regionId,
+		VoucherType: voucherType,
+		Numbers:     make([]string, 0, count),
+	}
+
+	for no := last + 1; no <= last+int64(count); no++ {
+		rendered, err := s.FormatNumber(ctx, regionId, invoiceType, voucherType, no, now)
+		if err != nil {
+			return nil, err
+		}
+		if rendered == "" {
+			rendered = strconv.FormatInt(no, 10)
+		}
+		res.Numbers = append(res.Numbers, rendered)
+	}
+
+	template, _, err := s.template(ctx, regionId, invoiceType, voucherType)
+	if err != nil {
+		return nil, err
+	}
+	res.Template = template
+
+	return res, nil
+}
+
+// FormatNumber renders an allocated sequence number with the region's template. It returns
+// an empty string when no template applies so the caller keeps its default format.
+// Proforma invoices count in a sequence of their own and always keep the default format,
+// rendering them with the tax invoice template would repeat the tax invoice numbers.
+func (s *NumberFormatService) FormatNumber(ctx *context.Context, regionId uuid.UUID, invoiceType, voucherType string, no int64, invoicedAt time.Time) (string, error) {
+	template, regionCode, err := s.template(ctx, regionId, invoiceType, voucherType)
+	if err != nil || template == "" {
+		return "", err
+	}
+
+	fyStartMonth, err := s.invoiceSequence.GetFiscalYearStartMonth(ctx, regionId)
+	if err != nil {
+		return "", err
+	}
+
+	return Render(template, regionCode, voucherType, no, invoicedAt, fyStartMonth)
+}
+
+func (s *NumberFormatService) template(ctx *context.Context, regionId uuid.UUID, invoiceType, voucherType string) (string, string, error) {
+	if strings.Contains(invoiceType, constants.CustomerProforma) {
+		return "", "", nil
+	}
+
+	templates, err := s.invoicePref.GetNumberTemplates(ctx, regionId.String())
+	if err != nil || templates == nil {
+		return "", "", err
+	}
+
+	return templates.Templates[voucherType], templates.RegionCode, nil
+}
+
+// Pattern matches the numbers template renders in the fiscal year fy, the sequence number
+// is its only group. The expression is valid both in Go and in Postgres.
+func Pattern(template, regionCode, voucherType, fy string) (*regexp.Regexp, error) {
+	var b strings.Builder
+	b.WriteString("^")
+
+	rest := template
+	for _, loc := range tokenRegex.FindAllStringSubmatchIndex(template, -1) {
+		offset := len(template) - len(rest)
+		b.WriteString(regexp.QuoteMeta(rest[:loc[0]-offset]))
+
+		name := template[loc[2]:loc[3]]
+		switch name {
+		case "REGION":
+			b.WriteString(regexp.QuoteMeta(regionCode))
+		case "FY":
+			b.WriteString(regexp.QuoteMeta(fy))
+		case "TYPE":
+			b.WriteString(regexp.QuoteMeta(voucherType))
+		case "SEQ":
+			b.WriteString("([0-9]+)")
+		default:
+			return nil, ErrTemplateToken
+		}
+
+		rest = template[loc[1]:]
+	}
+	b.WriteString(regexp.QuoteMeta(rest))
+	b.WriteString("$")
+
+	return regexp.Compile(b.String())
+}
+
+// Sequence returns the sequence number of a number that matches pattern.
+func Sequence(pattern *regexp.Regexp, no string) (int64, bool) {
+	match := pattern.FindStringSubmatch(no)
+	if match == nil {
+		return 0, false
+	}
+
+	seq, err := strconv.ParseInt(match[1], 10, 64)
+	if err != nil {
+		return 0, false
+	}
+
+	return seq, true
+}
+
+// ValidateTemplate checks that only known tokens are used and that the rendered numbers
+// stay unique across fiscal years.
+func ValidateTemplate(template string) error {
+	seqCount := 0
+	hasFY := false
+
+	for _, match := range tokenRegex.FindAllStringSubmatch(template, -1) {
+		switch match[1] {
+		case "SEQ":
+			seqCount++
+		case "FY":
+			hasFY = true
+		case "REGION", "TYPE":
+		default:
+			return ErrTemplateToken
+		}
+	}
+
+	if seqCount != 1 {
+		return ErrTemplateNoSeq
+	}
+
+	if !hasFY {
+		return ErrTemplateNoFY
+	}
+
+	return nil
+}
+
+// Render replaces the tokens of template. {SEQ:06} pads the sequence number with zeros
+// to six digits.
+func Render(template, regionCode, voucherType string, no int64, at time.Time, fyStartMonth time.Month) (string, error) {
+	var renderErr error
+
+	rendered := tokenRegex.ReplaceAllStringFunc(template, func(token string) string {
+		match := tokenRegex.FindStringSubmatch(token)
+
+		switch match[1] {
+		case "REGION":
+			return regionCode
+		case "FY":
+			return invoicesequence.FiscalYear(fyStartMonth, at)
+		case "TYPE":
+			return voucherType
+		case "SEQ":
+			if match[2] == "" {
+				return strconv.FormatInt(no, 10)
+			}
+			width, err := strconv.Atoi(match[2])
+			if err != nil {
+				renderErr = err
+				return token
+			}
+			return fmt.Sprintf("%0*d", width, no)
+		}
+
+		renderErr = ErrTemplateToken
+		return token
+	})
+
+	return rendered, renderErr
+}
@@ -0,0 +1,192 @@
+package numbering
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+	"time"
+
+	"bitbucket.org/radarventures/forwarder-adapters/utils/context"
+	"bitbucket.org/radarventures/forwarder-shipments/daos/invoice"
+	"bitbucket.org/radarventures/forwarder-shipments/daos/invoicesequence"
+	"bitbucket.org/radarventures/forwarder-shipments/database/models"
+	"bitbucket.org/radarventures/forwarder-shipments/services/invoice/numberformat"
+	"github.com/google/uuid"
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+)
+
+var (
+	ErrVoidReasonRequired  = errors.New("a reason is required to void an invoice number")
+	ErrVoidNumberInvalid   = errors.New("only a number already allocated from the sequence can be voided")
+	ErrVoidNumberDuplicate = errors.New("invoice number is already voided")
+	ErrInvalidStartMonth   = errors.New("fiscal year start month must be between 1 and 12")
+	ErrNumberCollision     = errors.New("no free invoice number found, the rendered numbers keep colliding with issued invoices")
+)
+
+// maxCollisionSkips bounds how many numbers IssueWithTx burns in a row when a rendered
+// number was already issued.
+const maxCollisionSkips = 10
+
+// VoidNumberReq burns a number of the sequence of the invoice type, voucher type and fiscal
+// year invoiced_at falls in.
+type VoidNumberReq struct {
+	RegionId    uuid.UUID `json:"region_id"`
+	InvoiceType string    `json:"invoice_type"`
+	VoucherType string    `json:"voucher_type"`
+	No          int64     `json:"no"`
+	InvoicedAt  time.Time `json:"invoiced_at"`
+	InvoiceId   uuid.UUID `json:"invoice_id"`
+	Reason      string    `json:"reason"`
+}
+
+type INumberingService interface {
+	IssueWithTx(ctx *context.Context, tx *gorm.DB, invoiceType, voucherType string, regionId uuid.UUID, invoicedAt time.Time) (string, error)
+	Void(ctx *context.Context, req *VoidNumberReq) (*models.InvoiceNumberVoid, error)
+	GetVoided(ctx *context.Context, regionId uuid.UUID, sequenceName string) ([]*models.InvoiceNumberVoid, error)
+	SaveFiscalYear(ctx *context.Context, req *models.InvoiceFiscalYear) error
+}
+
+type NumberingService struct {
+	invoiceSequence invoicesequence.IInvoiceSequence
+	invoice         invoice.IInvoice
+	numberFormat    numberformat.INumberFormatService
+}
+
+func NewNumberingService() INumberingService {
+	return &NumberingService{
+		invoiceSequence: invoicesequence.NewInvoiceSequence(),
+		invoice:         invoice.NewInvoice(),
+		numberFormat:    numberformat.NewNumberFormatService(),
+	}
+}
+
+// IssueWithTx allocates the next invoice number in the transaction that persists the
+// invoice and renders it with the region's number template. The sequence row stays locked
+// until tx ends and a rollback returns the number, so concurrent invoices never share a
+// number and no gap is left behind. A rendered number that was already issued is voided
+// and the next one is taken, an issued number is never handed out twice.
+func (s *NumberingService) IssueWithTx(ctx *context.Context, tx *gorm.DB, invoiceType, voucherType string, regionId uuid.UUID, invoicedAt time.Time) (string, error) {
+	for i := 0; i < maxCollisionSkips; i++ {
+		no, err := s.invoiceSequence.NewInvoiceNumberWithTx(ctx, tx, invoiceType, voucherType, regionId, invoicedAt)
+		if err != nil {
+			return "", err
+		}
+
+		number, err := s.numberFormat.FormatNumber(ctx, regionId, invoiceType, voucherType, no, invoicedAt)
+		if err != nil {
+			return "", err
+		}
+		if number == "" {
+			number = strconv.FormatInt(no, 10)
+		}
+
+		exists, err := s.invoice.ExistsNumberWithTx(ctx, tx, number)
+		if err != nil {
+			return "", err
+		}
+		if !exists {
+			return number, nil
+		}
+
+		seqName, err := s.invoiceSequence.GetSequenceName(ctx, invoiceType, voucherType, regionId, invoicedAt)
+		if err != nil {
+			return "", err
+		}
+
+		err = s.invoiceSequence.VoidInvoiceNumberWithTx(ctx, tx, &models.InvoiceNumberVoid{
+			RegionId:     regionId,
+			SequenceName: seqName,
+			No:           no,
+			Reason:       "rendered number " + number + " was already issued",
+		})
+		if err != nil {
+			return "", err
+		}
+
+		ctx.Log.Info("skipped invoice number colliding with an issued invoice", zap.String("sequence", seqName), zap.String("no", number))
+	}
+
+	return "", ErrNumberCollision
+}
+
+// Void records why an allocated number was burned, so the gap in the sequence is accounted
+// for. The number itself is never handed out again.
+func (s *NumberingService) Void(ctx *context.Context, req *VoidNumberReq) (*models.InvoiceNumberVoid, error) {
+	if strings.TrimSpace(req.Reason) == "" {
+		return nil, ErrVoidReasonRequired
+	}
+
+	invoicedAt := req.InvoicedAt.UTC()
+	if req.InvoicedAt.IsZero() {
+		invoicedAt = time.Now().UTC()
+	}
+
+	last, err := s.invoiceSequence.GetLastInvoiceNumber(ctx, req.InvoiceType, req.VoucherType, req.RegionId, invoicedAt)
+	if err != nil {
+		return nil, err
+	}
+	if req.No < 1 || req.No > last {
+		return nil, ErrVoidNumberInvalid
+	}
+
+	seqName, err := s.invoiceSequence.GetSequenceName(ctx, req.InvoiceType, req.VoucherType, req.RegionId, invoicedAt)
+	if err != nil {
+		return nil, err
+	}
+
+	voided, err := s.invoiceSequence.GetVoidedNumbers(ctx, req.RegionId, seqName)
+	if err != nil {
+		return nil, err
+	}
+	for _, v := range voided {
+		if v.No == req.No {
+			return nil, ErrVoidNumberDuplicate
+		}
+	}
+
+	void := &models.InvoiceNumberVoid{
+		RegionId:     req.RegionId,
+		SequenceName: seqName,
+		No:           req.No,
+		InvoiceId:    req.InvoiceId,
+		Reason:       strings.TrimSpace(req.Reason),
+	}
+	if err := s.invoiceSequence.VoidInvoiceNumber(ctx, void); err != nil {
+		return nil, err
+	}
+
+	ctx.Log.Info("invoice number voided", zap.String("sequence", seqName), zap.Int64("no", req.No), zap.Any("region_id", req.RegionId))
+
+	return void, nil
+}
+
+func (s *NumberingService) GetVoided(ctx *context.Context, regionId uuid.UUID, sequenceName string) ([]*models.InvoiceNumberVoid, error) {
+	return s.invoiceSequence.GetVoidedNumbers(ctx, regionId, sequenceName)
+}
+
+// SaveFiscalYear sets the month the region's sequences with a {FY} template restart from 1.
+// It applies from the next allocation, numbers already issued keep the fiscal year they
+// were issued in.
+func (s *NumberingService) SaveFiscalYear(ctx *context.Context, req *models.InvoiceFiscalYear) error {
+	if req.StartMonth < int(time.January) || req.StartMonth > int(time.December) {
+		return ErrInvalidStartMonth
+	}
+
+	now := time.Now().UTC()
+	if req.Id == uuid.Nil {
+		req.Id = uuid.New()
+	}
+	if req.CreatedAt.IsZero() {
+		req.CreatedAt = now
+	}
+	req.UpdatedAt = now
+	if ctx.Account != nil {
+		if req.CreatedBy == uuid.Nil {
+			req.CreatedBy = ctx.Account.ID
+		}
+		req.UpdatedBy = ctx.Account.ID
+	}
+
+	return s.invoiceSequence.UpsertFiscalYear(ctx, req)
+}
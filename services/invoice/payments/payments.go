@@ -0,0 +1,188 @@
+package payments
+
+import (
+	"errors"
+	"sort"
+	"strings"
+	"time"
+
+	"bitbucket.org/radarventures/forwarder-adapters/utils/context"
+	"bitbucket.org/radarventures/forwarder-shipments/constants"
+	"bitbucket.org/radarventures/forwarder-shipments/daos/invoice"
+	"bitbucket.org/radarventures/forwarder-shipments/daos/invoicebalance"
+	"bitbucket.org/radarventures/forwarder-shipments/daos/payment"
+	"bitbucket.org/radarventures/forwarder-shipments/database/models"
+	"bitbucket.org/radarventures/forwarder-shipments/services/invoice/balance"
+	"github.com/google/uuid"
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+)
+
+var (
+	ErrInvalidReceipt         = errors.New("receipt currency and a positive amount are required")
+	ErrAllocationsRequired    = errors.New("at least one invoice allocation is required")
+	ErrInvalidAllocation      = errors.New("allocation amount must be greater than zero")
+	ErrAllocationsExceed      = errors.New("allocations exceed the received amount")
+	ErrExchangeRateRequired   = errors.New("exchange rate is required when the receipt and invoice currencies differ")
+	ErrPaymentAgainstNote     = errors.New("payments can only be recorded against invoices")
+	ErrDuplicateAllocationInv = errors.New("an invoice can only be allocated once per receipt")
+)
+
+type IPaymentService interface {
+	RecordPayment(ctx *context.Context, req *models.PaymentReceiptReq) ([]*models.InvoiceBalance, error)
+	GetInvoicePayments(ctx *context.Context, invoiceNo string) ([]*models.PaymentAllocation, error)
+	GetOutstandingInvoices(ctx *context.Context, filter *models.InvoiceOutstandingFilter) ([]*models.InvoiceWithBalance, error)
+}
+
+type PaymentService struct {
+	invoiceDb        invoice.IInvoice
+	paymentDb        payment.IPayment
+	invoiceBalanceDb invoicebalance.IInvoiceBalance
+	balanceSrv       balance.IBalanceService
+}
+
+func NewPaymentService() IPaymentService {
+	return &PaymentService{
+		invoiceDb:        invoice.NewInvoice(),
+		paymentDb:        payment.NewPayment(),
+		invoiceBalanceDb: invoicebalance.NewInvoiceBalance(),
+		balanceSrv:       balance.NewBalanceService(),
+	}
+}
+
+// RecordPayment settles a received amount, possibly in another currency, against one or
+// many invoices and returns the updated balances of those invoices.
+func (s *PaymentService) RecordPayment(ctx *context.Context, req *models.PaymentReceiptReq) ([]*models.InvoiceBalance, error) {
+	req.Currency = strings.ToUpper(strings.TrimSpace(req.Currency))
+	if req.Currency == "" || req.Amount <= 0 {
+		return nil, ErrInvalidReceipt
+	}
+	if len(req.Allocations) == 0 {
+		return nil, ErrAllocationsRequired
+	}
+
+	now := time.Now().UTC()
+	if req.ReceivedOn.IsZero() {
+		req.ReceivedOn = now
+	}
+
+	receiptId := uuid.New()
+	allocated := 0.0
+	seen := map[string]bool{}
+	invoices := []*models.Invoice{}
+	allocations := []*models.PaymentAllocation{}
+
+	for _, allocation := range req.Allocations {
+		if allocation.Amount <= 0 {
+			return nil, ErrInvalidAllocation
+		}
+		if seen[allocation.InvoiceNo] {
+			return nil, ErrDuplicateAllocationInv
+		}
+		seen[allocation.InvoiceNo] = true
+		allocated += allocation.Amount
+
+		inv, err := s.invoiceDb.GetByInvoiceNumber(ctx, allocation.InvoiceNo)
+		if err != nil {
+			return nil, err
+		}
+		if inv.InvoiceType == constants.CreditNote || inv.InvoiceType == constants.DebitNote {
+			return nil, ErrPaymentAgainstNote
+		}
+
+		current, err := s.balanceSrv.Calculate(ctx, inv, nil)
+		if err != nil {
+			return nil, err
+		}
+
+		exchangeRate := allocation.ExchangeRate
+		if current.Currency == "" || strings.EqualFold(current.Currency, req.Currency) {
+			exchangeRate = 1
+		} else if exchangeRate <= 0 {
+			return nil, ErrExchangeRateRequired
+		}
+
+		invoices = append(invoices, inv)
+		allocations = append(allocations, &models.PaymentAllocation{
+			Id:              uuid.New(),
+			ReceiptId:       receiptId,
+			ReceiptNo:       req.ReceiptNo,
+			ReceivedOn:      req.ReceivedOn,
+			InvoiceId:       inv.ID,
+			InvoiceNo:       inv.No,
+			Currency:        req.Currency,
+			Amount:          allocation.Amount,
+			ExchangeRate:    exchangeRate,
+			InvoiceCurrency: current.Currency,
+			AllocatedAmount: allocation.Amount * exchangeRate,
+			Remarks:         req.Remarks,
+			CreatedBy:       ctx.Account.ID,
+			CreatedAt:       now,
+		})
+	}
+
+	if allocated > req.Amount+balance.AmountTolerance {
+		return nil, ErrAllocationsExceed
+	}
+
+	// Invoices are locked in id order, so receipts sharing invoices cannot deadlock
+	locked := append([]*models.Invoice{}, invoices...)
+	sort.Slice(locked, func(i, j int) bool { return locked[i].ID.String() < locked[j].ID.String() })
+
+	// The balances are recalculated in the transaction that writes the allocations. The
+	// balance rows stay locked until it commits, so receipts and notes against the same
+	// invoice are applied one after the other and none of them is lost.
+	balances := []*models.InvoiceBalance{}
+	err := ctx.DB.WithContext(ctx.Request.Context()).Transaction(func(tx *gorm.DB) error {
+		for _, inv := range locked {
+			_, err := s.invoiceBalanceDb.LockWithTx(ctx, tx, &models.InvoiceBalance{
+				InvoiceId:  inv.ID,
+				InvoiceNo:  inv.No,
+				ShipmentId: inv.ShipmentID,
+				Status:     constants.InvoiceStatusUnpaid,
+				UpdatedAt:  now,
+			})
+			if err != nil {
+				return err
+			}
+		}
+
+		if err := s.paymentDb.UpsertAllocationsWithTx(ctx, tx, allocations...); err != nil {
+			return err
+		}
+
+		for _, inv := range invoices {
+			invoiceBalance, err := s.balanceSrv.CalculateWithTx(ctx, tx, inv, nil)
+			if err != nil {
+				return err
+			}
+
+			if err := s.invoiceBalanceDb.UpsertWithTx(ctx, tx, invoiceBalance); err != nil {
+				return err
+			}
+
+			balances = append(balances, invoiceBalance)
+		}
+
+		return nil
+	})
+	if err != nil {
+		ctx.Log.Error("unable to record payment", zap.Error(err), zap.Any("receipt_no", req.ReceiptNo))
+		return nil, err
+	}
+
+	return balances, nil
+}
+
+func (s *PaymentService) GetInvoicePayments(ctx *context.Context, invoiceNo string) ([]*models.PaymentAllocation, error) {
+	inv, err := s.invoiceDb.GetByInvoiceNumber(ctx, invoiceNo)
+	if err != nil {
+		return nil, err
+	}
+
+	return s.paymentDb.GetAllocations(ctx, []string{inv.ID.String()})
+}
+
+func (s *PaymentService) GetOutstandingInvoices(ctx *context.Context, filter *models.InvoiceOutstandingFilter) ([]*models.InvoiceWithBalance, error) {
+	return s.invoiceDb.GetOutstanding(ctx, filter)
+}
@@ -0,0 +1,48 @@
+package retrieval
+
+import (
+	"errors"
+
+	"bitbucket.org/radarventures/forwarder-adapters/utils/context"
+	"bitbucket.org/radarventures/forwarder-shipments/daos/invoicerequest"
+	"bitbucket.org/radarventures/forwarder-shipments/database/models"
+	"go.uber.org/zap"
+)
+
+var ErrRetryIdsRequired = errors.New("at least one invoice request id is required")
+
+type IRetrievalService interface {
+	GetStuckRequests(ctx *context.Context, regionId string, deadLetterOnly bool) ([]*models.StuckInvoiceRequest, error)
+	RetryRequests(ctx *context.Context, req *models.RetryInvoiceRequestsReq) (int64, error)
+}
+
+type RetrievalService struct {
+	invoiceRequestDb invoicerequest.IInvoiceRequest
+}
+
+func NewRetrievalService() IRetrievalService {
+	return &RetrievalService{
+		invoiceRequestDb: invoicerequest.NewInvoiceRequest(),
+	}
+}
+
+func (s *RetrievalService) GetStuckRequests(ctx *context.Context, regionId string, deadLetterOnly bool) ([]*models.StuckInvoiceRequest, error) {
+	return s.invoiceRequestDb.GetStuck(ctx, regionId, deadLetterOnly)
+}
+
+// RetryRequests queues stuck invoice requests for the next InvoiceRetrievel run and returns
+// how many were requeued.
+func (s *RetrievalService) RetryRequests(ctx *context.Context, req *models.RetryInvoiceRequestsReq) (int64, error) {
+	if len(req.Ids) == 0 {
+		return 0, ErrRetryIdsRequired
+	}
+
+	count, err := s.invoiceRequestDb.ResetRetry(ctx, req.Ids)
+	if err != nil {
+		return 0, err
+	}
+
+	ctx.Log.Info("invoice requests queued for retry", zap.Any("ids", req.Ids), zap.Int64("count", count), zap.Any("by", ctx.Account.ID))
+
+	return count, nil
+}
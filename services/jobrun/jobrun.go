@@ -0,0 +1,41 @@
+package jobrun
+
+import (
+	"bitbucket.org/radarventures/forwarder-adapters/utils/context"
+	"bitbucket.org/radarventures/forwarder-shipments/daos/jobrun"
+	"bitbucket.org/radarventures/forwarder-shipments/database/models"
+)
+
+const (
+	DefaultJobRunsLimit = 50
+	MaxJobRunsLimit     = 500
+)
+
+type IJobRunService interface {
+	GetJobRuns(ctx *context.Context, filter *models.JobRunFilter) ([]*models.JobRun, error)
+}
+
+type JobRunService struct {
+	jobRunDb jobrun.IJobRun
+}
+
+func NewJobRunService() IJobRunService {
+	return &JobRunService{
+		jobRunDb: jobrun.NewJobRun(),
+	}
+}
+
+// GetJobRuns returns the history of scheduled cronjob runs, latest first.
+func (s *JobRunService) GetJobRuns(ctx *context.Context, filter *models.JobRunFilter) ([]*models.JobRun, error) {
+	if filter.Limit <= 0 {
+		filter.Limit = DefaultJobRunsLimit
+	}
+	if filter.Limit > MaxJobRunsLimit {
+		filter.Limit = MaxJobRunsLimit
+	}
+	if filter.Offset < 0 {
+		filter.Offset = 0
+	}
+
+	return s.jobRunDb.GetAll(ctx, filter)
+}
@@ -0,0 +1,253 @@
+package lockguard
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"bitbucket.org/radarventures/forwarder-adapters/utils/context"
+	"bitbucket.org/radarventures/forwarder-shipments/constants"
+	"bitbucket.org/radarventures/forwarder-shipments/daos/lockpolicy"
+	"bitbucket.org/radarventures/forwarder-shipments/daos/shipment"
+	"bitbucket.org/radarventures/forwarder-shipments/daos/shipmentcontainer"
+	"bitbucket.org/radarventures/forwarder-shipments/daos/unlockrequest"
+	"bitbucket.org/radarventures/forwarder-shipments/database/models"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+var (
+	ErrInvalidLockArea   = errors.New("unknown shipment lock area")
+	ErrLockFieldRequired = errors.New("field is required")
+)
+
+var lockAreas = []string{
+	constants.LockAreaShipment,
+	constants.LockAreaDocuments,
+	constants.LockAreaContainers,
+	constants.LockAreaBills,
+	constants.LockAreaCharges,
+	constants.LockAreaHBL,
+}
+
+// ILockGuardService decides whether a write to a shipment may go through. Every mutating
+// endpoint of a shipment checks it before writing.
+type ILockGuardService interface {
+	Check(ctx *context.Context, shipmentId uuid.UUID, area string, fields []string) (*models.ShipmentLockedError, error)
+	CheckContainer(ctx *context.Context, containerId uuid.UUID, fields []string) (*models.ShipmentLockedError, error)
+	CheckQuote(ctx *context.Context, quoteId string, fields []string) (*models.ShipmentLockedError, error)
+
+	SaveAllowedField(ctx *context.Context, req *models.ShipmentLockAllowedField) (*models.ShipmentLockAllowedField, error)
+	DeleteAllowedField(ctx *context.Context, id uuid.UUID) error
+	GetAllowedFields(ctx *context.Context, regionId string) ([]*models.ShipmentLockAllowedField, error)
+}
+
+type LockGuardService struct {
+	lockPolicyDb    lockpolicy.ILockPolicy
+	unlockRequestDb unlockrequest.IUnlockRequest
+	shipmentDb      shipment.IShipment
+	containerDb     shipmentcontainer.IShipmentContainer
+}
+
+func NewLockGuardService() ILockGuardService {
+	return &LockGuardService{
+		lockPolicyDb:    lockpolicy.NewLockPolicy(),
+		unlockRequestDb: unlockrequest.NewUnlockRequest(),
+		shipmentDb:      shipment.NewShipment(),
+		containerDb:     shipmentcontainer.NewShipmentContainer(),
+	}
+}
+
+// Check returns the error to answer a write of fields to the area of the shipment with, or nil
+// when the write may go through. A write goes through when the shipment is unlocked, when an
+// approved unlock request of the shipment covers the area, or when every field written is
+// allow-listed for the area. Ids that are not of a shipment are let through.
+func (s *LockGuardService) Check(ctx *context.Context, shipmentId uuid.UUID, area string, fields []string) (*models.ShipmentLockedError, error) {
+	shipment, err := s.shipmentDb.Get(ctx, shipmentId.String())
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	// An approved unlock request leaves the shipment unlocked for the areas of its scope only
+	unlock, err := s.unlockRequestDb.GetActive(ctx, shipment.Id, time.Now().UTC())
+	if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, err
+	}
+
+	if unlock != nil {
+		if scopeCovers(unlock.Scope, area) {
+			return nil, nil
+		}
+	} else if !shipment.IsShipmentLocked {
+		return nil, nil
+	}
+
+	allowed, err := s.lockPolicyDb.GetAllowedFields(ctx, shipment.RegionId.String())
+	if err != nil {
+		return nil, err
+	}
+
+	blocked, ok := blockedFields(allowed, area, fields)
+	if ok {
+		return nil, nil
+	}
+
+	holder, err := s.lockHolder(ctx, shipment.Id, unlock)
+	if err != nil {
+		return nil, err
+	}
+
+	message := fmt.Sprintf("shipment is locked by %s", holder.Name)
+	if unlock != nil {
+		message = fmt.Sprintf("shipment is unlocked for %s only until %s", unlock.Scope, unlock.ExpiresAt.Format(time.RFC3339))
+	}
+
+	return &models.ShipmentLockedError{
+		Code:          http.StatusLocked,
+		Error:         "shipment_locked",
+		Message:       message,
+		ShipmentId:    shipment.Id,
+		Area:          area,
+		BlockedFields: blocked,
+		Lock:          holder,
+	}, nil
+}
+
+// CheckContainer checks a write to a container against the lock of its shipment.
+func (s *LockGuardService) CheckContainer(ctx *context.Context, containerId uuid.UUID, fields []string) (*models.ShipmentLockedError, error) {
+	container, err := s.containerDb.Get(ctx, containerId.String())
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	return s.Check(ctx, container.ShipmentId, constants.LockAreaContainers, fields)
+}
+
+// CheckQuote checks a write to a quote or its line items against the lock of the shipment
+// booked from it. Quotes without a shipment are let through.
+func (s *LockGuardService) CheckQuote(ctx *context.Context, quoteId string, fields []string) (*models.ShipmentLockedError, error) {
+	shipment, err := s.shipmentDb.GetByQuote(ctx, quoteId)
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	return s.Check(ctx, shipment.Id, constants.LockAreaCharges, fields)
+}
+
+func (s *LockGuardService) SaveAllowedField(ctx *context.Context, req *models.ShipmentLockAllowedField) (*models.ShipmentLockAllowedField, error) {
+	if !validArea(req.Area) {
+		return nil, ErrInvalidLockArea
+	}
+
+	if req.Field == "" {
+		return nil, ErrLockFieldRequired
+	}
+
+	req.Id = uuid.New()
+	req.UpdatedBy = ctx.Account.ID
+	req.UpdatedAt = time.Now().UTC()
+
+	err := s.lockPolicyDb.UpsertAllowedField(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+
+	return req, nil
+}
+
+func (s *LockGuardService) DeleteAllowedField(ctx *context.Context, id uuid.UUID) error {
+	return s.lockPolicyDb.DeleteAllowedField(ctx, id)
+}
+
+func (s *LockGuardService) GetAllowedFields(ctx *context.Context, regionId string) ([]*models.ShipmentLockAllowedField, error) {
+	return s.lockPolicyDb.GetAllowedFields(ctx, regionId)
+}
+
+// lockHolder names what keeps the shipment locked: the unlock request limiting it to a scope,
+// the latest policy decision that locked it, or else a manual lock.
+func (s *LockGuardService) lockHolder(ctx *context.Context, shipmentId uuid.UUID, unlock *models.ShipmentUnlockRequest) (*models.LockHolder, error) {
+	if unlock != nil {
+		return &models.LockHolder{
+			Name:   "unlock request " + unlock.Id.String(),
+			Rule:   "unlock_scope",
+			Reason: unlock.Reason,
+		}, nil
+	}
+
+	decisions, err := s.lockPolicyDb.GetDecisions(ctx, shipmentId)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(decisions) == 0 {
+		return &models.LockHolder{
+			Name: "manual lock",
+		}, nil
+	}
+
+	return &models.LockHolder{
+		Name:     decisions[0].PolicyName,
+		Rule:     decisions[0].Rule,
+		Reason:   decisions[0].Reason,
+		LockedAt: &decisions[0].LockedAt,
+	}, nil
+}
+
+func scopeCovers(scope, area string) bool {
+	if scope == constants.UnlockScopeAll {
+		return true
+	}
+
+	for _, a := range constants.UnlockScopeAreas[scope] {
+		if a == area {
+			return true
+		}
+	}
+
+	return false
+}
+
+// blockedFields returns the fields that are not allow-listed for the area, and whether the
+// write may go through. A write without fields only goes through when the whole area is
+// allow-listed.
+func blockedFields(allowed []*models.ShipmentLockAllowedField, area string, fields []string) ([]string, bool) {
+	allow := map[string]bool{}
+	for _, a := range allowed {
+		if a.Area == area {
+			allow[a.Field] = true
+		}
+	}
+
+	if allow[constants.LockAllowAllFields] {
+		return nil, true
+	}
+
+	blocked := make([]string, 0)
+	for _, field := range fields {
+		if !allow[field] {
+			blocked = append(blocked, field)
+		}
+	}
+
+	return blocked, len(fields) > 0 && len(blocked) == 0
+}
+
+func validArea(area string) bool {
+	for _, a := range lockAreas {
+		if a == area {
+			return true
+		}
+	}
+
+	return false
+}
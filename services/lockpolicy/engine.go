@@ -0,0 +1,194 @@
+package lockpolicy
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"bitbucket.org/radarventures/forwarder-shipments/config/globals"
+	"bitbucket.org/radarventures/forwarder-shipments/constants"
+	"bitbucket.org/radarventures/forwarder-shipments/database/models"
+	"github.com/google/uuid"
+)
+
+// DefaultPolicy holds the rules shipments were locked by before policies could be configured.
+// It applies to regions without an active policy.
+func DefaultPolicy() *models.ShipmentLockPolicy {
+	return &models.ShipmentLockPolicy{
+		Name:              "default",
+		IsActive:          true,
+		TriggerMilestone:  "booking confirm",
+		FallbackMilestone: constants.MilestoneBookingCreated,
+		GracePeriods: models.LockGracePeriods{
+			globals.BookingTypeFCL:     60,
+			globals.BookingTypeLCL:     60,
+			globals.BookingTypeAIR:     30,
+			constants.ShipmentTypeMisc: 30,
+		},
+		RequireSellInvoiced: true,
+		RequireBuyApproved:  true,
+	}
+}
+
+// Evaluate checks the shipment against the policies in order. The first policy with a rule
+// that fires locks the shipment; every policy's outcome is explained either way.
+func Evaluate(policies []*models.ShipmentLockPolicy, shipment *models.Shipment, milestones []*models.LockMilestone, lineItems []*models.LockLineItem, now time.Time) *models.LockEvaluation {
+	evaluation := &models.LockEvaluation{
+		ShipmentId:   shipment.Id,
+		ShipmentType: shipment.Type,
+		IsLocked:     shipment.IsShipmentLocked,
+		Policies:     []*models.LockPolicyResult{},
+		EvaluatedAt:  now,
+	}
+
+	if shipment.Type == globals.BookingTypeCONSOL {
+		evaluation.Reason = "consol shipments are not locked automatically"
+		return evaluation
+	}
+
+	for _, policy := range policies {
+		result := evaluatePolicy(policy, shipment.Type, milestones, lineItems, now)
+		evaluation.Policies = append(evaluation.Policies, result)
+
+		if result.Fired && !evaluation.WouldLock {
+			evaluation.WouldLock = true
+			evaluation.Rule = result.Rule
+			evaluation.Reason = result.Reasons[len(result.Reasons)-1]
+			evaluation.Policy = result
+		}
+	}
+
+	if !evaluation.WouldLock {
+		evaluation.Reason = "no policy rule fired"
+	}
+
+	return evaluation
+}
+
+func evaluatePolicy(policy *models.ShipmentLockPolicy, shipmentType string, milestones []*models.LockMilestone, lineItems []*models.LockLineItem, now time.Time) *models.LockPolicyResult {
+	result := &models.LockPolicyResult{
+		PolicyName: policy.Name,
+		Reasons:    []string{},
+	}
+	if policy.Id != uuid.Nil {
+		id := policy.Id
+		result.PolicyId = &id
+	}
+
+	// The financial rule is checked last so that a fired milestone rule is the one reported
+	reason, fired := milestoneRule(policy, shipmentType, milestones, now)
+	result.Reasons = append(result.Reasons, reason)
+	if fired {
+		result.Fired = true
+		result.Rule = constants.LockRuleMilestone
+		return result
+	}
+
+	reason, fired = financialRule(policy, lineItems)
+	result.Reasons = append(result.Reasons, reason)
+	if fired {
+		result.Fired = true
+		result.Rule = constants.LockRuleFinancial
+	}
+
+	return result
+}
+
+func milestoneRule(policy *models.ShipmentLockPolicy, shipmentType string, milestones []*models.LockMilestone, now time.Time) (string, bool) {
+	if policy.TriggerMilestone == "" {
+		return "policy has no trigger milestone", false
+	}
+
+	graceDays, ok := policy.GracePeriods[shipmentType]
+	if !ok {
+		return fmt.Sprintf("policy has no grace period for %s shipments", shipmentType), false
+	}
+
+	name := policy.TriggerMilestone
+	completedAt, found := completedMilestone(milestones, name)
+	if !found && policy.FallbackMilestone != "" {
+		name = policy.FallbackMilestone
+		completedAt, found = completedMilestone(milestones, name)
+	}
+
+	if !found {
+		return fmt.Sprintf("milestone %q is not completed", policy.TriggerMilestone), false
+	}
+
+	lockAt := completedAt.AddDate(0, 0, graceDays)
+	if !now.After(lockAt) {
+		return fmt.Sprintf("milestone %q completed on %s, locks after %d days on %s", name, completedAt.Format("02 Jan 2006"), graceDays, lockAt.Format("02 Jan 2006")), false
+	}
+
+	return fmt.Sprintf("milestone %q completed on %s, more than %d days ago", name, completedAt.Format("02 Jan 2006"), graceDays), true
+}
+
+// financialRule fires once every line item meets the conditions the policy enables. As before
+// policies could be configured, a shipment without line items has none pending and fires it.
+func financialRule(policy *models.ShipmentLockPolicy, lineItems []*models.LockLineItem) (string, bool) {
+	if !policy.RequireSellInvoiced && !policy.RequireBuyApproved {
+		return "policy has no financial conditions", false
+	}
+
+	if len(lineItems) == 0 {
+		return "shipment has no line items pending", true
+	}
+
+	pending := 0
+	for _, li := range lineItems {
+		if (policy.RequireSellInvoiced && !li.IsSellInvoiceGenerated) || (policy.RequireBuyApproved && !li.IsBuyApproved) {
+			pending++
+		}
+	}
+
+	conditions := make([]string, 0, 2)
+	if policy.RequireSellInvoiced {
+		conditions = append(conditions, "sell invoiced")
+	}
+	if policy.RequireBuyApproved {
+		conditions = append(conditions, "buy approved")
+	}
+
+	if pending > 0 {
+		return fmt.Sprintf("%d of %d line items are not %s", pending, len(lineItems), strings.Join(conditions, " and ")), false
+	}
+
+	return fmt.Sprintf("all %d line items are %s", len(lineItems), strings.Join(conditions, " and ")), true
+}
+
+// completedMilestone returns when the latest completed milestone whose name contains name was
+// completed.
+func completedMilestone(milestones []*models.LockMilestone, name string) (time.Time, bool) {
+	name = strings.ToLower(strings.TrimSpace(name))
+
+	var latest time.Time
+	found := false
+	for _, milestone := range milestones {
+		if milestone.Status != globals.StatusCompleted || milestone.CompletedAt == 0 {
+			continue
+		}
+
+		if !strings.Contains(strings.ToLower(strings.TrimSpace(milestone.Name)), name) {
+			continue
+		}
+
+		completedAt := milestoneTime(milestone.CompletedAt)
+		if !found || completedAt.After(latest) {
+			latest = completedAt
+			found = true
+		}
+	}
+
+	return latest, found
+}
+
+// milestoneTime converts a workflow completion timestamp. Workflow reports some milestones in
+// milliseconds; a value in seconds only reaches 1e10 in the year 2286, so larger values are
+// milliseconds.
+func milestoneTime(v int64) time.Time {
+	if v >= 1e10 {
+		return time.UnixMilli(v).UTC()
+	}
+
+	return time.Unix(v, 0).UTC()
+}
@@ -0,0 +1,189 @@
+package lockpolicy
+
+import (
+	"errors"
+	"sync"
+	"time"
+
+	"bitbucket.org/radarventures/forwarder-adapters/apis/workflow"
+	"bitbucket.org/radarventures/forwarder-adapters/utils/context"
+	"bitbucket.org/radarventures/forwarder-shipments/config"
+	"bitbucket.org/radarventures/forwarder-shipments/daos/lineitem"
+	"bitbucket.org/radarventures/forwarder-shipments/daos/lockpolicy"
+	"bitbucket.org/radarventures/forwarder-shipments/daos/shipment"
+	"bitbucket.org/radarventures/forwarder-shipments/database/models"
+	"github.com/google/uuid"
+	"go.uber.org/zap"
+)
+
+var (
+	ErrPolicyNameRequired = errors.New("policy name is required")
+	ErrPolicyHasNoRule    = errors.New("policy needs a trigger milestone with grace periods or a financial condition")
+	ErrInvalidGracePeriod = errors.New("grace periods cannot be negative")
+)
+
+type ILockPolicyService interface {
+	SavePolicy(ctx *context.Context, req *models.ShipmentLockPolicy) (*models.ShipmentLockPolicy, error)
+	GetPolicies(ctx *context.Context, regionId string) ([]*models.ShipmentLockPolicy, error)
+	DeletePolicy(ctx *context.Context, id uuid.UUID) error
+	Evaluate(ctx *context.Context, shipment *models.Shipment) (*models.LockEvaluation, error)
+	EvaluateShipment(ctx *context.Context, shipmentId string) (*models.LockEvaluation, error)
+	RecordDecision(ctx *context.Context, evaluation *models.LockEvaluation) error
+	GetDecisions(ctx *context.Context, shipmentId uuid.UUID) ([]*models.ShipmentLockDecision, error)
+}
+
+type LockPolicyService struct {
+	lockPolicyDb lockpolicy.ILockPolicy
+	shipmentDb   shipment.IShipment
+	lineitemDb   lineitem.ILineItem
+	workflow     *workflow.Workflow
+
+	// Policies of each tenant's region are loaded once per service, the lock cronjob evaluates
+	// many shipments of the same region
+	mu       sync.Mutex
+	policies map[string][]*models.ShipmentLockPolicy
+}
+
+func NewLockPolicyService() ILockPolicyService {
+	return &LockPolicyService{
+		lockPolicyDb: lockpolicy.NewLockPolicy(),
+		shipmentDb:   shipment.NewShipment(),
+		lineitemDb:   lineitem.NewLineItem(),
+		workflow:     workflow.New(config.Get().WorkflowURL),
+		policies:     map[string][]*models.ShipmentLockPolicy{},
+	}
+}
+
+func (s *LockPolicyService) SavePolicy(ctx *context.Context, req *models.ShipmentLockPolicy) (*models.ShipmentLockPolicy, error) {
+	if req.Name == "" {
+		return nil, ErrPolicyNameRequired
+	}
+
+	for _, days := range req.GracePeriods {
+		if days < 0 {
+			return nil, ErrInvalidGracePeriod
+		}
+	}
+
+	hasMilestoneRule := req.TriggerMilestone != "" && len(req.GracePeriods) > 0
+	if !hasMilestoneRule && !req.RequireSellInvoiced && !req.RequireBuyApproved {
+		return nil, ErrPolicyHasNoRule
+	}
+
+	if req.Id == uuid.Nil {
+		req.Id = uuid.New()
+	}
+	req.UpdatedBy = ctx.Account.ID
+	req.UpdatedAt = time.Now().UTC()
+
+	err := s.lockPolicyDb.Upsert(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+
+	return req, nil
+}
+
+func (s *LockPolicyService) GetPolicies(ctx *context.Context, regionId string) ([]*models.ShipmentLockPolicy, error) {
+	return s.lockPolicyDb.GetForRegion(ctx, regionId, false)
+}
+
+func (s *LockPolicyService) DeletePolicy(ctx *context.Context, id uuid.UUID) error {
+	return s.lockPolicyDb.Delete(ctx, id)
+}
+
+// EvaluateShipment explains whether the shipment would be locked by the policies of its region.
+func (s *LockPolicyService) EvaluateShipment(ctx *context.Context, shipmentId string) (*models.LockEvaluation, error) {
+	shipment, err := s.shipmentDb.Get(ctx, shipmentId)
+	if err != nil {
+		return nil, err
+	}
+
+	return s.Evaluate(ctx, shipment)
+}
+
+func (s *LockPolicyService) Evaluate(ctx *context.Context, shipment *models.Shipment) (*models.LockEvaluation, error) {
+	policies, err := s.regionPolicies(ctx, shipment.RegionId.String())
+	if err != nil {
+		return nil, err
+	}
+
+	milestones := make([]*models.LockMilestone, 0)
+	res, err := s.workflow.GetMilestones(ctx, shipment.Id.String())
+	if err != nil {
+		ctx.Log.Error("unable to get the milestones", zap.Any("shipment_id", shipment.Id), zap.Error(err))
+		return nil, err
+	}
+	if res != nil {
+		for _, milestone := range res.Milestones {
+			milestones = append(milestones, &models.LockMilestone{
+				Name:        milestone.Name,
+				Status:      milestone.Status,
+				CompletedAt: milestone.CompletedAt,
+			})
+		}
+	}
+
+	items, err := s.lineitemDb.GetLineItemsWithFilter(ctx, &models.LiFields{
+		QuoteId: shipment.QuoteId,
+	})
+	if err != nil {
+		ctx.Log.Error("error getting line items", zap.Any("shipment_id", shipment.Id), zap.Error(err))
+		return nil, err
+	}
+
+	lineItems := make([]*models.LockLineItem, 0, len(items))
+	for _, li := range items {
+		lineItems = append(lineItems, &models.LockLineItem{
+			IsSellInvoiceGenerated: li.IsSellInvoiceGenerated != nil && *li.IsSellInvoiceGenerated,
+			IsBuyApproved:          li.IsBuyApproved != nil && *li.IsBuyApproved,
+		})
+	}
+
+	return Evaluate(policies, shipment, milestones, lineItems, time.Now().UTC()), nil
+}
+
+// RecordDecision records the policy and rule that locked a shipment.
+func (s *LockPolicyService) RecordDecision(ctx *context.Context, evaluation *models.LockEvaluation) error {
+	if !evaluation.WouldLock || evaluation.Policy == nil {
+		return nil
+	}
+
+	return s.lockPolicyDb.CreateDecision(ctx, &models.ShipmentLockDecision{
+		Id:         uuid.New(),
+		ShipmentId: evaluation.ShipmentId,
+		PolicyId:   evaluation.Policy.PolicyId,
+		PolicyName: evaluation.Policy.PolicyName,
+		Rule:       evaluation.Rule,
+		Reason:     evaluation.Reason,
+		LockedAt:   evaluation.EvaluatedAt,
+	})
+}
+
+func (s *LockPolicyService) GetDecisions(ctx *context.Context, shipmentId uuid.UUID) ([]*models.ShipmentLockDecision, error) {
+	return s.lockPolicyDb.GetDecisions(ctx, shipmentId)
+}
+
+// regionPolicies returns the active policies of the region in the tenant the context runs
+// for, or the default policy when the region has none.
+func (s *LockPolicyService) regionPolicies(ctx *context.Context, regionId string) ([]*models.ShipmentLockPolicy, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	key := ctx.TenantID + "." + regionId
+	if policies, ok := s.policies[key]; ok {
+		return policies, nil
+	}
+
+	policies, err := s.lockPolicyDb.GetForRegion(ctx, regionId, true)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(policies) == 0 {
+		policies = []*models.ShipmentLockPolicy{DefaultPolicy()}
+	}
+	s.policies[key] = policies
+
+	return policies, nil
+}
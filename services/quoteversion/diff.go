@@ -0,0 +1,222 @@
+package quoteversion
+
+import (
+	"math"
+	"sort"
+	"strings"
+
+	"bitbucket.org/radarventures/forwarder-shipments/database/models"
+)
+
+const taxSubType = "Tax"
+
+// snapshot is a versioned line item with the exchange rates of its version. The sell rate of
+// the line item is its exchange_rate.
+func snapshot(li *models.LineItemWithExRate) *models.QuoteLineItemVersion {
+	v := &models.QuoteLineItemVersion{
+		Id:               li.Id,
+		Name:             li.Name,
+		SubType:          li.SubType,
+		Units:            li.Units,
+		Buy:              li.Buy,
+		Sell:             li.Sell,
+		BuyCurrency:      strings.ToUpper(li.BuyCurrency),
+		SellCurrency:     strings.ToUpper(li.SellCurrency),
+		BuyExchangeRate:  li.BuyExchangeRate,
+		SellExchangeRate: li.ExchangeRate,
+		Tax:              li.Tax,
+		BuyTax:           li.BuyTax,
+	}
+	v.TotalBuy = round(v.Buy * v.BuyExchangeRate * v.Units * (1 + v.BuyTax/100))
+	v.TotalSell = round(v.Sell * v.SellExchangeRate * v.Units * (1 + v.Tax/100))
+
+	return v
+}
+
+// changedFields returns the fields of a line item that differ between two versions.
+func changedFields(a, b *models.QuoteLineItemVersion) []string {
+	fields := []string{}
+	add := func(name string, changed bool) {
+		if changed {
+			fields = append(fields, name)
+		}
+	}
+
+	add("name", a.Name != b.Name)
+	add("units", !same(a.Units, b.Units))
+	add("buy", !same(a.Buy, b.Buy))
+	add("sell", !same(a.Sell, b.Sell))
+	add("buy_currency", a.BuyCurrency != b.BuyCurrency)
+	add("sell_currency", a.SellCurrency != b.SellCurrency)
+	add("buy_exchange_rate", !same(a.BuyExchangeRate, b.BuyExchangeRate))
+	add("sell_exchange_rate", !same(a.SellExchangeRate, b.SellExchangeRate))
+	add("tax", !same(a.Tax, b.Tax))
+	add("buy_tax", !same(a.BuyTax, b.BuyTax))
+
+	return fields
+}
+
+// diff compares the line items of two versions of a quote.
+func diff(from, to []*models.QuoteLineItemVersion, res *models.QuoteVersionDiff) {
+	res.Added = []*models.QuoteLineItemVersion{}
+	res.Removed = []*models.QuoteLineItemVersion{}
+	res.Changed = []*models.QuoteLineItemChange{}
+
+	old := map[string]*models.QuoteLineItemVersion{}
+	for _, item := range from {
+		old[item.Id] = item
+	}
+
+	current := map[string]bool{}
+	for _, item := range to {
+		current[item.Id] = true
+		prev, ok := old[item.Id]
+		if !ok {
+			res.Added = append(res.Added, item)
+			continue
+		}
+
+		if fields := changedFields(prev, item); len(fields) > 0 {
+			res.Changed = append(res.Changed, &models.QuoteLineItemChange{
+				Id:     item.Id,
+				Name:   item.Name,
+				Fields: fields,
+				From:   prev,
+				To:     item,
+			})
+		}
+	}
+
+	for _, item := range from {
+		if !current[item.Id] {
+			res.Removed = append(res.Removed, item)
+		}
+	}
+
+	sortItems(res.Added)
+	sortItems(res.Removed)
+	sort.SliceStable(res.Changed, func(i, j int) bool {
+		return res.Changed[i].Name < res.Changed[j].Name
+	})
+
+	res.ExchangeRateChanges = exchangeRateChanges(from, to)
+	res.Margin = models.QuoteMarginChange{
+		From: margin(from),
+		To:   margin(to),
+	}
+	res.Margin.Margin = round(res.Margin.To.Margin - res.Margin.From.Margin)
+	res.Margin.MarginPercent = round(res.Margin.To.MarginPercent - res.Margin.From.MarginPercent)
+}
+
+// exchangeRateChanges returns the currencies whose buy or sell rate differs between the
+// versions.
+func exchangeRateChanges(from, to []*models.QuoteLineItemVersion) []*models.QuoteExchangeRateChange {
+	rates := func(items []*models.QuoteLineItemVersion) map[[2]string]float64 {
+		res := map[[2]string]float64{}
+		for _, item := range items {
+			if item.BuyCurrency != "" {
+				res[[2]string{item.BuyCurrency, "buyrate"}] = item.BuyExchangeRate
+			}
+			if item.SellCurrency != "" {
+				res[[2]string{item.SellCurrency, "sellrate"}] = item.SellExchangeRate
+			}
+		}
+		return res
+	}
+
+	old, current := rates(from), rates(to)
+	changes := []*models.QuoteExchangeRateChange{}
+	for key, rate := range current {
+		if prev, ok := old[key]; ok && !same(prev, rate) {
+			changes = append(changes, &models.QuoteExchangeRateChange{Currency: key[0], Type: key[1], From: prev, To: rate})
+		}
+	}
+
+	sort.Slice(changes, func(i, j int) bool {
+		if changes[i].Currency != changes[j].Currency {
+			return changes[i].Currency < changes[j].Currency
+		}
+		return changes[i].Type < changes[j].Type
+	})
+
+	return changes
+}
+
+func margin(items []*models.QuoteLineItemVersion) models.QuoteMargin {
+	m := models.QuoteMargin{}
+	for _, item := range items {
+		if item.SubType == taxSubType {
+			continue
+		}
+		m.Buy += item.Buy * item.BuyExchangeRate * item.Units
+		m.Sell += item.Sell * item.SellExchangeRate * item.Units
+	}
+
+	m.Buy, m.Sell = round(m.Buy), round(m.Sell)
+	m.Margin = round(m.Sell - m.Buy)
+	if m.Sell != 0 {
+		m.MarginPercent = round(m.Margin / m.Sell * 100)
+	}
+
+	return m
+}
+
+// revision summarises a diff for the customer from the sell side only. Charge amounts are in
+// the currency of the charge, totals in the currency of the region including tax.
+func revision(res *models.QuoteVersionDiff, from, to []*models.QuoteLineItemVersion) *models.QuoteRevisionSummary {
+	summary := &models.QuoteRevisionSummary{
+		FromVersion: res.FromVersion,
+		ToVersion:   res.ToVersion,
+		Added:       []*models.QuoteRevisionCharge{},
+		Removed:     []*models.QuoteRevisionCharge{},
+		Changed:     []*models.QuoteRevisionCharge{},
+	}
+
+	for _, item := range res.Added {
+		if item.Sell != 0 {
+			summary.Added = append(summary.Added, &models.QuoteRevisionCharge{Name: item.Name, Currency: item.SellCurrency, NewAmount: round(item.Sell * item.Units)})
+		}
+	}
+	for _, item := range res.Removed {
+		if item.Sell != 0 {
+			summary.Removed = append(summary.Removed, &models.QuoteRevisionCharge{Name: item.Name, Currency: item.SellCurrency, OldAmount: round(item.Sell * item.Units)})
+		}
+	}
+	for _, change := range res.Changed {
+		oldAmount, newAmount := round(change.From.Sell*change.From.Units), round(change.To.Sell*change.To.Units)
+		if same(oldAmount, newAmount) && change.From.SellCurrency == change.To.SellCurrency {
+			continue
+		}
+		summary.Changed = append(summary.Changed, &models.QuoteRevisionCharge{
+			Name:      change.Name,
+			Currency:  change.To.SellCurrency,
+			OldAmount: oldAmount,
+			NewAmount: newAmount,
+		})
+	}
+
+	for _, item := range from {
+		summary.OldTotal += item.TotalSell
+	}
+	for _, item := range to {
+		summary.NewTotal += item.TotalSell
+	}
+	summary.OldTotal, summary.NewTotal = round(summary.OldTotal), round(summary.NewTotal)
+	summary.Difference = round(summary.NewTotal - summary.OldTotal)
+
+	return summary
+}
+
+func sortItems(items []*models.QuoteLineItemVersion) {
+	sort.SliceStable(items, func(i, j int) bool {
+		return items[i].Name < items[j].Name
+	})
+}
+
+func same(a, b float64) bool {
+	return math.Abs(a-b) < 1e-9
+}
+
+func round(v float64) float64 {
+	return math.Round(v*100) / 100
+}
@@ -0,0 +1,152 @@
+package quoteversion
+
+import (
+	"errors"
+
+	"bitbucket.org/radarventures/forwarder-adapters/utils/context"
+	"bitbucket.org/radarventures/forwarder-shipments/daos/versionedlineitems"
+	"bitbucket.org/radarventures/forwarder-shipments/database/models"
+)
+
+var (
+	ErrRegionRequired    = errors.New("region is required")
+	ErrSameVersion       = errors.New("versions to compare must differ")
+	ErrVersionNotFound   = errors.New("quote has no line items for the version")
+	ErrNoPreviousVersion = errors.New("quote has no earlier version to compare with")
+	ErrMarginUnknown     = errors.New("quote has priced line items without an exchange rate")
+)
+
+type IQuoteVersionService interface {
+	GetVersions(ctx *context.Context, quoteId string) ([]int64, error)
+	Diff(ctx *context.Context, quoteId, regionId string, from, to int64) (*models.QuoteVersionDiff, error)
+	Revision(ctx *context.Context, quoteId, regionId string, from int64) (*models.QuoteRevisionSummary, error)
+	Margin(ctx *context.Context, quoteId, regionId string) (*models.QuoteMargin, error)
+}
+
+type QuoteVersionService struct {
+	lineItemDb versionedlineitems.IVersionedLineItems
+}
+
+func NewQuoteVersionService() IQuoteVersionService {
+	return &QuoteVersionService{
+		lineItemDb: versionedlineitems.NewVersionedLineItems(),
+	}
+}
+
+func (s *QuoteVersionService) GetVersions(ctx *context.Context, quoteId string) ([]int64, error) {
+	return s.lineItemDb.GetLineItemVersions(ctx, quoteId)
+}
+
+// Diff compares the line items of two versions of a quote in a region. A zero to compares
+// against the latest version and a zero from against the version before to.
+func (s *QuoteVersionService) Diff(ctx *context.Context, quoteId, regionId string, from, to int64) (*models.QuoteVersionDiff, error) {
+	res, _, _, err := s.diff(ctx, quoteId, regionId, from, to)
+	return res, err
+}
+
+// Revision summarises for the customer how the latest version of a quote changed the sell
+// side since the given version, or since the version before it when from is zero.
+func (s *QuoteVersionService) Revision(ctx *context.Context, quoteId, regionId string, from int64) (*models.QuoteRevisionSummary, error) {
+	res, fromItems, toItems, err := s.diff(ctx, quoteId, regionId, from, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	return revision(res, fromItems, toItems), nil
+}
+
+// Margin returns the margin of the latest version of a quote in a region. A priced line item
+// without its exchange rate would count as nothing, so the margin is ErrMarginUnknown then.
+func (s *QuoteVersionService) Margin(ctx *context.Context, quoteId, regionId string) (*models.QuoteMargin, error) {
+	version, err := s.lineItemDb.GetMaxLineItemVersion(ctx, quoteId)
+	if err != nil {
+		return nil, err
+	}
+	if version == 0 {
+		return nil, ErrVersionNotFound
+	}
+
+	items, err := s.items(ctx, quoteId, regionId, version)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, item := range items {
+		if item.SubType == taxSubType {
+			continue
+		}
+		if (item.Buy != 0 && item.BuyExchangeRate == 0) || (item.Sell != 0 && item.SellExchangeRate == 0) {
+			return nil, ErrMarginUnknown
+		}
+	}
+
+	m := margin(items)
+	return &m, nil
+}
+
+func (s *QuoteVersionService) diff(ctx *context.Context, quoteId, regionId string, from, to int64) (*models.QuoteVersionDiff, []*models.QuoteLineItemVersion, []*models.QuoteLineItemVersion, error) {
+	if regionId == "" {
+		return nil, nil, nil, ErrRegionRequired
+	}
+
+	versions, err := s.lineItemDb.GetLineItemVersions(ctx, quoteId)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	if len(versions) == 0 {
+		return nil, nil, nil, ErrVersionNotFound
+	}
+
+	if to == 0 {
+		to = versions[len(versions)-1]
+	}
+	if from == 0 {
+		for _, v := range versions {
+			if v < to {
+				from = v
+			}
+		}
+		if from == 0 {
+			return nil, nil, nil, ErrNoPreviousVersion
+		}
+	}
+	if from == to {
+		return nil, nil, nil, ErrSameVersion
+	}
+
+	fromItems, err := s.items(ctx, quoteId, regionId, from)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	toItems, err := s.items(ctx, quoteId, regionId, to)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+
+	res := &models.QuoteVersionDiff{
+		QuoteId:     quoteId,
+		RegionId:    regionId,
+		FromVersion: from,
+		ToVersion:   to,
+	}
+	diff(fromItems, toItems, res)
+
+	return res, fromItems, toItems, nil
+}
+
+func (s *QuoteVersionService) items(ctx *context.Context, quoteId, regionId string, version int64) ([]*models.QuoteLineItemVersion, error) {
+	lineItems, err := s.lineItemDb.GetVersionedLineItemsWithExchangeRates(ctx, quoteId, regionId, version)
+	if err != nil {
+		return nil, err
+	}
+	if len(lineItems) == 0 {
+		return nil, ErrVersionNotFound
+	}
+
+	items := make([]*models.QuoteLineItemVersion, 0, len(lineItems))
+	for _, li := range lineItems {
+		items = append(items, snapshot(li))
+	}
+
+	return items, nil
+}
@@ -0,0 +1,43 @@
+package quoteversion
+
+import (
+	"bytes"
+	"html/template"
+	"strconv"
+
+	"bitbucket.org/radarventures/forwarder-shipments/database/models"
+)
+
+// revisionTemplate is the "Revised quote" section of the quote PDF. It only reads the sell
+// side summary, so buy rates and margin cannot reach the customer through it.
+const revisionTemplate = `<div class="revised-quote">
+<h3>Revised quote</h3>
+<p>Changes since version {{.FromVersion}} of this quote.</p>
+<table>
+<tr><th>Charge</th><th>Currency</th><th>Previous</th><th>Revised</th></tr>
+{{- range .Added}}
+<tr><td>{{.Name}} (added)</td><td>{{.Currency}}</td><td>-</td><td>{{amount .NewAmount}}</td></tr>
+{{- end}}
+{{- range .Changed}}
+<tr><td>{{.Name}}</td><td>{{.Currency}}</td><td>{{amount .OldAmount}}</td><td>{{amount .NewAmount}}</td></tr>
+{{- end}}
+{{- range .Removed}}
+<tr><td>{{.Name}} (removed)</td><td>{{.Currency}}</td><td>{{amount .OldAmount}}</td><td>-</td></tr>
+{{- end}}
+</table>
+<p>Previous total: {{amount .OldTotal}}<br/>Revised total: {{amount .NewTotal}}<br/>Difference: {{amount .Difference}}</p>
+</div>`
+
+var revisionTmpl = template.Must(template.New("QuoteRevision").Funcs(template.FuncMap{
+	"amount": func(v float64) string { return strconv.FormatFloat(v, 'f', 2, 64) },
+}).Parse(revisionTemplate))
+
+// RenderRevision renders the summary as the "Revised quote" section of the quote PDF.
+func RenderRevision(summary *models.QuoteRevisionSummary) (string, error) {
+	buf := new(bytes.Buffer)
+	if err := revisionTmpl.Execute(buf, summary); err != nil {
+		return "", err
+	}
+
+	return buf.String(), nil
+}
@@ -0,0 +1,508 @@
+package ratepricing
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"bitbucket.org/radarventures/forwarder-adapters/utils/context"
+	"bitbucket.org/radarventures/forwarder-shipments/constants"
+	"bitbucket.org/radarventures/forwarder-shipments/database/models"
+	"github.com/google/uuid"
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+)
+
+const (
+	// MaxRateSheetRows bounds the rows of a single rate sheet import.
+	MaxRateSheetRows = 5000
+	// MaxRateSheetBytes bounds the size of an uploaded rate sheet.
+	MaxRateSheetBytes = 10 << 20
+)
+
+var (
+	ErrRateSheetFormat   = errors.New("rate sheet must be csv or xlsx")
+	ErrRateSheetEmpty    = errors.New("rate sheet has no rows")
+	ErrRateSheetColumns  = errors.New("rate sheet must have lane or template_id, charge_code, currency and amount columns")
+	ErrRateSheetTooLarge = fmt.Errorf("rate sheet must not have more than %d rows", MaxRateSheetRows)
+	ErrRateSheetTooBig   = fmt.Errorf("rate sheet must not be larger than %d MB", MaxRateSheetBytes>>20)
+	ErrRegionRequired    = errors.New("region is required")
+)
+
+const (
+	rejectionUnknownLane      = "no rate template of the region has this lane"
+	rejectionAmbiguousLane    = "lane matches more than one rate template, use template_id"
+	rejectionUnknownTemplate  = "no rate template of the region has this id"
+	rejectionDuplicateInSheet = "charge is already set by row %d"
+	rejectionValidityDiffers  = "validity differs from row %d of the same template"
+	rejectionContainerBasis   = "container type only applies to per_container charges"
+)
+
+// rateSheetRow is a validated row of a rate sheet.
+type rateSheetRow struct {
+	line          int
+	lane          string
+	templateId    uuid.UUID
+	code          string
+	name          string
+	containerType string
+	basis         string
+	currency      string
+	amount        float64
+	sellAmount    *float64
+	minAmount     *float64
+	effectiveFrom *time.Time
+	effectiveTo   *time.Time
+}
+
+// ImportRateSheet diffs the rows of a CSV or XLSX rate sheet against the pricing of the
+// region's rate templates. Each row sets one charge of the template of its lane: amount is the
+// buy rate, sell_amount and min_amount optionally set the sell rate and minimum buy charge.
+// Charges the sheet does not mention are kept. Unless it is a dry run, a sheet without row
+// errors is applied in one transaction; a sheet with any row error is not applied at all.
+func (s *RatePricingService) ImportRateSheet(ctx *context.Context, data []byte, format string, regionId string, dryRun bool) (*models.RateSheetImportResult, error) {
+	if regionId == "" {
+		return nil, ErrRegionRequired
+	}
+
+	var sheet []*sheetRow
+	var err error
+	switch strings.ToLower(format) {
+	case constants.RateSheetFormatCSV:
+		sheet, err = readCSV(data)
+	case constants.RateSheetFormatXLSX:
+		sheet, err = readXLSX(data)
+	default:
+		return nil, ErrRateSheetFormat
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	result := &models.RateSheetImportResult{
+		DryRun:    dryRun,
+		Templates: []*models.RateSheetTemplateDiff{},
+		Errors:    []*models.RateSheetRowError{},
+	}
+
+	rows, err := parseRateSheet(sheet, result)
+	if err != nil {
+		return nil, err
+	}
+
+	err = ctx.DB.WithContext(ctx.Request.Context()).Transaction(func(tx *gorm.DB) error {
+		err := tx.Exec("SELECT pg_advisory_xact_lock(hashtext(?))", "rate_template_pricing:"+regionId).Error
+		if err != nil {
+			ctx.Log.Error("Failed to acquire rate sheet import lock.", zap.Error(err))
+			return err
+		}
+
+		lanes, ids := []string{}, []uuid.UUID{}
+		for _, row := range rows {
+			if row.templateId != uuid.Nil {
+				ids = append(ids, row.templateId)
+			} else {
+				lanes = append(lanes, row.lane)
+			}
+		}
+
+		pricings, err := s.templateDb.GetPricingsWithTx(ctx, tx, regionId, lanes, ids)
+		if err != nil {
+			return err
+		}
+
+		changed := diffRateSheet(rows, pricings, result)
+		if dryRun || len(result.Errors) > 0 {
+			return nil
+		}
+
+		now := time.Now().UTC()
+		for _, pricing := range changed {
+			if err := validate(pricing); err != nil {
+				return err
+			}
+
+			pricing.UpdatedAt = now
+			if ctx.Account != nil {
+				pricing.UpdatedBy = ctx.Account.ID
+			}
+			for i, charge := range pricing.Charges {
+				charge.Position = i
+			}
+
+			if err := s.templateDb.SavePricingWithTx(ctx, tx, pricing); err != nil {
+				return err
+			}
+		}
+
+		result.Applied = true
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	ctx.Log.Info("imported rate sheet", zap.String("region_id", regionId), zap.Bool("dry_run", dryRun),
+		zap.Bool("applied", result.Applied), zap.Int("rows", result.Rows), zap.Int("errors", len(result.Errors)))
+
+	return result, nil
+}
+
+// parseRateSheet validates the rows of a sheet against the template schema. Rows that fail
+// are added to the errors of the result.
+func parseRateSheet(sheet []*sheetRow, result *models.RateSheetImportResult) ([]*rateSheetRow, error) {
+	if len(sheet) < 2 {
+		return nil, ErrRateSheetEmpty
+	}
+
+	columns := map[string]int{}
+	for i, name := range sheet[0].cells {
+		columns[strings.ReplaceAll(strings.ToLower(strings.TrimSpace(name)), " ", "_")] = i
+	}
+
+	_, hasLane := columns["lane"]
+	_, hasTemplate := columns["template_id"]
+	for _, name := range []string{"charge_code", "currency", "amount"} {
+		if _, ok := columns[name]; !ok {
+			return nil, ErrRateSheetColumns
+		}
+	}
+	if !hasLane && !hasTemplate {
+		return nil, ErrRateSheetColumns
+	}
+
+	column := func(record []string, name string) string {
+		i, ok := columns[name]
+		if !ok || i >= len(record) {
+			return ""
+		}
+		return strings.TrimSpace(record[i])
+	}
+
+	rows := make([]*rateSheetRow, 0, len(sheet)-1)
+	for _, record := range sheet[1:] {
+		if isBlank(record.cells) {
+			continue
+		}
+
+		result.Rows++
+		if result.Rows > MaxRateSheetRows {
+			return nil, ErrRateSheetTooLarge
+		}
+
+		row := &rateSheetRow{
+			line:          record.line,
+			lane:          strings.ToUpper(column(record.cells, "lane")),
+			code:          strings.ToUpper(column(record.cells, "charge_code")),
+			name:          column(record.cells, "charge_name"),
+			containerType: strings.ToUpper(column(record.cells, "container_type")),
+			basis:         strings.ToLower(column(record.cells, "basis")),
+			currency:      strings.ToUpper(column(record.cells, "currency")),
+		}
+
+		reject := func(reason string) {
+			result.Errors = append(result.Errors, &models.RateSheetRowError{Row: row.line, Lane: row.lane, ChargeCode: row.code, Reason: reason})
+		}
+
+		if err := parseRateSheetRow(row, column, record.cells); err != nil {
+			reject(err.Error())
+			continue
+		}
+
+		rows = append(rows, row)
+	}
+
+	if result.Rows == 0 {
+		return nil, ErrRateSheetEmpty
+	}
+
+	return rows, nil
+}
+
+func parseRateSheetRow(row *rateSheetRow, column func([]string, string) string, cells []string) error {
+	var err error
+	if raw := column(cells, "template_id"); raw != "" {
+		row.templateId, err = uuid.Parse(raw)
+		if err != nil {
+			return errors.New("template_id is not a valid id")
+		}
+	} else if row.lane == "" {
+		return errors.New("lane or template_id is required")
+	}
+
+	if row.code == "" {
+		return errors.New("charge_code is required")
+	}
+	if len(row.currency) != 3 {
+		return errors.New("currency must be a 3 letter code")
+	}
+
+	if row.basis == "" {
+		row.basis = constants.RateBasisShipment
+		if row.containerType != "" {
+			row.basis = constants.RateBasisContainer
+		}
+	}
+	if !bases[row.basis] {
+		return ErrInvalidBasis
+	}
+	if row.containerType != "" && row.basis != constants.RateBasisContainer {
+		return errors.New(rejectionContainerBasis)
+	}
+
+	row.amount, err = parseAmount(column(cells, "amount"))
+	if err != nil {
+		return fmt.Errorf("amount %w", err)
+	}
+	if raw := column(cells, "sell_amount"); raw != "" {
+		v, err := parseAmount(raw)
+		if err != nil {
+			return fmt.Errorf("sell_amount %w", err)
+		}
+		row.sellAmount = &v
+	}
+	if raw := column(cells, "min_amount"); raw != "" {
+		v, err := parseAmount(raw)
+		if err != nil {
+			return fmt.Errorf("min_amount %w", err)
+		}
+		row.minAmount = &v
+	}
+
+	if row.effectiveFrom, err = parseSheetDate(column(cells, "effective_from")); err != nil {
+		return fmt.Errorf("effective_from %w", err)
+	}
+	if row.effectiveTo, err = parseSheetDate(column(cells, "effective_to")); err != nil {
+		return fmt.Errorf("effective_to %w", err)
+	}
+	if row.effectiveFrom != nil && row.effectiveTo != nil && row.effectiveTo.Before(*row.effectiveFrom) {
+		return ErrInvalidValidity
+	}
+
+	return nil
+}
+
+// diffRateSheet resolves the rows to templates and records how they change each template in
+// the result. It returns the pricing of the templates the sheet changes, with the rows applied.
+func diffRateSheet(rows []*rateSheetRow, pricings []*models.RateTemplatePricing, result *models.RateSheetImportResult) []*models.RateTemplatePricing {
+	byId := map[uuid.UUID]*models.RateTemplatePricing{}
+	byLane := map[string][]*models.RateTemplatePricing{}
+	for _, p := range pricings {
+		byId[p.TemplateId] = p
+		if p.Lane != "" {
+			byLane[p.Lane] = append(byLane[p.Lane], p)
+		}
+	}
+
+	diffs := map[uuid.UUID]*models.RateSheetTemplateDiff{}
+	firstRow := map[uuid.UUID]*rateSheetRow{}
+	seen := map[string]int{}
+	changed := make([]*models.RateTemplatePricing, 0)
+
+	for _, row := range rows {
+		reject := func(reason string) {
+			result.Errors = append(result.Errors, &models.RateSheetRowError{Row: row.line, Lane: row.lane, ChargeCode: row.code, Reason: reason})
+		}
+
+		var pricing *models.RateTemplatePricing
+		if row.templateId != uuid.Nil {
+			if pricing = byId[row.templateId]; pricing == nil {
+				reject(rejectionUnknownTemplate)
+				continue
+			}
+		} else {
+			switch matches := byLane[row.lane]; len(matches) {
+			case 0:
+				reject(rejectionUnknownLane)
+				continue
+			case 1:
+				pricing = matches[0]
+			default:
+				reject(rejectionAmbiguousLane)
+				continue
+			}
+		}
+
+		key := pricing.TemplateId.String() + "|" + row.code + "|" + row.containerType
+		if line, ok := seen[key]; ok {
+			reject(fmt.Sprintf(rejectionDuplicateInSheet, line))
+			continue
+		}
+		seen[key] = row.line
+
+		diff, ok := diffs[pricing.TemplateId]
+		if !ok {
+			diff = &models.RateSheetTemplateDiff{
+				TemplateId:       pricing.TemplateId,
+				Lane:             pricing.Lane,
+				OldEffectiveFrom: pricing.EffectiveFrom,
+				OldEffectiveTo:   pricing.EffectiveTo,
+				EffectiveFrom:    pricing.EffectiveFrom,
+				EffectiveTo:      pricing.EffectiveTo,
+				Charges:          []*models.RateSheetChargeDiff{},
+			}
+			if row.effectiveFrom != nil || row.effectiveTo != nil {
+				diff.EffectiveFrom, diff.EffectiveTo = row.effectiveFrom, row.effectiveTo
+			}
+			if diff.Lane == "" {
+				diff.Lane = row.lane
+			}
+			diffs[pricing.TemplateId] = diff
+			firstRow[pricing.TemplateId] = row
+			result.Templates = append(result.Templates, diff)
+			changed = append(changed, pricing)
+		} else if first := firstRow[pricing.TemplateId]; !sameDate(first.effectiveFrom, row.effectiveFrom) || !sameDate(first.effectiveTo, row.effectiveTo) {
+			reject(fmt.Sprintf(rejectionValidityDiffers, first.line))
+			continue
+		}
+
+		diff.Charges = append(diff.Charges, applyRow(pricing, row))
+	}
+
+	for _, p := range changed {
+		diff := diffs[p.TemplateId]
+		p.Lane = diff.Lane
+		p.EffectiveFrom, p.EffectiveTo = diff.EffectiveFrom, diff.EffectiveTo
+		if p.Currency == "" {
+			p.Currency = firstRow[p.TemplateId].currency
+		}
+
+		for _, c := range diff.Charges {
+			switch c.Action {
+			case constants.RateSheetAdded:
+				result.Added++
+			case constants.RateSheetUpdated:
+				result.Updated++
+			default:
+				result.Unchanged++
+			}
+		}
+	}
+
+	sort.SliceStable(result.Errors, func(i, j int) bool {
+		return result.Errors[i].Row < result.Errors[j].Row
+	})
+
+	return changed
+}
+
+// applyRow sets the charge of a row on the pricing, adding it when the template does not have
+// it yet, and returns the change. Slabs and the minimum sell charge of a charge are kept.
+func applyRow(pricing *models.RateTemplatePricing, row *rateSheetRow) *models.RateSheetChargeDiff {
+	diff := &models.RateSheetChargeDiff{
+		Row:           row.line,
+		ChargeCode:    row.code,
+		ContainerType: row.containerType,
+	}
+
+	var charge *models.RateTemplateCharge
+	for _, c := range pricing.Charges {
+		if strings.EqualFold(c.Code, row.code) && strings.EqualFold(c.ContainerType, row.containerType) {
+			charge = c
+			break
+		}
+	}
+
+	if charge == nil {
+		charge = &models.RateTemplateCharge{
+			Id:            uuid.New(),
+			TemplateId:    pricing.TemplateId,
+			Code:          row.code,
+			Name:          row.name,
+			ContainerType: row.containerType,
+		}
+		if charge.Name == "" {
+			charge.Name = row.code
+		}
+		pricing.Charges = append(pricing.Charges, charge)
+		diff.Action = constants.RateSheetAdded
+	} else {
+		diff.Old = chargeValues(charge)
+		if row.name != "" {
+			charge.Name = row.name
+		}
+	}
+
+	charge.Basis = row.basis
+	charge.Currency = row.currency
+	charge.BuyRate = row.amount
+	if row.sellAmount != nil {
+		charge.SellRate = *row.sellAmount
+	}
+	if row.minAmount != nil {
+		charge.MinBuy = *row.minAmount
+	}
+
+	diff.New = chargeValues(charge)
+	if diff.Old != nil {
+		diff.Action = constants.RateSheetUnchanged
+		if *diff.Old != *diff.New {
+			diff.Action = constants.RateSheetUpdated
+		}
+	}
+
+	return diff
+}
+
+func chargeValues(c *models.RateTemplateCharge) *models.RateSheetValues {
+	return &models.RateSheetValues{
+		Basis:    c.Basis,
+		Currency: c.Currency,
+		BuyRate:  c.BuyRate,
+		SellRate: c.SellRate,
+		MinBuy:   c.MinBuy,
+	}
+}
+
+func parseAmount(raw string) (float64, error) {
+	v, err := strconv.ParseFloat(strings.ReplaceAll(raw, ",", ""), 64)
+	if err != nil || math.IsNaN(v) || math.IsInf(v, 0) {
+		return 0, errors.New("must be a number")
+	}
+	if v < 0 {
+		return 0, errors.New("must not be negative")
+	}
+
+	return v, nil
+}
+
+// parseSheetDate reads a date as YYYY-MM-DD, DD/MM/YYYY or the serial number spreadsheets
+// store dates as.
+func parseSheetDate(raw string) (*time.Time, error) {
+	if raw == "" {
+		return nil, nil
+	}
+
+	for _, layout := range []string{time.DateOnly, "02/01/2006"} {
+		if t, err := time.Parse(layout, raw); err == nil {
+			return &t, nil
+		}
+	}
+
+	if serial, err := strconv.ParseFloat(raw, 64); err == nil && serial > 0 {
+		t := time.Date(1899, 12, 30, 0, 0, 0, 0, time.UTC).AddDate(0, 0, int(serial))
+		return &t, nil
+	}
+
+	return nil, errors.New("must be a date as YYYY-MM-DD or DD/MM/YYYY")
+}
+
+func sameDate(a, b *time.Time) bool {
+	if a == nil || b == nil {
+		return a == nil && b == nil
+	}
+	return a.Equal(*b)
+}
+
+func isBlank(cells []string) bool {
+	for _, c := range cells {
+		if strings.TrimSpace(c) != "" {
+			return false
+		}
+	}
+	return true
+}
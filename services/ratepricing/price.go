@@ -0,0 +1,178 @@
+package ratepricing
+
+import (
+	"math"
+	"sort"
+
+	"bitbucket.org/radarventures/forwarder-shipments/constants"
+	"bitbucket.org/radarventures/forwarder-shipments/database/models"
+)
+
+// measure fills the chargeable weight and weight/measure of an RFQ. Air cargo is charged by
+// the greater of its gross and volumetric weight, LCL by the greater of its CBM and tonnes.
+func measure(basis *models.RfqPricingBasis) {
+	basis.ChargeableWeight = basis.GrossWeight
+	if basis.Type == constants.ShipmentTypeAIR {
+		basis.ChargeableWeight = math.Max(basis.GrossWeight, round(basis.Cbm*constants.AirVolumetricKgPerCBM))
+	}
+	basis.WeightMeasure = math.Max(basis.Cbm, basis.GrossWeight/1000)
+}
+
+// quantity returns how many units of its basis a charge applies to for the RFQ. A charge
+// that does not apply, like one for a container type the RFQ has none of, has quantity 0.
+func quantity(charge *models.RateTemplateCharge, basis *models.RfqPricingBasis) float64 {
+	switch charge.Basis {
+	case constants.RateBasisShipment:
+		return 1
+	case constants.RateBasisContainer:
+		if charge.ContainerType != "" {
+			return basis.Containers[charge.ContainerType]
+		}
+		total := 0.0
+		for _, count := range basis.Containers {
+			total += count
+		}
+		return total
+	case constants.RateBasisKg:
+		return basis.ChargeableWeight
+	case constants.RateBasisCBM:
+		return basis.Cbm
+	case constants.RateBasisWM:
+		return basis.WeightMeasure
+	case constants.RateBasisPackage:
+		return basis.Packages
+	}
+
+	return 0
+}
+
+// rates returns the buy and sell rate of the slab the quantity falls in, or the flat rates
+// when no slab matches.
+func rates(charge *models.RateTemplateCharge, qty float64) (float64, float64) {
+	for _, slab := range charge.Slabs {
+		if qty >= slab.From && (slab.To == 0 || qty < slab.To) {
+			return slab.BuyRate, slab.SellRate
+		}
+	}
+
+	return charge.BuyRate, charge.SellRate
+}
+
+// lineItem prices one side of a charge. It returns nil when the template does not price
+// that side.
+func lineItem(charge *models.RateTemplateCharge, side string, qty, rate, min float64, currency string) *models.PricedLineItem {
+	if rate == 0 && min == 0 {
+		return nil
+	}
+
+	item := &models.PricedLineItem{
+		ChargeId:      charge.Id,
+		Code:          charge.Code,
+		Name:          charge.Name,
+		Type:          side,
+		Basis:         charge.Basis,
+		ContainerType: charge.ContainerType,
+		Quantity:      qty,
+		Rate:          rate,
+		Amount:        round(qty * rate),
+		Currency:      currency,
+	}
+	if item.Amount < min {
+		item.Amount = min
+		item.MinApplied = true
+	}
+
+	return item
+}
+
+// price computes the buy and sell line items of the charges for the RFQ.
+func price(pricing *models.RateTemplatePricing, basis *models.RfqPricingBasis) *models.RfqPricing {
+	res := &models.RfqPricing{
+		RfqId:      basis.Id,
+		TemplateId: pricing.TemplateId,
+		Basis:      basis,
+		Buy:        []*models.PricedLineItem{},
+		Sell:       []*models.PricedLineItem{},
+		BuyTotal:   map[string]float64{},
+		SellTotal:  map[string]float64{},
+	}
+
+	for _, charge := range pricing.Charges {
+		qty := quantity(charge, basis)
+		if qty <= 0 {
+			continue
+		}
+
+		currency := charge.Currency
+		if currency == "" {
+			currency = pricing.Currency
+		}
+
+		buyRate, sellRate := rates(charge, qty)
+		if item := lineItem(charge, constants.LineItemBuy, qty, buyRate, charge.MinBuy, currency); item != nil {
+			res.Buy = append(res.Buy, item)
+			res.BuyTotal[currency] = round(res.BuyTotal[currency] + item.Amount)
+		}
+		if item := lineItem(charge, constants.LineItemSell, qty, sellRate, charge.MinSell, currency); item != nil {
+			res.Sell = append(res.Sell, item)
+			res.SellTotal[currency] = round(res.SellTotal[currency] + item.Amount)
+		}
+	}
+
+	return res
+}
+
+// covers reports whether the charges price every container type the RFQ asks for.
+func covers(charges []*models.RateTemplateCharge, basis *models.RfqPricingBasis) bool {
+	priced := map[string]bool{}
+	for _, charge := range charges {
+		if charge.Basis == constants.RateBasisContainer {
+			priced[charge.ContainerType] = true
+		}
+	}
+
+	if len(priced) == 0 || priced[""] {
+		return true
+	}
+	for containerType := range basis.Containers {
+		if !priced[containerType] {
+			return false
+		}
+	}
+
+	return true
+}
+
+type candidate struct {
+	*models.RateTemplateCandidate
+	pricing *models.RateTemplatePricing
+	covers  bool
+}
+
+// best picks the template to price with: one made for the customer over a generic one, one
+// that prices every container type of the RFQ, then the most recently effective.
+func best(candidates []*candidate) *candidate {
+	if len(candidates) == 0 {
+		return nil
+	}
+
+	sort.SliceStable(candidates, func(i, j int) bool {
+		a, b := candidates[i], candidates[j]
+		if a.CompanySpecific != b.CompanySpecific {
+			return a.CompanySpecific
+		}
+		if a.covers != b.covers {
+			return a.covers
+		}
+		if a.EffectiveFrom == nil || b.EffectiveFrom == nil {
+			return a.EffectiveFrom != nil && b.EffectiveFrom == nil
+		}
+		return a.EffectiveFrom.After(*b.EffectiveFrom)
+	})
+
+	return candidates[0]
+}
+
+func round(v float64) float64 {
+	return math.Round(v*100) / 100
+}
@@ -0,0 +1,227 @@
+package ratepricing
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"bitbucket.org/radarventures/forwarder-adapters/utils/context"
+	"bitbucket.org/radarventures/forwarder-shipments/constants"
+	ratetemplate "bitbucket.org/radarventures/forwarder-shipments/daos/rate-template"
+	"bitbucket.org/radarventures/forwarder-shipments/daos/rfq"
+	"bitbucket.org/radarventures/forwarder-shipments/daos/rfqcontainer"
+	"bitbucket.org/radarventures/forwarder-shipments/daos/rfqproduct"
+	"bitbucket.org/radarventures/forwarder-shipments/database/models"
+	"github.com/google/uuid"
+	"go.uber.org/zap"
+)
+
+var (
+	ErrCurrencyRequired   = errors.New("currency is required")
+	ErrInvalidValidity    = errors.New("effective to must not be before effective from")
+	ErrInvalidBasis       = errors.New("basis must be per_shipment, per_container, per_kg, per_cbm, per_wm or per_package")
+	ErrInvalidRate        = errors.New("rates and minimum charges must not be negative")
+	ErrInvalidSlabs       = errors.New("slabs must be ordered, not overlap and only the last may be open")
+	ErrNoMatchingTemplate = errors.New("no rate template applies to the rfq")
+)
+
+var bases = map[string]bool{
+	constants.RateBasisShipment:  true,
+	constants.RateBasisContainer: true,
+	constants.RateBasisKg:        true,
+	constants.RateBasisCBM:       true,
+	constants.RateBasisWM:        true,
+	constants.RateBasisPackage:   true,
+}
+
+type IRatePricingService interface {
+	SavePricing(ctx *context.Context, templateId uuid.UUID, req *models.RateTemplatePricing) (*models.RateTemplatePricing, error)
+	GetPricing(ctx *context.Context, templateId uuid.UUID) (*models.RateTemplatePricing, error)
+	PriceRfq(ctx *context.Context, rfqId string, on time.Time) (*models.RfqPricing, error)
+	ImportRateSheet(ctx *context.Context, data []byte, format string, regionId string, dryRun bool) (*models.RateSheetImportResult, error)
+}
+
+type RatePricingService struct {
+	templateDb  ratetemplate.IRateTemplate
+	rfqDb       rfq.IRfq
+	containerDb rfqcontainer.IRfqContainer
+	productDb   rfqproduct.IRfqProduct
+}
+
+func NewRatePricingService() IRatePricingService {
+	return &RatePricingService{
+		templateDb:  ratetemplate.NewRateTemplate(),
+		rfqDb:       rfq.NewRfq(),
+		containerDb: rfqcontainer.NewRfqContainer(),
+		productDb:   rfqproduct.NewRfqProduct(),
+	}
+}
+
+// SavePricing replaces the validity window, slabs and minimum charges of a rate template.
+func (s *RatePricingService) SavePricing(ctx *context.Context, templateId uuid.UUID, req *models.RateTemplatePricing) (*models.RateTemplatePricing, error) {
+	if _, err := s.templateDb.Get(ctx, templateId.String(), ""); err != nil {
+		return nil, err
+	}
+
+	if err := validate(req); err != nil {
+		return nil, err
+	}
+
+	now := time.Now().UTC()
+	req.TemplateId = templateId
+	req.Currency = strings.ToUpper(req.Currency)
+	req.Lane = strings.ToUpper(strings.TrimSpace(req.Lane))
+	req.UpdatedAt = now
+	if ctx.Account != nil {
+		req.UpdatedBy = ctx.Account.ID
+	}
+
+	existing, err := s.templateDb.GetPricing(ctx, templateId)
+	if err == nil {
+		req.CreatedAt = existing.CreatedAt
+	} else {
+		req.CreatedAt = now
+	}
+
+	for i, charge := range req.Charges {
+		charge.Id = uuid.New()
+		charge.TemplateId = templateId
+		charge.Position = i
+		charge.Currency = strings.ToUpper(charge.Currency)
+		sort.SliceStable(charge.Slabs, func(a, b int) bool {
+			return charge.Slabs[a].From < charge.Slabs[b].From
+		})
+	}
+
+	err = s.templateDb.SavePricing(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+
+	return req, nil
+}
+
+func (s *RatePricingService) GetPricing(ctx *context.Context, templateId uuid.UUID) (*models.RateTemplatePricing, error) {
+	return s.templateDb.GetPricing(ctx, templateId)
+}
+
+// PriceRfq computes the buy and sell line items of an RFQ from the best rate template of its
+// type, region and lane that applies to the customer and is valid on the given day. The lane
+// of the RFQ is its POL and POD, an RFQ without both ports matches no template.
+func (s *RatePricingService) PriceRfq(ctx *context.Context, rfqId string, on time.Time) (*models.RfqPricing, error) {
+	basis, err := s.rfqDb.GetPricingBasis(ctx, rfqId)
+	if err != nil {
+		return nil, err
+	}
+
+	lane := Lane(basis.Pol, basis.Pod)
+	if lane == "" {
+		return nil, ErrNoMatchingTemplate
+	}
+
+	containers, err := s.containerDb.GetTypeCounts(ctx, rfqId)
+	if err != nil {
+		return nil, err
+	}
+	basis.Containers = map[string]float64{}
+	for _, c := range containers {
+		basis.Containers[c.Type] += float64(c.Count)
+	}
+
+	basis.Packages, err = s.productDb.GetPackageCount(ctx, rfqId)
+	if err != nil {
+		return nil, err
+	}
+	measure(basis)
+
+	templates, err := s.templateDb.GetCandidates(ctx, basis.Type, basis.RegionId, lane, basis.CompanyId, on)
+	if err != nil {
+		return nil, err
+	}
+	if len(templates) == 0 {
+		return nil, ErrNoMatchingTemplate
+	}
+
+	candidates := make([]*candidate, 0, len(templates))
+	for _, t := range templates {
+		charges, err := s.templateDb.GetCharges(ctx, t.TemplateId)
+		if err != nil {
+			return nil, err
+		}
+
+		candidates = append(candidates, &candidate{
+			RateTemplateCandidate: t,
+			pricing: &models.RateTemplatePricing{
+				TemplateId:    t.TemplateId,
+				EffectiveFrom: t.EffectiveFrom,
+				EffectiveTo:   t.EffectiveTo,
+				Currency:      t.Currency,
+				Charges:       charges,
+			},
+			covers: covers(charges, basis),
+		})
+	}
+
+	match := best(candidates)
+	if match == nil {
+		return nil, ErrNoMatchingTemplate
+	}
+
+	ctx.Log.Info("pricing rfq from rate template", zap.String("rfq_id", rfqId),
+		zap.Any("template_id", match.TemplateId), zap.Int("candidates", len(candidates)))
+
+	res := price(match.pricing, basis)
+	res.PricedOn = on
+
+	return res, nil
+}
+
+// Lane is the POL-POD code of a pair of ports, empty when either port is missing.
+func Lane(pol, pod string) string {
+	pol = strings.ToUpper(strings.TrimSpace(pol))
+	pod = strings.ToUpper(strings.TrimSpace(pod))
+	if pol == "" || pod == "" {
+		return ""
+	}
+
+	return pol + "-" + pod
+}
+
+func validate(req *models.RateTemplatePricing) error {
+	if strings.TrimSpace(req.Currency) == "" {
+		return ErrCurrencyRequired
+	}
+	if req.EffectiveFrom != nil && req.EffectiveTo != nil && req.EffectiveTo.Before(*req.EffectiveFrom) {
+		return ErrInvalidValidity
+	}
+
+	for _, charge := range req.Charges {
+		if !bases[charge.Basis] {
+			return fmt.Errorf("%w: %s", ErrInvalidBasis, charge.Code)
+		}
+		if charge.BuyRate < 0 || charge.SellRate < 0 || charge.MinBuy < 0 || charge.MinSell < 0 {
+			return fmt.Errorf("%w: %s", ErrInvalidRate, charge.Code)
+		}
+
+		slabs := append(models.RateSlabs{}, charge.Slabs...)
+		sort.SliceStable(slabs, func(a, b int) bool {
+			return slabs[a].From < slabs[b].From
+		})
+		for i, slab := range slabs {
+			if slab.BuyRate < 0 || slab.SellRate < 0 {
+				return fmt.Errorf("%w: %s", ErrInvalidRate, charge.Code)
+			}
+			last := i == len(slabs)-1
+			if slab.From < 0 || (slab.To == 0 && !last) || (slab.To != 0 && slab.To <= slab.From) {
+				return fmt.Errorf("%w: %s", ErrInvalidSlabs, charge.Code)
+			}
+			if !last && slabs[i+1].From < slab.To {
+				return fmt.Errorf("%w: %s", ErrInvalidSlabs, charge.Code)
+			}
+		}
+	}
+
+	return nil
+}
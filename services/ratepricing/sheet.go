@@ -0,0 +1,211 @@
+package ratepricing
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/csv"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"path"
+	"strconv"
+	"strings"
+)
+
+// maxWorkbookPartBytes bounds the uncompressed size of a part of a workbook, so a small upload
+// cannot expand into a sheet far past MaxRateSheetRows before its rows are counted.
+const maxWorkbookPartBytes = 50 << 20
+
+var (
+	ErrInvalidWorkbook  = errors.New("xlsx file has no readable worksheet")
+	ErrWorkbookTooLarge = fmt.Errorf("xlsx file must not be larger than %d MB uncompressed", maxWorkbookPartBytes>>20)
+)
+
+// sheetRow is a row of a rate sheet with its row number in the file.
+type sheetRow struct {
+	line  int
+	cells []string
+}
+
+func readCSV(data []byte) ([]*sheetRow, error) {
+	reader := csv.NewReader(bytes.NewReader(data))
+	reader.FieldsPerRecord = -1
+	reader.TrimLeadingSpace = true
+
+	rows := make([]*sheetRow, 0)
+	for {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		line, _ := reader.FieldPos(0)
+		rows = append(rows, &sheetRow{line: line, cells: record})
+	}
+
+	return rows, nil
+}
+
+type xlsxText struct {
+	T string `xml:"t"`
+	R []struct {
+		T string `xml:"t"`
+	} `xml:"r"`
+}
+
+func (t xlsxText) String() string {
+	if len(t.R) == 0 {
+		return t.T
+	}
+
+	var b strings.Builder
+	for _, r := range t.R {
+		b.WriteString(r.T)
+	}
+	return b.String()
+}
+
+type xlsxSharedStrings struct {
+	Items []xlsxText `xml:"si"`
+}
+
+type xlsxWorkbookSheets struct {
+	Sheets []struct {
+		RId string `xml:"http://schemas.openxmlformats.org/officeDocument/2006/relationships id,attr"`
+	} `xml:"sheets>sheet"`
+}
+
+type xlsxRelationships struct {
+	Items []struct {
+		Id     string `xml:"Id,attr"`
+		Target string `xml:"Target,attr"`
+	} `xml:"Relationship"`
+}
+
+type xlsxWorksheet struct {
+	Rows []struct {
+		R     int `xml:"r,attr"`
+		Cells []struct {
+			Ref  string   `xml:"r,attr"`
+			Type string   `xml:"t,attr"`
+			V    string   `xml:"v"`
+			Is   xlsxText `xml:"is"`
+		} `xml:"c"`
+	} `xml:"sheetData>row"`
+}
+
+// readXLSX reads the first worksheet of a workbook. Dates come back as the serial numbers
+// spreadsheets store them as.
+func readXLSX(data []byte) ([]*sheetRow, error) {
+	zr, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		return nil, ErrInvalidWorkbook
+	}
+
+	files := map[string]*zip.File{}
+	for _, f := range zr.File {
+		files[f.Name] = f
+	}
+
+	decode := func(name string, v interface{}) (bool, error) {
+		f, ok := files[name]
+		if !ok {
+			return false, nil
+		}
+		// The zip reader fails a part that inflates past its declared size
+		if f.UncompressedSize64 > maxWorkbookPartBytes {
+			return false, ErrWorkbookTooLarge
+		}
+		rc, err := f.Open()
+		if err != nil {
+			return false, err
+		}
+		defer rc.Close()
+		return true, xml.NewDecoder(rc).Decode(v)
+	}
+
+	sheetName := "xl/worksheets/sheet1.xml"
+	workbook := &xlsxWorkbookSheets{}
+	rels := &xlsxRelationships{}
+	if ok, err := decode("xl/workbook.xml", workbook); err != nil {
+		return nil, err
+	} else if ok && len(workbook.Sheets) > 0 {
+		if _, err := decode("xl/_rels/workbook.xml.rels", rels); err != nil {
+			return nil, err
+		}
+		for _, rel := range rels.Items {
+			if rel.Id != workbook.Sheets[0].RId {
+				continue
+			}
+			if strings.HasPrefix(rel.Target, "/") {
+				sheetName = strings.TrimPrefix(rel.Target, "/")
+			} else {
+				sheetName = path.Join("xl", rel.Target)
+			}
+		}
+	}
+
+	shared := &xlsxSharedStrings{}
+	if _, err := decode("xl/sharedStrings.xml", shared); err != nil {
+		return nil, err
+	}
+
+	sheet := &xlsxWorksheet{}
+	ok, err := decode(sheetName, sheet)
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		return nil, ErrInvalidWorkbook
+	}
+
+	rows := make([]*sheetRow, 0, len(sheet.Rows))
+	for i, r := range sheet.Rows {
+		row := &sheetRow{line: r.R}
+		if row.line == 0 {
+			row.line = i + 1
+		}
+
+		for j, c := range r.Cells {
+			col := j
+			if c.Ref != "" {
+				col = xlsxColumnIndex(c.Ref)
+			}
+			for len(row.cells) <= col {
+				row.cells = append(row.cells, "")
+			}
+
+			switch c.Type {
+			case "s":
+				n, err := strconv.Atoi(c.V)
+				if err == nil && n >= 0 && n < len(shared.Items) {
+					row.cells[col] = shared.Items[n].String()
+				}
+			case "inlineStr":
+				row.cells[col] = c.Is.String()
+			default:
+				row.cells[col] = c.V
+			}
+		}
+		rows = append(rows, row)
+	}
+
+	return rows, nil
+}
+
+// xlsxColumnIndex returns the column of a cell reference, 0 for A1.
+func xlsxColumnIndex(ref string) int {
+	n := 0
+	for _, r := range ref {
+		if r < 'A' || r > 'Z' {
+			break
+		}
+		n = n*26 + int(r-'A'+1)
+	}
+
+	return n - 1
+}
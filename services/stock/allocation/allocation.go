@@ -0,0 +1,292 @@
+package allocation
+
+import (
+	"errors"
+	"time"
+
+	"bitbucket.org/radarventures/forwarder-adapters/utils/context"
+	"bitbucket.org/radarventures/forwarder-shipments/constants"
+	"bitbucket.org/radarventures/forwarder-shipments/daos/stock"
+	"bitbucket.org/radarventures/forwarder-shipments/database/models"
+	"github.com/google/uuid"
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+)
+
+const (
+	DefaultReservationTTL = 30 * time.Minute
+	MaxReservationTTL     = 24 * time.Hour
+)
+
+var (
+	ErrShipmentRequired    = errors.New("shipment id is required")
+	ErrAirlinePortRequired = errors.New("airline and port are required")
+	ErrStockNoRequired     = errors.New("stock number is required")
+	ErrStockExhausted      = errors.New("no stock number available for the airline and port")
+	ErrReservationNotFound = errors.New("no stock number is reserved for the shipment")
+	ErrStockNotVoidable    = errors.New("only available or reserved stock numbers can be voided")
+)
+
+type IAllocationService interface {
+	Reserve(ctx *context.Context, req *models.ReserveStockReq) (*models.StockReservation, error)
+	Confirm(ctx *context.Context, req *models.StockAllocationReq) (*models.StockReservation, error)
+	Release(ctx *context.Context, req *models.StockAllocationReq) error
+	Void(ctx *context.Context, req *models.StockAllocationReq) error
+	ReleaseExpired(ctx *context.Context, reservation *models.StockReservation) (bool, error)
+	GetNumberHistory(ctx *context.Context, stockNo string) (*models.StockNumberHistory, error)
+}
+
+type AllocationService struct {
+	stockDb stock.IStock
+}
+
+func NewAllocationService() IAllocationService {
+	return &AllocationService{
+		stockDb: stock.NewStock(),
+	}
+}
+
+// Reserve holds the next available number for the shipment until the TTL runs out. A shipment
+// that already holds a number keeps it, and a pending reservation is extended by the TTL.
+// Reservations of the same shipment are serialised, so concurrent calls end up with one number.
+func (s *AllocationService) Reserve(ctx *context.Context, req *models.ReserveStockReq) (*models.StockReservation, error) {
+	if req.ShipmentId == uuid.Nil {
+		return nil, ErrShipmentRequired
+	}
+
+	if req.AirLine == "" || req.PortId == "" {
+		return nil, ErrAirlinePortRequired
+	}
+
+	ttl := time.Duration(req.TTLMinutes) * time.Minute
+	if ttl <= 0 {
+		ttl = DefaultReservationTTL
+	}
+	if ttl > MaxReservationTTL {
+		ttl = MaxReservationTTL
+	}
+	reservedUntil := time.Now().UTC().Add(ttl)
+
+	var reservation *models.StockReservation
+	err := ctx.DB.WithContext(ctx.Request.Context()).Transaction(func(tx *gorm.DB) error {
+		err := s.stockDb.LockShipmentWithTx(ctx, tx, req.ShipmentId)
+		if err != nil {
+			return err
+		}
+
+		existing, err := s.stockDb.GetReservationWithTx(ctx, tx, req.ShipmentId, "")
+		if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+			return err
+		}
+
+		if existing != nil {
+			reservation = existing
+			if existing.Status != constants.StockStatusReserved {
+				return nil
+			}
+
+			_, err = s.stockDb.UpdateStatusWithTx(ctx, tx, existing.Id, constants.StockStatusReserved, constants.StockStatusReserved, existing.ShipmentId, &reservedUntil)
+			if err != nil {
+				return err
+			}
+			reservation.ReservedUntil = &reservedUntil
+			return nil
+		}
+
+		reservation, err = s.stockDb.ReserveNextWithTx(ctx, tx, req.AirLine, req.PortId, ctx.Account.RegionID, req.ShipmentId, reservedUntil)
+		if err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				return ErrStockExhausted
+			}
+			return err
+		}
+
+		return s.stockDb.CreateHistoryWithTx(ctx, tx, s.history(ctx, reservation, constants.StockStatusAvailable, constants.StockStatusReserved, ""))
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	ctx.Log.Info("stock number reserved", zap.String("stock_no", reservation.StockNo), zap.Any("shipment_id", req.ShipmentId), zap.Timep("reserved_until", reservation.ReservedUntil))
+
+	return reservation, nil
+}
+
+// Confirm marks the number reserved for the shipment as used once its booking is confirmed.
+// Confirming an already used number is a no-op.
+func (s *AllocationService) Confirm(ctx *context.Context, req *models.StockAllocationReq) (*models.StockReservation, error) {
+	if req.ShipmentId == uuid.Nil {
+		return nil, ErrShipmentRequired
+	}
+
+	var reservation *models.StockReservation
+	err := ctx.DB.WithContext(ctx.Request.Context()).Transaction(func(tx *gorm.DB) error {
+		var err error
+		reservation, err = s.stockDb.GetReservationWithTx(ctx, tx, req.ShipmentId, req.StockNo)
+		if err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				return ErrReservationNotFound
+			}
+			return err
+		}
+
+		if reservation.Status == constants.StockStatusUsed {
+			return nil
+		}
+
+		updated, err := s.stockDb.UpdateStatusWithTx(ctx, tx, reservation.Id, constants.StockStatusReserved, constants.StockStatusUsed, reservation.ShipmentId, nil)
+		if err != nil {
+			return err
+		}
+		if !updated {
+			return ErrReservationNotFound
+		}
+
+		reservation.Status = constants.StockStatusUsed
+		reservation.ReservedUntil = nil
+
+		return s.stockDb.CreateHistoryWithTx(ctx, tx, s.history(ctx, reservation, constants.StockStatusReserved, constants.StockStatusUsed, req.Reason))
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return reservation, nil
+}
+
+// Release returns the number reserved for the shipment to the available stock, e.g. when the
+// booking is cancelled before it is confirmed.
+func (s *AllocationService) Release(ctx *context.Context, req *models.StockAllocationReq) error {
+	if req.ShipmentId == uuid.Nil {
+		return ErrShipmentRequired
+	}
+
+	return ctx.DB.WithContext(ctx.Request.Context()).Transaction(func(tx *gorm.DB) error {
+		reservation, err := s.stockDb.GetReservationWithTx(ctx, tx, req.ShipmentId, req.StockNo)
+		if err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				return ErrReservationNotFound
+			}
+			return err
+		}
+
+		if reservation.Status != constants.StockStatusReserved {
+			return ErrReservationNotFound
+		}
+
+		return s.release(ctx, tx, reservation, req.Reason)
+	})
+}
+
+// ReleaseExpired releases a reservation found expired by the cronjob. It reports false when
+// the reservation was confirmed, released or extended in the meantime.
+func (s *AllocationService) ReleaseExpired(ctx *context.Context, expired *models.StockReservation) (bool, error) {
+	if expired.ShipmentId == nil {
+		return false, nil
+	}
+
+	released := false
+	err := ctx.DB.WithContext(ctx.Request.Context()).Transaction(func(tx *gorm.DB) error {
+		reservation, err := s.stockDb.GetReservationWithTx(ctx, tx, *expired.ShipmentId, expired.StockNo)
+		if err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				return nil
+			}
+			return err
+		}
+
+		if reservation.Status != constants.StockStatusReserved || reservation.ReservedUntil == nil || reservation.ReservedUntil.After(time.Now().UTC()) {
+			return nil
+		}
+
+		released = true
+		return s.release(ctx, tx, reservation, "reservation expired")
+	})
+	if err != nil {
+		return false, err
+	}
+
+	return released, nil
+}
+
+func (s *AllocationService) release(ctx *context.Context, tx *gorm.DB, reservation *models.StockReservation, reason string) error {
+	updated, err := s.stockDb.UpdateStatusWithTx(ctx, tx, reservation.Id, constants.StockStatusReserved, constants.StockStatusAvailable, nil, nil)
+	if err != nil {
+		return err
+	}
+	if !updated {
+		return ErrReservationNotFound
+	}
+
+	return s.stockDb.CreateHistoryWithTx(ctx, tx, s.history(ctx, reservation, constants.StockStatusReserved, constants.StockStatusAvailable, reason))
+}
+
+// Void takes a number that was never used out of the stock for good, e.g. when the airline
+// cancels it.
+func (s *AllocationService) Void(ctx *context.Context, req *models.StockAllocationReq) error {
+	if req.StockNo == "" {
+		return ErrStockNoRequired
+	}
+
+	reservation, err := s.stockDb.GetReservationByNumber(ctx, req.StockNo)
+	if err != nil {
+		return err
+	}
+
+	if reservation.Status != constants.StockStatusAvailable && reservation.Status != constants.StockStatusReserved {
+		return ErrStockNotVoidable
+	}
+
+	return ctx.DB.WithContext(ctx.Request.Context()).Transaction(func(tx *gorm.DB) error {
+		updated, err := s.stockDb.UpdateStatusWithTx(ctx, tx, reservation.Id, reservation.Status, constants.StockStatusVoided, nil, nil)
+		if err != nil {
+			return err
+		}
+		if !updated {
+			return ErrStockNotVoidable
+		}
+
+		return s.stockDb.CreateHistoryWithTx(ctx, tx, s.history(ctx, reservation, reservation.Status, constants.StockStatusVoided, req.Reason))
+	})
+}
+
+func (s *AllocationService) GetNumberHistory(ctx *context.Context, stockNo string) (*models.StockNumberHistory, error) {
+	if stockNo == "" {
+		return nil, ErrStockNoRequired
+	}
+
+	reservation, err := s.stockDb.GetReservationByNumber(ctx, stockNo)
+	if err != nil {
+		return nil, err
+	}
+
+	history, err := s.stockDb.GetHistory(ctx, reservation.Id)
+	if err != nil {
+		return nil, err
+	}
+
+	return &models.StockNumberHistory{
+		Stock:   reservation,
+		History: history,
+	}, nil
+}
+
+func (s *AllocationService) history(ctx *context.Context, reservation *models.StockReservation, from, to, reason string) *models.StockStatusHistory {
+	// Cronjobs run without an account
+	createdBy := uuid.Nil
+	if ctx.Account != nil {
+		createdBy = ctx.Account.ID
+	}
+
+	return &models.StockStatusHistory{
+		Id:         uuid.New(),
+		StockId:    reservation.Id,
+		StockNo:    reservation.StockNo,
+		FromStatus: from,
+		ToStatus:   to,
+		ShipmentId: reservation.ShipmentId,
+		Reason:     reason,
+		CreatedBy:  createdBy,
+		CreatedAt:  time.Now().UTC(),
+	}
+}
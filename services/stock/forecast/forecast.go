@@ -0,0 +1,165 @@
+package forecast
+
+import (
+	"errors"
+	"math"
+	"net/mail"
+	"sort"
+	"strings"
+	"time"
+
+	"bitbucket.org/radarventures/forwarder-adapters/utils/context"
+	"bitbucket.org/radarventures/forwarder-shipments/constants"
+	"bitbucket.org/radarventures/forwarder-shipments/daos/stock"
+	"bitbucket.org/radarventures/forwarder-shipments/daos/stockalert"
+	"bitbucket.org/radarventures/forwarder-shipments/database/models"
+	"github.com/google/uuid"
+)
+
+// DefaultForecastWeeks is the window the consumption rate is measured over.
+const DefaultForecastWeeks = 4
+
+var (
+	ErrAlertAirlinePortRequired = errors.New("airline and port are required")
+	ErrInvalidMinDaysCover      = errors.New("min days of cover must be greater than zero")
+	ErrInvalidAlertEmail        = errors.New("invalid stock owner email")
+)
+
+type IForecastService interface {
+	GetForecasts(ctx *context.Context, regionId string, weeks int) ([]*models.StockForecast, error)
+	GetDaysOfCover(ctx *context.Context, regionId string) (map[string]map[string]*float64, error)
+	SaveAlertSetting(ctx *context.Context, req *models.StockAlertSetting) error
+	GetAlertSettings(ctx *context.Context, regionId string) ([]*models.StockAlertSetting, error)
+}
+
+type ForecastService struct {
+	stockDb      stock.IStock
+	stockAlertDb stockalert.IStockAlert
+}
+
+func NewForecastService() IForecastService {
+	return &ForecastService{
+		stockDb:      stock.NewStock(),
+		stockAlertDb: stockalert.NewStockAlert(),
+	}
+}
+
+// GetForecasts projects the days of cover of every airline and port of the region, or of every
+// region when regionId is empty, from the numbers used over the last weeks.
+func (s *ForecastService) GetForecasts(ctx *context.Context, regionId string, weeks int) ([]*models.StockForecast, error) {
+	if weeks <= 0 {
+		weeks = DefaultForecastWeeks
+	}
+
+	available, err := s.stockDb.GetCountsByStatus(ctx, constants.StockStatusAvailable, regionId)
+	if err != nil {
+		return nil, err
+	}
+
+	used, err := s.stockDb.GetUsedCountsSince(ctx, regionId, time.Now().UTC().AddDate(0, 0, -7*weeks))
+	if err != nil {
+		return nil, err
+	}
+
+	settings, err := s.stockAlertDb.GetAll(ctx, regionId)
+	if err != nil {
+		return nil, err
+	}
+
+	forecasts := map[string]*models.StockForecast{}
+	get := func(liner, port, region string) *models.StockForecast {
+		key := region + "|" + liner + "|" + port
+		if f, ok := forecasts[key]; ok {
+			return f
+		}
+		f := &models.StockForecast{Liner: liner, Port: port, RegionId: region}
+		forecasts[key] = f
+		return f
+	}
+
+	for _, c := range available {
+		get(c.Liner, c.Port, c.RegionId).Available = c.Count
+	}
+	for _, c := range used {
+		get(c.Liner, c.Port, c.RegionId).UsedInWindow = c.Count
+	}
+	for _, setting := range settings {
+		minDaysCover := setting.MinDaysCover
+		get(setting.Liner, setting.Port, setting.RegionId).MinDaysCover = &minDaysCover
+	}
+
+	result := make([]*models.StockForecast, 0, len(forecasts))
+	for _, f := range forecasts {
+		f.DailyRate = float64(f.UsedInWindow) / float64(7*weeks)
+		if f.DailyRate > 0 {
+			daysOfCover := math.Round(float64(f.Available)/f.DailyRate*10) / 10
+			f.DaysOfCover = &daysOfCover
+		}
+		result = append(result, f)
+	}
+
+	sort.Slice(result, func(i, j int) bool {
+		if result[i].Liner != result[j].Liner {
+			return result[i].Liner < result[j].Liner
+		}
+		return result[i].Port < result[j].Port
+	})
+
+	return result, nil
+}
+
+// GetDaysOfCover returns the days of cover of the region by airline and port, in the shape of
+// the stock counts of GetStockCountByStatusWithAirlineAndPort.
+func (s *ForecastService) GetDaysOfCover(ctx *context.Context, regionId string) (map[string]map[string]*float64, error) {
+	forecasts, err := s.GetForecasts(ctx, regionId, DefaultForecastWeeks)
+	if err != nil {
+		return nil, err
+	}
+
+	cover := map[string]map[string]*float64{}
+	for _, f := range forecasts {
+		if _, ok := cover[f.Liner]; !ok {
+			cover[f.Liner] = map[string]*float64{}
+		}
+		cover[f.Liner][f.Port] = f.DaysOfCover
+	}
+
+	return cover, nil
+}
+
+func (s *ForecastService) SaveAlertSetting(ctx *context.Context, req *models.StockAlertSetting) error {
+	if req.Liner == "" || req.Port == "" {
+		return ErrAlertAirlinePortRequired
+	}
+
+	if req.MinDaysCover <= 0 {
+		return ErrInvalidMinDaysCover
+	}
+
+	emails := make([]string, 0, len(req.OwnerEmails))
+	for _, email := range req.OwnerEmails {
+		email = strings.TrimSpace(email)
+		if email == "" {
+			continue
+		}
+		if _, err := mail.ParseAddress(email); err != nil {
+			return ErrInvalidAlertEmail
+		}
+		emails = append(emails, email)
+	}
+
+	if req.RegionId == "" {
+		req.RegionId = ctx.Account.RegionID
+	}
+	req.Id = uuid.New()
+	req.OwnerEmails = emails
+	req.LastAlertedAt = nil
+	req.UpdatedBy = ctx.Account.ID
+	req.UpdatedAt = time.Now().UTC()
+
+	return s.stockAlertDb.Upsert(ctx, req)
+}
+
+func (s *ForecastService) GetAlertSettings(ctx *context.Context, regionId string) ([]*models.StockAlertSetting, error) {
+	return s.stockAlertDb.GetAll(ctx, regionId)
+}
@@ -0,0 +1,87 @@
+package numbers
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// An air waybill number is a 3 digit airline prefix followed by a 7 digit serial and a check
+// digit, the serial modulo 7. It is written as "176-12345675".
+const (
+	awbPrefixLength = 3
+	awbSerialLength = 7
+	awbLength       = awbPrefixLength + awbSerialLength + 1
+
+	maxAWBSerial = 9999999
+)
+
+var (
+	ErrInvalidAWBFormat     = errors.New("air waybill number must be a 3 digit prefix and an 8 digit serial")
+	ErrInvalidAWBCheckDigit = errors.New("air waybill number has an invalid check digit")
+	ErrInvalidAWBPrefix     = errors.New("airline prefix must be 3 digits")
+	ErrInvalidAWBSerial     = errors.New("start serial must be at most 7 digits")
+	ErrAWBRangeOverflow     = errors.New("range runs past the last serial 9999999")
+)
+
+// CheckDigit returns the IATA modulus 7 check digit of a serial.
+func CheckDigit(serial int64) int64 {
+	return serial % 7
+}
+
+// FormatAWBNumber writes the number of the serial with its check digit.
+func FormatAWBNumber(prefix string, serial int64) string {
+	return fmt.Sprintf("%s-%07d%d", prefix, serial, CheckDigit(serial))
+}
+
+// ParseAWBNumber validates a number written with or without the hyphen and spaces and returns
+// it in the "176-12345675" form.
+func ParseAWBNumber(no string) (string, error) {
+	digits := AWBDigits(no)
+	if len(digits) != awbLength {
+		return "", ErrInvalidAWBFormat
+	}
+
+	if _, err := strconv.ParseUint(digits, 10, 64); err != nil {
+		return "", ErrInvalidAWBFormat
+	}
+
+	serial, _ := strconv.ParseInt(digits[awbPrefixLength:awbLength-1], 10, 64)
+	checkDigit, _ := strconv.ParseInt(digits[awbLength-1:], 10, 64)
+	if CheckDigit(serial) != checkDigit {
+		return "", ErrInvalidAWBCheckDigit
+	}
+
+	return FormatAWBNumber(digits[:awbPrefixLength], serial), nil
+}
+
+// AWBDigits strips the separators of a number so differently written numbers compare equal.
+func AWBDigits(no string) string {
+	return strings.NewReplacer("-", "", " ", "").Replace(strings.TrimSpace(no))
+}
+
+// GenerateAWBRange returns count consecutive numbers starting at startSerial.
+func GenerateAWBRange(prefix string, startSerial int64, count int) ([]string, error) {
+	if len(prefix) != awbPrefixLength {
+		return nil, ErrInvalidAWBPrefix
+	}
+	if _, err := strconv.ParseUint(prefix, 10, 64); err != nil {
+		return nil, ErrInvalidAWBPrefix
+	}
+
+	if startSerial < 0 || startSerial > maxAWBSerial {
+		return nil, ErrInvalidAWBSerial
+	}
+
+	if startSerial+int64(count)-1 > maxAWBSerial {
+		return nil, ErrAWBRangeOverflow
+	}
+
+	result := make([]string, 0, count)
+	for i := 0; i < count; i++ {
+		result = append(result, FormatAWBNumber(prefix, startSerial+int64(i)))
+	}
+
+	return result, nil
+}
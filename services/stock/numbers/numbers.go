@@ -0,0 +1,259 @@
+package numbers
+
+import (
+	"encoding/csv"
+	"errors"
+	"io"
+	"strings"
+	"time"
+
+	"bitbucket.org/radarventures/forwarder-adapters/utils/context"
+	"bitbucket.org/radarventures/forwarder-shipments/constants"
+	"bitbucket.org/radarventures/forwarder-shipments/daos/stock"
+	"bitbucket.org/radarventures/forwarder-shipments/database/models"
+	"github.com/google/uuid"
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+)
+
+// MaxImportCount bounds the numbers a single range or CSV import may create.
+const MaxImportCount = 5000
+
+var (
+	ErrImportAirlinePortRequired = errors.New("airline and port are required")
+	ErrInvalidImportCount        = errors.New("count must be between 1 and 5000")
+	ErrImportStockNoColumn       = errors.New("csv must have a stock_no column")
+)
+
+const (
+	rejectionDuplicate       = "already in stock"
+	rejectionDuplicateInFile = "repeated in the import"
+	rejectionMissingAirline  = "airline and port are required"
+)
+
+type INumberService interface {
+	GenerateRange(ctx *context.Context, req *models.StockRangeReq) (*models.StockImportResult, error)
+	ImportCSV(ctx *context.Context, r io.Reader, airline string, port string) (*models.StockImportResult, error)
+	CreateNumbers(ctx *context.Context, req *models.StockNumbersReq) (*models.StockImportResult, error)
+}
+
+type NumberService struct {
+	stockDb stock.IStock
+}
+
+func NewNumberService() INumberService {
+	return &NumberService{
+		stockDb: stock.NewStock(),
+	}
+}
+
+type importRow struct {
+	row     int
+	liner   string
+	port    string
+	stockNo string
+}
+
+// GenerateRange adds count consecutive numbers from the start serial to the available stock
+// of the airline and port. Numbers already in stock are rejected and the rest are created.
+func (s *NumberService) GenerateRange(ctx *context.Context, req *models.StockRangeReq) (*models.StockImportResult, error) {
+	if req.AirLine == "" || req.PortId == "" {
+		return nil, ErrImportAirlinePortRequired
+	}
+
+	if req.Count <= 0 || req.Count > MaxImportCount {
+		return nil, ErrInvalidImportCount
+	}
+
+	stockNos, err := GenerateAWBRange(req.Prefix, req.StartSerial, req.Count)
+	if err != nil {
+		return nil, err
+	}
+
+	rows := make([]*importRow, 0, len(stockNos))
+	for _, stockNo := range stockNos {
+		rows = append(rows, &importRow{liner: req.AirLine, port: req.PortId, stockNo: stockNo})
+	}
+
+	return s.create(ctx, rows, &models.StockImportResult{Created: []string{}, Rejected: []*models.StockImportRejection{}})
+}
+
+// ImportCSV adds the numbers of a CSV with a stock_no column to the available stock. Optional
+// airline_id and port_id columns override the airline and port of the import for their row.
+// Rows with an invalid or duplicate number are rejected and the rest are created.
+func (s *NumberService) ImportCSV(ctx *context.Context, r io.Reader, airline string, port string) (*models.StockImportResult, error) {
+	reader := csv.NewReader(r)
+	reader.FieldsPerRecord = -1
+	reader.TrimLeadingSpace = true
+
+	header, err := reader.Read()
+	if err != nil {
+		return nil, err
+	}
+
+	columns := map[string]int{}
+	for i, name := range header {
+		columns[strings.ToLower(strings.TrimSpace(name))] = i
+	}
+
+	if _, ok := columns["stock_no"]; !ok {
+		return nil, ErrImportStockNoColumn
+	}
+
+	column := func(record []string, name string, fallback string) string {
+		i, ok := columns[name]
+		if !ok || i >= len(record) || strings.TrimSpace(record[i]) == "" {
+			return fallback
+		}
+		return strings.TrimSpace(record[i])
+	}
+
+	result := &models.StockImportResult{Created: []string{}, Rejected: []*models.StockImportRejection{}}
+	rows := make([]*importRow, 0)
+	seen := map[string]bool{}
+
+	// Row 1 is the header
+	for line := 2; ; line++ {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		raw := column(record, "stock_no", "")
+		if raw == "" {
+			continue
+		}
+
+		if len(rows) >= MaxImportCount {
+			return nil, ErrInvalidImportCount
+		}
+
+		row := &importRow{
+			row:   line,
+			liner: column(record, "airline_id", airline),
+			port:  column(record, "port_id", port),
+		}
+
+		if row.liner == "" || row.port == "" {
+			result.Rejected = append(result.Rejected, &models.StockImportRejection{Row: line, StockNo: raw, Reason: rejectionMissingAirline})
+			continue
+		}
+
+		row.stockNo, err = ParseAWBNumber(raw)
+		if err != nil {
+			result.Rejected = append(result.Rejected, &models.StockImportRejection{Row: line, StockNo: raw, Reason: err.Error()})
+			continue
+		}
+
+		if seen[row.stockNo] {
+			result.Rejected = append(result.Rejected, &models.StockImportRejection{Row: line, StockNo: row.stockNo, Reason: rejectionDuplicateInFile})
+			continue
+		}
+		seen[row.stockNo] = true
+
+		rows = append(rows, row)
+	}
+
+	return s.create(ctx, rows, result)
+}
+
+// CreateNumbers adds the numbers entered by the user to the available stock of the airline and
+// port. Invalid or duplicate numbers are rejected and the rest are created.
+func (s *NumberService) CreateNumbers(ctx *context.Context, req *models.StockNumbersReq) (*models.StockImportResult, error) {
+	if req.AirLine == "" || req.PortId == "" {
+		return nil, ErrImportAirlinePortRequired
+	}
+
+	if len(req.StockNos) == 0 || len(req.StockNos) > MaxImportCount {
+		return nil, ErrInvalidImportCount
+	}
+
+	result := &models.StockImportResult{Created: []string{}, Rejected: []*models.StockImportRejection{}}
+	rows := make([]*importRow, 0, len(req.StockNos))
+	seen := map[string]bool{}
+
+	for _, raw := range req.StockNos {
+		stockNo, err := ParseAWBNumber(raw)
+		if err != nil {
+			result.Rejected = append(result.Rejected, &models.StockImportRejection{StockNo: raw, Reason: err.Error()})
+			continue
+		}
+
+		if seen[stockNo] {
+			result.Rejected = append(result.Rejected, &models.StockImportRejection{StockNo: stockNo, Reason: rejectionDuplicateInFile})
+			continue
+		}
+		seen[stockNo] = true
+
+		rows = append(rows, &importRow{liner: req.AirLine, port: req.PortId, stockNo: stockNo})
+	}
+
+	return s.create(ctx, rows, result)
+}
+
+// create inserts the rows that are not in stock yet. Imports are serialised so two imports of
+// the same range cannot both pass the duplicate check.
+func (s *NumberService) create(ctx *context.Context, rows []*importRow, result *models.StockImportResult) (*models.StockImportResult, error) {
+	if len(rows) == 0 {
+		return result, nil
+	}
+
+	err := ctx.DB.WithContext(ctx.Request.Context()).Transaction(func(tx *gorm.DB) error {
+		err := tx.Exec("SELECT pg_advisory_xact_lock(hashtext(?))", "air_stocks:import").Error
+		if err != nil {
+			ctx.Log.Error("Failed to acquire stock import lock.", zap.Error(err))
+			return err
+		}
+
+		digits := make([]string, 0, len(rows))
+		for _, row := range rows {
+			digits = append(digits, AWBDigits(row.stockNo))
+		}
+
+		existing, err := s.stockDb.GetExistingNumbersWithTx(ctx, tx, digits)
+		if err != nil {
+			return err
+		}
+
+		inStock := map[string]bool{}
+		for _, no := range existing {
+			inStock[no] = true
+		}
+
+		now := time.Now().UTC()
+		numbers := make([]*models.StockNumber, 0, len(rows))
+		for _, row := range rows {
+			if inStock[AWBDigits(row.stockNo)] {
+				result.Rejected = append(result.Rejected, &models.StockImportRejection{Row: row.row, StockNo: row.stockNo, Reason: rejectionDuplicate})
+				continue
+			}
+
+			numbers = append(numbers, &models.StockNumber{
+				Id:        uuid.New(),
+				Liner:     row.liner,
+				Port:      row.port,
+				RegionId:  ctx.Account.RegionID,
+				Status:    constants.StockStatusAvailable,
+				StockNo:   row.stockNo,
+				CreatedAt: now,
+			})
+			result.Created = append(result.Created, row.stockNo)
+		}
+
+		if len(numbers) == 0 {
+			return nil
+		}
+
+		return s.stockDb.CreateNumbersWithTx(ctx, tx, numbers)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	ctx.Log.Info("stock numbers imported", zap.Int("created", len(result.Created)), zap.Int("rejected", len(result.Rejected)))
+
+	return result, nil
+}
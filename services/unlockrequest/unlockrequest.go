@@ -0,0 +1,542 @@
+package unlockrequest
+
+import (
+	"errors"
+	"time"
+
+	"bitbucket.org/radarventures/forwarder-adapters/utils/context"
+	"bitbucket.org/radarventures/forwarder-shipments/config"
+	"bitbucket.org/radarventures/forwarder-shipments/constants"
+	"bitbucket.org/radarventures/forwarder-shipments/daos/shipment"
+	"bitbucket.org/radarventures/forwarder-shipments/daos/unlockrequest"
+	"bitbucket.org/radarventures/forwarder-shipments/database/models"
+	shipments "bitbucket.org/radarventures/forwarder-shipments/services/shipment"
+	"github.com/google/uuid"
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+)
+
+const (
+	DefaultUnlockDuration = 2 * time.Hour
+	MaxUnlockDuration     = 72 * time.Hour
+)
+
+var (
+	ErrInvalidUnlockScope   = errors.New("unlock scope must be charges, documents or all")
+	ErrUnlockReasonRequired = errors.New("a reason is required to unlock a shipment")
+	ErrInvalidUnlockExpiry  = errors.New("unlock must expire in the future and within 72 hours")
+	ErrShipmentNotLocked    = errors.New("shipment is not locked")
+	ErrUnlockRequestOpen    = errors.New("shipment already has an open unlock request")
+	ErrUnlockRequestClosed  = errors.New("unlock request is no longer pending")
+	ErrNotUnlockApprover    = errors.New("only a finance approver of the region can decide unlock requests")
+	ErrSelfApproval         = errors.New("an unlock request cannot be decided by its requester")
+	ErrNotUnlockRequester   = errors.New("only the requester can cancel an unlock request")
+)
+
+type IUnlockRequestService interface {
+	RequestUnlock(ctx *context.Context, shipmentId uuid.UUID, req *models.UnlockShipmentReq) (*models.ShipmentUnlockRequest, error)
+	Decide(ctx *context.Context, requestId uuid.UUID, req *models.UnlockDecisionReq) (*models.ShipmentUnlockRequest, error)
+	Cancel(ctx *context.Context, requestId uuid.UUID, note string) (*models.ShipmentUnlockRequest, error)
+	RelockExpired(ctx *context.Context, expired *models.ShipmentUnlockRequest) (bool, error)
+	CheckApprover(ctx *context.Context, regionId string) error
+
+	GetRequests(ctx *context.Context, shipmentId uuid.UUID) ([]*models.ShipmentUnlockRequest, error)
+	GetRequest(ctx *context.Context, requestId uuid.UUID) (*models.ShipmentUnlockRequestDetail, error)
+	GetPending(ctx *context.Context, regionId string) ([]*models.ShipmentUnlockRequest, error)
+	GetActiveUnlock(ctx *context.Context, shipmentId uuid.UUID) (*models.ShipmentUnlockRequest, error)
+
+	SaveApprover(ctx *context.Context, regionId string, accountId uuid.UUID) error
+	DeleteApprover(ctx *context.Context, regionId string, accountId uuid.UUID) error
+	GetApprovers(ctx *context.Context, regionId string) ([]*models.ShipmentUnlockApprover, error)
+}
+
+type UnlockRequestService struct {
+	unlockRequestDb unlockrequest.IUnlockRequest
+	shipmentDb      shipment.IShipment
+	shipment        shipments.IShipmentService
+}
+
+func NewUnlockRequestService() IUnlockRequestService {
+	return &UnlockRequestService{
+		unlockRequestDb: unlockrequest.NewUnlockRequest(),
+		shipmentDb:      shipment.NewShipment(),
+		shipment:        shipments.NewShipmentService(),
+	}
+}
+
+// RequestUnlock asks to unlock a locked shipment for the scope until the requested expiry. The
+// shipment stays locked until a finance approver approves the request.
+func (s *UnlockRequestService) RequestUnlock(ctx *context.Context, shipmentId uuid.UUID, req *models.UnlockShipmentReq) (*models.ShipmentUnlockRequest, error) {
+	if !validScope(req.Scope) {
+		return nil, ErrInvalidUnlockScope
+	}
+
+	if req.Reason == "" {
+		return nil, ErrUnlockReasonRequired
+	}
+
+	now := time.Now().UTC()
+	expiresAt := req.ExpiresAt.UTC()
+	if req.ExpiresAt.IsZero() {
+		expiresAt = now.Add(DefaultUnlockDuration)
+	}
+	if !validExpiry(expiresAt, now) {
+		return nil, ErrInvalidUnlockExpiry
+	}
+
+	shipment, err := s.shipmentDb.Get(ctx, shipmentId.String())
+	if err != nil {
+		return nil, err
+	}
+
+	if !shipment.IsShipmentLocked {
+		return nil, ErrShipmentNotLocked
+	}
+
+	request := &models.ShipmentUnlockRequest{
+		Id:          uuid.New(),
+		ShipmentId:  shipment.Id,
+		RegionId:    shipment.RegionId.String(),
+		Scope:       req.Scope,
+		Reason:      req.Reason,
+		Status:      constants.UnlockRequestPending,
+		RequestedBy: ctx.Account.ID,
+		RequestedAt: now,
+		ExpiresAt:   expiresAt,
+	}
+
+	err = ctx.DB.WithContext(ctx.Request.Context()).Transaction(func(tx *gorm.DB) error {
+		_, err := s.unlockRequestDb.GetOpenWithTx(ctx, tx, shipment.Id, now)
+		if err == nil {
+			return ErrUnlockRequestOpen
+		}
+		if !errors.Is(err, gorm.ErrRecordNotFound) {
+			return err
+		}
+
+		err = s.unlockRequestDb.CreateWithTx(ctx, tx, request)
+		if err != nil {
+			return err
+		}
+
+		err = s.unlockRequestDb.CreateEventWithTx(ctx, tx, s.event(ctx, request, constants.UnlockEventRequested, req.Reason))
+		if err != nil {
+			return err
+		}
+
+		return s.recordStillLocked(ctx, tx, request, ctx.Account.ID.String())
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	ctx.Log.Info("shipment unlock requested", zap.Any("shipment_id", shipment.Id), zap.String("scope", request.Scope), zap.Time("expires_at", request.ExpiresAt))
+
+	return request, nil
+}
+
+// Decide approves or rejects a pending request. Approving the whole shipment unlocks it until
+// the request expires. A scoped approval keeps the shipment locked, lockguard lets writes to
+// the areas of the scope through while the request is active. Either way the decision is
+// recorded in shipment_lock and the timeline.
+func (s *UnlockRequestService) Decide(ctx *context.Context, requestId uuid.UUID, req *models.UnlockDecisionReq) (*models.ShipmentUnlockRequest, error) {
+	now := time.Now().UTC()
+
+	var request *models.ShipmentUnlockRequest
+	err := ctx.DB.WithContext(ctx.Request.Context()).Transaction(func(tx *gorm.DB) error {
+		var err error
+		request, err = s.unlockRequestDb.GetWithTx(ctx, tx, requestId)
+		if err != nil {
+			return err
+		}
+
+		if request.Status != constants.UnlockRequestPending || !request.ExpiresAt.After(now) {
+			return ErrUnlockRequestClosed
+		}
+
+		isApprover, err := s.unlockRequestDb.IsApprover(ctx, request.RegionId, ctx.Account.ID)
+		if err != nil {
+			return err
+		}
+		if !isApprover {
+			return ErrNotUnlockApprover
+		}
+
+		if request.RequestedBy == ctx.Account.ID {
+			return ErrSelfApproval
+		}
+
+		decidedBy := ctx.Account.ID
+		fields := map[string]interface{}{
+			"decided_by":    decidedBy,
+			"decided_at":    now,
+			"decision_note": req.Note,
+		}
+
+		if !req.Approve {
+			fields["status"] = constants.UnlockRequestRejected
+			if err := s.update(ctx, tx, request, fields); err != nil {
+				return err
+			}
+
+			err = s.unlockRequestDb.CreateEventWithTx(ctx, tx, s.event(ctx, request, constants.UnlockEventRejected, req.Note))
+			if err != nil {
+				return err
+			}
+
+			return s.recordStillLocked(ctx, tx, request, decidedBy.String())
+		}
+
+		if req.ExpiresAt != nil {
+			if !validExpiry(req.ExpiresAt.UTC(), now) {
+				return ErrInvalidUnlockExpiry
+			}
+			fields["expires_at"] = req.ExpiresAt.UTC()
+		}
+
+		lockId := uuid.New()
+		fields["status"] = constants.UnlockRequestApproved
+		fields["lock_id"] = lockId
+		if err := s.update(ctx, tx, request, fields); err != nil {
+			return err
+		}
+
+		err = s.unlockRequestDb.CreateEventWithTx(ctx, tx, s.event(ctx, request, constants.UnlockEventApproved, req.Note))
+		if err != nil {
+			return err
+		}
+
+		if request.Scope != constants.UnlockScopeAll {
+			return s.recordStillLocked(ctx, tx, request, decidedBy.String())
+		}
+
+		return s.setLocked(ctx, tx, request, false, decidedBy.String(), "", false)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	ctx.Log.Info("shipment unlock request decided", zap.Any("request_id", request.Id), zap.Any("shipment_id", request.ShipmentId), zap.String("status", request.Status))
+
+	return request, nil
+}
+
+// Cancel withdraws a pending request, or ends an approved unlock early by locking the shipment
+// again.
+func (s *UnlockRequestService) Cancel(ctx *context.Context, requestId uuid.UUID, note string) (*models.ShipmentUnlockRequest, error) {
+	now := time.Now().UTC()
+
+	var request *models.ShipmentUnlockRequest
+	err := ctx.DB.WithContext(ctx.Request.Context()).Transaction(func(tx *gorm.DB) error {
+		var err error
+		request, err = s.unlockRequestDb.GetWithTx(ctx, tx, requestId)
+		if err != nil {
+			return err
+		}
+
+		if request.RequestedBy != ctx.Account.ID {
+			return ErrNotUnlockRequester
+		}
+
+		wasPending := request.Status == constants.UnlockRequestPending
+		switch {
+		case wasPending:
+			err = s.update(ctx, tx, request, map[string]interface{}{
+				"status": constants.UnlockRequestCancelled,
+			})
+		case request.Status == constants.UnlockRequestApproved && request.RelockedAt == nil:
+			err = s.relock(ctx, tx, request, constants.UnlockRequestCancelled, ctx.Account.ID.String(), false, now)
+		default:
+			return ErrUnlockRequestClosed
+		}
+		if err != nil {
+			return err
+		}
+
+		err = s.unlockRequestDb.CreateEventWithTx(ctx, tx, s.event(ctx, request, constants.UnlockEventCancelled, note))
+		if err != nil {
+			return err
+		}
+
+		// Cancelling an approved unlock recorded the relock already
+		if !wasPending {
+			return nil
+		}
+
+		return s.recordStillLocked(ctx, tx, request, ctx.Account.ID.String())
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return request, nil
+}
+
+// RelockExpired closes a request found expired by the cronjob, locking its shipment again when
+// it was approved. It reports false when the request was decided, cancelled or already
+// relocked in the meantime.
+func (s *UnlockRequestService) RelockExpired(ctx *context.Context, expired *models.ShipmentUnlockRequest) (bool, error) {
+	now := time.Now().UTC()
+
+	closed := false
+	err := ctx.DB.WithContext(ctx.Request.Context()).Transaction(func(tx *gorm.DB) error {
+		request, err := s.unlockRequestDb.GetWithTx(ctx, tx, expired.Id)
+		if err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				return nil
+			}
+			return err
+		}
+
+		if request.ExpiresAt.After(now) {
+			return nil
+		}
+
+		switch {
+		case request.Status == constants.UnlockRequestPending:
+			err = s.update(ctx, tx, request, map[string]interface{}{
+				"status": constants.UnlockRequestExpired,
+			})
+			if err != nil {
+				return err
+			}
+			closed = true
+			return s.unlockRequestDb.CreateEventWithTx(ctx, tx, s.event(ctx, request, constants.UnlockEventExpired, "expired before it was decided"))
+		case request.Status == constants.UnlockRequestApproved && request.RelockedAt == nil:
+			err = s.relock(ctx, tx, request, constants.UnlockRequestExpired, config.Get().WizBotID, true, now)
+			if err != nil {
+				return err
+			}
+			closed = true
+			return s.unlockRequestDb.CreateEventWithTx(ctx, tx, s.event(ctx, request, constants.UnlockEventRelocked, "unlock expired"))
+		}
+
+		return nil
+	})
+	if err != nil {
+		return false, err
+	}
+
+	return closed, nil
+}
+
+// CheckApprover returns ErrNotUnlockApprover unless the caller is a finance approver of the
+// region. Locking or unlocking a shipment by hand is reserved to them, everyone else goes
+// through an unlock request.
+func (s *UnlockRequestService) CheckApprover(ctx *context.Context, regionId string) error {
+	if ctx.Account == nil {
+		return ErrNotUnlockApprover
+	}
+
+	isApprover, err := s.unlockRequestDb.IsApprover(ctx, regionId, ctx.Account.ID)
+	if err != nil {
+		return err
+	}
+	if !isApprover {
+		return ErrNotUnlockApprover
+	}
+
+	return nil
+}
+
+func (s *UnlockRequestService) GetRequests(ctx *context.Context, shipmentId uuid.UUID) ([]*models.ShipmentUnlockRequest, error) {
+	return s.unlockRequestDb.GetByShipment(ctx, shipmentId)
+}
+
+func (s *UnlockRequestService) GetRequest(ctx *context.Context, requestId uuid.UUID) (*models.ShipmentUnlockRequestDetail, error) {
+	request, err := s.unlockRequestDb.Get(ctx, requestId)
+	if err != nil {
+		return nil, err
+	}
+
+	events, err := s.unlockRequestDb.GetEvents(ctx, requestId)
+	if err != nil {
+		return nil, err
+	}
+
+	return &models.ShipmentUnlockRequestDetail{
+		Request: request,
+		Events:  events,
+	}, nil
+}
+
+func (s *UnlockRequestService) GetPending(ctx *context.Context, regionId string) ([]*models.ShipmentUnlockRequest, error) {
+	return s.unlockRequestDb.GetPending(ctx, regionId)
+}
+
+// GetActiveUnlock returns the approved request that currently unlocks the shipment, wholly or
+// for its scope, or nil when there is none.
+func (s *UnlockRequestService) GetActiveUnlock(ctx *context.Context, shipmentId uuid.UUID) (*models.ShipmentUnlockRequest, error) {
+	request, err := s.unlockRequestDb.GetActive(ctx, shipmentId, time.Now().UTC())
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	return request, nil
+}
+
+func (s *UnlockRequestService) SaveApprover(ctx *context.Context, regionId string, accountId uuid.UUID) error {
+	return s.unlockRequestDb.UpsertApprover(ctx, &models.ShipmentUnlockApprover{
+		RegionId:  regionId,
+		AccountId: accountId,
+		UpdatedBy: ctx.Account.ID,
+		UpdatedAt: time.Now().UTC(),
+	})
+}
+
+func (s *UnlockRequestService) DeleteApprover(ctx *context.Context, regionId string, accountId uuid.UUID) error {
+	return s.unlockRequestDb.DeleteApprover(ctx, regionId, accountId)
+}
+
+func (s *UnlockRequestService) GetApprovers(ctx *context.Context, regionId string) ([]*models.ShipmentUnlockApprover, error) {
+	return s.unlockRequestDb.GetApprovers(ctx, regionId)
+}
+
+// relock locks the shipment of an approved request again and closes the request with status.
+// Only an approval of the whole shipment has an unlock in shipment_lock to close.
+func (s *UnlockRequestService) relock(ctx *context.Context, tx *gorm.DB, request *models.ShipmentUnlockRequest, status string, updatedBy string, isCron bool, now time.Time) error {
+	err := s.update(ctx, tx, request, map[string]interface{}{
+		"status":      status,
+		"relocked_at": now,
+	})
+	if err != nil {
+		return err
+	}
+
+	lockId := ""
+	if request.LockId != nil && request.Scope == constants.UnlockScopeAll {
+		lockId = request.LockId.String()
+	}
+
+	return s.setLocked(ctx, tx, request, true, updatedBy, lockId, isCron)
+}
+
+// setLocked locks or unlocks the shipment of the request and records it in shipment_lock and
+// the timeline, the same way the manual toggle does.
+func (s *UnlockRequestService) setLocked(ctx *context.Context, tx *gorm.DB, request *models.ShipmentUnlockRequest, isLocked bool, updatedBy string, lockId string, isCron bool) error {
+	shipment, err := s.shipmentDb.Get(ctx, request.ShipmentId.String())
+	if err != nil {
+		return err
+	}
+
+	err = s.shipmentDb.UpdateShipmentLockWithTx(ctx, tx, shipment.Id.String(), isLocked)
+	if err != nil {
+		ctx.Log.Error("error while updating shipment lock", zap.Any("shipment_id", shipment.Id), zap.Error(err))
+		return err
+	}
+
+	shipmentLock := &models.ShipmentLock{
+		Id:         uuid.New(),
+		ShipmentId: shipment.Id,
+		UpdatedBy:  updatedBy,
+		IsLocked:   isLocked,
+	}
+	if !isLocked && request.LockId != nil {
+		shipmentLock.Id = *request.LockId
+	}
+
+	err = s.shipment.UpdateShipmentLockStatusTimeline(txContext(ctx, tx), shipmentLock, isLocked, updatedBy, lockId, isCron, shipment.Type, shipment.RegionId.String())
+	if err != nil {
+		ctx.Log.Error("error while persisting audit", zap.Any("shipment_id", shipment.Id), zap.Error(err))
+		return err
+	}
+
+	return nil
+}
+
+// recordStillLocked records a request, scoped approval, rejection or cancellation that leaves
+// the shipment locked in shipment_lock and the timeline, so the lock history shows every step
+// of the request and not only the unlock and relock.
+func (s *UnlockRequestService) recordStillLocked(ctx *context.Context, tx *gorm.DB, request *models.ShipmentUnlockRequest, updatedBy string) error {
+	shipment, err := s.shipmentDb.Get(ctx, request.ShipmentId.String())
+	if err != nil {
+		return err
+	}
+
+	shipmentLock := &models.ShipmentLock{
+		Id:         uuid.New(),
+		ShipmentId: shipment.Id,
+		UpdatedBy:  updatedBy,
+		IsLocked:   true,
+	}
+
+	err = s.shipment.UpdateShipmentLockStatusTimeline(txContext(ctx, tx), shipmentLock, true, updatedBy, "", false, shipment.Type, shipment.RegionId.String())
+	if err != nil {
+		ctx.Log.Error("error while persisting audit", zap.Any("shipment_id", shipment.Id), zap.Any("request_id", request.Id), zap.Error(err))
+		return err
+	}
+
+	return nil
+}
+
+// txContext returns a copy of ctx that writes through tx, for the shipment service that has no
+// WithTx variant of its lock timeline.
+func txContext(ctx *context.Context, tx *gorm.DB) *context.Context {
+	txCtx := *ctx
+	txCtx.DB = tx
+	return &txCtx
+}
+
+// update applies fields to a request that must still be in the status it was read with.
+func (s *UnlockRequestService) update(ctx *context.Context, tx *gorm.DB, request *models.ShipmentUnlockRequest, fields map[string]interface{}) error {
+	updated, err := s.unlockRequestDb.UpdateWithTx(ctx, tx, request.Id, request.Status, fields)
+	if err != nil {
+		return err
+	}
+	if !updated {
+		return ErrUnlockRequestClosed
+	}
+
+	if status, ok := fields["status"].(string); ok {
+		request.Status = status
+	}
+	if expiresAt, ok := fields["expires_at"].(time.Time); ok {
+		request.ExpiresAt = expiresAt
+	}
+	if lockId, ok := fields["lock_id"].(uuid.UUID); ok {
+		request.LockId = &lockId
+	}
+	if decidedBy, ok := fields["decided_by"].(uuid.UUID); ok {
+		request.DecidedBy = &decidedBy
+	}
+	if decidedAt, ok := fields["decided_at"].(time.Time); ok {
+		request.DecidedAt = &decidedAt
+	}
+	if note, ok := fields["decision_note"].(string); ok {
+		request.DecisionNote = note
+	}
+	if relockedAt, ok := fields["relocked_at"].(time.Time); ok {
+		request.RelockedAt = &relockedAt
+	}
+
+	return nil
+}
+
+func (s *UnlockRequestService) event(ctx *context.Context, request *models.ShipmentUnlockRequest, event, note string) *models.ShipmentUnlockRequestEvent {
+	// The relock cronjob runs without an account
+	actorId := config.Get().WizBotID
+	if ctx.Account != nil {
+		actorId = ctx.Account.ID.String()
+	}
+
+	return &models.ShipmentUnlockRequestEvent{
+		Id:         uuid.New(),
+		RequestId:  request.Id,
+		ShipmentId: request.ShipmentId,
+		Event:      event,
+		Note:       note,
+		ActorId:    actorId,
+		CreatedAt:  time.Now().UTC(),
+	}
+}
+
+func validScope(scope string) bool {
+	return scope == constants.UnlockScopeCharges || scope == constants.UnlockScopeDocuments || scope == constants.UnlockScopeAll
+}
+
+func validExpiry(expiresAt, now time.Time) bool {
+	return expiresAt.After(now) && !expiresAt.After(now.Add(MaxUnlockDuration))
+}